@@ -0,0 +1,421 @@
+// Package agent implements a command.Machine that runs commands through
+// a small helper process on the target machine.
+//
+// Some transports are slow or lossy at running commands: a serial
+// console, an ssh connection without multiplexing, a container runtime
+// with a high per-exec cost. [Bootstrap] cross-compiles a helper for
+// the target's OS and architecture, copies it over with the target's
+// filesystem, and starts it once. Every subsequent command, including
+// the commands behind file operations, is multiplexed over that one
+// process's standard input and output using length-prefixed frames.
+//
+//	host := ssh.Machine(sys.Machine(), "ssh", "device.example.com")
+//	m, err := agent.Bootstrap(ctx, host)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	defer command.Shutdown(ctx, m)
+//
+// Building the helper requires a Go toolchain on the local system and
+// lesiw.io/command in the calling module's dependencies.
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"lesiw.io/command"
+	"lesiw.io/command/internal/sh"
+	"lesiw.io/command/sys"
+	"lesiw.io/fs"
+	"lesiw.io/fs/path"
+)
+
+// Package is the import path of the agent's main package.
+const Package = "lesiw.io/command/agent/cmd/cmdagent"
+
+// Machine returns a command.Machine that bootstraps an agent on m when
+// the first command runs, and runs every command through it.
+//
+// If the bootstrap fails, every command fails with its error.
+func Machine(m command.Machine) command.Machine {
+	return &lazyMachine{host: m}
+}
+
+type lazyMachine struct {
+	sync.Mutex
+	host command.Machine
+	am   command.Machine
+	err  error
+}
+
+func (m *lazyMachine) init(ctx context.Context) (command.Machine, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.am != nil || m.err != nil {
+		return m.am, m.err
+	}
+	am, err := Bootstrap(ctx, m.host)
+	if err != nil && ctx.Err() != nil {
+		// The bootstrap was cut short by its caller, so the next
+		// command may try again.
+		return nil, err
+	}
+	m.am, m.err = am, err
+	return am, err
+}
+
+func (m *lazyMachine) Command(
+	ctx context.Context, arg ...string,
+) command.Buffer {
+	am, err := m.init(ctx)
+	if err != nil {
+		return command.Fail(err)
+	}
+	return am.Command(ctx, arg...)
+}
+
+var _ command.ShutdownMachine = (*lazyMachine)(nil)
+
+func (m *lazyMachine) Shutdown(ctx context.Context) error {
+	m.Lock()
+	am := m.am
+	if am == nil && m.err == nil {
+		m.err = errors.New("agent: machine shut down")
+	}
+	m.Unlock()
+
+	if am == nil {
+		return command.Shutdown(ctx, m.host)
+	}
+	return command.Shutdown(ctx, am)
+}
+
+// Bootstrap builds the agent for m's operating system and architecture,
+// copies it to a temporary directory on m, and starts it.
+// The returned Machine runs commands through the agent.
+//
+// Shutting down the returned Machine stops the agent, removes its
+// temporary directory, and shuts down m.
+func Bootstrap(
+	ctx context.Context, m command.Machine,
+) (command.Machine, error) {
+	goos, goarch := command.OS(ctx, m), command.Arch(ctx, m)
+	if goos == "unknown" || goarch == "unknown" {
+		return nil, fmt.Errorf("agent: unsupported platform %s/%s",
+			goos, goarch)
+	}
+	bin, err := build(ctx, goos, goarch)
+	if err != nil {
+		return nil, fmt.Errorf("agent: build: %w", err)
+	}
+
+	fsys := command.FS(m)
+	tmp, err := fs.Temp(ctx, fsys, "cmdagent/")
+	if err != nil {
+		return nil, fmt.Errorf("agent: temp dir: %w", err)
+	}
+	dir := tmp.Path()
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("agent: temp dir: %w", err)
+	}
+	name := path.Join(dir, exeName(goos))
+	err = fs.WriteFile(fs.WithFileMode(ctx, 0755), fsys, name, bin)
+	if err != nil {
+		_ = fs.RemoveAll(ctx, fsys, dir) // Best effort.
+		return nil, fmt.Errorf("agent: push: %w", err)
+	}
+
+	actx := command.WithoutEnv(context.WithoutCancel(ctx))
+	actx = command.WithoutCommandOptions(fs.WithoutWorkDir(actx))
+	actx, cancel := context.WithCancel(actx)
+	buf := m.Command(actx, name)
+	in, ok := buf.(command.WriteBuffer)
+	if !ok {
+		cancel()
+		_ = fs.RemoveAll(ctx, fsys, dir) // Best effort.
+		return nil, errors.New("agent: machine does not accept input")
+	}
+	return &machine{
+		host:   m,
+		conn:   newConn(buf, in),
+		cancel: cancel,
+		dir:    dir,
+		os:     goos,
+		arch:   goarch,
+	}, nil
+}
+
+func exeName(goos string) string {
+	if goos == "windows" {
+		return "cmdagent.exe"
+	}
+	return "cmdagent"
+}
+
+// build cross-compiles the agent on the local system.
+func build(ctx context.Context, goos, goarch string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "cmdagent")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, exeName(goos))
+	// The build runs locally, so the settings of ctx, which are meant
+	// for the target, must not apply to it; only its cancellation does.
+	bctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer context.AfterFunc(ctx, cancel)()
+	ctx = command.WithEnv(bctx, map[string]string{
+		"GOOS":        goos,
+		"GOARCH":      goarch,
+		"CGO_ENABLED": "0",
+	})
+	err = command.Do(ctx, sys.Machine(), "go", "build",
+		"-trimpath", "-ldflags=-s -w", "-o", out, Package,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(out)
+}
+
+type machine struct {
+	host   command.Machine
+	conn   *conn
+	cancel context.CancelFunc
+	dir    string
+	os     string
+	arch   string
+}
+
+func (m *machine) Command(ctx context.Context, arg ...string) command.Buffer {
+	if len(arg) == 0 {
+		return command.Fail(errors.New("no command given"))
+	}
+	return &cmd{
+		conn: m.conn,
+		ctx:  ctx,
+		req: request{
+			Args:  arg,
+			Env:   command.Envs(ctx),
+			Dir:   fs.WorkDir(ctx),
+			Merge: command.MergedStderr(ctx),
+
+			Stdout: command.StdoutFile(ctx),
+			Stderr: command.StderrFile(ctx),
+		},
+	}
+}
+
+func (m *machine) OS(context.Context) string   { return m.os }
+func (m *machine) Arch(context.Context) string { return m.arch }
+
+var _ command.ShutdownMachine = (*machine)(nil)
+
+func (m *machine) Shutdown(ctx context.Context) error {
+	err := m.conn.close()
+	m.cancel()
+	return errors.Join(err,
+		fs.RemoveAll(ctx, command.FS(m.host), m.dir),
+		command.Shutdown(ctx, m.host),
+	)
+}
+
+// conn is the client side of an agent connection.
+type conn struct {
+	in   io.WriteCloser
+	fw   *frameWriter
+	done chan struct{}
+
+	mu      sync.Mutex
+	next    uint32
+	streams map[uint32]*stream
+	err     error // Why the connection ended.
+}
+
+type stream struct {
+	out  *queue
+	log  io.Writer
+	exit chan status
+}
+
+func newConn(out io.Reader, in io.WriteCloser) *conn {
+	c := &conn{
+		in:      in,
+		fw:      &frameWriter{w: in},
+		done:    make(chan struct{}),
+		streams: make(map[uint32]*stream),
+	}
+	go c.readLoop(out)
+	return c
+}
+
+func (c *conn) readLoop(r io.Reader) {
+	defer close(c.done)
+	var err error
+	for {
+		var f frame
+		if f, err = readFrame(r); err != nil {
+			break
+		}
+		c.mu.Lock()
+		st := c.streams[f.id]
+		c.mu.Unlock()
+		if st == nil {
+			continue
+		}
+		switch f.kind {
+		case frameStdout:
+			_, _ = st.out.Write(f.data) // Closed queues drop output.
+		case frameStderr:
+			c.mu.Lock()
+			_, _ = st.log.Write(f.data) // Logs are best effort.
+			c.mu.Unlock()
+		case frameExit:
+			var s status
+			if jerr := json.Unmarshal(f.data, &s); jerr != nil {
+				s = status{Code: -1, Err: "bad exit frame: " + jerr.Error()}
+			}
+			c.mu.Lock()
+			delete(c.streams, f.id)
+			c.mu.Unlock()
+			st.out.closeWithError(nil)
+			st.exit <- s
+		}
+	}
+	if err == io.EOF {
+		err = errors.New("agent: connection closed")
+	} else {
+		err = fmt.Errorf("agent: %w", err)
+	}
+	c.mu.Lock()
+	c.err = err
+	for id, st := range c.streams {
+		delete(c.streams, id)
+		st.out.closeWithError(nil)
+		st.exit <- status{Code: -1, Err: err.Error()}
+	}
+	c.mu.Unlock()
+}
+
+// open registers a new stream and sends its start frame.
+func (c *conn) open(req request, log io.Writer) (uint32, *stream, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	st := &stream{out: newQueue(), log: log, exit: make(chan status, 1)}
+	c.mu.Lock()
+	if c.err != nil {
+		c.mu.Unlock()
+		return 0, nil, c.err
+	}
+	c.next++
+	id := c.next
+	c.streams[id] = st
+	c.mu.Unlock()
+	return id, st, c.fw.write(frameStart, id, data)
+}
+
+func (c *conn) close() error {
+	if err := c.in.Close(); err != nil {
+		return err
+	}
+	<-c.done
+	return nil
+}
+
+type cmd struct {
+	conn *conn
+	ctx  context.Context
+	req  request
+
+	log    io.Writer
+	logbuf bytes.Buffer
+
+	once     sync.Once
+	startErr error
+	id       uint32
+	st       *stream
+	stop     func() bool
+	err      error
+}
+
+func (c *cmd) init() error {
+	c.once.Do(func() {
+		log := c.log
+		if log == nil {
+			log = &c.logbuf
+		}
+		c.id, c.st, c.startErr = c.conn.open(c.req, log)
+		if c.startErr != nil {
+			c.startErr = &command.Error{Err: c.startErr}
+			return
+		}
+		c.stop = context.AfterFunc(c.ctx, func() {
+			_ = c.conn.fw.write(frameKill, c.id, nil) // Best effort.
+		})
+	})
+	return c.startErr
+}
+
+func (c *cmd) Log(w io.Writer) { c.log = w }
+
+func (c *cmd) String() string {
+	return sh.String(c.req.Env, c.req.Args...).String()
+}
+
+func (c *cmd) Read(p []byte) (int, error) {
+	if err := c.init(); err != nil {
+		return 0, err
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.st.out.Read(p)
+	if err != io.EOF {
+		return n, err
+	}
+	s := <-c.st.exit
+	c.stop()
+	c.err = io.EOF
+	if s.Code != 0 || s.Err != "" {
+		e := &command.Error{Code: s.Code}
+		if s.Err != "" {
+			e.Err = errors.New(s.Err)
+		}
+		if c.log == nil {
+			c.conn.mu.Lock()
+			e.Log = bytes.Clone(c.logbuf.Bytes())
+			c.conn.mu.Unlock()
+		}
+		c.err = command.Interrupted(c.ctx, e)
+	}
+	return n, c.err
+}
+
+func (c *cmd) Write(p []byte) (int, error) {
+	if err := c.init(); err != nil {
+		return 0, err
+	}
+	if err := c.conn.fw.write(frameInput, c.id, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *cmd) Close() error {
+	if err := c.init(); err != nil {
+		return err
+	}
+	return c.conn.fw.write(frameClose, c.id, nil)
+}
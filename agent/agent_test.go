@@ -0,0 +1,236 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+	"lesiw.io/command/sys"
+	"lesiw.io/fs"
+)
+
+func TestMain(m *testing.M) {
+	switch os.Getenv("AGENT_TEST_HELPER") {
+	case "cat":
+		_, _ = io.Copy(os.Stdout, os.Stdin)
+		os.Exit(0)
+	case "mixed":
+		fmt.Print("out\n")
+		fmt.Fprint(os.Stderr, "err\n")
+		fmt.Print("out\n")
+		os.Exit(0)
+	case "fail":
+		fmt.Fprint(os.Stderr, "oops\n")
+		os.Exit(3)
+	}
+	os.Exit(m.Run())
+}
+
+// serve returns a Machine connected to an in-process agent.
+func serve(t *testing.T) command.Machine {
+	t.Helper()
+	cr, aw := io.Pipe()
+	ar, cw := io.Pipe()
+	go func() { aw.CloseWithError(Serve(ar, aw)) }()
+	c := newConn(cr, cw)
+	t.Cleanup(func() {
+		if err := c.close(); err != nil {
+			t.Errorf("conn.close() err: %v", err)
+		}
+	})
+	return &machine{conn: c}
+}
+
+// helper returns a context that makes the test binary act as name.
+func helper(ctx context.Context, name string) context.Context {
+	return command.WithEnv(ctx, map[string]string{
+		"AGENT_TEST_HELPER": name,
+	})
+}
+
+func TestAgentStdio(t *testing.T) {
+	m, ctx := serve(t), helper(t.Context(), "cat")
+
+	var out bytes.Buffer
+	_, err := command.Copy(&out,
+		strings.NewReader("hello, agent\n"),
+		command.NewFilter(ctx, m, os.Args[0]),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "hello, agent\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestAgentConcurrentCommands(t *testing.T) {
+	m, ctx := serve(t), helper(t.Context(), "cat")
+
+	a := command.NewFilter(ctx, m, os.Args[0])
+	b := command.NewFilter(ctx, m, os.Args[0])
+	for _, w := range []io.Writer{b, a} {
+		if _, err := io.WriteString(w, "data\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, rw := range []io.ReadWriteCloser{a, b} {
+		if err := rw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		out, err := io.ReadAll(rw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := string(out), "data\n"; got != want {
+			t.Errorf("output = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestAgentCombinedOutput(t *testing.T) {
+	m, ctx := serve(t), helper(t.Context(), "mixed")
+
+	out, err := command.CombinedOutput(ctx, m, os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "out\nerr\nout"; out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
+
+func TestAgentStdoutFile(t *testing.T) {
+	m, ctx := serve(t), helper(t.Context(), "mixed")
+	dir := t.TempDir()
+	ctx = fs.WithWorkDir(ctx, dir)
+	ctx = command.WithStdoutFile(ctx, "out.txt")
+	ctx = command.WithStderrFile(ctx, filepath.Join(dir, "err.txt"))
+
+	out, err := command.Read(ctx, m, os.Args[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "" {
+		t.Errorf("output = %q, want none", out)
+	}
+	for name, want := range map[string]string{
+		"out.txt": "out\nout\n",
+		"err.txt": "err\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestAgentExitCode(t *testing.T) {
+	m, ctx := serve(t), helper(t.Context(), "fail")
+
+	err := command.Do(ctx, m, os.Args[0])
+	var cmdErr *command.Error
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("command.Do err: got %v, want *command.Error", err)
+	}
+	if got, want := cmdErr.Code, 3; got != want {
+		t.Errorf("Code = %d, want %d", got, want)
+	}
+	if got, want := string(cmdErr.Log), "oops\n"; got != want {
+		t.Errorf("Log = %q, want %q", got, want)
+	}
+}
+
+func TestAgentNotFound(t *testing.T) {
+	m := serve(t)
+
+	err := command.Do(t.Context(), m, "command-that-does-not-exist")
+	if !command.NotFound(err) {
+		t.Errorf("command.Do err: got %v, want not found", err)
+	}
+}
+
+func TestAgentConnectionLost(t *testing.T) {
+	cr, aw := io.Pipe()
+	_, cw := io.Pipe()
+	m := &machine{conn: newConn(cr, cw)}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	<-m.conn.done
+
+	if err := command.Do(t.Context(), m, "true"); err == nil {
+		t.Error("command.Do after disconnect: got nil, want error")
+	}
+}
+
+func TestBootstrap(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the agent")
+	}
+	ctx := t.Context()
+	m, err := Bootstrap(ctx, sys.Machine())
+	if err != nil {
+		t.Fatalf("Bootstrap() err: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := command.Shutdown(ctx, m); err != nil {
+			t.Errorf("command.Shutdown() err: %v", err)
+		}
+	})
+
+	out, err := command.Read(helper(ctx, "fail"), m, os.Args[0])
+	if err == nil {
+		t.Fatalf("command.Read() = %q, want error", out)
+	}
+	out, err = command.Read(ctx, m, "go", "env", "GOOS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out, command.OS(ctx, m); got != want {
+		t.Errorf("GOOS = %q, want %q", got, want)
+	}
+}
+
+func TestBuildWithTargetSettings(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds the agent")
+	}
+	// Settings meant for the target must not move the local build.
+	ctx := fs.WithWorkDir(t.Context(), t.TempDir())
+	ctx = command.WithStdoutFile(command.WithMergedStderr(ctx, true), "out")
+
+	bin, err := build(ctx, runtime.GOOS, runtime.GOARCH)
+	if err != nil {
+		t.Fatalf("build() err: %v", err)
+	}
+	if len(bin) == 0 {
+		t.Error("build() = empty binary")
+	}
+}
+
+func TestMachineCanceledBootstrap(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	m := Machine(new(mock.Machine)).(*lazyMachine)
+
+	if err := command.Do(ctx, m, "true"); err == nil {
+		t.Fatal("command.Do with canceled context: got nil, want error")
+	}
+
+	if m.err != nil {
+		t.Errorf("bootstrap error cached: %v", m.err)
+	}
+}
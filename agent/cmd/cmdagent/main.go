@@ -0,0 +1,17 @@
+// Command cmdagent serves the agent protocol on its standard input and
+// output. It is pushed to target machines by lesiw.io/command/agent.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"lesiw.io/command/agent"
+)
+
+func main() {
+	if err := agent.Serve(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,164 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Frames are a one-byte kind, a four-byte stream ID, a four-byte
+// payload length, and the payload. Integers are big-endian.
+const (
+	frameStart  byte = 'S' // Client: start a command. Payload: request.
+	frameInput  byte = 'I' // Client: data for stdin.
+	frameClose  byte = 'C' // Client: close stdin.
+	frameKill   byte = 'K' // Client: kill the command.
+	frameStdout byte = 'O' // Agent: data from stdout.
+	frameStderr byte = 'E' // Agent: data from stderr.
+	frameExit   byte = 'X' // Agent: command finished. Payload: status.
+
+	headerSize   = 9
+	maxFrameSize = 1 << 20
+)
+
+// request is the payload of a start frame.
+type request struct {
+	Args  []string          `json:"args"`
+	Env   map[string]string `json:"env,omitempty"`
+	Dir   string            `json:"dir,omitempty"`
+	Merge bool              `json:"merge,omitempty"` // Stderr to stdout.
+
+	// Stdout and Stderr name files to redirect output to, if set.
+	Stdout string `json:"stdout,omitempty"`
+	Stderr string `json:"stderr,omitempty"`
+}
+
+// status is the payload of an exit frame.
+// Err is set when the command could not start or did not exit normally.
+type status struct {
+	Code int    `json:"code"`
+	Err  string `json:"err,omitempty"`
+}
+
+type frame struct {
+	kind byte
+	id   uint32
+	data []byte
+}
+
+func readFrame(r io.Reader) (f frame, err error) {
+	var hdr [headerSize]byte
+	if _, err = io.ReadFull(r, hdr[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			err = fmt.Errorf("short frame header: %w", err)
+		}
+		return
+	}
+	f.kind = hdr[0]
+	f.id = binary.BigEndian.Uint32(hdr[1:5])
+	size := binary.BigEndian.Uint32(hdr[5:9])
+	if size > maxFrameSize {
+		return f, fmt.Errorf("frame too large: %d bytes", size)
+	}
+	f.data = make([]byte, size)
+	if _, err = io.ReadFull(r, f.data); err != nil {
+		err = fmt.Errorf("short frame: %w", err)
+	}
+	return
+}
+
+// frameWriter writes frames from many goroutines to one stream.
+type frameWriter struct {
+	sync.Mutex
+	w io.Writer
+}
+
+func (fw *frameWriter) write(kind byte, id uint32, data []byte) error {
+	fw.Lock()
+	defer fw.Unlock()
+	for {
+		chunk := data[:min(len(data), maxFrameSize)]
+		var hdr [headerSize]byte
+		hdr[0] = kind
+		binary.BigEndian.PutUint32(hdr[1:5], id)
+		binary.BigEndian.PutUint32(hdr[5:9], uint32(len(chunk)))
+		if _, err := fw.w.Write(append(hdr[:], chunk...)); err != nil {
+			return err
+		}
+		data = data[len(chunk):]
+		if len(data) == 0 {
+			return nil
+		}
+	}
+}
+
+// streamWriter is an io.Writer that writes frames of one kind.
+type streamWriter struct {
+	fw   *frameWriter
+	kind byte
+	id   uint32
+}
+
+func (sw *streamWriter) Write(p []byte) (int, error) {
+	if err := sw.fw.write(sw.kind, sw.id, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// queue is an unbounded in-memory pipe, so that one slow stream never
+// blocks the frames of another.
+type queue struct {
+	mu     sync.Mutex
+	cond   sync.Cond
+	buf    bytes.Buffer
+	closed bool
+	err    error
+}
+
+func newQueue() *queue {
+	q := new(queue)
+	q.cond.L = &q.mu
+	return q
+}
+
+func (q *queue) Write(p []byte) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return 0, io.ErrClosedPipe
+	}
+	q.buf.Write(p)
+	q.cond.Broadcast()
+	return len(p), nil
+}
+
+func (q *queue) Read(p []byte) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for q.buf.Len() == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.buf.Len() > 0 {
+		return q.buf.Read(p)
+	}
+	if q.err != nil {
+		return 0, q.err
+	}
+	return 0, io.EOF
+}
+
+// closeWithError closes the queue. Reads return the remaining data,
+// then err, or io.EOF if err is nil.
+func (q *queue) closeWithError(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed, q.err = true, err
+	q.cond.Broadcast()
+}
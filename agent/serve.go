@@ -0,0 +1,207 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// Serve runs the agent side of the protocol: it starts the commands
+// requested by frames read from r on the local system, and writes
+// their output and exit status as frames to w.
+//
+// Serve returns when r is exhausted, after killing any commands that
+// are still running. The agent binary pushed by [Bootstrap] calls
+// Serve with its standard input and output.
+func Serve(r io.Reader, w io.Writer) error {
+	s := &server{
+		fw:    &frameWriter{w: w},
+		procs: make(map[uint32]*proc),
+	}
+	defer s.killAll()
+	for {
+		f, err := readFrame(r)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := s.handle(f); err != nil {
+			return err
+		}
+	}
+}
+
+type server struct {
+	fw    *frameWriter
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	procs map[uint32]*proc
+}
+
+type proc struct {
+	cmd   *exec.Cmd
+	stdin *queue
+}
+
+func (s *server) handle(f frame) error {
+	switch f.kind {
+	case frameStart:
+		var req request
+		if err := json.Unmarshal(f.data, &req); err != nil {
+			return fmt.Errorf("bad start frame: %w", err)
+		}
+		return s.start(f.id, req)
+	case frameInput:
+		if p := s.proc(f.id); p != nil {
+			_, _ = p.stdin.Write(f.data) // Closed stdin drops input.
+		}
+	case frameClose:
+		if p := s.proc(f.id); p != nil {
+			p.stdin.closeWithError(nil)
+		}
+	case frameKill:
+		if p := s.proc(f.id); p != nil && p.cmd.Process != nil {
+			_ = p.cmd.Process.Kill() // Best effort.
+		}
+	default:
+		return fmt.Errorf("unknown frame kind %q", f.kind)
+	}
+	return nil
+}
+
+func (s *server) proc(id uint32) *proc {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.procs[id]
+}
+
+func (s *server) start(id uint32, req request) error {
+	if len(req.Args) == 0 {
+		return s.exit(id, status{Err: "no command given"})
+	}
+	p := &proc{
+		cmd:   exec.Command(req.Args[0], req.Args[1:]...),
+		stdin: newQueue(),
+	}
+	p.cmd.Dir = req.Dir
+	p.cmd.Env = os.Environ()
+	for k, v := range req.Env {
+		p.cmd.Env = append(p.cmd.Env, k+"="+v)
+	}
+	p.cmd.Stdout = &streamWriter{s.fw, frameStdout, id}
+	p.cmd.Stderr = &streamWriter{s.fw, frameStderr, id}
+	if req.Merge {
+		p.cmd.Stderr = p.cmd.Stdout
+	}
+	files, err := p.redirect(req)
+	// The command has its own copies of the files once started.
+	defer func() {
+		for _, f := range files {
+			_ = f.Close()
+		}
+	}()
+	if err != nil {
+		return s.exit(id, status{Err: err.Error()})
+	}
+	stdin, err := p.cmd.StdinPipe()
+	if err != nil {
+		return s.exit(id, status{Err: err.Error()})
+	}
+	if err := p.cmd.Start(); err != nil {
+		return s.exit(id, status{Err: err.Error()})
+	}
+
+	s.mu.Lock()
+	s.procs[id] = p
+	s.mu.Unlock()
+
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		_, _ = io.Copy(stdin, p.stdin) // The command may exit early.
+		_ = stdin.Close()
+	}()
+	go func() {
+		defer s.wg.Done()
+		st := waitStatus(p.cmd.Wait())
+		p.stdin.closeWithError(nil)
+		s.mu.Lock()
+		delete(s.procs, id)
+		s.mu.Unlock()
+		_ = s.exit(id, st) // Nothing to report to if the client is gone.
+	}()
+	return nil
+}
+
+// redirect sends the output of p to the files req names, and returns the
+// files it opened.
+func (p *proc) redirect(req request) ([]*os.File, error) {
+	var files []*os.File
+	open := func(name string) (*os.File, error) {
+		if !filepath.IsAbs(name) && req.Dir != "" {
+			name = filepath.Join(req.Dir, name)
+		}
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o666)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+		return f, nil
+	}
+	if req.Stdout != "" {
+		f, err := open(req.Stdout)
+		if err != nil {
+			return files, err
+		}
+		p.cmd.Stdout = f
+		if req.Merge {
+			p.cmd.Stderr = f
+		}
+	}
+	if req.Stderr != "" {
+		f, err := open(req.Stderr)
+		if err != nil {
+			return files, err
+		}
+		p.cmd.Stderr = f
+	}
+	return files, nil
+}
+
+func waitStatus(err error) status {
+	if err == nil {
+		return status{}
+	}
+	if ee := new(exec.ExitError); errors.As(err, &ee) {
+		st := status{Code: ee.ExitCode()}
+		if st.Code < 0 {
+			st.Err = err.Error()
+		}
+		return st
+	}
+	return status{Code: -1, Err: err.Error()}
+}
+
+func (s *server) exit(id uint32, st status) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return s.fw.write(frameExit, id, data)
+}
+
+func (s *server) killAll() {
+	s.mu.Lock()
+	for _, p := range s.procs {
+		_ = p.cmd.Process.Kill() // Best effort.
+		p.stdin.closeWithError(nil)
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
@@ -0,0 +1,242 @@
+// Package argv encodes command lines as text, for transcripts, traces,
+// and scripts that must read back exactly the arguments they record.
+//
+//	line := argv.Marshal([]string{"git", "commit", "-m", "fix\nbug"})
+//	// git commit -m $'fix\nbug'
+//	args, err := argv.Unmarshal(line)
+//
+// The encoding is the quoting of the shell, in a canonical form that
+// never spans lines. Arguments of safe characters are left bare, other
+// arguments are single-quoted, and arguments that hold single quotes,
+// control characters, or invalid UTF-8 are quoted as $'...', with
+// backslash escapes. Bash and other shells that support $'...' read the
+// encoding back to the same arguments.
+package argv
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+var unsafe = regexp.MustCompile(`[^\w@%+=:,./-]`)
+
+// Quote returns the encoding of the single argument arg.
+func Quote(arg string) string {
+	switch {
+	case arg == "":
+		return "''"
+	case !unsafe.MatchString(arg):
+		return arg
+	case !needsEscape(arg):
+		return "'" + arg + "'"
+	}
+	var b strings.Builder
+	b.WriteString("$'")
+	for i := 0; i < len(arg); {
+		r, size := utf8.DecodeRuneInString(arg[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			_, _ = fmt.Fprintf(&b, `\x%02x`, arg[i])
+		case r == '\\' || r == '\'':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r < 0x20 || r == 0x7f:
+			_, _ = fmt.Fprintf(&b, `\x%02x`, r)
+		default:
+			b.WriteString(arg[i : i+size])
+		}
+		i += size
+	}
+	b.WriteByte('\'')
+	return b.String()
+}
+
+func needsEscape(s string) bool {
+	if !utf8.ValidString(s) {
+		return true
+	}
+	for i := range len(s) {
+		if c := s[i]; c == '\'' || c < 0x20 || c == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// Marshal returns the encoding of args, each quoted as by [Quote] and
+// separated by spaces.
+func Marshal(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = Quote(arg)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Unmarshal decodes the arguments in s, which may be written by hand: in
+// addition to the forms [Marshal] writes, it reads double quotes and
+// backslash escapes outside quotes as the shell does. It does not expand
+// variables or other substitutions.
+func Unmarshal(s string) ([]string, error) {
+	var (
+		args  []string
+		cur   strings.Builder
+		inArg bool
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case ' ', '\t', '\n', '\r':
+			if inArg {
+				args, inArg = append(args, cur.String()), false
+				cur.Reset()
+			}
+			continue
+		case '\\':
+			if i++; i >= len(s) {
+				return nil, fmt.Errorf("trailing backslash: %s", s)
+			}
+			cur.WriteByte(s[i])
+		case '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote: %s", s)
+			}
+			cur.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+		case '"':
+			n, err := unquoteDouble(&cur, s[i+1:])
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", err, s)
+			}
+			i += n + 1
+		case '$':
+			if i+1 < len(s) && s[i+1] == '\'' {
+				n, err := unquoteANSI(&cur, s[i+2:])
+				if err != nil {
+					return nil, fmt.Errorf("%w: %s", err, s)
+				}
+				i += n + 2
+				break
+			}
+			cur.WriteByte(c)
+		default:
+			cur.WriteByte(c)
+		}
+		inArg = true
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+var errUnterminated = errors.New("unterminated quote")
+
+// unquoteDouble writes the text of the double-quoted string that s
+// begins, after its opening quote, to b and returns the length of its
+// rest, through the closing quote.
+func unquoteDouble(b *strings.Builder, s string) (int, error) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return i, nil
+		case '\\':
+			if i+1 < len(s) && strings.IndexByte("$`\"\\\n", s[i+1]) >= 0 {
+				i++
+				if s[i] != '\n' { // A continued line.
+					b.WriteByte(s[i])
+				}
+				continue
+			}
+			b.WriteByte(c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return 0, errUnterminated
+}
+
+// unquoteANSI writes the text of the $'...' string that s begins, after
+// its opening quote, to b and returns the length of its rest, through
+// the closing quote.
+func unquoteANSI(b *strings.Builder, s string) (int, error) {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '\'' {
+			return i, nil
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		if i++; i >= len(s) {
+			break
+		}
+		switch c := s[i]; c {
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'e', 'E':
+			b.WriteByte(0x1b)
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case '\\', '\'', '"', '?':
+			b.WriteByte(c)
+		case 'x':
+			v, n := digits(s[i+1:], 16, 2)
+			if n == 0 {
+				b.WriteString(`\x`)
+				continue
+			}
+			b.WriteByte(byte(v))
+			i += n
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			v, n := digits(s[i:], 8, 3)
+			b.WriteByte(byte(v))
+			i += n - 1
+		default:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		}
+	}
+	return 0, errUnterminated
+}
+
+// digits parses up to limit digits in base at the start of s, returning
+// their value and how many there were.
+func digits(s string, base, limit int) (v, n int) {
+	for n < limit && n < len(s) {
+		d := strings.IndexByte("0123456789abcdef", lower(s[n]))
+		if d < 0 || d >= base {
+			break
+		}
+		v = v*base + d
+		n++
+	}
+	return v, n
+}
+
+func lower(c byte) byte {
+	if 'A' <= c && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
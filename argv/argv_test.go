@@ -0,0 +1,101 @@
+package argv_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"lesiw.io/command/argv"
+)
+
+func TestMarshal(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{nil, ""},
+		{[]string{"go", "build", "./..."}, "go build ./..."},
+		{[]string{""}, "''"},
+		{[]string{"a b", "$HOME", "#x"}, "'a b' '$HOME' '#x'"},
+		{[]string{"it's"}, `$'it\'s'`},
+		{[]string{"a\nb\tc"}, `$'a\nb\tc'`},
+		{[]string{`back\slash`}, `'back\slash'`},
+		{[]string{`it's\`}, `$'it\'s\\'`},
+		{[]string{"\x00\x1b\x7f"}, `$'\x00\x1b\x7f'`},
+		{[]string{"\xff", "héllo wörld"}, `$'\xff' 'héllo wörld'`},
+	}
+	for _, tt := range tests {
+		if got := argv.Marshal(tt.args); got != tt.want {
+			t.Errorf("argv.Marshal(%q) = %s, want %s", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestUnmarshal(t *testing.T) {
+	tests := []struct {
+		s    string
+		want []string
+	}{
+		{"", nil},
+		{"  go  build\t./... ", []string{"go", "build", "./..."}},
+		{`'it'\''s'`, []string{"it's"}},
+		{`"a \"b\" \$c \d"`, []string{`a "b" $c \d`}},
+		{`a\ b`, []string{"a b"}},
+		{`$'\e[0m\101\x41\u'`, []string{"\x1b[0mAA\\u"}},
+		{`$HOME x$'y'`, []string{"$HOME", "xy"}},
+		{`''`, []string{""}},
+	}
+	for _, tt := range tests {
+		got, err := argv.Unmarshal(tt.s)
+		if err != nil {
+			t.Errorf("argv.Unmarshal(%s) error: %v", tt.s, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("argv.Unmarshal(%s) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestUnmarshalError(t *testing.T) {
+	for _, s := range []string{`'a`, `"a`, `$'a`, `a\`, `$'a\'`} {
+		if _, err := argv.Unmarshal(s); err == nil {
+			t.Errorf("argv.Unmarshal(%s) error: nil, want error", s)
+		}
+	}
+}
+
+func FuzzMarshal(f *testing.F) {
+	f.Add("go\x00build\x00./...")
+	f.Add("it's\x00a\nb\x00")
+	f.Add("\xff\x1b'\\\"$")
+	f.Fuzz(func(t *testing.T, s string) {
+		args := strings.Split(s, "\x00")
+		line := argv.Marshal(args)
+		if strings.ContainsAny(line, "\n\r") {
+			t.Errorf("argv.Marshal(%q) = %q spans lines", args, line)
+		}
+		got, err := argv.Unmarshal(line)
+		if err != nil {
+			t.Fatalf("argv.Unmarshal(%q) error: %v", line, err)
+		}
+		if !slices.Equal(got, args) {
+			t.Errorf("argv.Unmarshal(argv.Marshal(%q)) = %q", args, got)
+		}
+	})
+}
+
+func FuzzUnmarshal(f *testing.F) {
+	f.Add(`a 'b c' "d\"e" $'f\n\x41'`)
+	f.Add(`\`)
+	f.Fuzz(func(t *testing.T, s string) {
+		args, err := argv.Unmarshal(s)
+		if err != nil {
+			return
+		}
+		got, err := argv.Unmarshal(argv.Marshal(args))
+		if err != nil || !slices.Equal(got, args) {
+			t.Errorf("round trip of %q = %q, %v", args, got, err)
+		}
+	})
+}
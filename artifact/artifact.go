@@ -0,0 +1,248 @@
+// Package artifact collects files that commands produced on a
+// command.Machine, such as logs, coverage profiles, and binaries, into a
+// local directory.
+//
+//	ctx = fs.WithWorkDir(ctx, "/src/app")
+//	files, err := artifact.Collect(ctx, m, "out",
+//	    "**/*.log", "cover.out", "bin/*")
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	fmt.Println("collected", len(files), "files")
+//
+// Files are found with find and streamed with tar, so collecting works
+// with any machine that has both, such as a container or a remote host,
+// without copying files one at a time.
+package artifact
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"lesiw.io/command"
+)
+
+// ManifestName is the name of the manifest that Collect writes in the
+// destination directory.
+const ManifestName = "manifest.json"
+
+// A File is a collected file, as listed in the manifest.
+type File struct {
+	Path   string // Slash-separated, relative to the directory collected.
+	Size   int64
+	SHA256 string
+	Mode   os.FileMode
+}
+
+// Collect copies the regular files on m that match any of globs into the
+// local directory dst, as [Fetch] does, and writes a manifest of them to
+// [ManifestName] in dst. It returns the files in the manifest.
+func Collect(
+	ctx context.Context, m command.Machine, dst string, globs ...string,
+) ([]File, error) {
+	files, err := Fetch(ctx, m, dst, globs...)
+	if err != nil {
+		return nil, err
+	}
+	if files == nil {
+		files = []File{} // An empty manifest, rather than null.
+	}
+	manifest, err := json.MarshalIndent(files, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	manifest = append(manifest, '\n')
+	err = os.WriteFile(filepath.Join(dst, ManifestName), manifest, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// Fetch copies the regular files on m that match any of globs into the
+// local directory dst, creating it if needed, and returns them sorted by
+// path. Files already in dst are overwritten.
+//
+// Globs are matched against slash-separated paths relative to the
+// working directory of ctx, with the syntax of path.Match, and "**"
+// matches any number of directories. A glob with no matches is not an
+// error.
+func Fetch(
+	ctx context.Context, m command.Machine, dst string, globs ...string,
+) ([]File, error) {
+	for _, g := range globs {
+		if _, err := path.Match(g, ""); err != nil {
+			return nil, fmt.Errorf("bad glob %q: %w", g, err)
+		}
+	}
+	ctx = command.WithoutCommandOptions(ctx)
+	names, err := find(ctx, m, globs)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	files, err := fetch(ctx, m, dst, names)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(files, func(a, b File) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return files, nil
+}
+
+// find returns the names of the files on m that match globs.
+func find(
+	ctx context.Context, m command.Machine, globs []string,
+) ([]string, error) {
+	out, err := command.Read(ctx, m, "find", ".", "-type", "f")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list files: %w", err)
+	}
+	var names []string
+	for line := range strings.Lines(out) {
+		name := strings.TrimPrefix(strings.TrimRight(line, "\r\n"), "./")
+		if name == "" {
+			continue
+		}
+		if slices.ContainsFunc(globs, func(g string) bool {
+			return match(g, name)
+		}) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// match reports whether name matches pattern, where "**" matches any
+// number of path elements.
+func match(pattern, name string) bool {
+	return matchElems(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchElems(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := range len(name) + 1 {
+				if matchElems(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// fetch streams the named files from m with tar and unpacks them in dst.
+func fetch(
+	ctx context.Context, m command.Machine, dst string, names []string,
+) ([]File, error) {
+	var list bytes.Buffer
+	for _, name := range names {
+		list.WriteString(name + "\n")
+	}
+	pr, pw := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := command.Run(ctx, m, command.Spec{
+			Args:   []string{"tar", "-cf", "-", "-T", "-"},
+			Stdin:  &list,
+			Stdout: pw,
+		})
+		pw.CloseWithError(err)
+		done <- err
+	}()
+	files, err := untar(pr, dst)
+	if err == nil {
+		// Archives may be padded past their end. Errors reading the
+		// padding are those of tar, reported below.
+		_, _ = io.Copy(io.Discard, pr)
+	} else {
+		// If unpacking failed, tar must not block writing the rest.
+		_ = pr.CloseWithError(errors.New("artifact: collection stopped"))
+	}
+	rerr := <-done
+	if err != nil {
+		return nil, err
+	}
+	if rerr != nil {
+		return nil, fmt.Errorf("failed to archive files: %w", rerr)
+	}
+	return files, nil
+}
+
+func untar(r io.Reader, dst string) ([]File, error) {
+	var files []File
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("bad archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name := strings.TrimPrefix(path.Clean(hdr.Name), "./")
+		local, err := filepath.Localize(name)
+		if err != nil || !filepath.IsLocal(local) {
+			return nil, fmt.Errorf("bad path in archive: %q", hdr.Name)
+		}
+		mode := hdr.FileInfo().Mode()
+		f, err := extract(tr, filepath.Join(dst, local), mode)
+		if err != nil {
+			return nil, err
+		}
+		f.Path = name
+		files = append(files, f)
+	}
+}
+
+func extract(r io.Reader, name string, mode os.FileMode) (File, error) {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return File{}, err
+	}
+	w, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return File{}, err
+	}
+	h := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, h), r)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return File{}, fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return File{
+		Size:   n,
+		SHA256: hex.EncodeToString(h.Sum(nil)),
+		Mode:   mode.Perm(),
+	}, nil
+}
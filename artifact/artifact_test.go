@@ -0,0 +1,84 @@
+package artifact_test
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lesiw.io/command/artifact"
+	"lesiw.io/command/sys"
+	"lesiw.io/fs"
+)
+
+func TestCollect(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test needs find and tar")
+	}
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not found")
+	}
+	src, dst := t.TempDir(), t.TempDir()
+	for name, data := range map[string]string{
+		"build.log":       "ok\n",
+		"sub/deep/a.log":  "deeper\n",
+		"cover.out":       "mode: set\n",
+		"main.go":         "package main\n",
+		"sub/deep/b.txt":  "skipped\n",
+		"bin/app":         "binary",
+		"bin/nested/skip": "not matched by bin/*",
+	} {
+		p := filepath.Join(src, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := fs.WithWorkDir(t.Context(), src)
+
+	files, err := artifact.Collect(ctx, sys.Machine(), dst,
+		"**/*.log", "cover.out", "bin/*")
+	if err != nil {
+		t.Fatalf("artifact.Collect error: %v", err)
+	}
+
+	var got []string
+	for _, f := range files {
+		got = append(got, f.Path)
+	}
+	want := []string{"bin/app", "build.log", "cover.out", "sub/deep/a.log"}
+	if !cmp.Equal(want, got) {
+		t.Errorf("collected (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	data, err := os.ReadFile(filepath.Join(dst, "sub", "deep", "a.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "deeper\n" {
+		t.Errorf("sub/deep/a.log = %q, want %q", data, "deeper\n")
+	}
+	manifest, err := os.ReadFile(filepath.Join(dst, artifact.ManifestName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var listed []artifact.File
+	if err := json.Unmarshal(manifest, &listed); err != nil {
+		t.Fatalf("bad manifest: %v", err)
+	}
+	if !cmp.Equal(files, listed) {
+		t.Errorf("manifest (-want +got):\n%s", cmp.Diff(files, listed))
+	}
+}
+
+func TestCollectBadGlob(t *testing.T) {
+	_, err := artifact.Collect(t.Context(), sys.Machine(), t.TempDir(), "[")
+	if err == nil {
+		t.Error("artifact.Collect: got nil error, want bad glob")
+	}
+}
@@ -0,0 +1,233 @@
+// Package benchcmd measures the cost of running commands on
+// command.Machines, so that backends can be compared with data.
+//
+// A backend that starts a process per command, such as ssh or a
+// container CLI, pays for it on every call, while one that holds a
+// session or talks to an API pays once. [Run] measures both the
+// per-command latency and the throughput of streaming input and output
+// through each machine:
+//
+//	results, err := benchcmd.Run(ctx, map[string]command.Machine{
+//	    "sys": sys.Machine(),
+//	    "ssh": ssh.Machine(sys.Machine(), "ssh", "build01"),
+//	}, benchcmd.Options{})
+//	if err != nil {
+//	    return err
+//	}
+//	_ = benchcmd.WriteTable(os.Stdout, results)
+package benchcmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"lesiw.io/command"
+)
+
+// DefaultSizes are the payload sizes streamed by default.
+var DefaultSizes = []int{1 << 10, 1 << 20, 16 << 20}
+
+// Options configures Run.
+type Options struct {
+	// N is the number of trivial commands to run. The default is 100.
+	N int
+
+	// Sizes are the sizes of payloads, in bytes, to stream through each
+	// machine. The default is DefaultSizes.
+	Sizes []int
+
+	// Noop is a command that does nothing. The default is true, or
+	// cmd /c rem on Windows.
+	Noop []string
+
+	// Echo is a command that copies its input to its output. The default
+	// is cat. If the machine has no such command, set Sizes to an
+	// empty, non-nil slice to skip streaming.
+	Echo []string
+}
+
+// A Result is the measurements of one machine.
+type Result struct {
+	Machine  string
+	Commands int           // Number of trivial commands run.
+	Total    time.Duration // Time to run them, one after another.
+	Payloads []Payload
+}
+
+// PerCommand returns the mean time taken by one trivial command.
+func (r Result) PerCommand() time.Duration {
+	if r.Commands == 0 {
+		return 0
+	}
+	return r.Total / time.Duration(r.Commands)
+}
+
+// A Payload is the measurement of streaming one payload through a
+// machine and back.
+type Payload struct {
+	Size    int
+	Elapsed time.Duration
+}
+
+// BytesPerSecond returns the throughput of the payload.
+func (p Payload) BytesPerSecond() float64 {
+	if p.Elapsed <= 0 {
+		return 0
+	}
+	return float64(p.Size) / p.Elapsed.Seconds()
+}
+
+// Run measures each of ms in turn, in order of name, so that they do not
+// compete for resources. It stops at the first failure.
+func Run(
+	ctx context.Context, ms map[string]command.Machine, opts Options,
+) ([]Result, error) {
+	if opts.N <= 0 {
+		opts.N = 100
+	}
+	if opts.Sizes == nil {
+		opts.Sizes = DefaultSizes
+	}
+	if opts.Echo == nil {
+		opts.Echo = []string{"cat"}
+	}
+	var results []Result
+	for _, name := range slices.Sorted(maps.Keys(ms)) {
+		res, err := run(ctx, ms[name], opts)
+		if err != nil {
+			return results, fmt.Errorf("%s: %w", name, err)
+		}
+		res.Machine = name
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func run(
+	ctx context.Context, m command.Machine, opts Options,
+) (res Result, err error) {
+	noop := opts.Noop
+	if noop == nil {
+		noop = []string{"true"}
+		if command.OS(ctx, m) == "windows" {
+			noop = []string{"cmd", "/c", "rem"}
+		}
+	}
+	// The first command may set up a connection or detect the machine,
+	// which is not the cost of a command.
+	if err := command.Do(ctx, m, noop...); err != nil {
+		return res, err
+	}
+	start := time.Now()
+	for range opts.N {
+		if err := command.Do(ctx, m, noop...); err != nil {
+			return res, err
+		}
+	}
+	res.Commands, res.Total = opts.N, time.Since(start)
+
+	for _, size := range opts.Sizes {
+		if size <= 0 {
+			continue
+		}
+		elapsed, err := stream(ctx, m, opts.Echo, size)
+		if err != nil {
+			return res, err
+		}
+		res.Payloads = append(res.Payloads, Payload{size, elapsed})
+	}
+	return res, nil
+}
+
+func stream(
+	ctx context.Context, m command.Machine, echo []string, size int,
+) (time.Duration, error) {
+	var out counter
+	start := time.Now()
+	err := command.Run(ctx, m, command.Spec{
+		Args:   echo,
+		Stdin:  io.LimitReader(zeros{}, int64(size)),
+		Stdout: &out,
+	})
+	elapsed := time.Since(start)
+	if err != nil {
+		return 0, err
+	}
+	if int(out) != size {
+		return 0, fmt.Errorf("%d byte payload: got %d bytes back",
+			size, out)
+	}
+	return elapsed, nil
+}
+
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+type counter int64
+
+func (c *counter) Write(p []byte) (int, error) {
+	*c += counter(len(p))
+	return len(p), nil
+}
+
+// WriteTable writes results to w as a table, with a column for the
+// latency of trivial commands and one for the throughput of each
+// payload size.
+func WriteTable(w io.Writer, results []Result) error {
+	var sizes []int
+	for _, r := range results {
+		for _, p := range r.Payloads {
+			if !slices.Contains(sizes, p.Size) {
+				sizes = append(sizes, p.Size)
+			}
+		}
+	}
+	slices.Sort(sizes)
+
+	var head bytes.Buffer
+	head.WriteString("MACHINE\tCOMMANDS\tPER COMMAND")
+	for _, size := range sizes {
+		_, _ = fmt.Fprintf(&head, "\t%s", bytesize(size))
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, head.String())
+	for _, r := range results {
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%s", r.Machine, r.Commands,
+			r.PerCommand().Round(time.Microsecond))
+		for _, size := range sizes {
+			i := slices.IndexFunc(r.Payloads, func(p Payload) bool {
+				return p.Size == size
+			})
+			if i < 0 {
+				_, _ = fmt.Fprint(tw, "\t-")
+				continue
+			}
+			_, _ = fmt.Fprintf(tw, "\t%s/s",
+				bytesize(int(r.Payloads[i].BytesPerSecond())))
+		}
+		_, _ = fmt.Fprintln(tw)
+	}
+	return tw.Flush()
+}
+
+func bytesize(n int) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1fGiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1fMiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1fKiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%dB", n)
+}
@@ -0,0 +1,64 @@
+package benchcmd_test
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"lesiw.io/command"
+	"lesiw.io/command/benchcmd"
+	"lesiw.io/command/sys"
+)
+
+func TestRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires cat")
+	}
+	ms := map[string]command.Machine{"sys": sys.Machine()}
+
+	results, err := benchcmd.Run(t.Context(), ms, benchcmd.Options{
+		N:     3,
+		Sizes: []int{1 << 10, 1 << 16},
+	})
+	if err != nil {
+		t.Fatalf("benchcmd.Run error: %v", err)
+	}
+
+	if len(results) != 1 {
+		t.Fatalf("got %d results, want 1", len(results))
+	}
+	r := results[0]
+	if r.Machine != "sys" || r.Commands != 3 || r.PerCommand() <= 0 {
+		t.Errorf("result = %+v, want 3 timed commands on sys", r)
+	}
+	if len(r.Payloads) != 2 {
+		t.Errorf("got %d payloads, want 2", len(r.Payloads))
+	}
+}
+
+func TestWriteTable(t *testing.T) {
+	results := []benchcmd.Result{{
+		Machine:  "local",
+		Commands: 10,
+		Total:    20 * time.Millisecond,
+		Payloads: []benchcmd.Payload{{Size: 1 << 20, Elapsed: time.Second}},
+	}, {
+		Machine:  "remote",
+		Commands: 10,
+		Total:    time.Second,
+	}}
+	var b strings.Builder
+
+	if err := benchcmd.WriteTable(&b, results); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `MACHINE  COMMANDS  PER COMMAND  1.0MiB
+local    10        2ms          1.0MiB/s
+remote   10        100ms        -
+`
+	if got := b.String(); got != want {
+		t.Errorf("table:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,103 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+)
+
+// errBridgeStopped closes the pipe of a Bridge when one of its sides
+// stops, so that the other does not mistake a failure for the end of its
+// input or block writing output that nothing will read.
+var errBridgeStopped = errors.New("other side of bridge stopped")
+
+// A BridgeError reports which side of a [Bridge] failed. A side that
+// was stopped because the other failed does not count as failing.
+type BridgeError struct {
+	Src error // The error of the source command, if it failed.
+	Dst error // The error of the destination command, if it failed.
+}
+
+func (e *BridgeError) Error() string {
+	var parts []string
+	if e.Src != nil {
+		parts = append(parts, "bridge source: "+e.Src.Error())
+	}
+	if e.Dst != nil {
+		parts = append(parts, "bridge destination: "+e.Dst.Error())
+	}
+	return strings.Join(parts, "\n")
+}
+
+func (e *BridgeError) Unwrap() []error {
+	var errs []error
+	for _, err := range []error{e.Src, e.Dst} {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+// Bridge runs srcArgs on src and dstArgs on dst, streaming the output of
+// the first into the input of the second, as a shell pipeline would if
+// both commands ran on one machine. The output of dst is discarded.
+//
+//	err := command.Bridge(ctx,
+//	    prod, []string{"pg_dump", "app"},
+//	    db, []string{"psql", "app"},
+//	)
+//
+// If either command fails, the other is stopped: dst does not see the
+// partial output of a failed src as the end of its input. The returned
+// error is a [*BridgeError] that attributes the failure to its side. If
+// dst completes before reading all of src's output, src is stopped
+// without error, as with a broken pipe.
+func Bridge(
+	ctx context.Context,
+	src Machine, srcArgs []string,
+	dst Machine, dstArgs []string,
+) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	pr, pw := io.Pipe()
+	srcDone := make(chan error, 1)
+	go func() {
+		err := Run(ctx, src, Spec{Args: srcArgs, Stdout: pw})
+		if err != nil {
+			pw.CloseWithError(errBridgeStopped)
+			cancel(errBridgeStopped)
+		} else {
+			_ = pw.Close()
+		}
+		srcDone <- err
+	}()
+	dstErr := Run(ctx, dst, Spec{Args: dstArgs, Stdin: pr})
+	pr.CloseWithError(errBridgeStopped)
+	if e := new(Error); dstErr != nil && !errors.As(dstErr, &e) {
+		// Run reports a failure to write input only if the command
+		// itself succeeded, having exited without reading all of it.
+		dstErr = nil
+	}
+	if dstErr != nil {
+		cancel(errBridgeStopped)
+	}
+	srcErr := <-srcDone
+
+	stopped := func(err error) bool {
+		return errors.Is(err, errBridgeStopped) ||
+			errors.Is(err, ErrCanceled) &&
+				context.Cause(ctx) == errBridgeStopped
+	}
+	if stopped(srcErr) {
+		srcErr = nil
+	}
+	if stopped(dstErr) && srcErr != nil {
+		dstErr = nil
+	}
+	if srcErr == nil && dstErr == nil {
+		return nil
+	}
+	return &BridgeError{Src: srcErr, Dst: dstErr}
+}
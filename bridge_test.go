@@ -0,0 +1,94 @@
+package command_test
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/sys"
+)
+
+func TestBridge(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("requires sh")
+	}
+	out := filepath.Join(t.TempDir(), "out")
+
+	err := command.Bridge(t.Context(),
+		sys.Machine(), []string{"sh", "-c", `printf 'a\nb\n'`},
+		sys.Machine(), []string{"sh", "-c", `tr a-z A-Z > "$1"`, "sh", out},
+	)
+	if err != nil {
+		t.Fatalf("command.Bridge error: %v", err)
+	}
+
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "A\nB\n"; string(got) != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestBridgeSourceFails(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("requires sh")
+	}
+	out := filepath.Join(t.TempDir(), "out")
+
+	err := command.Bridge(t.Context(),
+		sys.Machine(), []string{"sh", "-c", "echo partial; exit 3"},
+		sys.Machine(), []string{"sh", "-c", `cat > "$1"`, "sh", out},
+	)
+
+	var berr *command.BridgeError
+	if !errors.As(err, &berr) {
+		t.Fatalf("command.Bridge error: got %v, want BridgeError", err)
+	}
+	if e := new(command.Error); !errors.As(berr.Src, &e) || e.Code != 3 {
+		t.Errorf("Src = %v, want exit status 3", berr.Src)
+	}
+	if berr.Dst != nil {
+		t.Errorf("Dst = %v, want nil", berr.Dst)
+	}
+}
+
+func TestBridgeDestinationFails(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("requires sh")
+	}
+
+	err := command.Bridge(t.Context(),
+		sys.Machine(), []string{"sh", "-c", "exec yes"},
+		sys.Machine(), []string{"sh", "-c", "read line; exit 4"},
+	)
+
+	var berr *command.BridgeError
+	if !errors.As(err, &berr) {
+		t.Fatalf("command.Bridge error: got %v, want BridgeError", err)
+	}
+	if berr.Src != nil {
+		t.Errorf("Src = %v, want nil", berr.Src)
+	}
+	if e := new(command.Error); !errors.As(berr.Dst, &e) || e.Code != 4 {
+		t.Errorf("Dst = %v, want exit status 4", berr.Dst)
+	}
+}
+
+func TestBridgeDestinationStopsEarly(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("requires sh")
+	}
+
+	err := command.Bridge(t.Context(),
+		sys.Machine(), []string{"sh", "-c", "exec yes"},
+		sys.Machine(), []string{"sh", "-c", "read line"},
+	)
+	if err != nil {
+		t.Errorf("command.Bridge error: %v", err)
+	}
+}
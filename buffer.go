@@ -3,6 +3,7 @@ package command
 import (
 	"errors"
 	"io"
+	"time"
 )
 
 // ErrClosed is returned when attempting to read from or write to a closed
@@ -19,6 +20,8 @@ var ErrReadOnly = errors.New("command: write to read-only buffer")
 // Buffers may implement additional interfaces for extended capabilities:
 //   - [AttachBuffer] - connect to controlling terminal
 //   - [LogBuffer] - capture diagnostic output
+//   - [ResultBuffer] - report how the command finished
+//   - [SpliceBuffer] - connect input and output without copying
 //   - [WriteBuffer] - provide input to the command
 type Buffer interface {
 	// Read reads output from the command.
@@ -82,6 +85,53 @@ type LogBuffer interface {
 	Log(io.Writer)
 }
 
+// ResultBuffer is an optional interface for buffers that report how
+// their command finished, for callers that stream output and so never see
+// the [Error] that Do and Read would return.
+type ResultBuffer interface {
+	Buffer
+
+	// Result returns the result of the command, and reports whether the
+	// command has finished. It is available once Read has returned an
+	// error, such as io.EOF.
+	Result() (Result, bool)
+}
+
+// Result describes a finished command.
+type Result struct {
+	Code     int       // The exit code, or -1 if it was killed by a signal.
+	Started  time.Time // When the command started.
+	Finished time.Time // When the command exited.
+}
+
+// Duration returns how long the command ran.
+func (r Result) Duration() time.Duration {
+	return r.Finished.Sub(r.Started)
+}
+
+// SpliceBuffer is an optional interface for buffers whose input and
+// output can be connected directly to files, so that data does not pass
+// through this process. On Linux, [Copy] splices adjacent stages of a
+// pipeline when it can, handing each the end of an operating system pipe.
+//
+// Both methods are called before the command starts. A buffer whose
+// input was spliced must not start when closed; a buffer whose output
+// was spliced must still be read to observe its completion, and its
+// Read returns 0 bytes and EOF once it is done.
+type SpliceBuffer interface {
+	Buffer
+
+	// SpliceFrom arranges for the command to read its input from src,
+	// and reports whether it could. It returns false if it does not
+	// recognize src, or if the command has started.
+	SpliceFrom(src io.Reader) bool
+
+	// SpliceTo arranges for the command to write its output to dst,
+	// and reports whether it could. It returns false if it does not
+	// recognize dst, or if the command has started.
+	SpliceTo(dst io.Writer) bool
+}
+
 // Attach attaches buf to the controlling terminal if it implements
 // [AttachBuffer].
 // Does nothing if buf does not implement AttachBuffer.
@@ -92,6 +142,21 @@ func Attach(buf Buffer) error {
 	return nil
 }
 
+// ResultOf returns the result of buf if it implements [ResultBuffer] and
+// its command has finished.
+//
+//	buf := m.Command(ctx, "make")
+//	_, err := io.Copy(os.Stdout, buf)
+//	if r, ok := command.ResultOf(buf); ok {
+//	    fmt.Printf("exit %d after %v\n", r.Code, r.Duration())
+//	}
+func ResultOf(buf Buffer) (Result, bool) {
+	if rb, ok := buf.(ResultBuffer); ok {
+		return rb.Result()
+	}
+	return Result{}, false
+}
+
 // Log sets the log destination for buf if it implements [LogBuffer].
 // Does nothing if buf does not implement LogBuffer.
 func Log(buf Buffer, w io.Writer) {
@@ -99,3 +164,52 @@ func Log(buf Buffer, w io.Writer) {
 		l.Log(w)
 	}
 }
+
+// SpliceFrom splices src into the input of buf if buf implements
+// [SpliceBuffer], and reports whether it could. If buf does not recognize
+// src, src is asked to splice its output into buf instead, so that
+// buffers that wrap commands splice the commands beneath them.
+//
+// Buffers that wrap another Buffer implement SpliceBuffer with it:
+//
+//	func (b *wrapper) SpliceFrom(src io.Reader) bool {
+//	    return command.SpliceFrom(b.Buffer, src)
+//	}
+func SpliceFrom(buf Buffer, src io.Reader) bool {
+	sb, ok := buf.(SpliceBuffer)
+	if !ok {
+		return false
+	}
+	if sb.SpliceFrom(src) {
+		return true
+	}
+	s, ok := src.(spliceToer)
+	if !ok {
+		return false
+	}
+	w, ok := sb.(io.Writer)
+	return ok && s.SpliceTo(w)
+}
+
+// SpliceTo splices the output of buf into dst if buf implements
+// [SpliceBuffer], and reports whether it could. If buf does not recognize
+// dst, dst is asked to splice buf into its input instead, as with
+// [SpliceFrom].
+func SpliceTo(buf Buffer, dst io.Writer) bool {
+	sb, ok := buf.(SpliceBuffer)
+	if !ok {
+		return false
+	}
+	if sb.SpliceTo(dst) {
+		return true
+	}
+	d, ok := dst.(spliceFromer)
+	return ok && d.SpliceFrom(sb)
+}
+
+// A spliceFromer can splice a reader into its input. Unlike a
+// [SpliceBuffer], it need not be readable, like the writer of [NewWriter].
+type spliceFromer interface{ SpliceFrom(src io.Reader) bool }
+
+// A spliceToer can splice its output into a writer.
+type spliceToer interface{ SpliceTo(dst io.Writer) bool }
@@ -0,0 +1,28 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+)
+
+// CacheDir returns the directory on m in which this module keeps its
+// caches: a lesiw-command directory in the user's cache directory,
+// which is %LocalAppData% on Windows and $XDG_CACHE_HOME, or ~/.cache,
+// elsewhere. The path is separated by forward slashes on every OS.
+func CacheDir(ctx context.Context, m Machine) (string, error) {
+	var dir string
+	if OS(ctx, m) == "windows" {
+		dir = Env(ctx, m, "LOCALAPPDATA")
+		dir = strings.ReplaceAll(dir, `\`, "/")
+	} else if dir = Env(ctx, m, "XDG_CACHE_HOME"); dir == "" {
+		if home := Env(ctx, m, "HOME"); home != "" {
+			dir = path.Join(home, ".cache")
+		}
+	}
+	if dir == "" {
+		return "", errors.New("no cache directory found")
+	}
+	return path.Join(dir, "lesiw-command"), nil
+}
@@ -0,0 +1,54 @@
+package command_test
+
+import (
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+)
+
+func TestCacheDir(t *testing.T) {
+	tests := []struct {
+		os   string
+		env  map[string]string
+		want string
+	}{{
+		os:   "linux",
+		env:  map[string]string{"HOME": "/home/u"},
+		want: "/home/u/.cache/lesiw-command",
+	}, {
+		os:   "linux",
+		env:  map[string]string{"XDG_CACHE_HOME": "/c", "HOME": "/home/u"},
+		want: "/c/lesiw-command",
+	}, {
+		os:   "windows",
+		env:  map[string]string{"LOCALAPPDATA": `C:\Users\u\AppData\Local`},
+		want: "C:/Users/u/AppData/Local/lesiw-command",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			m := new(mock.Machine)
+			m.SetOS(tt.os)
+			ctx := command.WithEnv(t.Context(), tt.env)
+
+			got, err := command.CacheDir(ctx, m)
+
+			if err != nil {
+				t.Fatalf("command.CacheDir error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("command.CacheDir = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheDirMissing(t *testing.T) {
+	m := new(mock.Machine)
+	m.SetOS("linux")
+	ctx := command.WithEnv(t.Context(), map[string]string{"HOME": ""})
+
+	if _, err := command.CacheDir(ctx, m); err == nil {
+		t.Error("command.CacheDir succeeded, want error")
+	}
+}
@@ -0,0 +1,342 @@
+// Package cachex caches the results of commands in a content-addressed
+// store, so that an expensive step whose inputs have not changed is
+// restored rather than run again, on any machine that shares the store.
+//
+//	c := &cachex.Cache{Store: cachex.Dir("/var/cache/build")}
+//	hit, err := c.Run(ctx, m, cachex.Step{
+//	    Args:    []string{"go", "build", "-o", "bin/app", "."},
+//	    Inputs:  []string{"go.mod", "go.sum", "main.go"},
+//	    Outputs: []string{"bin/app"},
+//	})
+//
+// A step's key is a digest of its arguments, working directory,
+// environment variables set in its context, inputs, outputs, and the OS
+// and architecture of the machine it runs on. Its
+// results, the files it declares as outputs and its standard output, are
+// stored once under the digest of their content, so steps with the same
+// results share storage. Stores may be local directories, buckets in
+// object storage, or anything else that implements [Store].
+package cachex
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"lesiw.io/command"
+	"lesiw.io/fs"
+)
+
+// A Step is a command whose results can be cached.
+type Step struct {
+	// Args is the command and its arguments.
+	Args []string
+
+	// Inputs are the files on the machine whose contents decide the
+	// results, in addition to those declared by command.WithInputs.
+	Inputs []string
+
+	// Outputs are the files on the machine that the command produces,
+	// relative to the working directory.
+	Outputs []string
+
+	// Stdout receives the command's output, whether it ran or was
+	// restored. If nil, output is discarded.
+	Stdout io.Writer
+}
+
+// A Cache runs steps, storing their results in Store.
+type Cache struct {
+	Store Store
+}
+
+// Key returns the key of step when run on m with ctx.
+func Key(ctx context.Context, m command.Machine, step Step) (string, error) {
+	h := sha256.New()
+	field := func(s string) { _, _ = io.WriteString(h, s+"\x00") }
+	field(command.OS(ctx, m))
+	field(command.Arch(ctx, m))
+	field(fs.WorkDir(ctx))
+	env := command.Envs(ctx)
+	for _, k := range slices.Sorted(maps.Keys(env)) {
+		field(k + "=" + env[k])
+	}
+	field("")
+	for _, arg := range step.Args {
+		field(arg)
+	}
+	field("")
+	fsys := command.FS(m)
+	inputs := slices.Concat(command.Inputs(ctx), step.Inputs)
+	for _, name := range inputs {
+		data, err := fs.ReadFile(ctx, fsys, name)
+		if err != nil {
+			return "", fmt.Errorf("failed to hash input %q: %w", name, err)
+		}
+		sum := sha256.Sum256(data)
+		field(name)
+		field(hex.EncodeToString(sum[:]))
+	}
+	field("")
+	for _, name := range step.Outputs {
+		field(name)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Run restores the results of step on m from the cache, if they are in
+// it, or else runs step on m and stores its results if it succeeds. It
+// reports whether the results were restored.
+//
+// A store that cannot be read is treated as missing the results, so
+// that the step runs. A failure to store results, after the step ran
+// successfully, is returned.
+func (c *Cache) Run(
+	ctx context.Context, m command.Machine, step Step,
+) (hit bool, err error) {
+	key, err := Key(ctx, m, step)
+	if err != nil {
+		return false, err
+	}
+	stdout := step.Stdout
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if entry, err := c.get(ctx, key); err == nil {
+		err := restore(ctx, m, entry, step.Outputs, stdout)
+		if err != nil {
+			// Finish reading the entry, to learn if it was bad.
+			_, rerr := io.Copy(io.Discard, entry)
+			err = errors.Join(err, rerr)
+		}
+		if err = errors.Join(err, entry.Close()); err == nil {
+			return true, nil
+		} else if !errors.Is(err, errBadEntry) {
+			return false, fmt.Errorf("failed to restore %s: %w", key, err)
+		}
+		// A bad entry is missing; the step overwrites what it wrote.
+	}
+
+	var out bytes.Buffer
+	err = command.Run(ctx, m, command.Spec{
+		Args:   step.Args,
+		Stdout: io.MultiWriter(stdout, &out),
+	})
+	if err != nil {
+		return false, err
+	}
+	entry, err := pack(ctx, m, out.Bytes(), step.Outputs)
+	if err != nil {
+		return false, fmt.Errorf("failed to store %s: %w", key, err)
+	}
+	if err := c.put(ctx, key, entry); err != nil {
+		return false, fmt.Errorf("failed to store %s: %w", key, err)
+	}
+	return false, nil
+}
+
+// Entries are stored as blobs named by the digest of their content, in
+// cas/, with the digest of each key's blob stored under the key, in ac/.
+
+func (c *Cache) get(ctx context.Context, key string) (io.ReadCloser, error) {
+	digest, err := readAll(ctx, c.Store, "ac/"+key)
+	if err != nil {
+		return nil, err
+	}
+	want := strings.TrimSpace(string(digest))
+	r, err := c.Store.Get(ctx, "cas/"+want)
+	if err != nil {
+		return nil, err
+	}
+	return &verifier{r: r, h: sha256.New(), want: want}, nil
+}
+
+// errBadEntry is the error of an entry that cannot be read from the store
+// or does not match its digest.
+var errBadEntry = errors.New("bad entry")
+
+// A verifier reads an entry, and fails at its end if the entry does not
+// match the digest it is stored under. Its errors wrap errBadEntry.
+type verifier struct {
+	r    io.ReadCloser
+	h    hash.Hash
+	want string
+}
+
+func (v *verifier) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	_, _ = v.h.Write(p[:n])
+	switch {
+	case err == nil:
+	case !errors.Is(err, io.EOF):
+		err = fmt.Errorf("%w %s: %w", errBadEntry, v.want, err)
+	case hex.EncodeToString(v.h.Sum(nil)) != v.want:
+		err = fmt.Errorf("%w %s: corrupt", errBadEntry, v.want)
+	}
+	return n, err
+}
+
+func (v *verifier) Close() error { return v.r.Close() }
+
+func (c *Cache) put(ctx context.Context, key string, entry []byte) error {
+	sum := sha256.Sum256(entry)
+	digest := hex.EncodeToString(sum[:])
+	if !exists(ctx, c.Store, "cas/"+digest) {
+		err := c.Store.Put(ctx, "cas/"+digest, bytes.NewReader(entry))
+		if err != nil {
+			return err
+		}
+	}
+	return c.Store.Put(ctx, "ac/"+key, strings.NewReader(digest+"\n"))
+}
+
+func readAll(ctx context.Context, s Store, key string) ([]byte, error) {
+	r, err := s.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func exists(ctx context.Context, s Store, key string) bool {
+	r, err := s.Get(ctx, key)
+	if err != nil {
+		return false
+	}
+	_ = r.Close()
+	return true
+}
+
+// stdoutName is the name of the standard output in an entry. Output
+// files are under files/.
+const stdoutName = "stdout"
+
+// pack returns an entry of out and the files on m named by outputs.
+func pack(
+	ctx context.Context, m command.Machine, out []byte, outputs []string,
+) ([]byte, error) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	add := func(name string, mode int64, data []byte) error {
+		hdr := &tar.Header{Name: name, Mode: mode, Size: int64(len(data))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := add(stdoutName, 0o644, out); err != nil {
+		return nil, err
+	}
+	fsys := command.FS(m)
+	for _, name := range outputs {
+		if err := checkOutput(name); err != nil {
+			return nil, err
+		}
+		data, err := fs.ReadFile(ctx, fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("missing output %q: %w", name, err)
+		}
+		mode := int64(0o644)
+		if info, err := fs.Stat(ctx, fsys, name); err == nil {
+			mode = int64(info.Mode().Perm())
+		}
+		if err := add("files/"+name, mode, data); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// restore writes the output files of the entry read from r to m and its
+// standard output to stdout. Only the files named by outputs are written,
+// and stdout is written only once the whole entry has been read, so that
+// a bad entry writes nothing to it.
+func restore(
+	ctx context.Context, m command.Machine, r io.Reader, outputs []string,
+	stdout io.Writer,
+) error {
+	fsys := command.FS(m)
+	var out bytes.Buffer
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Name == stdoutName {
+			if _, err := io.Copy(&out, tr); err != nil {
+				return err
+			}
+			continue
+		}
+		name, ok := strings.CutPrefix(hdr.Name, "files/")
+		if !ok {
+			continue
+		}
+		if err := checkOutput(name); err != nil {
+			return err
+		}
+		if !slices.Contains(outputs, name) {
+			continue
+		}
+		if err := restoreFile(ctx, fsys, name, hdr, tr); err != nil {
+			return err
+		}
+	}
+	// Read past the end of the archive to check its digest.
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		return err
+	}
+	_, err := out.WriteTo(stdout)
+	return err
+}
+
+// restoreFile writes the file described by hdr, read from r, to name.
+func restoreFile(
+	ctx context.Context, fsys fs.FS, name string, hdr *tar.Header,
+	r io.Reader,
+) error {
+	w, err := fs.Create(ctx, fsys, name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	if err = errors.Join(err, w.Close()); err != nil {
+		return err
+	}
+	err = fs.Chmod(ctx, fsys, name, fs.Mode(hdr.Mode).Perm())
+	if err != nil && !errors.Is(err, fs.ErrUnsupported) {
+		return err
+	}
+	return nil
+}
+
+// checkOutput returns an error if name, the name of an output file, is
+// absolute or leads out of the working directory.
+func checkOutput(name string) error {
+	clean := strings.ReplaceAll(name, `\`, "/")
+	if clean == "" || path.IsAbs(clean) ||
+		len(clean) >= 2 && clean[1] == ':' ||
+		slices.Contains(strings.Split(clean, "/"), "..") {
+		return fmt.Errorf("unsafe output name %q", name)
+	}
+	return nil
+}
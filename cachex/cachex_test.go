@@ -0,0 +1,312 @@
+package cachex_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/cachex"
+	"lesiw.io/command/mock"
+	lfs "lesiw.io/fs"
+)
+
+// buildMachine returns a machine whose build command writes bin/app and
+// counts its runs.
+func buildMachine(t *testing.T, runs *int) *mock.Machine {
+	t.Helper()
+	m := new(mock.Machine)
+	m.SetOS("linux")
+	m.SetArch("amd64")
+	m.Do(func(ctx context.Context, _ ...string) command.Buffer {
+		*runs++
+		err := lfs.WriteFile(ctx, m.FS(), "bin/app", []byte("binary"))
+		if err != nil {
+			return command.Fail(err)
+		}
+		return strings.NewReader("built\n")
+	}, "build")
+	err := lfs.WriteFile(t.Context(), m.FS(), "main.go", []byte("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestCacheRun(t *testing.T) {
+	ctx := t.Context()
+	c := &cachex.Cache{Store: cachex.Dir(t.TempDir())}
+	var runs int
+	m := buildMachine(t, &runs)
+	step := cachex.Step{
+		Args:    []string{"build"},
+		Inputs:  []string{"main.go"},
+		Outputs: []string{"bin/app"},
+	}
+
+	var out strings.Builder
+	step.Stdout = &out
+	hit, err := c.Run(ctx, m, step)
+	if err != nil || hit {
+		t.Fatalf("first Cache.Run = %v, %v, want miss", hit, err)
+	}
+
+	// Another machine with the same inputs restores the results.
+	var runs2 int
+	m2 := buildMachine(t, &runs2)
+	out.Reset()
+	hit, err = c.Run(ctx, m2, step)
+	if err != nil || !hit {
+		t.Fatalf("second Cache.Run = %v, %v, want hit", hit, err)
+	}
+	if runs2 != 0 {
+		t.Errorf("build ran %d times on a hit, want 0", runs2)
+	}
+	if got, want := out.String(), "built\n"; got != want {
+		t.Errorf("restored stdout = %q, want %q", got, want)
+	}
+	got, err := lfs.ReadFile(ctx, m2.FS(), "bin/app")
+	if err != nil {
+		t.Fatalf("restored bin/app: %v", err)
+	}
+	if want := "binary"; string(got) != want {
+		t.Errorf("restored bin/app = %q, want %q", got, want)
+	}
+
+	// Changing an input misses.
+	err = lfs.WriteFile(ctx, m.FS(), "main.go", []byte("v2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hit, err = c.Run(ctx, m, step)
+	if err != nil || hit {
+		t.Fatalf("Cache.Run after change = %v, %v, want miss", hit, err)
+	}
+	if runs != 2 {
+		t.Errorf("build ran %d times, want 2", runs)
+	}
+}
+
+func TestCacheRunEnv(t *testing.T) {
+	ctx := t.Context()
+	c := &cachex.Cache{Store: cachex.Dir(t.TempDir())}
+	var runs int
+	m := buildMachine(t, &runs)
+	step := cachex.Step{Args: []string{"build"}, Outputs: []string{"bin/app"}}
+
+	for _, flags := range []string{"-race", "", "-race"} {
+		env := map[string]string{"GOFLAGS": flags}
+		if _, err := c.Run(command.WithEnv(ctx, env), m, step); err != nil {
+			t.Fatalf("Cache.Run(GOFLAGS=%q) error: %v", flags, err)
+		}
+	}
+	if runs != 2 {
+		t.Errorf("build ran %d times, want 2", runs)
+	}
+}
+
+func TestCacheRunFailure(t *testing.T) {
+	ctx := t.Context()
+	c := &cachex.Cache{Store: cachex.Dir(t.TempDir())}
+	m := new(mock.Machine)
+	m.Return(command.Fail(&command.Error{Code: 2}), "build")
+	step := cachex.Step{Args: []string{"build"}}
+
+	for range 2 {
+		if _, err := c.Run(ctx, m, step); err == nil {
+			t.Fatal("Cache.Run succeeded, want error")
+		}
+	}
+	if got, want := len(mock.Calls(m, "build")), 2; got != want {
+		t.Errorf("build calls = %d, want %d", got, want)
+	}
+}
+
+// putEntry stores an entry under the key of step on m in the store in dir,
+// with files, by name in the entry, as its content.
+func putEntry(
+	t *testing.T, dir string, m command.Machine, step cachex.Step,
+	files map[string]string,
+) {
+	t.Helper()
+	key, err := cachex.Key(t.Context(), m, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for name, data := range files {
+		hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(data))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(tw, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	digest := hex.EncodeToString(sum[:])
+	store := cachex.Dir(dir)
+	err = store.Put(t.Context(), "cas/"+digest, &buf)
+	if err == nil {
+		err = store.Put(t.Context(), "ac/"+key,
+			strings.NewReader(digest+"\n"))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCacheRestoreOutputs(t *testing.T) {
+	ctx, dir := t.Context(), t.TempDir()
+	c := &cachex.Cache{Store: cachex.Dir(dir)}
+	var runs int
+	m := buildMachine(t, &runs)
+	step := cachex.Step{
+		Args:    []string{"build"},
+		Inputs:  []string{"main.go"},
+		Outputs: []string{"bin/app"},
+	}
+	putEntry(t, dir, m, step, map[string]string{
+		"stdout":        "built\n",
+		"files/bin/app": "binary",
+		"files/.bashrc": "evil",
+	})
+
+	hit, err := c.Run(ctx, m, step)
+
+	if err != nil || !hit {
+		t.Fatalf("Cache.Run = %v, %v, want hit", hit, err)
+	}
+	if _, err := lfs.Stat(ctx, m.FS(), ".bashrc"); err == nil {
+		t.Error("restored .bashrc, which the step does not output")
+	}
+}
+
+func TestCacheRestoreUnsafe(t *testing.T) {
+	for _, name := range []string{"../evil", "/etc/evil", "a/../../evil"} {
+		t.Run(name, func(t *testing.T) {
+			ctx, dir := t.Context(), t.TempDir()
+			c := &cachex.Cache{Store: cachex.Dir(dir)}
+			var runs int
+			m := buildMachine(t, &runs)
+			step := cachex.Step{
+				Args:    []string{"build"},
+				Outputs: []string{name},
+			}
+			putEntry(t, dir, m, step, map[string]string{
+				"files/" + name: "evil",
+			})
+
+			if _, err := c.Run(ctx, m, step); err == nil {
+				t.Error("Cache.Run succeeded, want error")
+			}
+			if runs != 0 {
+				t.Errorf("build ran %d times, want 0", runs)
+			}
+		})
+	}
+}
+
+func TestCacheRunCorrupt(t *testing.T) {
+	ctx, dir := t.Context(), t.TempDir()
+	c := &cachex.Cache{Store: cachex.Dir(dir)}
+	var runs int
+	m := buildMachine(t, &runs)
+	step := cachex.Step{Args: []string{"build"}, Outputs: []string{"bin/app"}}
+	putEntry(t, dir, m, step, map[string]string{"stdout": "built\n"})
+	blobs, err := filepath.Glob(filepath.Join(dir, "cas", "*"))
+	if err != nil || len(blobs) != 1 {
+		t.Fatalf("cas blobs = %v, %v, want one", blobs, err)
+	}
+	if err := os.WriteFile(blobs[0], []byte("junk"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	step.Stdout = &out
+	hit, err := c.Run(ctx, m, step)
+
+	if err != nil || hit {
+		t.Fatalf("Cache.Run = %v, %v, want miss", hit, err)
+	}
+	if runs != 1 {
+		t.Errorf("build ran %d times, want 1", runs)
+	}
+	if got, want := out.String(), "built\n"; got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+}
+
+func TestKey(t *testing.T) {
+	ctx := t.Context()
+	var runs int
+	m := buildMachine(t, &runs)
+	step := cachex.Step{Args: []string{"build"}, Inputs: []string{"main.go"}}
+
+	k1, err := cachex.Key(ctx, m, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.SetArch("arm64")
+	k2, err := cachex.Key(ctx, m, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k1 == k2 {
+		t.Error("keys for different architectures are equal")
+	}
+
+	_, err = cachex.Key(ctx, m, cachex.Step{Inputs: []string{"missing"}})
+	if err == nil {
+		t.Error("Key with a missing input succeeded, want error")
+	}
+}
+
+func TestBucket(t *testing.T) {
+	ctx := t.Context()
+	m := new(mock.Machine)
+	m.Return(command.Fail(&command.Error{
+		Log:  []byte("fatal error: An error occurred (404) when calling\n"),
+		Code: 1,
+	}), "aws", "s3", "cp")
+	m.Return(strings.NewReader(""),
+		"aws", "s3", "cp", "--only-show-errors", "-")
+	store := cachex.Bucket(m, "s3://cache/build/")
+
+	_, err := store.Get(ctx, "ac/abc")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Get error: got %v, want fs.ErrNotExist", err)
+	}
+	if err := store.Put(ctx, "ac/abc", strings.NewReader("d\n")); err != nil {
+		t.Errorf("Put error: %v", err)
+	}
+	calls := mock.Calls(m, "aws")
+	if len(calls) != 2 {
+		t.Fatalf("aws calls = %d, want 2", len(calls))
+	}
+	want := "aws s3 cp --only-show-errors s3://cache/build/ac/abc -"
+	if got := strings.Join(calls[0].Args, " "); got != want {
+		t.Errorf("Get ran %q, want %q", got, want)
+	}
+	want = "aws s3 cp --only-show-errors - s3://cache/build/ac/abc"
+	if got := strings.Join(calls[1].Args, " "); got != want {
+		t.Errorf("Put ran %q, want %q", got, want)
+	}
+
+	if _, err := cachex.Bucket(m, "ftp://x").Get(ctx, "k"); err == nil {
+		t.Error("Get from ftp:// succeeded, want error")
+	}
+}
@@ -0,0 +1,117 @@
+package cachex
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"lesiw.io/command"
+)
+
+// A Store holds blobs by key. Keys are slash-separated paths.
+type Store interface {
+	// Get returns a reader of the blob stored under key, or an error
+	// that wraps fs.ErrNotExist if there is none.
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+
+	// Put stores the content of r under key, replacing any blob that
+	// was stored under it.
+	Put(ctx context.Context, key string, r io.Reader) error
+}
+
+// Dir returns a Store that keeps blobs as files in the local directory
+// dir, creating it as needed.
+func Dir(dir string) Store { return dirStore(dir) }
+
+type dirStore string
+
+func (d dirStore) path(key string) string {
+	return filepath.Join(string(d), filepath.FromSlash(key))
+}
+
+func (d dirStore) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(d.path(key))
+}
+
+func (d dirStore) Put(_ context.Context, key string, r io.Reader) error {
+	name := d.path(key)
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	// Readers in other processes must never see a partial blob.
+	f, err := os.CreateTemp(filepath.Dir(name), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), name)
+}
+
+// Bucket returns a Store that keeps blobs in object storage under url,
+// with the aws CLI for an s3:// URL and the gcloud CLI for a gs:// URL,
+// run on m. Credentials are those of the CLI on m.
+//
+//	store := cachex.Bucket(sys.Machine(), "s3://ci-cache/build")
+func Bucket(m command.Machine, url string) Store {
+	return &bucket{m: m, url: strings.TrimSuffix(url, "/")}
+}
+
+type bucket struct {
+	m   command.Machine
+	url string
+}
+
+// notFoundRE matches the errors of the aws and gcloud CLIs for objects
+// that do not exist.
+var notFoundRE = regexp.MustCompile(
+	`(?i)\(404\)|not found|NoSuchKey|No URLs matched|does not exist`,
+)
+
+func (b *bucket) cp(src, dst string) ([]string, error) {
+	switch {
+	case strings.HasPrefix(b.url, "s3://"):
+		return []string{"aws", "s3", "cp", "--only-show-errors",
+			src, dst}, nil
+	case strings.HasPrefix(b.url, "gs://"):
+		return []string{"gcloud", "storage", "cp", "--quiet",
+			src, dst}, nil
+	}
+	return nil, fmt.Errorf("unsupported bucket %q", b.url)
+}
+
+func (b *bucket) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	args, err := b.cp(b.url+"/"+key, "-")
+	if err != nil {
+		return nil, err
+	}
+	// Blobs are small enough to read whole, which also reports a
+	// missing blob here rather than on the first read.
+	out, err := command.Read(ctx, b.m, args...)
+	if err != nil {
+		if notFoundRE.MatchString(err.Error()) {
+			return nil, fmt.Errorf("%s/%s: %w", b.url, key, fs.ErrNotExist)
+		}
+		return nil, err
+	}
+	return io.NopCloser(strings.NewReader(out)), nil
+}
+
+func (b *bucket) Put(ctx context.Context, key string, r io.Reader) error {
+	args, err := b.cp("-", b.url+"/"+key)
+	if err != nil {
+		return err
+	}
+	return command.Run(ctx, b.m, command.Spec{Args: args, Stdin: r})
+}
@@ -0,0 +1,66 @@
+package command
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+type mergeKey struct{}
+
+// CombinedOutput executes a command and returns its output and
+// diagnostic output, merged, as a string.
+// Trailing newlines are stripped from the output.
+//
+// CombinedOutput asks the machine to merge diagnostic output into output
+// at its source, as if by 2>&1, using [WithMergedStderr]. Machines that
+// do not support merging have their diagnostic output interleaved with
+// their output one write at a time, as the machine delivers it.
+//
+// Because diagnostic output is part of the result, errors returned by
+// CombinedOutput do not include log output. Like [Read], CombinedOutput
+// returns the output gathered before a failure, and records it in the
+// error's Output field.
+func CombinedOutput(
+	ctx context.Context, m Machine, args ...string,
+) (string, error) {
+	out := new(combinedWriter)
+	err := Run(WithMergedStderr(ctx, true), m, Spec{
+		Args:   args,
+		Stdout: out,
+		Stderr: out,
+	})
+	err = withOutput(ctx, err, out.String())
+	return strings.TrimRight(out.String(), "\r\n"), err
+}
+
+type combinedWriter struct {
+	sync.Mutex
+	strings.Builder
+}
+
+func (w *combinedWriter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	return w.Builder.Write(p)
+}
+
+// WithMergedStderr returns a new context that asks machines to merge
+// the diagnostic output of commands into their output, if merge is true,
+// or to keep them separate, if merge is false.
+//
+// Machines and helpers clear this setting from the commands they run for
+// themselves, with [WithoutCommandOptions].
+func WithMergedStderr(ctx context.Context, merge bool) context.Context {
+	if MergedStderr(ctx) == merge {
+		return ctx
+	}
+	return context.WithValue(ctx, mergeKey{}, merge)
+}
+
+// MergedStderr reports whether ctx asks for diagnostic output to be
+// merged into output.
+func MergedStderr(ctx context.Context) bool {
+	merge, _ := ctx.Value(mergeKey{}).(bool)
+	return merge
+}
@@ -0,0 +1,112 @@
+package command
+
+import (
+	"context"
+	"strings"
+)
+
+// completeScript prints the bash completions of the last of its
+// arguments, one per line. It loads bash-completion, if it is installed,
+// and runs the completion function registered for the command as bash
+// would on a tab press. Commands with no completion of their own
+// complete file names, and the first word completes command names.
+const completeScript = `words=("$@")
+cword=$((${#words[@]} - 1))
+cur=${words[cword]}
+if [ "$cword" -eq 0 ]; then
+	compgen -c -- "$cur"
+	exit 0
+fi
+for f in /usr/share/bash-completion/bash_completion \
+	/usr/local/share/bash-completion/bash_completion \
+	/opt/homebrew/share/bash-completion/bash_completion \
+	/etc/bash_completion; do
+	if [ -r "$f" ]; then . "$f"; break; fi
+done >/dev/null 2>&1
+cmd=${words[0]##*/}
+spec=$(complete -p "$cmd" 2>/dev/null)
+if [ -z "$spec" ] && declare -F _completion_loader >/dev/null; then
+	_completion_loader "$cmd" >/dev/null 2>&1
+	spec=$(complete -p "$cmd" 2>/dev/null)
+fi
+if [[ $spec =~ -F\ ([^ ]+) ]]; then
+	COMP_WORDS=("${words[@]}")
+	COMP_CWORD=$cword
+	COMP_LINE="${words[*]}"
+	COMP_POINT=${#COMP_LINE}
+	COMPREPLY=()
+	"${BASH_REMATCH[1]}" "$cmd" "$cur" "${words[cword-1]}" >/dev/null 2>&1
+	printf '%s\n' "${COMPREPLY[@]}"
+elif [ -n "$spec" ]; then
+	opts=${spec#complete }
+	eval "compgen ${opts% *} -- \"\$cur\""
+else
+	compgen -f -- "$cur"
+fi
+exit 0`
+
+// Complete returns the completions that the shell of m suggests for the
+// last of args, as if a user had typed args at a prompt on m and pressed
+// tab. Pass an empty last argument to list what may follow the others:
+//
+//	words, err := command.Complete(ctx, m, "git", "che")
+//	// [checkout cherry cherry-pick]
+//	words, err = command.Complete(ctx, m, "git", "checkout", "")
+//	// The branches of the repository in the working directory.
+//
+// On Unix, completion is done by bash, with the bash-completion package
+// if it is installed; without it, arguments complete as file names. On
+// Windows, it is done by PowerShell. The first argument completes as a
+// command name. Completions are in the order the shell gives them, with
+// duplicates removed, or nil if there are none.
+//
+// Complete is meant for interactive frontends to remote machines, which
+// cannot run the machine's own shell at a terminal.
+func Complete(
+	ctx context.Context, m Machine, args ...string,
+) ([]string, error) {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	ctx = WithoutCommandOptions(ctx)
+	var (
+		out string
+		err error
+	)
+	if OS(ctx, m) == "windows" {
+		words := make([]string, len(args))
+		for i, arg := range args {
+			words[i] = psQuoteWord(arg)
+		}
+		out, err = psRead(ctx, m, `$line = '%s'
+(TabExpansion2 -inputScript $line -cursorColumn $line.Length).
+	CompletionMatches | ForEach-Object { $_.CompletionText }`,
+			psEscape(strings.Join(words, " ")))
+	} else {
+		out, err = Read(ctx, m,
+			append([]string{"bash", "-c", completeScript, "bash"}, args...)...,
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var words []string
+	seen := make(map[string]bool)
+	for line := range strings.Lines(out) {
+		word := strings.TrimRight(line, " \r\n")
+		if word != "" && !seen[word] {
+			seen[word] = true
+			words = append(words, word)
+		}
+	}
+	return words, nil
+}
+
+// psQuoteWord quotes s as one word of a PowerShell command line, unless
+// it needs no quoting.
+func psQuoteWord(s string) string {
+	if !strings.ContainsAny(s, " \t'\"`$;&|(){}@,") {
+		return s
+	}
+	return "'" + psEscape(s) + "'"
+}
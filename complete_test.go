@@ -0,0 +1,54 @@
+package command_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lesiw.io/command"
+	"lesiw.io/command/sys"
+	"lesiw.io/fs"
+)
+
+func TestCompleteCommand(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("requires bash")
+	}
+	got, err := command.Complete(t.Context(), sys.Machine(), "ech")
+	if err != nil {
+		t.Fatalf("command.Complete error: %v", err)
+	}
+	if !slices.Contains(got, "echo") {
+		t.Errorf("command.Complete(ech) = %q, want echo among them", got)
+	}
+}
+
+func TestCompleteFiles(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("requires bash")
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"alpha.txt", "alps.txt", "beta.txt"} {
+		err := os.WriteFile(filepath.Join(dir, name), nil, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := fs.WithWorkDir(t.Context(), dir)
+
+	got, err := command.Complete(ctx, sys.Machine(),
+		"no-such-command-for-completion", "al")
+	if err != nil {
+		t.Fatalf("command.Complete error: %v", err)
+	}
+	slices.Sort(got)
+
+	want := []string{"alpha.txt", "alps.txt"}
+	if !cmp.Equal(got, want) {
+		t.Errorf("command.Complete (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
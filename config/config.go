@@ -0,0 +1,170 @@
+// Package config loads per-user defaults for the machines of
+// lesiw.io/command, so that every tool built on it behaves the same way
+// for a user or team.
+//
+// Defaults are read from the file config.toml in the lesiw-command
+// directory of $XDG_CONFIG_HOME, or of ~/.config if that is unset:
+//
+//	[container]
+//	# Container CLIs to try first, in order.
+//	cli = ["podman", "docker"]
+//
+//	[ssh]
+//	# Flags added to the command lines of ssh Machines.
+//	options = ["-o", "ServerAliveInterval=30"]
+//
+//	[trace]
+//	# How commands are traced: "off", "on", or "full".
+//	mode = "on"
+//
+// The file is optional, and so is each of its settings. Settings made by
+// programs always win over those of the file: options passed to a
+// Machine, a context from command.WithTrace, and the CMDTRACE environment
+// variable all take precedence. Programs can also replace the file's
+// settings for the commands of a context with [With].
+package config
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"lesiw.io/command/internal/toml"
+)
+
+// A Config holds defaults for machines.
+type Config struct {
+	// ContainerCLI lists container CLIs, such as "podman" or
+	// "lima nerdctl", that the ctr package tries before the others it
+	// knows, in order.
+	ContainerCLI []string
+
+	// SSHOptions are flags of the OpenSSH client that the ssh package
+	// adds to the command lines of its Machines, after the flags from
+	// their options. Since ssh uses the first value it is given for each
+	// option, those from the Machine's options win.
+	SSHOptions []string
+
+	// Trace is how commands are traced if the CMDTRACE environment
+	// variable is unset: "off", "on" for arguments only, or "full" to
+	// include environment variables. Empty means "off".
+	Trace string
+}
+
+// Path returns the path of the configuration file. On Windows, the file
+// is in the lesiw-command directory of %AppData% if XDG_CONFIG_HOME is
+// unset.
+func Path() (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" && runtime.GOOS == "windows" {
+		var err error
+		if dir, err = os.UserConfigDir(); err != nil {
+			return "", err
+		}
+	} else if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "lesiw-command", "config.toml"), nil
+}
+
+// Load reads the configuration file. If there is none, it returns an
+// empty Config.
+func Load() (*Config, error) {
+	path, err := Path()
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return new(Config), nil
+	} else if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	c, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("config: %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// Parse parses data in the format of the configuration file. Keys that
+// it does not know are ignored, so that files written for later versions
+// still load.
+func Parse(data []byte) (*Config, error) {
+	tables, err := toml.Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+	c := new(Config)
+	for _, t := range tables {
+		for _, kv := range t.Keys {
+			if err := c.set(kv); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return c, nil
+}
+
+func (c *Config) set(kv toml.KeyValue) (err error) {
+	switch kv.Name() {
+	case "container.cli":
+		c.ContainerCLI, err = kv.Strings()
+	case "ssh.options":
+		c.SSHOptions, err = kv.Strings()
+	case "trace.mode":
+		c.Trace, err = kv.Text()
+		if err == nil {
+			err = checkTrace(kv, c.Trace)
+		}
+	}
+	return err
+}
+
+func checkTrace(kv toml.KeyValue, mode string) error {
+	switch mode {
+	case "", "off", "on", "full":
+		return nil
+	}
+	return fmt.Errorf("line %d: %s: unknown mode %q",
+		kv.Line, kv.Name(), mode)
+}
+
+var loadDefault = sync.OnceValues(func() (*Config, error) {
+	c, err := Load()
+	if err != nil {
+		return new(Config), err
+	}
+	return c, nil
+})
+
+// Default returns the Config of the configuration file, which is read
+// once, on the first call. If the file cannot be read, Default returns an
+// empty Config and the reason.
+func Default() (*Config, error) { return loadDefault() }
+
+type configKey struct{}
+
+// With returns a context whose commands use c in place of the
+// configuration file. A nil c returns to the file.
+func With(ctx context.Context, c *Config) context.Context {
+	return context.WithValue(ctx, configKey{}, c)
+}
+
+// From returns the Config for the commands of ctx: the one given to
+// [With], if any, or else [Default], with its error.
+func From(ctx context.Context) (*Config, error) {
+	if c, _ := ctx.Value(configKey{}).(*Config); c != nil {
+		return c, nil
+	}
+	return Default()
+}
@@ -0,0 +1,172 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lesiw.io/command/config"
+	"lesiw.io/command/internal/configtest"
+)
+
+// TestMain gives the tests a configuration file that cannot be read, so
+// that they do not depend on that of the user who runs them.
+func TestMain(m *testing.M) { configtest.Main(m, "[trace]\nmode = 1\n") }
+
+func TestParse(t *testing.T) {
+	c, err := config.Parse([]byte(`
+# Defaults for the build team.
+[container]
+cli = ["podman", 'lima nerdctl'] # In order.
+
+[ssh]
+options = [
+	"-o", "ServerAliveInterval=30",
+	"-o", "IdentityFile=\"~/.ssh/team key\"", # Quoted for ssh.
+]
+
+[trace]
+mode = "on"
+
+[future]
+setting = "ignored"
+`))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	want := &config.Config{
+		ContainerCLI: []string{"podman", "lima nerdctl"},
+		SSHOptions: []string{
+			"-o", "ServerAliveInterval=30",
+			"-o", `IdentityFile="~/.ssh/team key"`,
+		},
+		Trace: "on",
+	}
+	if diff := cmp.Diff(want, c); diff != "" {
+		t.Errorf("Parse (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseDottedKeys(t *testing.T) {
+	c, err := config.Parse([]byte(`trace.mode = "full"
+container.cli = []
+`))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	want := &config.Config{ContainerCLI: []string{}, Trace: "full"}
+	if diff := cmp.Diff(want, c); diff != "" {
+		t.Errorf("Parse (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want string
+	}{{
+		name: "wrong type",
+		doc:  "[trace]\nmode = [\"on\"]\n",
+		want: "line 2: trace.mode: want a string",
+	}, {
+		name: "unknown mode",
+		doc:  "[trace]\nmode = \"loud\"\n",
+		want: `line 2: trace.mode: unknown mode "loud"`,
+	}, {
+		name: "unsupported value",
+		doc:  "[ssh]\nport = 22\n",
+		want: "line 2: unsupported value",
+	}, {
+		name: "unterminated string",
+		doc:  "[container]\ncli = [\"docker]\n",
+		want: "line 2: unterminated string",
+	}, {
+		name: "duplicate key",
+		doc:  "[trace]\nmode = \"on\"\nmode = \"off\"\n",
+		want: "line 3: trace.mode defined twice",
+	}, {
+		name: "trailing text",
+		doc:  "[trace] mode = \"on\"\n",
+		want: "line 1: want newline",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := config.Parse([]byte(tt.doc))
+			if err == nil {
+				t.Fatalf("Parse error = nil, want %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Parse error = %q, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+
+	c, err := config.Load()
+	if err != nil {
+		t.Fatalf("Load without a file: %v", err)
+	}
+	if diff := cmp.Diff(new(config.Config), c); diff != "" {
+		t.Errorf("Load without a file (-want +got):\n%s", diff)
+	}
+
+	path := filepath.Join(dir, "lesiw-command", "config.toml")
+	if got, err := config.Path(); err != nil || got != path {
+		t.Errorf("Path() = %q, %v, want %q", got, err, path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(path, []byte("[trace]\nmode = \"full\"\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err = config.Load()
+	if err != nil {
+		t.Fatalf("Load error: %v", err)
+	}
+	if got, want := c.Trace, "full"; got != want {
+		t.Errorf("Trace = %q, want %q", got, want)
+	}
+
+	err = os.WriteFile(path, []byte("[trace]\nmode = \"loud\"\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.Load(); err == nil ||
+		!strings.Contains(err.Error(), path) {
+		t.Errorf("Load error = %v, want error naming %s", err, path)
+	}
+}
+
+func TestDefaultError(t *testing.T) {
+	c, err := config.Default()
+	if err == nil || !strings.Contains(err.Error(), "config.toml") {
+		t.Errorf("Default error = %v, want error naming config.toml", err)
+	}
+	if diff := cmp.Diff(new(config.Config), c); diff != "" {
+		t.Errorf("Default (-want +got):\n%s", diff)
+	}
+}
+
+func TestWith(t *testing.T) {
+	c := &config.Config{Trace: "on"}
+	ctx := config.With(t.Context(), c)
+	if got, err := config.From(ctx); got != c || err != nil {
+		t.Errorf("From(With(ctx, c)) = %p, %v, want %p, nil", got, err, c)
+	}
+	ctx = config.With(ctx, nil)
+	def, defErr := config.Default()
+	if got, err := config.From(ctx); got != def || err != defErr {
+		t.Errorf("From(With(ctx, nil)) = %p, %v, want Default() %p, %v",
+			got, err, def, defErr)
+	}
+}
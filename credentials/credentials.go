@@ -0,0 +1,185 @@
+// Package credentials looks up the secrets that remote backends need,
+// such as SSH passwords and container registry tokens, from a [Store].
+//
+// Backends take a Store and the name of a secret, rather than the secret
+// itself, so that secrets are read only when needed, never appear in
+// command lines, and can come from wherever a deployment keeps them:
+//
+//	creds := credentials.Chain(
+//	    credentials.Env("DEPLOY"),
+//	    credentials.Keychain(sys.Machine(), "deploy"),
+//	)
+//	m := ssh.New(sys.Machine(), []string{"ssh", "admin@host"},
+//	    ssh.Password(creds, "admin"),
+//	)
+//
+// Tests can use a [Memory] store instead.
+package credentials
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"lesiw.io/command"
+)
+
+// ErrNotFound is returned by a Store that has no secret by the given
+// name.
+var ErrNotFound = errors.New("credentials: not found")
+
+// A Store looks up secrets by name.
+type Store interface {
+	// Get returns the secret called name. It returns an error wrapping
+	// ErrNotFound if there is none.
+	Get(ctx context.Context, name string) (string, error)
+}
+
+// StoreFunc adapts a function to a Store.
+type StoreFunc func(ctx context.Context, name string) (string, error)
+
+// Get returns f(ctx, name).
+func (f StoreFunc) Get(ctx context.Context, name string) (string, error) {
+	return f(ctx, name)
+}
+
+func notFound(name string) error {
+	return fmt.Errorf("%w: %s", ErrNotFound, name)
+}
+
+// Env returns a Store that reads secrets from environment variables of
+// the current process. The variable for a name is the name in upper case,
+// with characters other than letters and digits replaced by underscores,
+// after prefix and an underscore, if prefix is not empty: with the
+// prefix DEPLOY, the secret "db-password" is in DEPLOY_DB_PASSWORD.
+func Env(prefix string) Store {
+	return StoreFunc(func(_ context.Context, name string) (string, error) {
+		key := envName(name)
+		if prefix != "" {
+			key = prefix + "_" + key
+		}
+		v, ok := os.LookupEnv(key)
+		if !ok {
+			return "", notFound(name)
+		}
+		return v, nil
+	})
+}
+
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// Dir returns a Store that reads each secret from the file of the same
+// name in dir, as in the secret mounts of containers, such as
+// /run/secrets. A trailing newline is removed.
+func Dir(dir string) Store {
+	return StoreFunc(func(_ context.Context, name string) (string, error) {
+		if !filepath.IsLocal(name) {
+			return "", fmt.Errorf("bad secret name: %q", name)
+		}
+		b, err := os.ReadFile(filepath.Join(dir, name))
+		if errors.Is(err, os.ErrNotExist) {
+			return "", notFound(name)
+		} else if err != nil {
+			return "", err
+		}
+		s := strings.TrimSuffix(string(b), "\n")
+		return strings.TrimSuffix(s, "\r"), nil
+	})
+}
+
+// Keychain returns a Store that reads secrets from the keychain of the
+// operating system of m, for service: the login keychain on macOS, the
+// Secret Service (such as GNOME Keyring) on Linux, and the Credential
+// Locker on Windows. Each secret is the password of the account with its
+// name.
+func Keychain(m command.Machine, service string) Store {
+	return StoreFunc(func(ctx context.Context, name string) (string, error) {
+		var (
+			out string
+			err error
+		)
+		switch command.OS(ctx, m) {
+		case "darwin":
+			out, err = command.Read(ctx, m, "security",
+				"find-generic-password", "-s", service, "-a", name, "-w")
+		case "windows":
+			out, err = command.Read(ctx, m, "powershell",
+				"-NoProfile", "-NonInteractive", "-Command",
+				"[void][Windows.Security.Credentials.PasswordVault,"+
+					"Windows.Security.Credentials,"+
+					"ContentType=WindowsRuntime];"+
+					"$c = (New-Object "+
+					"Windows.Security.Credentials.PasswordVault)"+
+					".Retrieve($args[0], $args[1]);"+
+					"$c.RetrievePassword();"+
+					"[Console]::Out.Write($c.Password)",
+				service, name)
+		default:
+			out, err = command.Read(ctx, m, "secret-tool", "lookup",
+				"service", service, "account", name)
+		}
+		var cerr *command.Error
+		if errors.As(err, &cerr) && !command.NotFound(err) {
+			return "", fmt.Errorf("%w: %w", notFound(name), err)
+		} else if err != nil {
+			return "", fmt.Errorf("failed to read keychain: %w", err)
+		}
+		return out, nil
+	})
+}
+
+// Memory is a Store that keeps secrets in memory. The zero Memory is
+// empty and ready to use.
+type Memory struct {
+	mu      sync.RWMutex
+	secrets map[string]string
+}
+
+// Set sets the secret called name.
+func (s *Memory) Set(name, secret string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.secrets == nil {
+		s.secrets = make(map[string]string)
+	}
+	s.secrets[name] = secret
+}
+
+// Get returns the secret called name.
+func (s *Memory) Get(_ context.Context, name string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.secrets[name]
+	if !ok {
+		return "", notFound(name)
+	}
+	return v, nil
+}
+
+// Chain returns a Store that looks up each secret in stores, in order,
+// and returns the first found.
+func Chain(stores ...Store) Store {
+	return StoreFunc(func(ctx context.Context, name string) (string, error) {
+		for _, s := range stores {
+			v, err := s.Get(ctx, name)
+			if !errors.Is(err, ErrNotFound) {
+				return v, err
+			}
+		}
+		return "", notFound(name)
+	})
+}
@@ -0,0 +1,109 @@
+package credentials_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/credentials"
+	"lesiw.io/command/mock"
+)
+
+func TestEnv(t *testing.T) {
+	t.Setenv("DEPLOY_DB_PASSWORD", "hunter2")
+	s := credentials.Env("DEPLOY")
+
+	got, err := s.Get(t.Context(), "db-password")
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	if got != "hunter2" {
+		t.Errorf("Get = %q, want %q", got, "hunter2")
+	}
+	_, err = s.Get(t.Context(), "missing")
+	if !errors.Is(err, credentials.ErrNotFound) {
+		t.Errorf("Get(missing) error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestDir(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "token"), []byte("abc\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := credentials.Dir(dir)
+
+	got, err := s.Get(t.Context(), "token")
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	if got != "abc" {
+		t.Errorf("Get = %q, want %q", got, "abc")
+	}
+	_, err = s.Get(t.Context(), "missing")
+	if !errors.Is(err, credentials.ErrNotFound) {
+		t.Errorf("Get(missing) error = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Get(t.Context(), "../token"); err == nil {
+		t.Error("Get(../token): got nil error, want bad name")
+	}
+}
+
+func TestKeychain(t *testing.T) {
+	m := new(mock.Machine)
+	m.SetOS("linux")
+	m.Return(strings.NewReader("pa55\n"), "secret-tool")
+
+	got, err := credentials.Keychain(m, "deploy").Get(t.Context(), "admin")
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+	if got != "pa55" {
+		t.Errorf("Get = %q, want %q", got, "pa55")
+	}
+	want := []string{
+		"secret-tool", "lookup", "service", "deploy", "account", "admin",
+	}
+	calls := mock.Calls(m, "secret-tool")
+	if len(calls) != 1 ||
+		strings.Join(calls[0].Args, " ") != strings.Join(want, " ") {
+		t.Errorf("calls = %+v, want one with args %q", calls, want)
+	}
+}
+
+func TestKeychainMissing(t *testing.T) {
+	m := new(mock.Machine)
+	m.SetOS("darwin")
+	m.Return(command.Fail(&command.Error{Code: 44}), "security")
+
+	_, err := credentials.Keychain(m, "deploy").Get(t.Context(), "admin")
+	if !errors.Is(err, credentials.ErrNotFound) {
+		t.Errorf("Get error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestChain(t *testing.T) {
+	first, second := new(credentials.Memory), new(credentials.Memory)
+	first.Set("a", "1")
+	second.Set("a", "shadowed")
+	second.Set("b", "2")
+	s := credentials.Chain(first, second)
+
+	for name, want := range map[string]string{"a": "1", "b": "2"} {
+		got, err := s.Get(t.Context(), name)
+		if err != nil {
+			t.Fatalf("Get(%q) error: %v", name, err)
+		}
+		if got != want {
+			t.Errorf("Get(%q) = %q, want %q", name, got, want)
+		}
+	}
+	_, err := s.Get(t.Context(), "c")
+	if !errors.Is(err, credentials.ErrNotFound) {
+		t.Errorf("Get(c) error = %v, want ErrNotFound", err)
+	}
+}
@@ -2,20 +2,50 @@ package ctr
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
+	"strconv"
+	"time"
 
 	"golang.org/x/term"
 
 	"lesiw.io/command"
+	"lesiw.io/command/internal/sh"
 	"lesiw.io/fs"
-	"lesiw.io/fs/path"
 )
 
+type noStdinKey struct{}
+
+// WithoutStdin returns a context for commands that run in a container
+// with no standard input, so they see EOF as soon as they read it.
+//
+// By default, commands keep standard input open, so data written to
+// their Buffers streams into the container, as in a Copy pipeline:
+//
+//	_, err := command.Copy(
+//	    command.NewWriter(ctx, m, "psql", "-d", "app"),
+//	    command.NewReader(ctx, sys.Machine(), "cat", "dump.sql"),
+//	)
+//
+// Commands that read standard input when it is available, but should not
+// wait for it, need WithoutStdin. Writing to their Buffers fails with
+// command.ErrReadOnly.
+func WithoutStdin(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noStdinKey{}, true)
+}
+
+func stdin(ctx context.Context) bool {
+	noStdin, _ := ctx.Value(noStdinKey{}).(bool)
+	return !noStdin
+}
+
 type cmd struct {
 	command.Buffer
-	m   *machine
-	ctx context.Context
-	arg []string
+	m    *machine
+	ctx  context.Context
+	arg  []string
+	diag bool // Whether a failure has been diagnosed.
 }
 
 func newCmd(m *machine, ctx context.Context, args ...string) command.Buffer {
@@ -40,7 +70,19 @@ func (c *cmd) Close() error {
 	return nil
 }
 
+func (c *cmd) Read(p []byte) (int, error) {
+	n, err := c.Buffer.Read(p)
+	if err != nil && err != io.EOF && !c.diag {
+		c.diag = true
+		err = c.m.diagnose(c.ctx, err)
+	}
+	return n, err
+}
+
 func (c *cmd) Write(p []byte) (int, error) {
+	if !stdin(c.ctx) {
+		return 0, command.ErrReadOnly
+	}
 	if wb, ok := c.Buffer.(command.WriteBuffer); ok {
 		return wb.Write(p)
 	}
@@ -50,8 +92,29 @@ func (c *cmd) Write(p []byte) (int, error) {
 func (c *cmd) Log(w io.Writer) { command.Log(c.Buffer, w) }
 func (c *cmd) String() string  { return command.String(c.Buffer) }
 
+func (c *cmd) Result() (command.Result, bool) {
+	return command.ResultOf(c.Buffer)
+}
+
+// SpliceFrom splices src into the input of the command, unless it was
+// run without one; see [WithoutStdin].
+func (c *cmd) SpliceFrom(src io.Reader) bool {
+	return stdin(c.ctx) && command.SpliceFrom(c.Buffer, src)
+}
+
+func (c *cmd) SpliceTo(dst io.Writer) bool {
+	return command.SpliceTo(c.Buffer, dst)
+}
+
 func (c *cmd) setCmd(attach bool) {
 	cmdArgs := []string{"container", "exec"}
+	if c.m.ephemeral {
+		cmdArgs = []string{
+			"container", "run", "--rm", "--volumes-from", c.m.name,
+		}
+		cmdArgs = append(cmdArgs, c.m.runArgs()...)
+		cmdArgs = append(cmdArgs, c.m.args...)
+	}
 	if attach {
 		if term.IsTerminal(0) {
 			cmdArgs = append(cmdArgs, "-i")
@@ -59,19 +122,46 @@ func (c *cmd) setCmd(attach bool) {
 				cmdArgs = append(cmdArgs, "-t")
 			}
 		}
-	} else {
+	} else if stdin(c.ctx) {
 		// Unattached commands should not probe stdin/stdout.
 		cmdArgs = append(cmdArgs, "-i")
 	}
 	ctx := c.ctx
-	if dir := fs.WorkDir(ctx); dir != "" && path.IsAbs(dir) {
+	if dir, ok := c.m.workDir(ctx, fs.WorkDir(ctx)); ok {
 		cmdArgs = append(cmdArgs, "-w", dir)
-		ctx = fs.WithWorkDir(ctx, "")
+		ctx = fs.WithoutWorkDir(ctx)
 	}
 	for k, v := range command.Envs(ctx) {
 		cmdArgs = append(cmdArgs, "-e", k+"="+v)
 	}
-	cmdArgs = append(cmdArgs, c.m.name)
-	cmdArgs = append(cmdArgs, c.arg...)
+	argv := c.arg
+	if d := c.m.timeout; d > 0 {
+		secs := int64((d + time.Second - 1) / time.Second)
+		argv = append([]string{
+			"timeout", "-s", "KILL", strconv.FormatInt(secs, 10),
+		}, argv...)
+	}
+	stdout, stderr := command.StdoutFile(ctx), command.StderrFile(ctx)
+	if stdout != "" || stderr != "" {
+		if c.m.os == "windows" {
+			c.Buffer = command.Fail(fmt.Errorf(
+				"redirecting output in Windows containers: %w",
+				errors.ErrUnsupported,
+			))
+			return
+		}
+		redirect := sh.Redirect(stdout, stderr, command.MergedStderr(ctx))
+		argv = append([]string{
+			"sh", "-c", `exec "$@"` + redirect, "sh",
+		}, argv...)
+		ctx = command.WithoutCommandOptions(ctx)
+	}
+	if c.m.ephemeral && len(argv) > 0 {
+		cmdArgs = append(cmdArgs, "--entrypoint", argv[0], c.m.image)
+		argv = argv[1:]
+	} else {
+		cmdArgs = append(cmdArgs, c.m.name)
+	}
+	cmdArgs = append(cmdArgs, argv...)
 	c.Buffer = c.m.Machine.Command(command.WithoutEnv(ctx), cmdArgs...)
 }
@@ -124,3 +124,19 @@ func TestCmdClose(t *testing.T) {
 		t.Errorf("buf.Close() err: %v", err)
 	}
 }
+
+func TestCmdStream(t *testing.T) {
+	m, ctx := alpine(t), t.Context()
+
+	var out strings.Builder
+	_, err := command.Copy(&out,
+		strings.NewReader("hello\n"),
+		command.NewFilter(ctx, m, "tr", "a-z", "A-Z"),
+	)
+	if err != nil {
+		t.Fatalf("command.Copy err: %v", err)
+	}
+	if got, want := out.String(), "HELLO\n"; got != want {
+		t.Errorf("command.Copy output = %q, want %q", got, want)
+	}
+}
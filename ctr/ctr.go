@@ -7,15 +7,18 @@ import (
 	"crypto/sha1"
 	"errors"
 	"fmt"
-	"io/fs"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"lesiw.io/command"
+	"lesiw.io/command/config"
 	"lesiw.io/command/sub"
+	"lesiw.io/fs"
 	"lesiw.io/zeros"
 )
 
@@ -28,27 +31,45 @@ var (
 	}
 
 	errShutdown = errors.New("machine shut down")
+
+	stderr io.Writer = os.Stderr // Untraced image build output.
 )
 
 type ctlMachine struct {
 	host command.Machine
+	cli  string // The detected CLI, post-init.
 	once zeros.OnceValues[command.Machine, error]
 }
 
 // Ctl returns a Machine for the controller CLI (docker, podman, etc.)
-// found on the given Machine.
+// found on the given Machine. CLIs preferred by the user's configuration,
+// as loaded by package [config], are looked for first.
 //
 //	ctl := ctr.Ctl(sys.Machine())
 //	ctl.Command(ctx, "run", "-ti", "alpine", "sh")
 func Ctl(m command.Machine) command.Machine { return &ctlMachine{host: m} }
 
 func (m *ctlMachine) init(ctx context.Context) (command.Machine, error) {
-	return m.once.Do(func() (command.Machine, error) { return m.doInit(ctx) })
+	return m.once.Do(func() (command.Machine, error) {
+		return m.doInit(ctlContext(ctx))
+	})
+}
+
+// ctlContext returns a context for the commands that the controller runs
+// for itself, without the settings that were meant for the caller's
+// commands, so that they do not change the output it parses.
+func ctlContext(ctx context.Context) context.Context {
+	ctx = command.WithoutEnv(fs.WithoutWorkDir(ctx))
+	return command.WithoutCommandOptions(ctx)
 }
 
 func (m *ctlMachine) doInit(ctx context.Context) (command.Machine, error) {
+	list, err := candidates(ctx)
+	if err != nil {
+		return nil, err
+	}
 	var ctrcli []string
-	for _, cli := range clis {
+	for _, cli := range list {
 		args := append([]string{}, cli...)
 		args = append(args, "--version")
 		if !command.NotFound(command.Do(ctx, m.host, args...)) {
@@ -59,9 +80,33 @@ func (m *ctlMachine) doInit(ctx context.Context) (command.Machine, error) {
 	if len(ctrcli) == 0 {
 		return nil, fmt.Errorf("no container CLI found: %v", clis)
 	}
+	m.cli = strings.Join(ctrcli, " ")
 	return sub.Machine(m.host, ctrcli...), nil
 }
 
+// candidates returns the container CLIs to look for, in order: those
+// preferred by the user's configuration, and then the others.
+func candidates(ctx context.Context) ([][]string, error) {
+	c, err := config.From(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var list [][]string
+	for _, cli := range c.ContainerCLI {
+		if args := strings.Fields(cli); len(args) > 0 {
+			list = append(list, args)
+		}
+	}
+	for _, cli := range clis {
+		if !slices.ContainsFunc(list, func(c []string) bool {
+			return slices.Equal(c, cli)
+		}) {
+			list = append(list, cli)
+		}
+	}
+	return list, nil
+}
+
 func (m *ctlMachine) Command(
 	ctx context.Context, arg ...string,
 ) command.Buffer {
@@ -75,10 +120,27 @@ func (m *ctlMachine) Command(
 // Machine instantiates a command.Machine that runs commands in a container.
 //
 // If name begins with / or ., it is treated as a path to a Containerfile
-// and will be built. Otherwise it is treated as an image name.
+// and will be built, with its directory as the build context, streaming
+// its output to standard error (see [BuildOutput]). A relative
+// path is relative to the working directory of the context that starts
+// the container (see fs.WithWorkDir), or else the current directory.
+// Otherwise, name is treated as an image name.
+//
+// The working directory of each command's context becomes its working
+// directory in the container. Host paths under directories mounted with
+// [MountModule], -v, or --mount are translated to their paths in the
+// container, and relative paths are relative to the container's working
+// directory.
 //
 // Additional args are passed to the container run command.
 //
+// Windows containers are supported. In them, slash-rooted working
+// directories are on the C: drive, and options that depend on Unix
+// tools, like Secret and ExecTimeout, are unavailable.
+//
+// The container is removed on Shutdown. It is kept if it exits before
+// then, so that commands failing because it died can report why.
+//
 // The container does not start until the first command is executed,
 // so it is safe to declare a package variable as a ctr.Machine()
 // without incurring side effects at package initialization time.
@@ -88,17 +150,40 @@ func (m *ctlMachine) Command(
 func Machine(
 	m command.Machine, name string, args ...string,
 ) command.Machine {
-	return &machine{host: m, name: name, args: args}
+	return New(m, name, Args(args...))
 }
 
 type machine struct {
 	sync.RWMutex
 	command.Machine
-	host command.Machine
-	name string // ID, path, or image pre-init; ID post-init.
-	args []string
-	once zeros.OnceValue[error]
-	done bool
+	host    command.Machine
+	name    string // ID, path, or image pre-init; ID post-init.
+	args    []string
+	secrets []secret
+	health  time.Duration
+	build   io.Writer // Image build output; nil for the default.
+	timeout time.Duration
+
+	ephemeral  bool
+	digest     string   // The digest to pin the image to.
+	pins       *pins    // Digests pinned by PinDigests.
+	image      string   // The image of ephemeral containers.
+	entrypoint []string // The keepalive command.
+	init1      string   // The init process.
+
+	net    network
+	module string                  // The host path of the module to mount.
+	wd     zeros.OnceValue[string] // The container's working directory.
+	cli    string                  // The container CLI, post-init.
+	os     string                  // The OS of the container, post-init.
+	err    error                   // From options.
+	once   zeros.OnceValue[error]
+	done   bool
+
+	sidecars []*sidecar
+	network  string // The network shared with sidecars, post-init.
+
+	logins []login
 }
 
 func (m *machine) init(ctx context.Context) error {
@@ -113,7 +198,17 @@ func (m *machine) init(ctx context.Context) error {
 }
 
 func (m *machine) doInit(ctx context.Context) error {
-	m.Machine = Ctl(m.host)
+	if m.err != nil {
+		return m.err
+	}
+	wd := fs.WorkDir(ctx)
+	ctx = ctlContext(ctx)
+	ctl := &ctlMachine{host: m.host}
+	m.Machine = ctl
+	m.os = daemonOS(ctx, ctl)
+	if err := m.checkOS(); err != nil {
+		return err
+	}
 
 	// If name is an existing container ID, use that.
 	if len(m.name) > 0 && m.name[0] != '/' && m.name[0] != '.' {
@@ -127,27 +222,93 @@ func (m *machine) doInit(ctx context.Context) error {
 		}
 	}
 
+	if err := m.login(ctx); err != nil {
+		return err
+	}
+
 	// If name is a path, build the Containerfile at that path.
 	if len(m.name) > 0 && (m.name[0] == '/' || m.name[0] == '.') {
 		var err error
-		if m.name, err = buildContainer(ctx, m.Machine, m.name); err != nil {
+		m.name, err = buildContainer(ctx, m.Machine, wd, m.name, m.build)
+		if err != nil {
 			return fmt.Errorf("failed to build container: %w", err)
 		}
+	} else {
+		var err error
+		if m.name, err = m.pinImage(ctx, m.name); err != nil {
+			return err
+		}
 	}
 
 	// Otherwise, name is an image. Start that image.
-	cmd := []string{"container", "run", "--rm", "-d", "-i"}
-	cmd = append(cmd, m.args...)
-	cmd = append(cmd, m.name, "cat")
-	out, err := command.Read(ctx, m.Machine, cmd...)
+	if _, err := ctl.init(ctx); err != nil {
+		return err
+	}
+	m.cli = ctl.cli
+	if err := m.net.check(ctl.cli); err != nil {
+		return err
+	}
+	if err := m.startSidecars(ctx); err != nil {
+		return err
+	}
+	run := m.run
+	if m.ephemeral {
+		run = m.create
+	}
+	id, err := run(ctx, m.name)
 	if err != nil {
-		return fmt.Errorf("failed to start container: %w", err)
+		return errors.Join(err, m.stopSidecars(ctx))
 	}
 
-	m.name = strings.TrimSpace(string(out))
+	m.name = id
 	return nil
 }
 
+// runArgs returns the container run args set by options, which apply to
+// every container run for m.
+func (m *machine) runArgs() []string {
+	var args []string
+	if m.network != "" {
+		args = append(args, "--network", m.network)
+	}
+	args = append(args, m.net.args()...)
+	return append(args, m.moduleArgs(m.cli)...)
+}
+
+// run starts a container from image and returns its ID.
+func (m *machine) run(ctx context.Context, image string) (string, error) {
+	cmd := []string{"container", "run", "-d", "-i"}
+	if len(m.secrets) > 0 {
+		cmd = append(cmd, "--tmpfs", secretDir)
+	}
+	cmd = append(cmd, m.runArgs()...)
+	keepalive := m.keepalive()
+	switch {
+	case m.init1 != "":
+		cmd = append(cmd, "--entrypoint", m.init1)
+	case len(m.entrypoint) > 0:
+		cmd = append(cmd, "--entrypoint", keepalive[0])
+		keepalive = keepalive[1:]
+	}
+	cmd = append(cmd, m.args...)
+	cmd = append(cmd, image)
+	cmd = append(cmd, keepalive...)
+	out, err := command.Read(ctx, m.Machine, cmd...)
+	if err != nil {
+		return "", fmt.Errorf("failed to start container: %w", err)
+	}
+	id := strings.TrimSpace(out)
+	err = m.writeSecrets(ctx, id)
+	if err == nil && m.health > 0 {
+		err = m.waitHealthy(ctx, id)
+	}
+	if err != nil {
+		_ = command.Do(ctx, m.Machine, "container", "rm", "-f", id)
+		return "", err
+	}
+	return id, nil
+}
+
 func (m *machine) Command(ctx context.Context, arg ...string) command.Buffer {
 	if err := m.init(ctx); err != nil {
 		return command.Fail(err)
@@ -176,23 +337,35 @@ func (m *machine) Shutdown(ctx context.Context) error {
 	}
 
 	// Derive context with timeout to prevent blocking indefinitely.
-	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	ctx, cancel := context.WithTimeout(ctlContext(ctx), 30*time.Second)
 	defer cancel()
 
-	return command.Do(ctx, m.Machine, "container", "rm", "-f", m.name)
+	return errors.Join(
+		command.Do(ctx, m.Machine, "container", "rm", "-f", m.name),
+		m.stopSidecars(ctx),
+	)
 }
 
 func buildContainer(
-	ctx context.Context, m command.Machine, rpath string,
+	ctx context.Context, m command.Machine, wd, rpath string, w io.Writer,
 ) (image string, err error) {
-	var path string
-	if path, err = filepath.Abs(rpath); err != nil {
+	path := rpath
+	if wd != "" && !filepath.IsAbs(path) {
+		path = filepath.Join(wd, path)
+	}
+	if path, err = filepath.Abs(path); err != nil {
 		err = fmt.Errorf("bad Containerfile path %q: %w", rpath, err)
 		return
 	}
 	imagehash := sha1.New()
 	imagehash.Write([]byte(path))
 	image = fmt.Sprintf("%x", imagehash.Sum(nil))
+	unlock, err := lockBuild(ctx, image)
+	if err != nil {
+		err = fmt.Errorf("failed to lock build of %q: %w", path, err)
+		return
+	}
+	defer unlock()
 	out, insperr := command.Read(ctx, m,
 		"image", "inspect",
 		"--format", "{{.Created}}",
@@ -217,25 +390,35 @@ func buildContainer(
 			return // Container is newer than Containerfile.
 		}
 	}
-	err = command.Exec(ctx, m,
-		"image", "build",
-		"--file", path,
-		"--no-cache",
-		"--tag", image,
-		filepath.Dir(path),
-	)
+	if w == nil {
+		w = command.TraceWriter(ctx)
+	}
+	if w == nil {
+		w = stderr
+	}
+	tail := new(tailWriter)
+	log := io.MultiWriter(w, tail)
+	err = command.Run(ctx, m, command.Spec{
+		Args: []string{
+			"image", "build",
+			"--file", path,
+			"--no-cache",
+			"--tag", image,
+			filepath.Dir(path),
+		},
+		Stdout: log,
+		Stderr: log,
+	})
 	if err != nil {
-		err = fmt.Errorf("failed to build %q: %w", path, err)
+		err = fmt.Errorf("failed to build %q: %w%s", path, err, tail)
 	}
 	return
 }
 
 func getMtime(path string) (mtime int64, err error) {
-	var info fs.FileInfo
-	info, err = os.Lstat(path)
+	info, err := os.Lstat(path)
 	if err != nil {
-		return
+		return 0, err
 	}
-	mtime = info.ModTime().Unix()
-	return
+	return info.ModTime().Unix(), nil
 }
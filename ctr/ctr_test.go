@@ -1,18 +1,31 @@
 package ctr
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 
 	"lesiw.io/command"
+	"lesiw.io/command/config"
+	"lesiw.io/command/credentials"
+	"lesiw.io/command/internal/configtest"
 	"lesiw.io/command/mock"
+	"lesiw.io/fs"
 )
 
+// TestMain keeps the tests from depending on the configuration file of
+// the user who runs them.
+func TestMain(m *testing.M) { configtest.Main(m, "") }
+
 func callSuffix(calls []mock.Call, suffix []string) bool {
 	for _, call := range calls {
 		args := call.Args
@@ -78,6 +91,31 @@ func TestCtlFindsPodman(t *testing.T) {
 	}
 }
 
+func TestCtlConfigPreference(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(command.Fail(&command.Error{
+		Err: fmt.Errorf("command not found: nerdctl"),
+	}), "lima", "nerdctl", "--version")
+	ctl := Ctl(m)
+	ctx := config.With(t.Context(), &config.Config{
+		ContainerCLI: []string{"lima nerdctl", "podman"},
+	})
+
+	err := command.Do(ctx, ctl, "container", "run", "alpine")
+	if err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+
+	mockCalls := []mock.Call{
+		{Args: []string{"lima", "nerdctl", "--version"}},
+		{Args: []string{"podman", "--version"}},
+		{Args: []string{"podman", "container", "run", "alpine"}},
+	}
+	if got, want := mock.Calls(m), mockCalls; !cmp.Equal(got, want) {
+		t.Errorf("mock calls (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
 func TestCtlNoContainerCLI(t *testing.T) {
 	m := new(mock.Machine)
 	for _, cli := range clis {
@@ -162,3 +200,1044 @@ func TestMachineCommandAfterShutdown(t *testing.T) {
 		t.Errorf("command.Do after Shutdown: got %v, want errShutdown", err)
 	}
 }
+
+func TestMachineSnapshot(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("abc123"), "docker", "container", "run")
+	m.Return(strings.NewReader("def456"), "docker", "container", "run")
+	m.Return(strings.NewReader("sha256:img"), "docker", "container", "commit")
+	ctr := Machine(m, "alpine")
+
+	snap, err := command.Snapshot(t.Context(), ctr)
+	if err != nil {
+		t.Fatalf("command.Snapshot error: %v", err)
+	}
+	if err := snap.Restore(t.Context()); err != nil {
+		t.Fatalf("Restore error: %v", err)
+	}
+	if err := command.Do(t.Context(), ctr, "true"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+	if err := snap.Discard(t.Context()); err != nil {
+		t.Fatalf("Discard error: %v", err)
+	}
+
+	calls := mock.Calls(m)
+	for _, want := range [][]string{
+		{"container", "commit", "abc123"},
+		{"container", "run", "-d", "-i", "sha256:img", "cat"},
+		{"container", "rm", "-f", "abc123"},
+		{"container", "exec", "-i", "def456", "true"},
+		{"image", "rm", "sha256:img"},
+	} {
+		if !callSuffix(calls, want) {
+			t.Errorf("calls:\n%+v\nwant call ending with: %v", calls, want)
+		}
+	}
+}
+
+func TestSnapshotSaves(t *testing.T) {
+	m := New(new(mock.Machine), "alpine", Args(
+		"-v", "/host/src:/src:ro",
+		"--volume=cache:/var/cache/app",
+		"--mount", "type=volume,source=db,target=/var/lib/db",
+		"--tmpfs", "/scratch:size=64m",
+	)).(*machine)
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"/etc/app.conf", true},
+		{"/srcfile", true},
+		{"/src", false},
+		{"/src/main.go", false},
+		{"/var/cache/app/index", false},
+		{"/var/lib/db/", false},
+		{"/var/lib", false}, // Holds a mount.
+		{"/scratch/tmp", false},
+		{"relative/path", false},
+	}
+	for _, tt := range tests {
+		if got := m.SnapshotSaves(tt.name); got != tt.want {
+			t.Errorf("SnapshotSaves(%q) = %v, want %v",
+				tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMachineStreamsStdin(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("abc123"), "docker", "container", "run")
+	ctr := Machine(m, "alpine")
+
+	_, err := command.Copy(
+		command.NewWriter(t.Context(), ctr, "psql", "-d", "app"),
+		strings.NewReader("CREATE TABLE t ();\n"),
+	)
+	if err != nil {
+		t.Fatalf("command.Copy error: %v", err)
+	}
+
+	want := []mock.Call{{
+		Args: []string{
+			"docker", "container", "exec", "-i", "abc123", "psql", "-d", "app",
+		},
+		Got: []byte("CREATE TABLE t ();\n"),
+	}}
+	got := mock.Calls(m, "docker", "container", "exec")
+	if !cmp.Equal(got, want) {
+		t.Errorf("exec calls (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestMachineWithoutStdin(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("abc123"), "docker", "container", "run")
+	ctr := Machine(m, "alpine")
+	ctx := WithoutStdin(t.Context())
+
+	if err := command.Do(ctx, ctr, "true"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+	err := command.Run(ctx, ctr, command.Spec{
+		Args:  []string{"cat"},
+		Stdin: strings.NewReader("data"),
+	})
+	if !errors.Is(err, command.ErrReadOnly) {
+		t.Errorf("command.Run error: got %v, want ErrReadOnly", err)
+	}
+
+	want := []string{"container", "exec", "abc123", "true"}
+	if calls := mock.Calls(m); !callSuffix(calls, want) {
+		t.Errorf("calls:\n%+v\nwant call ending with: %v", calls, want)
+	}
+}
+
+func TestNewEnvFileAndSecret(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("abc123"), "docker", "container", "run")
+	ctr := New(m, "alpine",
+		EnvFile("test.env"),
+		Secret("db_password", "hunter2"),
+		Args("--network", "none"),
+	)
+
+	if err := command.Do(t.Context(), ctr, "true"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+
+	calls := mock.Calls(m)
+	run := []string{
+		"container", "run", "-d", "-i", "--tmpfs", "/run/secrets",
+		"--env-file", "test.env", "--network", "none", "alpine", "cat",
+	}
+	if !callSuffix(calls, run) {
+		t.Errorf("calls:\n%+v\nwant call ending with: %v", calls, run)
+	}
+	var wrote bool
+	for _, call := range calls {
+		if string(call.Got) == "hunter2" &&
+			slices.Contains(call.Args, "/run/secrets/db_password") {
+			wrote = true
+		}
+		for _, arg := range call.Args {
+			if strings.Contains(arg, "hunter2") {
+				t.Errorf("secret in args: %v", call.Args)
+			}
+		}
+		for _, v := range call.Env {
+			if strings.Contains(v, "hunter2") {
+				t.Errorf("secret in env: %v", call.Env)
+			}
+		}
+	}
+	if !wrote {
+		t.Errorf("secret not written to container: calls:\n%+v", calls)
+	}
+}
+
+func TestNewBadSecretName(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("abc123"), "docker", "container", "run")
+	ctr := New(m, "alpine", Secret("../etc/passwd", "x"))
+
+	if err := command.Do(t.Context(), ctr, "true"); err == nil {
+		t.Fatal("command.Do error: got nil, want bad secret name")
+	}
+	want := []string{"container", "rm", "-f", "abc123"}
+	if calls := mock.Calls(m); !callSuffix(calls, want) {
+		t.Errorf("calls:\n%+v\nwant call ending with: %v", calls, want)
+	}
+}
+
+func TestNewWaitHealthy(t *testing.T) {
+	healthPoll = time.Millisecond
+	t.Cleanup(func() { healthPoll = 500 * time.Millisecond })
+
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("abc123"), "docker", "container", "run")
+	inspect := []string{"docker", "container", "inspect", "--format"}
+	m.Return(strings.NewReader(""), inspect...)
+	m.Return(strings.NewReader("starting\n"), inspect...)
+	m.Return(strings.NewReader("starting\n"), inspect...)
+	m.Return(strings.NewReader("healthy\n"), inspect...)
+	ctr := New(m, "postgres", WaitHealthy(time.Minute))
+
+	if err := command.Do(t.Context(), ctr, "true"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+
+	// The first inspect checks whether postgres names a container.
+	if got, want := len(mock.Calls(m, inspect...)), 4; got != want {
+		t.Errorf("inspect calls: got %d, want %d", got, want)
+	}
+	want := []string{"container", "exec", "-i", "abc123", "true"}
+	if calls := mock.Calls(m); !callSuffix(calls, want) {
+		t.Errorf("calls:\n%+v\nwant call ending with: %v", calls, want)
+	}
+}
+
+func TestNewWaitHealthyUnhealthy(t *testing.T) {
+	healthPoll = time.Millisecond
+	t.Cleanup(func() { healthPoll = 500 * time.Millisecond })
+
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("abc123"), "docker", "container", "run")
+	inspect := []string{"docker", "container", "inspect", "--format"}
+	m.Return(strings.NewReader(""), inspect...)
+	m.Return(strings.NewReader("unhealthy\n"), inspect...)
+	ctr := New(m, "postgres", WaitHealthy(time.Minute))
+
+	err := command.Do(t.Context(), ctr, "true")
+	if err == nil || !strings.Contains(err.Error(), "unhealthy") {
+		t.Errorf("command.Do error: got %v, want unhealthy", err)
+	}
+	want := []string{"container", "rm", "-f", "abc123"}
+	if calls := mock.Calls(m); !callSuffix(calls, want) {
+		t.Errorf("calls:\n%+v\nwant call ending with: %v", calls, want)
+	}
+}
+
+func TestNewNetwork(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("abc123"), "docker", "container", "run")
+	ctr := New(m, "alpine",
+		HostNetwork(),
+		DNS("1.1.1.1", "2606:4700:4700::1111"),
+		AddHost("svc:10.0.0.2"),
+		AddHost("host.docker.internal:host-gateway"),
+	)
+
+	if err := command.Do(t.Context(), ctr, "true"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+
+	want := []string{
+		"container", "run", "-d", "-i",
+		"--network", "host",
+		"--dns", "1.1.1.1", "--dns", "2606:4700:4700::1111",
+		"--add-host", "svc:10.0.0.2",
+		"--add-host", "host.docker.internal:host-gateway",
+		"alpine", "cat",
+	}
+	if calls := mock.Calls(m); !callSuffix(calls, want) {
+		t.Errorf("calls:\n%+v\nwant call ending with: %v", calls, want)
+	}
+}
+
+func TestNewNetworkInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  Option
+	}{
+		{"dns", DNS("example.com")},
+		{"host without ip", AddHost("svc")},
+		{"host without name", AddHost(":10.0.0.2")},
+		{"host with bad ip", AddHost("svc:10.0.0")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := new(mock.Machine)
+			ctr := New(m, "alpine", tt.opt)
+
+			if err := command.Do(t.Context(), ctr, "true"); err == nil {
+				t.Error("command.Do error: got nil, want error")
+			}
+			if calls := mock.Calls(m); len(calls) > 0 {
+				t.Errorf("calls: got %+v, want none", calls)
+			}
+		})
+	}
+}
+
+func TestNewHostNetworkLima(t *testing.T) {
+	m := new(mock.Machine)
+	for _, cli := range clis[:3] {
+		m.Return(command.Fail(&command.Error{
+			Err: fmt.Errorf("command not found: %s", cli),
+		}), cli...)
+	}
+	ctr := New(m, "alpine", HostNetwork())
+
+	err := command.Do(t.Context(), ctr, "true")
+	if err == nil || !strings.Contains(err.Error(), "lima") {
+		t.Errorf("command.Do error: got %v, want lima error", err)
+	}
+	run := []string{"lima", "nerdctl", "container", "run"}
+	if calls := mock.Calls(m, run...); len(calls) > 0 {
+		t.Errorf("run calls: got %+v, want none", calls)
+	}
+}
+
+func TestNewExecTimeout(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("abc123"), "docker", "container", "run")
+	ctr := New(m, "alpine", ExecTimeout(1500*time.Millisecond))
+
+	if err := command.Do(t.Context(), ctr, "go", "test"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+
+	want := []string{
+		"container", "exec", "-i", "abc123",
+		"timeout", "-s", "KILL", "2", "go", "test",
+	}
+	if calls := mock.Calls(m); !callSuffix(calls, want) {
+		t.Errorf("calls:\n%+v\nwant call ending with: %v", calls, want)
+	}
+}
+
+func TestMachineDiagnosesExitedContainer(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("abc123"), "docker", "container", "run")
+	m.Return(command.Fail(&command.Error{
+		Err:  errors.New("exit status 137"),
+		Code: 137,
+	}), "docker", "container", "exec")
+	inspect := []string{"docker", "container", "inspect", "--format"}
+	m.Return(strings.NewReader(""), inspect...)
+	m.Return(strings.NewReader("false 137 true\n"), inspect...)
+	m.Return(strings.NewReader("Killed\n"), "docker", "container", "logs")
+	ctr := Machine(m, "alpine")
+
+	err := command.Do(t.Context(), ctr, "make")
+
+	exit := new(ExitError)
+	if !errors.As(err, &exit) {
+		t.Fatalf("command.Do error: got %v, want ExitError", err)
+	}
+	want := ExitError{
+		ID:        "abc123",
+		ExitCode:  137,
+		OOMKilled: true,
+		Logs:      "Killed",
+	}
+	got := *exit
+	got.Err = nil
+	if !cmp.Equal(got, want) {
+		t.Errorf("ExitError (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	if cerr := new(command.Error); !errors.As(err, &cerr) || cerr.Code != 137 {
+		t.Errorf("command.Do error: got %v, want exit code 137", err)
+	}
+}
+
+func TestMachineFailureWithRunningContainer(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("abc123"), "docker", "container", "run")
+	m.Return(command.Fail(&command.Error{
+		Err:  errors.New("exit status 1"),
+		Code: 1,
+	}), "docker", "container", "exec")
+	inspect := []string{"docker", "container", "inspect", "--format"}
+	m.Return(strings.NewReader(""), inspect...)
+	m.Return(strings.NewReader("true 0 false\n"), inspect...)
+	ctr := Machine(m, "alpine")
+
+	err := command.Do(t.Context(), ctr, "false")
+
+	if err == nil || errors.As(err, new(*ExitError)) {
+		t.Errorf("command.Do error: got %v, want plain failure", err)
+	}
+}
+
+func TestNewSidecar(t *testing.T) {
+	m := new(mock.Machine)
+	run := []string{"docker", "container", "run"}
+	m.Return(strings.NewReader("db1\n"), run...)
+	m.Return(strings.NewReader("abc123\n"), run...)
+	ctr := New(m, "golang",
+		Sidecar("docker.io/library/postgres:17", "-e", "POSTGRES_DB=app"),
+	)
+
+	if err := command.Do(t.Context(), ctr, "go", "test"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+	if err := command.Shutdown(t.Context(), ctr); err != nil {
+		t.Fatalf("command.Shutdown error: %v", err)
+	}
+
+	calls := mock.Calls(m, "docker", "network", "create")
+	if len(calls) != 1 || len(calls[0].Args) != 4 {
+		t.Fatalf("network create calls: %+v", calls)
+	}
+	network := calls[0].Args[3]
+	for _, want := range [][]string{{
+		"container", "run", "-d", "--network", network,
+		"--network-alias", "postgres", "-e", "POSTGRES_DB=app",
+		"docker.io/library/postgres:17",
+	}, {
+		"container", "run", "-d", "-i", "--network", network,
+		"golang", "cat",
+	}, {
+		"container", "rm", "-f", "abc123",
+	}, {
+		"container", "rm", "-f", "db1",
+	}, {
+		"network", "rm", network,
+	}} {
+		if calls := mock.Calls(m); !callSuffix(calls, want) {
+			t.Errorf("calls:\n%+v\nwant call ending with: %v", calls, want)
+		}
+	}
+}
+
+func TestNewSidecarFailure(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(command.Fail(&command.Error{
+		Err:  errors.New("pull access denied"),
+		Code: 125,
+	}), "docker", "container", "run")
+	ctr := New(m, "golang", Sidecar("nosuchimage"))
+
+	if err := command.Do(t.Context(), ctr, "true"); err == nil {
+		t.Fatal("command.Do error: got nil, want error")
+	}
+	if calls := mock.Calls(m, "docker", "network", "rm"); len(calls) != 1 {
+		t.Errorf("network rm calls: got %+v, want 1", calls)
+	}
+}
+
+func TestNewMountModule(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("abc123"), "docker", "container", "run")
+	ctr := New(m, "golang", MountModule())
+
+	if err := command.Do(t.Context(), ctr, "go", "test"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := mock.Calls(m, "docker", "container", "run")
+	if len(calls) != 1 {
+		t.Fatalf("run calls: got %+v, want 1", calls)
+	}
+	args := calls[0].Args
+	i := slices.Index(args, "-v")
+	if i < 0 || i+3 >= len(args) {
+		t.Fatalf("run args: got %v, want -v", args)
+	}
+	vol := strings.TrimSuffix(args[i+1], ":z")
+	if want := filepath.Dir(wd) + ":" + ModuleDir; vol != want {
+		t.Errorf("volume: got %q, want %q", vol, want)
+	}
+	want := []string{"-w", ModuleDir}
+	if got := args[i+2 : i+4]; !slices.Equal(got, want) {
+		t.Errorf("run args: got %v, want %v after volume", got, want)
+	}
+}
+
+func TestNewBuildOutput(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "Containerfile")
+	if err := os.WriteFile(file, []byte("FROM alpine\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m := new(mock.Machine)
+	m.Return(command.Fail(&command.Error{
+		Err: errors.New("no such image"),
+	}), "docker", "image", "inspect")
+	m.Return(io.MultiReader(
+		strings.NewReader("STEP 1/2: FROM alpine\nSTEP 2/2: RUN false\n"),
+		command.Fail(&command.Error{Err: errors.New("exit status 1")}),
+	), "docker", "image", "build")
+	var log strings.Builder
+	ctr := New(m, file, BuildOutput(&log))
+
+	err := command.Do(t.Context(), ctr, "true")
+
+	want := "STEP 1/2: FROM alpine\nSTEP 2/2: RUN false\n"
+	if got := log.String(); got != want {
+		t.Errorf("build output: got %q, want %q", got, want)
+	}
+	if err == nil || !strings.Contains(err.Error(), "\tSTEP 2/2: RUN false") {
+		t.Errorf("command.Do error: got %v, want build log tail", err)
+	}
+}
+
+func TestNewBuildOutputTrace(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "Containerfile")
+	if err := os.WriteFile(file, []byte("FROM alpine\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m := new(mock.Machine)
+	m.Return(command.Fail(&command.Error{
+		Err: errors.New("no such image"),
+	}), "docker", "image", "inspect")
+	m.Return(strings.NewReader("STEP 1/1: FROM alpine\n"),
+		"docker", "image", "build")
+	t.Setenv("CMDTRACE", "")
+	var global, local, errout strings.Builder
+	old, olderr := command.Trace, stderr
+	command.Trace, stderr = &global, &errout
+	t.Cleanup(func() { command.Trace, stderr = old, olderr })
+
+	if err := command.Do(t.Context(), New(m, file), "true"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+	ctx := command.WithTrace(t.Context(), &local)
+	if err := command.Do(ctx, New(m, file), "true"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+
+	want := "STEP 1/1: FROM alpine\n"
+	if got := global.String(); got != "" {
+		t.Errorf("untraced build trace: got %q, want none", got)
+	}
+	if got := errout.String(); got != want {
+		t.Errorf("untraced build output: got %q, want %q", got, want)
+	}
+	if got := local.String(); !strings.Contains(got, want) {
+		t.Errorf("traced build output: got %q, want %q", got, want)
+	}
+}
+
+func TestTailWriter(t *testing.T) {
+	w := new(tailWriter)
+	for i := range logTail + 5 {
+		_, _ = fmt.Fprintf(w, "line %d\r\n", i)
+	}
+	_, _ = fmt.Fprint(w, "partial")
+
+	lines := strings.Split(w.String(), "\n\t")
+	if got, want := len(lines), logTail+1; got != want {
+		t.Fatalf("lines: got %d, want %d", got, want)
+	}
+	if got, want := lines[1], "line 6"; got != want {
+		t.Errorf("first line: got %q, want %q", got, want)
+	}
+	if got, want := lines[logTail], "partial"; got != want {
+		t.Errorf("last line: got %q, want %q", got, want)
+	}
+}
+
+func TestLockBuild(t *testing.T) {
+	image := fmt.Sprintf("test%d", time.Now().UnixNano())
+	unlock, err := lockBuild(t.Context(), image)
+	if err != nil {
+		t.Fatalf("lockBuild error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 3*lockPoll)
+	defer cancel()
+	_, err = lockBuild(ctx, image)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("second lockBuild error: got %v, want deadline", err)
+	}
+
+	unlock()
+	unlock, err = lockBuild(t.Context(), image)
+	if err != nil {
+		t.Fatalf("lockBuild after unlock error: %v", err)
+	}
+	unlock()
+}
+
+func TestExisting(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(
+		strings.NewReader("abc123def456\n"),
+		"docker", "container", "inspect",
+	)
+	ctr := Existing(m, "app-db-1")
+
+	if err := command.Do(t.Context(), ctr, "true"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+	if err := command.Shutdown(t.Context(), ctr); err != nil {
+		t.Fatalf("command.Shutdown error: %v", err)
+	}
+
+	want := []string{"container", "exec", "-i", "abc123def456", "true"}
+	if calls := mock.Calls(m); !callSuffix(calls, want) {
+		t.Errorf("calls:\n%+v\nwant call ending with: %v", calls, want)
+	}
+	for _, cmd := range []string{"run", "rm"} {
+		calls := mock.Calls(m, "docker", "container", cmd)
+		if len(calls) > 0 {
+			t.Errorf("%s calls: got %+v, want none", cmd, calls)
+		}
+	}
+	if _, ok := ctr.(command.SnapshotMachine); ok {
+		t.Error("Existing is a SnapshotMachine, want not")
+	}
+}
+
+func TestExistingNotFound(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(command.Fail(&command.Error{
+		Err:  errors.New("no such container"),
+		Code: 1,
+	}), "docker", "container", "inspect")
+	ctr := Existing(m, "missing")
+
+	err := command.Do(t.Context(), ctr, "true")
+	if err == nil || !strings.Contains(err.Error(), `"missing"`) {
+		t.Errorf("command.Do error: got %v, want no container", err)
+	}
+	if calls := mock.Calls(m, "docker", "container", "run"); len(calls) > 0 {
+		t.Errorf("run calls: got %+v, want none", calls)
+	}
+}
+
+func TestMachineWindows(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("windows\n"), "docker", "info")
+	m.Return(strings.NewReader("abc123"), "docker", "container", "run")
+	ctr := Machine(m, "mcr.microsoft.com/windows/nanoserver:ltsc2022")
+	ctx := fs.WithWorkDir(t.Context(), "/src/app")
+
+	if err := command.Do(ctx, ctr, "dir"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+
+	for _, want := range [][]string{{
+		"container", "run", "-d", "-i",
+		"mcr.microsoft.com/windows/nanoserver:ltsc2022",
+		"cmd", "/c", "ping", "-t", "localhost",
+	}, {
+		"container", "exec", "-i", "-w", `C:\src\app`, "abc123", "dir",
+	}} {
+		if calls := mock.Calls(m); !callSuffix(calls, want) {
+			t.Errorf("calls:\n%+v\nwant call ending with: %v", calls, want)
+		}
+	}
+}
+
+func TestMachineWindowsUnsupported(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("windows\n"), "docker", "info")
+	ctr := New(m, "nanoserver", Secret("token", "x"))
+
+	if err := command.Do(t.Context(), ctr, "dir"); err == nil {
+		t.Error("command.Do error: got nil, want unsupported Secret")
+	}
+	if calls := mock.Calls(m, "docker", "container", "run"); len(calls) > 0 {
+		t.Errorf("run calls: got %+v, want none", calls)
+	}
+}
+
+func TestContainerDir(t *testing.T) {
+	tests := []struct {
+		os, dir, want string
+		abs           bool
+	}{
+		{"linux", "/src", "/src", true},
+		{"linux", "src", "src", false},
+		{"linux", "", "", false},
+		{"windows", "/src/app", `C:\src\app`, true},
+		{"windows", `D:\work`, `D:\work`, true},
+		{"windows", "D:/work", `D:\work`, true},
+		{"windows", `\\host\share`, `\\host\share`, true},
+		{"windows", `src\app`, `src\app`, false},
+		{"windows", "", "", false},
+	}
+	for _, tt := range tests {
+		m := &machine{os: tt.os}
+		got, abs := m.containerDir(tt.dir)
+		if got != tt.want || abs != tt.abs {
+			t.Errorf("containerDir(%q) on %s = %q, %v, want %q, %v",
+				tt.dir, tt.os, got, abs, tt.want, tt.abs)
+		}
+	}
+}
+
+func TestMachineWorkDir(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("abc123"), "docker", "container", "run")
+	m.Return(strings.NewReader("/app\n"), "docker", "container", "inspect",
+		"--format", "{{.Config.WorkingDir}}")
+	ctr := Machine(m, "alpine",
+		"-v", "/home/gopher/src:/work",
+		"--mount", "type=bind,source=/data,target=/mnt/data",
+	)
+	tests := []struct{ dir, want string }{
+		{"/home/gopher/src", "/work"},
+		{"/home/gopher/src/cmd/app", "/work/cmd/app"},
+		{"/home/gopher/srcs", "/home/gopher/srcs"},
+		{"/data/x", "/mnt/data/x"},
+		{"build", "/app/build"},
+	}
+	for _, tt := range tests {
+		ctx := fs.WithWorkDir(t.Context(), tt.dir)
+
+		if err := command.Do(ctx, ctr, "ls"); err != nil {
+			t.Fatalf("command.Do error: %v", err)
+		}
+
+		calls := mock.Calls(m, "docker", "container", "exec")
+		call := calls[len(calls)-1]
+		want := []string{"-w", tt.want, "abc123", "ls"}
+		if !callSuffix([]mock.Call{call}, want) {
+			t.Errorf("dir %q: exec = %v, want suffix %v",
+				tt.dir, call.Args, want)
+		}
+		if call.Dir != "" {
+			t.Errorf("dir %q: exec ran on host in %q, want no dir",
+				tt.dir, call.Dir)
+		}
+	}
+}
+
+func TestNewKeepalive(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		want []string
+	}{{
+		name: "default",
+		want: []string{"img", "cat"},
+	}, {
+		name: "entrypoint",
+		opts: []Option{Entrypoint("sleep", "infinity")},
+		want: []string{"--entrypoint", "sleep", "img", "infinity"},
+	}, {
+		name: "pid1",
+		opts: []Option{PID1("tini")},
+		want: []string{"--entrypoint", "tini", "img", "cat"},
+	}, {
+		name: "pid1 with entrypoint",
+		opts: []Option{PID1("tini"), Entrypoint("sleep", "infinity")},
+		want: []string{
+			"--entrypoint", "tini", "img", "sleep", "infinity",
+		},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := new(mock.Machine)
+			m.Return(strings.NewReader("abc123"), "docker", "container", "run")
+			ctr := New(m, "img", tt.opts...)
+
+			if err := command.Do(t.Context(), ctr, "true"); err != nil {
+				t.Fatalf("command.Do error: %v", err)
+			}
+
+			want := append([]string{"run", "-d", "-i"}, tt.want...)
+			if calls := mock.Calls(m); !callSuffix(calls, want) {
+				t.Errorf("calls:\n%+v\nwant call ending with: %v", calls, want)
+			}
+		})
+	}
+}
+
+func TestNewEphemeral(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("vol123\n"), "docker", "container", "create")
+	ctr := New(m, "gcr.io/distroless/static", Ephemeral(), Args("-v", "d:/d"))
+	ctx := command.WithEnv(t.Context(), map[string]string{"A": "1"})
+
+	if err := command.Do(ctx, ctr, "/app", "serve"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+	_, err := command.Snapshot(t.Context(), ctr)
+	if err == nil {
+		t.Error("command.Snapshot error: got nil, want unsupported")
+	}
+	if err := command.Shutdown(t.Context(), ctr); err != nil {
+		t.Fatalf("command.Shutdown error: %v", err)
+	}
+
+	for _, want := range [][]string{{
+		"container", "create", "--entrypoint", "true", "-v", "d:/d",
+		"gcr.io/distroless/static",
+	}, {
+		"container", "run", "--rm", "--volumes-from", "vol123",
+		"-v", "d:/d", "-i", "-e", "A=1",
+		"--entrypoint", "/app", "gcr.io/distroless/static", "serve",
+	}, {
+		"container", "rm", "-f", "vol123",
+	}} {
+		if calls := mock.Calls(m); !callSuffix(calls, want) {
+			t.Errorf("calls:\n%+v\nwant call ending with: %v", calls, want)
+		}
+	}
+	if calls := mock.Calls(m, "docker", "container", "exec"); len(calls) > 0 {
+		t.Errorf("exec calls: got %+v, want none", calls)
+	}
+}
+
+func TestStats(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("abc123"), "docker", "container", "run")
+	m.Return(
+		strings.NewReader("12.50%\t1.5MiB / 2GiB\t1.2kB / 0B\t4MB / 8kB\t3\n"),
+		"docker", "container", "stats",
+	)
+	ctr := Machine(m, "alpine")
+
+	got, err := Stats(t.Context(), command.Shell(ctr))
+	if err != nil {
+		t.Fatalf("Stats error: %v", err)
+	}
+
+	want := Stat{
+		CPU:         12.5,
+		Memory:      3 << 19,
+		MemoryLimit: 2 << 30,
+		NetRx:       1200,
+		BlockRead:   4e6,
+		BlockWrite:  8e3,
+		PIDs:        3,
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("Stats (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	wantCall := []string{"container", "stats", "--no-stream"}
+	calls := mock.Calls(m, "docker", "container", "stats")
+	if len(calls) != 1 || !slices.Equal(calls[0].Args[1:4], wantCall) ||
+		calls[0].Args[len(calls[0].Args)-1] != "abc123" {
+		t.Errorf("stats calls: got %+v, want %v ... abc123", calls, wantCall)
+	}
+}
+
+func TestPause(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("abc123"), "docker", "container", "run")
+	ctr := Machine(m, "alpine")
+
+	if err := Pause(t.Context(), ctr); err != nil {
+		t.Fatalf("Pause error: %v", err)
+	}
+	if err := Unpause(t.Context(), ctr); err != nil {
+		t.Fatalf("Unpause error: %v", err)
+	}
+
+	want := [][]string{
+		{"docker", "container", "pause", "abc123"},
+		{"docker", "container", "unpause", "abc123"},
+	}
+	var got [][]string
+	for _, call := range mock.Calls(m, "docker", "container") {
+		if strings.HasSuffix(call.Args[2], "pause") {
+			got = append(got, call.Args)
+		}
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("pause calls (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestPauseOtherMachine(t *testing.T) {
+	if err := Pause(t.Context(), new(mock.Machine)); err == nil {
+		t.Error("Pause error: got nil, want error")
+	}
+}
+
+const testDigest = "sha256:" +
+	"4bcff63911fcb4448bd4fdacec207030997caf25e9bea4045fa6c8c44de311d1"
+
+func TestNewDigest(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("abc123"), "docker", "container", "run")
+	ctr := New(m, "alpine:3", Digest(testDigest))
+
+	if err := command.Do(t.Context(), ctr, "true"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+
+	want := []string{"alpine:3@" + testDigest, "cat"}
+	if calls := mock.Calls(m); !callSuffix(calls, want) {
+		t.Errorf("calls:\n%+v\nwant call ending with: %v", calls, want)
+	}
+	bad := New(m, "alpine:3", Digest("x"))
+	if err := command.Do(t.Context(), bad, "true"); err == nil {
+		t.Error("command.Do with bad digest: got nil, want error")
+	}
+}
+
+func TestNewPinDigests(t *testing.T) {
+	lockfile := filepath.Join(t.TempDir(), "images.lock")
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("abc123"), "docker", "container", "run")
+	inspect := []string{"docker", "image", "inspect", "--format"}
+	m.Return(
+		strings.NewReader("docker.io/library/alpine@"+testDigest+"\n"),
+		inspect...,
+	)
+
+	for range 2 {
+		ctr := New(m, "alpine:3", PinDigests(lockfile))
+		if err := command.Do(t.Context(), ctr, "true"); err != nil {
+			t.Fatalf("command.Do error: %v", err)
+		}
+	}
+
+	if calls := mock.Calls(m, "docker", "image", "pull"); len(calls) != 1 {
+		t.Errorf("pull calls: got %+v, want 1", calls)
+	}
+	runs := mock.Calls(m, "docker", "container", "run")
+	for _, run := range runs {
+		if !slices.Contains(run.Args, "alpine:3@"+testDigest) {
+			t.Errorf("run args: got %v, want pinned image", run.Args)
+		}
+	}
+	got, err := os.ReadFile(lockfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "alpine:3 " + testDigest + "\n"; string(got) != want {
+		t.Errorf("lockfile: got %q, want %q", got, want)
+	}
+}
+
+func TestNewPinDigestsFromLockfile(t *testing.T) {
+	lockfile := filepath.Join(t.TempDir(), "images.lock")
+	lock := "# Pinned images.\nalpine:3 " + testDigest + "\n"
+	if err := os.WriteFile(lockfile, []byte(lock), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("abc123"), "docker", "container", "run")
+	ctr := New(m, "alpine:3", PinDigests(lockfile))
+
+	if err := command.Do(t.Context(), ctr, "true"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+
+	if calls := mock.Calls(m, "docker", "image"); len(calls) > 0 {
+		t.Errorf("image calls: got %+v, want none", calls)
+	}
+	want := []string{"alpine:3@" + testDigest, "cat"}
+	if calls := mock.Calls(m); !callSuffix(calls, want) {
+		t.Errorf("calls:\n%+v\nwant call ending with: %v", calls, want)
+	}
+}
+
+func TestMachineStdoutFile(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("abc123"), "docker", "container", "run")
+	ctr := Machine(m, "alpine")
+	ctx := command.WithStdoutFile(t.Context(), "/tmp/out.log")
+
+	if _, err := command.CombinedOutput(ctx, ctr, "make"); err != nil {
+		t.Fatalf("command.CombinedOutput error: %v", err)
+	}
+
+	want := []string{
+		"container", "exec", "-i", "abc123",
+		"sh", "-c", `exec "$@" >/tmp/out.log 2>&1`, "sh", "make",
+	}
+	calls := mock.Calls(m)
+	if !callSuffix(calls, want) {
+		t.Errorf("calls:\n%+v\nwant call ending with: %v", calls, want)
+	}
+}
+
+func TestMachineInternalContext(t *testing.T) {
+	m := new(mock.Machine)
+	var run context.Context
+	m.Do(func(ctx context.Context, _ ...string) command.Buffer {
+		run = ctx
+		return strings.NewReader("abc123")
+	}, "docker", "container", "run")
+	ctr := Machine(m, "alpine")
+	ctx := command.WithMergedStderr(t.Context(), true)
+	ctx = command.WithEnv(ctx, map[string]string{"FOO": "bar"})
+	ctx = fs.WithWorkDir(ctx, "/src")
+	ctx = command.WithStderrFile(ctx, "/tmp/err.log")
+
+	if err := command.Do(ctx, ctr, "true"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+
+	if command.MergedStderr(run) {
+		t.Error("container run: stderr merged, want separate")
+	}
+	if env := command.Envs(run); env != nil {
+		t.Errorf("container run: env %v, want none", env)
+	}
+	if dir := fs.WorkDir(run); dir != "" {
+		t.Errorf("container run: dir %q, want none", dir)
+	}
+	if path := command.StderrFile(run); path != "" {
+		t.Errorf("container run: stderr file %q, want none", path)
+	}
+	want := []string{
+		"-w", "/src", "-e", "FOO=bar", "abc123",
+		"sh", "-c", `exec "$@" 2>/tmp/err.log`, "sh", "true",
+	}
+	if calls := mock.Calls(m); !callSuffix(calls, want) {
+		t.Errorf("calls:\n%+v\nwant call ending with: %v", calls, want)
+	}
+}
+
+func TestMachineAllowedExitCodes(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(command.Fail(&command.Error{
+		Err:  errors.New("exit status 1"),
+		Code: 1,
+	}), "docker", "container", "run")
+	ctr := Machine(m, "alpine")
+	ctx := command.WithAllowedExitCodes(t.Context(), 1)
+
+	// The failure to start is itself an exit with code 1, so Do may
+	// allow it, but the container must not be used.
+	_ = command.Do(ctx, ctr, "true")
+
+	if calls := mock.Calls(m, "docker", "container", "exec"); calls != nil {
+		t.Errorf("exec calls: got %+v, want none", calls)
+	}
+}
+
+func TestNewLogin(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("abc123"), "docker", "container", "run")
+	creds := new(credentials.Memory)
+	creds.Set("token", "s3cret")
+	ctr := New(m, "registry.example.com/app",
+		Login("registry.example.com", "ci", creds, "token"),
+	)
+
+	if err := command.Do(t.Context(), ctr, "true"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+
+	login := []string{
+		"docker", "login", "--username", "ci", "--password-stdin",
+		"registry.example.com",
+	}
+	calls := mock.Calls(m, "docker", "login")
+	if len(calls) != 1 || !cmp.Equal(login, calls[0].Args) {
+		t.Fatalf("login calls = %+v, want one with args %q", calls, login)
+	}
+	if got := string(calls[0].Got); got != "s3cret" {
+		t.Errorf("login stdin = %q, want %q", got, "s3cret")
+	}
+}
+
+func TestNewLoginMissing(t *testing.T) {
+	m := new(mock.Machine)
+	ctr := New(m, "alpine",
+		Login("registry.example.com", "ci", new(credentials.Memory), "x"),
+	)
+
+	err := command.Do(t.Context(), ctr, "true")
+	if !errors.Is(err, credentials.ErrNotFound) {
+		t.Errorf("command.Do error = %v, want ErrNotFound", err)
+	}
+	if calls := mock.Calls(m, "docker", "container", "run"); len(calls) > 0 {
+		t.Errorf("container started without login: %+v", calls)
+	}
+}
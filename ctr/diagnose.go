@@ -0,0 +1,133 @@
+package ctr
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"lesiw.io/command"
+)
+
+// logTail is the number of container log lines attached to an ExitError.
+const logTail = 20
+
+// An ExitError reports that a command failed because its container was
+// no longer running.
+type ExitError struct {
+	Err error // The error of the failed command.
+
+	ID        string // The container ID.
+	ExitCode  int    // The exit code of the container's main process.
+	OOMKilled bool   // Whether the container ran out of memory.
+	Logs      string // The last lines of the container's logs.
+}
+
+func (e *ExitError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Err.Error())
+	fmt.Fprintf(&b, "\ncontainer %s exited with code %d", e.ID, e.ExitCode)
+	if e.OOMKilled {
+		b.WriteString(" (out of memory)")
+	}
+	if e.Logs != "" {
+		b.WriteString("\ncontainer logs:\n\t")
+		b.WriteString(strings.ReplaceAll(e.Logs, "\n", "\n\t"))
+	}
+	return b.String()
+}
+
+func (e *ExitError) Unwrap() error { return e.Err }
+
+// diagnose wraps err in an [ExitError] if the container of m has exited.
+// Otherwise, it returns err unchanged.
+func (m *machine) diagnose(ctx context.Context, err error) error {
+	// The command's context may be the reason it failed.
+	ctx, cancel := context.WithTimeout(
+		ctlContext(context.WithoutCancel(ctx)), 10*time.Second,
+	)
+	defer cancel()
+
+	m.RLock()
+	ctl, id := m.Machine, m.name
+	m.RUnlock()
+	if m.ephemeral {
+		return err // Each command has its own container.
+	}
+
+	out, ierr := command.Read(ctx, ctl,
+		"container", "inspect", "--format",
+		"{{.State.Running}} {{.State.ExitCode}} {{.State.OOMKilled}}",
+		id,
+	)
+	if ierr != nil {
+		return err
+	}
+	f := strings.Fields(out)
+	if len(f) != 3 || f[0] != "false" {
+		return err
+	}
+	code, cerr := strconv.Atoi(f[1])
+	if cerr != nil {
+		return err
+	}
+	e := &ExitError{
+		Err:       err,
+		ID:        id,
+		ExitCode:  code,
+		OOMKilled: f[2] == "true",
+	}
+	var logs strings.Builder
+	_ = command.Run(ctx, ctl, command.Spec{
+		Args: []string{
+			"container", "logs", "--tail", strconv.Itoa(logTail), id,
+		},
+		Stdout: &logs,
+		Stderr: &logs,
+	})
+	e.Logs = strings.TrimRight(logs.String(), "\n")
+	return e
+}
+
+// A tailWriter keeps the last [logTail] lines written to it.
+type tailWriter struct {
+	lines []string
+	part  []byte // An unterminated last line.
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			w.part = append(w.part, p...)
+			break
+		}
+		w.part = append(w.part, p[:i]...)
+		w.lines = append(w.lines, strings.TrimRight(string(w.part), "\r"))
+		w.part = w.part[:0]
+		if len(w.lines) > logTail {
+			w.lines = w.lines[1:]
+		}
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// String returns the kept lines, indented, after a newline, or nothing
+// if none were written.
+func (w *tailWriter) String() string {
+	lines := w.lines
+	if len(w.part) > 0 {
+		lines = append(lines[:len(lines):len(lines)], string(w.part))
+	}
+	if len(lines) > logTail {
+		lines = lines[len(lines)-logTail:]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+	return "\n\t" + strings.Join(lines, "\n\t")
+}
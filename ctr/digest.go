@@ -0,0 +1,169 @@
+package ctr
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"lesiw.io/command"
+)
+
+var digestRe = regexp.MustCompile(`^sha256:[0-9a-f]{64}$`)
+
+// Digest pins the image to digest, such as "sha256:4bcff6...", so the
+// container runs exactly that image whatever its tag now points to.
+func Digest(digest string) Option {
+	return func(m *machine) {
+		if !digestRe.MatchString(digest) {
+			m.err = errors.Join(m.err, fmt.Errorf("bad digest %q", digest))
+			return
+		}
+		m.digest = digest
+	}
+}
+
+// PinDigests resolves the image's tag to a digest on first use, and runs
+// that digest from then on, so that every run sees the same image.
+//
+// If lockfile is not empty, digests are recorded there, one image and
+// digest per line, and read from there first. Commit the lockfile to run
+// the same images across machines and time; delete a line to update its
+// image. Otherwise, digests are pinned for the life of the process.
+func PinDigests(lockfile string) Option {
+	return func(m *machine) {
+		m.pins = pinsFor(lockfile)
+	}
+}
+
+// pinned is the set of digests pinned by each lockfile.
+// The empty lockfile is the process's own.
+var pinned = struct {
+	sync.Mutex
+	m map[string]*pins
+}{m: make(map[string]*pins)}
+
+type pins struct {
+	sync.Mutex
+	file    string
+	digests map[string]string // Image to digest.
+	loaded  bool
+}
+
+func pinsFor(file string) *pins {
+	if file != "" {
+		if abs, err := filepath.Abs(file); err == nil {
+			file = abs
+		}
+	}
+	pinned.Lock()
+	defer pinned.Unlock()
+	p, ok := pinned.m[file]
+	if !ok {
+		p = &pins{file: file, digests: make(map[string]string)}
+		pinned.m[file] = p
+	}
+	return p
+}
+
+// pinImage returns the image that m runs for name.
+func (m *machine) pinImage(ctx context.Context, name string) (string, error) {
+	if strings.Contains(name, "@") {
+		return name, nil // Already pinned.
+	}
+	if m.digest != "" {
+		return name + "@" + m.digest, nil
+	}
+	if m.pins == nil {
+		return name, nil
+	}
+	digest, err := m.pins.resolve(ctx, m.Machine, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to pin %s: %w", name, err)
+	}
+	return name + "@" + digest, nil
+}
+
+func (p *pins) resolve(
+	ctx context.Context, ctl command.Machine, image string,
+) (string, error) {
+	p.Lock()
+	defer p.Unlock()
+
+	if !p.loaded {
+		if err := p.load(); err != nil {
+			return "", err
+		}
+		p.loaded = true
+	}
+	if d, ok := p.digests[image]; ok {
+		return d, nil
+	}
+	if err := command.Do(ctx, ctl, "image", "pull", image); err != nil {
+		return "", err
+	}
+	out, err := command.Read(ctx, ctl,
+		"image", "inspect", "--format", "{{index .RepoDigests 0}}", image,
+	)
+	if err != nil {
+		return "", err
+	}
+	_, d, _ := strings.Cut(strings.TrimSpace(out), "@")
+	if !digestRe.MatchString(d) {
+		return "", fmt.Errorf("no digest for %s: %q", image, out)
+	}
+	p.digests[image] = d
+	return d, p.save()
+}
+
+func (p *pins) load() error {
+	if p.file == "" {
+		return nil
+	}
+	f, err := os.Open(p.file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		image, d, ok := strings.Cut(line, " ")
+		if d = strings.TrimSpace(d); !ok || !digestRe.MatchString(d) {
+			return fmt.Errorf("bad line in %s: %q", p.file, line)
+		}
+		p.digests[image] = d
+	}
+	return sc.Err()
+}
+
+func (p *pins) save() error {
+	if p.file == "" {
+		return nil
+	}
+	var b strings.Builder
+	images := make([]string, 0, len(p.digests))
+	for image := range p.digests {
+		images = append(images, image)
+	}
+	slices.Sort(images)
+	for _, image := range images {
+		b.WriteString(image + " " + p.digests[image] + "\n")
+	}
+	tmp := p.file + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.file)
+}
@@ -0,0 +1,57 @@
+package ctr
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"lesiw.io/command"
+)
+
+// Existing instantiates a command.Machine that runs commands in a
+// container it does not own, such as a service started by compose or
+// an operator. name is the container's name or ID.
+//
+// Existing never starts or removes the container, and shutting it down
+// does nothing. It cannot snapshot the whole container either, so
+// command.Snapshot saves individual paths in it instead.
+//
+//	m := ctr.Existing(sys.Machine(), "myapp-db-1")
+func Existing(m command.Machine, name string) command.Machine {
+	return &existing{m: &machine{host: m, name: name}}
+}
+
+type existing struct{ m *machine }
+
+func (e *existing) init(ctx context.Context) error {
+	m := e.m
+	m.Lock()
+	defer m.Unlock()
+
+	return m.once.Do(func() error {
+		ctx := ctlContext(ctx)
+		m.Machine = Ctl(m.host)
+		m.os = daemonOS(ctx, m.Machine)
+		id, err := command.Read(ctx, m.Machine,
+			"container", "inspect",
+			"--format", "{{.ID}}",
+			m.name,
+		)
+		if err != nil {
+			return fmt.Errorf("no container %q: %w", m.name, err)
+		}
+		m.name = strings.TrimSpace(id)
+		return nil
+	})
+}
+
+func (e *existing) Command(
+	ctx context.Context, arg ...string,
+) command.Buffer {
+	if err := e.init(ctx); err != nil {
+		return command.Fail(err)
+	}
+	e.m.RLock()
+	defer e.m.RUnlock()
+	return newCmd(e.m, ctx, arg...)
+}
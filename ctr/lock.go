@@ -0,0 +1,41 @@
+package ctr
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lockPoll is the interval between attempts to take a build lock.
+const lockPoll = 100 * time.Millisecond
+
+// lockBuild takes the lock for building image, which is shared by every
+// process on this host, so that concurrent test binaries building the
+// same Containerfile build it once. It blocks until the lock is taken or
+// ctx is done.
+func lockBuild(ctx context.Context, image string) (unlock func(), err error) {
+	name := filepath.Join(os.TempDir(), "ctr-build-"+image+".lock")
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0o666)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		ok, err := tryLock(f)
+		if err != nil {
+			return nil, errors.Join(err, f.Close())
+		}
+		if ok {
+			return func() {
+				_ = unlockFile(f)
+				_ = f.Close()
+			}, nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil, errors.Join(ctx.Err(), f.Close())
+		case <-time.After(lockPoll):
+		}
+	}
+}
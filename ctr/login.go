@@ -0,0 +1,52 @@
+package ctr
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"lesiw.io/command"
+	"lesiw.io/command/credentials"
+)
+
+// Login logs in to registry as user before the image is pulled or built,
+// with the password or token called name in creds. The password is
+// passed to the container CLI on its standard input, never on its
+// command line.
+//
+//	m := ctr.New(sys.Machine(), "registry.example.com/app:1.2",
+//	    ctr.Login("registry.example.com", "ci", creds, "registry-token"),
+//	)
+func Login(
+	registry, user string, creds credentials.Store, name string,
+) Option {
+	return func(m *machine) {
+		m.logins = append(m.logins, login{registry, user, creds, name})
+	}
+}
+
+type login struct {
+	registry, user string
+	creds          credentials.Store
+	name           string
+}
+
+func (m *machine) login(ctx context.Context) error {
+	for _, l := range m.logins {
+		pw, err := l.creds.Get(ctx, l.name)
+		if err != nil {
+			return fmt.Errorf("failed to log in to %s: %w", l.registry, err)
+		}
+		err = command.Run(ctx, m.Machine, command.Spec{
+			Args: []string{
+				"login", "--username", l.user, "--password-stdin",
+				l.registry,
+			},
+			Stdin: strings.NewReader(pw),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to log in to %s: %w", l.registry, err)
+		}
+	}
+	return nil
+}
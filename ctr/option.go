@@ -0,0 +1,335 @@
+package ctr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"lesiw.io/command"
+)
+
+// An Option configures a container started by [New].
+type Option func(*machine)
+
+// New instantiates a command.Machine that runs commands in a container,
+// as [Machine] does, configured by typed options instead of raw container
+// run arguments.
+//
+//	m := ctr.New(sys.Machine(), "postgres:17",
+//	    ctr.EnvFile("test.env"),
+//	    ctr.Secret("db_password", password),
+//	)
+func New(m command.Machine, name string, opts ...Option) command.Machine {
+	cm := &machine{host: m, name: name}
+	for _, opt := range opts {
+		opt(cm)
+	}
+	return cm
+}
+
+// Args passes args to the container run command, as the args of
+// [Machine] do.
+func Args(args ...string) Option {
+	return func(m *machine) { m.args = append(m.args, args...) }
+}
+
+// EnvFile sets environment variables in the container from the file at
+// path on the host machine, in the KEY=VALUE format of the container
+// CLI's --env-file flag. Every command run in the container sees them.
+func EnvFile(path string) Option {
+	return Args("--env-file", path)
+}
+
+// secretDir is where secrets are mounted, as with docker compose.
+const secretDir = "/run/secrets"
+
+type secret struct{ name, value string }
+
+// Secret makes value available in the container as the file
+// /run/secrets/name, readable only by the container's default user.
+//
+// Secrets live on a tmpfs mount, so they are never written to disk or
+// committed to snapshots. The value is written through standard input,
+// never as an argument or environment variable, so it does not appear in
+// traces, errors, or the container's configuration. Containers with
+// secrets must have sh, cat, and chmod.
+func Secret(name, value string) Option {
+	return func(m *machine) {
+		m.secrets = append(m.secrets, secret{name, value})
+	}
+}
+
+// writeSecrets writes the secrets of m into the container id.
+func (m *machine) writeSecrets(ctx context.Context, id string) error {
+	for _, s := range m.secrets {
+		if s.name == "" || strings.ContainsAny(s.name, "/\x00") {
+			return fmt.Errorf("bad secret name %q", s.name)
+		}
+		err := command.Run(ctx, m.Machine, command.Spec{
+			Args: []string{
+				"container", "exec", "-i", id, "sh", "-c",
+				`umask 077 && cat > "$1" && chmod 0400 "$1"`, "sh",
+				secretDir + "/" + s.name,
+			},
+			Stdin: strings.NewReader(s.value),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write secret %q: %w", s.name, err)
+		}
+	}
+	return nil
+}
+
+// healthPoll is the interval between health checks in [WaitHealthy].
+var healthPoll = 500 * time.Millisecond
+
+// WaitHealthy waits up to timeout for the container to report healthy
+// before running the first command in it, if its image defines a
+// HEALTHCHECK. Containers without a healthcheck start as usual.
+//
+// If the container reports unhealthy, or is not healthy within timeout,
+// it is removed and every command fails.
+func WaitHealthy(timeout time.Duration) Option {
+	return func(m *machine) { m.health = timeout }
+}
+
+// waitHealthy polls the container id until it is healthy.
+func (m *machine) waitHealthy(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, m.health)
+	defer cancel()
+	for {
+		out, err := command.Read(ctx, m.Machine,
+			"container", "inspect", "--format",
+			"{{if .State.Health}}{{.State.Health.Status}}{{end}}",
+			id,
+		)
+		switch status := strings.TrimSpace(out); {
+		case err != nil && ctx.Err() == nil:
+			return fmt.Errorf("failed to inspect container: %w", err)
+		case err != nil:
+		case status == "" || status == "healthy":
+			return nil
+		case status == "unhealthy":
+			return fmt.Errorf("container %s is unhealthy", id)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf(
+				"container %s not healthy after %v", id, m.health,
+			)
+		case <-time.After(healthPoll):
+		}
+	}
+}
+
+type network struct {
+	host  bool
+	dns   []string
+	hosts []string
+}
+
+// HostNetwork runs the container in the host's network namespace, so
+// services listening on the host are reachable at localhost.
+func HostNetwork() Option {
+	return func(m *machine) { m.net.host = true }
+}
+
+// DNS sets the nameservers of the container. Each server must be an IP
+// address.
+func DNS(servers ...string) Option {
+	return func(m *machine) {
+		for _, s := range servers {
+			if _, err := netip.ParseAddr(s); err != nil {
+				err = fmt.Errorf("bad DNS server: %w", err)
+				m.err = errors.Join(m.err, err)
+				continue
+			}
+			m.net.dns = append(m.net.dns, s)
+		}
+	}
+}
+
+// AddHost adds a host-to-IP mapping to the container's /etc/hosts, in the
+// form "name:ip". The ip may be "host-gateway" to map name to the host.
+//
+//	m := ctr.New(sys.Machine(), "alpine", ctr.AddHost("svc:10.0.0.2"))
+func AddHost(host string) Option {
+	return func(m *machine) {
+		name, ip, ok := strings.Cut(host, ":")
+		if !ok || name == "" {
+			m.err = errors.Join(m.err, fmt.Errorf("bad host %q", host))
+			return
+		}
+		if _, err := netip.ParseAddr(ip); err != nil && ip != "host-gateway" {
+			m.err = errors.Join(m.err, fmt.Errorf("bad host %q", host))
+			return
+		}
+		m.net.hosts = append(m.net.hosts, host)
+	}
+}
+
+// check reports whether the container CLI cli supports the network
+// configuration n.
+func (n network) check(cli string) error {
+	// Lima runs containers in a virtual machine, so its host network is
+	// the VM's, and services on this host are not reachable at localhost.
+	if n.host && cli == "lima nerdctl" {
+		return errors.New("HostNetwork is not supported by lima nerdctl")
+	}
+	return nil
+}
+
+func (n network) args() (args []string) {
+	if n.host {
+		args = append(args, "--network", "host")
+	}
+	for _, s := range n.dns {
+		args = append(args, "--dns", s)
+	}
+	for _, h := range n.hosts {
+		args = append(args, "--add-host", h)
+	}
+	return
+}
+
+// ExecTimeout kills each command run in the container once it has run
+// for longer than d, rounded up to the second.
+//
+// Unlike a context deadline, which stops the container CLI on the host,
+// ExecTimeout is enforced inside the container with timeout(1), so a
+// hung process is killed even if the CLI or its connection to the
+// container runtime stays up. Killed commands fail with exit code 137.
+// The container must have a timeout command, as busybox and coreutils
+// provide.
+func ExecTimeout(d time.Duration) Option {
+	return func(m *machine) { m.timeout = d }
+}
+
+// ModuleDir is where [MountModule] mounts the Go module in the container.
+// In Windows containers, it is on the C: drive.
+const ModuleDir = "/src"
+
+// MountModule bind-mounts the root of the Go module containing the
+// current directory at [ModuleDir] in the container, and makes it the
+// default working directory of commands run there.
+//
+// On hosts with SELinux, the mount is relabeled so the container may
+// use it. With rootless podman, the container's user is mapped to the
+// current user, so files it creates in the module are owned by them.
+func MountModule() Option {
+	return func(m *machine) {
+		root, err := moduleRoot()
+		if err != nil {
+			m.err = errors.Join(m.err, err)
+			return
+		}
+		m.module = root
+	}
+}
+
+func moduleRoot() (string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", errors.New("no go.mod found")
+		}
+		dir = parent
+	}
+}
+
+// moduleArgs returns the container run args to mount the module, if any,
+// with the container CLI cli.
+func (m *machine) moduleArgs(cli string) []string {
+	if m.module == "" {
+		return nil
+	}
+	dir, _ := m.containerDir(ModuleDir)
+	vol := m.module + ":" + dir
+	if _, err := os.Stat("/sys/fs/selinux/enforce"); err == nil {
+		vol += ":z"
+	}
+	args := []string{"-v", vol, "-w", dir}
+	if cli == "podman" && os.Geteuid() > 0 {
+		args = append(args, "--userns=keep-id")
+	}
+	return args
+}
+
+// BuildOutput streams the output of building a Containerfile to w as the
+// build progresses. By default, it is streamed where the commands that
+// start the container are traced, if they are (see command.TraceWriter),
+// and to standard error otherwise. Pass io.Discard to hide it.
+func BuildOutput(w io.Writer) Option {
+	return func(m *machine) { m.build = w }
+}
+
+// Entrypoint overrides the image's entrypoint with a command that keeps
+// the container running until Shutdown. By default, the container runs
+// cat, which waits forever for input, as its command.
+//
+// Images without cat, like many minimal and distroless images, exit as
+// soon as they start unless given another keepalive command:
+//
+//	m := ctr.New(sys.Machine(), "busybox", ctr.Entrypoint("sleep", "1d"))
+func Entrypoint(arg ...string) Option {
+	return func(m *machine) { m.entrypoint = arg }
+}
+
+// PID1 runs init, such as tini or dumb-init, as the container's first
+// process, to reap zombie processes and forward signals. The keepalive
+// command, cat or the one set by [Entrypoint], runs under it.
+func PID1(init string) Option {
+	return func(m *machine) { m.init1 = init }
+}
+
+// Ephemeral runs each command in a new container, removed when the
+// command exits, instead of executing it in a long-running one. It is for
+// images with no keepalive command at all, such as distroless images
+// that hold a single static binary.
+//
+// Each command runs with the image's entrypoint replaced by the command,
+// so changes one command makes to the container's filesystem are not seen
+// by the next, except in volumes. Volumes the image declares are created
+// once, in a container that is never started, and shared by every
+// command; pass -v in [Args] for others.
+//
+// Ephemeral cannot be used with [Secret] or [WaitHealthy], and its
+// Machines cannot be snapshotted.
+func Ephemeral() Option {
+	return func(m *machine) { m.ephemeral = true }
+}
+
+// create creates the container that holds the volumes of an Ephemeral
+// machine, and returns its ID.
+func (m *machine) create(ctx context.Context, image string) (string, error) {
+	if len(m.secrets) > 0 || m.health > 0 {
+		return "", errors.New(
+			"Ephemeral cannot be used with Secret or WaitHealthy",
+		)
+	}
+	// The container is never started, so its entrypoint need not exist,
+	// but images without one would fail to create without it.
+	cmd := []string{"container", "create", "--entrypoint", "true"}
+	cmd = append(cmd, m.runArgs()...)
+	cmd = append(cmd, m.args...)
+	cmd = append(cmd, image)
+	out, err := command.Read(ctx, m.Machine, cmd...)
+	if err != nil {
+		return "", fmt.Errorf("failed to create container: %w", err)
+	}
+	m.image = image
+	return strings.TrimSpace(out), nil
+}
@@ -0,0 +1,109 @@
+package ctr
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"lesiw.io/command"
+)
+
+type sidecar struct {
+	image string
+	args  []string
+	id    string // Post-start.
+}
+
+// Sidecar starts a companion container from image alongside the
+// container of the Machine, such as a database for an application under
+// test. Additional args are passed to the container run command, and the
+// image runs its default command.
+//
+// The containers share a private network, on which each sidecar is
+// reachable by the last element of its image name, without tag or
+// digest: "postgres" for "docker.io/library/postgres:17". Pass
+// --network-alias in args for other names.
+//
+// Sidecars start, in order, before the Machine's container, and are
+// removed along with their network on Shutdown. Sidecar cannot be used
+// with [HostNetwork].
+//
+//	m := ctr.New(sys.Machine(), "golang",
+//	    ctr.Sidecar("postgres:17", "-e", "POSTGRES_PASSWORD=test"),
+//	)
+func Sidecar(image string, args ...string) Option {
+	return func(m *machine) {
+		m.sidecars = append(m.sidecars, &sidecar{image: image, args: args})
+	}
+}
+
+// alias returns the network alias of a sidecar running image.
+func alias(image string) string {
+	name := path.Base(image)
+	if i := strings.IndexAny(name, ":@"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// startSidecars creates the shared network and starts the sidecars of m.
+func (m *machine) startSidecars(ctx context.Context) (err error) {
+	if len(m.sidecars) == 0 {
+		return nil
+	}
+	if m.net.host {
+		return errors.New("Sidecar cannot be used with HostNetwork")
+	}
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, m.stopSidecars(ctx))
+		}
+	}()
+	name := "ctr-" + rand.Text()[:12]
+	err = command.Do(ctx, m.Machine, "network", "create", name)
+	if err != nil {
+		return fmt.Errorf("failed to create network: %w", err)
+	}
+	m.network = name
+	for _, s := range m.sidecars {
+		cmd := []string{
+			"container", "run", "-d",
+			"--network", m.network,
+			"--network-alias", alias(s.image),
+		}
+		cmd = append(cmd, s.args...)
+		cmd = append(cmd, s.image)
+		out, err := command.Read(ctx, m.Machine, cmd...)
+		if err != nil {
+			return fmt.Errorf("failed to start sidecar %s: %w", s.image, err)
+		}
+		s.id = strings.TrimSpace(out)
+	}
+	return nil
+}
+
+// stopSidecars removes the sidecars of m and their network.
+func (m *machine) stopSidecars(ctx context.Context) error {
+	var errs []error
+	for _, s := range m.sidecars {
+		if s.id == "" {
+			continue
+		}
+		err := command.Do(ctx, m.Machine, "container", "rm", "-f", s.id)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		s.id = ""
+	}
+	if m.network != "" {
+		err := command.Do(ctx, m.Machine, "network", "rm", m.network)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		m.network = ""
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,143 @@
+package ctr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"lesiw.io/command"
+)
+
+var _ command.PartialSnapshotMachine = (*machine)(nil)
+
+// Snapshot commits the container to an image.
+// Restoring the snapshot replaces the container with a new one started
+// from that image; discarding it removes the image. The image leaves out
+// the container's volumes, bind mounts, and tmpfs mounts, so
+// command.Snapshot copies the paths under them that it is asked for.
+func (m *machine) Snapshot(ctx context.Context) (command.Snap, error) {
+	if err := m.init(ctx); err != nil {
+		return nil, err
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+
+	if m.done {
+		return nil, errShutdown
+	}
+	if m.ephemeral {
+		return nil, errors.New("cannot snapshot Ephemeral containers")
+	}
+
+	out, err := command.Read(ctlContext(ctx), m.Machine,
+		"container", "commit", m.name,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to commit container: %w", err)
+	}
+	return &snap{m: m, image: strings.TrimSpace(out)}, nil
+}
+
+// SnapshotSaves reports whether name, an absolute path in the container,
+// is neither a mount point of the container, nor inside or above one.
+// Other paths are reported as not saved, to be copied.
+func (m *machine) SnapshotSaves(name string) bool {
+	if !strings.HasPrefix(name, "/") {
+		return false
+	}
+	name = path.Clean(name)
+	for _, target := range m.mountTargets() {
+		target = path.Clean(target)
+		if within(name, target) || within(target, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// mountTargets returns where the container run args of m mount volumes,
+// host directories, and tmpfs filesystems in the container.
+func (m *machine) mountTargets() []string {
+	var targets []string
+	if m.module != "" {
+		targets = append(targets, ModuleDir)
+	}
+	if len(m.secrets) > 0 {
+		targets = append(targets, secretDir)
+	}
+	args := m.args
+	for i := 0; i < len(args); i++ {
+		flag, val, eq := strings.Cut(args[i], "=")
+		switch flag {
+		case "-v", "--volume", "--mount", "--tmpfs":
+		default:
+			continue
+		}
+		if !eq && i+1 < len(args) {
+			i++
+			val = args[i]
+		}
+		switch flag {
+		case "--mount":
+			for field := range strings.SplitSeq(val, ",") {
+				k, v, _ := strings.Cut(field, "=")
+				switch k {
+				case "target", "destination", "dst":
+					targets = append(targets, v)
+				}
+			}
+		case "--tmpfs":
+			target, _, _ := strings.Cut(val, ":")
+			targets = append(targets, target)
+		default:
+			// host:target[:options], or an anonymous volume's target.
+			parts := strings.Split(val, ":")
+			if len(parts) >= 2 {
+				targets = append(targets, parts[1])
+			} else {
+				targets = append(targets, parts[0])
+			}
+		}
+	}
+	return targets
+}
+
+// within reports whether the clean path name is dir or inside it.
+func within(name, dir string) bool {
+	return name == dir || dir == "/" ||
+		strings.HasPrefix(name, strings.TrimSuffix(dir, "/")+"/")
+}
+
+type snap struct {
+	m     *machine
+	image string
+}
+
+func (s *snap) Restore(ctx context.Context) error {
+	ctx = ctlContext(ctx)
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if s.m.done {
+		return errShutdown
+	}
+
+	id, err := s.m.run(ctx, s.image)
+	if err != nil {
+		return err
+	}
+	old := s.m.name
+	s.m.name = id
+	return command.Do(ctx, s.m.Machine, "container", "rm", "-f", old)
+}
+
+func (s *snap) Discard(ctx context.Context) error {
+	err := command.Do(ctlContext(ctx), s.m.Machine, "image", "rm", s.image)
+	if err != nil {
+		return fmt.Errorf("failed to remove snapshot: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package ctr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"lesiw.io/command"
+)
+
+// container returns the started container machine beneath m.
+func container(ctx context.Context, m command.Machine) (*machine, error) {
+	switch m := command.Unshell(m).(type) {
+	case *machine:
+		return m, m.init(ctx)
+	case *existing:
+		return m.m, m.init(ctx)
+	}
+	return nil, errors.New("not a ctr machine")
+}
+
+// A Stat is a sample of a container's resource usage.
+type Stat struct {
+	CPU float64 // Percent of one CPU; may exceed 100 on multiple CPUs.
+
+	Memory      uint64 // Bytes of memory in use.
+	MemoryLimit uint64 // Bytes of memory available.
+
+	NetRx, NetTx          uint64 // Bytes received and sent on the network.
+	BlockRead, BlockWrite uint64 // Bytes read and written to block devices.
+
+	PIDs int // Number of processes.
+}
+
+// Stats samples the resource usage of the container of m, a Machine
+// returned by this package, so tests can record what they consumed.
+func Stats(ctx context.Context, m command.Machine) (Stat, error) {
+	cm, err := container(ctx, m)
+	if err != nil {
+		return Stat{}, err
+	}
+	cm.RLock()
+	ctl, id := cm.Machine, cm.name
+	cm.RUnlock()
+
+	out, err := command.Read(ctlContext(ctx), ctl,
+		"container", "stats", "--no-stream", "--format",
+		"{{.CPUPerc}}\t{{.MemUsage}}\t{{.NetIO}}\t{{.BlockIO}}\t{{.PIDs}}",
+		id,
+	)
+	if err != nil {
+		return Stat{}, fmt.Errorf("failed to get container stats: %w", err)
+	}
+	s, err := parseStat(out)
+	if err != nil {
+		return Stat{}, fmt.Errorf("bad container stats %q: %w", out, err)
+	}
+	return s, nil
+}
+
+func parseStat(line string) (s Stat, err error) {
+	f := strings.Split(strings.TrimSpace(line), "\t")
+	if len(f) != 5 {
+		return s, errors.New("want 5 fields")
+	}
+	cpu := strings.TrimSuffix(strings.TrimSpace(f[0]), "%")
+	if s.CPU, err = strconv.ParseFloat(cpu, 64); err != nil {
+		return
+	}
+	if s.Memory, s.MemoryLimit, err = sizePair(f[1]); err != nil {
+		return
+	}
+	if s.NetRx, s.NetTx, err = sizePair(f[2]); err != nil {
+		return
+	}
+	if s.BlockRead, s.BlockWrite, err = sizePair(f[3]); err != nil {
+		return
+	}
+	s.PIDs, err = strconv.Atoi(strings.TrimSpace(f[4]))
+	return
+}
+
+// sizePair parses a pair of sizes, as in "1.5MiB / 7.7GiB".
+func sizePair(s string) (a, b uint64, err error) {
+	x, y, ok := strings.Cut(s, "/")
+	if !ok {
+		return 0, 0, fmt.Errorf("bad size pair %q", s)
+	}
+	if a, err = size(x); err != nil {
+		return
+	}
+	b, err = size(y)
+	return
+}
+
+var units = map[string]float64{
+	"b":  1,
+	"kb": 1e3, "mb": 1e6, "gb": 1e9, "tb": 1e12,
+	"kib": 1 << 10, "mib": 1 << 20, "gib": 1 << 30, "tib": 1 << 40,
+}
+
+// size parses a size like "1.5MiB" or "12kB", as container CLIs print
+// them. Decimal and binary units are both accepted.
+func size(s string) (uint64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(s)
+	}
+	n, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad size %q", s)
+	}
+	unit := strings.ToLower(strings.TrimSpace(s[i:]))
+	if unit == "" {
+		unit = "b"
+	}
+	mul, ok := units[unit]
+	if !ok {
+		return 0, fmt.Errorf("bad size unit %q", s)
+	}
+	return uint64(n * mul), nil
+}
+
+// Pause freezes every process in the container of m, a Machine returned
+// by this package, as if the container stopped responding. Commands run
+// in it block until [Unpause].
+func Pause(ctx context.Context, m command.Machine) error {
+	return setPaused(ctx, m, "pause")
+}
+
+// Unpause resumes the processes in the container of m frozen by [Pause].
+func Unpause(ctx context.Context, m command.Machine) error {
+	return setPaused(ctx, m, "unpause")
+}
+
+func setPaused(ctx context.Context, m command.Machine, verb string) error {
+	cm, err := container(ctx, m)
+	if err != nil {
+		return err
+	}
+	cm.RLock()
+	ctl, id := cm.Machine, cm.name
+	cm.RUnlock()
+
+	err = command.Do(ctlContext(ctx), ctl, "container", verb, id)
+	if err != nil {
+		return fmt.Errorf("failed to %s container: %w", verb, err)
+	}
+	return nil
+}
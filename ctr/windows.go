@@ -0,0 +1,78 @@
+package ctr
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+
+	"lesiw.io/command"
+)
+
+// daemonOS returns the OS of the containers run by the container CLI
+// ctl: "windows" for Windows containers, or "linux".
+func daemonOS(ctx context.Context, ctl command.Machine) string {
+	out, err := command.Read(ctlContext(ctx), ctl,
+		"info", "--format", "{{.OSType}}",
+	)
+	if err == nil && strings.TrimSpace(out) == "windows" {
+		return "windows"
+	}
+	return "linux"
+}
+
+// checkOS reports whether the options of m are supported by the OS of
+// its containers.
+func (m *machine) checkOS() error {
+	if m.os != "windows" {
+		return nil
+	}
+	var errs []error
+	if len(m.secrets) > 0 {
+		errs = append(errs, errors.New(
+			"Secret is not supported with Windows containers",
+		))
+	}
+	if m.timeout > 0 {
+		errs = append(errs, errors.New(
+			"ExecTimeout is not supported with Windows containers",
+		))
+	}
+	return errors.Join(errs...)
+}
+
+// keepalive returns the command that keeps the container running.
+//
+// Unless set by [Entrypoint], on Linux, it is cat, which waits for input
+// that never comes. Windows containers have no cat, so they ping the
+// loopback address forever.
+func (m *machine) keepalive() []string {
+	if len(m.entrypoint) > 0 {
+		return m.entrypoint
+	}
+	if m.os == "windows" {
+		return []string{"cmd", "/c", "ping", "-t", "localhost"}
+	}
+	return []string{"cat"}
+}
+
+// containerDir returns dir as an absolute path in the container, or false
+// if dir is relative.
+//
+// In Windows containers, slash-rooted paths are taken to be on the C:
+// drive, so that the same paths work for either OS: "/src" is "C:\src".
+func (m *machine) containerDir(dir string) (string, bool) {
+	if m.os != "windows" {
+		return dir, path.IsAbs(dir)
+	}
+	dir = strings.ReplaceAll(dir, "/", `\`)
+	switch {
+	case strings.HasPrefix(dir, `\\`):
+		return dir, true // UNC path.
+	case strings.HasPrefix(dir, `\`):
+		return `C:` + dir, true
+	case len(dir) >= 3 && dir[1] == ':' && dir[2] == '\\':
+		return dir, true
+	}
+	return dir, false
+}
@@ -0,0 +1,117 @@
+package ctr
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"lesiw.io/command"
+	"lesiw.io/command/pathmap"
+)
+
+var _ pathmap.Mapper = (*machine)(nil)
+
+// PathMap returns the directories of the host mounted in the container by
+// [MountModule] and by -v, --volume, and --mount bind arguments.
+func (m *machine) PathMap() pathmap.Map {
+	var ms pathmap.Map
+	if m.module != "" {
+		ms = append(ms, pathmap.Mapping{Host: m.module, Target: ModuleDir})
+	}
+	return append(ms, parseMounts(m.args)...)
+}
+
+// parseMounts returns the bind mounts of host directories in container
+// run args. Named volumes are skipped.
+func parseMounts(args []string) pathmap.Map {
+	var ms pathmap.Map
+	for i := 0; i < len(args); i++ {
+		var val string
+		flag, v, eq := strings.Cut(args[i], "=")
+		switch flag {
+		case "-v", "--volume", "--mount":
+		default:
+			continue
+		}
+		if eq {
+			val = v
+		} else if i+1 < len(args) {
+			i++
+			val = args[i]
+		}
+		var mt pathmap.Mapping
+		if flag == "--mount" {
+			mt = parseMountFlag(val)
+		} else {
+			parts := strings.Split(val, ":")
+			if len(parts) >= 2 {
+				mt = pathmap.Mapping{Host: parts[0], Target: parts[1]}
+			}
+		}
+		if strings.HasPrefix(mt.Host, "/") && mt.Target != "" {
+			ms = append(ms, mt)
+		}
+	}
+	return ms
+}
+
+// parseMountFlag parses the value of a --mount flag, such as
+// type=bind,source=/src,target=/work.
+func parseMountFlag(val string) pathmap.Mapping {
+	var mt pathmap.Mapping
+	bind := false
+	for field := range strings.SplitSeq(val, ",") {
+		k, v, _ := strings.Cut(field, "=")
+		switch k {
+		case "type":
+			bind = v == "bind"
+		case "source", "src":
+			mt.Host = v
+		case "target", "destination", "dst":
+			mt.Target = v
+		}
+	}
+	if !bind {
+		return pathmap.Mapping{}
+	}
+	return mt
+}
+
+// workDir returns the working directory in the container for the
+// working directory dir of a command's context, or false if there is
+// none. Host paths under a mount are translated to where the mount is in
+// the container, and relative paths are taken to be relative to the
+// container's own working directory.
+func (m *machine) workDir(ctx context.Context, dir string) (string, bool) {
+	if dir == "" {
+		return "", false
+	}
+	if target, ok := m.PathMap().ToTarget(dir); ok {
+		return m.containerDir(target)
+	}
+	if abs, ok := m.containerDir(dir); ok {
+		return abs, true
+	}
+	wd := m.wd.Do(func() string { return m.defaultDir(ctx) })
+	if m.os == "windows" {
+		return strings.TrimRight(wd, `\`) + `\` +
+			strings.ReplaceAll(dir, "/", `\`), true
+	}
+	return path.Join(wd, dir), true
+}
+
+// defaultDir returns the working directory the container was configured
+// with, or its root directory if it has none.
+func (m *machine) defaultDir(ctx context.Context) string {
+	out, err := command.Read(ctlContext(ctx), m.Machine,
+		"container", "inspect", "--format", "{{.Config.WorkingDir}}",
+		m.name,
+	)
+	if dir := strings.TrimSpace(out); err == nil && dir != "" {
+		return dir
+	}
+	if m.os == "windows" {
+		return `C:\`
+	}
+	return "/"
+}
@@ -0,0 +1,200 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"lesiw.io/command/argv"
+	"lesiw.io/command/internal/spill"
+)
+
+// DialMachine is an optional interface for machines that can open
+// network connections from their side natively, such as over an ssh
+// channel, rather than through a relay command.
+type DialMachine interface {
+	Machine
+
+	// Dial connects to addr on the named network, as seen from the
+	// machine. It returns an error wrapping errors.ErrUnsupported if
+	// it cannot, so that [Dial] falls back to a relay command.
+	Dial(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// relayScript relays its input and output to the TCP address given by
+// its host and port arguments, and by its third argument in the form
+// that socat takes.
+const relayScript = `if command -v socat >/dev/null 2>&1; then
+	exec socat - "TCP:$3"
+elif command -v nc >/dev/null 2>&1; then
+	exec nc "$1" "$2"
+elif command -v bash >/dev/null 2>&1; then
+	exec bash -c 'exec 3<>"/dev/tcp/$1/$2" 4<&0 || exit
+	cat <&4 >&3 4<&- 2>/dev/null &
+	exec cat <&3 4<&-' bash "$1" "$2"
+fi
+echo "no socat, nc, or bash to relay connection" >&2
+exit 127`
+
+// Dial connects to addr on the named network from m, so that Go clients,
+// such as database/sql drivers and [http.Client], can talk to services
+// that only m can reach:
+//
+//	conn, err := command.Dial(ctx, m, "tcp", "localhost:5432")
+//
+// If m implements [DialMachine], Dial uses it. Otherwise the connection
+// is relayed by socat, nc, or bash on m, whichever it has, and only TCP
+// networks are supported; a failure to connect is reported by the first
+// Read or Write on the connection. Relayed connections are not supported
+// on Windows.
+//
+// The connection lasts until it is closed or ctx is done.
+func Dial(
+	ctx context.Context, m Machine, network, addr string,
+) (net.Conn, error) {
+	if dm, ok := m.(DialMachine); ok {
+		conn, err := dm.Dial(ctx, network, addr)
+		if !errors.Is(err, errors.ErrUnsupported) {
+			return conn, err
+		}
+	}
+	dialErr := func(err error) error {
+		return &net.OpError{Op: "dial", Net: network,
+			Addr: connAddr{network, addr}, Err: err}
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, dialErr(net.UnknownNetworkError(network))
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, dialErr(err)
+	}
+	if OS(ctx, m) == "windows" {
+		return nil, dialErr(fmt.Errorf("relay: %w", errors.ErrUnsupported))
+	}
+	socatAddr := addr
+	if strings.Contains(host, ":") {
+		socatAddr = "[" + host + "]:" + port
+	}
+	return commandConn(ctx, m, connAddr{network, addr},
+		"sh", "-c", relayScript, "sh", host, port, socatAddr), nil
+}
+
+// CommandConn returns a connection to the input and output of a command
+// on m, for tunnels that are commands, such as ssh -W or kubectl
+// port-forward with a relay. The command starts at once, and is canceled
+// when the connection is closed.
+//
+// Once the command finishes, reads return io.EOF if it succeeded, or its
+// error if it failed.
+func CommandConn(ctx context.Context, m Machine, args ...string) net.Conn {
+	return commandConn(ctx, m, connAddr{"command", argv.Marshal(args)},
+		args...)
+}
+
+func commandConn(
+	ctx context.Context, m Machine, remote net.Addr, args ...string,
+) net.Conn {
+	ctx, cancel := context.WithCancel(ctx)
+	local, pipe := net.Pipe()
+	c := &cmdConn{
+		Conn:   local,
+		remote: remote,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	buf := newCommand(ctx, m, args...)
+	log := spill.New(LogLimit(ctx), LogSpill(ctx))
+	Log(buf, log)
+	trace(ctx, buf, args...)
+	w, ok := buf.(WriteBuffer)
+	if !ok {
+		c.err = ErrReadOnly
+		_ = log.Close()
+		_ = pipe.Close()
+		close(c.done)
+		return c
+	}
+	go func() {
+		_, _ = io.Copy(w, pipe)
+		_ = w.Close()
+	}()
+	go func() {
+		defer close(c.done)
+		defer log.Close()
+		_, err := io.Copy(pipe, buf)
+		if e := new(Error); err != nil && errors.As(err, &e) {
+			e.Log, e.LogFile = log.Bytes(), log.Name()
+		} else {
+			_ = log.Remove()
+		}
+		c.err = redact(redactor(ctx), err)
+		// Closing the pipe ends reads of the connection, and the copying
+		// of its writes to the command.
+		_ = pipe.Close()
+	}()
+	return c
+}
+
+// A cmdConn is a connection to the input and output of a command.
+type cmdConn struct {
+	net.Conn // The local end of a pipe to the command.
+
+	remote net.Addr
+	cancel context.CancelFunc
+	done   chan struct{} // Closed once the command is done.
+	err    error         // The error of the command, once done.
+	once   sync.Once
+}
+
+func (c *cmdConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if errors.Is(err, io.EOF) {
+		<-c.done
+		if c.err != nil {
+			return n, c.err
+		}
+	}
+	return n, err
+}
+
+func (c *cmdConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if errors.Is(err, io.ErrClosedPipe) {
+		select {
+		case <-c.done:
+			if c.err != nil {
+				return n, c.err
+			}
+		default:
+		}
+	}
+	return n, err
+}
+
+// Close closes the connection and cancels its command, waiting for the
+// command to finish.
+func (c *cmdConn) Close() error {
+	var err error
+	c.once.Do(func() {
+		err = c.Conn.Close()
+		c.cancel()
+		<-c.done
+	})
+	return err
+}
+
+func (c *cmdConn) LocalAddr() net.Addr  { return connAddr{"command", ""} }
+func (c *cmdConn) RemoteAddr() net.Addr { return c.remote }
+
+// A connAddr is the address of a connection made through a machine.
+type connAddr struct{ network, addr string }
+
+func (a connAddr) Network() string { return a.network }
+func (a connAddr) String() string  { return a.addr }
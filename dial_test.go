@@ -0,0 +1,159 @@
+package command_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"runtime"
+	"strings"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+	"lesiw.io/command/sys"
+)
+
+// echoServer returns the address of a TCP server that echoes lines.
+func echoServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestDial(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	addr := echoServer(t)
+	conn, err := command.Dial(t.Context(), sys.Machine(), "tcp", addr)
+	if err != nil {
+		t.Fatalf("command.Dial error: %v", err)
+	}
+	defer conn.Close()
+	if got := conn.RemoteAddr().String(); got != addr {
+		t.Errorf("RemoteAddr = %q, want %q", got, addr)
+	}
+	r := bufio.NewReader(conn)
+	for _, line := range []string{"hello\n", "world\n"} {
+		if _, err := io.WriteString(conn, line); err != nil {
+			t.Fatalf("Write error: %v", err)
+		}
+		got, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("ReadString error: %v", err)
+		}
+		if got != line {
+			t.Errorf("read %q, want %q", got, line)
+		}
+	}
+	if err := conn.Close(); err != nil {
+		t.Errorf("Close error: %v", err)
+	}
+}
+
+func TestDialRefused(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	conn, err := command.Dial(t.Context(), sys.Machine(), "tcp", addr)
+	if err != nil {
+		t.Fatalf("command.Dial error: %v", err)
+	}
+	defer conn.Close()
+	if _, err := io.ReadAll(conn); err == nil {
+		t.Error("ReadAll error: nil, want connection failure")
+	}
+}
+
+func TestDialBadAddress(t *testing.T) {
+	m := new(mock.Machine)
+	m.SetOS("linux")
+	for _, tt := range []struct{ network, addr string }{
+		{"udp", "localhost:53"},
+		{"tcp", "localhost"},
+	} {
+		_, err := command.Dial(t.Context(), m, tt.network, tt.addr)
+		if err == nil {
+			t.Errorf("command.Dial(%q, %q) error: nil, want error",
+				tt.network, tt.addr)
+		}
+	}
+	if calls := mock.Calls(m); len(calls) > 0 {
+		t.Errorf("ran %d commands, want 0", len(calls))
+	}
+}
+
+func TestDialWindows(t *testing.T) {
+	m := new(mock.Machine)
+	m.SetOS("windows")
+	_, err := command.Dial(t.Context(), m, "tcp", "localhost:80")
+	if !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("command.Dial error: %v, want ErrUnsupported", err)
+	}
+}
+
+type dialMachine struct {
+	*mock.Machine
+	addr string
+}
+
+func (m *dialMachine) Dial(
+	_ context.Context, network, addr string,
+) (net.Conn, error) {
+	m.addr = addr
+	local, remote := net.Pipe()
+	_ = remote.Close()
+	return local, nil
+}
+
+func TestDialMachine(t *testing.T) {
+	m := &dialMachine{Machine: new(mock.Machine)}
+	conn, err := command.Dial(t.Context(), m, "tcp", "db:5432")
+	if err != nil {
+		t.Fatalf("command.Dial error: %v", err)
+	}
+	_ = conn.Close()
+	if got, want := m.addr, "db:5432"; got != want {
+		t.Errorf("dialed %q, want %q", got, want)
+	}
+}
+
+func TestCommandConn(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("pong"), "relay")
+	conn := command.CommandConn(t.Context(), m, "relay")
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("ReadAll error: %v", err)
+	}
+	if string(got) != "pong" {
+		t.Errorf("read %q, want %q", got, "pong")
+	}
+	if err := conn.Close(); err != nil {
+		t.Errorf("Close error: %v", err)
+	}
+}
@@ -13,7 +13,11 @@
 //
 // Other Machines provided by this package:
 //   - [lesiw.io/command/mem] - in-memory Machine for examples
+//   - [lesiw.io/command/agent] - runs commands through a pushed helper
 //   - [lesiw.io/command/ctr] - executes commands in containers
+//   - [lesiw.io/command/k8s] - executes commands in Kubernetes pods
+//   - [lesiw.io/command/pool] - distributes commands across machines
+//   - [lesiw.io/command/session] - runs commands through one long-lived shell
 //   - [lesiw.io/command/ssh] - executes commands over SSH
 //   - [lesiw.io/command/sub] - prefixes commands with fixed arguments
 //   - [lesiw.io/command/mock] - mock Machine for testing
@@ -372,19 +376,27 @@
 // # Tracing
 //
 // The CMDTRACE environment variable traces commands as they run.
-// Commands are traced when buffers are created via [Exec], [Read], or [Do],
-// before any I/O operations begin.
+// Commands are traced when buffers are created via [Run], [Exec], [Read],
+// or [Do], before any I/O operations begin.
 //
 //	CMDTRACE=on   trace commands to standard error
 //	CMDTRACE=full trace commands and environment variables
 //
 // The on setting prints arguments only, omitting environment
-// variables. The full setting includes environment variables.
-// Any other value disables tracing.
+// variables, quoted by [argv.Marshal] so that each command is one line
+// that reads back to the same arguments. The full setting includes
+// environment variables. Any other value disables tracing. If CMDTRACE
+// is unset, the trace mode of the user's configuration file, as loaded
+// by [lesiw.io/command/config], applies.
 //
 // Traces write to [Trace], which defaults to standard error with each
 // line prefixed by "+ ", mimicking set +x. Replace it to send traces
 // elsewhere:
 //
 //	command.Trace = logFile
+//
+// To trace only some commands, such as those of one test, use
+// [WithTrace] to carry a destination in their context instead.
+// [WithRedaction] replaces local paths in traces and errors with stable
+// tokens like $WORK and $HOME, for golden tests and bug reports.
 package command
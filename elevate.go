@@ -0,0 +1,119 @@
+package command
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+	"strings"
+)
+
+var elevationRE = regexp.MustCompile(`(?i)` + strings.Join([]string{
+	`permission denied`,
+	`access is denied`,
+	`operation not permitted`,
+	`must be (run as )?root`,
+	`are you root`,
+	`requires elevation`,
+	`run as administrator`,
+}, "|"))
+
+// NeedsElevation reports whether err looks like the failure of a command
+// that lacked the privileges it needed, such as one that was denied
+// access to a file, so that running it again with more privileges, as
+// with sudo, might succeed.
+func NeedsElevation(err error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, fs.ErrPermission) ||
+		elevationRE.MatchString(err.Error())
+}
+
+type elevationKey struct{}
+
+type elevation struct {
+	elevate func(Machine) Machine
+	confirm func(ctx context.Context, args []string) bool
+}
+
+// WithElevation returns a new context that runs commands again with more
+// privileges when they fail for lack of them, as reported by
+// [NeedsElevation]. The elevate function returns the machine to run a
+// command on in place of the one it failed on, and confirm, if it is not
+// nil, approves each command before it runs again.
+//
+//	ctx = command.WithElevation(ctx,
+//	    func(m command.Machine) command.Machine {
+//	        return sub.Machine(m, "sudo")
+//	    },
+//	    command.AskElevation(os.Stdin, os.Stderr),
+//	)
+//	err := command.Do(ctx, m, "systemctl", "restart", "nginx")
+//
+// A command runs again only if it was not given input and had not
+// produced output, so that the caller never observes both attempts.
+// [Run], [Do], [Read], and [Exec] apply the policy.
+func WithElevation(
+	ctx context.Context,
+	elevate func(Machine) Machine,
+	confirm func(ctx context.Context, args []string) bool,
+) context.Context {
+	return context.WithValue(ctx, elevationKey{},
+		&elevation{elevate: elevate, confirm: confirm})
+}
+
+// AskElevation returns a confirm function for [WithElevation] that asks
+// whether to run a command with more privileges on out and reads the
+// answer from in. Only an answer of y or yes approves.
+func AskElevation(
+	in io.Reader, out io.Writer,
+) func(ctx context.Context, args []string) bool {
+	r := bufio.NewReader(in)
+	return func(_ context.Context, args []string) bool {
+		_, _ = fmt.Fprintf(out,
+			"%s: permission denied; retry elevated? [y/N] ",
+			strings.Join(args, " "))
+		line, _ := r.ReadString('\n')
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return true
+		}
+		return false
+	}
+}
+
+// run runs spec on m, then on its elevated machine if it fails
+// for lack of privileges.
+func (e *elevation) run(ctx context.Context, m Machine, spec Spec) error {
+	ctx = context.WithValue(ctx, elevationKey{}, (*elevation)(nil))
+	out := &countWriter{w: spec.Stdout}
+	first := spec
+	first.Stdout = out
+	err := Run(ctx, m, first)
+	if err == nil || out.n > 0 || !NeedsElevation(err) {
+		return err
+	}
+	if e.confirm != nil && !e.confirm(ctx, spec.Args) {
+		return err
+	}
+	return Run(ctx, e.elevate(m), spec)
+}
+
+// A countWriter counts the bytes written to it, passing them on to w if
+// it is not nil.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	if c.w == nil {
+		return len(p), nil
+	}
+	return c.w.Write(p)
+}
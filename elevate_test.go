@@ -0,0 +1,121 @@
+package command_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+)
+
+func TestNeedsElevation(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("exit status 1"), false},
+		{fmt.Errorf("open /etc/shadow: %w", fs.ErrPermission), true},
+		{&command.Error{
+			Log:  []byte("touch: /etc/x: Permission denied\n"),
+			Code: 1,
+		}, true},
+		{&command.Error{Log: []byte("Access is denied.\r\n"), Code: 5}, true},
+		{&command.Error{
+			Log:  []byte("E: This command must be run as root\n"),
+			Code: 100,
+		}, true},
+		{&command.Error{Log: []byte("no such file\n"), Code: 1}, false},
+	}
+	for _, tt := range tests {
+		if got := command.NeedsElevation(tt.err); got != tt.want {
+			t.Errorf("NeedsElevation(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func elevationMachines() (m, root *mock.Machine) {
+	m, root = new(mock.Machine), new(mock.Machine)
+	m.Return(command.Fail(&command.Error{
+		Log:  []byte("cat: /etc/shadow: Permission denied\n"),
+		Code: 1,
+	}), "cat")
+	root.Return(strings.NewReader("root:*\n"), "cat")
+	return m, root
+}
+
+func TestWithElevation(t *testing.T) {
+	m, root := elevationMachines()
+	var asked []string
+	ctx := command.WithElevation(t.Context(),
+		func(command.Machine) command.Machine { return root },
+		func(_ context.Context, args []string) bool {
+			asked = args
+			return true
+		},
+	)
+
+	out, err := command.Read(ctx, m, "cat", "/etc/shadow")
+	if err != nil {
+		t.Fatalf("command.Read error: %v", err)
+	}
+	if want := "root:*"; out != want {
+		t.Errorf("command.Read = %q, want %q", out, want)
+	}
+	if got, want := strings.Join(asked, " "), "cat /etc/shadow"; got != want {
+		t.Errorf("confirmed %q, want %q", got, want)
+	}
+}
+
+func TestWithElevationDeclined(t *testing.T) {
+	m, root := elevationMachines()
+	var prompt strings.Builder
+	ctx := command.WithElevation(t.Context(),
+		func(command.Machine) command.Machine { return root },
+		command.AskElevation(strings.NewReader("n\n"), &prompt),
+	)
+
+	_, err := command.Read(ctx, m, "cat", "/etc/shadow")
+	if !command.NeedsElevation(err) {
+		t.Errorf("command.Read error: got %v, want permission denied", err)
+	}
+	if calls := mock.Calls(root, "cat"); len(calls) != 0 {
+		t.Errorf("elevated calls = %d, want 0", len(calls))
+	}
+	if !strings.Contains(prompt.String(), "cat /etc/shadow") {
+		t.Errorf("prompt = %q, want command", prompt.String())
+	}
+}
+
+func TestWithElevationOtherError(t *testing.T) {
+	m, root := new(mock.Machine), new(mock.Machine)
+	m.Return(command.Fail(&command.Error{
+		Log:  []byte("cat: /nope: No such file or directory\n"),
+		Code: 1,
+	}), "cat")
+	ctx := command.WithElevation(t.Context(),
+		func(command.Machine) command.Machine { return root }, nil)
+
+	if err := command.Do(ctx, m, "cat", "/nope"); err == nil {
+		t.Fatal("command.Do succeeded, want error")
+	}
+	if calls := mock.Calls(root, "cat"); len(calls) != 0 {
+		t.Errorf("elevated calls = %d, want 0", len(calls))
+	}
+}
+
+func TestAskElevation(t *testing.T) {
+	for answer, want := range map[string]bool{
+		"y\n": true, "YES\n": true, "\n": false, "no\n": false, "": false,
+	} {
+		var out strings.Builder
+		confirm := command.AskElevation(strings.NewReader(answer), &out)
+		if got := confirm(t.Context(), []string{"ls"}); got != want {
+			t.Errorf("answer %q: confirm = %v, want %v", answer, got, want)
+		}
+	}
+}
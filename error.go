@@ -1,11 +1,38 @@
 package command
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"strings"
 )
 
+// Errors that mark why a command was interrupted. Machines mark the
+// errors of interrupted commands with [Interrupted], so that callers can
+// tell them apart with errors.Is:
+//
+//	err := command.Do(ctx, m, "make")
+//	switch {
+//	case errors.Is(err, command.ErrCanceled):
+//	    // The caller gave up, as on Ctrl-C; don't retry.
+//	case errors.Is(err, command.ErrDeadline):
+//	    // It ran out of time; maybe retry with a longer timeout.
+//	case errors.Is(err, command.ErrKilled):
+//	    // Something else killed it, such as the OOM killer.
+//	}
+var (
+	// ErrCanceled means that the context of the command was canceled.
+	ErrCanceled = errors.New("command: canceled")
+
+	// ErrDeadline means that the deadline of the command's context
+	// passed.
+	ErrDeadline = errors.New("command: deadline exceeded")
+
+	// ErrKilled means that a signal killed the command while its context
+	// was still live.
+	ErrKilled = errors.New("command: killed")
+)
+
 // Error represents a command execution failure.
 //
 // Commands attached to their controlling terminal via Exec will have an
@@ -13,8 +40,20 @@ import (
 // being captured.
 type Error struct {
 	// Log contains the log output. This usually corresponds to stderr.
+	// Only the last [LogLimit] bytes are kept; see LogFile.
 	Log []byte
 
+	// LogFile, if set, names a temporary file that holds the complete
+	// log output, which was too large to keep in Log. It is only set
+	// for commands run with [WithLogSpill], whose callers are
+	// responsible for removing it.
+	LogFile string
+
+	// Output contains the output the command produced before it failed
+	// or was canceled, when the caller captured it, as [Read] does.
+	// It is not included in the error message.
+	Output []byte
+
 	// Err is the underlying error.
 	Err error
 
@@ -38,11 +77,66 @@ func (e *Error) Error() string {
 				),
 		)
 	}
+	if e.LogFile != "" {
+		sb.WriteString("\n\t[log truncated; see " + e.LogFile + "]")
+	}
 	return sb.String()
 }
 
 func (e *Error) Unwrap() error { return e.Err }
 
+// DefaultLogLimit is the [LogLimit] of contexts without [WithLogLimit].
+const DefaultLogLimit = 1 << 20
+
+type (
+	logLimitKey struct{}
+	logSpillKey struct{}
+)
+
+// WithLogLimit returns a context whose commands keep at most n bytes of
+// their diagnostic output in memory for their [Error]: the last n bytes,
+// which usually say why the command failed. A limit of 0 or less
+// restores [DefaultLogLimit].
+func WithLogLimit(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, logLimitKey{}, max(n, 0))
+}
+
+// LogLimit returns the limit set by [WithLogLimit], or
+// [DefaultLogLimit].
+func LogLimit(ctx context.Context) int {
+	if n, _ := ctx.Value(logLimitKey{}).(int); n > 0 {
+		return n
+	}
+	return DefaultLogLimit
+}
+
+// WithLogSpill returns a context whose commands, when their diagnostic
+// output exceeds [LogLimit], spill all of it to a temporary file, so
+// that the diagnostics of very noisy commands are kept without
+// exhausting memory. The file of a command that fails is named by the
+// LogFile of its [Error], and the caller must remove it; the files of
+// commands that succeed are removed. Without spilling, output beyond
+// the limit is dropped.
+//
+//	ctx := command.WithLogSpill(ctx, true)
+//	err := command.Do(ctx, m, "make")
+//	if e := new(command.Error); errors.As(err, &e) && e.LogFile != "" {
+//	    defer os.Remove(e.LogFile)
+//	}
+//
+// Machines and helpers do not spill the output of the commands they run
+// for themselves; see [WithoutCommandOptions].
+func WithLogSpill(ctx context.Context, spill bool) context.Context {
+	return context.WithValue(ctx, logSpillKey{}, spill)
+}
+
+// LogSpill reports whether ctx asks for spilling, as set by
+// [WithLogSpill].
+func LogSpill(ctx context.Context) bool {
+	spill, _ := ctx.Value(logSpillKey{}).(bool)
+	return spill
+}
+
 // NotFound returns true if err represents a command that failed to start,
 // typically indicating the command was not found.
 //
@@ -65,3 +159,98 @@ func NotFound(err error) bool {
 	}
 	return cmdErr.Err != nil && cmdErr.Code == 0
 }
+
+// Missing returns true if err represents a command that is not installed:
+// one that failed to start, as [NotFound] reports, or one that exited with
+// code 127, which is how a shell reports a command it cannot find. Remote
+// machines, such as ssh, ctr, and k8s, run commands through a shell, so
+// their missing commands exit with code 127 rather than failing to start.
+//
+// A command may exit with code 127 for its own reasons, so Missing suits
+// probes for tools that do not, such as downloaders and archivers.
+func Missing(err error) bool {
+	var cmdErr *Error
+	if !errors.As(err, &cmdErr) {
+		return false
+	}
+	return NotFound(err) || cmdErr.Err == nil && cmdErr.Code == 127
+}
+
+// Interrupted marks err, the error of a command that ran with ctx, with
+// why the command was interrupted: [ErrDeadline] if the deadline of ctx
+// passed, [ErrCanceled] if ctx was otherwise canceled, or [ErrKilled] if
+// err is an [Error] with a negative Code, which is how a command killed by
+// a signal exits. Otherwise, it returns err unchanged.
+//
+// Machines that run processes call Interrupted on the errors their
+// Buffers return: those of sys, agent, session, and execadapter, and of
+// machines built on them, such as ssh, ctr, and k8s. A container's
+// processes are killed beyond the reach of kubectl, so k8s errors carry
+// [ErrCanceled] and [ErrDeadline] but never ErrKilled. The mem and mock
+// machines run nothing that can be interrupted, and do not mark errors.
+//
+// If err holds an Error, the mark goes on the Err of a copy of it, so that
+// its Code and Log are kept; err itself is not modified.
+func Interrupted(ctx context.Context, err error) error {
+	if err == nil || errors.Is(err, ErrCanceled) ||
+		errors.Is(err, ErrDeadline) || errors.Is(err, ErrKilled) {
+		return err
+	}
+	var cmdErr *Error
+	hasErr := errors.As(err, &cmdErr)
+	var mark error
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		mark = ErrDeadline
+	case ctx.Err() != nil:
+		mark = ErrCanceled
+	case hasErr && cmdErr.Code < 0:
+		mark = ErrKilled
+	default:
+		return err
+	}
+	if !hasErr {
+		return fmt.Errorf("%w: %w", mark, err)
+	}
+	c := *cmdErr
+	if c.Err == nil {
+		c.Err = mark
+	} else {
+		c.Err = fmt.Errorf("%w: %w", mark, c.Err)
+	}
+	msg := c.Error()
+	if err != cmdErr {
+		msg = mark.Error() + ": " + err.Error()
+	}
+	return &copiedError{msg, &c, err}
+}
+
+// replaceError returns err with c, a changed copy of the [Error] e in it,
+// in place of e, and with msg as its message. Errors are copied rather
+// than changed, since others may hold them.
+func replaceError(err error, e, c *Error, msg string) error {
+	if err == e {
+		return c
+	}
+	return &copiedError{msg, c, err}
+}
+
+// A copiedError is err with another message, and a copy of the [Error] in
+// it that errors.Is and errors.As find first.
+type copiedError struct {
+	msg string
+	e   *Error
+	err error
+}
+
+func (e *copiedError) Error() string   { return e.msg }
+func (e *copiedError) Unwrap() []error { return []error{e.e, e.err} }
+
+// An editedError is err with another message.
+type editedError struct {
+	msg string
+	err error
+}
+
+func (e *editedError) Error() string { return e.msg }
+func (e *editedError) Unwrap() error { return e.err }
@@ -1,10 +1,14 @@
 package command_test
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"lesiw.io/command"
 )
@@ -111,3 +115,102 @@ func TestNotFound(t *testing.T) {
 		})
 	}
 }
+
+func TestInterrupted(t *testing.T) {
+	canceled, cancel := context.WithCancel(t.Context())
+	cancel()
+	expired, cancel := context.WithDeadline(t.Context(), time.Unix(0, 0))
+	defer cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want error
+	}{
+		{"canceled", canceled, &command.Error{Code: -1}, command.ErrCanceled},
+		{"deadline", expired, &command.Error{Code: -1}, command.ErrDeadline},
+		{"killed", t.Context(), &command.Error{Code: -1}, command.ErrKilled},
+		{"plain", canceled, io.ErrUnexpectedEOF, command.ErrCanceled},
+		{"exit", t.Context(), &command.Error{Code: 1}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := command.Interrupted(tt.ctx, tt.err)
+			for _, mark := range []error{command.ErrCanceled,
+				command.ErrDeadline, command.ErrKilled} {
+				got, want := errors.Is(err, mark), mark == tt.want
+				if got != want {
+					t.Errorf("errors.Is(%v, %v) = %v, want %v",
+						err, mark, got, want)
+				}
+			}
+			if !errors.Is(err, tt.err) {
+				t.Errorf("errors.Is(%v, %v) = false, want true", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestInterruptedKeepsError(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	err := command.Interrupted(ctx, &command.Error{
+		Err:  errors.New("signal: killed"),
+		Code: -1,
+		Log:  []byte("partial\n"),
+	})
+
+	var cmdErr *command.Error
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("errors.As(%v, *command.Error) = false", err)
+	}
+	if got, want := cmdErr.Code, -1; got != want {
+		t.Errorf("Code = %d, want %d", got, want)
+	}
+	want := "command: canceled: signal: killed\n\tpartial"
+	if got := err.Error(); got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if again := command.Interrupted(ctx, err); again.Error() != want {
+		t.Errorf("Interrupted twice = %q, want %q", again.Error(), want)
+	}
+}
+
+func TestReadOutputCopiesError(t *testing.T) {
+	shared := &command.Error{Code: 1}
+	m := command.MachineFunc(
+		func(context.Context, ...string) command.Buffer {
+			return io.MultiReader(strings.NewReader("partial"),
+				iotest.ErrReader(shared))
+		},
+	)
+
+	_, err := command.Read(t.Context(), m, "build")
+
+	var cmdErr *command.Error
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("errors.As(%v, *command.Error) = false", err)
+	}
+	if got, want := string(cmdErr.Output), "partial"; got != want {
+		t.Errorf("Output = %q, want %q", got, want)
+	}
+	if shared.Output != nil {
+		t.Errorf("shared Output = %q, want nil", shared.Output)
+	}
+}
+
+func TestInterruptedCopiesError(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	shared := &command.Error{Code: -1}
+
+	err := command.Interrupted(ctx, shared)
+
+	if !errors.Is(err, command.ErrCanceled) {
+		t.Errorf("errors.Is(%v, ErrCanceled) = false, want true", err)
+	}
+	if shared.Err != nil {
+		t.Errorf("shared Err = %v, want nil", shared.Err)
+	}
+}
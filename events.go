@@ -0,0 +1,239 @@
+package command
+
+import (
+	"context"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// An EventKind is the kind of an [Event].
+type EventKind int
+
+const (
+	// EventStart is sent when a command starts.
+	EventStart EventKind = iota + 1
+
+	// EventStdout is sent with each chunk of a command's output.
+	EventStdout
+
+	// EventStderr is sent with each chunk of a command's diagnostic
+	// output, when it is captured with [Log].
+	EventStderr
+
+	// EventExit is sent when a command completes.
+	EventExit
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case EventStart:
+		return "start"
+	case EventStdout:
+		return "stdout"
+	case EventStderr:
+		return "stderr"
+	case EventExit:
+		return "exit"
+	}
+	return "unknown"
+}
+
+// An Event reports a step in the life of a command.
+type Event struct {
+	Kind EventKind
+
+	// ID identifies the command among the events of every command run
+	// by this process.
+	ID uint64
+
+	// Machine is the Machine that runs the command.
+	Machine Machine
+
+	// Args are the arguments of the command. They must not be modified.
+	Args []string
+
+	// Time is when the event happened.
+	Time time.Time
+
+	// Data is the output of an EventStdout or EventStderr event. It is
+	// only valid during the call to the subscriber.
+	Data []byte
+
+	// Err is the error of an EventExit event, or nil if the command
+	// succeeded.
+	Err error
+}
+
+type subscriber struct {
+	fn func(Event)
+	ms []Machine
+}
+
+func (s *subscriber) wants(m Machine) bool {
+	if len(s.ms) == 0 {
+		return true
+	}
+	return slices.ContainsFunc(s.ms, func(sm Machine) bool {
+		return sameMachine(sm, m)
+	})
+}
+
+var (
+	subsMu  sync.Mutex
+	subs    atomic.Pointer[[]*subscriber]
+	eventID atomic.Uint64
+)
+
+// Subscribe calls fn with the events of commands run with [Run], [Do],
+// [Read], [Exec], [NewReader], [NewWriter], or [NewFilter], until the
+// returned function is called. If any machines are given, it only calls
+// fn for commands run directly on one of them.
+//
+// Subscribers decouple observers, such as progress displays, metrics,
+// and audit logs, from the Machines that commands run on:
+//
+//	stop := command.Subscribe(func(e command.Event) {
+//	    if e.Kind == command.EventExit {
+//	        log.Printf("%v: %v", e.Args, e.Err)
+//	    }
+//	})
+//	defer stop()
+//
+// Events are sent synchronously, from the goroutine that reads or starts
+// the command, so fn must be quick and safe for concurrent use. The
+// events of one command are sent in order.
+func Subscribe(fn func(Event), m ...Machine) (cancel func()) {
+	s := &subscriber{fn: fn, ms: slices.Clone(m)}
+	subsMu.Lock()
+	defer subsMu.Unlock()
+	var list []*subscriber
+	if p := subs.Load(); p != nil {
+		list = slices.Clone(*p)
+	}
+	list = append(list, s)
+	subs.Store(&list)
+	return sync.OnceFunc(func() {
+		subsMu.Lock()
+		defer subsMu.Unlock()
+		list := slices.DeleteFunc(slices.Clone(*subs.Load()),
+			func(sub *subscriber) bool { return sub == s })
+		subs.Store(&list)
+	})
+}
+
+// newCommand runs m.Command for the package's entry points, with the
+// scratch directory and events that ctx and subscribers ask for.
+func newCommand(ctx context.Context, m Machine, args ...string) Buffer {
+	buf := scratchCommand(ctx, m, args...)
+	p := subs.Load()
+	if p == nil || len(*p) == 0 {
+		return buf
+	}
+	var list []*subscriber
+	for _, s := range *p {
+		if s.wants(m) {
+			list = append(list, s)
+		}
+	}
+	if len(list) == 0 {
+		return buf
+	}
+	eb := &eventBuffer{
+		Buffer: buf,
+		subs:   list,
+		event:  Event{ID: eventID.Add(1), Machine: m, Args: args},
+	}
+	if _, ok := buf.(WriteBuffer); ok {
+		return &eventWriteBuffer{eb}
+	}
+	return eb
+}
+
+// An eventBuffer sends the events of its command to subscribers.
+type eventBuffer struct {
+	Buffer
+	subs  []*subscriber
+	event Event
+	start sync.Once
+	exit  sync.Once
+}
+
+func (b *eventBuffer) send(kind EventKind, data []byte, err error) {
+	e := b.event
+	e.Kind, e.Time, e.Data, e.Err = kind, time.Now(), data, err
+	for _, s := range b.subs {
+		s.fn(e)
+	}
+}
+
+func (b *eventBuffer) begin() {
+	b.start.Do(func() { b.send(EventStart, nil, nil) })
+}
+
+func (b *eventBuffer) Read(p []byte) (int, error) {
+	b.begin()
+	n, err := b.Buffer.Read(p)
+	if n > 0 {
+		b.send(EventStdout, p[:n], nil)
+	}
+	if err != nil {
+		b.exit.Do(func() {
+			if err == io.EOF {
+				b.send(EventExit, nil, nil)
+			} else {
+				b.send(EventExit, nil, err)
+			}
+		})
+	}
+	return n, err
+}
+
+func (b *eventBuffer) Attach() error {
+	b.begin()
+	return Attach(b.Buffer)
+}
+
+func (b *eventBuffer) Log(w io.Writer) {
+	Log(b.Buffer, &eventLog{w: w, b: b})
+}
+
+func (b *eventBuffer) String() string { return String(b.Buffer) }
+
+func (b *eventBuffer) Close() error {
+	if closer, ok := b.Buffer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (b *eventBuffer) Result() (Result, bool) { return ResultOf(b.Buffer) }
+
+// SpliceFrom reports false, so that a command with subscribers is never
+// spliced.
+func (*eventBuffer) SpliceFrom(io.Reader) bool { return false }
+
+// SpliceTo reports false: spliced output would bypass Read, and with it
+// the command's stdout events.
+func (*eventBuffer) SpliceTo(io.Writer) bool { return false }
+
+type eventWriteBuffer struct{ *eventBuffer }
+
+func (b *eventWriteBuffer) Write(p []byte) (int, error) {
+	b.begin()
+	return b.Buffer.(WriteBuffer).Write(p)
+}
+
+// An eventLog sends the diagnostic output of a command to subscribers as
+// it passes through.
+type eventLog struct {
+	w io.Writer
+	b *eventBuffer
+}
+
+func (l *eventLog) Write(p []byte) (int, error) {
+	l.b.send(EventStderr, p, nil)
+	return l.w.Write(p)
+}
@@ -0,0 +1,159 @@
+package command_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+	"lesiw.io/command/sys"
+)
+
+type eventLog struct {
+	sync.Mutex
+	events []string
+}
+
+func (l *eventLog) add(e command.Event) {
+	l.Lock()
+	defer l.Unlock()
+	s := e.Kind.String() + " " + strings.Join(e.Args, " ")
+	if e.Data != nil {
+		s += " " + strings.TrimSpace(string(e.Data))
+	}
+	if e.Err != nil {
+		s += " err"
+	}
+	l.events = append(l.events, s)
+}
+
+func TestSubscribe(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("hello\n"), "echo")
+	m.Return(command.Fail(&command.Error{Code: 1}), "false")
+	var log eventLog
+	stop := command.Subscribe(log.add, m)
+
+	_, _ = command.Read(t.Context(), m, "echo", "hello")
+	_ = command.Do(t.Context(), m, "false")
+	_ = command.Do(t.Context(), new(mock.Machine), "ignored")
+	stop()
+	_ = command.Do(t.Context(), m, "echo", "after")
+
+	want := []string{
+		"start echo hello",
+		"stdout echo hello hello",
+		"exit echo hello",
+		"start false",
+		"exit false err",
+	}
+	if !cmp.Equal(log.events, want) {
+		t.Errorf("events (-want +got):\n%s", cmp.Diff(want, log.events))
+	}
+}
+
+func TestSubscribeStderr(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	m := sys.Machine()
+	var log eventLog
+	defer command.Subscribe(log.add, m)()
+
+	err := command.Run(t.Context(), m, command.Spec{
+		Args:   []string{"sh", "-c", "echo warn >&2"},
+		Stderr: io.Discard,
+	})
+	if err != nil {
+		t.Fatalf("command.Run error: %v", err)
+	}
+
+	want := []string{
+		"start sh -c echo warn >&2",
+		"stderr sh -c echo warn >&2 warn",
+		"exit sh -c echo warn >&2",
+	}
+	if !cmp.Equal(log.events, want) {
+		t.Errorf("events (-want +got):\n%s", cmp.Diff(want, log.events))
+	}
+}
+
+func TestSubscribeUncomparable(t *testing.T) {
+	type wrapped struct{ command.Machine }
+	m := new(mock.Machine)
+	f := wrapped{command.MachineFunc(m.Command)}
+	var log eventLog
+	defer command.Subscribe(log.add, f, command.MachineFunc(m.Command))()
+
+	if err := command.Do(t.Context(), f, "true"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+
+	if len(log.events) != 0 {
+		t.Errorf("events: got %q, want none", log.events)
+	}
+}
+
+// A closeReader is a read-only buffer that records whether it was closed.
+type closeReader struct {
+	io.Reader
+	closed bool
+}
+
+func (r *closeReader) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestSubscribeForwardsClose(t *testing.T) {
+	buf := &closeReader{Reader: strings.NewReader("")}
+	m := command.MachineFunc(func(context.Context, ...string) command.Buffer {
+		return buf
+	})
+	defer command.Subscribe(func(command.Event) {})()
+
+	err := command.Run(t.Context(), m, command.Spec{
+		Args:  []string{"cat"},
+		Stdin: strings.NewReader("hello"),
+	})
+
+	if !errors.Is(err, command.ErrReadOnly) {
+		t.Errorf("command.Run error: got %v, want ErrReadOnly", err)
+	}
+	if !buf.closed {
+		t.Error("read-only buffer was not closed")
+	}
+}
+
+func TestSubscribeSplice(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires echo")
+	}
+	m := sys.Machine()
+	var log eventLog
+	defer command.Subscribe(log.add, m)()
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	_, err = command.Copy(f, command.NewReader(t.Context(), m, "echo", "hi"))
+	if err != nil {
+		t.Fatalf("command.Copy error: %v", err)
+	}
+
+	want := []string{"start echo hi", "stdout echo hi hi", "exit echo hi"}
+	if !cmp.Equal(log.events, want) {
+		t.Errorf("events (-want +got):\n%s", cmp.Diff(want, log.events))
+	}
+}
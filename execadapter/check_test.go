@@ -0,0 +1,11 @@
+//go:build !remote && !race
+
+package execadapter
+
+import (
+	"testing"
+
+	"lesiw.io/command/internal/testcheck"
+)
+
+func TestCheck(t *testing.T) { testcheck.Run(t) }
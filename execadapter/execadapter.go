@@ -0,0 +1,251 @@
+// Package execadapter lifts code that builds its own [exec.Cmd] values
+// into a command.Machine.
+//
+// Programs often wrap the commands they run with setup of their own, such
+// as credential helpers, SDK wrappers, or process attributes. A function
+// that does that setup becomes a Machine without being rewritten:
+//
+//	m := execadapter.Machine(
+//	    func(ctx context.Context, args []string) *exec.Cmd {
+//	        cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+//	        cmd.Env = append(os.Environ(), "CLOUDSDK_CORE_PROJECT=ci")
+//	        return cmd
+//	    },
+//	)
+//	out, err := command.Read(ctx, m, "gcloud", "compute", "zones", "list")
+package execadapter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	"lesiw.io/command"
+	"lesiw.io/command/internal/sh"
+	"lesiw.io/command/internal/spill"
+	"lesiw.io/fs"
+)
+
+// Machine returns a command.Machine that runs each command as the
+// exec.Cmd that build returns for its arguments.
+//
+// The Machine fills in what build leaves unset: the environment of the
+// context over that of the current process, the working directory of the
+// context, and the command's input, output, and diagnostic output. What
+// build sets is left as it is. The command is killed when the context is
+// done, whether or not build used exec.CommandContext.
+//
+// Requests to redirect output to files, as by command.WithStdoutFile,
+// fail with an error wrapping errors.ErrUnsupported.
+func Machine(
+	build func(ctx context.Context, args []string) *exec.Cmd,
+) command.Machine {
+	return machine{build}
+}
+
+type machine struct {
+	build func(ctx context.Context, args []string) *exec.Cmd
+}
+
+func (m machine) Command(ctx context.Context, args ...string) command.Buffer {
+	if len(args) == 0 {
+		return command.Fail(errors.New("no command given"))
+	}
+	if command.StdoutFile(ctx) != "" || command.StderrFile(ctx) != "" {
+		return command.Fail(&command.Error{Err: fmt.Errorf(
+			"redirect to file: %w", errors.ErrUnsupported)})
+	}
+	c := m.build(ctx, args)
+	if c == nil {
+		return command.Fail(&command.Error{
+			Err: fmt.Errorf("no exec.Cmd for %s", args[0]),
+		})
+	}
+	env := command.Envs(ctx)
+	if len(env) > 0 {
+		if c.Env == nil {
+			c.Env = os.Environ()
+		}
+		for k, v := range env {
+			c.Env = append(c.Env, k+"="+v)
+		}
+	}
+	if dir := fs.WorkDir(ctx); c.Dir == "" && dir != "" {
+		c.Dir = filepath.FromSlash(dir)
+	}
+	b := &buffer{
+		ctx:     ctx,
+		cmd:     c,
+		env:     env,
+		merge:   command.MergedStderr(ctx),
+		handed:  c.Stdin != nil,
+		cmdwait: make(chan error, 1),
+	}
+	b.start = sync.OnceValue(b.startFunc)
+	b.wait = sync.OnceValue(b.waitFunc)
+	return b
+}
+
+type buffer struct {
+	ctx    context.Context
+	cmd    *exec.Cmd
+	env    map[string]string
+	merge  bool // Send diagnostic output to output.
+	handed bool // Input was set by build.
+
+	start   func() error
+	wait    func() error
+	cmdwait chan error
+
+	reader *io.PipeReader
+	writer io.WriteCloser
+	logger io.Writer
+	logbuf *spill.Buffer
+}
+
+var (
+	_ command.WriteBuffer  = (*buffer)(nil)
+	_ command.LogBuffer    = (*buffer)(nil)
+	_ command.AttachBuffer = (*buffer)(nil)
+)
+
+func (b *buffer) startFunc() error {
+	c := b.cmd
+	if c.Stdin == nil {
+		w, err := c.StdinPipe()
+		if err != nil {
+			return &command.Error{Err: err}
+		}
+		b.writer = w
+	}
+	var pw *io.PipeWriter
+	if c.Stdout == nil {
+		b.reader, pw = io.Pipe()
+		c.Stdout = pw
+	}
+	if c.Stderr == nil && b.merge && pw != nil {
+		c.Stderr = pw
+	}
+	if c.Stderr == nil {
+		if b.logger != nil {
+			c.Stderr = b.logger
+		} else {
+			b.logbuf = spill.New(command.LogLimit(b.ctx),
+				command.LogSpill(b.ctx))
+			c.Stderr = b.logbuf
+		}
+	}
+	if err := c.Start(); err != nil {
+		if pw != nil {
+			_ = pw.Close()
+		}
+		return cmdError(err)
+	}
+	stop := context.AfterFunc(b.ctx, func() { _ = c.Process.Kill() })
+	go func() {
+		err := c.Wait()
+		stop()
+		if pw != nil {
+			_ = pw.Close()
+		}
+		b.cmdwait <- err
+	}()
+	return nil
+}
+
+func (b *buffer) waitFunc() error {
+	err := <-b.cmdwait
+	if b.logbuf != nil {
+		defer b.logbuf.Close()
+	}
+	if err == nil {
+		if b.logbuf != nil {
+			_ = b.logbuf.Remove()
+		}
+		return nil
+	}
+	err = cmdError(err)
+	if e := new(command.Error); b.logbuf != nil && errors.As(err, &e) {
+		e.Log, e.LogFile = b.logbuf.Bytes(), b.logbuf.Name()
+	}
+	return command.Interrupted(b.ctx, err)
+}
+
+// cmdError wraps an error of os/exec as a command.Error, with the exit
+// code of the command if it ran.
+func cmdError(err error) error {
+	e := &command.Error{Err: err}
+	if ee := new(exec.ExitError); errors.As(err, &ee) {
+		e.Code = ee.ExitCode()
+	}
+	return e
+}
+
+func (b *buffer) Read(p []byte) (int, error) {
+	if err := b.start(); err != nil {
+		return 0, err
+	}
+	if b.reader == nil {
+		if err := b.wait(); err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+	n, err := b.reader.Read(p)
+	if err != nil {
+		if werr := b.wait(); werr != nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+func (b *buffer) Write(p []byte) (int, error) {
+	if err := b.start(); err != nil {
+		return 0, err
+	}
+	if b.writer == nil {
+		return 0, nil
+	}
+	return b.writer.Write(p)
+}
+
+func (b *buffer) Close() error {
+	if b.handed {
+		return nil // Its input is not ours to close.
+	}
+	if err := b.start(); err != nil {
+		return err
+	}
+	if b.writer == nil {
+		return nil
+	}
+	if err := b.writer.Close(); err != nil && !errors.Is(err, os.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+func (b *buffer) Log(w io.Writer) { b.logger = w }
+
+func (b *buffer) Attach() error {
+	if b.cmd.Stdin == nil {
+		b.cmd.Stdin, b.handed = os.Stdin, true
+	}
+	if b.cmd.Stdout == nil {
+		b.cmd.Stdout = os.Stdout
+	}
+	if b.cmd.Stderr == nil {
+		b.cmd.Stderr = os.Stderr
+	}
+	return b.start()
+}
+
+func (b *buffer) String() string {
+	return sh.String(b.env, b.cmd.Args...).String()
+}
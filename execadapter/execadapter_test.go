@@ -0,0 +1,105 @@
+package execadapter_test
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"lesiw.io/command"
+	"lesiw.io/command/execadapter"
+	"lesiw.io/fs"
+)
+
+func shMachine(t *testing.T) command.Machine {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	return execadapter.Machine(
+		func(ctx context.Context, args []string) *exec.Cmd {
+			cmd := exec.Command(args[0], args[1:]...)
+			cmd.Env = []string{"PATH=/usr/bin:/bin", "BUILT=yes"}
+			return cmd
+		},
+	)
+}
+
+func TestMachine(t *testing.T) {
+	m := shMachine(t)
+	ctx := command.WithEnv(t.Context(), map[string]string{"FROM": "ctx"})
+	ctx = fs.WithWorkDir(ctx, "/")
+	out, err := command.Read(ctx, m,
+		"sh", "-c", `echo "$BUILT $FROM $(pwd)"`)
+	if err != nil {
+		t.Fatalf("command.Read error: %v", err)
+	}
+	if want := "yes ctx /"; out != want {
+		t.Errorf("command.Read = %q, want %q", out, want)
+	}
+}
+
+func TestMachineStdin(t *testing.T) {
+	m := shMachine(t)
+	var out strings.Builder
+	err := command.Run(t.Context(), m, command.Spec{
+		Args:   []string{"tr", "a-z", "A-Z"},
+		Stdin:  strings.NewReader("hello"),
+		Stdout: &out,
+	})
+	if err != nil {
+		t.Fatalf("command.Run error: %v", err)
+	}
+	if got, want := out.String(), "HELLO"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestMachineFailure(t *testing.T) {
+	m := shMachine(t)
+	err := command.Do(t.Context(), m, "sh", "-c", "echo oops >&2; exit 3")
+	e := new(command.Error)
+	if !errors.As(err, &e) {
+		t.Fatalf("command.Do error: %v, want *command.Error", err)
+	}
+	if e.Code != 3 {
+		t.Errorf("Code = %d, want 3", e.Code)
+	}
+	if got := string(e.Log); !strings.Contains(got, "oops") {
+		t.Errorf("Log = %q, want oops", got)
+	}
+}
+
+func TestMachineNotFound(t *testing.T) {
+	m := shMachine(t)
+	err := command.Do(t.Context(), m, "no-such-command-execadapter")
+	if !command.NotFound(err) {
+		t.Errorf("command.Do error: %v, want not found", err)
+	}
+}
+
+func TestMachineCancel(t *testing.T) {
+	m := shMachine(t)
+	ctx, cancel := context.WithTimeout(t.Context(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := command.Do(ctx, m, "sleep", "30")
+	if !errors.Is(err, command.ErrDeadline) {
+		t.Errorf("command.Do error: %v, want ErrDeadline", err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("command.Do took %v after its deadline", d)
+	}
+}
+
+func TestMachineRedirect(t *testing.T) {
+	m := shMachine(t)
+	ctx := command.WithStdoutFile(t.Context(), "out.txt")
+	err := command.Do(ctx, m, "true")
+	if !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("command.Do error: %v, want ErrUnsupported", err)
+	}
+}
@@ -0,0 +1,204 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"lesiw.io/command/argv"
+)
+
+// An ExecCmd is a command on a machine, prepared and run in the manner of
+// an exec.Cmd, for code written against os/exec. It is created by [Cmd].
+//
+// Its fields, like those of exec.Cmd, must be set before the command
+// starts, and an ExecCmd runs only once.
+type ExecCmd struct {
+	// Args is the command and its arguments.
+	Args []string
+
+	// Env holds environment variables in the form key=value, which are
+	// set over the environment of the context.
+	Env []string
+
+	// Dir is the working directory. If empty, the context's working
+	// directory is used.
+	Dir string
+
+	// Stdin is the command's input. If nil, the command gets no input.
+	Stdin io.Reader
+
+	// Stdout and Stderr receive the command's output and diagnostic
+	// output. If Stdout is nil, output is discarded; if Stderr is nil,
+	// diagnostic output is attached to any returned [Error].
+	Stdout io.Writer
+	Stderr io.Writer
+
+	ctx     context.Context
+	m       Machine
+	merge   bool
+	done    chan struct{}
+	err     error
+	closers []io.Closer // Pipe ends to close once the command is done.
+	readers []*io.PipeReader
+}
+
+// Cmd returns an ExecCmd that runs args on m with ctx, for libraries that
+// accept values shaped like exec.Cmd:
+//
+//	cmd := command.Cmd(ctx, m, "pg_dump", "app")
+//	r, err := cmd.StdoutPipe()
+//	if err != nil {
+//	    return err
+//	}
+//	if err := cmd.Start(); err != nil {
+//	    return err
+//	}
+//	_, err = io.Copy(dst, r)
+//	if werr := cmd.Wait(); err == nil {
+//	    err = werr
+//	}
+func Cmd(ctx context.Context, m Machine, args ...string) *ExecCmd {
+	return &ExecCmd{Args: args, ctx: ctx, m: m}
+}
+
+var (
+	errStarted    = errors.New("command: already started")
+	errNotStarted = errors.New("command: not started")
+	errStdoutSet  = errors.New("command: Stdout already set")
+	errStderrSet  = errors.New("command: Stderr already set")
+	errStdinSet   = errors.New("command: Stdin already set")
+)
+
+// Run starts c and waits for it to complete.
+func (c *ExecCmd) Run() error {
+	if err := c.Start(); err != nil {
+		return err
+	}
+	return c.Wait()
+}
+
+// Start starts c without waiting for it to complete.
+func (c *ExecCmd) Start() error {
+	if c.done != nil {
+		return errStarted
+	}
+	spec := Spec{
+		Args:   c.Args,
+		Dir:    c.Dir,
+		Stdin:  c.Stdin,
+		Stdout: c.Stdout,
+		Stderr: c.Stderr,
+	}
+	if len(c.Env) > 0 {
+		spec.Env = make(map[string]string, len(c.Env))
+		for _, kv := range c.Env {
+			k, v, _ := strings.Cut(kv, "=")
+			spec.Env[k] = v
+		}
+	}
+	ctx := c.ctx
+	if c.merge {
+		ctx = WithMergedStderr(ctx, true)
+	}
+	c.done = make(chan struct{})
+	go func() {
+		defer close(c.done)
+		c.err = Run(ctx, c.m, spec)
+		for _, cl := range c.closers {
+			_ = cl.Close()
+		}
+	}()
+	return nil
+}
+
+// Wait waits for c to complete, and returns its error, if any. Output
+// from pipes must be read before calling Wait, which closes them.
+func (c *ExecCmd) Wait() error {
+	if c.done == nil {
+		return errNotStarted
+	}
+	<-c.done
+	for _, r := range c.readers {
+		_ = r.Close()
+	}
+	return c.err
+}
+
+// Output runs c and returns its output.
+func (c *ExecCmd) Output() ([]byte, error) {
+	if c.Stdout != nil {
+		return nil, errStdoutSet
+	}
+	var out strings.Builder
+	c.Stdout = &out
+	err := c.Run()
+	return []byte(out.String()), withOutput(c.ctx, err, out.String())
+}
+
+// CombinedOutput runs c and returns its output and diagnostic output,
+// merged, as [CombinedOutput] does.
+func (c *ExecCmd) CombinedOutput() ([]byte, error) {
+	if c.Stdout != nil {
+		return nil, errStdoutSet
+	}
+	if c.Stderr != nil {
+		return nil, errStderrSet
+	}
+	out := new(combinedWriter)
+	c.Stdout, c.Stderr, c.merge = out, out, true
+	err := c.Run()
+	return []byte(out.String()), withOutput(c.ctx, err, out.String())
+}
+
+// StdinPipe returns a pipe to c's input, which must be closed for c to
+// see the end of its input.
+func (c *ExecCmd) StdinPipe() (io.WriteCloser, error) {
+	if c.Stdin != nil {
+		return nil, errStdinSet
+	}
+	if c.done != nil {
+		return nil, errStarted
+	}
+	r, w := io.Pipe()
+	c.Stdin = r
+	// Writes after the command is done fail, rather than block.
+	c.closers = append(c.closers, r)
+	return w, nil
+}
+
+// StdoutPipe returns a pipe from c's output.
+func (c *ExecCmd) StdoutPipe() (io.ReadCloser, error) {
+	if c.Stdout != nil {
+		return nil, errStdoutSet
+	}
+	if c.done != nil {
+		return nil, errStarted
+	}
+	r, w := io.Pipe()
+	c.Stdout = w
+	c.closers = append(c.closers, w)
+	c.readers = append(c.readers, r)
+	return r, nil
+}
+
+// StderrPipe returns a pipe from c's diagnostic output.
+func (c *ExecCmd) StderrPipe() (io.ReadCloser, error) {
+	if c.Stderr != nil {
+		return nil, errStderrSet
+	}
+	if c.done != nil {
+		return nil, errStarted
+	}
+	r, w := io.Pipe()
+	c.Stderr = w
+	c.closers = append(c.closers, w)
+	c.readers = append(c.readers, r)
+	return r, nil
+}
+
+// String returns c's arguments, quoted as by argv.Marshal.
+func (c *ExecCmd) String() string {
+	return argv.Marshal(c.Args)
+}
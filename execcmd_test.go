@@ -0,0 +1,135 @@
+package command_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+	"lesiw.io/command/sys"
+)
+
+func TestCmdOutput(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("hello\n"), "echo", "hello")
+	cmd := command.Cmd(t.Context(), m, "echo", "hello")
+	cmd.Env = []string{"A=1"}
+	cmd.Dir = "/src"
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("ExecCmd.Output error: %v", err)
+	}
+	if got, want := string(out), "hello\n"; got != want {
+		t.Errorf("ExecCmd.Output = %q, want %q", got, want)
+	}
+	calls := mock.Calls(m, "echo")
+	if len(calls) != 1 {
+		t.Fatalf("echo calls = %d, want 1", len(calls))
+	}
+	if got := calls[0].Env["A"]; got != "1" {
+		t.Errorf("env A = %q, want %q", got, "1")
+	}
+	if got, want := calls[0].Dir, "/src"; got != want {
+		t.Errorf("dir = %q, want %q", got, want)
+	}
+	if err := cmd.Run(); err == nil {
+		t.Error("second ExecCmd.Run error: nil, want already started")
+	}
+}
+
+func TestCmdPipes(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires cat")
+	}
+	cmd := command.Cmd(t.Context(), sys.Machine(), "cat")
+	w, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatalf("ExecCmd.StdinPipe error: %v", err)
+	}
+	r, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatalf("ExecCmd.StdoutPipe error: %v", err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("ExecCmd.Start error: %v", err)
+	}
+	go func() {
+		_, _ = io.WriteString(w, "data")
+		_ = w.Close()
+	}()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("io.ReadAll error: %v", err)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("ExecCmd.Wait error: %v", err)
+	}
+	if want := "data"; string(got) != want {
+		t.Errorf("read %q, want %q", got, want)
+	}
+}
+
+func TestCmdStdinPipeOpen(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires true")
+	}
+	cmd := command.Cmd(t.Context(), sys.Machine(), "true")
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Wait error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Wait did not return with stdin open")
+	}
+	if _, err := stdin.Write([]byte("late")); err == nil {
+		t.Error("Write after Wait: got nil, want error")
+	}
+}
+
+func TestCmdFailure(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(command.Fail(&command.Error{Code: 2}), "false")
+	cmd := command.Cmd(t.Context(), m, "false")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if e := new(command.Error); !errors.As(err, &e) || e.Code != 2 {
+		t.Errorf("ExecCmd.Run error: %v, want exit status 2", err)
+	}
+}
+
+func TestCmdUsage(t *testing.T) {
+	m := new(mock.Machine)
+	cmd := command.Cmd(t.Context(), m, "true")
+	if err := cmd.Wait(); err == nil {
+		t.Error("ExecCmd.Wait before Start error: nil, want error")
+	}
+	cmd.Stdout = io.Discard
+	if _, err := cmd.StdoutPipe(); err == nil {
+		t.Error("ExecCmd.StdoutPipe with Stdout error: nil, want error")
+	}
+	if _, err := cmd.Output(); err == nil {
+		t.Error("ExecCmd.Output with Stdout error: nil, want error")
+	}
+	if got, want := command.Cmd(t.Context(), m, "echo", "a b").String(),
+		"echo 'a b'"; got != want {
+		t.Errorf("ExecCmd.String = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,94 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Expand returns a Machine that runs commands on m after replacing each
+// ${NAME} in their arguments with the value of the environment variable
+// NAME in the command's context (see [WithEnv]). The environment of the
+// local process and of m are not consulted. A variable that is not set
+// expands to the empty string. $${ is replaced by a literal ${.
+//
+// Only the braced form is expanded, so that arguments containing $ for
+// other reasons, such as regular expressions, pass through unchanged.
+//
+//	m := command.Expand(sys.Machine())
+//	ctx := command.WithEnv(ctx, map[string]string{"TAG": "v1.2.0"})
+//	command.Do(ctx, m, "git", "tag", "${TAG}")
+func Expand(m Machine) Machine {
+	return &expandMachine{m: m}
+}
+
+// ExpandStrict is like [Expand], but a command that refers to a variable
+// that is not set fails without running.
+func ExpandStrict(m Machine) Machine {
+	return &expandMachine{m: m, strict: true}
+}
+
+type expandMachine struct {
+	m      Machine
+	strict bool
+}
+
+func (m *expandMachine) Command(ctx context.Context, arg ...string) Buffer {
+	env := Envs(ctx)
+	args := make([]string, len(arg))
+	for i, a := range arg {
+		var err error
+		if args[i], err = expand(a, env, m.strict); err != nil {
+			return Fail(fmt.Errorf("expand argument %d: %w", i, err))
+		}
+	}
+	return m.m.Command(ctx, args...)
+}
+
+func expand(s string, env map[string]string, strict bool) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			break
+		}
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i] + "{") // s[i-1] is the first $.
+			s = s[i+2:]
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated ${ in %q", s)
+		}
+		name := s[i+2 : i+end]
+		if !validName(name) {
+			return "", fmt.Errorf("bad variable name %q", name)
+		}
+		val, ok := env[name]
+		if !ok && strict {
+			return "", fmt.Errorf("variable %s is not set", name)
+		}
+		b.WriteString(s[:i])
+		b.WriteString(val)
+		s = s[i+end+1:]
+	}
+	b.WriteString(s)
+	return b.String(), nil
+}
+
+func validName(name string) bool {
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && (r < 'a' || r > 'z') && (r < 'A' || r > 'Z') &&
+			(r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+package command_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+)
+
+func TestExpand(t *testing.T) {
+	ctx, m := t.Context(), new(mock.Machine)
+	ctx = command.WithEnv(ctx, map[string]string{"TAG": "v1", "DIR": "/a b"})
+	t.Setenv("HOME", "/home/gopher")
+
+	err := command.Do(ctx, command.Expand(m), "echo",
+		"${TAG}", "${DIR}/x", "$${TAG}", "$TAG", "${HOME}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	calls := mock.Calls(m, "echo")
+	if len(calls) != 1 {
+		t.Fatalf("got %d calls, want 1", len(calls))
+	}
+	want := []string{"echo", "v1", "/a b/x", "${TAG}", "$TAG", ""}
+	if got := calls[0].Args; !cmp.Equal(want, got) {
+		t.Errorf("args (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestExpandStrict(t *testing.T) {
+	ctx, m := t.Context(), new(mock.Machine)
+
+	err := command.Do(ctx, command.ExpandStrict(m), "echo", "${MISSING}")
+
+	if err == nil {
+		t.Fatal("err = nil, want error")
+	}
+	if n := len(mock.Calls(m, "echo")); n != 0 {
+		t.Errorf("got %d calls, want 0", n)
+	}
+}
@@ -0,0 +1,85 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+type allowedCodesKey struct{}
+
+// WithAllowedExitCodes returns a context in which commands that exit with
+// any of codes succeed, as if they had exited with status 0.
+//
+// It suits commands whose nonzero exits carry meaning rather than
+// failure, like grep, diff, or systemctl is-active. The codes apply to
+// every command run with the returned context, by [Run] and its
+// shorthands or as the buffers of [NewReader], [NewWriter], and
+// [NewFilter], whose reads then end at EOF, so scope it to the call that
+// needs it:
+//
+//	err := command.Do(command.WithAllowedExitCodes(ctx, 1), m,
+//	    "grep", "-q", "^user:", "/etc/passwd",
+//	)
+//
+// To learn which code a command exited with, use [DoExpect]. Calling
+// WithAllowedExitCodes with no codes clears them, as
+// [WithoutCommandOptions] does for the commands that machines and helpers
+// run for themselves.
+func WithAllowedExitCodes(ctx context.Context, codes ...int) context.Context {
+	return context.WithValue(ctx, allowedCodesKey{}, slices.Clone(codes))
+}
+
+// allowed reports whether err is an exit with a code allowed by ctx.
+func allowed(ctx context.Context, err error) bool {
+	return exitAllowed(allowedCodes(ctx), err)
+}
+
+// allowedCodes returns the exit codes allowed by ctx.
+func allowedCodes(ctx context.Context) []int {
+	codes, _ := ctx.Value(allowedCodesKey{}).([]int)
+	return codes
+}
+
+// exitAllowed reports whether err is an exit with one of codes.
+func exitAllowed(codes []int, err error) bool {
+	if len(codes) == 0 {
+		return false
+	}
+	e := new(Error)
+	return errors.As(err, &e) && e.Code != 0 && slices.Contains(codes, e.Code)
+}
+
+// DoExpect executes a command for its side effects and returns its exit
+// code. It returns an error if the command exits with a code not in
+// codes, or if it fails to run at all.
+//
+//	code, err := command.DoExpect(ctx, m, []int{0, 1},
+//	    "diff", "-q", "old.txt", "new.txt",
+//	)
+//	if err != nil {
+//	    return err
+//	}
+//	changed := code == 1
+func DoExpect(
+	ctx context.Context, m Machine, codes []int, args ...string,
+) (int, error) {
+	ctx = context.WithValue(ctx, allowedCodesKey{}, nil)
+	err := Do(ctx, m, args...)
+	code := 0
+	if err != nil {
+		e := new(Error)
+		if !errors.As(err, &e) || e.Code == 0 {
+			return 0, err
+		}
+		code = e.Code
+	}
+	if !slices.Contains(codes, code) {
+		if err == nil {
+			err = fmt.Errorf("exit status 0, want one of %v", codes)
+		}
+		return code, err
+	}
+	return code, nil
+}
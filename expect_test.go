@@ -0,0 +1,108 @@
+package command_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+)
+
+func TestDoExpect(t *testing.T) {
+	tests := []struct {
+		name    string
+		ret     error
+		codes   []int
+		want    int
+		wantErr bool
+	}{
+		{"success", nil, []int{0, 1}, 0, false},
+		{"allowed", &command.Error{Code: 1}, []int{0, 1}, 1, false},
+		{"disallowed", &command.Error{Code: 2}, []int{0, 1}, 2, true},
+		{"unexpected success", nil, []int{1}, 0, true},
+		{"missing", &command.Error{Err: errors.New("no")}, []int{0}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := new(mock.Machine)
+			if tt.ret != nil {
+				m.Return(command.Fail(tt.ret), "grep")
+			} else {
+				m.Return(strings.NewReader(""), "grep")
+			}
+
+			got, err := command.DoExpect(t.Context(), m, tt.codes,
+				"grep", "-q", "x", "file")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("command.DoExpect error: got %v, want error: %v",
+					err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("command.DoExpect = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDoExpectIgnoresAllowedExitCodes(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(command.Fail(&command.Error{Code: 2}), "diff")
+	ctx := command.WithAllowedExitCodes(t.Context(), 2)
+
+	code, err := command.DoExpect(ctx, m, []int{0}, "diff", "a", "b")
+	if err == nil {
+		t.Error("command.DoExpect error: got nil, want error")
+	}
+	if code != 2 {
+		t.Errorf("command.DoExpect = %d, want 2", code)
+	}
+}
+
+func TestWithAllowedExitCodes(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(command.Fail(&command.Error{Code: 1}), "grep")
+	m.Return(command.Fail(&command.Error{Code: 2}), "diff")
+	ctx := command.WithAllowedExitCodes(t.Context(), 1)
+
+	if err := command.Do(ctx, m, "grep", "-q", "x", "file"); err != nil {
+		t.Errorf("command.Do(grep) error: got %v, want <nil>", err)
+	}
+	if err := command.Do(ctx, m, "diff", "a", "b"); err == nil {
+		t.Error("command.Do(diff) error: got <nil>, want error")
+	}
+	if err := command.Do(t.Context(), m, "grep"); err == nil {
+		t.Error("command.Do(grep) without codes: got <nil>, want error")
+	}
+}
+
+func TestAllowedExitCodesPipeline(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(io.MultiReader(strings.NewReader("b\na\n"),
+		command.Fail(&command.Error{Code: 1})), "grep")
+	m.Return(io.MultiReader(strings.NewReader("a\nb\n"),
+		command.Fail(&command.Error{Code: 1})), "sort")
+	m.Return(command.Fail(&command.Error{Code: 1}), "diff")
+	ctx := command.WithAllowedExitCodes(t.Context(), 1)
+
+	var out strings.Builder
+	_, err := command.Copy(&out,
+		command.NewReader(ctx, m, "grep", "x", "file"),
+		command.NewFilter(ctx, m, "sort"),
+	)
+	if err != nil {
+		t.Errorf("command.Copy error: %v", err)
+	}
+	if got, want := out.String(), "a\nb\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	w := command.NewWriter(ctx, m, "diff", "-", "file")
+	if _, err := io.WriteString(w, "data"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("Close error: %v", err)
+	}
+}
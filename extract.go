@@ -0,0 +1,304 @@
+package command
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"lesiw.io/fs"
+	"lesiw.io/fs/path"
+)
+
+type archiveKind int
+
+const (
+	archiveTar archiveKind = iota
+	archiveTarGz
+	archiveTarZst
+	archiveZip
+)
+
+func archiveKindOf(name string) (archiveKind, error) {
+	lower := strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz"),
+		strings.HasSuffix(lower, ".tgz"):
+		return archiveTarGz, nil
+	case strings.HasSuffix(lower, ".tar.zst"),
+		strings.HasSuffix(lower, ".tzst"):
+		return archiveTarZst, nil
+	case strings.HasSuffix(lower, ".tar"):
+		return archiveTar, nil
+	case strings.HasSuffix(lower, ".zip"):
+		return archiveZip, nil
+	}
+	return 0, fmt.Errorf("unknown archive format: %s", path.Base(name))
+}
+
+// Extract extracts the archive at src on m into the directory dst on m,
+// creating dst if needed. The format is chosen by src's extension:
+// .tar, .tar.gz or .tgz, .tar.zst or .tzst, and .zip.
+//
+// Extract runs the archiver found on m: tar, unzip, or PowerShell on
+// Windows, with zstd for zstd-compressed archives. If m has none, the
+// archive is streamed to the local process, unpacked, and written to
+// dst as a tar stream through m's filesystem. The local fallback rejects
+// entries whose names or link targets would escape dst, and entries
+// inside symbolic links. It does not support zstd: a .tar.zst archive
+// fails with an error wrapping errors.ErrUnsupported if m lacks tar or
+// zstd.
+//
+//	err := command.Extract(ctx, m, "/tmp/go.tar.gz", "/usr/local")
+func Extract(ctx context.Context, m Machine, src, dst string) error {
+	kind, err := archiveKindOf(src)
+	if err != nil {
+		return fmt.Errorf("extract %s: %w", src, err)
+	}
+	ctx = WithoutCommandOptions(ctx)
+	m = Unshell(m)
+	fsys := FS(m)
+	if err := fs.MkdirAll(ctx, fsys, dst); err != nil {
+		return fmt.Errorf("extract %s: %w", src, err)
+	}
+	err = unarchive(ctx, m, kind, src, dst)
+	if Missing(err) {
+		err = unarchiveLocal(ctx, fsys, kind, src, dst)
+	}
+	if err != nil {
+		return fmt.Errorf("extract %s: %w", src, err)
+	}
+	return nil
+}
+
+// unarchive extracts src into dst with tools on m.
+// It returns an error satisfying [Missing] if m lacks the tools.
+func unarchive(
+	ctx context.Context, m Machine, kind archiveKind, src, dst string,
+) error {
+	if OS(ctx, m) == "windows" && kind == archiveZip {
+		return psDo(ctx, m,
+			`Expand-Archive -Force -LiteralPath '%s' -DestinationPath '%s'`,
+			psEscape(src), psEscape(dst))
+	}
+	switch kind {
+	case archiveTar:
+		return Do(ctx, m, "tar", "-xf", src, "-C", dst)
+	case archiveTarGz:
+		return Do(ctx, m, "tar", "-xzf", src, "-C", dst)
+	case archiveTarZst:
+		_, err := Copy(
+			NewWriter(ctx, m, "tar", "-xf", "-", "-C", dst),
+			NewReader(ctx, m, "zstd", "-dc", src),
+		)
+		return err
+	default:
+		return Do(ctx, m, "unzip", "-q", "-o", src, "-d", dst)
+	}
+}
+
+// unarchiveLocal reads src from fsys, unpacks it in the local process,
+// and writes its contents to dst as a tar stream.
+func unarchiveLocal(
+	ctx context.Context, fsys fs.FS, kind archiveKind, src, dst string,
+) error {
+	in := fs.OpenBuffer(ctx, fsys, src)
+	out := fs.CreateBuffer(ctx, fsys, dirName(dst))
+	var err error
+	switch kind {
+	case archiveTar:
+		err = cleanTar(out, in)
+	case archiveTarGz:
+		var zr *gzip.Reader
+		if zr, err = gzip.NewReader(in); err == nil {
+			err = cleanTar(out, zr)
+		}
+	case archiveTarZst:
+		err = fmt.Errorf("zstd archives need tar and zstd on the machine: %w",
+			errors.ErrUnsupported)
+	case archiveZip:
+		err = zipToTar(out, in)
+	}
+	return errors.Join(err, out.Close())
+}
+
+// zipToTar converts the zip archive read from r to a tar stream on w.
+func zipToTar(w io.Writer, r io.Reader) error {
+	data, err := io.ReadAll(r) // Zip archives need random access.
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(w)
+	links := make(linkGuard)
+	for _, f := range zr.File {
+		var target string
+		if f.Mode()&fs.ModeSymlink != 0 {
+			// A zip archive stores the target as the link's content.
+			if target, err = zipLinkTarget(f); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(f.FileInfo(), target)
+		if err != nil {
+			return err
+		}
+		hdr.Name = f.Name
+		if err := links.clean(hdr); err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, rc)
+		if err = errors.Join(err, rc.Close()); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// zipLinkTarget returns the target of the symbolic link f.
+func zipLinkTarget(f *zip.File) (string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return "", err
+	}
+	target, err := io.ReadAll(io.LimitReader(rc, 4096))
+	if err = errors.Join(err, rc.Close()); err != nil {
+		return "", err
+	}
+	return string(target), nil
+}
+
+// cleanTar copies the tar stream read from r to w, with entries made safe
+// by [linkGuard.clean].
+func cleanTar(w io.Writer, r io.Reader) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	links := make(linkGuard)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return tw.Close()
+		} else if err != nil {
+			return err
+		}
+		if err := links.clean(hdr); err != nil {
+			return err
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// linkGuard makes the entries of an archive safe to extract, keeping
+// the symbolic links it has seen so that no later entry is written
+// through one.
+type linkGuard map[string]bool
+
+// clean makes the name of hdr safe with [entryName], and rejects links
+// that point outside of the directory the archive is extracted into.
+func (g linkGuard) clean(hdr *tar.Header) (err error) {
+	if hdr.Name, err = entryName(hdr.Name); err != nil {
+		return err
+	}
+	if g.under(hdr.Name) {
+		return fmt.Errorf("entry under symbolic link in archive: %q",
+			hdr.Name)
+	}
+	switch hdr.Typeflag {
+	case tar.TypeLink:
+		// Hard links name their target from the top of the archive.
+		if hdr.Linkname, err = entryName(hdr.Linkname); err != nil {
+			return err
+		}
+		if g.under(hdr.Linkname) {
+			return fmt.Errorf("link under symbolic link in archive: %q",
+				hdr.Name)
+		}
+	case tar.TypeSymlink:
+		target := strings.ReplaceAll(hdr.Linkname, `\`, "/")
+		if target == "" || target[0] == '/' || hasDrive(target) ||
+			escapes(path.Dir(hdr.Name), target) {
+			return fmt.Errorf("unsafe link in archive: %q -> %q",
+				hdr.Name, hdr.Linkname)
+		}
+		g[hdr.Name] = true
+	}
+	return nil
+}
+
+// under reports whether name is inside one of the symbolic links in g.
+func (g linkGuard) under(name string) bool {
+	for i := range len(name) {
+		if name[i] == '/' && g[name[:i]] {
+			return true
+		}
+	}
+	return false
+}
+
+// escapes reports whether target, a relative path from dir, leads out of
+// the top of the archive.
+func escapes(dir, target string) bool {
+	var depth int
+	for elem := range strings.SplitSeq(dir+"/"+target, "/") {
+		switch elem {
+		case "", ".":
+		case "..":
+			if depth--; depth < 0 {
+				return true
+			}
+		default:
+			depth++
+		}
+	}
+	return false
+}
+
+// hasDrive reports whether name starts with a Windows drive letter.
+func hasDrive(name string) bool {
+	return len(name) >= 2 && name[1] == ':' &&
+		('a' <= name[0]|0x20 && name[0]|0x20 <= 'z')
+}
+
+// entryName returns the name of an archive entry relative to the
+// directory it is extracted into. As tar does, it strips leading slashes
+// and drive letters; names with .. elements, which could escape the
+// directory, are an error.
+func entryName(name string) (string, error) {
+	clean := strings.ReplaceAll(name, `\`, "/")
+	if hasDrive(clean) {
+		clean = clean[2:]
+	}
+	clean = strings.TrimLeft(clean, "/")
+	for elem := range strings.SplitSeq(clean, "/") {
+		if elem == ".." {
+			return "", fmt.Errorf("unsafe entry name in archive: %q", name)
+		}
+	}
+	if clean == "" {
+		clean = "."
+	}
+	return clean, nil
+}
@@ -0,0 +1,291 @@
+package command_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mem"
+	"lesiw.io/command/mock"
+	"lesiw.io/fs"
+)
+
+var archiveFiles = map[string]string{
+	"bin/tool":   "#!/bin/sh\n",
+	"README.txt": "hello\n",
+}
+
+func tarArchive(t *testing.T, w io.Writer) {
+	t.Helper()
+	tw := tar.NewWriter(w)
+	for name, data := range archiveFiles {
+		err := tw.WriteHeader(&tar.Header{
+			Name: name, Mode: 0644, Size: int64(len(data)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tgzArchive(t *testing.T) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tarArchive(t, zw)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zipArchive(t *testing.T) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, data := range archiveFiles {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtract(t *testing.T) {
+	tests := []struct {
+		name string
+		data func(*testing.T) []byte
+	}{
+		{"pkg.tar.gz", tgzArchive},
+		{"pkg.zip", zipArchive},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, m := t.Context(), mem.Machine()
+			fsys := command.FS(m)
+			err := fs.WriteFile(ctx, fsys, tt.name, tt.data(t))
+			if err != nil {
+				t.Fatalf("fs.WriteFile error: %v", err)
+			}
+
+			if err := command.Extract(ctx, m, tt.name, "out"); err != nil {
+				t.Fatalf("command.Extract error: %v", err)
+			}
+
+			for name, want := range archiveFiles {
+				got, err := fs.ReadFile(ctx, fsys, "out/"+name)
+				if err != nil {
+					t.Errorf("fs.ReadFile(%q) error: %v", name, err)
+				} else if string(got) != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractToolExit127(t *testing.T) {
+	ctx, m := t.Context(), new(mock.Machine)
+	m.SetOS("linux")
+	m.Return(command.Fail(&command.Error{Code: 127}), "tar")
+	fsys := command.FS(m)
+	err := fs.WriteFile(ctx, fsys, "pkg.tar.gz", tgzArchive(t))
+	if err != nil {
+		t.Fatalf("fs.WriteFile error: %v", err)
+	}
+
+	if err := command.Extract(ctx, m, "pkg.tar.gz", "out"); err != nil {
+		t.Fatalf("command.Extract error: %v", err)
+	}
+
+	for name, want := range archiveFiles {
+		got, err := fs.ReadFile(ctx, fsys, "out/"+name)
+		if err != nil {
+			t.Errorf("fs.ReadFile(%q) error: %v", name, err)
+		} else if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestExtractUnknownFormat(t *testing.T) {
+	err := command.Extract(t.Context(), mem.Machine(), "pkg.rar", "out")
+	if err == nil {
+		t.Error("command.Extract error: got nil, want error")
+	}
+}
+
+func TestExtractUnsafeNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		entry string
+		want  string // The extracted file, or "" for an error.
+	}{
+		{"pkg.tar", "../evil.txt", ""},
+		{"pkg.tar", "/abs.txt", "out/abs.txt"},
+		{"pkg.tar", "a/../../evil.txt", ""},
+		{"pkg.zip", "../evil.txt", ""},
+		{"pkg.zip", "/abs.txt", "out/abs.txt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name+"/"+tt.entry, func(t *testing.T) {
+			ctx, m := t.Context(), mem.Machine()
+			fsys := command.FS(m)
+			data := singleEntry(t, tt.name, tt.entry)
+			if err := fs.WriteFile(ctx, fsys, tt.name, data); err != nil {
+				t.Fatalf("fs.WriteFile error: %v", err)
+			}
+
+			err := command.Extract(ctx, m, tt.name, "out")
+
+			if tt.want == "" {
+				if err == nil {
+					t.Error("command.Extract error: got nil, want error")
+				}
+				if _, err := fs.Stat(ctx, fsys, "evil.txt"); err == nil {
+					t.Error("evil.txt was extracted outside of out")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("command.Extract error: %v", err)
+			}
+			if got, err := fs.ReadFile(ctx, fsys, tt.want); err != nil {
+				t.Errorf("fs.ReadFile(%q) error: %v", tt.want, err)
+			} else if string(got) != "x" {
+				t.Errorf("%s = %q, want %q", tt.want, got, "x")
+			}
+		})
+	}
+}
+
+func TestExtractUnsafeLinks(t *testing.T) {
+	sym := func(name, target string) *tar.Header {
+		return &tar.Header{
+			Typeflag: tar.TypeSymlink, Name: name, Linkname: target,
+		}
+	}
+	tests := []struct {
+		name    string
+		entries []*tar.Header
+	}{
+		{"symlink up", []*tar.Header{sym("l", "../evil.txt")}},
+		{"symlink deep", []*tar.Header{sym("a/l", "../../evil.txt")}},
+		{"symlink abs", []*tar.Header{sym("l", "/etc/passwd")}},
+		{"symlink drive", []*tar.Header{sym("l", `C:\Windows`)}},
+		{"hard link", []*tar.Header{{
+			Typeflag: tar.TypeLink, Name: "h", Linkname: "../evil.txt",
+		}}},
+		{"through symlink", []*tar.Header{
+			sym("d", "."),
+			sym("d/l", ".."),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, m := t.Context(), mem.Machine()
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			for _, hdr := range tt.entries {
+				if err := tw.WriteHeader(hdr); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := tw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			err := fs.WriteFile(ctx, command.FS(m), "pkg.tar", buf.Bytes())
+			if err != nil {
+				t.Fatalf("fs.WriteFile error: %v", err)
+			}
+
+			err = command.Extract(ctx, m, "pkg.tar", "out")
+
+			if err == nil {
+				t.Error("command.Extract error: got nil, want error")
+			}
+		})
+	}
+}
+
+func TestExtractUnsafeZipLink(t *testing.T) {
+	ctx, m := t.Context(), mem.Machine()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	hdr := &zip.FileHeader{Name: "l"}
+	hdr.SetMode(fs.ModeSymlink | 0777)
+	w, err := zw.CreateHeader(hdr)
+	if err == nil {
+		_, err = io.WriteString(w, "../evil.txt")
+	}
+	if err = errors.Join(err, zw.Close()); err != nil {
+		t.Fatal(err)
+	}
+	err = fs.WriteFile(ctx, command.FS(m), "pkg.zip", buf.Bytes())
+	if err != nil {
+		t.Fatalf("fs.WriteFile error: %v", err)
+	}
+
+	err = command.Extract(ctx, m, "pkg.zip", "out")
+
+	if err == nil {
+		t.Error("command.Extract error: got nil, want error")
+	}
+}
+
+// singleEntry returns an archive of the format of name that holds one
+// file, called entry, whose content is "x".
+func singleEntry(t *testing.T, name, entry string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if strings.HasSuffix(name, ".zip") {
+		zw := zip.NewWriter(&buf)
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: entry})
+		if err == nil {
+			_, err = io.WriteString(w, "x")
+		}
+		if err = errors.Join(err, zw.Close()); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	tw := tar.NewWriter(&buf)
+	err := tw.WriteHeader(&tar.Header{Name: entry, Mode: 0644, Size: 1})
+	if err == nil {
+		_, err = io.WriteString(tw, "x")
+	}
+	if err = errors.Join(err, tw.Close()); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractZstdUnsupported(t *testing.T) {
+	ctx, m := t.Context(), mem.Machine()
+	err := fs.WriteFile(ctx, command.FS(m), "pkg.tar.zst", nil)
+	if err != nil {
+		t.Fatalf("fs.WriteFile error: %v", err)
+	}
+
+	err = command.Extract(ctx, m, "pkg.tar.zst", "out")
+	if !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("command.Extract error: got %v, want ErrUnsupported", err)
+	}
+}
@@ -0,0 +1,153 @@
+package command
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+
+	"lesiw.io/fs"
+)
+
+// A Digest is the expected checksum of a file, for [Fetch].
+type Digest struct {
+	alg string
+	sum string
+	new func() hash.Hash
+}
+
+// SHA256 returns a Digest for the hex-encoded SHA-256 checksum sum.
+func SHA256(sum string) Digest {
+	return Digest{"sha256", strings.ToLower(sum), sha256.New}
+}
+
+func (d Digest) String() string { return d.alg + ":" + d.sum }
+
+// Fetch downloads url to the file dst on m, and verifies that the file
+// matches digest. Fetch fails without a digest.
+//
+// The download runs on m with the first downloader found there: curl or
+// wget, or PowerShell on Windows. If m has none, the local process
+// downloads url and streams it to m through its filesystem.
+// The file is downloaded beside dst and renamed into place only after it
+// is verified, so dst is never left holding unverified content.
+//
+//	err := command.Fetch(ctx, m,
+//	    "https://go.dev/dl/go1.24.7.linux-amd64.tar.gz", "/tmp/go.tar.gz",
+//	    command.SHA256(goSHA256),
+//	)
+func Fetch(
+	ctx context.Context, m Machine, url, dst string, digest Digest,
+) error {
+	if digest.new == nil {
+		return fmt.Errorf("fetch %s: no digest", url)
+	}
+	ctx = WithoutCommandOptions(ctx)
+	m = Unshell(m)
+	fsys := FS(m)
+	part := dst + ".part"
+
+	err := download(ctx, m, url, part)
+	if Missing(err) {
+		err = stream(ctx, fsys, url, part)
+	}
+	if err == nil {
+		err = verify(ctx, m, part, digest)
+	}
+	if err == nil {
+		err = fs.Rename(ctx, fsys, part, dst)
+	}
+	if err != nil {
+		_ = fs.Remove(ctx, fsys, part) // Best effort.
+		return fmt.Errorf("fetch %s: %w", url, err)
+	}
+	return nil
+}
+
+// download downloads url to dst with a tool on m.
+// It returns an error satisfying [Missing] if m has no downloader.
+func download(ctx context.Context, m Machine, url, dst string) error {
+	if OS(ctx, m) == "windows" {
+		return psDo(ctx, m, psScript(
+			`$ProgressPreference = 'SilentlyContinue'`,
+			`Invoke-WebRequest -UseBasicParsing -Uri '%s' -OutFile '%s'`,
+		), psEscape(url), psEscape(dst))
+	}
+	err := Do(ctx, m, "curl", "-fsSL", "-o", dst, url)
+	if Missing(err) {
+		err = Do(ctx, m, "wget", "-q", "-O", dst, url)
+	}
+	return err
+}
+
+// stream downloads url in the local process and writes it to dst.
+func stream(ctx context.Context, fsys fs.FS, url, dst string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad status: %s", resp.Status)
+	}
+	w := fs.CreateBuffer(ctx, fsys, dst)
+	_, err = io.Copy(w, resp.Body)
+	return errors.Join(err, w.Close())
+}
+
+// verify checks the file name on m against digest.
+// It hashes on m when a hashing tool is available, and otherwise reads
+// the file back to hash it locally.
+func verify(ctx context.Context, m Machine, name string, d Digest) error {
+	sum, err := remoteSum(ctx, m, name, d)
+	if Missing(err) {
+		h := d.new()
+		_, err = io.Copy(h, fs.OpenBuffer(ctx, FS(m), name))
+		sum = hex.EncodeToString(h.Sum(nil))
+	}
+	if err != nil {
+		return err
+	}
+	if sum != d.sum {
+		return fmt.Errorf("checksum mismatch: got %s:%s, want %s",
+			d.alg, sum, d)
+	}
+	return nil
+}
+
+func remoteSum(
+	ctx context.Context, m Machine, name string, d Digest,
+) (string, error) {
+	var (
+		out string
+		err error
+	)
+	if OS(ctx, m) == "windows" {
+		out, err = psRead(ctx, m,
+			`(Get-FileHash -Algorithm %s -LiteralPath '%s').Hash`,
+			strings.ToUpper(d.alg), psEscape(name))
+	} else {
+		bits := strings.TrimPrefix(d.alg, "sha")
+		out, err = Read(ctx, m, d.alg+"sum", name)
+		if Missing(err) {
+			out, err = Read(ctx, m, "shasum", "-a", bits, name)
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	sum, _, _ := strings.Cut(strings.TrimSpace(out), " ")
+	return strings.ToLower(sum), nil
+}
+
+// psEscape escapes s for use in a PowerShell single-quoted string.
+func psEscape(s string) string { return strings.ReplaceAll(s, "'", "''") }
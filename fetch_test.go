@@ -0,0 +1,107 @@
+package command_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mem"
+	"lesiw.io/command/mock"
+	"lesiw.io/fs"
+)
+
+func fetchServer(t *testing.T, body string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(body))
+		},
+	))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetch(t *testing.T) {
+	ctx, m := t.Context(), mem.Machine()
+	srv := fetchServer(t, "payload")
+	sum := sha256.Sum256([]byte("payload"))
+
+	err := command.Fetch(ctx, m, srv.URL, "file.bin",
+		command.SHA256(hex.EncodeToString(sum[:])))
+	if err != nil {
+		t.Fatalf("command.Fetch error: %v", err)
+	}
+
+	got, err := fs.ReadFile(ctx, command.FS(m), "file.bin")
+	if err != nil {
+		t.Fatalf("fs.ReadFile error: %v", err)
+	}
+	if want := "payload"; string(got) != want {
+		t.Errorf("file.bin = %q, want %q", got, want)
+	}
+}
+
+func TestFetchChecksumMismatch(t *testing.T) {
+	ctx, m := t.Context(), mem.Machine()
+	srv := fetchServer(t, "tampered")
+	sum := sha256.Sum256([]byte("payload"))
+
+	err := command.Fetch(ctx, m, srv.URL, "file.bin",
+		command.SHA256(hex.EncodeToString(sum[:])))
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("command.Fetch error: got %v, want checksum mismatch", err)
+	}
+
+	for _, name := range []string{"file.bin", "file.bin.part"} {
+		_, err := fs.Stat(ctx, command.FS(m), name)
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("fs.Stat(%q) error: got %v, want ErrNotExist", name, err)
+		}
+	}
+}
+
+func TestFetchNoDigest(t *testing.T) {
+	err := command.Fetch(t.Context(), mem.Machine(),
+		"https://example.com/file", "file", command.Digest{})
+	if err == nil {
+		t.Error("command.Fetch error: got nil, want error")
+	}
+}
+
+func TestFetchToolsExit127(t *testing.T) {
+	ctx, m := t.Context(), new(mock.Machine)
+	m.SetOS("linux")
+	for _, tool := range []string{"curl", "wget", "sha256sum", "shasum"} {
+		m.Return(command.Fail(&command.Error{Code: 127}), tool)
+	}
+	srv := fetchServer(t, "payload")
+	sum := sha256.Sum256([]byte("payload"))
+
+	err := command.Fetch(ctx, m, srv.URL, "file.bin",
+		command.SHA256(hex.EncodeToString(sum[:])))
+	if err != nil {
+		t.Fatalf("command.Fetch error: %v", err)
+	}
+
+	got, err := fs.ReadFile(ctx, command.FS(m), "file.bin")
+	if err != nil {
+		t.Fatalf("fs.ReadFile error: %v", err)
+	}
+	if want := "payload"; string(got) != want {
+		t.Errorf("file.bin = %q, want %q", got, want)
+	}
+	var tools []string
+	for _, call := range m.Calls {
+		tools = append(tools, call.Args[0])
+	}
+	want := []string{"curl", "wget", "sha256sum", "shasum"}
+	if !slices.Equal(tools, want) {
+		t.Errorf("commands = %q, want %q", tools, want)
+	}
+}
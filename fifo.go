@@ -0,0 +1,78 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+
+	"lesiw.io/fs"
+)
+
+// A FIFO is a named pipe on a machine. It lets commands that insist on
+// reading or writing a file, rather than standard input or output, take
+// part in streaming pipelines without storing the whole stream in a
+// temporary file.
+//
+//	f, err := command.NewFIFO(ctx, m)
+//	if err != nil {
+//	    return err
+//	}
+//	defer f.Remove(ctx)
+//	go func() {
+//	    errc <- command.Do(ctx, m, "pg_dump", "-f", f.Path, "app")
+//	}()
+//	r := f.Reader(ctx)
+//	defer r.Close()
+//	_, err = io.Copy(dst, r)
+//
+// Opening a FIFO blocks until it has both a reader and a writer, so the
+// command on the other end must run concurrently.
+type FIFO struct {
+	// Path is the path of the FIFO on its machine.
+	Path string
+
+	m   Machine
+	dir string
+}
+
+// NewFIFO creates a FIFO in a new temporary directory on m, with mkfifo.
+// The caller is responsible for removing it with [FIFO.Remove].
+//
+// FIFOs are not supported on Windows.
+func NewFIFO(ctx context.Context, m Machine) (*FIFO, error) {
+	if OS(ctx, m) == "windows" {
+		return nil, fmt.Errorf("failed to create fifo: %w",
+			errors.ErrUnsupported)
+	}
+	ctx = WithoutCommandOptions(ctx)
+	fsys := FS(m)
+	dir, err := mkTempDir(ctx, fsys, "command-fifo")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create fifo: %w", err)
+	}
+	f := &FIFO{Path: path.Join(dir, "fifo"), m: m, dir: dir}
+	if err := Do(ctx, m, "mkfifo", "-m", "600", f.Path); err != nil {
+		_ = fs.RemoveAll(ctx, fsys, dir) // Best effort.
+		return nil, fmt.Errorf("failed to create fifo: %w", err)
+	}
+	return f, nil
+}
+
+// Reader returns a reader of the data written to f, by the command on the
+// other end, until that command closes it.
+func (f *FIFO) Reader(ctx context.Context) io.ReadCloser {
+	return NewReader(ctx, f.m, "cat", f.Path)
+}
+
+// Writer returns a writer to f, for the command on the other end to read.
+// Closing it gives that command the end of its input.
+func (f *FIFO) Writer(ctx context.Context) io.WriteCloser {
+	return NewWriter(ctx, f.m, "sh", "-c", `cat > "$1"`, "sh", f.Path)
+}
+
+// Remove removes f and its directory.
+func (f *FIFO) Remove(ctx context.Context) error {
+	return fs.RemoveAll(WithoutCommandOptions(ctx), FS(f.m), f.dir)
+}
@@ -0,0 +1,93 @@
+package command_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+	"lesiw.io/command/sys"
+)
+
+func newTestFIFO(t *testing.T) *command.FIFO {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("requires fifos")
+	}
+	if _, err := exec.LookPath("mkfifo"); err != nil {
+		t.Skip("requires mkfifo")
+	}
+	f, err := command.NewFIFO(t.Context(), sys.Machine())
+	if err != nil {
+		t.Fatalf("command.NewFIFO error: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := f.Remove(t.Context()); err != nil {
+			t.Errorf("FIFO.Remove error: %v", err)
+		}
+		if _, err := os.Stat(f.Path); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("os.Stat(%q) error: %v, want not exist", f.Path, err)
+		}
+	})
+	return f
+}
+
+func TestFIFOReader(t *testing.T) {
+	ctx, f := t.Context(), newTestFIFO(t)
+	errc := make(chan error, 1)
+	go func() {
+		errc <- command.Do(ctx, sys.Machine(),
+			"sh", "-c", `printf hello > "$1"`, "sh", f.Path)
+	}()
+
+	r := f.Reader(ctx)
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("io.ReadAll error: %v", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("Reader.Close error: %v", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+	if want := "hello"; string(got) != want {
+		t.Errorf("read %q, want %q", got, want)
+	}
+}
+
+func TestFIFOWriter(t *testing.T) {
+	ctx, f := t.Context(), newTestFIFO(t)
+	outc := make(chan string, 1)
+	go func() {
+		out, _ := command.Read(ctx, sys.Machine(),
+			"sh", "-c", `tr a-z A-Z < "$1"`, "sh", f.Path)
+		outc <- out
+	}()
+
+	w := f.Writer(ctx)
+	if _, err := io.Copy(w, strings.NewReader("hello")); err != nil {
+		t.Fatalf("io.Copy error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Writer.Close error: %v", err)
+	}
+	if got, want := <-outc, "HELLO"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestFIFOWindows(t *testing.T) {
+	m := new(mock.Machine)
+	m.SetOS("windows")
+
+	_, err := command.NewFIFO(t.Context(), m)
+	if !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("command.NewFIFO error: got %v, want ErrUnsupported", err)
+	}
+}
@@ -3,6 +3,8 @@ package command
 import (
 	"context"
 	"io"
+	"sync"
+	"time"
 )
 
 // NewFilter creates a bidirectional command filter with full
@@ -21,19 +23,61 @@ import (
 func NewFilter(
 	ctx context.Context, m Machine, args ...string,
 ) io.ReadWriteCloser {
-	buf := m.Command(ctx, args...)
-	return &filter{buf: buf}
+	ctx, cancel := context.WithCancel(ctx)
+	buf := newCommand(ctx, m, args...)
+	_, writable := buf.(WriteBuffer)
+	return &filter{
+		buf:    buf,
+		cancel: cancel,
+		leak:   track(m, args),
+		stall:  stallTimeout(ctx),
+		codes:  allowedCodes(ctx),
+		closed: !writable, // A read-only command has no input to close.
+	}
 }
 
 // Deprecated: Use NewFilter instead.
 var NewStream = NewFilter
 
 type filter struct {
-	buf Buffer
+	buf    Buffer
+	cancel context.CancelFunc
+	leak   *leak
+	stall  time.Duration
+	codes  []int // Exit codes that end the output without error.
+
+	mu      sync.Mutex
+	closed  bool // Its input was closed.
+	drained bool // Its output was read to the end.
+}
+
+func (f *filter) stallTimeout() time.Duration { return f.stall }
+func (f *filter) abort()                      { f.cancel() }
+
+// end records that the filter's input was closed or its output drained,
+// and releases its context once both have happened.
+func (f *filter) end(closed, drained bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = f.closed || closed
+	f.drained = f.drained || drained
+	if f.closed && f.drained {
+		f.cancel()
+	}
 }
 
 func (f *filter) Read(p []byte) (int, error) {
-	return f.buf.Read(p)
+	n, err := f.buf.Read(p)
+	if exitAllowed(f.codes, err) {
+		err = io.EOF
+	}
+	if err == io.EOF {
+		f.leak.release()
+	}
+	if err != nil {
+		f.end(false, true)
+	}
+	return n, err
 }
 
 func (f *filter) Write(p []byte) (int, error) {
@@ -44,6 +88,8 @@ func (f *filter) Write(p []byte) (int, error) {
 }
 
 func (f *filter) Close() error {
+	f.leak.release()
+	defer f.end(true, false)
 	if wb, ok := f.buf.(WriteBuffer); ok {
 		return wb.Close()
 	}
@@ -65,9 +111,26 @@ func (f *filter) ReadFrom(src io.Reader) (n int64, err error) {
 
 	// Auto-close stdin after copy completes
 	closeErr := wb.Close()
+	f.end(true, false)
 	if err == nil {
 		err = closeErr
 	}
 
 	return n, err
 }
+
+// SpliceFrom splices src into the command's input, if the command
+// implements [SpliceBuffer].
+func (f *filter) SpliceFrom(src io.Reader) bool {
+	return SpliceFrom(f.buf, src)
+}
+
+// SpliceTo splices the command's output into dst, if the command
+// implements [SpliceBuffer].
+func (f *filter) SpliceTo(dst io.Writer) bool {
+	return SpliceTo(f.buf, dst)
+}
+
+// Result returns the result of the command, if it implements
+// [ResultBuffer].
+func (f *filter) Result() (Result, bool) { return ResultOf(f.buf) }
@@ -1,6 +1,7 @@
 package command_test
 
 import (
+	"context"
 	"io"
 	"strings"
 	"testing"
@@ -35,3 +36,30 @@ func TestFilterReadFromClosesStdin(t *testing.T) {
 		t.Errorf("output = %q, want %q", got, want)
 	}
 }
+
+func TestFilterReleasesContext(t *testing.T) {
+	var cmdCtx context.Context
+	m := command.MachineFunc(func(
+		ctx context.Context, args ...string,
+	) command.Buffer {
+		cmdCtx = ctx
+		return mem.Machine().Command(ctx, args...)
+	})
+	filter := command.NewFilter(t.Context(), m, "cat")
+
+	if _, err := io.WriteString(filter, "data"); err != nil {
+		t.Fatalf("io.WriteString() error = %v, want nil", err)
+	}
+	if err := filter.Close(); err != nil {
+		t.Fatalf("Close() error = %v, want nil", err)
+	}
+	if cmdCtx.Err() != nil {
+		t.Fatal("command context canceled before output was read")
+	}
+	if _, err := io.ReadAll(filter); err != nil {
+		t.Fatalf("io.ReadAll() error = %v, want nil", err)
+	}
+	if cmdCtx.Err() == nil {
+		t.Error("command context not canceled after output was read")
+	}
+}
@@ -0,0 +1,216 @@
+// Package fingerprint records the environment of a command.Machine, so
+// that a build that behaves differently on two runs, or on two runners,
+// can be traced to what changed between them.
+//
+//	fp, err := fingerprint.Take(ctx, m, "go", "git", "docker")
+//	if err != nil {
+//	    return err
+//	}
+//	for _, c := range fingerprint.Diff(last, fp) {
+//	    log.Print(c)
+//	}
+//
+// A Fingerprint encodes to JSON, to be stored between runs.
+package fingerprint
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"lesiw.io/command"
+)
+
+// A Fingerprint describes the environment of a machine at one point in
+// time.
+type Fingerprint struct {
+	OS      string `json:"os"`
+	Arch    string `json:"arch"`
+	Release string `json:"release"` // Such as "Ubuntu 24.04.1 LTS".
+
+	// Tools maps the name of each tool asked for to the first line of
+	// its version output, or to "" if the tool was not found.
+	Tools map[string]string `json:"tools"`
+
+	// EnvHash is a digest of the machine's environment variables, with
+	// those set in the context, such as by command.WithEnv, applied.
+	// Variables that change from one shell to the next, such as PWD and
+	// SHLVL, are left out.
+	EnvHash string `json:"env_hash"`
+}
+
+// volatile lists environment variables left out of EnvHash.
+var volatile = []string{"_", "OLDPWD", "PWD", "SHLVL"}
+
+// versionArgs holds the arguments that print a tool's version, for tools
+// that do not accept --version.
+var versionArgs = map[string][]string{
+	"go":      {"version"},
+	"java":    {"-version"},
+	"kubectl": {"version", "--client"},
+}
+
+// Take fingerprints m, recording the version of each of tools. The
+// commands it runs to do so ignore the command options of ctx, such as
+// command.WithStdoutFile, but not its environment.
+func Take(
+	ctx context.Context, m command.Machine, tools ...string,
+) (*Fingerprint, error) {
+	ctx = command.WithoutCommandOptions(ctx)
+	fp := &Fingerprint{
+		OS:    command.OS(ctx, m),
+		Arch:  command.Arch(ctx, m),
+		Tools: make(map[string]string, len(tools)),
+	}
+	fp.Release = release(ctx, m, fp.OS)
+	for _, tool := range tools {
+		v, err := version(ctx, m, tool)
+		if err != nil {
+			return nil, fmt.Errorf("%s version: %w", tool, err)
+		}
+		fp.Tools[tool] = v
+	}
+	env, err := environ(ctx, m, fp.OS)
+	if err != nil {
+		return nil, fmt.Errorf("environment: %w", err)
+	}
+	fp.EnvHash = envHash(env)
+	return fp, nil
+}
+
+func release(ctx context.Context, m command.Machine, os string) string {
+	var (
+		out string
+		err error
+	)
+	switch os {
+	case "windows":
+		out, err = command.Read(ctx, m, "powershell", "-NoProfile",
+			"-NonInteractive", "-Command",
+			"(Get-CimInstance Win32_OperatingSystem).Caption + ' ' + "+
+				"[Environment]::OSVersion.Version")
+	case "darwin":
+		out, err = command.Read(ctx, m, "sw_vers", "-productVersion")
+		if err == nil {
+			out = "macOS " + out
+		}
+	default:
+		out, err = command.Read(ctx, m, "sh", "-c",
+			`. /etc/os-release 2>/dev/null && echo "$PRETTY_NAME" ||
+uname -sr`)
+	}
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(out)
+}
+
+func version(
+	ctx context.Context, m command.Machine, tool string,
+) (string, error) {
+	args, ok := versionArgs[tool]
+	if !ok {
+		args = []string{"--version"}
+	}
+	ctx = command.WithMergedStderr(ctx, true) // java prints to stderr.
+	out, err := command.Read(ctx, m, append([]string{tool}, args...)...)
+	if command.Missing(err) || exitCode(err) == 126 {
+		return "", nil // Not found, or found but not executable.
+	} else if err != nil {
+		return "", err
+	}
+	line, _, _ := strings.Cut(strings.TrimSpace(out), "\n")
+	return strings.TrimSpace(line), nil
+}
+
+// exitCode returns the exit code of the command that returned err, or 0
+// if err is not a command.Error.
+func exitCode(err error) int {
+	var cerr *command.Error
+	if errors.As(err, &cerr) {
+		return cerr.Code
+	}
+	return 0
+}
+
+func environ(
+	ctx context.Context, m command.Machine, os string,
+) (map[string]string, error) {
+	var (
+		out string
+		err error
+	)
+	if os == "windows" {
+		out, err = command.Read(ctx, m, "powershell", "-NoProfile",
+			"-NonInteractive", "-Command",
+			"Get-ChildItem env: | ForEach-Object { $_.Name + '=' + $_.Value }")
+	} else {
+		out, err = command.Read(ctx, m, "env")
+	}
+	if err != nil {
+		return nil, err
+	}
+	env := make(map[string]string)
+	for line := range strings.Lines(out) {
+		k, v, ok := strings.Cut(strings.TrimRight(line, "\r\n"), "=")
+		if ok && k != "" {
+			env[k] = v
+		}
+	}
+	maps.Copy(env, command.Envs(ctx))
+	return env, nil
+}
+
+func envHash(env map[string]string) string {
+	h := sha256.New()
+	for _, k := range slices.Sorted(maps.Keys(env)) {
+		if slices.Contains(volatile, k) {
+			continue
+		}
+		_, _ = fmt.Fprintf(h, "%s=%s\x00", k, env[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// A Change is a difference between two fingerprints.
+type Change struct {
+	Field string // Such as "os", "release", or "tools.go".
+	Old   string
+	New   string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%s: %q -> %q", c.Field, c.Old, c.New)
+}
+
+// Diff returns the differences between old and new, ordered by field.
+// A tool fingerprinted in only one of them is reported as changing from
+// or to "".
+func Diff(old, new *Fingerprint) []Change {
+	var changes []Change
+	add := func(field, o, n string) {
+		if o != n {
+			changes = append(changes, Change{field, o, n})
+		}
+	}
+	add("arch", old.Arch, new.Arch)
+	add("env", old.EnvHash, new.EnvHash)
+	add("os", old.OS, new.OS)
+	add("release", old.Release, new.Release)
+	tools := slices.Sorted(maps.Keys(old.Tools))
+	for tool := range new.Tools {
+		if _, ok := old.Tools[tool]; !ok {
+			tools = append(tools, tool)
+		}
+	}
+	slices.Sort(tools)
+	for _, tool := range tools {
+		add("tools."+tool, old.Tools[tool], new.Tools[tool])
+	}
+	return changes
+}
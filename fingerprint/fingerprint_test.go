@@ -0,0 +1,87 @@
+package fingerprint_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lesiw.io/command"
+	"lesiw.io/command/fingerprint"
+	"lesiw.io/command/mock"
+)
+
+func newMachine(goVersion, path string) *mock.Machine {
+	m := new(mock.Machine)
+	m.SetOS("linux")
+	m.SetArch("amd64")
+	m.Return(strings.NewReader("Debian GNU/Linux 12 (bookworm)\n"), "sh")
+	m.Return(strings.NewReader(goVersion+"\n"), "go", "version")
+	m.Return(command.Fail(&command.Error{Err: errors.New("not found")}),
+		"docker")
+	m.Return(command.Fail(&command.Error{Code: 127}), "kubectl")
+	m.Return(command.Fail(&command.Error{Code: 126}), "make")
+	m.Return(strings.NewReader("PATH="+path+"\nPWD=/tmp\n"), "env")
+	return m
+}
+
+func TestTake(t *testing.T) {
+	m := newMachine("go version go1.24.1 linux/amd64", "/usr/bin")
+
+	fp, err := fingerprint.Take(t.Context(), m,
+		"go", "docker", "kubectl", "make")
+	if err != nil {
+		t.Fatalf("fingerprint.Take error: %v", err)
+	}
+
+	if got, want := fp.Release, "Debian GNU/Linux 12 (bookworm)"; got != want {
+		t.Errorf("Release = %q, want %q", got, want)
+	}
+	want := map[string]string{
+		"go":      "go version go1.24.1 linux/amd64",
+		"docker":  "",
+		"kubectl": "",
+		"make":    "",
+	}
+	if !cmp.Equal(want, fp.Tools) {
+		t.Errorf("Tools (-want +got):\n%s", cmp.Diff(want, fp.Tools))
+	}
+	if fp.EnvHash == "" {
+		t.Error("EnvHash is empty")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	ctx := t.Context()
+	a, err := fingerprint.Take(ctx,
+		newMachine("go version go1.24.1 linux/amd64", "/usr/bin"), "go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := fingerprint.Take(ctx,
+		newMachine("go version go1.24.2 linux/amd64", "/usr/bin"), "go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := fingerprint.Take(ctx,
+		newMachine("go version go1.24.2 linux/amd64", "/opt/bin"), "go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d := fingerprint.Diff(a, a); d != nil {
+		t.Errorf("Diff(a, a) = %v, want nil", d)
+	}
+	want := []fingerprint.Change{{
+		Field: "tools.go",
+		Old:   "go version go1.24.1 linux/amd64",
+		New:   "go version go1.24.2 linux/amd64",
+	}}
+	if got := fingerprint.Diff(a, b); !cmp.Equal(want, got) {
+		t.Errorf("Diff(a, b) (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	if got := fingerprint.Diff(b, c); len(got) != 1 || got[0].Field != "env" {
+		t.Errorf("Diff(b, c) = %v, want env change", got)
+	}
+}
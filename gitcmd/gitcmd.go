@@ -0,0 +1,105 @@
+// Package gitcmd runs common git operations on a command.Machine.
+//
+// Each function runs git on the machine through sub.Machine(m, "git")
+// and parses its output, so callers need not build argument lists or
+// scrape porcelain output by hand. Commands run in the working directory
+// of ctx:
+//
+//	ctx = fs.WithWorkDir(ctx, "/src/app")
+//	head, err := gitcmd.RevParse(ctx, m, "HEAD")
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	st, err := gitcmd.Status(ctx, m)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	if len(st) > 0 {
+//	    log.Fatalf("%s: worktree is dirty", head)
+//	}
+package gitcmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"lesiw.io/command"
+	"lesiw.io/command/sub"
+)
+
+func git(m command.Machine) command.Machine { return sub.Machine(m, "git") }
+
+// Clone clones the repository at url into dir.
+func Clone(ctx context.Context, m command.Machine, url, dir string) error {
+	return command.Do(ctx, git(m), "clone", "--quiet", "--", url, dir)
+}
+
+// Fetch fetches refspecs from remote. With no refspecs, it fetches the
+// remote's configured refspecs.
+func Fetch(
+	ctx context.Context, m command.Machine, remote string, refspecs ...string,
+) error {
+	args := append([]string{"fetch", "--quiet", remote}, refspecs...)
+	return command.Do(ctx, git(m), args...)
+}
+
+// RevParse returns the object name that rev resolves to.
+func RevParse(
+	ctx context.Context, m command.Machine, rev string,
+) (string, error) {
+	return command.Read(command.WithoutCommandOptions(ctx), git(m),
+		"rev-parse", "--verify", "--end-of-options", rev)
+}
+
+// An Entry is one changed path in the output of [Status].
+type Entry struct {
+	// Index and Worktree are the status codes of the path in the index
+	// and in the worktree, as in git-status(1): ' ' for unmodified, and
+	// M, T, A, D, R, C, U, ? (untracked), or ! (ignored).
+	Index, Worktree byte
+
+	Path string
+
+	// Orig is the path the entry was renamed or copied from, if any.
+	Orig string
+}
+
+// Untracked reports whether the path is not tracked by git.
+func (e Entry) Untracked() bool { return e.Index == '?' }
+
+// Status returns the changed and untracked paths in the worktree.
+// An empty result means the worktree is clean.
+func Status(ctx context.Context, m command.Machine) ([]Entry, error) {
+	out, err := command.Read(command.WithoutCommandOptions(ctx), git(m),
+		"status", "--porcelain=v1", "-z", "--untracked-files=all")
+	if err != nil {
+		return nil, err
+	}
+	return parseStatus(out)
+}
+
+func parseStatus(out string) ([]Entry, error) {
+	var entries []Entry
+	fields := strings.Split(out, "\x00")
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if f == "" {
+			continue
+		}
+		if len(f) < 4 || f[2] != ' ' {
+			return nil, fmt.Errorf("bad status line: %q", f)
+		}
+		e := Entry{Index: f[0], Worktree: f[1], Path: f[3:]}
+		if e.Index == 'R' || e.Index == 'C' {
+			// With -z, the source of a rename or copy follows as its own
+			// field.
+			if i++; i >= len(fields) {
+				return nil, fmt.Errorf("missing source of %q", e.Path)
+			}
+			e.Orig = fields[i]
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
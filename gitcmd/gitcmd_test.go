@@ -0,0 +1,113 @@
+package gitcmd_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lesiw.io/command"
+	"lesiw.io/command/gitcmd"
+	"lesiw.io/command/mock"
+)
+
+func TestStatus(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader(
+		" M main.go\x00R  new.go\x00old.go\x00?? notes.txt\x00",
+	), "git", "status")
+
+	got, err := gitcmd.Status(t.Context(), m)
+	if err != nil {
+		t.Fatalf("gitcmd.Status error: %v", err)
+	}
+
+	want := []gitcmd.Entry{
+		{Index: ' ', Worktree: 'M', Path: "main.go"},
+		{Index: 'R', Worktree: ' ', Path: "new.go", Orig: "old.go"},
+		{Index: '?', Worktree: '?', Path: "notes.txt"},
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("gitcmd.Status (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	if !got[2].Untracked() {
+		t.Errorf("Untracked() = false for %q, want true", got[2].Path)
+	}
+}
+
+func TestStatusClean(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader(""), "git", "status")
+
+	got, err := gitcmd.Status(t.Context(), m)
+	if err != nil {
+		t.Fatalf("gitcmd.Status error: %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("gitcmd.Status = %v, want no entries", got)
+	}
+}
+
+func TestStatusBadOutput(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("garbage\x00"), "git", "status")
+
+	if _, err := gitcmd.Status(t.Context(), m); err == nil {
+		t.Error("gitcmd.Status error: got nil, want error")
+	}
+}
+
+func TestStatusIgnoresAllowedExitCodes(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(command.Fail(&command.Error{Code: 128}), "git", "status")
+	ctx := command.WithAllowedExitCodes(t.Context(), 128)
+
+	if _, err := gitcmd.Status(ctx, m); err == nil {
+		t.Error("gitcmd.Status error: got nil, want exit 128")
+	}
+}
+
+func TestRevParse(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("4b825dc\n"), "git", "rev-parse")
+
+	got, err := gitcmd.RevParse(t.Context(), m, "HEAD")
+	if err != nil {
+		t.Fatalf("gitcmd.RevParse error: %v", err)
+	}
+	if want := "4b825dc"; got != want {
+		t.Errorf("gitcmd.RevParse = %q, want %q", got, want)
+	}
+
+	calls := mock.Calls(m, "git", "rev-parse")
+	want := []string{
+		"git", "rev-parse", "--verify", "--end-of-options", "HEAD",
+	}
+	if len(calls) != 1 || !cmp.Equal(calls[0].Args, want) {
+		t.Errorf("calls: got %v, want one with args %v", calls, want)
+	}
+}
+
+func TestCloneFetch(t *testing.T) {
+	m := new(mock.Machine)
+	ctx := t.Context()
+
+	if err := gitcmd.Clone(ctx, m, "https://example.com/r", "r"); err != nil {
+		t.Fatalf("gitcmd.Clone error: %v", err)
+	}
+	if err := gitcmd.Fetch(ctx, m, "origin", "main"); err != nil {
+		t.Fatalf("gitcmd.Fetch error: %v", err)
+	}
+
+	var got [][]string
+	for _, c := range mock.Calls(m, "git") {
+		got = append(got, c.Args)
+	}
+	want := [][]string{
+		{"git", "clone", "--quiet", "--", "https://example.com/r", "r"},
+		{"git", "fetch", "--quiet", "origin", "main"},
+	}
+	if !cmp.Equal(got, want) {
+		t.Errorf("calls (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
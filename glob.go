@@ -0,0 +1,54 @@
+package command
+
+import (
+	"context"
+	"slices"
+	"strings"
+)
+
+// globScript prints each path that matches the pattern in $1, one per
+// line. An empty IFS keeps the unquoted $1 from being split on
+// whitespace, while still letting the shell expand it. The existence
+// test drops the pattern itself, which sh leaves in place when nothing
+// matches.
+const globScript = `IFS=
+for f in $1; do
+	if [ -e "$f" ] || [ -L "$f" ]; then printf '%s\n' "$f"; fi
+done`
+
+// Glob returns the names of the files on m that match pattern, in
+// lexical order, or nil if none match. Matching is done on m, by sh on
+// Unix and by PowerShell on Windows, so the pattern follows the rules of
+// the machine's shell and relative patterns are relative to the working
+// directory (see fs.WithWorkDir).
+//
+// Use Glob rather than passing an unquoted pattern through sh -c, which
+// runs a command with the pattern itself as an argument when it matches
+// nothing.
+func Glob(ctx context.Context, m Machine, pattern string) ([]string, error) {
+	ctx = WithoutCommandOptions(ctx)
+	var (
+		out string
+		err error
+	)
+	if OS(ctx, m) == "windows" {
+		out, err = psRead(ctx, m, `$p = '%s'
+$rooted = [IO.Path]::IsPathRooted($p)
+Resolve-Path -Path $p -Relative:(-not $rooted) -ErrorAction Ignore |
+	ForEach-Object { ([string]$_) -replace '^\.\\', '' }`,
+			psEscape(pattern))
+	} else {
+		out, err = Read(ctx, m, "sh", "-c", globScript, "sh", pattern)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for line := range strings.Lines(out) {
+		if line = strings.TrimRight(line, "\r\n"); line != "" {
+			names = append(names, line)
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
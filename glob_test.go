@@ -0,0 +1,71 @@
+package command_test
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lesiw.io/command"
+	"lesiw.io/command/sys"
+	"lesiw.io/fs"
+)
+
+func TestGlob(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	dir := t.TempDir()
+	for _, name := range []string{"a.txt", "b c.txt", "d.log"} {
+		err := os.WriteFile(filepath.Join(dir, name), nil, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := fs.WithWorkDir(t.Context(), dir)
+	m := sys.Machine()
+
+	got, err := command.Glob(ctx, m, "*.txt")
+	if err != nil {
+		t.Fatalf("command.Glob error: %v", err)
+	}
+	if want := []string{"a.txt", "b c.txt"}; !cmp.Equal(want, got) {
+		t.Errorf("Glob(*.txt) (-want +got):\n%s", cmp.Diff(want, got))
+	}
+
+	got, err = command.Glob(ctx, m, "*.md")
+	if err != nil {
+		t.Fatalf("command.Glob error: %v", err)
+	}
+	if got != nil {
+		t.Errorf("Glob(*.md) = %q, want nil", got)
+	}
+}
+
+func TestGlobCommandOptions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "a.txt"), nil, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(t.TempDir(), "out.txt")
+	ctx := fs.WithWorkDir(t.Context(), dir)
+	ctx = command.WithStdoutFile(ctx, out)
+	ctx = command.WithMergedStderr(command.WithAllowedExitCodes(ctx, 1), true)
+
+	got, err := command.Glob(ctx, sys.Machine(), "*.txt")
+	if err != nil {
+		t.Fatalf("command.Glob error: %v", err)
+	}
+	if want := []string{"a.txt"}; !cmp.Equal(want, got) {
+		t.Errorf("Glob(*.txt) (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	if _, err := os.Stat(out); !os.IsNotExist(err) {
+		t.Errorf("stdout file: got %v, want not exist", err)
+	}
+}
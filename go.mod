@@ -5,7 +5,9 @@ go 1.24.7
 require (
 	github.com/Antonboom/errname v1.1.1
 	github.com/google/go-cmp v0.7.0
+	golang.org/x/crypto v0.44.0
 	golang.org/x/sync v0.18.0
+	golang.org/x/sys v0.38.0
 	golang.org/x/term v0.37.0
 	golang.org/x/tools v0.39.0
 	lesiw.io/checker v0.12.0
@@ -13,13 +15,11 @@ require (
 	lesiw.io/fs v0.13.0
 	lesiw.io/linelen v0.2.0
 	lesiw.io/plscheck v0.20.0
-	lesiw.io/prefix v0.1.0
 	lesiw.io/tidytypes v0.2.0
 	lesiw.io/zeros v0.3.0
 )
 
 require (
 	golang.org/x/mod v0.30.0 // indirect
-	golang.org/x/sys v0.38.0 // indirect
 	golang.org/x/telemetry v0.0.0-20251111182119-bc8e575c7b54 // indirect
 )
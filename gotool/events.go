@@ -0,0 +1,181 @@
+package gotool
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"iter"
+	"slices"
+	"strings"
+)
+
+// Events returns the events that go test -json writes to r, decoded as
+// they arrive, so that tools can show the progress of tests while they
+// run. It stops at the first error, which it yields with a zero Event.
+//
+// Lines that are not JSON, such as build errors from older toolchains,
+// become output events with no package.
+//
+//	r := command.NewReader(ctx, m, "go", "test", "-json", "./...")
+//	defer r.Close()
+//	for e, err := range gotool.Events(r) {
+//	    if err != nil {
+//	        return err
+//	    }
+//	    if e.Action == "fail" && e.Test != "" {
+//	        fmt.Println("FAIL", e.Package, e.Test)
+//	    }
+//	}
+func Events(r io.Reader) iter.Seq2[Event, error] {
+	return func(yield func(Event, error) bool) {
+		sc := bufio.NewScanner(r)
+		sc.Buffer(nil, 1<<20)
+		for sc.Scan() {
+			e, err := decodeEvent(sc.Bytes())
+			if !yield(e, err) || err != nil {
+				return
+			}
+		}
+		if err := sc.Err(); err != nil {
+			yield(Event{}, err)
+		}
+	}
+}
+
+func decodeEvent(line []byte) (Event, error) {
+	if !bytes.HasPrefix(line, []byte("{")) {
+		return Event{Action: "output", Output: string(line) + "\n"}, nil
+	}
+	var e Event
+	if err := json.Unmarshal(line, &e); err != nil {
+		return Event{}, fmt.Errorf("bad test event %q: %w", line, err)
+	}
+	return e, nil
+}
+
+// Done reports whether e ends a test or a package: whether its action
+// is pass, fail, or skip.
+func (e Event) Done() bool {
+	return e.Action == "pass" || e.Action == "fail" || e.Action == "skip"
+}
+
+// NewEventWriter returns a Writer that decodes the go test -json events
+// written to it and calls f with each one, in order. It is meant to be
+// the destination of a pipeline:
+//
+//	var sum gotool.Summary
+//	_, err := command.Copy(
+//	    gotool.NewEventWriter(sum.Add),
+//	    command.NewReader(ctx, m, "go", "test", "-json", "./..."),
+//	)
+//
+// Write fails if an event cannot be decoded. Close decodes an
+// unterminated last line.
+func NewEventWriter(f func(Event)) io.WriteCloser {
+	return &eventWriter{f: f}
+}
+
+type eventWriter struct {
+	f   func(Event)
+	buf []byte
+}
+
+func (w *eventWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		line := w.buf[:i]
+		w.buf = w.buf[i+1:]
+		if err := w.emit(line); err != nil {
+			return len(p), err
+		}
+	}
+}
+
+func (w *eventWriter) Close() error {
+	line := w.buf
+	w.buf = nil
+	if len(line) == 0 {
+		return nil
+	}
+	return w.emit(line)
+}
+
+func (w *eventWriter) emit(line []byte) error {
+	e, err := decodeEvent(bytes.TrimSuffix(line, []byte("\r")))
+	if err != nil {
+		return err
+	}
+	w.f(e)
+	return nil
+}
+
+// A Summary tallies the results of a go test -json run. Add events to it
+// as they arrive; the zero Summary is empty and ready to use.
+type Summary struct {
+	Pass, Fail, Skip int // Counts of tests, including subtests.
+
+	// Failures are the tests and packages that failed, in the order they
+	// failed, with their output.
+	Failures []Failure
+
+	output map[[2]string][]string
+}
+
+// A Failure is a failed test, or a package that failed with no test to
+// blame, such as one that did not build.
+type Failure struct {
+	Package string
+	Test    string // Empty for a package.
+	Output  string
+}
+
+// Add adds e to the summary.
+func (s *Summary) Add(e Event) {
+	key := [2]string{e.Package, e.Test}
+	switch e.Action {
+	case "output":
+		if s.output == nil {
+			s.output = make(map[[2]string][]string)
+		}
+		s.output[key] = append(s.output[key], e.Output)
+		return
+	case "pass":
+		if e.Test != "" {
+			s.Pass++
+		}
+	case "skip":
+		if e.Test != "" {
+			s.Skip++
+		}
+	case "fail":
+		if e.Test != "" {
+			s.Fail++
+		}
+		if e.Test != "" || !s.blamed(e.Package) {
+			s.Failures = append(s.Failures, Failure{
+				Package: e.Package,
+				Test:    e.Test,
+				Output:  strings.Join(s.output[key], ""),
+			})
+		}
+	default:
+		return
+	}
+	delete(s.output, key)
+}
+
+// blamed reports whether a test in pkg has already failed.
+func (s *Summary) blamed(pkg string) bool {
+	return slices.ContainsFunc(s.Failures, func(f Failure) bool {
+		return f.Package == pkg && f.Test != ""
+	})
+}
+
+// OK reports whether nothing has failed.
+func (s *Summary) OK() bool { return len(s.Failures) == 0 }
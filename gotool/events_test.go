@@ -0,0 +1,86 @@
+package gotool_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"lesiw.io/command"
+	"lesiw.io/command/gotool"
+	"lesiw.io/command/mock"
+)
+
+const testOutput = `{"Action":"start","Package":"a"}
+{"Action":"run","Package":"a","Test":"TestOK"}
+{"Action":"pass","Package":"a","Test":"TestOK"}
+{"Action":"run","Package":"a","Test":"TestBad"}
+{"Action":"output","Package":"a","Test":"TestBad","Output":"want 1\n"}
+{"Action":"fail","Package":"a","Test":"TestBad"}
+{"Action":"skip","Package":"a","Test":"TestLater"}
+{"Action":"fail","Package":"a"}
+{"Action":"output","Package":"b","Output":"b.go:1: syntax error\n"}
+{"Action":"fail","Package":"b","FailedBuild":"b"}`
+
+func TestEventWriter(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader(testOutput), "go", "test")
+	var sum gotool.Summary
+
+	_, err := command.Copy(
+		gotool.NewEventWriter(sum.Add),
+		command.NewReader(t.Context(), m, "go", "test", "-json", "./..."),
+	)
+	if err != nil {
+		t.Fatalf("command.Copy error: %v", err)
+	}
+
+	want := gotool.Summary{
+		Pass: 1, Fail: 1, Skip: 1,
+		Failures: []gotool.Failure{
+			{Package: "a", Test: "TestBad", Output: "want 1\n"},
+			{Package: "b", Output: "b.go:1: syntax error\n"},
+		},
+	}
+	opt := cmpopts.IgnoreUnexported(gotool.Summary{})
+	if !cmp.Equal(want, sum, opt) {
+		t.Errorf("Summary (-want +got):\n%s", cmp.Diff(want, sum, opt))
+	}
+	if sum.OK() {
+		t.Error("OK() = true, want false")
+	}
+}
+
+func TestEvents(t *testing.T) {
+	var got []string
+	for e, err := range gotool.Events(strings.NewReader(testOutput)) {
+		if err != nil {
+			t.Fatalf("gotool.Events error: %v", err)
+		}
+		if e.Done() {
+			got = append(got, e.Action+" "+e.Package+" "+e.Test)
+		}
+	}
+
+	want := []string{
+		"pass a TestOK",
+		"fail a TestBad",
+		"skip a TestLater",
+		"fail a ",
+		"fail b ",
+	}
+	if !cmp.Equal(want, got) {
+		t.Errorf("done events (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestEventsBadJSON(t *testing.T) {
+	for _, err := range gotool.Events(strings.NewReader("{oops\n")) {
+		if err == nil {
+			t.Fatal("gotool.Events: got nil error, want bad event")
+		}
+		return
+	}
+	t.Fatal("gotool.Events yielded nothing")
+}
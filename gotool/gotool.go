@@ -0,0 +1,108 @@
+// Package gotool runs the go command on a command.Machine.
+//
+// [Machine] wraps go with module-aware defaults, so that builds do not
+// depend on the environment of whoever runs them, and helpers such as
+// [Build] and [Test] run common subcommands and parse their output:
+//
+//	ctx = fs.WithWorkDir(ctx, "/src/app")
+//	if err := gotool.Build(ctx, m, "./..."); err != nil {
+//	    log.Fatal(err)
+//	}
+//	events, err := gotool.Test(ctx, m, "./...")
+//	for _, e := range events {
+//	    if e.Action == "fail" && e.Test != "" {
+//	        fmt.Println("FAIL", e.Package, e.Test)
+//	    }
+//	}
+//
+// To show the progress of tests as they run, decode their events as
+// they stream with [Events] or [NewEventWriter], and tally them with a
+// [Summary].
+//
+// The working directory of ctx is passed to go with its -C flag, so it
+// applies even on machines whose go command is found through a wrapper.
+package gotool
+
+import (
+	"context"
+	"maps"
+
+	"lesiw.io/command"
+	"lesiw.io/command/sub"
+	"lesiw.io/fs"
+)
+
+// DefaultFlags is the default value of GOFLAGS for commands run by a
+// Machine. It keeps builds from changing go.mod and go.sum.
+const DefaultFlags = "-mod=readonly"
+
+// An Option configures a Machine.
+type Option func(*machine)
+
+// Flags sets GOFLAGS, replacing [DefaultFlags].
+func Flags(flags string) Option {
+	return func(m *machine) { m.env["GOFLAGS"] = flags }
+}
+
+// ModCache pins GOMODCACHE to dir, so that every command shares one
+// module cache, wherever the machine's go would otherwise put it.
+func ModCache(dir string) Option {
+	return func(m *machine) { m.env["GOMODCACHE"] = dir }
+}
+
+type machine struct {
+	command.Machine
+	env map[string]string
+}
+
+// Machine returns a Machine that runs go subcommands on m.
+//
+// Commands run in module mode, with GOFLAGS set to [DefaultFlags] unless
+// changed by an option. Environment variables set in the context take
+// precedence over these defaults.
+//
+//	gm := gotool.Machine(m, gotool.ModCache("/cache/mod"))
+//	err := command.Do(ctx, gm, "mod", "download")
+func Machine(m command.Machine, opts ...Option) command.Machine {
+	gm := &machine{
+		Machine: sub.Machine(m, "go"),
+		env: map[string]string{
+			"GO111MODULE": "on",
+			"GOFLAGS":     DefaultFlags,
+		},
+	}
+	for _, opt := range opts {
+		opt(gm)
+	}
+	return gm
+}
+
+func (m *machine) Command(
+	ctx context.Context, arg ...string,
+) command.Buffer {
+	env := maps.Clone(m.env)
+	for k := range command.Envs(ctx) {
+		delete(env, k)
+	}
+	ctx = command.WithEnv(ctx, env)
+	if dir := fs.WorkDir(ctx); dir != "" {
+		arg = append([]string{"-C", dir}, arg...)
+		ctx = fs.WithoutWorkDir(ctx)
+	}
+	return m.Machine.Command(ctx, arg...)
+}
+
+// gotool returns m as a Machine that runs go, wrapping it with the
+// defaults of [Machine] if it is not one already.
+func gotool(m command.Machine) command.Machine {
+	if _, ok := m.(*machine); ok {
+		return m
+	}
+	return Machine(m)
+}
+
+// Build compiles pkgs, discarding the results. It checks that they build.
+func Build(ctx context.Context, m command.Machine, pkgs ...string) error {
+	args := append([]string{"build"}, pkgs...)
+	return command.Do(ctx, gotool(m), args...)
+}
@@ -0,0 +1,97 @@
+package gotool_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lesiw.io/command"
+	"lesiw.io/command/gotool"
+	"lesiw.io/command/mock"
+	"lesiw.io/fs"
+)
+
+func TestBuild(t *testing.T) {
+	m := new(mock.Machine)
+	ctx := fs.WithWorkDir(t.Context(), "/src/app")
+	ctx = command.WithEnv(ctx, map[string]string{"GOFLAGS": "-v"})
+
+	err := gotool.Build(ctx, gotool.Machine(m, gotool.ModCache("/mod")),
+		"./...")
+	if err != nil {
+		t.Fatalf("gotool.Build error: %v", err)
+	}
+
+	calls := mock.Calls(m, "go")
+	if len(calls) != 1 {
+		t.Fatalf("got %d go calls, want 1", len(calls))
+	}
+	want := mock.Call{
+		Args: []string{"go", "-C", "/src/app", "build", "./..."},
+		Env: map[string]string{
+			"GO111MODULE": "on",
+			"GOFLAGS":     "-v",
+			"GOMODCACHE":  "/mod",
+		},
+	}
+	if got := calls[0]; !cmp.Equal(want, got) {
+		t.Errorf("go call (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestBuildDefaults(t *testing.T) {
+	m := new(mock.Machine)
+
+	if err := gotool.Build(t.Context(), m); err != nil {
+		t.Fatalf("gotool.Build error: %v", err)
+	}
+
+	calls := mock.Calls(m, "go")
+	if len(calls) != 1 {
+		t.Fatalf("got %d go calls, want 1", len(calls))
+	}
+	want := mock.Call{
+		Args: []string{"go", "build"},
+		Env: map[string]string{
+			"GO111MODULE": "on",
+			"GOFLAGS":     gotool.DefaultFlags,
+		},
+	}
+	if got := calls[0]; !cmp.Equal(want, got) {
+		t.Errorf("go call (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestTest(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(io.MultiReader(
+		strings.NewReader(
+			`{"Action":"run","Package":"p","Test":"TestA"}`+"\n"+
+				`{"Action":"fail","Package":"p","Test":"TestA",`+
+				`"Elapsed":0.5}`+"\n"+
+				"not json\n",
+		),
+		command.Fail(&command.Error{Code: 1}),
+	), "go", "test")
+
+	got, err := gotool.Test(t.Context(), m, "-run", "TestA", "./p")
+	if err == nil {
+		t.Error("gotool.Test: got nil error, want failure")
+	}
+
+	want := []gotool.Event{
+		{Action: "run", Package: "p", Test: "TestA"},
+		{Action: "fail", Package: "p", Test: "TestA", Elapsed: 0.5},
+		{Action: "output", Output: "not json\n"},
+	}
+	if !cmp.Equal(want, got) {
+		t.Errorf("gotool.Test (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	calls := mock.Calls(m, "go")
+	wantArgs := []string{"go", "test", "-json", "-run", "TestA", "./p"}
+	if len(calls) != 1 || !cmp.Equal(wantArgs, calls[0].Args) {
+		t.Errorf("go calls = %v, want one with args %q", calls, wantArgs)
+	}
+}
@@ -0,0 +1,51 @@
+package gotool
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"lesiw.io/command"
+)
+
+// An Event is one event of go test -json, as described by go doc
+// test2json.
+type Event struct {
+	Time    time.Time
+	Action  string // start, run, pause, cont, pass, bench, fail, output, skip
+	Package string
+	Test    string
+	Elapsed float64 // Seconds.
+	Output  string
+
+	// FailedBuild is the import path of the package that failed to build,
+	// if the package failed because of a build error.
+	FailedBuild string
+}
+
+// Test runs the tests of pkgs with go test -json and returns the events
+// it reports. Additional flags for go test, such as -run, may be given
+// before pkgs.
+//
+// If tests fail, Test returns their events along with the error.
+func Test(
+	ctx context.Context, m command.Machine, pkgs ...string,
+) ([]Event, error) {
+	args := append([]string{"test", "-json"}, pkgs...)
+	ctx = command.WithoutCommandOptions(ctx)
+	r := command.NewReader(ctx, gotool(m), args...)
+	events, err := readEvents(r)
+	return events, errors.Join(err, r.Close())
+}
+
+func readEvents(r io.Reader) ([]Event, error) {
+	var events []Event
+	for e, err := range Events(r) {
+		if err != nil {
+			return events, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
@@ -0,0 +1,38 @@
+// Package configtest keeps tests from reading the configuration file of
+// the user who runs them.
+package configtest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Main runs the tests of m with XDG_CONFIG_HOME set to a new directory,
+// whose configuration file holds toml if it is not empty, and exits. It
+// is meant to be called from TestMain:
+//
+//	func TestMain(m *testing.M) { configtest.Main(m, "") }
+func Main(m *testing.M, toml string) {
+	os.Exit(run(m, toml))
+}
+
+func run(m *testing.M, toml string) int {
+	dir, err := os.MkdirTemp("", "config")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	_ = os.Setenv("XDG_CONFIG_HOME", dir)
+	if toml != "" {
+		path := filepath.Join(dir, "lesiw-command", "config.toml")
+		err = os.MkdirAll(filepath.Dir(path), 0o755)
+		if err == nil {
+			err = os.WriteFile(path, []byte(toml), 0o644)
+		}
+		if err != nil {
+			panic(err)
+		}
+	}
+	return m.Run()
+}
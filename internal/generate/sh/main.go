@@ -36,15 +36,19 @@ func main() {
 // Default skip lists for known packages
 var defaultSkips = map[string][]string{
 	"lesiw.io/command": {
-		"Shell",      // Constructor, not a helper
-		"FS",         // Sh caches FS, manually implemented
-		"OS",         // Sh caches OS, manually implemented
-		"Arch",       // Sh caches Arch, manually implemented
-		"Env",        // Sh must probe sh.m, manually implemented
-		"Shutdown",   // Sh must delegate to sh.m, manually implemented
-		"Handle",     // Manually implemented in sh.go
-		"HandleFunc", // Manually implemented in sh.go
-		"Unshell",    // Manually implemented in sh.go
+		"Shell",        // Constructor, not a helper
+		"FS",           // Sh caches FS, manually implemented
+		"OS",           // Sh caches OS, manually implemented
+		"Arch",         // Sh caches Arch, manually implemented
+		"Env",          // Sh must probe sh.m, manually implemented
+		"Shutdown",     // Sh must delegate to sh.m, manually implemented
+		"Handle",       // Manually implemented in sh.go
+		"HandleFunc",   // Manually implemented in sh.go
+		"Unshell",      // Manually implemented in sh.go
+		"Abs",          // Sh.Abs is generated from lesiw.io/fs
+		"Glob",         // Sh.Glob is generated from lesiw.io/fs
+		"Expand",       // Wrapper, not a helper
+		"ExpandStrict", // Wrapper, not a helper
 	},
 }
 
@@ -374,7 +378,8 @@ func findFuncDoc(fn *types.Func, pkg *packages.Package) string {
 func collectImports(t types.Type, imports map[string]struct{}) {
 	switch t := t.(type) {
 	case *types.Named:
-		if pkg := t.Obj().Pkg(); pkg != nil {
+		pkg := t.Obj().Pkg()
+		if pkg != nil && pkg.Path() != "lesiw.io/command" {
 			imports[pkg.Path()] = struct{}{}
 		}
 	case *types.Pointer:
@@ -70,3 +70,60 @@ func String(env map[string]string, arg ...string) Stringer {
 	ret.WriteString(Join(arg))
 	return Stringer(ret.String())
 }
+
+// Split splits a command line into arguments. It reverses Join, and
+// understands single quotes, double quotes, and backslash escapes.
+func Split(s string) ([]string, error) {
+	var (
+		args  []string
+		cur   strings.Builder
+		inArg bool
+	)
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case ' ', '\t':
+			if inArg {
+				args, inArg = append(args, cur.String()), false
+				cur.Reset()
+			}
+		case '\\':
+			if i++; i >= len(s) {
+				return nil, fmt.Errorf("trailing backslash: %s", s)
+			}
+			cur.WriteByte(s[i])
+			inArg = true
+		case '\'', '"':
+			end := strings.IndexByte(s[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote: %s", s)
+			}
+			cur.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+			inArg = true
+		default:
+			cur.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, cur.String())
+	}
+	return args, nil
+}
+
+// Redirect returns the redirections that send output to the file stdout
+// and diagnostic output to the file stderr, where either may be empty.
+// If merge is set, diagnostic output without a file of its own follows
+// output.
+func Redirect(stdout, stderr string, merge bool) string {
+	var b strings.Builder
+	if stdout != "" {
+		b.WriteString(" >" + Quote(stdout))
+	}
+	if stderr != "" {
+		b.WriteString(" 2>" + Quote(stderr))
+	} else if merge {
+		b.WriteString(" 2>&1")
+	}
+	return b.String()
+}
@@ -0,0 +1,125 @@
+// Package spill implements a buffer that keeps the end of what is
+// written to it in memory and, if asked, spills all of it to a temporary
+// file.
+package spill
+
+import (
+	"bytes"
+	"os"
+	"sync"
+)
+
+// A Buffer holds the last Limit bytes written to it in memory. If Spill
+// is set, once more is written, it creates a temporary file, which holds
+// everything written, from the first byte. Writes to a Buffer do not
+// fail: if the file cannot be created or written, it holds only what
+// was written to it before.
+//
+// A Buffer is safe for concurrent use.
+type Buffer struct {
+	Limit int
+	Spill bool
+
+	mu   sync.Mutex
+	mem  []byte // Ends with the last Limit bytes written.
+	file *os.File
+	bad  bool // Whether the file could not be created or written.
+	size int64
+}
+
+// New returns a Buffer that holds up to limit bytes in memory, and
+// spills to a file if spill is set.
+func New(limit int, spill bool) *Buffer {
+	return &Buffer{Limit: limit, Spill: spill}
+}
+
+func (b *Buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	limit := max(b.Limit, 0)
+	b.size += int64(len(p))
+	if b.Spill && b.file == nil && !b.bad && b.size > int64(limit) {
+		b.spill() // Nothing has been dropped from memory yet.
+	}
+	if b.file != nil && !b.bad {
+		if _, err := b.file.Write(p); err != nil {
+			b.bad = true
+		}
+	}
+	b.mem = append(b.mem, p...)
+	if n := len(b.mem); n > 2*limit {
+		b.mem = append(b.mem[:0], b.mem[n-limit:]...)
+	}
+	return len(p), nil
+}
+
+// spill creates the file and copies the memory buffer to it.
+// b.mu must be held.
+func (b *Buffer) spill() {
+	f, err := os.CreateTemp("", "command-log-*")
+	if err != nil {
+		b.bad = true
+		return
+	}
+	if _, err := f.Write(b.mem); err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		b.bad = true
+		return
+	}
+	b.file = f
+}
+
+// Bytes returns what is held in memory: at most Limit bytes from the
+// end of what was written.
+func (b *Buffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	mem := b.mem
+	if n, limit := len(mem), max(b.Limit, 0); n > limit {
+		mem = mem[n-limit:]
+	}
+	return bytes.Clone(mem)
+}
+
+// Len returns the number of bytes written, including any not held in
+// memory.
+func (b *Buffer) Len() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.size
+}
+
+// Name returns the name of the file holding everything written, or ""
+// if nothing has spilled.
+func (b *Buffer) Name() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.file == nil {
+		return ""
+	}
+	return b.file.Name()
+}
+
+// Close closes the file, if any, leaving it in place.
+func (b *Buffer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.file == nil {
+		return nil
+	}
+	return b.file.Close()
+}
+
+// Remove closes and removes the file, if any.
+func (b *Buffer) Remove() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.file == nil {
+		return nil
+	}
+	_ = b.file.Close()
+	err := os.Remove(b.file.Name())
+	b.file = nil
+	return err
+}
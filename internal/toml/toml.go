@@ -0,0 +1,380 @@
+// Package toml parses the subset of TOML that configuration and task
+// files use: tables, and keys whose values are strings, arrays of
+// strings, or inline tables of strings.
+package toml
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A Table is a table of a document, with its keys in the order they
+// were written.
+type Table struct {
+	Name string // The dotted name, or "" for the root table.
+	Line int    // The line of the header, or 0 for the root table.
+	Keys []KeyValue
+}
+
+// A KeyValue is a key of a table and its value: a string, a []string, or
+// a map[string]string.
+type KeyValue struct {
+	Table string // The name of the table that holds the key.
+	Key   string // The dotted key, relative to the table.
+	Value any
+	Line  int
+}
+
+// Name returns the dotted name of the key, as "table.key".
+func (kv KeyValue) Name() string {
+	if kv.Table == "" {
+		return kv.Key
+	}
+	return kv.Table + "." + kv.Key
+}
+
+// Text returns the value of kv, or an error if it is not a string.
+func (kv KeyValue) Text() (string, error) {
+	s, ok := kv.Value.(string)
+	if !ok {
+		return "", kv.errorf("want a string")
+	}
+	return s, nil
+}
+
+// Strings returns the value of kv, or an error if it is not an array of
+// strings.
+func (kv KeyValue) Strings() ([]string, error) {
+	s, ok := kv.Value.([]string)
+	if !ok {
+		return nil, kv.errorf("want an array of strings")
+	}
+	return s, nil
+}
+
+// Map returns the value of kv, or an error if it is not an inline table
+// of strings.
+func (kv KeyValue) Map() (map[string]string, error) {
+	m, ok := kv.Value.(map[string]string)
+	if !ok {
+		return nil, kv.errorf("want an inline table of strings")
+	}
+	return m, nil
+}
+
+func (kv KeyValue) errorf(format string, args ...any) error {
+	return &Error{
+		Line: kv.Line,
+		Msg:  kv.Name() + ": " + fmt.Sprintf(format, args...),
+	}
+}
+
+// An Error is an error in a document, on the line it names.
+type Error struct {
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// Parse parses doc. The first table it returns is the root table, which
+// holds the keys before the first header, and is returned even if it
+// has none. Keys and tables may not be defined twice.
+func Parse(doc string) ([]*Table, error) {
+	p := &parser{s: doc, line: 1}
+	var (
+		root   = &Table{}
+		tables = []*Table{root}
+		table  = root
+		seen   = make(map[string]bool)
+	)
+	for {
+		p.skipSpace(true)
+		if p.eof() {
+			return tables, nil
+		}
+		line := p.line
+		if p.peek() == '[' {
+			p.pos++
+			p.skipSpace(false)
+			name, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpace(false)
+			if !p.consume(']') {
+				return nil, p.errorf("want ] after table name")
+			}
+			if seen["["+name+"]"] {
+				return nil, p.errorf("table %s defined twice", name)
+			}
+			seen["["+name+"]"] = true
+			table = &Table{Name: name, Line: line}
+			tables = append(tables, table)
+		} else {
+			key, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpace(false)
+			if !p.consume('=') {
+				return nil, p.errorf("want = after key")
+			}
+			p.skipSpace(false)
+			value, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			kv := KeyValue{Table: table.Name, Key: key, Value: value,
+				Line: line}
+			if seen[kv.Name()] {
+				return nil, &Error{Line: line,
+					Msg: kv.Name() + " defined twice"}
+			}
+			seen[kv.Name()] = true
+			table.Keys = append(table.Keys, kv)
+		}
+		p.skipSpace(false)
+		if !p.eof() && p.peek() != '\n' {
+			return nil, p.errorf("want newline")
+		}
+	}
+}
+
+type parser struct {
+	s    string
+	pos  int
+	line int
+}
+
+func (p *parser) eof() bool  { return p.pos >= len(p.s) }
+func (p *parser) peek() byte { return p.s[p.pos] }
+
+func (p *parser) errorf(format string, args ...any) error {
+	return &Error{Line: p.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) consume(c byte) bool {
+	if !p.eof() && p.peek() == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// skipSpace skips spaces, tabs, and comments, and newlines too if
+// newlines is set.
+func (p *parser) skipSpace(newlines bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '\n' && newlines:
+			p.pos++
+			p.line++
+		case c == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// key parses a key, which may be dotted, or quoted.
+func (p *parser) key() (string, error) {
+	var parts []string
+	for {
+		var part string
+		if !p.eof() && (p.peek() == '"' || p.peek() == '\'') {
+			s, err := p.string()
+			if err != nil {
+				return "", err
+			}
+			part = s
+		} else {
+			start := p.pos
+			for !p.eof() && isBareKey(p.peek()) {
+				p.pos++
+			}
+			if p.pos == start {
+				return "", p.errorf("want a key")
+			}
+			part = p.s[start:p.pos]
+		}
+		parts = append(parts, part)
+		p.skipSpace(false)
+		if !p.consume('.') {
+			return strings.Join(parts, "."), nil
+		}
+		p.skipSpace(false)
+	}
+}
+
+func isBareKey(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *parser) value() (any, error) {
+	if p.eof() {
+		return nil, p.errorf("want a value")
+	}
+	switch p.peek() {
+	case '"', '\'':
+		return p.string()
+	case '[':
+		return p.array()
+	case '{':
+		return p.inlineTable()
+	}
+	return nil, p.errorf("unsupported value; " +
+		"want a string, an array, or an inline table")
+}
+
+// array parses an array of strings, which may span lines and end in a
+// comma.
+func (p *parser) array() ([]string, error) {
+	p.pos++ // [
+	list := []string{}
+	for {
+		p.skipSpace(true)
+		if p.consume(']') {
+			return list, nil
+		}
+		if p.eof() {
+			return nil, p.errorf("unterminated array")
+		}
+		s, err := p.string()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+		p.skipSpace(true)
+		if p.consume(']') {
+			return list, nil
+		}
+		if !p.consume(',') {
+			return nil, p.errorf("want , or ] in array")
+		}
+	}
+}
+
+// inlineTable parses an inline table of strings, which must fit on one
+// line.
+func (p *parser) inlineTable() (map[string]string, error) {
+	p.pos++ // {
+	m := make(map[string]string)
+	p.skipSpace(false)
+	if p.consume('}') {
+		return m, nil
+	}
+	for {
+		p.skipSpace(false)
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		if !p.consume('=') {
+			return nil, p.errorf("want = after key")
+		}
+		p.skipSpace(false)
+		if _, ok := m[key]; ok {
+			return nil, p.errorf("%s defined twice", key)
+		}
+		if m[key], err = p.string(); err != nil {
+			return nil, err
+		}
+		p.skipSpace(false)
+		if p.consume('}') {
+			return m, nil
+		}
+		if !p.consume(',') {
+			return nil, p.errorf("want , or } in inline table")
+		}
+	}
+}
+
+func (p *parser) string() (string, error) {
+	if !p.eof() && p.peek() == '\'' {
+		p.pos++
+		end := strings.IndexAny(p.s[p.pos:], "'\n")
+		if end < 0 || p.s[p.pos+end] != '\'' {
+			return "", p.errorf("unterminated string")
+		}
+		s := p.s[p.pos : p.pos+end]
+		p.pos += end + 1
+		return s, nil
+	}
+	if !p.eof() && p.peek() == '"' {
+		return p.basicString()
+	}
+	return "", p.errorf("want a string")
+}
+
+// basicString parses a string in double quotes, with escapes.
+func (p *parser) basicString() (string, error) {
+	p.pos++ // "
+	var b strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.peek()
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if err := p.escape(&b); err != nil {
+				return "", err
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+func (p *parser) escape(b *strings.Builder) error {
+	if p.eof() {
+		return p.errorf("unterminated string")
+	}
+	c := p.peek()
+	p.pos++
+	switch c {
+	case 'b':
+		b.WriteByte('\b')
+	case 't':
+		b.WriteByte('\t')
+	case 'n':
+		b.WriteByte('\n')
+	case 'f':
+		b.WriteByte('\f')
+	case 'r':
+		b.WriteByte('\r')
+	case '"', '\\':
+		b.WriteByte(c)
+	case 'u', 'U':
+		n := 4
+		if c == 'U' {
+			n = 8
+		}
+		if p.pos+n > len(p.s) {
+			return p.errorf("short \\%c escape", c)
+		}
+		r, err := strconv.ParseUint(p.s[p.pos:p.pos+n], 16, 32)
+		if err != nil || !utf8.ValidRune(rune(r)) {
+			return p.errorf("invalid \\%c escape", c)
+		}
+		p.pos += n
+		b.WriteRune(rune(r))
+	default:
+		return p.errorf("invalid escape \\%c", c)
+	}
+	return nil
+}
@@ -1,16 +1,17 @@
 package command
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"golang.org/x/sync/errgroup"
-
-	"lesiw.io/prefix"
 )
 
 var (
@@ -18,16 +19,76 @@ var (
 	// here unless tracing is enabled; see the Tracing section in the
 	// package documentation. The default prefixes each line with "+ ",
 	// mimicking set +x.
-	Trace io.Writer = prefix.NewWriter("+ ", os.Stderr)
+	Trace io.Writer = newPrefixWriter("+ ", os.Stderr)
 
 	// Deprecated: Set the CMDTRACE environment variable and use
 	// [Trace] instead.
-	ShTrace = prefix.NewWriter("+ ", stderr)
+	ShTrace = newPrefixWriter("+ ", stderr)
 
 	stdout io.Writer = os.Stdout
 	stderr io.Writer = os.Stderr
 )
 
+// ErrStalled is returned by [Copy] when its pipeline moves no data for
+// longer than a timeout set with [WithStallTimeout].
+var ErrStalled = errors.New("command: pipeline stalled")
+
+type stallKey struct{}
+
+// WithStallTimeout returns a context whose commands, when they are
+// stages of a [Copy] pipeline, bound how long it waits while no stage
+// moves any data. When the pipeline stalls, Copy cancels the command of
+// every stage and fails with [ErrStalled], reported against the first
+// stage that had not finished: usually the one whose input was never
+// closed.
+//
+// The timeout applies to the buffers of [NewReader], [NewWriter], and
+// [NewFilter]. If the stages of a pipeline have different timeouts, the
+// shortest is used. A timeout of 0 or less clears it.
+//
+//	ctx := command.WithStallTimeout(ctx, time.Minute)
+//	_, err := command.Copy(os.Stdout,
+//	    command.NewReader(ctx, m, "tar", "-c", "."),
+//	    command.NewFilter(ctx, m, "gzip"),
+//	)
+func WithStallTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, stallKey{}, max(d, 0))
+}
+
+func stallTimeout(ctx context.Context) time.Duration {
+	d, _ := ctx.Value(stallKey{}).(time.Duration)
+	return d
+}
+
+// A stallStage is a stage of a pipeline that may set a stall timeout.
+// Its abort method cancels the context of its command, stopping it.
+type stallStage interface {
+	stallTimeout() time.Duration
+	abort()
+}
+
+// pipelineStallTimeout returns the shortest stall timeout of the stages
+// of a pipeline, or 0 if none has one.
+func pipelineStallTimeout(
+	dst io.Writer, src io.Reader, fil []io.ReadWriter,
+) time.Duration {
+	var d time.Duration
+	stages := []any{src, dst}
+	for _, f := range fil {
+		stages = append(stages, f)
+	}
+	for _, v := range stages {
+		s, ok := v.(stallStage)
+		if !ok {
+			continue
+		}
+		if t := s.stallTimeout(); t > 0 && (d == 0 || t < d) {
+			d = t
+		}
+	}
+	return d
+}
+
 // Copy copies the output of each filter into the input of the next filter.
 //
 // Copy uses io.Copy internally, which automatically optimizes for
@@ -37,6 +98,17 @@ var (
 //
 // The fil stages must be both readable and writable (io.ReadWriter). Use
 // NewFilter() to wrap Buffer instances for use in pipelines.
+//
+// On Linux, Copy splices each stage to the next where their Buffers
+// implement [SpliceBuffer]: the commands read and write operating system
+// pipes, and data moves between them, or between them and files, with
+// splice(2), without passing through this process. sys.Machine commands
+// splice. Spliced data is counted in written like any other.
+//
+// If a stage was made with a context from [WithStallTimeout], Copy fails
+// with [ErrStalled] when no stage moves data for that long. Stages are
+// then neither spliced nor copied with io.WriterTo, so that the data
+// they move can be watched. Without a timeout, nothing is watched.
 func Copy(
 	dst io.Writer, src io.Reader, fil ...io.ReadWriter,
 ) (written int64, err error) {
@@ -51,6 +123,17 @@ func Copy(
 
 	results := &copyError{results: make([]copyResult, len(fil)+1)}
 
+	var watch *stallWatch
+	if d := pipelineStallTimeout(dst, src, fil); d > 0 {
+		watch = newStallWatch(d, dst, src, fil)
+		defer watch.stop()
+	}
+
+	var hops []*splicedHop
+	if canSplice && watch == nil {
+		hops = splice(dst, src, fil)
+	}
+
 	go func() {
 		var written int64
 		for n := range count {
@@ -73,21 +156,40 @@ func Copy(
 		i := i
 		w := w
 		r := r
+		if watch != nil {
+			r = watch.reader(r)
+		}
 		g.Go(func() (err error) {
+			var closed bool // Whether w was closed by the hop.
 			defer func() {
+				if watch != nil {
+					watch.done(i + 1)
+				}
 				// Close the writer after copying completes.
 				// This is critical for pipelines using io.Pipe() or similar
 				// constructs, where the next stage's reader won't get EOF
 				// until the writer closes.
 				var closeErr error
-				if c, ok := w.(io.Closer); ok {
+				if c, ok := w.(io.Closer); ok && !closed {
 					closeErr = c.Close()
 				}
+				if h := hopAt(hops, i); h != nil {
+					// r is done with the input it was handed.
+					h.closeIn()
+				}
 				results.set(i+1, copyResult{
 					cmd: cmdString(r),
 					err: errors.Join(err, closeErr),
 				})
 			}()
+			if h := hopAt(hops, i+1); h != nil {
+				closed = i == len(fil)-1 && h.in != nil
+				n, err := h.run(r, w, closed)
+				if err == nil {
+					count <- n
+				}
+				return err
+			}
 			// io.Copy automatically uses ReaderFrom/WriterTo optimizations.
 			// When w implements io.ReaderFrom (like NewWriter), it will
 			// auto-close stdin after the copy completes.
@@ -102,13 +204,263 @@ func Copy(
 	close(count)
 
 	// If any stage errored, return combined error with all results.
-	if err != nil {
+	// A stalled pipeline fails even if its stages then exit cleanly.
+	if watch != nil && watch.blame(results) {
+		err = results
+	} else if err != nil {
 		err = results
 	}
 
 	return <-total, err
 }
 
+// A splicedHop moves data from one stage of a pipeline to the next with
+// splice(2). Each stage is a file, or a command that was handed an end of
+// an operating system pipe.
+type splicedHop struct {
+	r, w *os.File // The ends the data moves between.
+
+	out *os.File // The write end handed to the reader, if any.
+	in  *os.File // The read end handed to the writer, if any.
+
+	inOnce sync.Once
+}
+
+// splice splices each stage of a pipeline to the next where it can, and
+// returns the hops that were spliced, indexed like copyError.results.
+// The other hops are nil.
+func splice(dst io.Writer, src io.Reader, fil []io.ReadWriter) []*splicedHop {
+	hops := make([]*splicedHop, len(fil)+1)
+	for i := -1; i < len(fil); i++ {
+		var (
+			r io.Reader = src
+			w io.Writer = dst
+		)
+		if i >= 0 {
+			r = fil[i]
+		}
+		if i < len(fil)-1 {
+			w = fil[i+1]
+		}
+		hops[i+1] = spliceHop(r, w)
+	}
+	return hops
+}
+
+// spliceHop splices r to w, if both are files or commands that take an
+// end of a pipe, and one is a command.
+func spliceHop(r io.Reader, w io.Writer) *splicedHop {
+	h := new(splicedHop)
+	switch w := w.(type) {
+	case *os.File:
+		h.w = w
+	case spliceFromer:
+		pr, pw, err := newPipe()
+		if err != nil {
+			return nil
+		}
+		if !w.SpliceFrom(pr) {
+			_, _ = pr.Close(), pw.Close()
+			return nil
+		}
+		h.w, h.in = pw, pr
+	default:
+		return nil
+	}
+	switch r := r.(type) {
+	case *os.File:
+		h.r = r
+	case spliceToer:
+		pr, pw, err := newPipe()
+		if err == nil && r.SpliceTo(pw) {
+			h.r, h.out = pr, pw
+		} else if err == nil {
+			_, _ = pr.Close(), pw.Close()
+		}
+	}
+	if h.in == nil && h.out == nil {
+		return nil // Files are copied as usual.
+	}
+	return h
+}
+
+// run moves the data of the hop from r to w, and returns how much it
+// moved. If r was not spliced, it is copied into the pipe of w. If
+// closeW is set, w is closed as the data moves, since a writer whose
+// input was spliced runs only once it is closed.
+func (h *splicedHop) run(
+	r io.Reader, w io.Writer, closeW bool,
+) (n int64, err error) {
+	var g errgroup.Group
+	g.Go(func() (err error) {
+		if h.r != nil {
+			n, err = spliceFile(h.w, h.r)
+		} else {
+			n, err = io.Copy(h.w, r)
+		}
+		if h.out != nil {
+			err = errors.Join(err, h.r.Close())
+		}
+		if h.in != nil {
+			// Closing the write end ends the input of the writer.
+			err = errors.Join(err, h.w.Close())
+		}
+		return err
+	})
+	if h.out != nil {
+		g.Go(func() error {
+			// No data passes through here, but a command must still be
+			// read to run it to completion. Its output ends with it.
+			_, err := io.Copy(io.Discard, r)
+			return errors.Join(err, h.out.Close())
+		})
+	}
+	if c, ok := w.(io.Closer); ok && closeW {
+		g.Go(func() error {
+			defer h.closeIn()
+			return c.Close()
+		})
+	}
+	err = g.Wait()
+	return n, err
+}
+
+// hopAt returns the hop at i, or nil if it was not spliced.
+func hopAt(hops []*splicedHop, i int) *splicedHop {
+	if i < 0 || i >= len(hops) {
+		return nil
+	}
+	return hops[i]
+}
+
+// closeIn closes the read end handed to the writer of the hop, once the
+// writer is done with it, so that writing to a writer that exited early
+// fails instead of blocking.
+func (h *splicedHop) closeIn() {
+	if h.in != nil {
+		h.inOnce.Do(func() { _ = h.in.Close() })
+	}
+}
+
+// A stallWatch stops a pipeline that stops moving data.
+type stallWatch struct {
+	timeout time.Duration
+	stages  []stallStage
+	closers []io.Closer
+	last    atomic.Int64 // Time of the last progress, in Unix nanoseconds.
+	running []atomic.Bool
+	stalled atomic.Int64 // Index of the stalled stage, plus one.
+	quit    chan struct{}
+}
+
+func newStallWatch(
+	timeout time.Duration, dst io.Writer, src io.Reader, fil []io.ReadWriter,
+) *stallWatch {
+	sw := &stallWatch{
+		timeout: timeout,
+		running: make([]atomic.Bool, len(fil)+1),
+		quit:    make(chan struct{}),
+	}
+	stages := []any{src, dst}
+	for _, f := range fil {
+		stages = append(stages, f)
+	}
+	for _, v := range stages {
+		if s, ok := v.(stallStage); ok {
+			sw.stages = append(sw.stages, s)
+		}
+		if c, ok := v.(io.Closer); ok {
+			sw.closers = append(sw.closers, c)
+		}
+	}
+	for i := range sw.running {
+		sw.running[i].Store(true)
+	}
+	sw.last.Store(time.Now().UnixNano())
+	go sw.run()
+	return sw
+}
+
+// stallTick is the shortest interval at which a stallWatch checks for a
+// stall.
+const stallTick = time.Millisecond
+
+func (sw *stallWatch) run() {
+	t := time.NewTicker(max(sw.timeout/4, stallTick))
+	defer t.Stop()
+	for {
+		select {
+		case <-sw.quit:
+			return
+		case <-t.C:
+		}
+		idle := time.Since(time.Unix(0, sw.last.Load()))
+		if idle < sw.timeout {
+			continue
+		}
+		for i := range sw.running {
+			if sw.running[i].Load() {
+				sw.stalled.Store(int64(i) + 1)
+				break
+			}
+		}
+		if sw.stalled.Load() == 0 {
+			sw.stalled.Store(1) // Every stage finished as it fired.
+		}
+		// Closing a stage only closes its input, so cancel each
+		// command as well.
+		for _, s := range sw.stages {
+			s.abort()
+		}
+		for _, c := range sw.closers {
+			_ = c.Close() // Unblock every stage.
+		}
+		return
+	}
+}
+
+func (sw *stallWatch) stop() { close(sw.quit) }
+
+func (sw *stallWatch) done(stage int) {
+	sw.running[stage].Store(false)
+	sw.last.Store(time.Now().UnixNano())
+}
+
+// blame marks the stalled stage, if any, in results, and reports whether
+// the pipeline stalled.
+func (sw *stallWatch) blame(results *copyError) bool {
+	i := int(sw.stalled.Load()) - 1
+	if i < 0 {
+		return false
+	}
+	results.Lock()
+	defer results.Unlock()
+	stall := fmt.Errorf("%w: no data moved for %v", ErrStalled, sw.timeout)
+	results.results[i].err = errors.Join(stall, results.results[i].err)
+	return true
+}
+
+func (sw *stallWatch) reader(r io.Reader) io.Reader {
+	return &stallReader{r, sw}
+}
+
+// A stallReader records progress on each read. It keeps the String
+// method of the reader it wraps, for error messages.
+type stallReader struct {
+	io.Reader
+	sw *stallWatch
+}
+
+func (r *stallReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.sw.last.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
+
+func (r *stallReader) String() string { return cmdString(r.Reader) }
+
 type copyResult struct {
 	cmd string
 	err error
@@ -2,11 +2,13 @@ package command
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"io"
 	"strings"
 	"testing"
 	"testing/iotest"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -122,3 +124,77 @@ func TestReadAll(t *testing.T) {
 		})
 	}
 }
+
+type stuckReader struct{ *io.PipeReader }
+
+func (stuckReader) String() string { return "stuck source" }
+
+// A catBuffer is a Buffer that outputs its input, like cat.
+type catBuffer struct {
+	r *io.PipeReader
+	w *io.PipeWriter
+}
+
+func newCatBuffer() *catBuffer {
+	r, w := io.Pipe()
+	return &catBuffer{r, w}
+}
+
+func (b *catBuffer) Read(p []byte) (int, error)  { return b.r.Read(p) }
+func (b *catBuffer) Write(p []byte) (int, error) { return b.w.Write(p) }
+func (b *catBuffer) Close() error                { return b.w.Close() }
+
+func TestCopyStall(t *testing.T) {
+	for _, d := range []time.Duration{50 * time.Millisecond, 3} {
+		t.Run(d.String(), func(t *testing.T) { testCopyStall(t, d) })
+	}
+}
+
+func testCopyStall(t *testing.T, d time.Duration) {
+	ctx := WithStallTimeout(t.Context(), d)
+	m := MachineFunc(func(context.Context, ...string) Buffer {
+		return newCatBuffer()
+	})
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	_, err := Copy(io.Discard, stuckReader{pr}, NewFilter(ctx, m, "cat"))
+
+	if !errors.Is(err, ErrStalled) {
+		t.Fatalf("Copy() error = %v, want ErrStalled", err)
+	}
+	want := "stuck source\n\tcommand: pipeline stalled"
+	if got := err.Error(); !strings.HasPrefix(got, want) {
+		t.Errorf("Copy() error = %q, want prefix %q", got, want)
+	}
+}
+
+// A sleepBuffer is a Buffer that ignores its input and outputs nothing,
+// exiting cleanly only when its context is canceled, like sleep.
+type sleepBuffer struct{ ctx context.Context }
+
+func (b sleepBuffer) Read([]byte) (int, error) {
+	<-b.ctx.Done()
+	return 0, io.EOF
+}
+func (sleepBuffer) Write(p []byte) (int, error) { return len(p), nil }
+func (sleepBuffer) Close() error                { return nil }
+
+func TestCopyStallCancelsFilter(t *testing.T) {
+	ctx := WithStallTimeout(t.Context(), 50*time.Millisecond)
+	m := MachineFunc(func(ctx context.Context, args ...string) Buffer {
+		if args[0] == "sleep" {
+			return sleepBuffer{ctx}
+		}
+		return strings.NewReader("x\n")
+	})
+
+	_, err := Copy(io.Discard,
+		NewReader(ctx, m, "echo", "x"),
+		NewFilter(ctx, m, "sleep"),
+	)
+
+	if !errors.Is(err, ErrStalled) {
+		t.Fatalf("Copy() error = %v, want ErrStalled", err)
+	}
+}
@@ -0,0 +1,232 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"io"
+	iofs "io/fs"
+	"slices"
+	"strings"
+
+	"lesiw.io/fs"
+	"lesiw.io/fs/path"
+)
+
+// IOFS returns a view of the file tree at root on m as an io/fs.FS, so
+// that code written for the standard library, such as fs.WalkDir and
+// template.ParseFS, can read the files of remote and container machines:
+//
+//	fsys := command.IOFS(ctx, m, "/etc/app")
+//	tmpl, err := template.ParseFS(fsys, "templates/*.tmpl")
+//
+// The view uses the filesystem of [FS], with ctx for each operation, so
+// it takes the fast paths of machines that have them. A relative root is
+// relative to the working directory of ctx. Names are as io/fs requires:
+// unrooted, slash-separated paths. Where the filesystem lists directories
+// with commands, their entries may report modification times only to the
+// minute.
+//
+// The returned FS also implements [WriteFS], for callers that need to
+// change the tree, and the ReadDirFS, ReadFileFS, StatFS, and SubFS
+// interfaces of io/fs.
+func IOFS(ctx context.Context, m Machine, root string) iofs.FS {
+	if root == "" {
+		root = "."
+	}
+	return &ioFS{ctx: ctx, fsys: FS(m), root: root}
+}
+
+// A WriteFS is an io/fs.FS that can be changed, as returned by [IOFS].
+type WriteFS interface {
+	iofs.FS
+
+	// WriteFile writes data to the named file, creating it with
+	// permissions perm if it does not exist.
+	WriteFile(name string, data []byte, perm iofs.FileMode) error
+
+	// MkdirAll creates the named directory, with permissions perm,
+	// along with any parents that do not exist.
+	MkdirAll(name string, perm iofs.FileMode) error
+
+	// Remove removes the named file or empty directory.
+	Remove(name string) error
+}
+
+type ioFS struct {
+	ctx  context.Context
+	fsys fs.FS
+	root string
+}
+
+var (
+	_ WriteFS         = (*ioFS)(nil)
+	_ iofs.ReadDirFS  = (*ioFS)(nil)
+	_ iofs.ReadFileFS = (*ioFS)(nil)
+	_ iofs.StatFS     = (*ioFS)(nil)
+	_ iofs.SubFS      = (*ioFS)(nil)
+)
+
+// path returns the path on the machine of name, after checking that it
+// is valid for op. Backslashes are not valid, since machines may take
+// them for separators.
+func (f *ioFS) path(op, name string) (string, error) {
+	if !iofs.ValidPath(name) || strings.Contains(name, `\`) {
+		return "", &iofs.PathError{Op: op, Path: name, Err: iofs.ErrInvalid}
+	}
+	if name == "." {
+		return f.root, nil
+	}
+	return path.Join(f.root, name), nil
+}
+
+// pathError returns err as an error of op on name, unless it is nil.
+func pathError(op, name string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if pe := new(iofs.PathError); errors.As(err, &pe) {
+		err = pe.Err
+	}
+	return &iofs.PathError{Op: op, Path: name, Err: err}
+}
+
+func (f *ioFS) Open(name string) (iofs.File, error) {
+	full, err := f.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(f.ctx, f.fsys, full)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	if info.IsDir() {
+		return &ioDir{fsys: f, name: name, info: info}, nil
+	}
+	r, err := fs.Open(f.ctx, f.fsys, full)
+	if err != nil {
+		return nil, pathError("open", name, err)
+	}
+	return &ioFile{ReadPathCloser: r, info: info}, nil
+}
+
+func (f *ioFS) Stat(name string) (iofs.FileInfo, error) {
+	full, err := f.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(f.ctx, f.fsys, full)
+	return info, pathError("stat", name, err)
+}
+
+func (f *ioFS) ReadFile(name string) ([]byte, error) {
+	full, err := f.path("readfile", name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := fs.ReadFile(f.ctx, f.fsys, full)
+	return data, pathError("readfile", name, err)
+}
+
+func (f *ioFS) ReadDir(name string) ([]iofs.DirEntry, error) {
+	full, err := f.path("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	var entries []iofs.DirEntry
+	for entry, err := range fs.ReadDir(f.ctx, f.fsys, full) {
+		if err != nil {
+			return entries, pathError("readdir", name, err)
+		}
+		entries = append(entries, entry)
+	}
+	slices.SortFunc(entries, func(a, b iofs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return entries, nil
+}
+
+func (f *ioFS) Sub(dir string) (iofs.FS, error) {
+	full, err := f.path("sub", dir)
+	if err != nil {
+		return nil, err
+	}
+	return &ioFS{ctx: f.ctx, fsys: f.fsys, root: full}, nil
+}
+
+func (f *ioFS) WriteFile(
+	name string, data []byte, perm iofs.FileMode,
+) error {
+	full, err := f.path("writefile", name)
+	if err != nil {
+		return err
+	}
+	err = fs.WriteFile(fs.WithFileMode(f.ctx, perm), f.fsys, full, data)
+	return pathError("writefile", name, err)
+}
+
+func (f *ioFS) MkdirAll(name string, perm iofs.FileMode) error {
+	full, err := f.path("mkdir", name)
+	if err != nil {
+		return err
+	}
+	ctx := fs.WithDirMode(f.ctx, perm)
+	return pathError("mkdir", name, fs.MkdirAll(ctx, f.fsys, full))
+}
+
+func (f *ioFS) Remove(name string) error {
+	full, err := f.path("remove", name)
+	if err != nil {
+		return err
+	}
+	return pathError("remove", name, fs.Remove(f.ctx, f.fsys, full))
+}
+
+// An ioFile is a regular file opened by an ioFS.
+type ioFile struct {
+	fs.ReadPathCloser
+	info iofs.FileInfo
+}
+
+func (f *ioFile) Stat() (iofs.FileInfo, error) { return f.info, nil }
+
+// An ioDir is a directory opened by an ioFS. Its entries are read on the
+// first call to ReadDir.
+type ioDir struct {
+	fsys    *ioFS
+	name    string
+	info    iofs.FileInfo
+	entries []iofs.DirEntry
+	read    bool
+}
+
+func (d *ioDir) Stat() (iofs.FileInfo, error) { return d.info, nil }
+
+func (d *ioDir) Read([]byte) (int, error) {
+	return 0, &iofs.PathError{
+		Op: "read", Path: d.name, Err: errors.New("is a directory"),
+	}
+}
+
+func (d *ioDir) Close() error { return nil }
+
+func (d *ioDir) ReadDir(n int) ([]iofs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.read = entries, true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
@@ -0,0 +1,131 @@
+package command_test
+
+import (
+	"errors"
+	iofs "io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"testing/fstest"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+	"lesiw.io/command/sys"
+	"lesiw.io/fs"
+)
+
+func TestIOFS(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{
+		"a.txt":       "a",
+		"sub/b.txt":   "b",
+		"sub/c/d.txt": "d",
+	} {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root := filepath.ToSlash(dir)
+	fsys := command.IOFS(t.Context(), sys.Machine(), root)
+	err := fstest.TestFS(fsys, "a.txt", "sub/b.txt", "sub/c/d.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmds := mock.Passthrough(sys.Machine())
+	cmds.SetFS(nil) // Operate on files with commands.
+	fsys = command.IOFS(t.Context(), cmds, root)
+	var files []string
+	err = iofs.WalkDir(fsys, ".",
+		func(name string, d iofs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			data, err := iofs.ReadFile(fsys, name)
+			files = append(files, name+"="+string(data))
+			return err
+		})
+	if err != nil {
+		t.Fatalf("fs.WalkDir error: %v", err)
+	}
+	want := []string{"a.txt=a", "sub/b.txt=b", "sub/c/d.txt=d"}
+	if !slices.Equal(files, want) {
+		t.Errorf("files = %q, want %q", files, want)
+	}
+}
+
+func TestIOFSMachine(t *testing.T) {
+	m := new(mock.Machine)
+	m.SetOS("linux")
+	ctx := t.Context()
+	if err := fs.MkdirAll(ctx, m.FS(), "/srv/app"); err != nil {
+		t.Fatal(err)
+	}
+	err := fs.WriteFile(ctx, m.FS(), "/srv/app/config", []byte("x=1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fsys := command.IOFS(ctx, m, "/srv")
+	data, err := iofs.ReadFile(fsys, "app/config")
+	if err != nil {
+		t.Fatalf("fs.ReadFile error: %v", err)
+	}
+	if got, want := string(data), "x=1\n"; got != want {
+		t.Errorf("fs.ReadFile = %q, want %q", got, want)
+	}
+	var names []string
+	err = iofs.WalkDir(fsys, ".",
+		func(name string, _ iofs.DirEntry, err error) error {
+			names = append(names, name)
+			return err
+		})
+	if err != nil {
+		t.Fatalf("fs.WalkDir error: %v", err)
+	}
+	if got, want := len(names), 3; got != want {
+		t.Errorf("fs.WalkDir visited %q, want %d names", names, want)
+	}
+
+	_, err = fsys.Open("missing")
+	if !errors.Is(err, iofs.ErrNotExist) {
+		t.Errorf("Open(missing) error: %v, want ErrNotExist", err)
+	}
+	_, err = fsys.Open("/srv/app")
+	if !errors.Is(err, iofs.ErrInvalid) {
+		t.Errorf("Open(/srv/app) error: %v, want ErrInvalid", err)
+	}
+}
+
+func TestIOFSWrite(t *testing.T) {
+	m := new(mock.Machine)
+	m.SetOS("linux")
+	ctx := t.Context()
+	if err := fs.MkdirAll(ctx, m.FS(), "/srv"); err != nil {
+		t.Fatal(err)
+	}
+	wfs, ok := command.IOFS(ctx, m, "/srv").(command.WriteFS)
+	if !ok {
+		t.Fatal("IOFS does not implement WriteFS")
+	}
+	if err := wfs.MkdirAll("a/b", 0o755); err != nil {
+		t.Fatalf("WriteFS.MkdirAll error: %v", err)
+	}
+	if err := wfs.WriteFile("a/b/f", []byte("hi"), 0o600); err != nil {
+		t.Fatalf("WriteFS.WriteFile error: %v", err)
+	}
+	data, err := fs.ReadFile(ctx, m.FS(), "/srv/a/b/f")
+	if err != nil || string(data) != "hi" {
+		t.Errorf("fs.ReadFile = %q, %v, want %q", data, err, "hi")
+	}
+	if err := wfs.Remove("a/b/f"); err != nil {
+		t.Fatalf("WriteFS.Remove error: %v", err)
+	}
+	if _, err := iofs.Stat(wfs, "a/b/f"); !errors.Is(err, iofs.ErrNotExist) {
+		t.Errorf("fs.Stat error: %v, want ErrNotExist", err)
+	}
+}
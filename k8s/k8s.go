@@ -0,0 +1,325 @@
+// Package k8s implements a command.Machine that executes commands in a
+// container of a Kubernetes pod.
+//
+// Commands run through the exec API of the pod, as kubectl exec runs
+// them: over WebSocket, or SPDY for API servers that do not support it.
+// The Machine is given a clientset and the REST configuration it was
+// made from, so it authenticates as the clientset does:
+//
+//	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+//	if err != nil {
+//	    return err
+//	}
+//	clientset, err := kubernetes.NewForConfig(config)
+//	if err != nil {
+//	    return err
+//	}
+//	m := k8s.Machine(clientset, config, "web", "web-0", "app")
+//	out, err := command.Read(ctx, m, "cat", "/etc/hostname")
+//
+// Input streams into the container, and the exit code of a command in the
+// container is the Code of its [command.Error]. When the API server cannot
+// run the command at all, as when the pod does not exist, the error
+// instead holds an [ExecError].
+package k8s
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+	"k8s.io/client-go/util/exec"
+
+	"lesiw.io/command"
+	"lesiw.io/command/internal/sh"
+	"lesiw.io/command/internal/spill"
+	"lesiw.io/fs"
+)
+
+// identRE matches the names of shell variables.
+var identRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// An ExecError reports that the API server could not run a command in a
+// container, as when the pod or container does not exist, or the API
+// server cannot be reached. Err is the error of the API client, which
+// can be examined with the functions of k8s.io/apimachinery's errors
+// package, such as IsNotFound.
+type ExecError struct {
+	Namespace string
+	Pod       string
+	Container string
+	Err       error
+}
+
+func (e *ExecError) Error() string {
+	target := e.Namespace + "/" + e.Pod
+	if e.Container != "" {
+		target += "/" + e.Container
+	}
+	return "k8s: exec in " + target + ": " + e.Err.Error()
+}
+
+func (e *ExecError) Unwrap() error { return e.Err }
+
+// Machine returns a command.Machine that executes commands in container
+// of pod in namespace, through the exec API of clientset, which was made
+// from config. If container is empty, the pod must have only one. If
+// namespace is empty, it is "default".
+//
+// Commands given an environment or working directory by their context
+// run under sh in the container, which must have it.
+func Machine(
+	clientset kubernetes.Interface, config *rest.Config,
+	namespace, pod, container string,
+) command.Machine {
+	if namespace == "" {
+		namespace = corev1.NamespaceDefault
+	}
+	return &machine{
+		client:    clientset,
+		config:    config,
+		namespace: namespace,
+		pod:       pod,
+		container: container,
+	}
+}
+
+type machine struct {
+	client    kubernetes.Interface
+	config    *rest.Config
+	namespace string
+	pod       string
+	container string
+}
+
+func (m *machine) Command(ctx context.Context, args ...string) command.Buffer {
+	if len(args) == 0 {
+		return command.Fail(errors.New("no command given"))
+	}
+	b := &execBuffer{
+		ctx:   ctx,
+		m:     m,
+		args:  args,
+		merge: command.MergedStderr(ctx),
+		done:  make(chan error, 1),
+	}
+
+	// The exec API has no fields for the environment or working
+	// directory of a command, so they are set by a shell in the
+	// container.
+	var prefix strings.Builder
+	if dir := fs.WorkDir(ctx); dir != "" {
+		prefix.WriteString("cd " + sh.Quote(dir) + " && ")
+	}
+	for k, v := range command.Envs(ctx) {
+		if !identRE.MatchString(k) {
+			return command.Fail(&command.Error{Err: fmt.Errorf(
+				"k8s: bad environment variable name %q", k)})
+		}
+		prefix.WriteString("export " + k + "=" + sh.Quote(v) + "; ")
+	}
+	stdout, stderr := command.StdoutFile(ctx), command.StderrFile(ctx)
+	if prefix.Len() > 0 || stdout != "" || stderr != "" {
+		inner := prefix.String() + `exec "$@"` +
+			sh.Redirect(stdout, stderr, b.merge)
+		b.args = append([]string{"sh", "-c", inner, "sh"}, args...)
+		b.merge = false
+	}
+	b.start = sync.OnceValue(b.startFunc)
+	b.wait = sync.OnceValue(b.waitFunc)
+	return b
+}
+
+// newExecutor returns an executor for the exec request at u, which tries
+// WebSocket and falls back to SPDY, as kubectl does.
+func newExecutor(config *rest.Config, u *url.URL) (
+	remotecommand.Executor, error,
+) {
+	spdy, err := remotecommand.NewSPDYExecutor(config, "POST", u)
+	if err != nil {
+		return nil, err
+	}
+	ws, err := remotecommand.NewWebSocketExecutor(config, "GET", u.String())
+	if err != nil {
+		return nil, err
+	}
+	return remotecommand.NewFallbackExecutor(ws, spdy, func(err error) bool {
+		return httpstream.IsUpgradeFailure(err) ||
+			httpstream.IsHTTPSProxyError(err)
+	})
+}
+
+// An execBuffer is the command.Buffer of a command run by a machine.
+type execBuffer struct {
+	ctx   context.Context
+	m     *machine
+	args  []string
+	merge bool // Send diagnostic output to output.
+
+	start func() error
+	wait  func() error
+	done  chan error
+
+	attached bool
+	reader   *io.PipeReader
+	writer   *io.PipeWriter
+	logger   io.Writer
+	logbuf   *spill.Buffer
+}
+
+var (
+	_ command.WriteBuffer  = (*execBuffer)(nil)
+	_ command.LogBuffer    = (*execBuffer)(nil)
+	_ command.AttachBuffer = (*execBuffer)(nil)
+)
+
+func (b *execBuffer) startFunc() error {
+	req := b.m.client.CoreV1().RESTClient().Post().
+		Resource("pods").
+		Namespace(b.m.namespace).
+		Name(b.m.pod).
+		SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: b.m.container,
+			Command:   b.args,
+			Stdin:     true,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	e, err := newExecutor(b.m.config, req.URL())
+	if err != nil {
+		return &command.Error{Err: b.execError(err), Code: 1}
+	}
+	var (
+		opts remotecommand.StreamOptions
+		in   *io.PipeReader
+		pw   *io.PipeWriter
+	)
+	if b.attached {
+		opts.Stdin, opts.Stdout, opts.Stderr = os.Stdin, os.Stdout, os.Stderr
+	} else {
+		in, b.writer = io.Pipe()
+		b.reader, pw = io.Pipe()
+		opts.Stdin, opts.Stdout = in, pw
+		switch {
+		case b.merge:
+			opts.Stderr = pw
+		case b.logger != nil:
+			opts.Stderr = b.logger
+		default:
+			b.logbuf = spill.New(command.LogLimit(b.ctx),
+				command.LogSpill(b.ctx))
+			opts.Stderr = b.logbuf
+		}
+	}
+	go func() {
+		err := e.StreamWithContext(b.ctx, opts)
+		if in != nil {
+			_ = in.Close() // Fail writes to the finished command.
+		}
+		if pw != nil {
+			_ = pw.Close()
+		}
+		b.done <- err
+	}()
+	return nil
+}
+
+func (b *execBuffer) waitFunc() error {
+	err := <-b.done
+	if b.logbuf != nil {
+		defer b.logbuf.Close()
+	}
+	if err == nil {
+		if b.logbuf != nil {
+			_ = b.logbuf.Remove()
+		}
+		return nil
+	}
+	e := &command.Error{Code: 1}
+	if ee := exec.ExitError(nil); errors.As(err, &ee) && ee.Exited() {
+		e.Code = ee.ExitStatus()
+	} else if b.ctx.Err() == nil {
+		e.Err = b.execError(err)
+	} // Else the command was interrupted, as Interrupted records.
+	if b.logbuf != nil {
+		e.Log, e.LogFile = b.logbuf.Bytes(), b.logbuf.Name()
+	}
+	return command.Interrupted(b.ctx, e)
+}
+
+func (b *execBuffer) execError(err error) error {
+	return &ExecError{
+		Namespace: b.m.namespace,
+		Pod:       b.m.pod,
+		Container: b.m.container,
+		Err:       err,
+	}
+}
+
+func (b *execBuffer) Read(p []byte) (int, error) {
+	if err := b.start(); err != nil {
+		return 0, err
+	}
+	if b.reader == nil {
+		if err := b.wait(); err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+	n, err := b.reader.Read(p)
+	if err != nil {
+		if werr := b.wait(); werr != nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+func (b *execBuffer) Write(p []byte) (int, error) {
+	if err := b.start(); err != nil {
+		return 0, err
+	}
+	if b.writer == nil {
+		return 0, nil
+	}
+	return b.writer.Write(p)
+}
+
+func (b *execBuffer) Close() error {
+	if err := b.start(); err != nil {
+		return err
+	}
+	if b.writer == nil {
+		return nil
+	}
+	return b.writer.Close()
+}
+
+func (b *execBuffer) Log(w io.Writer) { b.logger = w }
+
+func (b *execBuffer) Attach() error {
+	b.attached = true
+	return nil
+}
+
+func (b *execBuffer) String() string {
+	target := b.m.namespace + "/" + b.m.pod
+	if b.m.container != "" {
+		target += "/" + b.m.container
+	}
+	return sh.String(nil, append([]string{"k8s", target}, b.args...)...).
+		String()
+}
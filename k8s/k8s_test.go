@@ -0,0 +1,259 @@
+package k8s_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream/wsstream"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+
+	"lesiw.io/command"
+	"lesiw.io/command/k8s"
+	"lesiw.io/fs"
+)
+
+// An apiServer serves the exec API of the pod web-0 in the namespace
+// web, running the commands it is given locally. Other pods do not
+// exist.
+type apiServer struct {
+	mu    sync.Mutex
+	calls []execCall
+}
+
+// An execCall is the container and command of an exec request.
+type execCall struct {
+	container string
+	command   []string
+}
+
+// fakeAPI starts an apiServer, and returns a clientset for it and the
+// configuration it was made from.
+func fakeAPI(t *testing.T) (
+	*apiServer, kubernetes.Interface, *rest.Config,
+) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	api := new(apiServer)
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	config := &rest.Config{Host: srv.URL}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		t.Fatalf("kubernetes.NewForConfig error: %v", err)
+	}
+	return api, clientset, config
+}
+
+func (api *apiServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/v1/namespaces/web/pods/web-0/exec" {
+		pod := strings.Split(r.URL.Path, "/")[6]
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(&metav1.Status{
+			TypeMeta: metav1.TypeMeta{Kind: "Status", APIVersion: "v1"},
+			Status:   metav1.StatusFailure,
+			Message:  `pods "` + pod + `" not found`,
+			Reason:   metav1.StatusReasonNotFound,
+			Details:  &metav1.StatusDetails{Name: pod, Kind: "pods"},
+			Code:     http.StatusNotFound,
+		})
+		return
+	}
+	query := r.URL.Query()
+	args := query["command"]
+	api.mu.Lock()
+	api.calls = append(api.calls,
+		execCall{container: query.Get("container"), command: args})
+	api.mu.Unlock()
+
+	conn := wsstream.NewConn(map[string]wsstream.ChannelProtocolConfig{
+		"v5.channel.k8s.io": {Binary: true, Channels: []wsstream.ChannelType{
+			wsstream.ReadChannel,   // stdin
+			wsstream.WriteChannel,  // stdout
+			wsstream.WriteChannel,  // stderr
+			wsstream.WriteChannel,  // error
+			wsstream.IgnoreChannel, // resize
+		}},
+	})
+	_, streams, err := conn.Open(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	cmd := exec.Command(args[0], args[1:]...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return
+	}
+	go func() {
+		_, _ = io.Copy(stdin, streams[0])
+		_ = stdin.Close()
+	}()
+	cmd.Stdout, cmd.Stderr = streams[1], streams[2]
+	status := &metav1.Status{Status: metav1.StatusSuccess}
+	if err := cmd.Run(); err != nil {
+		status = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: err.Error(),
+			Reason:  "NonZeroExitCode",
+		}
+		if ee := new(exec.ExitError); errors.As(err, &ee) {
+			status.Details = &metav1.StatusDetails{
+				Causes: []metav1.StatusCause{{
+					Type:    "ExitCode",
+					Message: strconv.Itoa(ee.ExitCode()),
+				}},
+			}
+		}
+	}
+	_ = json.NewEncoder(streams[3]).Encode(status)
+}
+
+func (api *apiServer) lastCall(t *testing.T) execCall {
+	t.Helper()
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	if len(api.calls) == 0 {
+		t.Fatal("no exec requests")
+	}
+	return api.calls[len(api.calls)-1]
+}
+
+func TestMachineArgs(t *testing.T) {
+	api, clientset, config := fakeAPI(t)
+	km := k8s.Machine(clientset, config, "web", "web-0", "app")
+	ctx := command.WithEnv(t.Context(), map[string]string{"MODE": "a b"})
+	ctx = fs.WithWorkDir(ctx, "/")
+
+	out, err := command.Read(ctx, km, "sh", "-c", `echo "$MODE"; pwd`)
+	if err != nil {
+		t.Fatalf("command.Read error: %v", err)
+	}
+	if want := "a b\n/"; out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+	got := api.lastCall(t)
+	want := []string{
+		"sh", "-c", `cd / && export MODE='a b'; exec "$@"`, "sh",
+		"sh", "-c", `echo "$MODE"; pwd`,
+	}
+	if got.container != "app" {
+		t.Errorf("container = %q, want %q", got.container, "app")
+	}
+	if strings.Join(got.command, "\x00") != strings.Join(want, "\x00") {
+		t.Errorf("command = %q, want %q", got.command, want)
+	}
+}
+
+func TestMachineNoPrefix(t *testing.T) {
+	api, clientset, config := fakeAPI(t)
+	km := k8s.Machine(clientset, config, "web", "web-0", "")
+	if err := command.Do(t.Context(), km, "true"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+	got := api.lastCall(t)
+	if got.container != "" {
+		t.Errorf("container = %q, want none", got.container)
+	}
+	if len(got.command) != 1 || got.command[0] != "true" {
+		t.Errorf("command = %q, want %q", got.command, []string{"true"})
+	}
+}
+
+func TestMachineBadEnv(t *testing.T) {
+	api, clientset, config := fakeAPI(t)
+	km := k8s.Machine(clientset, config, "web", "web-0", "app")
+	ctx := command.WithEnv(t.Context(),
+		map[string]string{"X=1; touch /tmp/pwned; Y": "v"})
+
+	err := command.Do(ctx, km, "true")
+	if err == nil || !strings.Contains(err.Error(), "environment variable") {
+		t.Errorf("command.Do err = %v, want bad variable name", err)
+	}
+	if len(api.calls) > 0 {
+		t.Errorf("exec requests = %v, want none", api.calls)
+	}
+}
+
+func TestMachineStdin(t *testing.T) {
+	_, clientset, config := fakeAPI(t)
+	km := k8s.Machine(clientset, config, "web", "web-0", "app")
+	var out strings.Builder
+	_, err := command.Copy(&out, strings.NewReader("hello\n"),
+		command.NewFilter(t.Context(), km, "tr", "a-z", "A-Z"))
+	if err != nil {
+		t.Fatalf("command.Copy error: %v", err)
+	}
+	if got, want := out.String(), "HELLO\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestMachineExitCode(t *testing.T) {
+	_, clientset, config := fakeAPI(t)
+	km := k8s.Machine(clientset, config, "web", "web-0", "app")
+	err := command.Do(t.Context(), km, "sh", "-c", "echo oops >&2; exit 3")
+	var cerr *command.Error
+	if !errors.As(err, &cerr) {
+		t.Fatalf("error = %v, want *command.Error", err)
+	}
+	if cerr.Code != 3 {
+		t.Errorf("Code = %d, want 3", cerr.Code)
+	}
+	if got, want := string(cerr.Log), "oops\n"; got != want {
+		t.Errorf("Log = %q, want %q", got, want)
+	}
+	if ee := new(k8s.ExecError); errors.As(err, &ee) {
+		t.Errorf("error = %v, want no ExecError", err)
+	}
+}
+
+func TestMachineExecError(t *testing.T) {
+	_, clientset, config := fakeAPI(t)
+	km := k8s.Machine(clientset, config, "web", "missing", "app")
+	err := command.Do(t.Context(), km, "true")
+	ee := new(k8s.ExecError)
+	if !errors.As(err, &ee) {
+		t.Fatalf("error = %v, want *k8s.ExecError", err)
+	}
+	if ee.Namespace != "web" || ee.Pod != "missing" || ee.Container != "app" {
+		t.Errorf("ExecError = %+v, want web/missing/app", ee)
+	}
+	if !apierrors.IsNotFound(err) {
+		t.Errorf("error = %v, want NotFound", err)
+	}
+	if command.NotFound(err) {
+		t.Errorf("error = %v, want no command not found", err)
+	}
+}
+
+func TestMachineDeadline(t *testing.T) {
+	_, clientset, config := fakeAPI(t)
+	km := k8s.Machine(clientset, config, "web", "web-0", "app")
+	ctx, cancel := context.WithTimeout(t.Context(), 200*time.Millisecond)
+	defer cancel()
+
+	err := command.Do(ctx, km, "sleep", "1")
+	if !errors.Is(err, command.ErrDeadline) {
+		t.Errorf("error = %v, want ErrDeadline", err)
+	}
+	if command.NotFound(err) {
+		t.Errorf("error = %v, want no command not found", err)
+	}
+}
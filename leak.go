@@ -0,0 +1,131 @@
+package command
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+var leaks struct {
+	sync.Mutex
+	on   bool
+	open map[*leak]struct{}
+}
+
+// A leak is a buffer tracked by [DebugLeaks] until it is released.
+type leak struct {
+	m    Machine
+	args []string
+	pcs  []uintptr
+}
+
+// DebugLeaks turns leak tracking on or off. Turning it off forgets any
+// buffers already tracked.
+//
+// While tracking is on, each buffer created by [NewReader], [NewWriter],
+// or [NewFilter] is recorded along with the stack that created it, until
+// it is closed or read to EOF. A buffer that is neither holds its command
+// open, and is a common cause of hung pipelines. [Leaks] reports the
+// buffers still open, and [Shutdown] reports those created on the machine
+// it shuts down. In tests:
+//
+//	command.DebugLeaks(true)
+//	t.Cleanup(func() {
+//	    if err := command.Leaks(); err != nil {
+//	        t.Error(err)
+//	    }
+//	    command.DebugLeaks(false)
+//	})
+//
+// Tracking records a stack trace per buffer, so it is meant for debugging
+// and tests rather than production use.
+func DebugLeaks(on bool) {
+	leaks.Lock()
+	defer leaks.Unlock()
+	leaks.on = on
+	leaks.open = nil
+	if on {
+		leaks.open = make(map[*leak]struct{})
+	}
+}
+
+// Leaks returns an error describing each buffer tracked by [DebugLeaks]
+// that has not been closed or read to EOF, with the stack that created
+// it. It returns nil if there are none, or if tracking is off.
+func Leaks() error {
+	return leakReport(func(*leak) bool { return true })
+}
+
+// machineLeaks is like [Leaks], but reports only the buffers created on m.
+func machineLeaks(m Machine) error {
+	return leakReport(func(l *leak) bool { return sameMachine(l.m, m) })
+}
+
+// leakReport describes the open buffers for which keep returns true.
+func leakReport(keep func(*leak) bool) error {
+	leaks.Lock()
+	defer leaks.Unlock()
+	var open []*leak
+	for l := range leaks.open {
+		if keep(l) {
+			open = append(open, l)
+		}
+	}
+	if len(open) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "command: %d leaked buffer(s)", len(open))
+	for _, l := range open {
+		fmt.Fprintf(&b, "\n\n%s\n\tcreated at:", strings.Join(l.args, " "))
+		frames := runtime.CallersFrames(l.pcs)
+		for {
+			f, more := frames.Next()
+			fmt.Fprintf(&b, "\n\t%s\n\t\t%s:%d", f.Function, f.File, f.Line)
+			if !more {
+				break
+			}
+		}
+	}
+	return leakError(b.String())
+}
+
+type leakError string
+
+func (e leakError) Error() string { return string(e) }
+
+// sameMachine reports whether a and b are the same machine. Machines
+// that cannot be compared, such as a [MachineFunc] or a struct holding
+// one, are never the same.
+func sameMachine(a, b Machine) bool {
+	return reflect.TypeOf(a) == reflect.TypeOf(b) &&
+		reflect.ValueOf(a).Comparable() && a == b
+}
+
+// track starts tracking a buffer for args run on m, if tracking is on.
+// It returns nil otherwise.
+func track(m Machine, args []string) *leak {
+	leaks.Lock()
+	defer leaks.Unlock()
+	if !leaks.on {
+		return nil
+	}
+	l := &leak{m: m, args: args, pcs: make([]uintptr, 32)}
+	// Skip runtime.Callers, track, and the constructor calling track.
+	l.pcs = l.pcs[:runtime.Callers(3, l.pcs)]
+	leaks.open[l] = struct{}{}
+	return l
+}
+
+// release stops tracking l. It may be called on a nil leak, or more than
+// once.
+func (l *leak) release() {
+	if l == nil {
+		return
+	}
+	leaks.Lock()
+	defer leaks.Unlock()
+	delete(leaks.open, l)
+}
@@ -0,0 +1,112 @@
+package command_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mem"
+)
+
+func debugLeaks(t *testing.T) {
+	command.DebugLeaks(true)
+	t.Cleanup(func() { command.DebugLeaks(false) })
+}
+
+func TestLeaks(t *testing.T) {
+	debugLeaks(t)
+	m, ctx := mem.Machine(), t.Context()
+
+	r := command.NewReader(ctx, m, "echo", "hello")
+	w := command.NewWriter(ctx, m, "tee", "out.txt")
+
+	err := command.Leaks()
+	if err == nil {
+		t.Fatal("command.Leaks() = nil, want error")
+	}
+	for _, want := range []string{
+		"2 leaked", "echo hello", "tee out.txt", "TestLeaks",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("command.Leaks() = %q, want it to contain %q",
+				err, want)
+		}
+	}
+
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatalf("io.ReadAll error: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+	if err := command.Leaks(); err != nil {
+		t.Errorf("command.Leaks() after release = %v, want nil", err)
+	}
+}
+
+func TestLeaksCopy(t *testing.T) {
+	debugLeaks(t)
+	m, ctx := mem.Machine(), t.Context()
+
+	_, err := command.Copy(
+		command.NewWriter(ctx, m, "tee", "out.txt"),
+		command.NewReader(ctx, m, "echo", "hello"),
+		command.NewFilter(ctx, m, "tr", "a-z", "A-Z"),
+	)
+	if err != nil {
+		t.Fatalf("command.Copy error: %v", err)
+	}
+	if err := command.Leaks(); err != nil {
+		t.Errorf("command.Leaks() = %v, want nil", err)
+	}
+}
+
+func TestLeaksShutdown(t *testing.T) {
+	debugLeaks(t)
+	m, ctx := mem.Machine(), t.Context()
+
+	r := command.NewReader(ctx, m, "echo", "hello")
+	defer r.Close()
+
+	err := command.Shutdown(ctx, m)
+	if err == nil || !strings.Contains(err.Error(), "echo hello") {
+		t.Errorf("command.Shutdown error: got %v, want leak report", err)
+	}
+}
+
+func TestLeaksShutdownOtherMachine(t *testing.T) {
+	debugLeaks(t)
+	m, other, ctx := mem.Machine(), mem.Machine(), t.Context()
+
+	r := command.NewReader(ctx, m, "echo", "hello")
+	defer r.Close()
+
+	if err := command.Shutdown(ctx, other); err != nil {
+		t.Errorf("command.Shutdown(other) = %v, want nil", err)
+	}
+}
+
+func TestLeaksOff(t *testing.T) {
+	m, ctx := mem.Machine(), t.Context()
+
+	r := command.NewReader(ctx, m, "echo", "hello")
+	defer r.Close()
+
+	if err := command.Leaks(); err != nil {
+		t.Errorf("command.Leaks() = %v, want nil", err)
+	}
+}
+
+func TestLeaksShutdownUncomparable(t *testing.T) {
+	debugLeaks(t)
+	type wrapped struct{ command.Machine }
+	m, ctx := wrapped{command.MachineFunc(mem.Machine().Command)}, t.Context()
+
+	r := command.NewReader(ctx, m, "echo", "hello")
+	defer r.Close()
+
+	if err := command.Shutdown(ctx, m); err != nil {
+		t.Errorf("command.Shutdown = %v, want nil", err)
+	}
+}
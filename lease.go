@@ -0,0 +1,128 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"time"
+
+	"lesiw.io/fs"
+)
+
+// ErrLeaseExpired is returned by [RunLeased] when the watchdog on the
+// target killed the command because its lease was not renewed.
+var ErrLeaseExpired = errors.New("command: lease expired")
+
+// leaseScript runs a command in the background, with a watchdog that
+// kills it if the lease file, which holds the time of the last heartbeat
+// in seconds since the epoch, is not renewed within ttl seconds.
+//
+// Asynchronous commands read from /dev/null unless redirected, so the
+// command's input is passed on by descriptor 3.
+const leaseScript = `lease=$1 ttl=$2
+shift 2
+exec 3<&0
+"$@" <&3 3<&- &
+pid=$!
+exec 3<&-
+(
+	while kill -0 "$pid" 2>/dev/null; do
+		sleep 1
+		beat=$(cat "$lease" 2>/dev/null)
+		if [ $(( $(date +%s) - ${beat:-0} )) -gt "$ttl" ]; then
+			: > "$lease.expired"
+			kill -TERM "$pid" 2>/dev/null
+			sleep 5
+			kill -KILL "$pid" 2>/dev/null
+			exit
+		fi
+	done
+) </dev/null >/dev/null 2>&1 &
+watchdog=$!
+wait "$pid"
+status=$?
+kill "$watchdog" 2>/dev/null
+exit "$status"`
+
+// leaseBeat renews the lease file named by its argument. The time is
+// written beside it and renamed into place, so that the watchdog never
+// reads a file that is empty while it is written.
+const leaseBeat = `date +%s > "$1.tmp" && mv -f "$1.tmp" "$1"`
+
+// RunLeased runs spec on m, as [Run] does, under a lease that the caller
+// renews every third of ttl for as long as the command runs. A watchdog
+// on m kills the command once the lease goes ttl without renewal, as when
+// the caller crashed or lost its connection to m, so that long jobs do
+// not outlive the process that started them:
+//
+//	err := command.RunLeased(ctx, m, time.Minute, command.Spec{
+//	    Args: []string{"./migrate", "--all"},
+//	})
+//
+// Renewals are timed by the clock of m, in whole seconds, so ttl must be
+// at least a second. A command killed by the watchdog fails with an error
+// that wraps [ErrLeaseExpired]. The watchdog signals only the command's
+// own process, not processes it started.
+//
+// Leases are not supported on Windows.
+func RunLeased(
+	ctx context.Context, m Machine, ttl time.Duration, spec Spec,
+) error {
+	if OS(ctx, m) == "windows" {
+		return fmt.Errorf("failed to lease command: %w",
+			errors.ErrUnsupported)
+	}
+	if ttl < time.Second {
+		return fmt.Errorf("lease ttl %v is under 1s", ttl)
+	}
+	hctx := WithoutCommandOptions(ctx) // For the lease's own commands.
+	fsys := FS(m)
+	dir, err := mkTempDir(hctx, fsys, "command-lease")
+	if err != nil {
+		return fmt.Errorf("failed to lease command: %w", err)
+	}
+	defer func() {
+		_ = fs.RemoveAll(context.WithoutCancel(hctx), fsys, dir)
+	}()
+	lease := path.Join(dir, "lease")
+	if err := Do(hctx, m, "sh", "-c", leaseBeat, "sh", lease); err != nil {
+		return fmt.Errorf("failed to lease command: %w", err)
+	}
+
+	hctx, stop := context.WithCancel(hctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		t := time.NewTicker(ttl / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-hctx.Done():
+				return
+			case <-t.C:
+			}
+			// Retried at the next tick; the watchdog decides when
+			// renewals have failed for too long.
+			_ = Do(hctx, m, "sh", "-c", leaseBeat, "sh", lease)
+		}
+	}()
+
+	secs := strconv.Itoa(int(math.Ceil(ttl.Seconds())))
+	leased := spec
+	leased.Args = append([]string{"sh", "-c", leaseScript, "sh",
+		lease, secs}, spec.Args...)
+	err = Run(ctx, m, leased)
+	stop()
+	<-done
+	if err == nil {
+		return nil
+	}
+	_, serr := fs.Stat(context.WithoutCancel(hctx), fsys, lease+".expired")
+	if serr == nil {
+		return fmt.Errorf("%w: %w", ErrLeaseExpired, err)
+	}
+	return err
+}
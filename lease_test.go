@@ -0,0 +1,121 @@
+package command_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+	"lesiw.io/command/sys"
+)
+
+func skipLease(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+}
+
+func TestRunLeased(t *testing.T) {
+	skipLease(t)
+	var out strings.Builder
+	err := command.RunLeased(t.Context(), sys.Machine(), time.Second,
+		command.Spec{
+			Args:   []string{"sh", "-c", "sleep 1; cat"},
+			Stdin:  strings.NewReader("hello"),
+			Stdout: &out,
+		})
+	if err != nil {
+		t.Fatalf("command.RunLeased error: %v", err)
+	}
+	if got, want := out.String(), "hello"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestRunLeasedFailure(t *testing.T) {
+	skipLease(t)
+	err := command.RunLeased(t.Context(), sys.Machine(), time.Second,
+		command.Spec{Args: []string{"sh", "-c", "exit 3"}})
+	if err == nil {
+		t.Fatal("command.RunLeased error: nil, want failure")
+	}
+	if errors.Is(err, command.ErrLeaseExpired) {
+		t.Errorf("command.RunLeased error: %v, want not expired", err)
+	}
+	var cerr *command.Error
+	if !errors.As(err, &cerr) || cerr.Code != 3 {
+		t.Errorf("command.RunLeased error: %v, want exit status 3", err)
+	}
+}
+
+func TestRunLeasedOrphan(t *testing.T) {
+	skipLease(t)
+	pidfile := filepath.Join(t.TempDir(), "pid")
+	ctx, cancel := context.WithCancel(t.Context())
+	go func() {
+		for {
+			if _, err := os.Stat(pidfile); err == nil {
+				cancel()
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	// Canceling kills only the wrapper, leaving the workload to the
+	// watchdog, as if the caller had died.
+	err := command.RunLeased(ctx, sys.Machine(), time.Second, command.Spec{
+		Args: []string{"sh", "-c", `echo $$ > "$1"; exec sleep 30`,
+			"sh", pidfile},
+	})
+	if !errors.Is(err, command.ErrLeaseExpired) {
+		t.Errorf("command.RunLeased error: %v, want ErrLeaseExpired", err)
+	}
+	data, err := os.ReadFile(pidfile)
+	if err != nil {
+		t.Fatalf("os.ReadFile error: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatalf("bad pid %q: %v", data, err)
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for exec.Command("kill", "-0", strconv.Itoa(pid)).Run() == nil {
+		if time.Now().After(deadline) {
+			_ = exec.Command("kill", "-KILL", strconv.Itoa(pid)).Run()
+			t.Fatalf("workload %d still running after lease expired", pid)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestRunLeasedWindows(t *testing.T) {
+	m := new(mock.Machine)
+	m.SetOS("windows")
+	err := command.RunLeased(t.Context(), m, time.Minute,
+		command.Spec{Args: []string{"job"}})
+	if !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("command.RunLeased error: %v, want ErrUnsupported", err)
+	}
+	if calls := mock.Calls(m, "job"); len(calls) > 0 {
+		t.Errorf("ran %d job calls, want 0", len(calls))
+	}
+}
+
+func TestRunLeasedShortTTL(t *testing.T) {
+	m := new(mock.Machine)
+	m.SetOS("linux")
+	err := command.RunLeased(t.Context(), m, time.Millisecond,
+		command.Spec{Args: []string{"job"}})
+	if err == nil {
+		t.Error("command.RunLeased error: nil, want ttl error")
+	}
+}
@@ -0,0 +1,230 @@
+package command
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"lesiw.io/fs"
+)
+
+// Lock acquires the advisory lock called name on m, waiting until it is
+// free or ctx is done, and returns a function that releases it. Commands
+// that coordinate on a shared host, such as deployments from separate
+// CI jobs, hold the same lock to keep from running at once:
+//
+//	unlock, err := command.Lock(ctx, m, "deploy", time.Minute)
+//	if err != nil {
+//	    return err
+//	}
+//	defer unlock()
+//
+// The lock is a directory in the temporary directory of m, which any
+// machine with a file system can create atomically. While the lock is
+// held, it is renewed every third of ttl; a lock that has not been
+// renewed for ttl, as when its holder crashed, is taken over. A ttl of 0
+// means the lock never expires; any other ttl must be at least a
+// millisecond. Expiry is judged by the clocks of the
+// callers, so ttl should be well beyond any difference between them.
+//
+// A lock is advisory: it keeps out only those who ask for it.
+func Lock(
+	ctx context.Context, m Machine, name string, ttl time.Duration,
+) (unlock func() error, err error) {
+	if ttl < 0 || ttl > 0 && ttl < minLockTTL {
+		return nil, fmt.Errorf("bad lock ttl %v for %q", ttl, name)
+	}
+	ctx = WithoutCommandOptions(ctx)
+	dir, err := lockPath(ctx, m, name)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return nil, fmt.Errorf("failed to lock %q: %w", name, err)
+	}
+	l := &lock{
+		fsys:  FS(m),
+		dir:   dir,
+		owner: path.Join(dir, "owner"),
+		token: hex.EncodeToString(b),
+		ttl:   ttl,
+	}
+	for {
+		ok, err := l.try(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock %q: %w", name, err)
+		}
+		if ok {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("failed to lock %q: %w", name,
+				Interrupted(ctx, ctx.Err()))
+		case <-time.After(waitInterval):
+		}
+	}
+	if ttl > 0 {
+		l.renewing(context.WithoutCancel(ctx))
+	}
+	return sync.OnceValue(func() error { return l.release(ctx) }), nil
+}
+
+// minLockTTL is the shortest ttl a lock may expire after.
+const minLockTTL = time.Millisecond
+
+// lockPath returns the path of the directory of the lock called name.
+func lockPath(ctx context.Context, m Machine, name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) || name[0] == '.' {
+		return "", fmt.Errorf("bad lock name %q", name)
+	}
+	var tmp string
+	if OS(ctx, m) == "windows" {
+		tmp = Env(ctx, m, "TEMP")
+	} else if tmp = Env(ctx, m, "TMPDIR"); tmp == "" {
+		tmp = "/tmp"
+	}
+	if tmp == "" {
+		return "", fmt.Errorf("no temporary directory for lock %q", name)
+	}
+	return path.Join(tmp, "command-locks", name+".lock"), nil
+}
+
+type lock struct {
+	fsys  fs.FS
+	dir   string
+	owner string // The file that names the holder and its expiry.
+	token string // The holder, this lock.
+	ttl   time.Duration
+	stop  func() // Stops renewal.
+}
+
+// try tries once to acquire l, taking it over if it has expired.
+func (l *lock) try(ctx context.Context) (bool, error) {
+	if err := fs.MkdirAll(ctx, l.fsys, path.Dir(l.dir)); err != nil {
+		return false, err
+	}
+	err := fs.Mkdir(ctx, l.fsys, l.dir)
+	if err == nil {
+		return true, l.write(ctx)
+	}
+	if !errors.Is(err, fs.ErrExist) {
+		return false, err
+	}
+	stale, ok := l.expired(ctx)
+	if !ok {
+		return false, nil
+	}
+	// The holder is gone. Of the waiters that saw it go, only the one that
+	// claims the takeover removes its lock, and only if it is still the
+	// lock that expired, so that no waiter removes a lock taken since.
+	claim := l.dir + ".takeover." + holder(stale)
+	if err := fs.Mkdir(ctx, l.fsys, claim); errors.Is(err, fs.ErrExist) {
+		// A claimant that crashed would keep the lock from everyone.
+		info, err := fs.Stat(ctx, l.fsys, claim)
+		if err == nil && time.Since(info.ModTime()) > takeoverLimit {
+			_ = fs.RemoveAll(ctx, l.fsys, claim) // Retried while waiting.
+		}
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer func() { _ = fs.RemoveAll(ctx, l.fsys, claim) }()
+	if cur, ok := l.expired(ctx); !ok || cur != stale {
+		return false, nil
+	}
+	if err := fs.RemoveAll(ctx, l.fsys, l.dir); err != nil {
+		return false, err
+	}
+	return l.try(ctx)
+}
+
+// takeoverLimit is how long a claim to take over an expired lock may be
+// held before it is presumed abandoned.
+const takeoverLimit = time.Minute
+
+// expired reports whether the holder of l's lock has let it expire, and if
+// so, returns the contents of its owner file, which are empty if the holder
+// never wrote it.
+func (l *lock) expired(ctx context.Context) (string, bool) {
+	data, err := fs.ReadFile(ctx, l.fsys, l.owner)
+	if err != nil {
+		// The holder may not have written it yet, or may have crashed
+		// before it could.
+		info, err := fs.Stat(ctx, l.fsys, l.dir)
+		return "", err == nil && l.ttl > 0 &&
+			time.Since(info.ModTime()) > l.ttl
+	}
+	owner := strings.TrimSpace(string(data))
+	_, expiry, _ := strings.Cut(owner, " ")
+	n, err := strconv.ParseInt(expiry, 10, 64)
+	return owner, err == nil && n != 0 && time.Now().UnixNano() >= n
+}
+
+// holder returns the token of the holder named by owner, the contents of
+// an owner file.
+func holder(owner string) string {
+	token, _, _ := strings.Cut(owner, " ")
+	if token == "" || strings.ContainsAny(token, `/\.`) {
+		return "none"
+	}
+	return token
+}
+
+// write records l as the holder of its lock, with a new expiry.
+func (l *lock) write(ctx context.Context) error {
+	var expiry int64
+	if l.ttl > 0 {
+		expiry = time.Now().Add(l.ttl).UnixNano()
+	}
+	data := l.token + " " + strconv.FormatInt(expiry, 10) + "\n"
+	return fs.WriteFile(ctx, l.fsys, l.owner, []byte(data))
+}
+
+// held reports whether l still holds its lock.
+func (l *lock) held(ctx context.Context) bool {
+	data, err := fs.ReadFile(ctx, l.fsys, l.owner)
+	return err == nil && strings.HasPrefix(string(data), l.token+" ")
+}
+
+// renewing renews l every third of its ttl until it is released.
+func (l *lock) renewing(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	l.stop = func() { cancel(); <-done }
+	go func() {
+		defer close(done)
+		t := time.NewTicker(l.ttl / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+			if l.held(ctx) {
+				_ = l.write(ctx) // Retried at the next tick.
+			}
+		}
+	}()
+}
+
+// release releases l, if it still holds its lock.
+func (l *lock) release(ctx context.Context) error {
+	if l.stop != nil {
+		l.stop()
+	}
+	ctx = context.WithoutCancel(ctx)
+	if !l.held(ctx) {
+		return nil
+	}
+	return fs.RemoveAll(ctx, l.fsys, l.dir)
+}
@@ -0,0 +1,154 @@
+package command_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+	"lesiw.io/command/sys"
+	"lesiw.io/fs"
+)
+
+func lockMachine() *mock.Machine {
+	m := new(mock.Machine)
+	m.SetOS("linux")
+	return m
+}
+
+func TestLock(t *testing.T) {
+	ctx := command.WithEnv(t.Context(),
+		map[string]string{"TMPDIR": t.TempDir()})
+	m := sys.Machine()
+
+	var running, overlaps atomic.Int32
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := command.Lock(ctx, m, "test", time.Minute)
+			if err != nil {
+				t.Errorf("command.Lock error: %v", err)
+				return
+			}
+			if running.Add(1) > 1 {
+				overlaps.Add(1)
+			}
+			time.Sleep(10 * time.Millisecond)
+			running.Add(-1)
+			if err := unlock(); err != nil {
+				t.Errorf("unlock error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := overlaps.Load(); n > 0 {
+		t.Errorf("lock held by more than one caller %d times", n)
+	}
+}
+
+func TestLockTimeout(t *testing.T) {
+	ctx, m := t.Context(), lockMachine()
+	unlock, err := command.Lock(ctx, m, "busy", 0)
+	if err != nil {
+		t.Fatalf("command.Lock error: %v", err)
+	}
+	defer unlock()
+
+	tctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	_, err = command.Lock(tctx, m, "busy", 0)
+	if !errors.Is(err, command.ErrDeadline) {
+		t.Errorf("command.Lock error: got %v, want ErrDeadline", err)
+	}
+}
+
+func TestLockExpired(t *testing.T) {
+	ctx, m := t.Context(), lockMachine()
+	err := fs.MkdirAll(ctx, m.FS(), "/tmp/command-locks/stale.lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = fs.WriteFile(ctx, m.FS(), "/tmp/command-locks/stale.lock/owner",
+		[]byte("crashed 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	unlock, err := command.Lock(tctx, m, "stale", time.Minute)
+	if err != nil {
+		t.Fatalf("command.Lock error: %v", err)
+	}
+	if err := unlock(); err != nil {
+		t.Errorf("unlock error: %v", err)
+	}
+	_, err = fs.Stat(ctx, m.FS(), "/tmp/command-locks/stale.lock")
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("lock directory after unlock: %v, want not exist", err)
+	}
+}
+
+func TestLockExpiredContended(t *testing.T) {
+	tmp := t.TempDir()
+	ctx := command.WithEnv(t.Context(), map[string]string{"TMPDIR": tmp})
+	m := sys.Machine()
+	dir := tmp + "/command-locks/stale.lock"
+	if err := fs.MkdirAll(ctx, command.FS(m), dir); err != nil {
+		t.Fatal(err)
+	}
+	err := fs.WriteFile(ctx, command.FS(m), dir+"/owner",
+		[]byte("crashed 1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var running, overlaps atomic.Int32
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := command.Lock(ctx, m, "stale", time.Minute)
+			if err != nil {
+				t.Errorf("command.Lock error: %v", err)
+				return
+			}
+			if running.Add(1) > 1 {
+				overlaps.Add(1)
+			}
+			time.Sleep(10 * time.Millisecond)
+			running.Add(-1)
+			if err := unlock(); err != nil {
+				t.Errorf("unlock error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := overlaps.Load(); n > 0 {
+		t.Errorf("lock held by more than one caller %d times", n)
+	}
+}
+
+func TestLockBadName(t *testing.T) {
+	_, err := command.Lock(t.Context(), lockMachine(), "../etc", 0)
+	if err == nil {
+		t.Error("command.Lock(../etc) succeeded, want error")
+	}
+}
+
+func TestLockBadTTL(t *testing.T) {
+	for _, ttl := range []time.Duration{-time.Second, 1, 2} {
+		_, err := command.Lock(t.Context(), lockMachine(), "test", ttl)
+		if err == nil {
+			t.Errorf("command.Lock(ttl=%v) succeeded, want error", ttl)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Package logs follows the logs of services on a command.Machine,
+// whichever way the machine keeps them, so that a test can stream a
+// service's logs without knowing where it runs.
+//
+//	r, err := logs.Source(ctx, m, "unit=myapp")
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	defer r.Close()
+//	go io.Copy(os.Stderr, r)
+package logs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"lesiw.io/command"
+	"lesiw.io/command/ctr"
+)
+
+// Source returns a reader that follows logs on m, starting with recent
+// lines and continuing with new ones until it is closed.
+//
+// The spec names the logs with one source, followed by options, all
+// separated by commas:
+//
+//	unit=NAME       a systemd unit, with journalctl -f
+//	container=ID    a container, with docker logs -f or another CLI
+//	pod=NAME        a Kubernetes pod, or TYPE/NAME, with kubectl logs -f
+//	file=PATH       a log file, with tail -F
+//
+// The options are:
+//
+//	lines=N         start with the last N lines
+//	namespace=NS    the Kubernetes namespace of a pod
+//	user            a unit of the user's service manager
+//
+// For example, "pod=deployment/web,namespace=prod,lines=100" follows the
+// last 100 lines of a deployment's pods.
+func Source(
+	ctx context.Context, m command.Machine, spec string,
+) (io.ReadCloser, error) {
+	s, err := parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	var args []string
+	switch s.kind {
+	case "unit":
+		args = []string{"journalctl", "--follow", "--output=cat",
+			"--no-pager", "--unit=" + s.name}
+		if s.user {
+			args = append(args, "--user")
+		}
+		if s.lines >= 0 {
+			args = append(args, "--lines="+strconv.Itoa(s.lines))
+		}
+	case "container":
+		args = []string{"logs", "--follow"}
+		if s.lines >= 0 {
+			args = append(args, "--tail", strconv.Itoa(s.lines))
+		}
+		args = append(args, s.name)
+		m = ctr.Ctl(m)
+	case "pod":
+		args = []string{"kubectl", "logs", "--follow"}
+		if s.namespace != "" {
+			args = append(args, "--namespace", s.namespace)
+		}
+		if s.lines >= 0 {
+			args = append(args, "--tail", strconv.Itoa(s.lines))
+		}
+		args = append(args, s.name)
+	case "file":
+		args = []string{"tail", "-F"}
+		if s.lines >= 0 {
+			args = append(args, "-n", strconv.Itoa(s.lines))
+		}
+		args = append(args, s.name)
+	}
+	// Diagnostic output, such as that of kubectl, is part of the logs.
+	ctx = command.WithMergedStderr(command.WithoutCommandOptions(ctx), true)
+	return command.NewReader(ctx, m, args...), nil
+}
+
+type spec struct {
+	kind, name string
+	lines      int // Negative for the tool's default.
+	namespace  string
+	user       bool
+}
+
+func parse(text string) (spec, error) {
+	s := spec{lines: -1}
+	for field := range strings.SplitSeq(text, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(field), "=")
+		switch k {
+		case "unit", "container", "pod", "file":
+			if s.kind != "" {
+				return s, fmt.Errorf("bad log spec %q: more than one source",
+					text)
+			}
+			if v == "" {
+				return s, fmt.Errorf("bad log spec %q: empty %s", text, k)
+			}
+			s.kind, s.name = k, v
+		case "lines":
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return s, fmt.Errorf("bad log spec %q: bad lines %q",
+					text, v)
+			}
+			s.lines = n
+		case "namespace":
+			s.namespace = v
+		case "user":
+			s.user = true
+		default:
+			return s, fmt.Errorf("bad log spec %q: unknown key %q", text, k)
+		}
+	}
+	if s.kind == "" {
+		return s, fmt.Errorf("bad log spec %q: no source", text)
+	}
+	return s, nil
+}
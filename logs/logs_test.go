@@ -0,0 +1,80 @@
+package logs_test
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lesiw.io/command/logs"
+	"lesiw.io/command/mock"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []string
+	}{{
+		"unit=myapp",
+		[]string{"journalctl", "--follow", "--output=cat", "--no-pager",
+			"--unit=myapp"},
+	}, {
+		"unit=myapp,user,lines=5",
+		[]string{"journalctl", "--follow", "--output=cat", "--no-pager",
+			"--unit=myapp", "--user", "--lines=5"},
+	}, {
+		"container=db,lines=0",
+		[]string{"docker", "logs", "--follow", "--tail", "0", "db"},
+	}, {
+		"pod=deployment/web, namespace=prod",
+		[]string{"kubectl", "logs", "--follow", "--namespace", "prod",
+			"deployment/web"},
+	}, {
+		"file=/var/log/app.log,lines=100",
+		[]string{"tail", "-F", "-n", "100", "/var/log/app.log"},
+	}}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			m := new(mock.Machine)
+			m.Return(strings.NewReader("started\n"), tt.want[0])
+
+			r, err := logs.Source(t.Context(), m, tt.spec)
+			if err != nil {
+				t.Fatalf("logs.Source error: %v", err)
+			}
+			out, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("io.ReadAll error: %v", err)
+			}
+			_ = r.Close()
+
+			if got, want := string(out), "started\n"; got != want {
+				t.Errorf("logs = %q, want %q", got, want)
+			}
+			calls := mock.Calls(m, tt.want[0], tt.want[1])
+			if len(calls) != 1 {
+				t.Fatalf("got %d calls, want 1", len(calls))
+			}
+			if got := calls[0].Args; !cmp.Equal(got, tt.want) {
+				t.Errorf("args (-want +got):\n%s", cmp.Diff(tt.want, got))
+			}
+		})
+	}
+}
+
+func TestSourceBadSpec(t *testing.T) {
+	for _, spec := range []string{
+		"",
+		"lines=5",
+		"unit=",
+		"unit=a,container=b",
+		"unit=a,lines=-1",
+		"unit=a,color=red",
+	} {
+		_, err := logs.Source(t.Context(), new(mock.Machine), spec)
+		if err == nil {
+			t.Errorf("logs.Source(%q) error = nil, want error", spec)
+		}
+	}
+}
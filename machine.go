@@ -1,15 +1,10 @@
 package command
 
 import (
-	"bytes"
 	"context"
 	"errors"
-	"fmt"
-	"io"
-	"os"
 	"strings"
 
-	"lesiw.io/command/internal/sh"
 	"lesiw.io/fs"
 )
 
@@ -93,11 +88,20 @@ type ShutdownMachine interface {
 //
 // The context passed to Shutdown is derived using context.WithoutCancel to
 // ensure cleanup can complete even after the parent context is canceled.
+//
+// If leak tracking is on, the returned error also reports any buffers
+// created on m that are still open; see [DebugLeaks]. Buffers created on
+// other machines, such as those m wraps, are reported by their own
+// Shutdown, or by [Leaks].
 func Shutdown(ctx context.Context, m Machine) error {
+	var err error
 	if closer, ok := m.(ShutdownMachine); ok {
-		return closer.Shutdown(context.WithoutCancel(ctx))
+		err = closer.Shutdown(context.WithoutCancel(ctx))
+	}
+	if lerr := machineLeaks(m); lerr != nil {
+		err = errors.Join(err, lerr)
 	}
-	return nil
+	return err
 }
 
 // Exec executes a command and waits for it to complete.
@@ -105,37 +109,28 @@ func Shutdown(ctx context.Context, m Machine) error {
 //
 // Unlike Read, errors returned by Exec will not include log output.
 func Exec(ctx context.Context, m Machine, args ...string) error {
-	r := m.Command(ctx, args...)
-	trace(r, args...)
-	return exec(r)
+	return Run(ctx, m, Spec{
+		Args:   args,
+		Stdout: stdout,
+		Stderr: stderr,
+		TTY:    true,
+	})
 }
 
 // Read executes a command and returns its output as a string.
 // Trailing newlines are stripped from the output.
 // For exact output, use [io.ReadAll].
 //
-// If the command fails, the error will contain an exit code and log output.
+// If the command fails, the error will contain an exit code and log output,
+// and the output read so far is returned along with it. The output is also
+// recorded in the error's Output field, so it survives being passed up.
 func Read(ctx context.Context, m Machine, args ...string) (string, error) {
-	r := m.Command(ctx, args...)
-
-	var buf bytes.Buffer
-	if logger, ok := r.(LogBuffer); ok {
-		logger.Log(&buf)
-	}
-
-	trace(r, args...)
-	out, err := io.ReadAll(r)
+	var out strings.Builder
+	err := Run(ctx, m, Spec{Args: args, Stdout: &out})
+	err = withOutput(ctx, err, out.String())
 
-	// Convert to string then strip trailing newlines
-	// (like shell $() behavior)
-	result := string(out)
-	result = strings.TrimRight(result, "\r\n")
-
-	if e := new(Error); err != nil && buf.Len() > 0 && errors.As(err, &e) {
-		e.Log = buf.Bytes()
-	}
-
-	return result, err
+	// Strip trailing newlines (like shell $() behavior).
+	return strings.TrimRight(out.String(), "\r\n"), err
 }
 
 // Do executes a command for its side effects, discarding output.
@@ -143,50 +138,22 @@ func Read(ctx context.Context, m Machine, args ...string) (string, error) {
 //
 // If the command fails, the error will contain exit code and log output.
 func Do(ctx context.Context, m Machine, args ...string) error {
-	r := m.Command(ctx, args...)
-
-	var buf bytes.Buffer
-	if logger, ok := r.(LogBuffer); ok {
-		logger.Log(&buf)
-	}
-
-	trace(r, args...)
-	_, err := io.Copy(io.Discard, r)
-
-	if e := new(Error); err != nil && buf.Len() > 0 && errors.As(err, &e) {
-		e.Log = buf.Bytes()
-	}
-
-	return err
+	return Run(ctx, m, Spec{Args: args})
 }
 
-// exec attaches a command to the controlling terminal.
-// If the command implements AttachBuffer, it calls that method.
-// Otherwise, it streams both stdout and stderr.
-func exec(buf Buffer) error {
-	if attacher, ok := buf.(AttachBuffer); ok {
-		if err := attacher.Attach(); err != nil {
-			return err
-		}
-	}
-	if logger, ok := buf.(LogBuffer); ok {
-		logger.Log(stderr)
+// withOutput returns err with out as the output of its [Error], if it
+// has one. The Error is copied, not modified.
+func withOutput(ctx context.Context, err error, out string) error {
+	e := new(Error)
+	if err == nil || !errors.As(err, &e) {
+		return err
 	}
-	_, err := io.Copy(stdout, buf)
-	return err
-}
-
-// trace reports commands to [Trace] per the CMDTRACE environment
-// variable, which is read on each call so tests and long-lived
-// processes see changes.
-func trace(buf Buffer, args ...string) {
-	switch os.Getenv("CMDTRACE") {
-	case "on":
-		_, _ = fmt.Fprintf(Trace, "%s\n", sh.Join(args))
-	case "full":
-		line := strings.TrimRight(fmt.Sprint(buf), "\n")
-		_, _ = fmt.Fprintf(Trace, "%s\n", line)
+	if r := redactor(ctx); r != nil {
+		out = r.Replace(out)
 	}
+	c := *e
+	c.Output = []byte(out)
+	return replaceError(err, e, &c, err.Error())
 }
 
 // probeRead executes a command using Read, automatically unshelling the
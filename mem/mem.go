@@ -17,6 +17,7 @@ package mem
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"lesiw.io/command"
@@ -40,6 +41,10 @@ func (m *machine) Command(ctx context.Context, arg ...string) command.Buffer {
 			Err: fmt.Errorf("bad command: no command given"),
 		})
 	}
+	if command.StdoutFile(ctx) != "" || command.StderrFile(ctx) != "" {
+		return command.Fail(&command.Error{Err: fmt.Errorf(
+			"redirect to file: %w", errors.ErrUnsupported)})
+	}
 	switch arg[0] {
 	case "cat":
 		return catCommand(ctx, m, arg...)
@@ -1,6 +1,7 @@
 package mem
 
 import (
+	"errors"
 	"io"
 	"testing"
 
@@ -29,6 +30,16 @@ func TestCommandSuccess(t *testing.T) {
 	}
 }
 
+func TestStdoutFileUnsupported(t *testing.T) {
+	m := Machine()
+	ctx := command.WithStdoutFile(t.Context(), "out.txt")
+
+	err := command.Do(ctx, m, "echo", "hello")
+	if !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Do() error = %v, want ErrUnsupported", err)
+	}
+}
+
 func TestEcho(t *testing.T) {
 	m, ctx := Machine(), t.Context()
 
@@ -6,7 +6,8 @@
 // argument pattern matching.
 //
 // The mock Machine tracks every command invocation in the Calls slice,
-// including arguments, environment, and input written to the command.
+// including arguments, environment, working directory, and input written
+// to the command.
 // Tests can inspect Calls using cmp.Diff or direct comparison.
 //
 // Responses are queued using Return() with optional argument patterns.
@@ -38,13 +39,28 @@
 //	// ... use sh ...
 //	calls := mock.Calls(sh)            // All invocations
 //	gitCalls := mock.Calls(sh, "git")  // Just git invocations
+//
+// # Ordering
+//
+// Machine is safe for concurrent use. Calls lists invocations in the order
+// they completed; Records lists them in the order they started, with the
+// logical and wall-clock times of each start and completion, to check
+// how concurrent commands were ordered:
+//
+//	if !mock.Before(m, []string{"git", "fetch"}, []string{"git", "merge"}) {
+//	    t.Error("merged before fetching")
+//	}
 package mock
 
 import (
 	"bytes"
 	"context"
+	"errors"
 	"io"
+	"math/rand/v2"
+	"slices"
 	"sync"
+	"time"
 
 	"lesiw.io/command"
 	"lesiw.io/fs"
@@ -54,8 +70,35 @@ import (
 // Call represents a single command invocation captured by the mock Machine.
 type Call struct {
 	Args []string
-	Env  map[string]string
-	Got  []byte
+
+	// Env and Dir are the environment and working directory that the
+	// command's context carried, as set by command.WithEnv and
+	// fs.WithWorkDir.
+	Env map[string]string
+	Dir string
+
+	Got []byte
+}
+
+// A Record is a Call with the timing of its invocation.
+//
+// Start and End are ticks of a logical clock shared by all calls to a
+// Machine: Start is taken when the command is created, and End when it
+// completes by reaching EOF, failing, or being closed. Unlike wall-clock
+// times, ticks are never equal, so they order calls made concurrently.
+type Record struct {
+	Call
+
+	Start, End         int
+	StartTime, EndTime time.Time
+}
+
+// Before reports whether r completed before o started.
+func (r Record) Before(o Record) bool { return r.End < o.Start }
+
+// Overlaps reports whether r and o ran concurrently.
+func (r Record) Overlaps(o Record) bool {
+	return !r.Before(o) && !o.Before(r)
 }
 
 type mockResponse struct {
@@ -76,12 +119,103 @@ type Machine struct {
 	mu        sync.Mutex
 	once      sync.Once
 	Calls     []Call
+	records   []Record
+	clock     int
 	responses []mockResponse
 	captured  map[string][]byte
 	handlers  []mockHandler
 	fsys      fs.FS
 	os        string
 	arch      string
+	real      command.Machine
+	failures  []failure
+	random    *rand.Rand
+	randomP   float64
+}
+
+// ErrInjected is the error behind the failures injected by FailRandom.
+var ErrInjected = errors.New("mock: injected failure")
+
+type failure struct {
+	args  []string
+	every int
+	count int
+	err   error
+}
+
+// FailEvery makes every nth command matching the argument prefix args fail
+// with err, starting with the nth. With no args, it counts every command.
+//
+// Injected failures take precedence over handlers registered with Do or
+// Return, and are recorded in Calls like any other command.
+//
+//	m.FailEvery(3, &command.Error{Code: 255}, "ssh") // Every third ssh.
+func (m *Machine) FailEvery(n int, err error, args ...string) {
+	if n < 1 {
+		panic("mock: FailEvery with n < 1")
+	}
+	m.init()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failures = append(m.failures, failure{
+		args:  append([]string(nil), args...),
+		every: n,
+		err:   err,
+	})
+}
+
+// FailRandom makes each command fail with probability p, with a
+// [command.Error] that wraps [ErrInjected] and has exit code 1.
+// The failures are drawn from a generator seeded with seed, so a test
+// that runs commands in the same order sees the same failures each time.
+//
+// Injected failures take precedence over handlers registered with Do or
+// Return, and are recorded in Calls like any other command.
+// A p of 0 turns random failures off.
+func (m *Machine) FailRandom(p float64, seed uint64) {
+	m.init()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.randomP = p
+	m.random = rand.New(rand.NewPCG(seed, seed))
+}
+
+// injected returns the failure to inject for args, if any.
+// m.mu must be held.
+func (m *Machine) injected(args []string) error {
+	var err error
+	for i := range m.failures {
+		f := &m.failures[i]
+		if !argsMatch(args, f.args) {
+			continue
+		}
+		if f.count++; f.count%f.every == 0 && err == nil {
+			err = f.err
+		}
+	}
+	if err == nil && m.randomP > 0 && m.random.Float64() < m.randomP {
+		err = &command.Error{Err: ErrInjected, Code: 1}
+	}
+	return err
+}
+
+// Passthrough returns a Machine that forwards commands with no matching
+// handler to real, instead of succeeding with no output. Commands with a
+// handler registered by Do or Return are intercepted as usual. Every
+// command is recorded in Calls either way.
+//
+// The returned Machine uses the filesystem, OS, and architecture of real,
+// unless they are overridden with SetFS, SetOS, or SetArch.
+//
+//	m := mock.Passthrough(sys.Machine())
+//	m.Return(strings.NewReader("Docker version 28.0.0\n"), "docker")
+//	// git runs for real; docker is faked.
+func Passthrough(real command.Machine) *Machine {
+	m := new(Machine)
+	m.init()
+	m.real = real
+	m.fsys = command.FS(real)
+	return m
 }
 
 // init initializes captured map and fsys if they are nil.
@@ -110,12 +244,17 @@ func (m *Machine) SetFS(fsys fs.FS) {
 }
 
 // OS implements the command.OSMachine interface.
-// Returns the OS set by SetOS, or "" if not set (triggers probe-based
+// Returns the OS set by SetOS. If it is not set, returns the OS of the
+// real machine in passthrough mode, or "" (triggers probe-based
 // detection).
 func (m *Machine) OS(ctx context.Context) string {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-	return m.os
+	os, real := m.os, m.real
+	m.mu.Unlock()
+	if os == "" && real != nil {
+		return command.OS(ctx, real)
+	}
+	return os
 }
 
 // SetOS sets the operating system returned by OS().
@@ -127,12 +266,17 @@ func (m *Machine) SetOS(os string) {
 }
 
 // Arch implements the command.ArchMachine interface.
-// Returns the architecture set by SetArch, or "" if not set (triggers
+// Returns the architecture set by SetArch. If it is not set, returns the
+// architecture of the real machine in passthrough mode, or "" (triggers
 // probe-based detection).
 func (m *Machine) Arch(ctx context.Context) string {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-	return m.arch
+	arch, real := m.arch, m.real
+	m.mu.Unlock()
+	if arch == "" && real != nil {
+		return command.Arch(ctx, real)
+	}
+	return arch
 }
 
 // SetArch sets the architecture returned by Arch().
@@ -231,16 +375,30 @@ func (m *Machine) makeQueueHandler(arg []string) func(
 			reader = bytes.NewReader(nil)
 		}
 
-		return &mockCmd{
-			machine: m,
-			call: Call{
-				Args: append([]string{}, args...),
-				Env:  command.Envs(ctx),
-			},
-			reader:     reader,
-			captureBuf: captureBuf,
-			key:        key,
-		}
+		c := m.newCmd(ctx, args, reader)
+		c.captureBuf, c.key = captureBuf, key
+		return c
+	}
+}
+
+// newCmd returns a recorded command reading its output from r.
+func (m *Machine) newCmd(
+	ctx context.Context, args []string, r io.Reader,
+) *mockCmd {
+	m.mu.Lock()
+	m.clock++
+	start := m.clock
+	m.mu.Unlock()
+	return &mockCmd{
+		machine: m,
+		call: Call{
+			Args: append([]string{}, args...),
+			Env:  command.Envs(ctx),
+			Dir:  fs.WorkDir(ctx),
+		},
+		reader: r,
+		start:  start,
+		time:   time.Now(),
 	}
 }
 
@@ -292,6 +450,10 @@ func (m *Machine) Command(ctx context.Context, args ...string) command.Buffer {
 
 	m.init()
 	m.mu.Lock()
+	if err := m.injected(args); err != nil {
+		m.mu.Unlock()
+		return m.newCmd(ctx, args, command.Fail(err))
+	}
 	var bestHandler *mockHandler
 	var bestHandlerLen int = -1
 
@@ -306,32 +468,40 @@ func (m *Machine) Command(ctx context.Context, args ...string) command.Buffer {
 		}
 	}
 
+	real := m.real
 	m.mu.Unlock()
 
 	if bestHandler != nil {
 		return bestHandler.fn(ctx, args...)
 	}
 
-	return &mockCmd{
-		machine: m,
-		call: Call{
-			Args: append([]string{}, args...),
-			Env:  command.Envs(ctx),
-		},
-		reader: bytes.NewReader(nil),
+	if real != nil {
+		buf := real.Command(ctx, args...)
+		c := m.newCmd(ctx, args, buf)
+		c.real = buf
+		return c
 	}
+
+	return m.newCmd(ctx, args, bytes.NewReader(nil))
 }
 
 type mockCmd struct {
 	sync.Mutex
-	once       sync.Once
-	machine    *Machine
-	call       Call
-	reader     io.Reader
-	captureBuf *bytes.Buffer
-	key        string
-	input      []byte
-	callIndex  int
+	once        sync.Once
+	machine     *Machine
+	call        Call
+	reader      io.Reader
+	captureBuf  *bytes.Buffer
+	key         string
+	input       []byte
+	callIndex   int
+	recordIndex int
+	start       int
+	time        time.Time
+	result      *command.Result
+
+	// real is the buffer of the real machine, in passthrough mode.
+	real command.Buffer
 }
 
 func (c *mockCmd) Read(p []byte) (n int, err error) {
@@ -346,22 +516,72 @@ func (c *mockCmd) Read(p []byte) (n int, err error) {
 			c.machine.mu.Unlock()
 		}
 		c.recordCall()
+		c.finish(err)
 	}
 	return n, err
 }
 
+// Result implements command.ResultBuffer. A mock command finishes when
+// its output is read to the end; it started when it was created. Like a
+// real command, one that fails to start has no result.
+func (c *mockCmd) Result() (command.Result, bool) {
+	if c.real != nil {
+		return command.ResultOf(c.real)
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.result == nil {
+		return command.Result{}, false
+	}
+	return *c.result, true
+}
+
+func (c *mockCmd) finish(err error) {
+	if command.NotFound(err) {
+		return
+	}
+	r := command.Result{Started: c.time, Finished: time.Now()}
+	if cmdErr := new(command.Error); errors.As(err, &cmdErr) {
+		r.Code = cmdErr.Code
+	} else if err != io.EOF {
+		r.Code = 1
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.result == nil {
+		c.result = &r
+	}
+}
+
 func (c *mockCmd) Write(p []byte) (n int, err error) {
+	n = len(p)
+	if c.real != nil {
+		wb, ok := c.real.(command.WriteBuffer)
+		if !ok {
+			return 0, command.ErrReadOnly
+		}
+		n, err = wb.Write(p)
+	}
 	c.Lock()
-	c.input = append(c.input, p...)
+	c.input = append(c.input, p[:n]...)
 	c.Unlock()
-	return len(p), nil
+	return n, err
 }
 
 func (c *mockCmd) Close() error {
 	c.recordCall()
+	if wb, ok := c.real.(command.WriteBuffer); ok {
+		return wb.Close()
+	}
 	return nil
 }
 
+// Log implements command.LogBuffer, for the real buffer in passthrough
+// mode.
+func (c *mockCmd) Log(w io.Writer) {
+	command.Log(c.real, w)
+}
+
 func (c *mockCmd) recordCall() {
 	c.Lock()
 	if len(c.input) > 0 {
@@ -370,16 +590,26 @@ func (c *mockCmd) recordCall() {
 	call := c.call
 	c.Unlock()
 
+	m := c.machine
 	c.once.Do(func() {
-		c.machine.mu.Lock()
-		c.machine.Calls = append(c.machine.Calls, Call{})
-		c.callIndex = len(c.machine.Calls) - 1
-		c.machine.mu.Unlock()
+		m.mu.Lock()
+		m.clock++
+		m.Calls = append(m.Calls, Call{})
+		m.records = append(m.records, Record{
+			Start:     c.start,
+			End:       m.clock,
+			StartTime: c.time,
+			EndTime:   time.Now(),
+		})
+		c.callIndex = len(m.Calls) - 1
+		c.recordIndex = len(m.records) - 1
+		m.mu.Unlock()
 	})
 
-	c.machine.mu.Lock()
-	c.machine.Calls[c.callIndex] = call
-	c.machine.mu.Unlock()
+	m.mu.Lock()
+	m.Calls[c.callIndex] = call
+	m.records[c.recordIndex].Call = call
+	m.mu.Unlock()
 }
 
 // Calls returns invocations tracked by m, or nil if m is not a mock.Machine.
@@ -425,6 +655,42 @@ func Calls(m command.Machine, pattern ...string) []Call {
 	return filtered
 }
 
+// Records returns the invocations tracked by m with their timing, in the
+// order they started, or nil if m is not a mock.Machine. Like [Calls], it
+// unwraps Shells and filters by an optional argument prefix.
+func Records(m command.Machine, pattern ...string) []Record {
+	mm, ok := m.(*Machine)
+	if sh, isSh := m.(command.Unsheller); !ok && isSh {
+		mm, ok = sh.Unshell().(*Machine)
+	}
+	if !ok {
+		return nil
+	}
+	mm.mu.Lock()
+	records := append([]Record{}, mm.records...)
+	mm.mu.Unlock()
+
+	records = slices.DeleteFunc(records, func(r Record) bool {
+		return !argsMatch(r.Args, pattern)
+	})
+	slices.SortFunc(records, func(a, b Record) int {
+		return a.Start - b.Start
+	})
+	return records
+}
+
+// Before reports whether the first invocation on m matching the prefix a
+// completed before the first invocation matching the prefix b started.
+// It returns false if either has no match.
+//
+//	if !mock.Before(m, []string{"git", "fetch"}, []string{"git", "merge"}) {
+//	    t.Error("git merge ran before git fetch completed")
+//	}
+func Before(m command.Machine, a, b []string) bool {
+	ra, rb := Records(m, a...), Records(m, b...)
+	return len(ra) > 0 && len(rb) > 0 && ra[0].Before(rb[0])
+}
+
 // argsMatch checks if actual args match the pattern.
 // Empty pattern matches all commands.
 func argsMatch(actual, pattern []string) bool {
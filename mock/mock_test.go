@@ -2,14 +2,20 @@ package mock_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
+	"sync"
 	"testing"
 	"testing/iotest"
 
+	"github.com/google/go-cmp/cmp"
+
 	"lesiw.io/command"
+	"lesiw.io/command/mem"
 	"lesiw.io/command/mock"
+	"lesiw.io/fs"
 )
 
 func TestMachineSingleQueue(t *testing.T) {
@@ -511,3 +517,253 @@ func TestMachineConcurrentRecordCall(t *testing.T) {
 		)
 	}
 }
+
+func TestPassthrough(t *testing.T) {
+	m, ctx := mock.Passthrough(mem.Machine()), t.Context()
+	m.Return(strings.NewReader("Docker version 28.0.0\n"), "docker")
+
+	if got, err := command.Read(ctx, m, "echo", "real"); err != nil {
+		t.Fatal(err)
+	} else if want := "real"; got != want {
+		t.Errorf("echo = %q, want %q", got, want)
+	}
+	got, err := command.Read(ctx, m, "docker", "--version")
+	if err != nil {
+		t.Fatal(err)
+	} else if want := "Docker version 28.0.0"; got != want {
+		t.Errorf("docker = %q, want %q", got, want)
+	}
+	if err := command.Do(ctx, m, "missing"); !command.NotFound(err) {
+		t.Errorf("missing: got %v, want not found error", err)
+	}
+
+	var args [][]string
+	for _, c := range mock.Calls(m) {
+		args = append(args, c.Args)
+	}
+	want := [][]string{{"echo", "real"}, {"docker", "--version"}, {"missing"}}
+	if !cmp.Equal(args, want) {
+		t.Errorf("calls (-want +got):\n%s", cmp.Diff(want, args))
+	}
+	if got, want := command.OS(ctx, m), "linux"; got != want {
+		t.Errorf("OS = %q, want %q", got, want)
+	}
+}
+
+func TestPassthroughInput(t *testing.T) {
+	m, ctx := mock.Passthrough(mem.Machine()), t.Context()
+
+	var out strings.Builder
+	_, err := command.Copy(&out,
+		strings.NewReader("hello"),
+		command.NewFilter(ctx, m, "tr", "a-z", "A-Z"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := out.String(), "HELLO"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	calls := mock.Calls(m, "tr")
+	if len(calls) != 1 || string(calls[0].Got) != "hello" {
+		t.Errorf("tr calls = %v, want one with input %q", calls, "hello")
+	}
+}
+
+func TestRecordsOrdering(t *testing.T) {
+	m, ctx := new(mock.Machine), t.Context()
+
+	if err := command.Do(ctx, m, "git", "fetch"); err != nil {
+		t.Fatal(err)
+	}
+	// Start two commands, then finish them in the opposite order.
+	a := command.NewReader(ctx, m, "build", "a")
+	b := command.NewReader(ctx, m, "build", "b")
+	if _, err := io.ReadAll(b); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(a); err != nil {
+		t.Fatal(err)
+	}
+	if err := command.Do(ctx, m, "git", "merge"); err != nil {
+		t.Fatal(err)
+	}
+
+	if !mock.Before(m, []string{"git", "fetch"}, []string{"git", "merge"}) {
+		t.Error("Before(git fetch, git merge) = false, want true")
+	}
+	if mock.Before(m, []string{"git", "merge"}, []string{"git", "fetch"}) {
+		t.Error("Before(git merge, git fetch) = true, want false")
+	}
+	if mock.Before(m, []string{"git"}, []string{"nonexistent"}) {
+		t.Error("Before(git, nonexistent) = true, want false")
+	}
+
+	builds := mock.Records(m, "build")
+	if len(builds) != 2 {
+		t.Fatalf("build records = %d, want 2", len(builds))
+	}
+	if got := builds[0].Args[1]; got != "a" {
+		t.Errorf("first started build = %q, want %q", got, "a")
+	}
+	if !builds[0].Overlaps(builds[1]) {
+		t.Error("builds a and b do not overlap, want overlap")
+	}
+	if got, want := mock.Calls(m, "build")[0].Args[1], "b"; got != want {
+		t.Errorf("first completed build = %q, want %q", got, want)
+	}
+	for _, r := range mock.Records(m) {
+		if r.EndTime.Before(r.StartTime) {
+			t.Errorf("%v: EndTime %v before StartTime %v",
+				r.Args, r.EndTime, r.StartTime)
+		}
+	}
+}
+
+func TestRecordsConcurrent(t *testing.T) {
+	m, ctx := new(mock.Machine), t.Context()
+
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range 3 {
+				err := command.Do(ctx, m, "step", fmt.Sprint(i), fmt.Sprint(j))
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	records := mock.Records(m)
+	if got, want := len(records), 150; got != want {
+		t.Fatalf("records = %d, want %d", got, want)
+	}
+	last := make(map[string]mock.Record)
+	for _, r := range records {
+		g := r.Args[1]
+		if prev, ok := last[g]; ok && !prev.Before(r) {
+			t.Errorf("goroutine %s: %v not before %v", g, prev.Args, r.Args)
+		}
+		last[g] = r
+	}
+}
+
+func TestMachineRecordsContext(t *testing.T) {
+	m := new(mock.Machine)
+	ctx := fs.WithWorkDir(t.Context(), "/src")
+	ctx = command.WithEnv(ctx, map[string]string{"GOOS": "linux"})
+
+	if err := command.Do(ctx, m, "go", "build"); err != nil {
+		t.Fatal(err)
+	}
+	err := command.Run(t.Context(), m, command.Spec{
+		Args: []string{"go", "test"},
+		Dir:  "/src/pkg",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []mock.Call{{
+		Args: []string{"go", "build"},
+		Env:  map[string]string{"GOOS": "linux"},
+		Dir:  "/src",
+	}, {
+		Args: []string{"go", "test"},
+		Dir:  "/src/pkg",
+	}}
+	if got := mock.Calls(m); !cmp.Equal(got, want) {
+		t.Errorf("calls (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestMachineFailEvery(t *testing.T) {
+	m, ctx := new(mock.Machine), t.Context()
+	m.Return(strings.NewReader("ok"), "ssh")
+	errFlaky := &command.Error{Code: 255}
+	m.FailEvery(3, errFlaky, "ssh")
+
+	var got []bool
+	for range 7 {
+		err := command.Do(ctx, m, "ssh", "host", "true")
+		got = append(got, err == nil)
+		if err != nil && !errors.Is(err, errFlaky) {
+			t.Errorf("ssh error: got %v, want %v", err, errFlaky)
+		}
+	}
+	if err := command.Do(ctx, m, "git", "status"); err != nil {
+		t.Errorf("git error: got %v, want <nil>", err)
+	}
+
+	want := []bool{true, true, false, true, true, false, true}
+	if !cmp.Equal(got, want) {
+		t.Errorf("ssh successes (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	if got, want := len(mock.Calls(m, "ssh")), 7; got != want {
+		t.Errorf("ssh calls = %d, want %d", got, want)
+	}
+}
+
+func TestMachineFailRandom(t *testing.T) {
+	run := func() []bool {
+		m, ctx := new(mock.Machine), t.Context()
+		m.FailRandom(0.5, 42)
+		var ok []bool
+		for range 100 {
+			err := command.Do(ctx, m, "curl", "example.com")
+			if err != nil && !errors.Is(err, mock.ErrInjected) {
+				t.Fatalf("curl error: got %v, want ErrInjected", err)
+			}
+			ok = append(ok, err == nil)
+		}
+		return ok
+	}
+
+	first, second := run(), run()
+	if !cmp.Equal(first, second) {
+		t.Error("failures differ between runs with the same seed")
+	}
+	var failed int
+	for _, ok := range first {
+		if !ok {
+			failed++
+		}
+	}
+	if failed < 25 || failed > 75 {
+		t.Errorf("failed %d of 100 calls, want about 50", failed)
+	}
+}
+
+func TestMachineResult(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("ok\n"), "true")
+	m.Return(command.Fail(&command.Error{Code: 2}), "false")
+	m.Return(command.Fail(&command.Error{Err: io.EOF}), "missing")
+
+	for _, tt := range []struct {
+		name string
+		code int
+		ok   bool
+	}{
+		{"true", 0, true},
+		{"false", 2, true},
+		{"missing", 0, false},
+	} {
+		buf := m.Command(t.Context(), tt.name)
+		_, _ = io.ReadAll(buf)
+		got, ok := command.ResultOf(buf)
+		if ok != tt.ok || got.Code != tt.code {
+			t.Errorf("%s: ResultOf() = code %d, %v, want code %d, %v",
+				tt.name, got.Code, ok, tt.code, tt.ok)
+		}
+		if ok && got.Finished.Before(got.Started) {
+			t.Errorf("%s: Finished %v before Started %v",
+				tt.name, got.Finished, got.Started)
+		}
+	}
+}
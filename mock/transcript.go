@@ -0,0 +1,264 @@
+package mock
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/argv"
+)
+
+var (
+	assignRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+	exitRE   = regexp.MustCompile(`^\[exit (\d+)\]$`)
+)
+
+// An Entry is a command in a [Transcript] and what it printed.
+type Entry struct {
+	// Args is the command and its arguments.
+	Args []string
+
+	// Out is the command's output, as lines ending in newlines.
+	Out string
+
+	// Code is the command's exit code. A command with a code other
+	// than 0 fails.
+	Code int
+
+	// NotFound reports whether the command failed as one that does not
+	// exist.
+	NotFound bool
+}
+
+// err returns the error that e's command fails with, if any.
+func (e *Entry) err() error {
+	switch {
+	case e.NotFound:
+		return &command.Error{
+			Err: fmt.Errorf("command not found: %s", e.Args[0]),
+		}
+	case e.Code != 0:
+		return &command.Error{Code: e.Code}
+	}
+	return nil
+}
+
+// A Transcript is a captured session of commands, in the format that
+// [FromTranscript] replays.
+type Transcript []Entry
+
+// FromTranscript returns a Machine that replays transcript, a captured
+// session in the format of command traces: each command on a line
+// beginning with "+ ", followed by the lines it printed.
+//
+//	m := mock.FromTranscript(t, `
+//	+ uname -s
+//	Linux
+//	+ git rev-parse HEAD
+//	4b825dc642cb6eb9a060e54bf8d69288fbee4904
+//	+ test -d build
+//	[exit 1]
+//	`)
+//
+// A line "[exit N]" after a command's output makes it fail with exit code
+// N, and "[not found]" makes it fail as a command that does not exist.
+// Commands are quoted as [argv.Marshal] quotes them. Environment
+// assignments that prefix a command, as in CMDTRACE=full traces, are
+// ignored.
+//
+// Commands match transcript lines exactly, arguments and all. A command
+// repeated in the transcript responds with each of its outputs in turn,
+// and then with the last one again. The test fails if the Machine runs a
+// command that the transcript lacks, or if, by the end of the test, it
+// has not run each command as many times as the transcript does.
+func FromTranscript(t testing.TB, transcript string) *Machine {
+	t.Helper()
+	entries, err := ParseTranscript(transcript)
+	if err != nil {
+		t.Fatalf("mock.FromTranscript: %v", err)
+	}
+	m := new(Machine)
+	var (
+		mu     sync.Mutex
+		queues = make(map[string][]*Entry)
+		want   = make(map[string]int)
+		keys   []string
+	)
+	for i := range entries {
+		e := &entries[i]
+		key := argv.Marshal(e.Args)
+		if want[key] == 0 {
+			keys = append(keys, key)
+		}
+		queues[key] = append(queues[key], e)
+		want[key]++
+	}
+	m.Do(func(ctx context.Context, args ...string) command.Buffer {
+		key := argv.Marshal(args)
+		mu.Lock()
+		q := queues[key]
+		var e *Entry
+		if len(q) > 0 {
+			e = q[0]
+			if len(q) > 1 {
+				queues[key] = q[1:]
+			}
+		}
+		mu.Unlock()
+		if e == nil {
+			t.Errorf("mock.FromTranscript: unexpected command: %s", key)
+			return m.newCmd(ctx, args, command.Fail(&command.Error{
+				Err: fmt.Errorf("not in transcript: %s", key),
+			}))
+		}
+		var r io.Reader = strings.NewReader(e.Out)
+		if err := e.err(); err != nil {
+			r = io.MultiReader(r, command.Fail(err))
+		}
+		return m.newCmd(ctx, args, r)
+	})
+	t.Cleanup(func() {
+		got := make(map[string]int)
+		for _, c := range Calls(m) {
+			got[argv.Marshal(c.Args)]++
+		}
+		for _, key := range keys {
+			if got[key] < want[key] {
+				t.Errorf("mock.FromTranscript: %s ran %d time(s), want %d",
+					key, got[key], want[key])
+			}
+		}
+	})
+	return m
+}
+
+// ParseTranscript parses a transcript in the format that
+// [FromTranscript] replays.
+func ParseTranscript(transcript string) (Transcript, error) {
+	var (
+		entries Transcript
+		cur     *Entry
+		done    bool // The current entry has a status.
+	)
+	lines := strings.Split(strings.TrimPrefix(transcript, "\n"), "\n")
+	if n := len(lines); n > 0 && strings.TrimSpace(lines[n-1]) == "" {
+		lines = lines[:n-1] // The newline ending the last line.
+	}
+	for i, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		if cmd, ok := strings.CutPrefix(line, "+ "); ok {
+			args, err := argv.Unmarshal(cmd)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			}
+			for len(args) > 0 && assignRE.MatchString(args[0]) {
+				args = args[1:]
+			}
+			if len(args) == 0 {
+				return nil, fmt.Errorf("line %d: no command", i+1)
+			}
+			entries = append(entries, Entry{Args: args})
+			cur, done = &entries[len(entries)-1], false
+			continue
+		}
+		if cur == nil {
+			if strings.TrimSpace(line) == "" {
+				continue
+			}
+			return nil, fmt.Errorf("line %d: output before any command",
+				i+1)
+		}
+		if done {
+			return nil, fmt.Errorf("line %d: output after status", i+1)
+		}
+		if match := exitRE.FindStringSubmatch(line); match != nil {
+			cur.Code, _ = strconv.Atoi(match[1])
+			done = true
+			continue
+		}
+		if line == "[not found]" {
+			cur.NotFound, done = true, true
+			continue
+		}
+		cur.Out += line + "\n"
+	}
+	return entries, nil
+}
+
+// String formats t as a transcript that [ParseTranscript] reads back:
+// each command on a line beginning with "+ ", followed by its output and,
+// if it failed, its status.
+func (t Transcript) String() string {
+	var b strings.Builder
+	for _, e := range t {
+		b.WriteString("+ " + argv.Marshal(e.Args) + "\n")
+		b.WriteString(e.Out)
+		if e.Out != "" && !strings.HasSuffix(e.Out, "\n") {
+			b.WriteString("\n")
+		}
+		switch {
+		case e.NotFound:
+			b.WriteString("[not found]\n")
+		case e.Code != 0:
+			_, _ = fmt.Fprintf(&b, "[exit %d]\n", e.Code)
+		}
+	}
+	return b.String()
+}
+
+// DiffTranscript returns a line-by-line diff of the transcripts want and
+// got, or the empty string if they are the same. Lines only in want begin
+// with "- ", lines only in got with "+ ", and lines in both with two
+// spaces:
+//
+//	if diff := mock.DiffTranscript(want, got); diff != "" {
+//	    t.Errorf("transcript (-want +got):\n%s", diff)
+//	}
+func DiffTranscript(want, got Transcript) string {
+	a := strings.SplitAfter(want.String(), "\n")
+	b := strings.SplitAfter(got.String(), "\n")
+	a, b = a[:len(a)-1], b[:len(b)-1] // After the last newline.
+	// lcs[i][j] is the length of the longest common subsequence of
+	// a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var (
+		diff    strings.Builder
+		changed bool
+	)
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			diff.WriteString("  " + a[i])
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			diff.WriteString("- " + a[i])
+			i, changed = i+1, true
+		default:
+			diff.WriteString("+ " + b[j])
+			j, changed = j+1, true
+		}
+	}
+	if !changed {
+		return ""
+	}
+	return diff.String()
+}
@@ -0,0 +1,171 @@
+package mock_test
+
+import (
+	"errors"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+)
+
+func TestFromTranscript(t *testing.T) {
+	m := mock.FromTranscript(t, `
++ uname -s
+Linux
++ git log --format='%h %s' -1
+4b825dc initial commit
++ CGO_ENABLED=0 go build ./...
++ test -d build
+[exit 1]
++ test -d build
+
++ docker version
+[not found]
+`)
+	ctx := t.Context()
+
+	if got, want := command.OS(ctx, m), "linux"; got != want {
+		t.Errorf("command.OS = %q, want %q", got, want)
+	}
+	out, err := command.Read(ctx, m, "git", "log", "--format=%h %s", "-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "4b825dc initial commit"; out != want {
+		t.Errorf("git log = %q, want %q", out, want)
+	}
+	if err := command.Do(ctx, m, "go", "build", "./..."); err != nil {
+		t.Errorf("go build error: %v", err)
+	}
+	err = command.Do(ctx, m, "test", "-d", "build")
+	if e := new(command.Error); !errors.As(err, &e) || e.Code != 1 {
+		t.Errorf("first test -d error: got %v, want exit status 1", err)
+	}
+	if err := command.Do(ctx, m, "test", "-d", "build"); err != nil {
+		t.Errorf("second test -d error: %v", err)
+	}
+	err = command.Do(ctx, m, "docker", "version")
+	if !command.NotFound(err) {
+		t.Errorf("docker error: got %v, want not found", err)
+	}
+}
+
+func TestFromTranscriptUnmet(t *testing.T) {
+	ft := new(fakeT)
+	m := mock.FromTranscript(ft, "+ echo hi\nhi\n+ echo hi\nhi\n")
+	if err := command.Do(t.Context(), m, "echo", "hi"); err != nil {
+		t.Fatal(err)
+	}
+	if err := command.Do(t.Context(), m, "ls"); err == nil {
+		t.Error("ls error: got <nil>, want error")
+	}
+	ft.cleanup()
+
+	if got, want := len(ft.errors), 2; got != want {
+		t.Errorf("errors = %q, want %d errors", ft.errors, want)
+	}
+}
+
+// fakeT records the errors and cleanups of a test.
+type fakeT struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (*fakeT) Helper()            {}
+func (t *fakeT) Cleanup(f func()) { t.cleanups = append(t.cleanups, f) }
+
+func (t *fakeT) Errorf(format string, args ...any) {
+	t.errors = append(t.errors, format)
+}
+
+func (t *fakeT) cleanup() {
+	for _, f := range t.cleanups {
+		f()
+	}
+}
+
+func TestParseTranscript(t *testing.T) {
+	tr, err := mock.ParseTranscript(`
++ FOO=1 echo 'a b'
+a b
++ false
+[exit 1]
++ nope
+[not found]
+`)
+	if err != nil {
+		t.Fatalf("mock.ParseTranscript error: %v", err)
+	}
+	want := mock.Transcript{
+		{Args: []string{"echo", "a b"}, Out: "a b\n"},
+		{Args: []string{"false"}, Code: 1},
+		{Args: []string{"nope"}, NotFound: true},
+	}
+	if diff := mock.DiffTranscript(want, tr); diff != "" {
+		t.Errorf("mock.ParseTranscript (-want +got):\n%s", diff)
+	}
+	again, err := mock.ParseTranscript(tr.String())
+	if err != nil {
+		t.Fatalf("mock.ParseTranscript(String()) error: %v", err)
+	}
+	if diff := mock.DiffTranscript(tr, again); diff != "" {
+		t.Errorf("round trip (-want +got):\n%s", diff)
+	}
+}
+
+func TestParseTranscriptError(t *testing.T) {
+	for _, s := range []string{
+		"output\n+ echo\n",
+		"+ echo\n[exit 1]\nmore\n",
+		"+ echo 'unterminated\n",
+	} {
+		if _, err := mock.ParseTranscript(s); err == nil {
+			t.Errorf("mock.ParseTranscript(%q) error: nil, want error", s)
+		}
+	}
+}
+
+func TestTranscriptString(t *testing.T) {
+	tr := mock.Transcript{
+		{Args: []string{"git", "log", "--format=%h %s"}, Out: "abc x"},
+		{Args: []string{"test", "-d", "build"}, Code: 1},
+	}
+	want := "+ git log '--format=%h %s'\nabc x\n" +
+		"+ test -d build\n[exit 1]\n"
+	if got := tr.String(); got != want {
+		t.Errorf("Transcript.String = %q, want %q", got, want)
+	}
+}
+
+func TestDiffTranscript(t *testing.T) {
+	want := mock.Transcript{
+		{Args: []string{"uname", "-s"}, Out: "Linux\n"},
+		{Args: []string{"make"}},
+	}
+	got := mock.Transcript{
+		{Args: []string{"uname", "-s"}, Out: "Linux\n"},
+		{Args: []string{"make", "all"}, Code: 2},
+	}
+	wantDiff := "  + uname -s\n  Linux\n- + make\n+ + make all\n+ [exit 2]\n"
+	if diff := mock.DiffTranscript(want, got); diff != wantDiff {
+		t.Errorf("mock.DiffTranscript = %q, want %q", diff, wantDiff)
+	}
+	if diff := mock.DiffTranscript(want, want); diff != "" {
+		t.Errorf("mock.DiffTranscript(want, want) = %q, want empty", diff)
+	}
+}
+
+func TestTranscriptMultilineArgs(t *testing.T) {
+	tr := mock.Transcript{
+		{Args: []string{"git", "commit", "-m", "fix\nbug"}, Out: "ok\n"},
+	}
+	got, err := mock.ParseTranscript(tr.String())
+	if err != nil {
+		t.Fatalf("mock.ParseTranscript error: %v", err)
+	}
+	if diff := mock.DiffTranscript(tr, got); diff != "" {
+		t.Errorf("round trip (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,173 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+type netDiagKey struct{}
+
+// WithNetDiagnostics returns a new context that diagnoses the network
+// failures of commands run with it. When a command fails with what looks
+// like a network error, such as a refused connection or a name that did
+// not resolve, the addresses, routes, and DNS configuration of the
+// machine that ran it are gathered and attached to its error as a
+// [NetError].
+//
+// Diagnostics are gathered on the machine that ran the command, so they
+// show the network as it saw it, such as from inside a container or on a
+// CI runner:
+//
+//	ctx := command.WithNetDiagnostics(ctx)
+//	err := command.Do(ctx, m, "curl", "-fsS", "https://example.com/")
+//	var nerr *command.NetError
+//	if errors.As(err, &nerr) {
+//	    log.Print(nerr.Routes)
+//	}
+//
+// [Run], [Do], [Read], and [Exec] diagnose their commands.
+func WithNetDiagnostics(ctx context.Context) context.Context {
+	return context.WithValue(ctx, netDiagKey{}, true)
+}
+
+// A NetError is the error of a command that failed with a network error,
+// along with the network state of the machine that ran it. Each field
+// holds the output of the tools that report it, or is empty if they
+// could not be run.
+type NetError struct {
+	Err error // The error of the failed command.
+
+	Addrs  string // The network interfaces and their addresses.
+	Routes string // The routing table.
+	DNS    string // The resolver configuration and lookups of hosts.
+}
+
+func (e *NetError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Err.Error())
+	for _, s := range []struct{ name, text string }{
+		{"addresses", e.Addrs},
+		{"routes", e.Routes},
+		{"dns", e.DNS},
+	} {
+		if s.text == "" {
+			continue
+		}
+		b.WriteString("\n" + s.name + ":\n\t")
+		b.WriteString(strings.ReplaceAll(s.text, "\n", "\n\t"))
+	}
+	return b.String()
+}
+
+func (e *NetError) Unwrap() error { return e.Err }
+
+var netErrorRE = regexp.MustCompile(`(?i)` + strings.Join([]string{
+	`could not resolve`,
+	`name or service not known`,
+	`temporary failure in name resolution`,
+	`no such host`,
+	`no address associated`,
+	`connection refused`,
+	`connection reset`,
+	`connection timed out`,
+	`network is unreachable`,
+	`no route to host`,
+	`failed to connect`,
+	`unable to connect`,
+	`tls handshake`,
+	`i/o timeout`,
+}, "|"))
+
+// netError reports whether err looks like a network error.
+func netError(err error) bool {
+	if netErrorRE.MatchString(err.Error()) {
+		return true
+	}
+	e := new(Error)
+	return errors.As(err, &e) && netErrorRE.Match(e.Log)
+}
+
+// netDiagnose wraps err in a [NetError] if ctx asks for diagnostics and
+// err looks like a network error.
+func netDiagnose(
+	ctx context.Context, m Machine, args []string, err error,
+) error {
+	if on, _ := ctx.Value(netDiagKey{}).(bool); !on {
+		return err
+	}
+	if err == nil || NotFound(err) || !netError(err) {
+		return err
+	}
+	// The command's context may be the reason it failed, and the
+	// diagnostics must not be redirected or diagnosed themselves.
+	ctx, cancel := context.WithTimeout(
+		context.WithoutCancel(ctx), 10*time.Second,
+	)
+	defer cancel()
+	ctx = context.WithValue(ctx, netDiagKey{}, false)
+	ctx = WithoutCommandOptions(ctx)
+
+	e := &NetError{Err: err}
+	hosts := argHosts(args)
+	if OS(ctx, m) == "windows" {
+		e.Addrs, _ = Read(ctx, m, "ipconfig", "/all")
+		e.Routes, _ = Read(ctx, m, "route", "print")
+		var dns []string
+		for _, h := range hosts {
+			if out, _ := Read(ctx, m, "nslookup", h); out != "" {
+				dns = append(dns, out)
+			}
+		}
+		e.DNS = strings.Join(dns, "\n")
+	} else {
+		e.Addrs, _ = Read(ctx, m, "sh", "-c", addrsScript)
+		e.Routes, _ = Read(ctx, m, "sh", "-c", routesScript)
+		e.DNS, _ = Read(ctx, m,
+			append([]string{"sh", "-c", dnsScript, "sh"}, hosts...)...)
+	}
+	if e.Addrs == "" && e.Routes == "" && e.DNS == "" {
+		return err
+	}
+	return e
+}
+
+const addrsScript = `ip addr 2>/dev/null || ifconfig -a`
+
+const routesScript = `ip route 2>/dev/null || netstat -rn`
+
+// dnsScript prints the resolver configuration, then looks up each host
+// in its arguments.
+const dnsScript = `grep -v '^#' /etc/resolv.conf 2>/dev/null
+for h; do
+	if command -v getent >/dev/null 2>&1; then
+		getent ahosts "$h" || echo "$h: no addresses"
+	else
+		nslookup "$h" 2>&1
+	fi
+done
+:`
+
+// argHosts returns the hosts named by URLs or host:port pairs in args.
+func argHosts(args []string) []string {
+	var hosts []string
+	for _, arg := range args {
+		var host string
+		if u, err := url.Parse(arg); err == nil && u.Host != "" {
+			host = u.Hostname()
+		} else if h, p, err := net.SplitHostPort(arg); err == nil &&
+			h != "" && p != "" && strings.Trim(p, "0123456789") == "" {
+			host = h
+		}
+		if host != "" && net.ParseIP(host) == nil &&
+			!slices.Contains(hosts, host) {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
@@ -0,0 +1,84 @@
+package command_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+)
+
+func netDiagMachine(log string) *mock.Machine {
+	m := new(mock.Machine)
+	m.SetOS("linux")
+	m.Return(command.Fail(&command.Error{Log: []byte(log), Code: 6}),
+		"curl")
+	m.Return(strings.NewReader("inet 10.0.0.2/24\n"), "sh")
+	m.Return(strings.NewReader("default via 10.0.0.1\n"), "sh")
+	m.Return(strings.NewReader("nameserver 10.0.0.53\n"), "sh")
+	return m
+}
+
+func TestNetDiagnostics(t *testing.T) {
+	ctx := command.WithNetDiagnostics(t.Context())
+	m := netDiagMachine("curl: (6) Could not resolve host: db.internal\n")
+
+	err := command.Do(ctx, m, "curl", "-fsS", "http://db.internal:8080/")
+
+	var nerr *command.NetError
+	if !errors.As(err, &nerr) {
+		t.Fatalf("command.Do error: got %v, want NetError", err)
+	}
+	if got, want := nerr.Addrs, "inet 10.0.0.2/24"; got != want {
+		t.Errorf("Addrs = %q, want %q", got, want)
+	}
+	if got, want := nerr.Routes, "default via 10.0.0.1"; got != want {
+		t.Errorf("Routes = %q, want %q", got, want)
+	}
+	if got, want := nerr.DNS, "nameserver 10.0.0.53"; got != want {
+		t.Errorf("DNS = %q, want %q", got, want)
+	}
+	if !strings.Contains(err.Error(), "routes:\n\tdefault via 10.0.0.1") {
+		t.Errorf("command.Do error = %q, want routes", err)
+	}
+	if e := new(command.Error); !errors.As(err, &e) || e.Code != 6 {
+		t.Errorf("command.Do error: got %v, want wrapped Error", err)
+	}
+
+	calls := mock.Calls(m, "sh")
+	if len(calls) != 3 {
+		t.Fatalf("diagnostic calls = %d, want 3", len(calls))
+	}
+	dns := calls[2].Args
+	if got, want := dns[len(dns)-1], "db.internal"; got != want {
+		t.Errorf("looked up %q, want %q", got, want)
+	}
+}
+
+func TestNetDiagnosticsOff(t *testing.T) {
+	m := netDiagMachine("curl: (7) Failed to connect: Connection refused\n")
+
+	err := command.Do(t.Context(), m, "curl", "http://localhost/")
+
+	if nerr := new(command.NetError); errors.As(err, &nerr) {
+		t.Errorf("command.Do error: got NetError without diagnostics")
+	}
+	if calls := mock.Calls(m, "sh"); len(calls) != 0 {
+		t.Errorf("diagnostic calls = %d, want 0", len(calls))
+	}
+}
+
+func TestNetDiagnosticsOtherError(t *testing.T) {
+	ctx := command.WithNetDiagnostics(t.Context())
+	m := netDiagMachine("curl: (23) Failure writing output\n")
+
+	err := command.Do(ctx, m, "curl", "http://localhost/")
+
+	if nerr := new(command.NetError); errors.As(err, &nerr) {
+		t.Errorf("command.Do error: got NetError for a non-network error")
+	}
+	if calls := mock.Calls(m, "sh"); len(calls) != 0 {
+		t.Errorf("diagnostic calls = %d, want 0", len(calls))
+	}
+}
@@ -0,0 +1,126 @@
+package command
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"path"
+	"slices"
+
+	"lesiw.io/fs"
+)
+
+type inputsKey struct{}
+
+// WithInputs returns a new context that declares paths as the inputs of
+// the commands run with it, files on the machine that runs them whose
+// contents decide their results. Calls accumulate.
+//
+// [Once] runs a command again when one of its inputs changes.
+func WithInputs(ctx context.Context, paths ...string) context.Context {
+	inputs := slices.Concat(Inputs(ctx), paths)
+	return context.WithValue(ctx, inputsKey{}, inputs)
+}
+
+// Inputs returns the paths declared in ctx by [WithInputs].
+func Inputs(ctx context.Context) []string {
+	inputs, _ := ctx.Value(inputsKey{}).([]string)
+	return inputs
+}
+
+// Once runs a command on m, as [Do] does, unless it has already run
+// successfully there under key with the same arguments, working
+// directory, environment variables set by [WithEnv], and inputs, as
+// declared by [WithInputs]. It suits
+// provisioning and code generation steps that are slow to repeat:
+//
+//	ctx := command.WithInputs(ctx, "api.proto")
+//	err := command.Once(ctx, m, "gen-api",
+//	    "protoc", "--go_out=.", "api.proto")
+//
+// Once records a stamp for key in the [CacheDir] of m, once the command
+// succeeds. A command that fails leaves no stamp, so it runs again the
+// next time. [Invalidate] removes a stamp, so that the command runs
+// again regardless.
+func Once(ctx context.Context, m Machine, key string, args ...string) error {
+	sctx := WithoutCommandOptions(ctx) // For the stamp's own commands.
+	name, err := stampPath(sctx, m, key)
+	if err != nil {
+		return err
+	}
+	sum, err := stampSum(sctx, m, args)
+	if err != nil {
+		return err
+	}
+	fsys := FS(m)
+	if old, err := fs.ReadFile(sctx, fsys, name); err == nil &&
+		string(old) == sum {
+		return nil
+	}
+	if err := Do(ctx, m, args...); err != nil {
+		return err
+	}
+	if err := fs.MkdirAll(sctx, fsys, path.Dir(name)); err != nil {
+		return fmt.Errorf("failed to record stamp for %q: %w", key, err)
+	}
+	if err := fs.WriteFile(sctx, fsys, name, []byte(sum)); err != nil {
+		return fmt.Errorf("failed to record stamp for %q: %w", key, err)
+	}
+	return nil
+}
+
+// Invalidate removes the stamp that [Once] recorded for key on m, if
+// there is one, so that its command runs again.
+func Invalidate(ctx context.Context, m Machine, key string) error {
+	ctx = WithoutCommandOptions(ctx)
+	name, err := stampPath(ctx, m, key)
+	if err != nil {
+		return err
+	}
+	err = fs.Remove(ctx, FS(m), name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove stamp for %q: %w", key, err)
+	}
+	return nil
+}
+
+// stampPath returns the path of the stamp for key on m.
+func stampPath(ctx context.Context, m Machine, key string) (string, error) {
+	dir, err := CacheDir(ctx, m)
+	if err != nil {
+		return "", fmt.Errorf("no stamp for %q: %w", key, err)
+	}
+	h := sha256.Sum256([]byte(key))
+	return path.Join(dir, "stamps", hex.EncodeToString(h[:])), nil
+}
+
+// stampSum returns the digest of a command's arguments, working
+// directory, environment, and inputs.
+func stampSum(ctx context.Context, m Machine, args []string) (string, error) {
+	h := sha256.New()
+	for _, arg := range args {
+		_, _ = io.WriteString(h, arg+"\x00")
+	}
+	_, _ = io.WriteString(h, "\x00"+fs.WorkDir(ctx)+"\x00")
+	env := Envs(ctx)
+	for _, k := range slices.Sorted(maps.Keys(env)) {
+		_, _ = io.WriteString(h, k+"="+env[k]+"\x00")
+	}
+	_, _ = io.WriteString(h, "\x00")
+	fsys := FS(m)
+	for _, name := range Inputs(ctx) {
+		r := fs.OpenBuffer(ctx, fsys, name)
+		ih := sha256.New()
+		_, err := io.Copy(ih, r)
+		_ = r.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to hash input %q: %w", name, err)
+		}
+		_, _ = fmt.Fprintf(h, "%s\x00%x\x00", name, ih.Sum(nil))
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,111 @@
+package command_test
+
+import (
+	"strings"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+	"lesiw.io/fs"
+)
+
+func onceMachine(t *testing.T) *mock.Machine {
+	t.Helper()
+	m := new(mock.Machine)
+	m.SetOS("linux")
+	m.Return(strings.NewReader("/home/gopher\n"), "printenv", "HOME")
+	err := fs.WriteFile(t.Context(), m.FS(), "api.proto", []byte("v1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestOnce(t *testing.T) {
+	m := onceMachine(t)
+	ctx := command.WithInputs(t.Context(), "api.proto")
+	gen := func() {
+		t.Helper()
+		err := command.Once(ctx, m, "gen", "protoc", "api.proto")
+		if err != nil {
+			t.Fatalf("command.Once error: %v", err)
+		}
+	}
+
+	gen()
+	gen()
+	if got, want := len(mock.Calls(m, "protoc")), 1; got != want {
+		t.Fatalf("protoc calls = %d, want %d", got, want)
+	}
+
+	err := fs.WriteFile(ctx, m.FS(), "api.proto", []byte("v2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen()
+	if got, want := len(mock.Calls(m, "protoc")), 2; got != want {
+		t.Fatalf("protoc calls after input change = %d, want %d", got, want)
+	}
+
+	if err := command.Invalidate(ctx, m, "gen"); err != nil {
+		t.Fatalf("command.Invalidate error: %v", err)
+	}
+	gen()
+	if got, want := len(mock.Calls(m, "protoc")), 3; got != want {
+		t.Fatalf("protoc calls after Invalidate = %d, want %d", got, want)
+	}
+}
+
+func TestOnceArgsChange(t *testing.T) {
+	ctx, m := t.Context(), onceMachine(t)
+
+	for _, args := range [][]string{
+		{"make", "a"}, {"make", "a"}, {"make", "b"},
+	} {
+		if err := command.Once(ctx, m, "make", args...); err != nil {
+			t.Fatalf("command.Once error: %v", err)
+		}
+	}
+	if got, want := len(mock.Calls(m, "make")), 2; got != want {
+		t.Errorf("make calls = %d, want %d", got, want)
+	}
+}
+
+func TestOnceEnvChange(t *testing.T) {
+	ctx, m := t.Context(), onceMachine(t)
+
+	for _, mode := range []string{"debug", "debug", "release"} {
+		ctx := command.WithEnv(ctx, map[string]string{"MODE": mode})
+		if err := command.Once(ctx, m, "make", "make"); err != nil {
+			t.Fatalf("command.Once error: %v", err)
+		}
+	}
+	if got, want := len(mock.Calls(m, "make")), 2; got != want {
+		t.Errorf("make calls = %d, want %d", got, want)
+	}
+}
+
+func TestOnceFailure(t *testing.T) {
+	ctx, m := t.Context(), onceMachine(t)
+	m.Return(command.Fail(&command.Error{Code: 1}), "make")
+	m.Return(strings.NewReader(""), "make")
+
+	if err := command.Once(ctx, m, "make", "make"); err == nil {
+		t.Fatal("command.Once succeeded, want error")
+	}
+	for range 2 {
+		if err := command.Once(ctx, m, "make", "make"); err != nil {
+			t.Fatalf("command.Once error: %v", err)
+		}
+	}
+	if got, want := len(mock.Calls(m, "make")), 2; got != want {
+		t.Errorf("make calls = %d, want %d", got, want)
+	}
+}
+
+func TestInvalidateMissing(t *testing.T) {
+	ctx, m := t.Context(), onceMachine(t)
+	if err := command.Invalidate(ctx, m, "never-ran"); err != nil {
+		t.Errorf("command.Invalidate error: %v", err)
+	}
+}
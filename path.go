@@ -0,0 +1,80 @@
+package command
+
+import (
+	"context"
+	"path"
+	"strings"
+
+	"lesiw.io/fs"
+)
+
+// Join joins path elements with the path separator of m, like
+// [path/filepath.Join] would if it ran on m. Empty elements are ignored,
+// and the result is cleaned. A trailing separator on the last element is
+// kept, since it marks a directory.
+//
+// Elements may use either separator on Windows machines.
+//
+//	command.Join(ctx, m, `C:\Users\me`, "src/app") // C:\Users\me\src\app
+func Join(ctx context.Context, m Machine, elem ...string) string {
+	windows := OS(ctx, m) == "windows"
+	var (
+		slashed []string
+		dir     bool
+	)
+	for _, e := range elem {
+		if e == "" {
+			continue
+		}
+		if windows {
+			e = strings.ReplaceAll(e, `\`, "/")
+		}
+		slashed = append(slashed, e)
+		dir = strings.HasSuffix(e, "/")
+	}
+	if len(slashed) == 0 {
+		return ""
+	}
+	var unc bool // A \\server\share path, which path.Join would mangle.
+	if windows && strings.HasPrefix(slashed[0], "//") {
+		unc, slashed[0] = true, strings.TrimLeft(slashed[0], "/")
+	}
+	joined := path.Join(slashed...)
+	if dir && joined != "/" {
+		joined += "/"
+	}
+	if unc {
+		joined = "//" + joined
+	}
+	if windows {
+		return FromSlash(ctx, m, joined)
+	}
+	return joined
+}
+
+// ToSlash returns name with each path separator of m replaced by a slash.
+// It returns name unchanged unless m is a Windows machine.
+func ToSlash(ctx context.Context, m Machine, name string) string {
+	if OS(ctx, m) != "windows" {
+		return name
+	}
+	return strings.ReplaceAll(name, `\`, "/")
+}
+
+// FromSlash returns name with each slash replaced by the path separator
+// of m. It returns name unchanged unless m is a Windows machine.
+func FromSlash(ctx context.Context, m Machine, name string) string {
+	if OS(ctx, m) != "windows" {
+		return name
+	}
+	return strings.ReplaceAll(name, "/", `\`)
+}
+
+// Abs returns an absolute representation of name on m. A relative name is
+// resolved against the working directory of ctx if it has one, and
+// against the working directory of m otherwise.
+//
+// Abs is shorthand for [fs.Abs] on the filesystem of m.
+func Abs(ctx context.Context, m Machine, name string) (string, error) {
+	return fs.Abs(ctx, FS(m), name)
+}
@@ -0,0 +1,70 @@
+package command_test
+
+import (
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mem"
+	"lesiw.io/command/mock"
+	"lesiw.io/fs"
+)
+
+func TestJoin(t *testing.T) {
+	tests := []struct {
+		os   string
+		elem []string
+		want string
+	}{
+		{"linux", []string{"/usr", "local", "bin"}, "/usr/local/bin"},
+		{"linux", []string{"a", "", "../b"}, "b"},
+		{"linux", []string{"/srv", "data/"}, "/srv/data/"},
+		{"linux", []string{`a\b`, "c"}, `a\b/c`},
+		{"windows", []string{`C:\Users`, "me/src"}, `C:\Users\me\src`},
+		{"windows", []string{`C:\a`, `..\b`}, `C:\b`},
+		{"windows", []string{`\\srv\share`, "dir"}, `\\srv\share\dir`},
+		{"windows", []string{"a", `b\`}, `a\b\`},
+		{"windows", nil, ""},
+	}
+	for _, tt := range tests {
+		m := new(mock.Machine)
+		m.SetOS(tt.os)
+		got := command.Join(t.Context(), m, tt.elem...)
+		if got != tt.want {
+			t.Errorf("command.Join(%s, %q) = %q, want %q",
+				tt.os, tt.elem, got, tt.want)
+		}
+	}
+}
+
+func TestSlash(t *testing.T) {
+	tests := []struct {
+		os, native, slashed string
+	}{
+		{"linux", "/a/b", "/a/b"},
+		{"windows", `C:\a\b`, "C:/a/b"},
+	}
+	for _, tt := range tests {
+		m, ctx := new(mock.Machine), t.Context()
+		m.SetOS(tt.os)
+		if got := command.ToSlash(ctx, m, tt.native); got != tt.slashed {
+			t.Errorf("command.ToSlash(%s, %q) = %q, want %q",
+				tt.os, tt.native, got, tt.slashed)
+		}
+		if got := command.FromSlash(ctx, m, tt.slashed); got != tt.native {
+			t.Errorf("command.FromSlash(%s, %q) = %q, want %q",
+				tt.os, tt.slashed, got, tt.native)
+		}
+	}
+}
+
+func TestAbs(t *testing.T) {
+	ctx := fs.WithWorkDir(t.Context(), "/work")
+
+	got, err := command.Abs(ctx, mem.Machine(), "src/app")
+	if err != nil {
+		t.Fatalf("command.Abs error: %v", err)
+	}
+	if want := "/work/src/app"; got != want {
+		t.Errorf("command.Abs = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,153 @@
+// Package pathmap translates paths between a host and a machine that
+// sees the host's directories at other paths, such as a container with
+// bind mounts or a remote machine with a synchronized checkout.
+//
+// A machine that declares its mounts, by implementing [Mapper], lets
+// code written for the host run against it unchanged:
+//
+//	m := pathmap.Machine(ctrMachine, pathmap.Mapping{
+//	    Host:   "/home/gopher/src",
+//	    Target: "/work",
+//	})
+//	ctx = fs.WithWorkDir(ctx, "/home/gopher/src/app")
+//	command.Do(ctx, m, "go", "build", "/home/gopher/src/app/cmd/tool")
+//	// Runs go build /work/app/cmd/tool in /work/app.
+package pathmap
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"strings"
+
+	"lesiw.io/command"
+	"lesiw.io/fs"
+)
+
+// A Mapping declares that the directory Host on the host is the
+// directory Target on the machine. Both are slash-separated absolute
+// paths.
+type Mapping struct {
+	Host   string
+	Target string
+}
+
+// A Map is a set of mappings. When mappings nest, the most specific one
+// applies.
+type Map []Mapping
+
+// ToTarget returns the path on the machine of the host path p, or false
+// if p is not under any Host directory.
+func (m Map) ToTarget(p string) (string, bool) {
+	return m.translate(p, false)
+}
+
+// ToHost returns the host path of the path p on the machine, or false if
+// p is not under any Target directory.
+func (m Map) ToHost(p string) (string, bool) {
+	return m.translate(p, true)
+}
+
+func (m Map) translate(p string, toHost bool) (string, bool) {
+	best, bestLen := "", -1
+	for _, mp := range m {
+		from, to := mp.Host, mp.Target
+		if toHost {
+			from, to = to, from
+		}
+		from = strings.TrimSuffix(from, "/")
+		rest, ok := strings.CutPrefix(p, from)
+		if !ok || rest != "" && rest[0] != '/' || len(from) <= bestLen {
+			continue
+		}
+		best, bestLen = strings.TrimSuffix(to, "/")+rest, len(from)
+		if best == "" {
+			best = "/"
+		}
+	}
+	return best, bestLen >= 0
+}
+
+// Args returns a copy of args with host paths translated to the machine.
+// An argument is translated if it is a path under a Host directory, or
+// if it is a flag of the form -name=path with such a path.
+func (m Map) Args(args []string) []string {
+	out := slices.Clone(args)
+	for i, arg := range out {
+		if t, ok := m.ToTarget(arg); ok {
+			out[i] = t
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if flag, val, ok := strings.Cut(arg, "="); ok {
+			if t, ok := m.ToTarget(val); ok {
+				out[i] = flag + "=" + t
+			}
+		}
+	}
+	return out
+}
+
+// A Mapper is a command.Machine that sees directories of the host at
+// other paths.
+type Mapper interface {
+	command.Machine
+
+	// PathMap returns the machine's mappings.
+	PathMap() Map
+}
+
+// Of returns the mappings of m, if it is a Mapper.
+func Of(m command.Machine) Map {
+	if mp, ok := m.(Mapper); ok {
+		return mp.PathMap()
+	}
+	return nil
+}
+
+// ToTarget returns the path on m of the host path p, or p itself if m
+// does not map it.
+func ToTarget(m command.Machine, p string) string {
+	if t, ok := Of(m).ToTarget(p); ok {
+		return t
+	}
+	return p
+}
+
+// ToHost returns the host path of the path p on m, or p itself if m does
+// not map it.
+func ToHost(m command.Machine, p string) string {
+	if h, ok := Of(m).ToHost(p); ok {
+		return h
+	}
+	return p
+}
+
+// Machine returns a Mapper that runs commands on m with host paths in
+// their arguments and working directory translated by mappings, in
+// addition to any of m's own.
+func Machine(m command.Machine, mappings ...Mapping) command.Machine {
+	mm := append(Of(m), mappings...)
+	slices.SortStableFunc(mm, func(a, b Mapping) int {
+		return cmp.Compare(len(b.Host), len(a.Host))
+	})
+	return &machine{m: m, mm: mm}
+}
+
+type machine struct {
+	m  command.Machine
+	mm Map
+}
+
+func (m *machine) PathMap() Map { return slices.Clone(m.mm) }
+
+func (m *machine) Command(
+	ctx context.Context, arg ...string,
+) command.Buffer {
+	if dir, ok := m.mm.ToTarget(fs.WorkDir(ctx)); ok {
+		ctx = fs.WithWorkDir(ctx, dir)
+	}
+	return m.m.Command(ctx, m.mm.Args(arg)...)
+}
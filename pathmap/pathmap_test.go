@@ -0,0 +1,130 @@
+package pathmap_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+	"lesiw.io/command/pathmap"
+	"lesiw.io/fs"
+)
+
+func TestMap(t *testing.T) {
+	mm := pathmap.Map{
+		{Host: "/src", Target: "/work"},
+		{Host: "/src/vendor", Target: "/deps"},
+		{Host: "/data/", Target: "/mnt/data/"},
+	}
+	tests := []struct {
+		path, target string
+		ok           bool
+	}{
+		{"/src", "/work", true},
+		{"/src/app/main.go", "/work/app/main.go", true},
+		{"/src/vendor/x", "/deps/x", true},
+		{"/data/db", "/mnt/data/db", true},
+		{"/srcs/app", "", false},
+		{"/other", "", false},
+		{"src/app", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := mm.ToTarget(tt.path)
+		if got != tt.target || ok != tt.ok {
+			t.Errorf("ToTarget(%q) = %q, %v, want %q, %v",
+				tt.path, got, ok, tt.target, tt.ok)
+		}
+		if !ok {
+			continue
+		}
+		if back, ok := mm.ToHost(got); !ok || back != tt.path {
+			t.Errorf("ToHost(%q) = %q, %v, want %q, true",
+				got, back, ok, tt.path)
+		}
+	}
+}
+
+func TestArgs(t *testing.T) {
+	mm := pathmap.Map{{Host: "/src", Target: "/work"}}
+	args := []string{"go", "test", "-coverprofile=/src/c.out", "/src/...",
+		"/srcs", "-v"}
+
+	got := mm.Args(args)
+
+	want := []string{"go", "test", "-coverprofile=/work/c.out", "/work/...",
+		"/srcs", "-v"}
+	if !cmp.Equal(got, want) {
+		t.Errorf("Args (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	if args[3] != "/src/..." {
+		t.Errorf("Args modified its argument: %q", args)
+	}
+}
+
+func TestMachine(t *testing.T) {
+	m := new(mock.Machine)
+	pm := pathmap.Machine(m, pathmap.Mapping{Host: "/src", Target: "/work"})
+	ctx := fs.WithWorkDir(t.Context(), "/src/app")
+
+	err := command.Do(ctx, pm, "go", "build", "-o", "/src/bin/app", ".")
+	if err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+
+	calls := mock.Calls(m, "go")
+	if len(calls) != 1 {
+		t.Fatalf("got %d calls, want 1", len(calls))
+	}
+	wantArgs := []string{"go", "build", "-o", "/work/bin/app", "."}
+	if got := calls[0].Args; !cmp.Equal(got, wantArgs) {
+		t.Errorf("args (-want +got):\n%s", cmp.Diff(wantArgs, got))
+	}
+	if got, want := calls[0].Dir, "/work/app"; got != want {
+		t.Errorf("dir = %q, want %q", got, want)
+	}
+	got, want := pathmap.ToHost(pm, "/work/bin/app"), "/src/bin/app"
+	if got != want {
+		t.Errorf("ToHost = %q, want %q", got, want)
+	}
+}
+
+func TestMachineUnmapped(t *testing.T) {
+	m := new(mock.Machine)
+	pm := pathmap.Machine(m, pathmap.Mapping{Host: "/src", Target: "/work"})
+	ctx := fs.WithWorkDir(t.Context(), "/tmp")
+
+	if err := command.Do(ctx, pm, "ls", "/etc"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+
+	calls := mock.Calls(m, "ls")
+	if len(calls) != 1 {
+		t.Fatalf("got %d calls, want 1", len(calls))
+	}
+	want := []string{"ls", "/etc"}
+	if got := calls[0].Args; !cmp.Equal(got, want) {
+		t.Errorf("args = %q, want %q", got, want)
+	}
+	if got, want := calls[0].Dir, "/tmp"; got != want {
+		t.Errorf("dir = %q, want %q", got, want)
+	}
+	if got := pathmap.ToTarget(m, "/src"); got != "/src" {
+		t.Errorf("ToTarget on unmapped machine = %q, want %q", got, "/src")
+	}
+}
+
+func TestMachineNested(t *testing.T) {
+	inner := pathmap.Machine(new(mock.Machine),
+		pathmap.Mapping{Host: "/src", Target: "/work"})
+	outer := pathmap.Machine(inner,
+		pathmap.Mapping{Host: "/src/cache", Target: "/cache"})
+
+	want := pathmap.Map{
+		{Host: "/src/cache", Target: "/cache"},
+		{Host: "/src", Target: "/work"},
+	}
+	if got := pathmap.Of(outer); !cmp.Equal(got, want) {
+		t.Errorf("Of (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
@@ -0,0 +1,216 @@
+// Package pkg installs system packages on a command.Machine with the
+// package manager found there.
+//
+// Provisioning code usually needs a handful of packages and should not
+// care whether the target runs Debian, Fedora, Alpine, macOS, or
+// Windows. [Install] detects the package manager and installs only the
+// packages that are missing, so it is safe to call repeatedly.
+//
+//	if err := pkg.Install(ctx, m, "git", "make"); err != nil {
+//	    log.Fatal(err)
+//	}
+//
+// Package names are passed to the package manager unchanged; they are
+// not translated between distributions. Installing usually requires
+// privileges: run as root, or wrap m, as in sub.Machine(m, "sudo").
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"lesiw.io/command"
+)
+
+// ErrNoManager is returned when no supported package manager is found.
+var ErrNoManager = errors.New("pkg: no supported package manager found")
+
+type manager struct {
+	name string
+	os   string // Empty for any Unix.
+
+	// tool is the executable of the manager, which is present if tool
+	// is.
+	tool string
+
+	// query runs successfully if the package is installed. If listed
+	// is set, it also succeeds for packages that are not installed, and
+	// prints nothing for them.
+	query  func(pkg string) []string
+	listed bool
+
+	// install installs pkgs. If update is set, it runs first if
+	// install fails, to refresh stale package indexes.
+	install func(pkgs ...string) []string
+	update  []string
+	env     map[string]string
+
+	// each is set if install takes one package per invocation.
+	each bool
+}
+
+var managers = []manager{{
+	name:  "apt",
+	tool:  "apt-get",
+	query: func(p string) []string { return []string{"dpkg", "-s", p} },
+	install: func(p ...string) []string {
+		return append([]string{
+			"apt-get", "install", "-y", "--no-install-recommends",
+		}, p...)
+	},
+	update: []string{"apt-get", "update"},
+	env:    map[string]string{"DEBIAN_FRONTEND": "noninteractive"},
+}, {
+	name:  "dnf",
+	tool:  "dnf",
+	query: func(p string) []string { return []string{"rpm", "-q", p} },
+	install: func(p ...string) []string {
+		return append([]string{"dnf", "install", "-y"}, p...)
+	},
+}, {
+	name: "apk",
+	tool: "apk",
+	query: func(p string) []string {
+		return []string{"apk", "info", "-e", p}
+	},
+	install: func(p ...string) []string {
+		return append([]string{"apk", "add", "--no-cache"}, p...)
+	},
+}, {
+	name: "brew",
+	tool: "brew",
+	query: func(p string) []string {
+		return []string{"brew", "list", "--versions", p}
+	},
+	install: func(p ...string) []string {
+		return append([]string{"brew", "install"}, p...)
+	},
+	env: map[string]string{"HOMEBREW_NO_AUTO_UPDATE": "1"},
+}, {
+	name: "winget",
+	os:   "windows",
+	tool: "winget",
+	query: func(p string) []string {
+		return []string{"winget", "list", "--exact", "--id", p}
+	},
+	install: func(p ...string) []string {
+		return append([]string{
+			"winget", "install", "--exact", "--silent",
+			"--accept-package-agreements", "--accept-source-agreements",
+			"--id",
+		}, p...)
+	},
+	each: true,
+}, {
+	name: "choco",
+	os:   "windows",
+	tool: "choco",
+	query: func(p string) []string {
+		return []string{"choco", "list", "--exact", "--limit-output", p}
+	},
+	listed: true,
+	install: func(p ...string) []string {
+		return append([]string{"choco", "install", "-y"}, p...)
+	},
+}}
+
+// Detect returns the name of the package manager on m: one of apt, dnf,
+// apk, brew, winget, or choco. It returns [ErrNoManager] if m has none
+// of them.
+func Detect(ctx context.Context, m command.Machine) (string, error) {
+	mgr, err := detect(ctx, command.Unshell(m))
+	if err != nil {
+		return "", err
+	}
+	return mgr.name, nil
+}
+
+func detect(ctx context.Context, m command.Machine) (*manager, error) {
+	ctx = command.WithoutCommandOptions(ctx)
+	windows := command.OS(ctx, m) == "windows"
+	for i := range managers {
+		mgr := &managers[i]
+		if (mgr.os == "windows") != windows {
+			continue
+		}
+		if present(ctx, m, mgr.tool, windows) {
+			return mgr, nil
+		}
+	}
+	return nil, ErrNoManager
+}
+
+// present reports whether the executable tool is on m. On Unix, it asks
+// the shell with command -v; on Windows, which may lack a shell, it runs
+// tool --version, and counts tool as present unless it is
+// [command.Missing].
+func present(
+	ctx context.Context, m command.Machine, tool string, windows bool,
+) bool {
+	if windows {
+		return !command.Missing(command.Do(ctx, m, tool, "--version"))
+	}
+	return command.Do(ctx, m,
+		"sh", "-c", `command -v "$1" >/dev/null`, "sh", tool) == nil
+}
+
+// Install installs the packages pkgs on m with the package manager found
+// there. Packages that are already installed are skipped, and if all of
+// them are, Install runs no install command at all.
+//
+// The probes for the package manager and packages run without the
+// command options of ctx, such as [command.WithAllowedExitCodes], so
+// those options apply only to the install command.
+func Install(ctx context.Context, m command.Machine, pkgs ...string) error {
+	m = command.Unshell(m)
+	mgr, err := detect(ctx, m)
+	if err != nil {
+		return err
+	}
+	var (
+		qctx    = command.WithoutCommandOptions(ctx)
+		missing []string
+	)
+	for _, p := range pkgs {
+		if !mgr.installed(qctx, m, p) {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	if len(mgr.env) > 0 {
+		ctx = command.WithEnv(ctx, mgr.env)
+	}
+	if mgr.each {
+		for _, p := range missing {
+			if err := command.Do(ctx, m, mgr.install(p)...); err != nil {
+				return fmt.Errorf("pkg: %s install %s: %w", mgr.name, p, err)
+			}
+		}
+		return nil
+	}
+	err = command.Do(ctx, m, mgr.install(missing...)...)
+	if err != nil && mgr.update != nil {
+		if uerr := command.Do(ctx, m, mgr.update...); uerr == nil {
+			err = command.Do(ctx, m, mgr.install(missing...)...)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("pkg: %s install: %w", mgr.name, err)
+	}
+	return nil
+}
+
+// installed reports whether the package p is installed on m.
+func (mgr *manager) installed(
+	ctx context.Context, m command.Machine, p string,
+) bool {
+	if !mgr.listed {
+		return command.Do(ctx, m, mgr.query(p)...) == nil
+	}
+	out, err := command.Read(ctx, m, mgr.query(p)...)
+	return err == nil && strings.TrimSpace(out) != ""
+}
@@ -0,0 +1,165 @@
+package pkg_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+	"lesiw.io/command/pkg"
+)
+
+// notFound makes the executable name absent from m: command -v fails to
+// find it, and running it fails to start.
+func notFound(m *mock.Machine, name string) {
+	m.Return(command.Fail(&command.Error{Code: 1}),
+		"sh", "-c", `command -v "$1" >/dev/null`, "sh", name)
+	m.Return(command.Fail(&command.Error{
+		Err: fmt.Errorf("command not found: %s", name),
+	}), name)
+}
+
+func TestInstallApt(t *testing.T) {
+	m := new(mock.Machine)
+	m.SetOS("linux")
+	m.Return(command.Fail(&command.Error{Code: 1}), "dpkg", "-s", "git")
+
+	if err := pkg.Install(t.Context(), m, "git", "make"); err != nil {
+		t.Fatalf("pkg.Install error: %v", err)
+	}
+
+	want := []mock.Call{{
+		Args: []string{
+			"apt-get", "install", "-y", "--no-install-recommends", "git",
+		},
+		Env: map[string]string{"DEBIAN_FRONTEND": "noninteractive"},
+	}}
+	if got := mock.Calls(m, "apt-get", "install"); !cmp.Equal(got, want) {
+		t.Errorf("install calls (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestInstallAlreadyInstalled(t *testing.T) {
+	m := new(mock.Machine)
+	m.SetOS("linux")
+
+	if err := pkg.Install(t.Context(), m, "git"); err != nil {
+		t.Fatalf("pkg.Install error: %v", err)
+	}
+
+	if got := mock.Calls(m, "apt-get", "install"); len(got) > 0 {
+		t.Errorf("install calls: got %v, want none", got)
+	}
+}
+
+func TestInstallUpdatesAptIndex(t *testing.T) {
+	m := new(mock.Machine)
+	m.SetOS("linux")
+	m.Return(command.Fail(&command.Error{Code: 1}), "dpkg")
+	m.Return(command.Fail(&command.Error{Code: 100}), "apt-get", "install")
+	m.Return(strings.NewReader(""), "apt-get", "install")
+
+	if err := pkg.Install(t.Context(), m, "git"); err != nil {
+		t.Fatalf("pkg.Install error: %v", err)
+	}
+
+	if got := mock.Calls(m, "apt-get", "update"); len(got) != 1 {
+		t.Errorf("update calls: got %v, want 1", got)
+	}
+}
+
+func TestInstallIgnoresAllowedExitCodes(t *testing.T) {
+	m := new(mock.Machine)
+	m.SetOS("linux")
+	m.Return(command.Fail(&command.Error{Code: 1}), "dpkg", "-s", "git")
+	ctx := command.WithAllowedExitCodes(t.Context(), 1)
+
+	if err := pkg.Install(ctx, m, "git"); err != nil {
+		t.Fatalf("pkg.Install error: %v", err)
+	}
+
+	if got := mock.Calls(m, "apt-get", "install"); len(got) != 1 {
+		t.Errorf("install calls: got %v, want 1", got)
+	}
+}
+
+func TestInstallChoco(t *testing.T) {
+	m := new(mock.Machine)
+	m.SetOS("windows")
+	notFound(m, "winget")
+	// choco list succeeds whether or not a package is installed, and
+	// prints nothing for packages that are not.
+	m.Return(strings.NewReader("git|2.43.0\n"),
+		"choco", "list", "--exact", "--limit-output", "git")
+	m.Return(strings.NewReader(""),
+		"choco", "list", "--exact", "--limit-output", "make")
+
+	if err := pkg.Install(t.Context(), m, "git", "make"); err != nil {
+		t.Fatalf("pkg.Install error: %v", err)
+	}
+
+	want := []mock.Call{{Args: []string{"choco", "install", "-y", "make"}}}
+	if got := mock.Calls(m, "choco", "install"); !cmp.Equal(got, want) {
+		t.Errorf("install calls (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestDetect(t *testing.T) {
+	m := new(mock.Machine)
+	m.SetOS("linux")
+	notFound(m, "apt-get")
+	notFound(m, "dnf")
+
+	got, err := pkg.Detect(t.Context(), m)
+	if err != nil {
+		t.Fatalf("pkg.Detect error: %v", err)
+	}
+	if want := "apk"; got != want {
+		t.Errorf("pkg.Detect = %q, want %q", got, want)
+	}
+}
+
+func TestDetectWindows(t *testing.T) {
+	m := new(mock.Machine)
+	m.SetOS("windows")
+	notFound(m, "winget")
+
+	got, err := pkg.Detect(t.Context(), m)
+	if err != nil {
+		t.Fatalf("pkg.Detect error: %v", err)
+	}
+	if want := "choco"; got != want {
+		t.Errorf("pkg.Detect = %q, want %q", got, want)
+	}
+}
+
+func TestDetectWindowsExit127(t *testing.T) {
+	m := new(mock.Machine)
+	m.SetOS("windows")
+	m.Return(command.Fail(&command.Error{Code: 127}), "winget")
+
+	got, err := pkg.Detect(t.Context(), m)
+	if err != nil {
+		t.Fatalf("pkg.Detect error: %v", err)
+	}
+	if want := "choco"; got != want {
+		t.Errorf("pkg.Detect = %q, want %q", got, want)
+	}
+}
+
+func TestDetectNoManager(t *testing.T) {
+	m := new(mock.Machine)
+	m.SetOS("linux")
+	for _, name := range []string{"apt-get", "dnf", "apk", "brew"} {
+		notFound(m, name)
+	}
+
+	_, err := pkg.Detect(t.Context(), m)
+	if !errors.Is(err, pkg.ErrNoManager) {
+		t.Errorf("pkg.Detect error: got %v, want ErrNoManager", err)
+	}
+}
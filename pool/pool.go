@@ -0,0 +1,159 @@
+// Package pool implements a command.Machine that distributes commands
+// across a set of machines.
+//
+// Each command runs on exactly one machine of the pool, chosen by a
+// [Strategy] when the command is created. This suits independent units
+// of work, such as build or test shards, spread across a set of
+// equivalent workers.
+//
+//	workers := pool.New([]command.Machine{
+//	    ssh.Machine(sys.Machine(), "ssh", "build1"),
+//	    ssh.Machine(sys.Machine(), "ssh", "build2"),
+//	}, pool.Balance(pool.LeastLoaded()))
+package pool
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"lesiw.io/command"
+)
+
+// Machine returns a command.Machine that runs each command on one of ms,
+// chosen round-robin.
+func Machine(ms ...command.Machine) command.Machine { return New(ms) }
+
+// New returns a command.Machine that runs each command on one of ms.
+//
+// Without options, machines are chosen round-robin.
+func New(ms []command.Machine, opt ...Option) command.Machine {
+	m := &machine{
+		ms:       append([]command.Machine(nil), ms...),
+		inflight: make([]int, len(ms)),
+		strategy: RoundRobin(),
+	}
+	for _, o := range opt {
+		o(m)
+	}
+	return m
+}
+
+// An Option configures a pool created with [New].
+type Option func(*machine)
+
+// Balance sets the strategy used to choose a machine for each command.
+func Balance(s Strategy) Option {
+	return func(m *machine) { m.strategy = s }
+}
+
+type machine struct {
+	sync.Mutex
+	ms       []command.Machine
+	inflight []int
+	strategy Strategy
+}
+
+func (m *machine) Command(ctx context.Context, arg ...string) command.Buffer {
+	if len(m.ms) == 0 {
+		return command.Fail(errors.New("pool: no machines"))
+	}
+
+	m.Lock()
+	i := m.strategy.Pick(m.inflight)
+	if i < 0 || i >= len(m.ms) {
+		m.Unlock()
+		return command.Fail(fmt.Errorf("pool: strategy picked %d of %d",
+			i, len(m.ms)))
+	}
+	m.inflight[i]++
+	m.Unlock()
+
+	c := &cmd{
+		Buffer: m.ms[i].Command(ctx, arg...),
+		done:   sync.OnceFunc(func() { m.release(i) }),
+	}
+	if _, ok := c.Buffer.(command.WriteBuffer); ok {
+		return &writeCmd{c}
+	}
+	return c
+}
+
+func (m *machine) release(i int) {
+	m.Lock()
+	defer m.Unlock()
+	m.inflight[i]--
+}
+
+var _ command.ShutdownMachine = (*machine)(nil)
+
+// Shutdown shuts down every machine in the pool.
+func (m *machine) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, pm := range m.ms {
+		errs = append(errs, command.Shutdown(ctx, pm))
+	}
+	return errors.Join(errs...)
+}
+
+// cmd tracks a command as in flight until it is read to completion, or
+// until it is closed without having started.
+type cmd struct {
+	command.Buffer
+	done    func()
+	started atomic.Bool
+}
+
+func (c *cmd) Read(p []byte) (int, error) {
+	c.started.Store(true)
+	n, err := c.Buffer.Read(p)
+	if err != nil {
+		c.done()
+	}
+	return n, err
+}
+
+// Close closes the command's input. A command that has started is still
+// running, so it stays in flight until its output is read.
+func (c *cmd) Close() error {
+	if !c.started.Load() {
+		defer c.done()
+	}
+	if closer, ok := c.Buffer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Attach attaches the command to the terminal. It stays in flight until
+// its output is read, as other commands do.
+func (c *cmd) Attach() error {
+	c.started.Store(true)
+	return command.Attach(c.Buffer)
+}
+
+func (c *cmd) Log(w io.Writer) { command.Log(c.Buffer, w) }
+func (c *cmd) String() string  { return command.String(c.Buffer) }
+
+func (c *cmd) Result() (command.Result, bool) {
+	return command.ResultOf(c.Buffer)
+}
+
+func (c *cmd) SpliceFrom(src io.Reader) bool {
+	return command.SpliceFrom(c.Buffer, src)
+}
+
+func (c *cmd) SpliceTo(dst io.Writer) bool {
+	return command.SpliceTo(c.Buffer, dst)
+}
+
+// A writeCmd is a cmd whose command takes input.
+type writeCmd struct{ *cmd }
+
+func (c *writeCmd) Write(p []byte) (int, error) {
+	c.started.Store(true)
+	return c.Buffer.(command.WriteBuffer).Write(p)
+}
@@ -0,0 +1,227 @@
+package pool_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+	"lesiw.io/command/pool"
+)
+
+func mocks(n int) (ms []command.Machine, mm []*mock.Machine) {
+	for range n {
+		m := new(mock.Machine)
+		ms = append(ms, m)
+		mm = append(mm, m)
+	}
+	return
+}
+
+func TestMachineRoundRobin(t *testing.T) {
+	ms, mm := mocks(3)
+	p := pool.Machine(ms...)
+
+	for range 6 {
+		if err := command.Do(t.Context(), p, "true"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, m := range mm {
+		if got, want := len(m.Calls), 2; got != want {
+			t.Errorf("machine %d: got %d calls, want %d", i, got, want)
+		}
+	}
+}
+
+func TestMachineLeastLoaded(t *testing.T) {
+	ms, mm := mocks(2)
+	p := pool.New(ms, pool.Balance(pool.LeastLoaded()))
+
+	// Hold a command open on the first machine.
+	busy := p.Command(t.Context(), "sleep")
+	for range 3 {
+		if err := command.Do(t.Context(), p, "true"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := io.Copy(io.Discard, busy); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(mock.Calls(mm[0], "true")), 0; got != want {
+		t.Errorf("busy machine: got %d calls, want %d", got, want)
+	}
+	if got, want := len(mock.Calls(mm[1], "true")), 3; got != want {
+		t.Errorf("idle machine: got %d calls, want %d", got, want)
+	}
+}
+
+func TestMachineCloseReleases(t *testing.T) {
+	ms, mm := mocks(2)
+	p := pool.New(ms, pool.Balance(pool.LeastLoaded()))
+
+	// A command closed without being started is no longer in flight, so
+	// its machine is as idle as the other.
+	closed := p.Command(t.Context(), "sleep")
+	if err := closed.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range 4 {
+		if err := command.Do(t.Context(), p, "true"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i, m := range mm {
+		if got, want := len(mock.Calls(m, "true")), 2; got != want {
+			t.Errorf("machine %d: got %d calls, want %d", i, got, want)
+		}
+	}
+}
+
+func TestMachineCloseInputKeepsInFlight(t *testing.T) {
+	ms, mm := mocks(2)
+	p := pool.New(ms, pool.Balance(pool.LeastLoaded()))
+
+	// A command whose input is closed is still running until its output
+	// is read, so it keeps its machine busy.
+	busy := p.Command(t.Context(), "cat")
+	if _, err := busy.(io.Writer).Write([]byte("hello\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := busy.(io.Closer).Close(); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		if err := command.Do(t.Context(), p, "true"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := io.Copy(io.Discard, busy); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(mock.Calls(mm[0], "true")), 0; got != want {
+		t.Errorf("busy machine: got %d calls, want %d", got, want)
+	}
+	if got, want := len(mock.Calls(mm[1], "true")), 3; got != want {
+		t.Errorf("idle machine: got %d calls, want %d", got, want)
+	}
+}
+
+func TestMachineAttachKeepsInFlight(t *testing.T) {
+	ms, mm := mocks(2)
+	p := pool.New(ms, pool.Balance(pool.LeastLoaded()))
+
+	// An attached command runs until its output is read, as Exec reads
+	// it, so it keeps its machine busy.
+	busy := p.Command(t.Context(), "sleep")
+	if err := command.Attach(busy); err != nil {
+		t.Fatal(err)
+	}
+	for range 4 {
+		if err := command.Do(t.Context(), p, "true"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := io.Copy(io.Discard, busy); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := len(mock.Calls(mm[0], "true")), 0; got != want {
+		t.Errorf("busy machine: got %d calls, want %d", got, want)
+	}
+	if got, want := len(mock.Calls(mm[1], "true")), 4; got != want {
+		t.Errorf("idle machine: got %d calls, want %d", got, want)
+	}
+}
+
+func TestMachineReadOnly(t *testing.T) {
+	m := new(mock.Machine)
+	m.Do(func(context.Context, ...string) command.Buffer {
+		return strings.NewReader("hello\n")
+	}, "echo")
+	p := pool.Machine(m)
+
+	buf := p.Command(t.Context(), "echo")
+
+	if _, ok := buf.(command.WriteBuffer); ok {
+		t.Error("read-only command: got WriteBuffer, want Buffer")
+	}
+	if _, ok := p.Command(t.Context(), "cat").(command.WriteBuffer); !ok {
+		t.Error("writable command: got Buffer, want WriteBuffer")
+	}
+}
+
+func TestMachineWeighted(t *testing.T) {
+	ms, mm := mocks(2)
+	p := pool.New(ms, pool.Balance(pool.Weighted(3, 1)))
+
+	for range 8 {
+		if err := command.Do(t.Context(), p, "true"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := len(mm[0].Calls), 6; got != want {
+		t.Errorf("machine 0: got %d calls, want %d", got, want)
+	}
+	if got, want := len(mm[1].Calls), 2; got != want {
+		t.Errorf("machine 1: got %d calls, want %d", got, want)
+	}
+}
+
+func TestMachinePreservesOutput(t *testing.T) {
+	ms, mm := mocks(1)
+	mm[0].Return(strings.NewReader("hello\n"), "echo")
+
+	out, err := command.Read(t.Context(), pool.Machine(ms...), "echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "hello"; out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+func TestMachineEmpty(t *testing.T) {
+	if err := command.Do(t.Context(), pool.Machine(), "true"); err == nil {
+		t.Error("command.Do on empty pool: got nil, want error")
+	}
+}
+
+func TestMachineBadStrategy(t *testing.T) {
+	ms, _ := mocks(1)
+	p := pool.New(ms, pool.Balance(pool.StrategyFunc(func([]int) int {
+		return 5
+	})))
+
+	if err := command.Do(t.Context(), p, "true"); err == nil {
+		t.Error("command.Do: got nil, want error")
+	}
+}
+
+func TestMachineShutdown(t *testing.T) {
+	errA, errB := errors.New("a"), errors.New("b")
+	p := pool.Machine(shutdownMachine{errA}, shutdownMachine{errB})
+
+	err := command.Shutdown(t.Context(), p)
+	if !errors.Is(err, errA) || !errors.Is(err, errB) {
+		t.Errorf("command.Shutdown: got %v, want %v and %v", err, errA, errB)
+	}
+}
+
+type shutdownMachine struct{ err error }
+
+func (shutdownMachine) Command(
+	context.Context, ...string,
+) command.Buffer {
+	return strings.NewReader("")
+}
+
+func (m shutdownMachine) Shutdown(context.Context) error { return m.err }
@@ -0,0 +1,176 @@
+package pool
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"lesiw.io/command"
+)
+
+// A Report summarizes a command run on every machine of a fleet by
+// [Each].
+type Report struct {
+	Args     []string
+	Start    time.Time
+	Duration time.Duration
+	Results  []Result // Sorted by machine name.
+}
+
+// A Result is the outcome of a command on one machine.
+type Result struct {
+	Machine  string
+	Status   string // "ok" or "failed".
+	Error    string `json:",omitempty"`
+	Duration time.Duration
+
+	// Output is the path of the file holding the command's output and
+	// diagnostic output, if it was saved.
+	Output string `json:",omitempty"`
+}
+
+// Each runs a command on every machine in ms at once, and reports the
+// outcome on each, by the name it has in ms. If dir is not empty, the
+// output of each machine is saved to a file named after it in dir.
+//
+// The error joins the errors of the machines that failed. The report is
+// complete either way.
+//
+//	rep, err := pool.Each(ctx, hosts, "logs", "apt-get", "upgrade", "-y")
+//	_ = rep.WriteText(os.Stdout)
+func Each(
+	ctx context.Context, ms map[string]command.Machine, dir string,
+	args ...string,
+) (*Report, error) {
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+	rep := &Report{
+		Args:    slices.Clone(args),
+		Start:   time.Now(),
+		Results: make([]Result, len(ms)),
+	}
+	names := slices.Sorted(maps.Keys(ms))
+	errs := make([]error, len(ms))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rep.Results[i], errs[i] = each(ctx, ms[name], name, dir, args)
+		}()
+	}
+	wg.Wait()
+	rep.Duration = time.Since(rep.Start)
+	return rep, errors.Join(errs...)
+}
+
+func each(
+	ctx context.Context, m command.Machine, name, dir string, args []string,
+) (Result, error) {
+	r := Result{Machine: name, Status: "ok"}
+	spec := command.Spec{Args: args}
+	if dir != "" {
+		r.Output = filepath.Join(dir, filepath.Base(name)+".log")
+		f, err := os.Create(r.Output)
+		if err != nil {
+			r.Status, r.Error = "failed", err.Error()
+			return r, fmt.Errorf("%s: %w", name, err)
+		}
+		defer f.Close()
+		ctx = command.WithMergedStderr(ctx, true)
+		spec.Stdout, spec.Stderr = f, f
+	}
+	start := time.Now()
+	err := command.Run(ctx, m, spec)
+	r.Duration = time.Since(start)
+	if err != nil {
+		r.Status, r.Error = "failed", err.Error()
+		return r, fmt.Errorf("%s: %w", name, err)
+	}
+	return r, nil
+}
+
+// Failed returns the number of machines on which the command failed.
+func (r *Report) Failed() int {
+	var n int
+	for _, res := range r.Results {
+		if res.Status != "ok" {
+			n++
+		}
+	}
+	return n
+}
+
+// WriteText writes the report to w as a table.
+func (r *Report) WriteText(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "MACHINE\tSTATUS\tDURATION\tOUTPUT")
+	for _, res := range r.Results {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", res.Machine, res.Status,
+			res.Duration.Round(time.Millisecond), res.Output)
+	}
+	_, _ = fmt.Fprintf(tw, "\n%d of %d failed in %s: %s\n",
+		r.Failed(), len(r.Results), r.Duration.Round(time.Millisecond),
+		strings.Join(r.Args, " "))
+	return tw.Flush()
+}
+
+// WriteJSON writes the report to w as JSON.
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "\t")
+	return enc.Encode(r)
+}
+
+var reportHTML = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Command}}</title></head>
+<body>
+<h1>{{.Command}}</h1>
+<p>{{.Failed}} of {{len .Results}} failed in {{.Duration}}.</p>
+<table>
+<tr><th>Machine</th><th>Status</th><th>Duration</th><th>Output</th></tr>
+{{- range .Results}}
+<tr><td>{{.Machine}}</td><td title="{{.Error}}">{{.Status}}</td>
+<td>{{.Duration}}</td><td>{{if .Output}}<a href="{{.Output}}">{{.Output}}</a>
+{{- end}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+// WriteHTML writes the report to w as an HTML page. Output files are
+// linked by their paths.
+func (r *Report) WriteHTML(w io.Writer) error {
+	results := slices.Clone(r.Results)
+	for i := range results {
+		results[i].Duration = results[i].Duration.Round(time.Millisecond)
+		results[i].Output = filepath.ToSlash(results[i].Output)
+	}
+	return reportHTML.Execute(w, struct {
+		Command  string
+		Failed   int
+		Duration time.Duration
+		Results  []Result
+	}{
+		Command:  strings.Join(r.Args, " "),
+		Failed:   r.Failed(),
+		Duration: r.Duration.Round(time.Millisecond),
+		Results:  results,
+	})
+}
@@ -0,0 +1,92 @@
+package pool_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+	"lesiw.io/command/pool"
+)
+
+func TestEach(t *testing.T) {
+	ok, bad := new(mock.Machine), new(mock.Machine)
+	ok.Return(strings.NewReader("upgraded\n"), "upgrade")
+	bad.Return(command.Fail(&command.Error{Code: 1}), "upgrade")
+	dir := t.TempDir()
+
+	rep, err := pool.Each(t.Context(), map[string]command.Machine{
+		"web1": ok,
+		"db1":  bad,
+	}, dir, "upgrade")
+	if err == nil || !strings.Contains(err.Error(), "db1") {
+		t.Errorf("pool.Each error: got %v, want db1 failure", err)
+	}
+
+	if got := rep.Failed(); got != 1 {
+		t.Errorf("Failed() = %d, want 1", got)
+	}
+	if len(rep.Results) != 2 {
+		t.Fatalf("got %d results, want 2", len(rep.Results))
+	}
+	db, web := rep.Results[0], rep.Results[1]
+	if db.Machine != "db1" || db.Status != "failed" {
+		t.Errorf("Results[0] = %+v, want db1 failed", db)
+	}
+	if web.Machine != "web1" || web.Status != "ok" {
+		t.Errorf("Results[1] = %+v, want web1 ok", web)
+	}
+	if want := filepath.Join(dir, "web1.log"); web.Output != want {
+		t.Errorf("web1 Output = %q, want %q", web.Output, want)
+	}
+	out, err := os.ReadFile(web.Output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "upgraded\n" {
+		t.Errorf("web1 output = %q, want %q", out, "upgraded\n")
+	}
+}
+
+func TestReportFormats(t *testing.T) {
+	ok := new(mock.Machine)
+	rep, err := pool.Each(t.Context(), map[string]command.Machine{
+		"web1": ok,
+	}, "", "uptime")
+	if err != nil {
+		t.Fatalf("pool.Each error: %v", err)
+	}
+
+	var text bytes.Buffer
+	if err := rep.WriteText(&text); err != nil {
+		t.Fatalf("WriteText error: %v", err)
+	}
+	if !strings.Contains(text.String(), "web1") ||
+		!strings.Contains(text.String(), "0 of 1 failed") {
+		t.Errorf("WriteText:\n%s", text.String())
+	}
+
+	var js bytes.Buffer
+	if err := rep.WriteJSON(&js); err != nil {
+		t.Fatalf("WriteJSON error: %v", err)
+	}
+	var got pool.Report
+	if err := json.Unmarshal(js.Bytes(), &got); err != nil {
+		t.Fatalf("bad JSON: %v", err)
+	}
+	if len(got.Results) != 1 || got.Results[0].Machine != "web1" {
+		t.Errorf("JSON results = %+v, want web1", got.Results)
+	}
+
+	var html bytes.Buffer
+	if err := rep.WriteHTML(&html); err != nil {
+		t.Fatalf("WriteHTML error: %v", err)
+	}
+	if !strings.Contains(html.String(), "<td>web1</td>") {
+		t.Errorf("WriteHTML:\n%s", html.String())
+	}
+}
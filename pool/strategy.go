@@ -0,0 +1,78 @@
+package pool
+
+// A Strategy chooses the machine that runs the next command.
+//
+// Pick is given the number of commands in flight on each machine, in
+// the order the machines were given to [New], and returns the index of
+// the chosen machine. A command is in flight from its creation until it
+// is read to completion or closed.
+//
+// Pick is never called concurrently for the same pool, so strategies
+// may keep state without further synchronization.
+type Strategy interface {
+	Pick(inflight []int) int
+}
+
+// StrategyFunc is an adapter to allow ordinary functions to be used as
+// strategies.
+type StrategyFunc func(inflight []int) int
+
+// Pick implements the Strategy interface.
+func (f StrategyFunc) Pick(inflight []int) int { return f(inflight) }
+
+// RoundRobin returns a Strategy that chooses each machine in turn.
+func RoundRobin() Strategy {
+	var next int
+	return StrategyFunc(func(inflight []int) int {
+		i := next % len(inflight)
+		next = i + 1
+		return i
+	})
+}
+
+// LeastLoaded returns a Strategy that chooses the machine with the
+// fewest commands in flight. Ties go to the machine that was chosen
+// least recently, so idle machines share work evenly.
+func LeastLoaded() Strategy {
+	var next int
+	return StrategyFunc(func(inflight []int) int {
+		best := -1
+		for j := range inflight {
+			i := (next + j) % len(inflight)
+			if best < 0 || inflight[i] < inflight[best] {
+				best = i
+			}
+		}
+		next = best + 1
+		return best
+	})
+}
+
+// Weighted returns a Strategy that chooses machines in proportion to
+// weight, using smooth weighted round-robin so that heavier machines
+// are interleaved with lighter ones rather than chosen in bursts.
+//
+// The weight at index i applies to machine i. Machines without a
+// weight, or with a weight less than 1, have a weight of 1.
+func Weighted(weight ...int) Strategy {
+	var current []int
+	return StrategyFunc(func(inflight []int) int {
+		if len(current) != len(inflight) {
+			current = make([]int, len(inflight))
+		}
+		var total, best int
+		for i := range current {
+			w := 1
+			if i < len(weight) && weight[i] > 1 {
+				w = weight[i]
+			}
+			current[i] += w
+			total += w
+			if current[i] > current[best] {
+				best = i
+			}
+		}
+		current[best] -= total
+		return best
+	})
+}
@@ -0,0 +1,46 @@
+package pool
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func picks(s Strategy, inflight []int, n int) (got []int) {
+	for range n {
+		got = append(got, s.Pick(inflight))
+	}
+	return
+}
+
+func TestRoundRobin(t *testing.T) {
+	got := picks(RoundRobin(), make([]int, 3), 7)
+	want := []int{0, 1, 2, 0, 1, 2, 0}
+	if !cmp.Equal(want, got) {
+		t.Errorf("picks (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestLeastLoadedTies(t *testing.T) {
+	got := picks(LeastLoaded(), []int{1, 0, 0}, 4)
+	want := []int{1, 2, 1, 2}
+	if !cmp.Equal(want, got) {
+		t.Errorf("picks (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestWeightedInterleaves(t *testing.T) {
+	got := picks(Weighted(5, 1, 1), make([]int, 3), 7)
+	want := []int{0, 0, 1, 0, 2, 0, 0}
+	if !cmp.Equal(want, got) {
+		t.Errorf("picks (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestWeightedDefaults(t *testing.T) {
+	got := picks(Weighted(), make([]int, 2), 4)
+	want := []int{0, 1, 0, 1}
+	if !cmp.Equal(want, got) {
+		t.Errorf("picks (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
@@ -0,0 +1,82 @@
+package command
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// maxSpare is the largest buffer a prefixWriter keeps for reuse.
+const maxSpare = 64 << 10
+
+// A prefixWriter writes a prefix at the start of each line written to it.
+//
+// It is built for trace output, which may come from many goroutines at
+// once: prefixed output is assembled in reused buffers rather than
+// allocated per line or per write, and writes that arrive while another
+// is in progress are batched into the next write to the underlying
+// writer instead of each waiting their turn to make a small one.
+type prefixWriter struct {
+	prefix []byte
+	w      io.Writer
+
+	mu      sync.Mutex
+	bol     bool   // Whether the next byte begins a line.
+	pending []byte // Output not yet written to w.
+	spare   []byte // An empty buffer to swap with pending.
+	busy    bool   // Whether a Write is writing to w.
+	err     error  // From the last write to w.
+}
+
+func newPrefixWriter(prefix string, w io.Writer) *prefixWriter {
+	return &prefixWriter{prefix: []byte(prefix), w: w, bol: true}
+}
+
+func (pw *prefixWriter) Write(p []byte) (int, error) {
+	pw.mu.Lock()
+	pw.pending = pw.appendPrefixed(pw.pending, p)
+	if pw.busy {
+		// The Write in progress writes these bytes when it finishes its
+		// own, along with any others that arrive meanwhile.
+		err := pw.err
+		pw.mu.Unlock()
+		return len(p), err
+	}
+	pw.busy = true
+	for len(pw.pending) > 0 {
+		out := pw.pending
+		pw.pending = pw.spare[:0]
+		pw.mu.Unlock()
+		_, err := pw.w.Write(out)
+		pw.mu.Lock()
+		pw.err = err
+		if cap(out) <= maxSpare {
+			pw.spare = out
+		} else {
+			pw.spare = nil
+		}
+	}
+	pw.busy = false
+	err := pw.err
+	pw.mu.Unlock()
+	return len(p), err
+}
+
+// appendPrefixed appends p to buf, with the prefix at the start of each
+// line. pw.mu must be held.
+func (pw *prefixWriter) appendPrefixed(buf, p []byte) []byte {
+	for len(p) > 0 {
+		if pw.bol {
+			buf = append(buf, pw.prefix...)
+			pw.bol = false
+		}
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			return append(buf, p...)
+		}
+		buf = append(buf, p[:i+1]...)
+		p = p[i+1:]
+		pw.bol = true
+	}
+	return buf
+}
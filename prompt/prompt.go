@@ -0,0 +1,202 @@
+// Package prompt answers the password prompts of commands run on a
+// command.Machine, without giving them a terminal.
+//
+// Tools such as sudo -S ask for a password on their diagnostic output
+// and read it from their input. [Machine] watches for such prompts and
+// answers them with a [Prompter], which may ask the user at the terminal
+// or fetch the password from anywhere else:
+//
+//	sudo := prompt.Machine(
+//	    sub.Machine(sys.Machine(), "sudo", "-S"),
+//	    prompt.Terminal(),
+//	)
+//	err := command.Do(ctx, sudo, "apt-get", "update")
+package prompt
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+
+	"lesiw.io/command"
+)
+
+// A Prompter answers a prompt, usually for a password.
+type Prompter interface {
+	Prompt(ctx context.Context, prompt string) (string, error)
+}
+
+// Func adapts a function to a Prompter.
+type Func func(ctx context.Context, prompt string) (string, error)
+
+// Prompt returns f(ctx, prompt).
+func (f Func) Prompt(ctx context.Context, prompt string) (string, error) {
+	return f(ctx, prompt)
+}
+
+// Terminal returns a Prompter that shows each prompt on standard error
+// and reads the answer from the terminal on standard input, without
+// echoing it.
+func Terminal() Prompter {
+	return Func(func(_ context.Context, prompt string) (string, error) {
+		fd := int(os.Stdin.Fd())
+		if !term.IsTerminal(fd) {
+			return "", errors.New("prompt: standard input is not a terminal")
+		}
+		_, _ = fmt.Fprint(os.Stderr, prompt)
+		b, err := term.ReadPassword(fd)
+		_, _ = fmt.Fprintln(os.Stderr)
+		return string(b), err
+	})
+}
+
+// Password matches the password prompts of common tools, such as sudo,
+// su, and ssh: a line that ends in "password:" or "passphrase:", with
+// anything between, and no newline after.
+var Password = regexp.MustCompile(
+	`(?i)(password|passphrase)[^\n]*:\s*$`,
+)
+
+// Machine returns a command.Machine that runs commands on m, watches
+// their diagnostic output for prompts that match Password, and writes
+// the answers of p, followed by a newline, to their input. Prompts are
+// not passed on with the rest of their diagnostic output.
+//
+// Commands must read answers from their input, as sudo does with -S, and
+// their input must be left open until they have read them.
+func Machine(m command.Machine, p Prompter) command.Machine {
+	return &machine{m: m, p: p}
+}
+
+type machine struct {
+	m command.Machine
+	p Prompter
+}
+
+func (m *machine) Command(
+	ctx context.Context, arg ...string,
+) command.Buffer {
+	c := &cmd{Buffer: m.m.Command(ctx, arg...), ctx: ctx, p: m.p}
+	command.Log(c.Buffer, (*watcher)(c))
+	return c
+}
+
+type cmd struct {
+	command.Buffer
+	ctx context.Context
+	p   Prompter
+
+	mu      sync.Mutex
+	log     io.Writer    // Set by Log.
+	logbuf  bytes.Buffer // Diagnostic output, if Log is not called.
+	partial []byte       // An unterminated line of diagnostic output.
+	err     error        // From the prompter.
+}
+
+func (c *cmd) Read(p []byte) (int, error) {
+	n, err := c.Buffer.Read(p)
+	if err == nil {
+		return n, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.partial) > 0 {
+		_ = c.emit(c.partial)
+		c.partial = nil
+	}
+	if err != io.EOF {
+		var cerr *command.Error
+		if errors.As(err, &cerr) && c.log == nil && len(cerr.Log) == 0 {
+			cerr.Log = c.logbuf.Bytes()
+		}
+		if c.err != nil {
+			err = errors.Join(err, c.err)
+		}
+	}
+	return n, err
+}
+
+func (c *cmd) Write(p []byte) (int, error) {
+	if w, ok := c.Buffer.(command.WriteBuffer); ok {
+		return w.Write(p)
+	}
+	return 0, command.ErrReadOnly
+}
+
+func (c *cmd) Close() error {
+	if w, ok := c.Buffer.(io.Closer); ok {
+		return w.Close()
+	}
+	return nil
+}
+
+func (c *cmd) Log(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.log = w
+}
+
+func (c *cmd) Attach() error  { return command.Attach(c.Buffer) }
+func (c *cmd) String() string { return command.String(c.Buffer) }
+
+// A watcher receives the diagnostic output of a cmd, answers its
+// prompts, and passes the rest on.
+type watcher cmd
+
+func (w *watcher) Write(p []byte) (int, error) {
+	c := (*cmd)(w)
+	c.mu.Lock()
+	c.partial = append(c.partial, p...)
+	var out []byte
+	if i := bytes.LastIndexByte(c.partial, '\n'); i >= 0 {
+		out = append(out, c.partial[:i+1]...)
+		c.partial = c.partial[i+1:]
+	}
+	var prompt string
+	if Password.Match(c.partial) {
+		prompt = strings.TrimSpace(string(c.partial)) + " "
+		c.partial = nil
+	}
+	err := c.emit(out)
+	c.mu.Unlock()
+	if err != nil || prompt == "" {
+		return len(p), err
+	}
+	return len(p), c.answer(prompt)
+}
+
+// emit passes diagnostic output on. c.mu must be held.
+func (c *cmd) emit(p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	if c.log != nil {
+		_, err := c.log.Write(p)
+		return err
+	}
+	c.logbuf.Write(p)
+	return nil
+}
+
+func (c *cmd) answer(prompt string) error {
+	ans, err := c.p.Prompt(c.ctx, prompt)
+	if err == nil {
+		_, err = c.Write([]byte(ans + "\n"))
+	}
+	if err != nil {
+		c.mu.Lock()
+		c.err = fmt.Errorf("prompt: %w", err)
+		c.mu.Unlock()
+		// Closing input lets the command fail instead of waiting.
+		_ = c.Close()
+	}
+	return nil
+}
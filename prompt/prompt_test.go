@@ -0,0 +1,70 @@
+package prompt_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/prompt"
+	"lesiw.io/command/sys"
+)
+
+const script = `printf 'warming up\n' >&2
+printf '[sudo] password for user: ' >&2
+read pw
+echo "got $pw"`
+
+func TestMachine(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	var asked []string
+	p := prompt.Func(func(_ context.Context, s string) (string, error) {
+		asked = append(asked, s)
+		return "hunter2", nil
+	})
+	m := prompt.Machine(sys.Machine(), p)
+	var log bytes.Buffer
+	buf := m.Command(t.Context(), "sh", "-c", script)
+	command.Log(buf, &log)
+
+	var out bytes.Buffer
+	if _, err := out.ReadFrom(buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := out.String(), "got hunter2\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+	want := []string{"[sudo] password for user: "}
+	if got := asked; !slices.Equal(got, want) {
+		t.Errorf("prompts = %q, want %q", got, want)
+	}
+	if got, want := log.String(), "warming up\n"; got != want {
+		t.Errorf("log = %q, want %q", got, want)
+	}
+}
+
+func TestMachineError(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	p := prompt.Func(func(context.Context, string) (string, error) {
+		return "", errors.New("no password")
+	})
+	m := prompt.Machine(sys.Machine(), p)
+
+	_, err := command.Read(t.Context(), m, "sh", "-c", script+"\nexit 1")
+
+	if err == nil {
+		t.Fatal("err = nil, want error")
+	}
+	if !strings.Contains(err.Error(), "no password") {
+		t.Errorf("err = %q, want prompter error", err)
+	}
+}
@@ -4,12 +4,16 @@ import (
 	"context"
 	"io"
 	"sync"
+	"time"
 )
 
 type reader struct {
 	sync.Mutex
 	r       io.Reader
 	cancel  context.CancelFunc
+	leak    *leak
+	stall   time.Duration
+	codes   []int // Exit codes that end the output without error.
 	started bool
 	closed  bool
 }
@@ -23,9 +27,37 @@ func (r *reader) Read(p []byte) (int, error) {
 	r.started = true
 	r.Unlock()
 
-	return r.r.Read(p)
+	n, err := r.r.Read(p)
+	if exitAllowed(r.codes, err) {
+		err = io.EOF
+	}
+	if err == io.EOF {
+		r.leak.release()
+	}
+	return n, err
 }
 
+// Result returns the result of the command, if it implements
+// [ResultBuffer].
+func (r *reader) Result() (Result, bool) { return ResultOf(r.r) }
+
+// SpliceFrom reports false: the command of a reader takes no input.
+func (r *reader) SpliceFrom(io.Reader) bool { return false }
+
+// SpliceTo splices the command's output into dst, if the command
+// implements [SpliceBuffer] and has not started.
+func (r *reader) SpliceTo(dst io.Writer) bool {
+	r.Lock()
+	defer r.Unlock()
+	if r.started || r.closed {
+		return false
+	}
+	return SpliceTo(r.r, dst)
+}
+
+func (r *reader) stallTimeout() time.Duration { return r.stall }
+func (r *reader) abort()                      { r.cancel() }
+
 func (r *reader) Close() error {
 	r.Lock()
 	if r.closed {
@@ -35,6 +67,7 @@ func (r *reader) Close() error {
 	r.closed = true
 	started := r.started
 	r.Unlock()
+	r.leak.release()
 
 	if started && r.cancel != nil {
 		r.cancel()
@@ -60,7 +93,10 @@ func (r *reader) Close() error {
 func NewReader(ctx context.Context, m Machine, args ...string) io.ReadCloser {
 	ctx, cancel := context.WithCancel(ctx)
 	return &reader{
-		r:      m.Command(ctx, args...),
+		r:      newCommand(ctx, m, args...),
 		cancel: cancel,
+		leak:   track(m, args),
+		stall:  stallTimeout(ctx),
+		codes:  allowedCodes(ctx),
 	}
 }
@@ -0,0 +1,92 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"lesiw.io/fs"
+)
+
+// reattachPoll is how often Process.Wait checks whether the process is
+// still running.
+var reattachPoll = 100 * time.Millisecond
+
+// A Process is a handle to a process running in the background of a
+// machine, such as a daemon started by sys.Detach.
+type Process struct {
+	Pid int
+
+	m Machine
+}
+
+// Reattach returns a handle to the process whose ID is recorded in
+// pidfile on m. The pidfile is read with m's filesystem, so relative
+// paths are relative to the working directory of ctx.
+//
+// Reattach does not check that the process is still running; see
+// [Process.Running].
+func Reattach(
+	ctx context.Context, m Machine, pidfile string,
+) (*Process, error) {
+	b, err := fs.ReadFile(ctx, FS(m), pidfile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pidfile: %w", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || pid <= 0 {
+		return nil, fmt.Errorf("bad pidfile %q: %q", pidfile, b)
+	}
+	return &Process{Pid: pid, m: m}, nil
+}
+
+// Running reports whether the process is running. Processes that the
+// machine's user may not signal are reported as not running.
+func (p *Process) Running(ctx context.Context) bool {
+	pid := strconv.Itoa(p.Pid)
+	if OS(ctx, p.m) == "windows" {
+		out, err := Read(ctx, p.m,
+			"tasklist", "/FI", "PID eq "+pid, "/FO", "CSV", "/NH",
+		)
+		return err == nil && strings.Contains(out, `"`+pid+`"`)
+	}
+	return Do(ctx, p.m, "kill", "-0", pid) == nil
+}
+
+// Stop asks the process to exit, as with SIGTERM. It does not wait for
+// the process to exit; see [Process.Wait].
+func (p *Process) Stop(ctx context.Context) error {
+	pid := strconv.Itoa(p.Pid)
+	if OS(ctx, p.m) == "windows" {
+		return Do(ctx, p.m, "taskkill", "/PID", pid)
+	}
+	return Do(ctx, p.m, "kill", "-TERM", pid)
+}
+
+// Kill causes the process to exit immediately, as with SIGKILL.
+func (p *Process) Kill(ctx context.Context) error {
+	pid := strconv.Itoa(p.Pid)
+	if OS(ctx, p.m) == "windows" {
+		return Do(ctx, p.m, "taskkill", "/F", "/PID", pid)
+	}
+	return Do(ctx, p.m, "kill", "-KILL", pid)
+}
+
+// Wait waits for the process to exit, or for ctx to be done.
+//
+// Unlike os.Process.Wait, Wait works for processes that are not children
+// of the current process, so it cannot report how the process exited.
+func (p *Process) Wait(ctx context.Context) error {
+	t := time.NewTicker(reattachPoll)
+	defer t.Stop()
+	for p.Running(ctx) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+	return ctx.Err()
+}
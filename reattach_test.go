@@ -0,0 +1,76 @@
+package command_test
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+	"lesiw.io/fs"
+)
+
+func TestReattach(t *testing.T) {
+	ctx, m := t.Context(), new(mock.Machine)
+	m.SetOS("linux")
+	err := fs.WriteFile(ctx, command.FS(m), "app.pid", []byte("4242\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := command.Reattach(ctx, m, "app.pid")
+	if err != nil {
+		t.Fatalf("command.Reattach error: %v", err)
+	}
+	if p.Pid != 4242 {
+		t.Errorf("Pid = %d, want 4242", p.Pid)
+	}
+	if !p.Running(ctx) {
+		t.Error("Running() = false, want true")
+	}
+	if err := p.Stop(ctx); err != nil {
+		t.Errorf("Stop() error: %v", err)
+	}
+
+	want := [][]string{{"kill", "-0", "4242"}, {"kill", "-TERM", "4242"}}
+	var got [][]string
+	for _, c := range mock.Calls(m, "kill") {
+		got = append(got, c.Args)
+	}
+	if !cmp.Equal(want, got) {
+		t.Errorf("calls (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestReattachExited(t *testing.T) {
+	ctx, m := t.Context(), new(mock.Machine)
+	m.SetOS("linux")
+	m.Return(command.Fail(&command.Error{Code: 1}), "kill")
+	err := fs.WriteFile(ctx, command.FS(m), "app.pid", []byte("4242"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := command.Reattach(ctx, m, "app.pid")
+	if err != nil {
+		t.Fatalf("command.Reattach error: %v", err)
+	}
+	if p.Running(ctx) {
+		t.Error("Running() = true, want false")
+	}
+	if err := p.Wait(ctx); err != nil {
+		t.Errorf("Wait() error: %v", err)
+	}
+}
+
+func TestReattachBadPidfile(t *testing.T) {
+	ctx, m := t.Context(), new(mock.Machine)
+	err := fs.WriteFile(ctx, command.FS(m), "app.pid", []byte("nope"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := command.Reattach(ctx, m, "app.pid"); err == nil {
+		t.Error("command.Reattach: got nil error, want bad pidfile")
+	}
+}
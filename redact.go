@@ -0,0 +1,146 @@
+package command
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"lesiw.io/fs"
+)
+
+type redactKey struct{}
+
+// WithRedaction returns a new context that normalizes the traces and
+// errors of commands run with it, so they are stable across systems and
+// safe to share. Paths are replaced with tokens:
+//
+//	$WORK  the working directory (see fs.WithWorkDir), or the
+//	       current directory if none is set
+//	$TMP   the temporary directory
+//	$HOME  the home directory
+//
+// Longer paths are replaced first, so a working directory beneath the
+// home directory becomes $WORK rather than $HOME/....
+//
+// Redaction applies to trace lines and to the messages of returned
+// errors, and to the Log and Output of an [Error]. The underlying error
+// remains available through errors.Unwrap.
+func WithRedaction(ctx context.Context) context.Context {
+	return context.WithValue(ctx, redactKey{}, true)
+}
+
+// redactor returns a replacer for the paths to redact in ctx, or nil if
+// ctx does not ask for redaction.
+func redactor(ctx context.Context) *replacer {
+	if on, _ := ctx.Value(redactKey{}).(bool); !on {
+		return nil
+	}
+	r := new(replacer)
+	add := func(path, name string) {
+		path = strings.TrimRight(path, `/\`)
+		if path != "" {
+			r.tokens = append(r.tokens, token{path, name})
+		}
+	}
+	work := fs.WorkDir(ctx)
+	if work == "" || !filepath.IsAbs(work) {
+		if wd, err := os.Getwd(); err == nil {
+			work = filepath.Join(wd, work)
+		}
+	}
+	add(work, "$WORK")
+	add(os.TempDir(), "$TMP")
+	if home, err := os.UserHomeDir(); err == nil {
+		add(home, "$HOME")
+	}
+	slices.SortStableFunc(r.tokens, func(a, b token) int {
+		return cmp.Compare(len(b.path), len(a.path))
+	})
+	return r
+}
+
+type token struct{ path, name string }
+
+// A replacer replaces paths with tokens, but only whole paths: a path
+// must not follow or be followed by a character of a name, so that a
+// home directory of /home/u leaves /home/user alone.
+type replacer struct{ tokens []token }
+
+// Replace returns s with the paths of r replaced.
+func (r *replacer) Replace(s string) string {
+	var b strings.Builder
+	last := 0
+	for i := 0; i < len(s); {
+		if i > 0 && inPath(s[i-1]) {
+			i++
+			continue
+		}
+		t, ok := r.match(s[i:])
+		if !ok {
+			i++
+			continue
+		}
+		b.WriteString(s[last:i])
+		b.WriteString(t.name)
+		i += len(t.path)
+		last = i
+	}
+	if last == 0 {
+		return s
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// match returns the longest token whose path begins s and ends at a
+// boundary.
+func (r *replacer) match(s string) (token, bool) {
+	for _, t := range r.tokens {
+		if !strings.HasPrefix(s, t.path) {
+			continue
+		}
+		if n := len(t.path); n == len(s) || !inName(s[n]) {
+			return t, true
+		}
+	}
+	return token{}, false
+}
+
+// inName reports whether c can be part of the name of a file.
+func inName(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+		c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.' ||
+		c >= 0x80
+}
+
+// inPath reports whether c can be part of a path before a name.
+func inPath(c byte) bool { return inName(c) || c == '/' || c == '\\' }
+
+// redact returns err with its message and log rewritten by r. The [Error]
+// in err is copied, not modified, since others may hold it. An error
+// without one keeps only its message rewritten.
+func redact(r *replacer, err error) error {
+	if r == nil || err == nil {
+		return err
+	}
+	e := new(Error)
+	if !errors.As(err, &e) {
+		msg := r.Replace(err.Error())
+		if msg == err.Error() {
+			return err
+		}
+		return &editedError{msg: msg, err: err}
+	}
+	c := *e
+	if c.Log != nil {
+		c.Log = []byte(r.Replace(string(c.Log)))
+	}
+	if c.Err != nil {
+		c.Err = &editedError{msg: r.Replace(c.Err.Error()), err: c.Err}
+	}
+	return replaceError(err, e, &c, r.Replace(err.Error()))
+}
@@ -0,0 +1,121 @@
+//go:build !windows
+
+package command
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"lesiw.io/fs"
+)
+
+func redactContext(t *testing.T) context.Context {
+	t.Helper()
+	t.Setenv("HOME", "/home/gopher")
+	t.Setenv("TMPDIR", "/var/tmp")
+	ctx := fs.WithWorkDir(t.Context(), "/home/gopher/src")
+	return WithRedaction(ctx)
+}
+
+func TestRedactTrace(t *testing.T) {
+	t.Setenv("CMDTRACE", "on")
+	ctx := redactContext(t)
+	var buf strings.Builder
+	ctx = WithTrace(ctx, &buf)
+
+	err := Do(ctx, traceMachine(""), "cp",
+		"/home/gopher/src/a", "/home/gopher/b", "/var/tmp/c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := buf.String(), "cp $WORK/a $HOME/b $TMP/c\n"; got != want {
+		t.Errorf("trace = %q, want %q", got, want)
+	}
+}
+
+func TestRedactError(t *testing.T) {
+	ctx := redactContext(t)
+	errOpen := errors.New("open /home/gopher/src/go.mod")
+	m := MachineFunc(func(context.Context, ...string) Buffer {
+		return Fail(&Error{
+			Err:  errOpen,
+			Log:  []byte("see /var/tmp/build.log\n"),
+			Code: 1,
+		})
+	})
+
+	_, err := Read(ctx, m, "go", "build")
+
+	want := "open $WORK/go.mod\n\tsee $TMP/build.log"
+	if err == nil || err.Error() != want {
+		t.Errorf("Read error: got %v, want %q", err, want)
+	}
+	if !errors.Is(err, errOpen) {
+		t.Errorf("Read error: got %v, want wrapped errOpen", err)
+	}
+}
+
+func TestRedactPlainError(t *testing.T) {
+	ctx := redactContext(t)
+	errOpen := errors.New("open /home/gopher/.netrc: permission denied")
+	m := MachineFunc(func(context.Context, ...string) Buffer {
+		return Fail(errOpen)
+	})
+
+	_, err := Read(ctx, m, "curl", "-n", "example.com")
+
+	want := "open $HOME/.netrc: permission denied"
+	if err == nil || err.Error() != want {
+		t.Errorf("Read error: got %v, want %q", err, want)
+	}
+	if !errors.Is(err, errOpen) {
+		t.Errorf("Read error: got %v, want wrapped errOpen", err)
+	}
+}
+
+func TestRedactBoundary(t *testing.T) {
+	ctx := redactContext(t)
+	var buf strings.Builder
+	ctx = WithTrace(ctx, &buf)
+	t.Setenv("CMDTRACE", "on")
+
+	err := Do(ctx, traceMachine(""), "ls",
+		"/home/gopherx", "/opt/home/gopher", "/home/gopher")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "ls /home/gopherx /opt/home/gopher $HOME\n"
+	if got := buf.String(); got != want {
+		t.Errorf("trace = %q, want %q", got, want)
+	}
+}
+
+func TestRedactErrorCopy(t *testing.T) {
+	ctx := redactContext(t)
+	shared := &Error{
+		Err:  errors.New("open /home/gopher/src/go.mod"),
+		Log:  []byte("see /var/tmp/build.log\n"),
+		Code: 1,
+	}
+	m := MachineFunc(func(context.Context, ...string) Buffer {
+		return Fail(shared)
+	})
+
+	_, err := Read(ctx, m, "go", "build")
+
+	if err == nil || !strings.Contains(err.Error(), "$WORK/go.mod") {
+		t.Errorf("Read error: got %v, want redacted", err)
+	}
+	got, want := shared.Err.Error(), "open /home/gopher/src/go.mod"
+	if got != want {
+		t.Errorf("shared Err = %q, want %q", got, want)
+	}
+	got, want = string(shared.Log), "see /var/tmp/build.log\n"
+	if got != want {
+		t.Errorf("shared Log = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,79 @@
+package command
+
+import "context"
+
+type (
+	stdoutFileKey struct{}
+	stderrFileKey struct{}
+)
+
+// WithStdoutFile returns a new context that asks machines to write the
+// output of commands to the file at path, which is created or truncated,
+// instead of returning it from their Buffers. The path is on the machine
+// that runs the command, and relative paths are relative to the
+// command's working directory. An empty path clears the request.
+//
+// Machines redirect output at its source, so that it never passes
+// through this process, however large it is. The local system hands the
+// file to the command directly, and remote machines redirect with their
+// shell, as if by >path. Machines that cannot redirect output fail with
+// an error wrapping errors.ErrUnsupported, as mem does, except mock,
+// which ignores the request.
+//
+//	ctx := command.WithStdoutFile(ctx, "build.log")
+//	err := command.Do(ctx, m, "make")
+//
+// Machines and helpers clear this setting from the commands they run for
+// themselves, with [WithoutCommandOptions].
+func WithStdoutFile(ctx context.Context, path string) context.Context {
+	if StdoutFile(ctx) == path {
+		return ctx
+	}
+	return context.WithValue(ctx, stdoutFileKey{}, path)
+}
+
+// WithStderrFile returns a new context that asks machines to write the
+// diagnostic output of commands to the file at path, as [WithStdoutFile]
+// does for their output. It takes precedence over [WithMergedStderr].
+func WithStderrFile(ctx context.Context, path string) context.Context {
+	if StderrFile(ctx) == path {
+		return ctx
+	}
+	return context.WithValue(ctx, stderrFileKey{}, path)
+}
+
+// StdoutFile returns the path of the file that ctx asks output to be
+// written to, or "" if none.
+func StdoutFile(ctx context.Context) string {
+	path, _ := ctx.Value(stdoutFileKey{}).(string)
+	return path
+}
+
+// StderrFile returns the path of the file that ctx asks diagnostic
+// output to be written to, or "" if none.
+func StderrFile(ctx context.Context) string {
+	path, _ := ctx.Value(stderrFileKey{}).(string)
+	return path
+}
+
+// WithoutCommandOptions returns a new context that clears the options that
+// apply to each command the caller runs: [WithStdoutFile],
+// [WithStderrFile], [WithMergedStderr], [WithAllowedExitCodes],
+// [WithScratch], and [WithLogSpill]. It keeps the environment and
+// working directory, which the commands run with it still honor.
+//
+// Machines and helpers such as [Glob] use it for the commands they run
+// for themselves, so that options meant for the caller's commands do not
+// redirect or reinterpret the output they read. Machines that fold the
+// options into the commands they forward clear them the same way.
+func WithoutCommandOptions(ctx context.Context) context.Context {
+	ctx = WithStdoutFile(WithStderrFile(ctx, ""), "")
+	ctx = WithMergedStderr(withoutScratch(ctx), false)
+	if len(allowedCodes(ctx)) > 0 {
+		ctx = WithAllowedExitCodes(ctx)
+	}
+	if LogSpill(ctx) {
+		ctx = WithLogSpill(ctx, false)
+	}
+	return ctx
+}
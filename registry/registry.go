@@ -0,0 +1,225 @@
+// Package registry finds command.Machines by their labels, so that code
+// can ask for a machine that can do a job rather than for a particular
+// one.
+//
+//	b1 := ssh.Machine(sys.Machine(), "ssh", "b1")
+//	registry.Add("linux-builder", b1, "os=linux", "arch=arm64", "gpu")
+//	local := sys.Machine()
+//	registry.Add("local", local, registry.Detect(ctx, local)...)
+//
+//	builders, err := registry.Select("os=linux,arch=arm64")
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	p := pool.New(builders)
+//
+// A label is a key=value pair, or a bare key. A selector is a
+// comma-separated list of terms, all of which a machine's labels must
+// satisfy:
+//
+//	key=value   the machine has the label key=value
+//	key!=value  the machine has no label key=value
+//	key         the machine has a label with the key
+//	!key        the machine has no label with the key
+//
+// The empty selector selects every machine.
+package registry
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"lesiw.io/command"
+)
+
+// A Registry holds named machines and their labels. The zero value is an
+// empty registry ready to use.
+type Registry struct {
+	mu      sync.RWMutex
+	entries []entry
+}
+
+type entry struct {
+	name   string
+	m      command.Machine
+	labels map[string]string
+}
+
+// Default is the registry of the package-level functions.
+var Default = new(Registry)
+
+// Add adds m to the Default registry under name. See [Registry.Add].
+func Add(name string, m command.Machine, labels ...string) {
+	Default.Add(name, m, labels...)
+}
+
+// Get returns the machine in the Default registry called name. See
+// [Registry.Get].
+func Get(name string) (command.Machine, bool) { return Default.Get(name) }
+
+// Select returns the machines in the Default registry that match
+// selector. See [Registry.Select].
+func Select(selector string) ([]command.Machine, error) {
+	return Default.Select(selector)
+}
+
+// Add adds m to r under name, with labels. It replaces any machine that
+// was already called name.
+func (r *Registry) Add(name string, m command.Machine, labels ...string) {
+	e := entry{name: name, m: m, labels: make(map[string]string)}
+	for _, l := range labels {
+		k, v, _ := strings.Cut(l, "=")
+		e.labels[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.index(name); i >= 0 {
+		r.entries[i] = e
+		return
+	}
+	r.entries = append(r.entries, e)
+}
+
+// Remove removes the machine called name from r.
+func (r *Registry) Remove(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if i := r.index(name); i >= 0 {
+		r.entries = slices.Delete(r.entries, i, i+1)
+	}
+}
+
+func (r *Registry) index(name string) int {
+	return slices.IndexFunc(r.entries, func(e entry) bool {
+		return e.name == name
+	})
+}
+
+// Get returns the machine called name.
+func (r *Registry) Get(name string) (command.Machine, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if i := r.index(name); i >= 0 {
+		return r.entries[i].m, true
+	}
+	return nil, false
+}
+
+// Labels returns the labels of the machine called name, sorted.
+func (r *Registry) Labels(name string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	i := r.index(name)
+	if i < 0 {
+		return nil
+	}
+	var labels []string
+	for k, v := range r.entries[i].labels {
+		if v == "" {
+			labels = append(labels, k)
+		} else {
+			labels = append(labels, k+"="+v)
+		}
+	}
+	slices.Sort(labels)
+	return labels
+}
+
+// Select returns the machines in r that match selector, in the order
+// they were added. It returns an error if selector is malformed.
+func (r *Registry) Select(selector string) ([]command.Machine, error) {
+	terms, err := parse(selector)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var ms []command.Machine
+	for _, e := range r.entries {
+		if terms.match(e.labels) {
+			ms = append(ms, e.m)
+		}
+	}
+	return ms, nil
+}
+
+// Machine returns the machine called selector, if there is one, or else
+// the first machine that matches selector. It returns an error if no
+// machine matches.
+//
+// Machine lets a Registry choose the machines of a taskfile.Runner.
+func (r *Registry) Machine(selector string) (command.Machine, error) {
+	if m, ok := r.Get(selector); ok {
+		return m, nil
+	}
+	ms, err := r.Select(selector)
+	if err != nil {
+		return nil, err
+	}
+	if len(ms) == 0 {
+		return nil, fmt.Errorf("no machine matches %q", selector)
+	}
+	return ms[0], nil
+}
+
+// Detect returns the os and arch labels of m, as reported by command.OS
+// and command.Arch.
+func Detect(ctx context.Context, m command.Machine) []string {
+	return []string{
+		"os=" + command.OS(ctx, m),
+		"arch=" + command.Arch(ctx, m),
+	}
+}
+
+type term struct {
+	key, value string
+	hasValue   bool
+	negate     bool
+}
+
+type terms []term
+
+func parse(selector string) (terms, error) {
+	var ts terms
+	if strings.TrimSpace(selector) == "" {
+		return nil, nil
+	}
+	for s := range strings.SplitSeq(selector, ",") {
+		var t term
+		s = strings.TrimSpace(s)
+		switch {
+		case strings.Contains(s, "!="):
+			t.key, t.value, _ = strings.Cut(s, "!=")
+			t.hasValue, t.negate = true, true
+		case strings.Contains(s, "="):
+			t.key, t.value, _ = strings.Cut(s, "=")
+			t.hasValue = true
+		case strings.HasPrefix(s, "!"):
+			t.key, t.negate = s[1:], true
+		default:
+			t.key = s
+		}
+		t.key, t.value = strings.TrimSpace(t.key), strings.TrimSpace(t.value)
+		if t.key == "" {
+			return nil, fmt.Errorf("bad selector %q: empty key", selector)
+		}
+		ts = append(ts, t)
+	}
+	return ts, nil
+}
+
+func (ts terms) match(labels map[string]string) bool {
+	for _, t := range ts {
+		v, ok := labels[t.key]
+		if t.hasValue {
+			ok = ok && v == t.value
+		}
+		if ok == t.negate {
+			return false
+		}
+	}
+	return true
+}
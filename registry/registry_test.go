@@ -0,0 +1,94 @@
+package registry_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+	"lesiw.io/command/registry"
+)
+
+func TestSelect(t *testing.T) {
+	a, b, c := new(mock.Machine), new(mock.Machine), new(mock.Machine)
+	r := new(registry.Registry)
+	r.Add("a", a, "os=linux", "arch=amd64")
+	r.Add("b", b, "os=linux", "arch=arm64", "gpu")
+	r.Add("c", c, "os=darwin", "arch=arm64")
+
+	tests := []struct {
+		selector string
+		want     []command.Machine
+	}{
+		{"", []command.Machine{a, b, c}},
+		{"os=linux", []command.Machine{a, b}},
+		{"os=linux,arch=arm64", []command.Machine{b}},
+		{"arch=arm64, os!=linux", []command.Machine{c}},
+		{"gpu", []command.Machine{b}},
+		{"!gpu", []command.Machine{a, c}},
+		{"os=windows", nil},
+	}
+	for _, tt := range tests {
+		got, err := r.Select(tt.selector)
+		if err != nil {
+			t.Errorf("Select(%q) error: %v", tt.selector, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Select(%q) = %d machines, want %d",
+				tt.selector, len(got), len(tt.want))
+		}
+	}
+	if _, err := r.Select("os=linux,,arch=arm64"); err == nil {
+		t.Error("Select with empty term error = nil, want error")
+	}
+}
+
+func TestAddReplaces(t *testing.T) {
+	old, cur := new(mock.Machine), new(mock.Machine)
+	r := new(registry.Registry)
+	r.Add("builder", old, "os=linux")
+	r.Add("builder", cur, "os=darwin", "gpu")
+
+	if got, ok := r.Get("builder"); !ok || got != cur {
+		t.Errorf("Get(builder) = %v, %v, want the replacement", got, ok)
+	}
+	want := []string{"gpu", "os=darwin"}
+	if got := r.Labels("builder"); !cmp.Equal(got, want) {
+		t.Errorf("Labels(builder) = %q, want %q", got, want)
+	}
+	r.Remove("builder")
+	if _, ok := r.Get("builder"); ok {
+		t.Error("Get after Remove ok = true, want false")
+	}
+}
+
+func TestMachine(t *testing.T) {
+	a, b := new(mock.Machine), new(mock.Machine)
+	r := new(registry.Registry)
+	r.Add("a", a, "os=linux")
+	r.Add("b", b, "os=linux", "arch=arm64")
+
+	if m, err := r.Machine("b"); err != nil || m != b {
+		t.Errorf("Machine(b) = %v, %v, want b by name", m, err)
+	}
+	if m, err := r.Machine("os=linux"); err != nil || m != a {
+		t.Errorf("Machine(os=linux) = %v, %v, want first match", m, err)
+	}
+	if _, err := r.Machine("os=plan9"); err == nil {
+		t.Error("Machine(os=plan9) error = nil, want no match")
+	}
+}
+
+func TestDetect(t *testing.T) {
+	m := new(mock.Machine)
+	m.SetOS("linux")
+	m.SetArch("arm64")
+
+	want := []string{"os=linux", "arch=arm64"}
+	if got := registry.Detect(t.Context(), m); !cmp.Equal(got, want) {
+		t.Errorf("Detect = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,143 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"lesiw.io/fs"
+)
+
+// ScratchEnv is the environment variable that holds the path of a
+// command's scratch directory. See [WithScratch].
+const ScratchEnv = "COMMAND_SCRATCH"
+
+type scratchKey struct{}
+
+// WithScratch returns a new context that gives each command run with it a
+// private scratch directory on the machine that runs it. The directory is
+// created just before the command starts, its path is in the command's
+// environment as [ScratchEnv], and it is removed with everything in it
+// once the command's output has been read to the end, or once ctx is
+// done, such as when a reader from NewReader is closed.
+//
+// [Run], [Do], [Read], [Exec], [NewReader], [NewWriter], and [NewFilter]
+// provide scratch directories. Buffers from Machine.Command do not.
+//
+//	ctx := command.WithScratch(ctx)
+//	err := command.Do(ctx, m, "sh", "-c", `cd "$COMMAND_SCRATCH" && make`)
+func WithScratch(ctx context.Context) context.Context {
+	return context.WithValue(ctx, scratchKey{}, true)
+}
+
+// withoutScratch returns a context that does not ask for scratch
+// directories, for the commands that manage them.
+func withoutScratch(ctx context.Context) context.Context {
+	if !scratchOn(ctx) {
+		return ctx
+	}
+	return context.WithValue(ctx, scratchKey{}, false)
+}
+
+func scratchOn(ctx context.Context) bool {
+	on, _ := ctx.Value(scratchKey{}).(bool)
+	return on
+}
+
+// scratchCommand runs m.Command, first creating a scratch directory for
+// the command if ctx asks for one.
+func scratchCommand(ctx context.Context, m Machine, args ...string) Buffer {
+	if !scratchOn(ctx) {
+		return m.Command(ctx, args...)
+	}
+	fctx := WithoutCommandOptions(ctx) // For the directory's own commands.
+	fsys := FS(m)
+	dir, err := mkTempDir(fctx, fsys, "command-scratch")
+	if err != nil {
+		return Fail(&Error{
+			Err: fmt.Errorf("failed to create scratch directory: %w", err),
+		})
+	}
+	ctx = WithEnv(withoutScratch(ctx), map[string]string{ScratchEnv: dir})
+	sc := &scratchBuffer{Buffer: m.Command(ctx, args...)}
+	var (
+		mu   sync.Mutex
+		stop func() bool
+	)
+	sc.remove = sync.OnceFunc(func() {
+		// If ctx is already done, remove may run before stop is set.
+		mu.Lock()
+		if stop != nil {
+			stop()
+		}
+		mu.Unlock()
+		// Best effort, like removing any temporary directory.
+		_ = fs.RemoveAll(context.WithoutCancel(fctx), fsys, dir)
+	})
+	// A command that is canceled may never be read to the end.
+	mu.Lock()
+	stop = context.AfterFunc(ctx, sc.remove)
+	mu.Unlock()
+	if _, ok := sc.Buffer.(WriteBuffer); ok {
+		return &scratchWriteBuffer{sc}
+	}
+	return sc
+}
+
+// mkTempDir creates a new temporary directory on fsys, named after
+// prefix.
+func mkTempDir(
+	ctx context.Context, fsys fs.FS, prefix string,
+) (string, error) {
+	if tfs, ok := fsys.(fs.TempDirFS); ok {
+		return tfs.TempDir(ctx, prefix)
+	}
+	w, err := fs.Temp(ctx, fsys, prefix+"/")
+	if err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return w.Path(), nil
+}
+
+// A scratchBuffer removes the scratch directory of its command once the
+// command completes.
+type scratchBuffer struct {
+	Buffer
+	remove func()
+}
+
+func (b *scratchBuffer) Read(p []byte) (int, error) {
+	n, err := b.Buffer.Read(p)
+	if err != nil {
+		b.remove()
+	}
+	return n, err
+}
+
+func (b *scratchBuffer) Attach() error   { return Attach(b.Buffer) }
+func (b *scratchBuffer) Log(w io.Writer) { Log(b.Buffer, w) }
+func (b *scratchBuffer) String() string  { return String(b.Buffer) }
+
+func (b *scratchBuffer) Result() (Result, bool) { return ResultOf(b.Buffer) }
+
+func (b *scratchBuffer) SpliceFrom(src io.Reader) bool {
+	return SpliceFrom(b.Buffer, src)
+}
+
+func (b *scratchBuffer) SpliceTo(dst io.Writer) bool {
+	return SpliceTo(b.Buffer, dst)
+}
+
+type scratchWriteBuffer struct{ *scratchBuffer }
+
+func (b *scratchWriteBuffer) Write(p []byte) (int, error) {
+	return b.Buffer.(WriteBuffer).Write(p)
+}
+
+func (b *scratchWriteBuffer) Close() error {
+	return b.Buffer.(WriteBuffer).Close()
+}
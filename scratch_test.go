@@ -0,0 +1,103 @@
+package command_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mem"
+	"lesiw.io/command/sys"
+)
+
+func TestWithScratch(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	ctx, m := command.WithScratch(t.Context()), sys.Machine()
+	script := `touch "$COMMAND_SCRATCH/x" && echo "$COMMAND_SCRATCH"`
+
+	first, err := command.Read(ctx, m, "sh", "-c", script)
+	if err != nil {
+		t.Fatalf("command.Read error: %v", err)
+	}
+	second, err := command.Read(ctx, m, "sh", "-c", script)
+	if err != nil {
+		t.Fatalf("command.Read error: %v", err)
+	}
+
+	if first == "" || !filepath.IsAbs(first) {
+		t.Fatalf("scratch directory = %q, want an absolute path", first)
+	}
+	if first == second {
+		t.Errorf("commands shared scratch directory %q", first)
+	}
+	for _, dir := range []string{first, second} {
+		if _, err := os.Stat(dir); !os.IsNotExist(err) {
+			t.Errorf("os.Stat(%q) error = %v, want not exist", dir, err)
+		}
+	}
+}
+
+func TestWithScratchReader(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	ctx := command.WithScratch(t.Context())
+	r := command.NewReader(ctx, sys.Machine(),
+		"sh", "-c", `echo "$COMMAND_SCRATCH"; sleep 10`)
+	line := make([]byte, 512)
+	n, err := r.Read(line)
+	if err != nil {
+		t.Fatalf("Read error: %v", err)
+	}
+	dir := strings.TrimSpace(string(line[:n]))
+	if _, err := os.Stat(dir); err != nil {
+		t.Fatalf("scratch directory missing while running: %v", err)
+	}
+
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	// The directory is removed in the background once ctx is done.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, err := os.Stat(dir)
+		if os.IsNotExist(err) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("os.Stat(%q) error = %v, want not exist", dir, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWithScratchCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	ctx, m := command.WithScratch(ctx), mem.Machine()
+
+	for range 100 {
+		_, _ = command.Read(ctx, m, "echo", "hello")
+	}
+}
+
+func TestWithoutScratch(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	out, err := command.Read(t.Context(), sys.Machine(),
+		"sh", "-c", `echo "${COMMAND_SCRATCH-unset}"`)
+	if err != nil {
+		t.Fatalf("command.Read error: %v", err)
+	}
+	if out != "unset" {
+		t.Errorf("COMMAND_SCRATCH = %q, want unset", out)
+	}
+}
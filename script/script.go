@@ -0,0 +1,384 @@
+// Package script runs line-oriented command scripts against any
+// command.Machine.
+//
+// A script is a sequence of lines, each holding one command and its
+// arguments. Blank lines and lines beginning with # are ignored.
+//
+//	# Build and run the tool in a container.
+//	env GOFLAGS=-trimpath
+//	cd /src
+//	exec go build -o /bin/tool .
+//	exec tool --version
+//	stdout '^tool v\d+'
+//	! stderr .
+//
+//	! exec tool --bad-flag
+//	status 2
+//	stderr 'unknown flag'
+//
+// The commands are:
+//
+//	cd dir            change the working directory of later commands
+//	env KEY=VALUE...  set environment variables for later commands
+//	exec cmd args...  run cmd on the machine
+//	status code       check the exit code of the last exec
+//	stdout regexp     check the output of the last exec
+//	stderr regexp     check the diagnostic output of the last exec
+//
+// Prefixing exec with ! expects the command to fail, and prefixing it
+// with ? accepts success or failure; an unprefixed exec must succeed.
+// A command that cannot be found fails the script either way.
+// Prefixing stdout or stderr with ! expects the regexp not to match.
+// Regexps use [regexp/syntax], with multi-line mode enabled.
+//
+// Arguments are separated by spaces. Single quotes preserve spaces, and
+// a doubled single quote inside quotes is a literal quote. $'...' quotes
+// also take backslash escapes, such as \n and \', as [argv.Marshal]
+// writes them, so that any argument fits on a line. $NAME and ${NAME}
+// outside quotes expand to variables set with env, or to the empty
+// string.
+//
+// Because every command goes through the Machine, the same script can
+// check a local build, a container, or a remote host:
+//
+//	s, err := script.Parse("build.txt", data)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	if err := s.Run(ctx, ctr.Machine(sys.Machine(), "golang")); err != nil {
+//	    log.Fatal(err)
+//	}
+package script
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"lesiw.io/command"
+	"lesiw.io/command/argv"
+	"lesiw.io/fs"
+	"lesiw.io/fs/path"
+)
+
+// A Script is a parsed script.
+type Script struct {
+	// Name identifies the script in errors, usually by its file name.
+	Name string
+
+	lines []line
+}
+
+type line struct {
+	num  int
+	neg  bool // Prefixed with !.
+	any  bool // Prefixed with ?.
+	verb string
+	args []word
+}
+
+// A word is an argument, as pieces that do or do not expand variables.
+type word []piece
+
+type piece struct {
+	text   string
+	quoted bool
+}
+
+// Parse parses the script in data. name identifies the script in errors.
+func Parse(name string, data []byte) (*Script, error) {
+	s := &Script{Name: name}
+	for i, text := range strings.Split(string(data), "\n") {
+		l, err := parseLine(i+1, text)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, i+1, err)
+		}
+		if l.verb == "" {
+			continue
+		}
+		if err := l.check(); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", name, i+1, err)
+		}
+		s.lines = append(s.lines, l)
+	}
+	return s, nil
+}
+
+func parseLine(num int, text string) (l line, err error) {
+	l.num = num
+	words, err := split(strings.TrimSpace(text))
+	if err != nil || len(words) == 0 {
+		return
+	}
+	if len(words[0]) == 1 && !words[0][0].quoted {
+		switch words[0][0].text {
+		case "!":
+			l.neg, words = true, words[1:]
+		case "?":
+			l.any, words = true, words[1:]
+		}
+	}
+	if len(words) == 0 {
+		return l, errors.New("missing command after prefix")
+	}
+	if len(words[0]) != 1 || words[0][0].quoted {
+		return l, errors.New("command name must be a plain word")
+	}
+	l.verb, l.args = words[0][0].text, words[1:]
+	return
+}
+
+func (l line) check() error {
+	switch l.verb {
+	case "cd", "status":
+		if len(l.args) != 1 {
+			return fmt.Errorf("usage: %s arg", l.verb)
+		}
+	case "env":
+	case "exec":
+		if len(l.args) == 0 {
+			return errors.New("usage: exec cmd args...")
+		}
+	case "stdout", "stderr":
+		if len(l.args) != 1 {
+			return fmt.Errorf("usage: %s regexp", l.verb)
+		}
+	default:
+		return fmt.Errorf("unknown command %q", l.verb)
+	}
+	if l.verb != "exec" && l.any {
+		return fmt.Errorf("%s does not support ?", l.verb)
+	}
+	if l.verb != "exec" && l.verb != "stdout" && l.verb != "stderr" &&
+		l.neg {
+		return fmt.Errorf("%s does not support !", l.verb)
+	}
+	return nil
+}
+
+// split splits text into words, honoring single quotes and comments.
+func split(text string) ([]word, error) {
+	var (
+		words  []word
+		cur    word
+		inWord bool
+		buf    strings.Builder
+	)
+	flush := func(quoted bool) {
+		if buf.Len() > 0 || quoted {
+			cur = append(cur, piece{buf.String(), quoted})
+			buf.Reset()
+		}
+	}
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '#' && !inWord:
+			return words, nil
+		case c == ' ' || c == '\t':
+			if inWord {
+				flush(false)
+				words, cur, inWord = append(words, cur), nil, false
+			}
+		case c == '\'':
+			flush(false)
+			inWord = true
+			for i++; ; i++ {
+				if i >= len(text) {
+					return nil, errors.New("unterminated quote")
+				}
+				if text[i] == '\'' {
+					if i+1 < len(text) && text[i+1] == '\'' {
+						buf.WriteByte('\'')
+						i++
+						continue
+					}
+					break
+				}
+				buf.WriteByte(text[i])
+			}
+			flush(true)
+		case c == '$' && i+1 < len(text) && text[i+1] == '\'':
+			end := ansiEnd(text, i+2)
+			if end < 0 {
+				return nil, errors.New("unterminated quote")
+			}
+			args, err := argv.Unmarshal(text[i : end+1])
+			if err != nil {
+				return nil, err
+			}
+			flush(false)
+			inWord = true
+			buf.WriteString(args[0])
+			flush(true)
+			i = end
+		default:
+			inWord = true
+			buf.WriteByte(c)
+		}
+	}
+	if inWord {
+		flush(false)
+		words = append(words, cur)
+	}
+	return words, nil
+}
+
+// ansiEnd returns the index of the quote that closes the $'...' string
+// whose text starts at text[i], or -1 if there is none.
+func ansiEnd(text string, i int) int {
+	for ; i < len(text); i++ {
+		switch text[i] {
+		case '\\':
+			i++
+		case '\'':
+			return i
+		}
+	}
+	return -1
+}
+
+var varRE = regexp.MustCompile(`\$(\w+|\{\w+\})`)
+
+func (w word) expand(env map[string]string) string {
+	var b strings.Builder
+	for _, p := range w {
+		if p.quoted {
+			b.WriteString(p.text)
+			continue
+		}
+		b.WriteString(varRE.ReplaceAllStringFunc(p.text,
+			func(v string) string { return env[strings.Trim(v[1:], "{}")] },
+		))
+	}
+	return b.String()
+}
+
+// Run runs the script on m. It stops at the first failed command or
+// assertion and returns an error naming the script and line.
+//
+// Commands start in the working directory and with the environment
+// variables of ctx.
+func (s *Script) Run(ctx context.Context, m command.Machine) error {
+	st := &state{
+		env: maps.Clone(command.Envs(ctx)),
+		dir: fs.WorkDir(ctx),
+	}
+	if st.env == nil {
+		st.env = make(map[string]string)
+	}
+	for _, l := range s.lines {
+		args := make([]string, len(l.args))
+		for i, w := range l.args {
+			args[i] = w.expand(st.env)
+		}
+		if err := st.run(ctx, m, l, args); err != nil {
+			return fmt.Errorf("%s:%d: %s: %w", s.Name, l.num, l.verb, err)
+		}
+	}
+	return nil
+}
+
+type state struct {
+	env map[string]string
+	dir string
+
+	ran    bool
+	code   int
+	stdout string
+	stderr string
+}
+
+func (st *state) run(
+	ctx context.Context, m command.Machine, l line, args []string,
+) error {
+	switch l.verb {
+	case "cd":
+		if path.IsAbs(args[0]) || st.dir == "" {
+			st.dir = args[0]
+		} else {
+			st.dir = path.Join(st.dir, args[0])
+		}
+	case "env":
+		for _, kv := range args {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok {
+				return fmt.Errorf("bad assignment %q", kv)
+			}
+			st.env[k] = v
+		}
+	case "exec":
+		return st.exec(ctx, m, l, args)
+	case "status":
+		want, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("bad exit code %q", args[0])
+		}
+		if !st.ran {
+			return errors.New("no command has run")
+		}
+		if st.code != want {
+			return fmt.Errorf("exit code %d, want %d", st.code, want)
+		}
+	case "stdout", "stderr":
+		if !st.ran {
+			return errors.New("no command has run")
+		}
+		re, err := regexp.Compile("(?m)" + args[0])
+		if err != nil {
+			return err
+		}
+		out := st.stdout
+		if l.verb == "stderr" {
+			out = st.stderr
+		}
+		if matched := re.MatchString(out); matched == l.neg {
+			if l.neg {
+				return fmt.Errorf("unexpected match for %q in:\n%s",
+					args[0], out)
+			}
+			return fmt.Errorf("no match for %q in:\n%s", args[0], out)
+		}
+	}
+	return nil
+}
+
+func (st *state) exec(
+	ctx context.Context, m command.Machine, l line, args []string,
+) error {
+	if len(st.env) > 0 {
+		ctx = command.WithEnv(ctx, st.env)
+	}
+	if st.dir != "" {
+		ctx = fs.WithWorkDir(ctx, st.dir)
+	}
+	var stdout, stderr bytes.Buffer
+	err := command.Run(ctx, m, command.Spec{
+		Args:   args,
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	st.ran = true
+	st.stdout, st.stderr, st.code = stdout.String(), stderr.String(), 0
+	if command.NotFound(err) {
+		return err
+	}
+	if err != nil {
+		st.code = -1
+		if e := new(command.Error); errors.As(err, &e) {
+			st.code = e.Code
+		}
+	}
+	switch {
+	case l.any:
+	case l.neg && err == nil:
+		return errors.New("command succeeded unexpectedly")
+	case !l.neg && err != nil:
+		return fmt.Errorf("%w\n%s", err, st.stderr)
+	}
+	return nil
+}
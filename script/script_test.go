@@ -0,0 +1,145 @@
+package script_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lesiw.io/command"
+	"lesiw.io/command/argv"
+	"lesiw.io/command/mock"
+	"lesiw.io/command/script"
+	"lesiw.io/fs"
+)
+
+func testMachine() *mock.Machine {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("hello world\n"), "echo")
+	m.Return(command.Fail(&command.Error{Code: 2}), "false")
+	m.Return(command.Fail(&command.Error{
+		Err: errors.New("command not found: missing"),
+	}), "missing")
+	return m
+}
+
+func run(t *testing.T, m command.Machine, text string) error {
+	t.Helper()
+	s, err := script.Parse("test.txt", []byte(text))
+	if err != nil {
+		t.Fatalf("script.Parse error: %v", err)
+	}
+	return s.Run(t.Context(), m)
+}
+
+func TestScript(t *testing.T) {
+	m := testMachine()
+	var dir string
+	m.Do(func(ctx context.Context, _ ...string) command.Buffer {
+		dir = fs.WorkDir(ctx)
+		return strings.NewReader("")
+	}, "pwd")
+
+	err := run(t, m, `
+# Comments and blank lines are ignored.
+env NAME=world GREETING='hi there'
+exec echo $NAME ${GREETING} 'lit''s $NAME'
+stdout '^hello world$'
+! stdout goodbye
+! stderr .
+
+! exec false
+status 2
+? exec false
+
+cd /src
+cd sub
+exec pwd
+`)
+	if err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+
+	want := []mock.Call{{
+		Args: []string{"echo", "world", "hi there", "lit's $NAME"},
+		Env:  map[string]string{"NAME": "world", "GREETING": "hi there"},
+	}}
+	if got := mock.Calls(m, "echo"); !cmp.Equal(got, want) {
+		t.Errorf("echo calls (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	if want := "/src/sub"; dir != want {
+		t.Errorf("work dir: got %q, want %q", dir, want)
+	}
+}
+
+func TestScriptMarshaledArgs(t *testing.T) {
+	m := testMachine()
+	args := []string{"echo", "a\nb", "it's", "$NAME", "", `x\y`}
+	if err := run(t, m, "exec "+argv.Marshal(args)+"\n"); err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+	want := []mock.Call{{Args: args}}
+	if got := mock.Calls(m, "echo"); !cmp.Equal(got, want) {
+		t.Errorf("echo calls (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
+
+func TestScriptFailures(t *testing.T) {
+	tests := []struct {
+		name, text, want string
+	}{{
+		name: "exec fails",
+		text: "exec false",
+		want: "test.txt:1: exec: exit status 2",
+	}, {
+		name: "exec succeeds unexpectedly",
+		text: "! exec echo",
+		want: "test.txt:1: exec: command succeeded unexpectedly",
+	}, {
+		name: "not found",
+		text: "? exec missing",
+		want: "test.txt:1: exec: command not found: missing",
+	}, {
+		name: "status",
+		text: "? exec false\nstatus 1",
+		want: "test.txt:2: status: exit code 2, want 1",
+	}, {
+		name: "stdout",
+		text: "exec echo\n\nstdout goodbye",
+		want: "test.txt:3: stdout: no match for \"goodbye\"",
+	}, {
+		name: "negated stdout",
+		text: "exec echo\n! stdout hello",
+		want: "test.txt:2: stdout: unexpected match for \"hello\"",
+	}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := run(t, testMachine(), tt.text)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("Run error: got %v, want prefix %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		text, want string
+	}{
+		{"frobnicate", `x.txt:1: unknown command "frobnicate"`},
+		{"\nexec 'oops", "x.txt:2: unterminated quote"},
+		{"exec $'oops", "x.txt:1: unterminated quote"},
+		{"? cd /", "x.txt:1: cd does not support ?"},
+		{"! env A=B", "x.txt:1: env does not support !"},
+		{"!", "x.txt:1: missing command after prefix"},
+		{"status", "x.txt:1: usage: status arg"},
+	}
+	for _, tt := range tests {
+		_, err := script.Parse("x.txt", []byte(tt.text))
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("Parse(%q) error: got %v, want %q", tt.text, err, tt.want)
+		}
+	}
+}
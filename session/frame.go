@@ -0,0 +1,109 @@
+package session
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+)
+
+// sentinelReader reads a shell's stdout, splitting it into the output
+// of each command at the sentinel token.
+type sentinelReader struct {
+	r     io.Reader
+	br    *bufio.Reader
+	token []byte
+}
+
+func newSentinelReader(r io.Reader, token string) *sentinelReader {
+	return &sentinelReader{r: r, br: bufio.NewReader(r), token: []byte(token)}
+}
+
+// next reads the current command's output into p. When the sentinel is
+// reached, next returns the status that followed it instead.
+func (s *sentinelReader) next(p []byte) (n int, status string, err error) {
+	for {
+		if _, err = s.br.Peek(1); err != nil {
+			return 0, "", err
+		}
+		data, _ := s.br.Peek(s.br.Buffered())
+		if i := bytes.Index(data, s.token); i > 0 {
+			n = copy(p, data[:i])
+			_, _ = s.br.Discard(n)
+			return n, "", nil
+		} else if i == 0 {
+			line, err := s.br.ReadString('\n')
+			if err != nil {
+				return 0, "", err
+			}
+			status = strings.TrimSpace(line[len(s.token):])
+			return 0, status, nil
+		}
+		if safe := len(data) - partial(data, s.token); safe > 0 {
+			n = copy(p, data[:safe])
+			_, _ = s.br.Discard(n)
+			return n, "", nil
+		}
+		// All buffered data may begin the token. Wait for more.
+		if _, err = s.br.Peek(len(data) + 1); err != nil {
+			return 0, "", err
+		}
+	}
+}
+
+// partial returns the length of the longest suffix of data that is a
+// proper prefix of token.
+func partial(data, token []byte) int {
+	for k := min(len(data), len(token)-1); k > 0; k-- {
+		if bytes.HasSuffix(token[:k], data[len(data)-k:]) {
+			return k
+		}
+	}
+	return 0
+}
+
+// demux routes a shell's stderr to the log of the running command,
+// signaling mark each time the sentinel token passes.
+type demux struct {
+	sync.Mutex
+	token []byte
+	dst   io.Writer
+	pend  []byte
+	mark  chan struct{}
+}
+
+func newDemux(token string) *demux {
+	return &demux{token: []byte(token), mark: make(chan struct{}, 1)}
+}
+
+func (d *demux) set(w io.Writer) {
+	d.Lock()
+	defer d.Unlock()
+	d.dst = w
+}
+
+func (d *demux) Write(p []byte) (int, error) {
+	d.Lock()
+	defer d.Unlock()
+	d.pend = append(d.pend, p...)
+	for {
+		i := bytes.Index(d.pend, d.token)
+		if i < 0 {
+			break
+		}
+		d.emit(d.pend[:i])
+		d.pend = bytes.TrimPrefix(d.pend[i+len(d.token):], []byte("\n"))
+		d.mark <- struct{}{}
+	}
+	safe := len(d.pend) - partial(d.pend, d.token)
+	d.emit(d.pend[:safe])
+	d.pend = append(d.pend[:0], d.pend[safe:]...)
+	return len(p), nil
+}
+
+func (d *demux) emit(p []byte) {
+	if d.dst != nil && len(p) > 0 {
+		_, _ = d.dst.Write(p)
+	}
+}
@@ -0,0 +1,70 @@
+package session
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestSentinelReaderSplits(t *testing.T) {
+	const tok = "__tok__"
+	in := "first" + tok + " 0\nsecond\n" + tok + " 2\n"
+	s := newSentinelReader(iotest.OneByteReader(strings.NewReader(in)), tok)
+
+	for _, want := range []struct{ out, status string }{
+		{"first", "0"},
+		{"second\n", "2"},
+	} {
+		var out strings.Builder
+		p := make([]byte, 3)
+		for {
+			n, status, err := s.next(p)
+			if err != nil {
+				t.Fatalf("next() err: %v", err)
+			}
+			out.Write(p[:n])
+			if status != "" {
+				if status != want.status {
+					t.Errorf("status = %q, want %q", status, want.status)
+				}
+				break
+			}
+		}
+		if got := out.String(); got != want.out {
+			t.Errorf("output = %q, want %q", got, want.out)
+		}
+	}
+	if _, _, err := s.next(make([]byte, 1)); err != io.EOF {
+		t.Errorf("next() at end err = %v, want io.EOF", err)
+	}
+}
+
+func TestDemuxRoutes(t *testing.T) {
+	const tok = "__tok__"
+	d := newDemux(tok)
+	var a, b strings.Builder
+
+	d.set(&a)
+	for _, c := range "one\n__t" {
+		if _, err := d.Write([]byte(string(c))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := d.Write([]byte("ok__\n")); err != nil {
+		t.Fatal(err)
+	}
+	<-d.mark
+	d.set(&b)
+	if _, err := d.Write([]byte("two\n" + tok + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	<-d.mark
+
+	if got, want := a.String(), "one\n"; got != want {
+		t.Errorf("first log = %q, want %q", got, want)
+	}
+	if got, want := b.String(), "two\n"; got != want {
+		t.Errorf("second log = %q, want %q", got, want)
+	}
+}
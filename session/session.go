@@ -0,0 +1,404 @@
+// Package session implements a command.Machine that runs commands
+// through a single long-lived shell process.
+//
+// Starting a process is expensive on some machines: each command on an
+// ssh.Machine is a new connection, and each command on a ctr.Machine is
+// a new exec through the container runtime. A session machine starts
+// one shell on the underlying machine, POSIX sh or, on Windows,
+// PowerShell, and sends every command to it, framing each command's
+// output and exit status with a random sentinel.
+//
+//	host := ssh.Machine(sys.Machine(), "ssh", "build.example.com")
+//	m := session.Machine(host)
+//	defer command.Shutdown(ctx, m)
+//
+// Commands run one at a time, and working directories and environment
+// variables from the context apply to that command alone: sh runs each
+// command in its own subshell, and PowerShell restores its environment
+// and location after each. Commands read no input: their stdin is
+// empty, and their buffers do not implement [command.WriteBuffer].
+// Filesystem access goes directly to the underlying machine.
+//
+// Commands must be read to the end or closed: a command holds the shell
+// until then. If a command's context is canceled, or it is closed before
+// it finishes, the shell running it is killed and the next command
+// starts a new one.
+//
+// On Windows, commands run as native programs of PowerShell, whose output
+// passes through unchanged. Their diagnostic output is not merged into
+// their output, and redirecting their output to files, as with
+// command.WithStdoutFile, fails with an error wrapping
+// errors.ErrUnsupported.
+package session
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+
+	"lesiw.io/command"
+	"lesiw.io/command/internal/sh"
+	"lesiw.io/fs"
+)
+
+// identRE matches the names of shell variables.
+var identRE = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Machine returns a command.Machine that runs commands on m through a
+// single long-lived shell process.
+func Machine(m command.Machine) command.Machine {
+	return &machine{m: m}
+}
+
+type machine struct {
+	m command.Machine
+
+	run sync.Mutex // Held while a command runs.
+
+	mu      sync.Mutex
+	sh      *shell
+	once    sync.Once
+	windows bool
+}
+
+func (m *machine) Command(ctx context.Context, arg ...string) command.Buffer {
+	if len(arg) == 0 {
+		return command.Fail(errors.New("no command given"))
+	}
+	m.once.Do(func() { m.windows = command.OS(ctx, m.m) == "windows" })
+	if m.windows &&
+		(command.StdoutFile(ctx) != "" || command.StderrFile(ctx) != "") {
+		return command.Fail(&command.Error{Err: fmt.Errorf(
+			"session: redirecting output on windows: %w",
+			errors.ErrUnsupported)})
+	}
+	env := command.Envs(ctx)
+	for k := range env {
+		if !identRE.MatchString(k) {
+			return command.Fail(&command.Error{Err: fmt.Errorf(
+				"session: bad environment variable name %q", k)})
+		}
+	}
+	return &cmd{
+		m:     m,
+		ctx:   ctx,
+		args:  arg,
+		env:   env,
+		merge: command.MergedStderr(ctx),
+	}
+}
+
+var _ command.FSMachine = (*machine)(nil)
+
+func (m *machine) FS() fs.FS { return command.FS(m.m) }
+
+var _ command.OSMachine = (*machine)(nil)
+
+func (m *machine) OS(ctx context.Context) string {
+	return command.OS(ctx, m.m)
+}
+
+var _ command.ArchMachine = (*machine)(nil)
+
+func (m *machine) Arch(ctx context.Context) string {
+	return command.Arch(ctx, m.m)
+}
+
+var _ command.ShutdownMachine = (*machine)(nil)
+
+// Shutdown ends the session's shell and shuts down the underlying
+// machine.
+func (m *machine) Shutdown(ctx context.Context) error {
+	m.mu.Lock()
+	s := m.sh
+	m.sh = nil
+	m.mu.Unlock()
+
+	var err error
+	if s != nil {
+		err = s.close()
+	}
+	return errors.Join(err, command.Shutdown(ctx, m.m))
+}
+
+// shell returns the running shell, starting one if needed.
+func (m *machine) shell(ctx context.Context) (*shell, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sh != nil && m.sh.ctx.Err() == nil {
+		return m.sh, nil
+	}
+	s, err := startShell(ctx, m.m, m.windows)
+	if err != nil {
+		return nil, err
+	}
+	m.sh = s
+	return s, nil
+}
+
+type shell struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	in      command.WriteBuffer
+	out     *sentinelReader
+	errs    *demux
+	token   string
+	windows bool // Whether the shell is PowerShell.
+}
+
+func startShell(
+	ctx context.Context, m command.Machine, windows bool,
+) (*shell, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, fmt.Errorf("session token: %w", err)
+	}
+	token := "__cmdsession_" + hex.EncodeToString(b[:]) + "__"
+
+	ctx = command.WithoutEnv(context.WithoutCancel(ctx))
+	ctx = command.WithoutCommandOptions(fs.WithoutWorkDir(ctx))
+	ctx, cancel := context.WithCancel(ctx)
+	args := []string{"sh"}
+	if windows {
+		args = psArgs
+	}
+	buf := m.Command(ctx, args...)
+	in, ok := buf.(command.WriteBuffer)
+	if !ok {
+		cancel()
+		return nil, errors.New("session: shell does not accept input")
+	}
+	s := &shell{
+		ctx:     ctx,
+		cancel:  cancel,
+		in:      in,
+		out:     newSentinelReader(buf, token),
+		errs:    newDemux(token),
+		token:   token,
+		windows: windows,
+	}
+	command.Log(buf, s.errs)
+	return s, nil
+}
+
+func (s *shell) kill() { s.cancel() }
+
+func (s *shell) close() error {
+	defer s.cancel()
+	if err := s.in.Close(); err != nil {
+		return err
+	}
+	_, err := io.Copy(io.Discard, s.out.r)
+	return err
+}
+
+// script returns the shell input that runs args and reports its status.
+func (s *shell) script(
+	dir string, env map[string]string, redirect string, args ...string,
+) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "if command -v %s >/dev/null 2>&1; then (",
+		sh.Quote(args[0]))
+	for k, v := range env {
+		fmt.Fprintf(&b, "export %s=%s; ", k, sh.Quote(v))
+	}
+	if dir != "" {
+		fmt.Fprintf(&b, "cd %s && ", sh.Quote(dir))
+	}
+	fmt.Fprintf(&b, "exec %s%s", sh.Join(args), redirect)
+	b.WriteString(") </dev/null; ")
+	fmt.Fprintf(&b, "printf '%%s %%d\\n' %s \"$?\"; ", s.token)
+	fmt.Fprintf(&b, "else printf '%%s notfound\\n' %s; fi; ", s.token)
+	fmt.Fprintf(&b, "printf '%%s\\n' %s >&2\n", s.token)
+	return b.String()
+}
+
+type cmd struct {
+	m     *machine
+	ctx   context.Context
+	args  []string
+	env   map[string]string
+	merge bool
+
+	log    io.Writer
+	logbuf bytes.Buffer
+
+	sh       *shell
+	stop     func() bool
+	released sync.Once
+
+	mu      sync.Mutex // Guards started, running, and err, for Close.
+	started bool
+	running bool  // Holding the shell, until finished or closed.
+	err     error // Terminal error, or io.EOF.
+}
+
+// errClosed is returned by reads of a closed command.
+var errClosed = errors.New("session: read of closed command")
+
+func (c *cmd) Log(w io.Writer) { c.log = w }
+
+func (c *cmd) String() string { return sh.String(c.env, c.args...).String() }
+
+// Close stops the command if it is still running, killing its shell, so
+// that the next command can run.
+func (c *cmd) Close() error {
+	c.mu.Lock()
+	running := c.running && c.err == nil
+	c.started = true
+	if c.err == nil {
+		c.err = errClosed
+	}
+	c.mu.Unlock()
+	if running {
+		c.sh.kill()
+		c.release()
+	}
+	return nil
+}
+
+func (c *cmd) Read(p []byte) (int, error) {
+	c.mu.Lock()
+	if err := c.err; err != nil {
+		c.mu.Unlock()
+		return 0, err
+	}
+	started := c.started
+	c.started = true
+	c.mu.Unlock()
+	if !started {
+		// Starting waits for the command before, which Close must not.
+		if err := c.begin(); err != nil {
+			return 0, err
+		}
+	}
+	n, status, err := c.sh.out.next(p)
+	if err != nil {
+		if cerr := c.ctx.Err(); cerr != nil {
+			err = cerr
+		} else if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		c.sh.kill()
+		c.sh.errs.set(nil)
+		return n, c.finish(command.Interrupted(c.ctx, &command.Error{
+			Err: fmt.Errorf("session: %w", err),
+			Log: c.capturedLog(),
+		}))
+	}
+	if status == "" {
+		return n, nil
+	}
+
+	// Wait for stderr to drain before reporting the outcome and handing
+	// the shell to the next command.
+	select {
+	case <-c.sh.errs.mark:
+	case <-c.sh.ctx.Done():
+	}
+	c.sh.errs.set(nil)
+	switch status {
+	case "0":
+		err = io.EOF
+	case "notfound":
+		err = &command.Error{
+			Err: fmt.Errorf("command not found: %s", c.args[0]),
+		}
+	default:
+		e := &command.Error{Log: c.capturedLog()}
+		if _, err := fmt.Sscan(status, &e.Code); err != nil {
+			e.Err = fmt.Errorf("session: bad status %q", status)
+		}
+		err = e
+	}
+	return n, c.finish(err)
+}
+
+// finish records err as the outcome of c, unless it was closed first,
+// and hands the shell to the next command. It returns the outcome.
+func (c *cmd) finish(err error) error {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	err = c.err
+	c.mu.Unlock()
+	c.release()
+	return err
+}
+
+// begin starts c, unless it is closed before it can.
+func (c *cmd) begin() error {
+	err := c.start()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.err == nil {
+		c.err = err
+	} else if err == nil {
+		// Closed while waiting for the shell.
+		c.sh.kill()
+		c.release()
+	}
+	if c.err != nil {
+		return c.err
+	}
+	c.running = true
+	return nil
+}
+
+// start waits for the shell and starts c in it.
+func (c *cmd) start() error {
+	c.m.run.Lock()
+	s, err := c.m.shell(c.ctx)
+	if err != nil {
+		c.m.run.Unlock()
+		return err
+	}
+	c.sh = s
+	if c.log != nil {
+		s.errs.set(c.log)
+	} else {
+		s.errs.set(&c.logbuf)
+	}
+	c.stop = context.AfterFunc(c.ctx, s.kill)
+	var script string
+	if s.windows {
+		script = s.psScript(fs.WorkDir(c.ctx), c.env, c.args...)
+	} else {
+		redirect := sh.Redirect(
+			command.StdoutFile(c.ctx), command.StderrFile(c.ctx), c.merge,
+		)
+		script = s.script(fs.WorkDir(c.ctx), c.env, redirect, c.args...)
+	}
+	if _, err := io.WriteString(s.in, script); err != nil {
+		s.kill()
+		c.release()
+		return &command.Error{Err: fmt.Errorf("session: %w", err)}
+	}
+	return nil
+}
+
+// release hands the shell to the next command, once.
+func (c *cmd) release() {
+	c.released.Do(func() {
+		if c.stop != nil {
+			c.stop()
+		}
+		c.sh.errs.set(nil)
+		c.m.run.Unlock()
+	})
+}
+
+func (c *cmd) capturedLog() []byte {
+	if c.log != nil {
+		return nil
+	}
+	return c.logbuf.Bytes()
+}
@@ -0,0 +1,388 @@
+package session_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+	"lesiw.io/command/session"
+	"lesiw.io/command/sys"
+	"lesiw.io/fs"
+)
+
+func sessionMachine(t *testing.T) command.Machine {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("session machines need sh")
+	}
+	m := session.Machine(sys.Machine())
+	t.Cleanup(func() {
+		if err := command.Shutdown(t.Context(), m); err != nil {
+			t.Errorf("command.Shutdown() err: %v", err)
+		}
+	})
+	return m
+}
+
+func TestSessionRead(t *testing.T) {
+	m := sessionMachine(t)
+
+	for _, want := range []string{"hello", "it's 'quoted'", "a\nb"} {
+		got, err := command.Read(t.Context(), m, "printf", "%s", want)
+		if err != nil {
+			t.Fatalf("command.Read(%q) err: %v", want, err)
+		}
+		if got != want {
+			t.Errorf("command.Read(%q) = %q", want, got)
+		}
+	}
+}
+
+func TestSessionOutputWithoutNewline(t *testing.T) {
+	m := sessionMachine(t)
+
+	out, err := io.ReadAll(m.Command(t.Context(), "printf", "no newline"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(out), "no newline"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestSessionReusesShell(t *testing.T) {
+	m := sessionMachine(t)
+
+	first, err := command.Read(t.Context(), m, "sh", "-c", "echo $PPID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := command.Read(t.Context(), m, "sh", "-c", "echo $PPID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != second {
+		t.Errorf("parent pids differ: %q != %q", first, second)
+	}
+}
+
+func TestSessionExitCode(t *testing.T) {
+	m := sessionMachine(t)
+
+	err := command.Do(t.Context(), m, "sh", "-c", "echo oops >&2; exit 3")
+	var cmdErr *command.Error
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("command.Do err: got %v, want *command.Error", err)
+	}
+	if got, want := cmdErr.Code, 3; got != want {
+		t.Errorf("Code = %d, want %d", got, want)
+	}
+	if got, want := string(cmdErr.Log), "oops\n"; got != want {
+		t.Errorf("Log = %q, want %q", got, want)
+	}
+
+	// The session survives a failed command.
+	if err := command.Do(t.Context(), m, "true"); err != nil {
+		t.Errorf("command.Do(true) after failure err: %v", err)
+	}
+}
+
+func TestSessionLog(t *testing.T) {
+	m := sessionMachine(t)
+
+	var log bytes.Buffer
+	buf := m.Command(t.Context(), "sh", "-c", "echo out; echo err >&2")
+	command.Log(buf, &log)
+	out, err := io.ReadAll(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(out), "out\n"; got != want {
+		t.Errorf("stdout = %q, want %q", got, want)
+	}
+	if got, want := log.String(), "err\n"; got != want {
+		t.Errorf("stderr = %q, want %q", got, want)
+	}
+}
+
+func TestSessionNotFound(t *testing.T) {
+	m := sessionMachine(t)
+
+	err := command.Do(t.Context(), m, "command-that-does-not-exist")
+	if !command.NotFound(err) {
+		t.Errorf("command.Do err: got %v, want not found", err)
+	}
+}
+
+func TestSessionEnvAndWorkDir(t *testing.T) {
+	m := sessionMachine(t)
+	dir := t.TempDir()
+
+	ctx := command.WithEnv(t.Context(), map[string]string{"GREETING": "hi"})
+	ctx = fs.WithWorkDir(ctx, dir)
+	out, err := command.Read(ctx, m, "sh", "-c", `echo "$GREETING $PWD"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "hi ") || !strings.HasSuffix(out, dir) {
+		t.Errorf("output = %q, want %q", out, "hi "+dir)
+	}
+
+	// Neither applies to the next command.
+	out, err = command.Read(t.Context(), m, "sh", "-c", `echo "$GREETING"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "" {
+		t.Errorf("GREETING = %q, want empty", out)
+	}
+}
+
+func TestSessionCancelRestartsShell(t *testing.T) {
+	m := sessionMachine(t)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	r := m.Command(ctx, "sleep", "60")
+	cancel()
+	if _, err := io.ReadAll(r); err == nil {
+		t.Fatal("canceled command: got nil error")
+	}
+
+	out, err := command.Read(t.Context(), m, "echo", "again")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out, "again"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestSessionCombinedOutput(t *testing.T) {
+	m := sessionMachine(t)
+
+	out, err := command.CombinedOutput(t.Context(), m,
+		"sh", "-c", "echo out; echo err >&2; echo out")
+	if err != nil {
+		t.Fatalf("command.CombinedOutput() err: %v", err)
+	}
+	if want := "out\nerr\nout"; out != want {
+		t.Errorf("command.CombinedOutput() = %q, want %q", out, want)
+	}
+}
+
+func TestSessionStdoutFile(t *testing.T) {
+	m := sessionMachine(t)
+	name := filepath.Join(t.TempDir(), "out.txt")
+	ctx := command.WithStdoutFile(t.Context(), name)
+
+	// The first command starts the shell, which must not be redirected.
+	out, err := command.Read(ctx, m, "echo", "hello")
+	if err != nil {
+		t.Fatalf("command.Read err: %v", err)
+	}
+	if out != "" {
+		t.Errorf("command.Read = %q, want no output", out)
+	}
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello\n" {
+		t.Errorf("out.txt = %q, want %q", got, "hello\n")
+	}
+	out, err = command.Read(t.Context(), m, "echo", "again")
+	if err != nil || out != "again" {
+		t.Errorf("command.Read = %q, %v, want %q", out, err, "again")
+	}
+}
+
+func TestSessionCloseReleasesShell(t *testing.T) {
+	m := sessionMachine(t)
+
+	r := m.Command(t.Context(), "sh", "-c", "echo started; sleep 60")
+	buf := make([]byte, len("started\n"))
+	if _, err := io.ReadFull(r, buf); err != nil {
+		t.Fatalf("read first line: %v", err)
+	}
+	if err := r.(io.Closer).Close(); err != nil {
+		t.Fatalf("Close err: %v", err)
+	}
+	if _, err := r.Read(buf); err == nil {
+		t.Error("read after Close: got nil error")
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+	out, err := command.Read(ctx, m, "echo", "again")
+	if err != nil {
+		t.Fatalf("command after Close: %v", err)
+	}
+	if got, want := out, "again"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestSessionCloseWhileWaiting(t *testing.T) {
+	m := sessionMachine(t)
+
+	first := m.Command(t.Context(), "sh", "-c", "echo started; sleep 60")
+	buf := make([]byte, len("started\n"))
+	if _, err := io.ReadFull(first, buf); err != nil {
+		t.Fatalf("read first line: %v", err)
+	}
+	second := m.Command(t.Context(), "echo", "second")
+	read := make(chan error, 1)
+	go func() {
+		_, err := second.Read(buf)
+		read <- err
+	}()
+	time.Sleep(50 * time.Millisecond) // Let the read wait for the shell.
+
+	closed := make(chan error, 1)
+	go func() { closed <- second.(io.Closer).Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatalf("Close err: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked while the command waited for the shell")
+	}
+	if err := first.(io.Closer).Close(); err != nil {
+		t.Fatalf("Close err: %v", err)
+	}
+	select {
+	case err := <-read:
+		if err == nil {
+			t.Error("read of closed command: got nil error")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("read of closed command blocked")
+	}
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+	out, err := command.Read(ctx, m, "echo", "again")
+	if err != nil {
+		t.Fatalf("command after Close: %v", err)
+	}
+	if got, want := out, "again"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
+
+func TestSessionBadEnvName(t *testing.T) {
+	m := sessionMachine(t)
+	ctx := command.WithEnv(t.Context(), map[string]string{
+		"A;touch injected": "x",
+	})
+
+	err := command.Do(ctx, m, "true")
+	if err == nil || !strings.Contains(err.Error(), "environment variable") {
+		t.Errorf("command.Do err = %v, want bad variable name", err)
+	}
+}
+
+func TestSessionWindowsStdoutFile(t *testing.T) {
+	host := new(mock.Machine)
+	host.SetOS("windows")
+	m := session.Machine(host)
+
+	ctx := command.WithStdoutFile(t.Context(), `C:\out.txt`)
+	err := command.Do(ctx, m, "cmd", "/c", "ver")
+	if !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("command.Do err = %v, want ErrUnsupported", err)
+	}
+	if calls := mock.Calls(host); len(calls) > 0 {
+		t.Errorf("host calls = %v, want none", calls)
+	}
+}
+
+// A fakePS stands in for PowerShell. It records each line of its input,
+// and answers it with the output of a successful command.
+type fakePS struct {
+	r     *io.PipeReader
+	w     *io.PipeWriter
+	log   io.Writer
+	lines []string
+}
+
+var tokenRE = regexp.MustCompile(`__cmdsession_[0-9a-f]+__`)
+
+func newFakePS() *fakePS {
+	ps := new(fakePS)
+	ps.r, ps.w = io.Pipe()
+	return ps
+}
+
+func (ps *fakePS) Read(p []byte) (int, error) { return ps.r.Read(p) }
+func (ps *fakePS) Log(w io.Writer)            { ps.log = w }
+func (ps *fakePS) Close() error               { return ps.w.Close() }
+
+func (ps *fakePS) Write(p []byte) (int, error) {
+	line := string(p)
+	ps.lines = append(ps.lines, line)
+	token := tokenRE.FindString(line)
+	go func() {
+		_, _ = io.WriteString(ps.w, "out\r\n"+token+" 0\n")
+		_, _ = io.WriteString(ps.log, token+"\n")
+	}()
+	return len(p), nil
+}
+
+func TestSessionWindowsPowerShell(t *testing.T) {
+	host := new(mock.Machine)
+	host.SetOS("windows")
+	ps := newFakePS()
+	var args []string
+	host.Do(func(_ context.Context, arg ...string) command.Buffer {
+		args = arg
+		return ps
+	}, "powershell")
+	m := session.Machine(host)
+
+	ctx := command.WithEnv(t.Context(), map[string]string{"MODE": "it's"})
+	ctx = fs.WithWorkDir(ctx, `C:\src`)
+	for range 2 {
+		out, err := command.Read(ctx, m, "cmd", "/c", `echo "a b"`)
+		if err != nil {
+			t.Fatalf("command.Read err: %v", err)
+		}
+		if out != "out" {
+			t.Errorf("output = %q, want %q", out, "out")
+		}
+	}
+	want := []string{"powershell", "-NoProfile", "-NonInteractive",
+		"-Command", "-"}
+	if !slices.Equal(args, want) {
+		t.Errorf("shell args = %q, want %q", args, want)
+	}
+	if len(ps.lines) != 2 {
+		t.Fatalf("shell input = %q, want 2 lines", ps.lines)
+	}
+	line := ps.lines[0]
+	if strings.Count(line, "\n") != 1 || !strings.HasSuffix(line, "\n") {
+		t.Errorf("script = %q, want one line", line)
+	}
+	for _, want := range []string{
+		`[Environment]::SetEnvironmentVariable('MODE', 'it''s')`,
+		`Push-Location -LiteralPath 'C:\src'`,
+		`$null | & 'cmd' '/c' 'echo \"a b\"'`,
+		"Pop-Location",
+	} {
+		if !strings.Contains(line, want) {
+			t.Errorf("script = %q, want it to contain %q", line, want)
+		}
+	}
+}
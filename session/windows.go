@@ -0,0 +1,80 @@
+package session
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// psArgs are the arguments that start a PowerShell that reads its
+// commands from its input.
+var psArgs = []string{"powershell", "-NoProfile", "-NonInteractive",
+	"-Command", "-"}
+
+// psScript returns the PowerShell input that runs args and reports its
+// status. PowerShell runs each line of its input as it reads it, so the
+// script is one line. PowerShell has no subshells: the environment and
+// location of the command are set in the shell itself, and restored
+// afterward.
+//
+// The command is the last in its pipeline, so its output is not captured
+// by PowerShell, and passes through unchanged. Its input is $null, so
+// that it does not read the input of the shell.
+func (s *shell) psScript(
+	dir string, env map[string]string, args ...string,
+) string {
+	var b strings.Builder
+	b.WriteString("$__e = @{}; ")
+	for k, v := range env {
+		fmt.Fprintf(&b, "$__e['%s'] = $env:%s; ", k, k)
+		fmt.Fprintf(&b, "[Environment]::SetEnvironmentVariable('%s', %s); ",
+			k, psQuote(v))
+	}
+	b.WriteString("$__s = 'notfound'; ")
+	fmt.Fprintf(&b, "if (Get-Command -CommandType Application "+
+		"-ErrorAction Ignore -Name "+
+		"([Management.Automation.WildcardPattern]::Escape(%s))) { ",
+		psQuote(args[0]))
+	b.WriteString("$__s = 1; try { ")
+	if dir != "" {
+		fmt.Fprintf(&b, "Push-Location -LiteralPath %s -ErrorAction Stop; ",
+			psQuote(dir))
+	}
+	b.WriteString("try { $null | &")
+	for _, arg := range args {
+		b.WriteString(" " + psQuote(psNativeEscape(arg)))
+	}
+	b.WriteString("; $__s = $LASTEXITCODE } finally { ")
+	if dir != "" {
+		b.WriteString("Pop-Location")
+	}
+	b.WriteString(" } } catch { [Console]::Error.WriteLine($_) } }; ")
+	b.WriteString("foreach ($__k in $__e.Keys) { " +
+		"[Environment]::SetEnvironmentVariable($__k, $__e[$__k]) }; ")
+	// Sentinels end in a bare newline, as the readers expect.
+	fmt.Fprintf(&b, "[Console]::Out.Write(\"%s $__s`n\"); ", s.token)
+	fmt.Fprintf(&b, "[Console]::Error.Write(\"%s`n\")\n", s.token)
+	return b.String()
+}
+
+// psQuote quotes a string as a PowerShell single-quoted literal,
+// in which the only special character is the quote itself.
+func psQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+var psQuoteRun = regexp.MustCompile(`(\\*)"`)
+
+// psNativeEscape prepares an argument for Windows PowerShell's native
+// command invocation, which passes embedded double quotes to programs
+// unescaped. Quotes are escaped, doubling any backslashes before them,
+// and a trailing backslash run is doubled when the argument contains
+// whitespace, since PowerShell wraps such arguments in quotes.
+func psNativeEscape(s string) string {
+	e := psQuoteRun.ReplaceAllString(s, `$1$1\"`)
+	if strings.ContainsAny(e, " \t") {
+		trail := len(e) - len(strings.TrimRight(e, `\`))
+		e += strings.Repeat(`\`, trail)
+	}
+	return e
+}
@@ -6,6 +6,9 @@ package command
 import (
 	"context"
 	"io"
+	"io/fs"
+	"net"
+	"time"
 )
 
 // The methods below are convenience wrappers that delegate to lesiw.io/command
@@ -14,6 +17,133 @@ import (
 //
 // command operations.
 
+// Bridge runs srcArgs on src and dstArgs on dst, streaming the output of
+// the first into the input of the second, as a shell pipeline would if
+// both commands ran on one machine. The output of dst is discarded.
+//
+//	err := command.Bridge(ctx,
+//	    prod, []string{"pg_dump", "app"},
+//	    db, []string{"psql", "app"},
+//	)
+//
+// If either command fails, the other is stopped: dst does not see the
+// partial output of a failed src as the end of its input. The returned
+// error is a [*BridgeError] that attributes the failure to its side. If
+// dst completes before reading all of src's output, src is stopped
+// without error, as with a broken pipe.
+//
+// This is a convenience method that calls [Bridge].
+func (sh *Sh) Bridge(
+	ctx context.Context, srcArgs []string, dst Machine, dstArgs []string,
+) error {
+	return Bridge(ctx, sh, srcArgs, dst, dstArgs)
+}
+
+// Cmd returns an ExecCmd that runs args on m with ctx, for libraries that
+// accept values shaped like exec.Cmd:
+//
+//	cmd := command.Cmd(ctx, m, "pg_dump", "app")
+//	r, err := cmd.StdoutPipe()
+//	if err != nil {
+//	    return err
+//	}
+//	if err := cmd.Start(); err != nil {
+//	    return err
+//	}
+//	_, err = io.Copy(dst, r)
+//	if werr := cmd.Wait(); err == nil {
+//	    err = werr
+//	}
+//
+// This is a convenience method that calls [Cmd].
+func (sh *Sh) Cmd(
+	ctx context.Context, args ...string,
+) *ExecCmd {
+	return Cmd(ctx, sh, args...)
+}
+
+// CombinedOutput executes a command and returns its output and
+// diagnostic output, merged, as a string.
+// Trailing newlines are stripped from the output.
+//
+// CombinedOutput asks the machine to merge diagnostic output into output
+// at its source, as if by 2>&1, using [WithMergedStderr]. Machines that
+// do not support merging have their diagnostic output interleaved with
+// their output one write at a time, as the machine delivers it.
+//
+// Because diagnostic output is part of the result, errors returned by
+// CombinedOutput do not include log output. Like [Read], CombinedOutput
+// returns the output gathered before a failure, and records it in the
+// error's Output field.
+//
+// This is a convenience method that calls [CombinedOutput].
+func (sh *Sh) CombinedOutput(
+	ctx context.Context, args ...string,
+) (string, error) {
+	return CombinedOutput(ctx, sh, args...)
+}
+
+// CommandConn returns a connection to the input and output of a command
+// on m, for tunnels that are commands, such as ssh -W or kubectl
+// port-forward with a relay. The command starts at once, and is canceled
+// when the connection is closed.
+//
+// Once the command finishes, reads return io.EOF if it succeeded, or its
+// error if it failed.
+//
+// This is a convenience method that calls [CommandConn].
+func (sh *Sh) CommandConn(
+	ctx context.Context, args ...string,
+) net.Conn {
+	return CommandConn(ctx, sh, args...)
+}
+
+// Complete returns the completions that the shell of m suggests for the
+// last of args, as if a user had typed args at a prompt on m and pressed
+// tab. Pass an empty last argument to list what may follow the others:
+//
+//	words, err := command.Complete(ctx, m, "git", "che")
+//	// [checkout cherry cherry-pick]
+//	words, err = command.Complete(ctx, m, "git", "checkout", "")
+//	// The branches of the repository in the working directory.
+//
+// On Unix, completion is done by bash, with the bash-completion package
+// if it is installed; without it, arguments complete as file names. On
+// Windows, it is done by PowerShell. The first argument completes as a
+// command name. Completions are in the order the shell gives them, with
+// duplicates removed, or nil if there are none.
+//
+// Complete is meant for interactive frontends to remote machines, which
+// cannot run the machine's own shell at a terminal.
+//
+// This is a convenience method that calls [Complete].
+func (sh *Sh) Complete(
+	ctx context.Context, args ...string,
+) ([]string, error) {
+	return Complete(ctx, sh, args...)
+}
+
+// Dial connects to addr on the named network from m, so that Go clients,
+// such as database/sql drivers and [http.Client], can talk to services
+// that only m can reach:
+//
+//	conn, err := command.Dial(ctx, m, "tcp", "localhost:5432")
+//
+// If m implements [DialMachine], Dial uses it. Otherwise the connection
+// is relayed by socat, nc, or bash on m, whichever it has, and only TCP
+// networks are supported; a failure to connect is reported by the first
+// Read or Write on the connection. Relayed connections are not supported
+// on Windows.
+//
+// The connection lasts until it is closed or ctx is done.
+//
+// This is a convenience method that calls [Dial].
+func (sh *Sh) Dial(
+	ctx context.Context, network string, addr string,
+) (net.Conn, error) {
+	return Dial(ctx, sh, network, addr)
+}
+
 // Do executes a command for its side effects, discarding output.
 // Only the error status is returned.
 //
@@ -26,6 +156,25 @@ func (sh *Sh) Do(
 	return Do(ctx, sh, args...)
 }
 
+// DoExpect executes a command for its side effects and returns its exit
+// code. It returns an error if the command exits with a code not in
+// codes, or if it fails to run at all.
+//
+//	code, err := command.DoExpect(ctx, m, []int{0, 1},
+//	    "diff", "-q", "old.txt", "new.txt",
+//	)
+//	if err != nil {
+//	    return err
+//	}
+//	changed := code == 1
+//
+// This is a convenience method that calls [DoExpect].
+func (sh *Sh) DoExpect(
+	ctx context.Context, codes []int, args ...string,
+) (int, error) {
+	return DoExpect(ctx, sh, codes, args...)
+}
+
 // Exec executes a command and waits for it to complete.
 // The command's output is attached to the controlling terminal.
 //
@@ -38,6 +187,146 @@ func (sh *Sh) Exec(
 	return Exec(ctx, sh, args...)
 }
 
+// Extract extracts the archive at src on m into the directory dst on m,
+// creating dst if needed. The format is chosen by src's extension:
+// .tar, .tar.gz or .tgz, .tar.zst or .tzst, and .zip.
+//
+// Extract runs the archiver found on m: tar, unzip, or PowerShell on
+// Windows, with zstd for zstd-compressed archives. If m has none, the
+// archive is streamed to the local process, unpacked, and written to
+// dst as a tar stream through m's filesystem. The local fallback does
+// not support zstd.
+//
+//	err := command.Extract(ctx, m, "/tmp/go.tar.gz", "/usr/local")
+//
+// This is a convenience method that calls [Extract].
+func (sh *Sh) Extract(
+	ctx context.Context, src string, dst string,
+) error {
+	return Extract(ctx, sh, src, dst)
+}
+
+// Fetch downloads url to the file dst on m, and verifies that the file
+// matches digest. Fetch fails without a digest.
+//
+// The download runs on m with the first downloader found there: curl or
+// wget, or PowerShell on Windows. If m has none, the local process
+// downloads url and streams it to m through its filesystem.
+// The file is downloaded beside dst and renamed into place only after it
+// is verified, so dst is never left holding unverified content.
+//
+//	err := command.Fetch(ctx, m,
+//	    "https://go.dev/dl/go1.24.7.linux-amd64.tar.gz", "/tmp/go.tar.gz",
+//	    command.SHA256(goSHA256),
+//	)
+//
+// This is a convenience method that calls [Fetch].
+func (sh *Sh) Fetch(
+	ctx context.Context, url string, dst string, digest Digest,
+) error {
+	return Fetch(ctx, sh, url, dst, digest)
+}
+
+// FromSlash returns name with each slash replaced by the path separator
+// of m. It returns name unchanged unless m is a Windows machine.
+//
+// This is a convenience method that calls [FromSlash].
+func (sh *Sh) FromSlash(
+	ctx context.Context, name string,
+) string {
+	return FromSlash(ctx, sh, name)
+}
+
+// IOFS returns a view of the file tree at root on m as an io/fs.FS, so
+// that code written for the standard library, such as fs.WalkDir and
+// template.ParseFS, can read the files of remote and container machines:
+//
+//	fsys := command.IOFS(ctx, m, "/etc/app")
+//	tmpl, err := template.ParseFS(fsys, "templates/*.tmpl")
+//
+// The view uses the filesystem of [FS], with ctx for each operation, so
+// it takes the fast paths of machines that have them. A relative root is
+// relative to the working directory of ctx. Names are as io/fs requires:
+// unrooted, slash-separated paths. Where the filesystem lists directories
+// with commands, their entries may report modification times only to the
+// minute.
+//
+// The returned FS also implements [WriteFS], for callers that need to
+// change the tree, and the ReadDirFS, ReadFileFS, StatFS, and SubFS
+// interfaces of io/fs.
+//
+// This is a convenience method that calls [IOFS].
+func (sh *Sh) IOFS(
+	ctx context.Context, root string,
+) fs.FS {
+	return IOFS(ctx, sh, root)
+}
+
+// Invalidate removes the stamp that [Once] recorded for key on m, if
+// there is one, so that its command runs again.
+//
+// This is a convenience method that calls [Invalidate].
+func (sh *Sh) Invalidate(
+	ctx context.Context, key string,
+) error {
+	return Invalidate(ctx, sh, key)
+}
+
+// Join joins path elements with the path separator of m, like
+// [path/filepath.Join] would if it ran on m. Empty elements are ignored,
+// and the result is cleaned. A trailing separator on the last element is
+// kept, since it marks a directory.
+//
+// Elements may use either separator on Windows machines.
+//
+//	command.Join(ctx, m, `C:\Users\me`, "src/app") // C:\Users\me\src\app
+//
+// This is a convenience method that calls [Join].
+func (sh *Sh) Join(
+	ctx context.Context, elem ...string,
+) string {
+	return Join(ctx, sh, elem...)
+}
+
+// Lock acquires the advisory lock called name on m, waiting until it is
+// free or ctx is done, and returns a function that releases it. Commands
+// that coordinate on a shared host, such as deployments from separate
+// CI jobs, hold the same lock to keep from running at once:
+//
+//	unlock, err := command.Lock(ctx, m, "deploy", time.Minute)
+//	if err != nil {
+//	    return err
+//	}
+//	defer unlock()
+//
+// The lock is a directory in the temporary directory of m, which any
+// machine with a file system can create atomically. While the lock is
+// held, it is renewed every third of ttl; a lock that has not been
+// renewed for ttl, as when its holder crashed, is taken over. A ttl of 0
+// means the lock never expires. Expiry is judged by the clocks of the
+// callers, so ttl should be well beyond any difference between them.
+//
+// A lock is advisory: it keeps out only those who ask for it.
+//
+// This is a convenience method that calls [Lock].
+func (sh *Sh) Lock(
+	ctx context.Context, name string, ttl time.Duration,
+) (func() error, error) {
+	return Lock(ctx, sh, name, ttl)
+}
+
+// NewFIFO creates a FIFO in a new temporary directory on m, with mkfifo.
+// The caller is responsible for removing it with [FIFO.Remove].
+//
+// FIFOs are not supported on Windows.
+//
+// This is a convenience method that calls [NewFIFO].
+func (sh *Sh) NewFIFO(
+	ctx context.Context,
+) (*FIFO, error) {
+	return NewFIFO(ctx, sh)
+}
+
 // NewFilter creates a bidirectional command filter with full
 // Read/Write/Close access.
 //
@@ -94,11 +383,34 @@ func (sh *Sh) NewWriter(
 	return NewWriter(ctx, sh, args...)
 }
 
+// Once runs a command on m, as [Do] does, unless it has already run
+// successfully there under key with the same arguments, working
+// directory, and inputs, as declared by [WithInputs]. It suits
+// provisioning and code generation steps that are slow to repeat:
+//
+//	ctx := command.WithInputs(ctx, "api.proto")
+//	err := command.Once(ctx, m, "gen-api",
+//	    "protoc", "--go_out=.", "api.proto")
+//
+// Once records a stamp for key in the user's cache directory on m, once
+// the command succeeds. A command that fails leaves no stamp, so it runs
+// again the next time. [Invalidate] removes a stamp, so that the command
+// runs again regardless.
+//
+// This is a convenience method that calls [Once].
+func (sh *Sh) Once(
+	ctx context.Context, key string, args ...string,
+) error {
+	return Once(ctx, sh, key, args...)
+}
+
 // Read executes a command and returns its output as a string.
 // Trailing newlines are stripped from the output.
 // For exact output, use [io.ReadAll].
 //
-// If the command fails, the error will contain an exit code and log output.
+// If the command fails, the error will contain an exit code and log output,
+// and the output read so far is returned along with it. The output is also
+// recorded in the error's Output field, so it survives being passed up.
 //
 // This is a convenience method that calls [Read].
 func (sh *Sh) Read(
@@ -106,3 +418,130 @@ func (sh *Sh) Read(
 ) (string, error) {
 	return Read(ctx, sh, args...)
 }
+
+// Reattach returns a handle to the process whose ID is recorded in
+// pidfile on m. The pidfile is read with m's filesystem, so relative
+// paths are relative to the working directory of ctx.
+//
+// Reattach does not check that the process is still running; see
+// [Process.Running].
+//
+// This is a convenience method that calls [Reattach].
+func (sh *Sh) Reattach(
+	ctx context.Context, pidfile string,
+) (*Process, error) {
+	return Reattach(ctx, sh, pidfile)
+}
+
+// Run executes the command described by spec and waits for it to
+// complete.
+//
+// [Do], [Read], and [Exec] are shorthands for common Specs.
+//
+//	err := command.Run(ctx, m, command.Spec{
+//	    Args:   []string{"tr", "a-z", "A-Z"},
+//	    Stdin:  strings.NewReader("hello"),
+//	    Stdout: os.Stdout,
+//	})
+//
+// This is a convenience method that calls [Run].
+func (sh *Sh) Run(
+	ctx context.Context, spec Spec,
+) error {
+	return Run(ctx, sh, spec)
+}
+
+// RunLeased runs spec on m, as [Run] does, under a lease that the caller
+// renews every third of ttl for as long as the command runs. A watchdog
+// on m kills the command once the lease goes ttl without renewal, as when
+// the caller crashed or lost its connection to m, so that long jobs do
+// not outlive the process that started them:
+//
+//	err := command.RunLeased(ctx, m, time.Minute, command.Spec{
+//	    Args: []string{"./migrate", "--all"},
+//	})
+//
+// Renewals are timed by the clock of m, in whole seconds, so ttl must be
+// at least a second. A command killed by the watchdog fails with an error
+// that wraps [ErrLeaseExpired]. The watchdog signals only the command's
+// own process, not processes it started.
+//
+// Leases are not supported on Windows.
+//
+// This is a convenience method that calls [RunLeased].
+func (sh *Sh) RunLeased(
+	ctx context.Context, ttl time.Duration, spec Spec,
+) error {
+	return RunLeased(ctx, sh, ttl, spec)
+}
+
+// Snapshot saves the files and directories at paths on m, so that a
+// later Restore can undo changes made to them.
+//
+// If m, or the machine beneath a Shell, implements [SnapshotMachine],
+// the whole machine is saved instead.
+// Otherwise, each path is copied to a temporary directory on m.
+// Restoring recreates each path exactly as it was: paths that did not
+// exist are removed, and files regain their saved permissions.
+//
+// Environment variables live in the context, which is immutable, so
+// they need no snapshot: keep the parent context to return to it.
+//
+//	snap, err := command.Snapshot(ctx, m, "/etc/app/")
+//	if err != nil {
+//	    return err
+//	}
+//	defer snap.Discard(ctx)
+//	if err := provision(ctx, m); err != nil {
+//	    return errors.Join(err, snap.Restore(ctx))
+//	}
+//
+// This is a convenience method that calls [Snapshot].
+func (sh *Sh) Snapshot(
+	ctx context.Context, paths ...string,
+) (Snap, error) {
+	return Snapshot(ctx, sh, paths...)
+}
+
+// ToSlash returns name with each path separator of m replaced by a slash.
+// It returns name unchanged unless m is a Windows machine.
+//
+// This is a convenience method that calls [ToSlash].
+func (sh *Sh) ToSlash(
+	ctx context.Context, name string,
+) string {
+	return ToSlash(ctx, sh, name)
+}
+
+// WaitHTTP waits until a GET of url from m returns status, or any 2xx
+// status if status is 0. It gives up after timeout, or when ctx is done,
+// if that is sooner; a timeout of 0 waits as long as ctx allows.
+//
+// The request is made on m, with curl or wget on Unix and PowerShell on
+// Windows, so that services in a container or on a remote host can be
+// checked from where they run, inside their network:
+//
+//	m := ctr.Machine(sys.Machine(), "nginx")
+//	err := command.WaitHTTP(ctx, m, "http://localhost/", 200, time.Minute)
+//
+// This is a convenience method that calls [WaitHTTP].
+func (sh *Sh) WaitHTTP(
+	ctx context.Context, url string, status int, timeout time.Duration,
+) error {
+	return WaitHTTP(ctx, sh, url, status, timeout)
+}
+
+// WaitTCP waits until a TCP connection from m to addr, a host and port
+// such as "localhost:5432", succeeds. It gives up after timeout, or when
+// ctx is done, if that is sooner; a timeout of 0 waits as long as ctx
+// allows.
+//
+// Like [WaitHTTP], it connects from m itself, with bash or nc on Unix and
+// PowerShell on Windows, so it suits services that do not speak HTTP.
+//
+// This is a convenience method that calls [WaitTCP].
+func (sh *Sh) WaitTCP(
+	ctx context.Context, addr string, timeout time.Duration,
+) error {
+	return WaitTCP(ctx, sh, addr, timeout)
+}
@@ -0,0 +1,172 @@
+// Package shellinfo detects the shells available on a command.Machine.
+//
+// Helpers that run scripts, rather than single commands, need to know
+// which interpreter a machine has. [Detect] finds out once per machine
+// and remembers the answer:
+//
+//	info, err := shellinfo.Detect(ctx, m)
+//	if err != nil {
+//	    return err
+//	}
+//	err = command.Do(ctx, m, info.Command("echo hello")...)
+package shellinfo
+
+import (
+	"context"
+	"errors"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+
+	"lesiw.io/command"
+)
+
+// Names of the shells that Detect looks for.
+const (
+	Sh         = "sh"
+	Bash       = "bash"
+	PowerShell = "powershell"
+	Pwsh       = "pwsh"
+	Cmd        = "cmd"
+)
+
+// ErrNoShell is returned by Detect if a machine has none of the shells
+// it looks for.
+var ErrNoShell = errors.New("no shell found")
+
+// Info describes the shells available on a machine.
+type Info struct {
+	// OS is the operating system of the machine, as reported by
+	// command.OS.
+	OS string
+
+	// Paths maps the name of each shell found to its path.
+	Paths map[string]string
+}
+
+// Has reports whether the shell name is available.
+func (i *Info) Has(name string) bool {
+	_, ok := i.Paths[name]
+	return ok
+}
+
+// Preferred returns the name of the shell to run scripts with: sh if it
+// is available on machines other than Windows, and otherwise pwsh,
+// powershell, or cmd, in that order. It returns "" if there is none.
+func (i *Info) Preferred() string {
+	order := []string{Sh, Bash, Pwsh, PowerShell, Cmd}
+	if i.OS == "windows" {
+		order = []string{Pwsh, PowerShell, Cmd, Sh, Bash}
+	}
+	for _, name := range order {
+		if i.Has(name) {
+			return name
+		}
+	}
+	return ""
+}
+
+// Command returns the arguments that run script with the preferred
+// shell, or nil if there is none.
+func (i *Info) Command(script string) []string {
+	switch i.Preferred() {
+	case Sh, Bash:
+		return []string{i.Preferred(), "-c", script}
+	case Pwsh, PowerShell:
+		return []string{i.Preferred(), "-NoProfile", "-NonInteractive",
+			"-Command", script}
+	case Cmd:
+		return []string{Cmd, "/c", script}
+	}
+	return nil
+}
+
+var (
+	mu    sync.Mutex
+	cache = map[command.Machine]*Info{}
+)
+
+// Detect returns the shells available on m. The result for m is cached
+// for the life of the process if m is comparable, as machines usually
+// are; use [Forget] after changing what is installed on m.
+func Detect(ctx context.Context, m command.Machine) (*Info, error) {
+	cacheable := hashable(m)
+	if cacheable {
+		mu.Lock()
+		info, ok := cache[m]
+		mu.Unlock()
+		if ok {
+			return info, nil
+		}
+	}
+	info, err := detect(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	if cacheable {
+		mu.Lock()
+		cache[m] = info
+		mu.Unlock()
+	}
+	return info, nil
+}
+
+// Forget removes the cached result of Detect for m.
+func Forget(m command.Machine) {
+	if !hashable(m) {
+		return // Never cached.
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	delete(cache, m)
+}
+
+// hashable reports whether m can be a key of the cache. A machine of a
+// comparable type may still hold a value that is not, such as a struct
+// holding a [command.MachineFunc], so its value is checked.
+func hashable(m command.Machine) bool {
+	return reflect.ValueOf(m).Comparable()
+}
+
+// posixProbe prints the path of each shell in $@ that sh can find.
+const posixProbe = `for s; do
+	p=$(command -v "$s") && printf '%s\t%s\n' "$s" "$p"
+done; exit 0`
+
+const windowsProbe = `Get-Command -CommandType Application ` +
+	`-ErrorAction Ignore sh,bash,pwsh,powershell,cmd | ` +
+	`ForEach-Object { $_.Name + "` + "`t" + `" + $_.Source }`
+
+func detect(ctx context.Context, m command.Machine) (*Info, error) {
+	ctx = command.WithoutCommandOptions(ctx)
+	info := &Info{OS: command.OS(ctx, m), Paths: map[string]string{}}
+	var (
+		out string
+		err error
+	)
+	if info.OS == "windows" {
+		out, err = command.Read(ctx, m, PowerShell,
+			"-NoProfile", "-NonInteractive", "-Command", windowsProbe)
+	} else {
+		out, err = command.Read(ctx, m, Sh, "-c", posixProbe, Sh,
+			Sh, Bash, Pwsh, PowerShell)
+	}
+	if err != nil && !command.NotFound(err) {
+		return nil, err
+	}
+	for line := range strings.Lines(out) {
+		name, p, ok := strings.Cut(strings.TrimRight(line, "\r\n"), "\t")
+		if !ok || p == "" {
+			continue
+		}
+		name = strings.TrimSuffix(strings.ToLower(path.Base(name)), ".exe")
+		if _, dup := info.Paths[name]; !dup {
+			info.Paths[name] = p
+		}
+	}
+	if len(info.Paths) == 0 {
+		return nil, ErrNoShell
+	}
+	return info, nil
+}
@@ -0,0 +1,94 @@
+package shellinfo_test
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+	"lesiw.io/command/shellinfo"
+)
+
+func TestDetect(t *testing.T) {
+	ctx, m := t.Context(), new(mock.Machine)
+	m.SetOS("linux")
+	m.Return(strings.NewReader("sh\t/bin/sh\nbash\t/usr/bin/bash\n"), "sh")
+
+	info, err := shellinfo.Detect(ctx, m)
+	if err != nil {
+		t.Fatalf("shellinfo.Detect error: %v", err)
+	}
+	want := map[string]string{"sh": "/bin/sh", "bash": "/usr/bin/bash"}
+	if !cmp.Equal(want, info.Paths) {
+		t.Errorf("Paths (-want +got):\n%s", cmp.Diff(want, info.Paths))
+	}
+	if got := info.Command("true"); !slices.Equal(
+		got, []string{"sh", "-c", "true"},
+	) {
+		t.Errorf("Command = %q, want sh -c", got)
+	}
+
+	if _, err := shellinfo.Detect(ctx, m); err != nil {
+		t.Fatalf("shellinfo.Detect error: %v", err)
+	}
+	if n := len(mock.Calls(m, "sh")); n != 1 {
+		t.Errorf("sh probed %d times, want 1", n)
+	}
+}
+
+func TestDetectWindows(t *testing.T) {
+	ctx, m := t.Context(), new(mock.Machine)
+	m.SetOS("windows")
+	m.Return(strings.NewReader(
+		"cmd.exe\tC:\\Windows\\system32\\cmd.exe\r\n"+
+			"powershell.exe\tC:\\Windows\\powershell.exe\r\n",
+	), "powershell")
+
+	info, err := shellinfo.Detect(ctx, m)
+	if err != nil {
+		t.Fatalf("shellinfo.Detect error: %v", err)
+	}
+	if got, want := info.Preferred(), shellinfo.PowerShell; got != want {
+		t.Errorf("Preferred = %q, want %q", got, want)
+	}
+	if !info.Has(shellinfo.Cmd) {
+		t.Error("Has(cmd) = false, want true")
+	}
+}
+
+func TestDetectNone(t *testing.T) {
+	ctx, m := t.Context(), new(mock.Machine)
+	m.SetOS("linux")
+	m.Return(command.Fail(&command.Error{Err: errors.New("not found")}))
+
+	_, err := shellinfo.Detect(ctx, m)
+
+	if !errors.Is(err, shellinfo.ErrNoShell) {
+		t.Errorf("err = %v, want ErrNoShell", err)
+	}
+}
+
+// A wrapped machine has a comparable type, but holds a value that is not.
+type wrapped struct{ command.Machine }
+
+func TestDetectUncomparable(t *testing.T) {
+	ctx, m := t.Context(), new(mock.Machine)
+	m.Return(strings.NewReader("sh\t/bin/sh\n"), "sh")
+	w := wrapped{command.MachineFunc(m.Command)}
+
+	for range 2 {
+		if _, err := shellinfo.Detect(ctx, w); err != nil {
+			t.Fatalf("shellinfo.Detect error: %v", err)
+		}
+	}
+	shellinfo.Forget(w)
+	shellinfo.Forget(command.MachineFunc(m.Command))
+
+	if n := len(mock.Calls(m, "sh")); n != 2 {
+		t.Errorf("sh probed %d times, want 2", n)
+	}
+}
@@ -0,0 +1,233 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"lesiw.io/fs"
+	"lesiw.io/fs/path"
+)
+
+// A Snap is machine state saved by [Snapshot].
+type Snap interface {
+	// Restore returns the machine to the saved state.
+	// A Snap may be restored any number of times until it is discarded.
+	Restore(ctx context.Context) error
+
+	// Discard releases any resources holding the saved state.
+	Discard(ctx context.Context) error
+}
+
+// SnapshotMachine is an optional interface that allows a Machine to save
+// and restore its entire state, such as by committing a container.
+//
+// When a Machine implements SnapshotMachine, [Snapshot] uses it instead
+// of copying individual paths.
+type SnapshotMachine interface {
+	Machine
+
+	// Snapshot saves the machine's current state.
+	Snapshot(ctx context.Context) (Snap, error)
+}
+
+// PartialSnapshotMachine is a [SnapshotMachine] whose snapshots leave out
+// some of its paths, as a container's snapshots leave out its volumes and
+// bind mounts. [Snapshot] copies the paths it is asked for that the
+// machine's snapshots leave out.
+type PartialSnapshotMachine interface {
+	SnapshotMachine
+
+	// SnapshotSaves reports whether the machine's snapshots save all of
+	// the file or directory name.
+	SnapshotSaves(name string) bool
+}
+
+// Snapshot saves the files and directories at paths on m, so that a
+// later Restore can undo changes made to them.
+//
+// If m, or the machine beneath a Shell, implements [SnapshotMachine],
+// the whole machine is saved instead, along with any of paths that its
+// snapshots leave out (see [PartialSnapshotMachine]).
+// Otherwise, each path is copied to a temporary directory on m.
+// Restoring recreates each path exactly as it was: paths that did not
+// exist are removed, and files regain their saved permissions.
+//
+// Environment variables live in the context, which is immutable, so
+// they need no snapshot: keep the parent context to return to it.
+//
+//	snap, err := command.Snapshot(ctx, m, "/etc/app/")
+//	if err != nil {
+//	    return err
+//	}
+//	defer snap.Discard(ctx)
+//	if err := provision(ctx, m); err != nil {
+//	    return errors.Join(err, snap.Restore(ctx))
+//	}
+func Snapshot(ctx context.Context, m Machine, paths ...string) (Snap, error) {
+	ctx = WithoutCommandOptions(ctx)
+	if sm, ok := Unshell(m).(SnapshotMachine); ok {
+		return machineSnapshot(ctx, m, sm, paths)
+	}
+	return savePaths(ctx, m, paths)
+}
+
+// machineSnapshot saves sm, the machine beneath m, and copies those of
+// paths that its snapshots leave out.
+func machineSnapshot(
+	ctx context.Context, m Machine, sm SnapshotMachine, paths []string,
+) (Snap, error) {
+	var left []string
+	if psm, ok := sm.(PartialSnapshotMachine); ok {
+		for _, p := range paths {
+			if !psm.SnapshotSaves(p) {
+				left = append(left, p)
+			}
+		}
+	}
+	if len(left) == 0 {
+		return sm.Snapshot(ctx)
+	}
+	// The copies are made first, so that a snapshot that saves the
+	// temporary directory holding them restores them too.
+	ps, err := savePaths(ctx, m, left)
+	if err != nil {
+		return nil, err
+	}
+	s, err := sm.Snapshot(ctx)
+	if err != nil {
+		return nil, errors.Join(err, ps.Discard(ctx))
+	}
+	return &mixedSnap{machine: s, paths: ps}, nil
+}
+
+// A mixedSnap is a snapshot of a machine, and copies of the paths that
+// the snapshot leaves out.
+type mixedSnap struct {
+	machine Snap
+	paths   *pathSnap
+}
+
+func (s *mixedSnap) Restore(ctx context.Context) error {
+	if err := s.machine.Restore(ctx); err != nil {
+		return err
+	}
+	return s.paths.Restore(ctx)
+}
+
+func (s *mixedSnap) Discard(ctx context.Context) error {
+	return errors.Join(s.machine.Discard(ctx), s.paths.Discard(ctx))
+}
+
+// savePaths copies paths to a temporary directory on m.
+func savePaths(
+	ctx context.Context, m Machine, paths []string,
+) (*pathSnap, error) {
+	fsys := FS(m)
+	tmp, err := fs.Temp(ctx, fsys, "snapshot/")
+	if err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+	s := &pathSnap{fsys: fsys, dir: tmp.Path()}
+	if err := tmp.Close(); err != nil {
+		return nil, fmt.Errorf("snapshot: %w", err)
+	}
+	for i, p := range paths {
+		err := s.save(ctx, p, path.Join(s.dir, strconv.Itoa(i)))
+		if err != nil {
+			return nil, errors.Join(
+				fmt.Errorf("snapshot %s: %w", p, err),
+				s.Discard(ctx),
+			)
+		}
+	}
+	return s, nil
+}
+
+type pathSnap struct {
+	fsys    fs.FS
+	dir     string
+	entries []snapEntry
+}
+
+type snapEntry struct {
+	name   string // Unmarked, for removal.
+	path   string // Marked as a directory if needed, for copying.
+	saved  string
+	mode   fs.Mode
+	exists bool
+}
+
+func (s *pathSnap) save(ctx context.Context, name, saved string) error {
+	e := snapEntry{name: fileName(name), path: name}
+	info, err := fs.Stat(ctx, s.fsys, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		s.entries = append(s.entries, e)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	e.exists, e.mode, e.saved = true, info.Mode(), saved
+	if info.IsDir() {
+		e.path, e.saved = dirName(name), dirName(saved)
+	}
+	if err := copyPath(ctx, s.fsys, e.saved, e.path, 0); err != nil {
+		return err
+	}
+	s.entries = append(s.entries, e)
+	return nil
+}
+
+func (s *pathSnap) Restore(ctx context.Context) error {
+	ctx = WithoutCommandOptions(ctx)
+	var errs []error
+	for _, e := range s.entries {
+		if err := fs.RemoveAll(ctx, s.fsys, e.name); err != nil {
+			errs = append(errs, fmt.Errorf("restore %s: %w", e.path, err))
+			continue
+		}
+		if !e.exists {
+			continue
+		}
+		err := copyPath(ctx, s.fsys, e.path, e.saved, e.mode)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("restore %s: %w", e.path, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *pathSnap) Discard(ctx context.Context) error {
+	return fs.RemoveAll(WithoutCommandOptions(ctx), s.fsys, s.dir)
+}
+
+// copyPath copies src to dst on fsys. Directories are copied as tar
+// streams. If mode is nonzero, files are created with that mode.
+func copyPath(
+	ctx context.Context, fsys fs.FS, dst, src string, mode fs.Mode,
+) error {
+	if mode != 0 && !path.IsDir(dst) {
+		ctx = fs.WithFileMode(ctx, mode.Perm())
+	}
+	w := fs.CreateBuffer(ctx, fsys, dst)
+	_, err := io.Copy(w, fs.OpenBuffer(ctx, fsys, src))
+	return errors.Join(err, w.Close())
+}
+
+// dirName returns name with a trailing separator, marking a directory.
+func dirName(name string) string {
+	if path.IsDir(name) {
+		return name
+	}
+	return name + "/"
+}
+
+// fileName returns name without a trailing separator.
+func fileName(name string) string {
+	for len(name) > 1 && path.IsDir(name) && !path.IsRoot(name) {
+		name = name[:len(name)-1]
+	}
+	return name
+}
@@ -0,0 +1,145 @@
+package command_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mem"
+	"lesiw.io/fs"
+)
+
+func TestSnapshotRestore(t *testing.T) {
+	ctx, m := t.Context(), mem.Machine()
+	fsys := command.FS(m)
+	mustWrite(t, fsys, "etc/app.conf", "old")
+	mustWrite(t, fsys, "data/a.txt", "a")
+	mustWrite(t, fsys, "data/b.txt", "b")
+
+	snap, err := command.Snapshot(ctx, m,
+		"etc/app.conf", "data/", "new.txt")
+	if err != nil {
+		t.Fatalf("command.Snapshot error: %v", err)
+	}
+	mustWrite(t, fsys, "etc/app.conf", "new")
+	if err := fs.Remove(ctx, fsys, "data/a.txt"); err != nil {
+		t.Fatalf("fs.Remove error: %v", err)
+	}
+	mustWrite(t, fsys, "data/c.txt", "c")
+	mustWrite(t, fsys, "new.txt", "new")
+
+	if err := snap.Restore(ctx); err != nil {
+		t.Fatalf("Restore error: %v", err)
+	}
+	for name, want := range map[string]string{
+		"etc/app.conf": "old",
+		"data/a.txt":   "a",
+		"data/b.txt":   "b",
+	} {
+		got, err := fs.ReadFile(ctx, fsys, name)
+		if err != nil {
+			t.Errorf("fs.ReadFile(%q) error: %v", name, err)
+		} else if string(got) != want {
+			t.Errorf("fs.ReadFile(%q): got %q, want %q", name, got, want)
+		}
+	}
+	for _, name := range []string{"data/c.txt", "new.txt"} {
+		_, err := fs.Stat(ctx, fsys, name)
+		if !errors.Is(err, fs.ErrNotExist) {
+			t.Errorf("fs.Stat(%q) error: got %v, want ErrNotExist", name, err)
+		}
+	}
+	if err := snap.Discard(ctx); err != nil {
+		t.Errorf("Discard error: %v", err)
+	}
+}
+
+type snapMachine struct {
+	command.Machine
+	snapped bool
+}
+
+func (m *snapMachine) Snapshot(context.Context) (command.Snap, error) {
+	m.snapped = true
+	return nil, nil
+}
+
+func TestSnapshotMachine(t *testing.T) {
+	m := &snapMachine{Machine: mem.Machine()}
+
+	if _, err := command.Snapshot(t.Context(), m, "a"); err != nil {
+		t.Fatalf("command.Snapshot error: %v", err)
+	}
+	if !m.snapped {
+		t.Error("command.Snapshot did not use SnapshotMachine")
+	}
+}
+
+type partialSnapMachine struct {
+	command.Machine
+	restored bool
+}
+
+func (m *partialSnapMachine) Snapshot(context.Context) (command.Snap, error) {
+	return (*machineSnap)(m), nil
+}
+
+func (m *partialSnapMachine) FS() fs.FS { return command.FS(m.Machine) }
+
+func (m *partialSnapMachine) SnapshotSaves(name string) bool {
+	return name != "mnt/data.txt"
+}
+
+type machineSnap partialSnapMachine
+
+func (s *machineSnap) Restore(context.Context) error {
+	s.restored = true
+	return nil
+}
+
+func (s *machineSnap) Discard(context.Context) error { return nil }
+
+func TestSnapshotPartialMachine(t *testing.T) {
+	ctx := t.Context()
+	m := &partialSnapMachine{Machine: mem.Machine()}
+	fsys := command.FS(m)
+	mustWrite(t, fsys, "mnt/data.txt", "old")
+	mustWrite(t, fsys, "etc/app.conf", "old")
+
+	snap, err := command.Snapshot(ctx, m, "mnt/data.txt", "etc/app.conf")
+	if err != nil {
+		t.Fatalf("command.Snapshot error: %v", err)
+	}
+	mustWrite(t, fsys, "mnt/data.txt", "new")
+	mustWrite(t, fsys, "etc/app.conf", "new")
+
+	if err := snap.Restore(ctx); err != nil {
+		t.Fatalf("Restore error: %v", err)
+	}
+	if !m.restored {
+		t.Error("Restore did not restore the machine snapshot")
+	}
+	for name, want := range map[string]string{
+		"mnt/data.txt": "old", // Copied.
+		"etc/app.conf": "new", // Left to the machine snapshot.
+	} {
+		got, err := fs.ReadFile(ctx, fsys, name)
+		if err != nil {
+			t.Errorf("fs.ReadFile(%q) error: %v", name, err)
+		} else if string(got) != want {
+			t.Errorf("fs.ReadFile(%q): got %q, want %q", name, got, want)
+		}
+	}
+	if err := snap.Discard(ctx); err != nil {
+		t.Errorf("Discard error: %v", err)
+	}
+}
+
+func mustWrite(t *testing.T, fsys fs.FS, name, data string) {
+	t.Helper()
+	err := fs.WriteFile(t.Context(), fsys, name, []byte(data))
+	if err != nil {
+		t.Fatalf("fs.WriteFile(%q) error: %v", name, err)
+	}
+}
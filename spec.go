@@ -0,0 +1,245 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"lesiw.io/command/internal/spill"
+	"lesiw.io/fs"
+)
+
+// A Spec describes a command to [Run].
+//
+// Spec is an alternative to passing options through the context.
+// Fields left at their zero values fall back to the context, so a Spec
+// may be as small as its Args. [WithStallTimeout] has no field: it
+// applies to the stages of a [Copy] pipeline, which Run does not build.
+type Spec struct {
+	// Args is the command and its arguments.
+	Args []string
+
+	// Env is merged over the context's environment, as if by [WithEnv].
+	Env map[string]string
+
+	// Dir is the working directory, as if by fs.WithWorkDir.
+	// If empty, the context's working directory is used.
+	Dir string
+
+	// Stdin is copied to the command's input, which is then closed.
+	// The Buffer returned by the machine must implement [WriteBuffer].
+	// If nil, no input is written and input is left open.
+	Stdin io.Reader
+
+	// Stdout receives the command's output. If nil, output is discarded.
+	Stdout io.Writer
+
+	// Stderr receives the command's diagnostic output.
+	// If nil, diagnostic output is attached to any returned [Error].
+	Stderr io.Writer
+
+	// TTY attaches the command to the controlling terminal, if its
+	// Buffer implements [AttachBuffer].
+	TTY bool
+
+	// MergedStderr sends diagnostic output to Stdout, as if by
+	// [WithMergedStderr].
+	MergedStderr bool
+
+	// StdoutFile and StderrFile name files on the machine that receive
+	// output and diagnostic output instead, as if by [WithStdoutFile]
+	// and [WithStderrFile].
+	StdoutFile string
+	StderrFile string
+
+	// AllowedExitCodes are exit codes that are not failures, as if by
+	// [WithAllowedExitCodes].
+	AllowedExitCodes []int
+
+	// Scratch gives the command a scratch directory, as if by
+	// [WithScratch].
+	Scratch bool
+
+	// LogLimit and LogSpill bound the diagnostic output kept for an
+	// [Error], as if by [WithLogLimit] and [WithLogSpill].
+	LogLimit int
+	LogSpill bool
+
+	// StdinTimeout stops a command given no Stdin once it is idle for
+	// that long, as if by [WithStdinTimeout].
+	StdinTimeout time.Duration
+}
+
+// Run executes the command described by spec and waits for it to
+// complete.
+//
+// [Do], [Read], and [Exec] are shorthands for common Specs.
+//
+//	err := command.Run(ctx, m, command.Spec{
+//	    Args:   []string{"tr", "a-z", "A-Z"},
+//	    Stdin:  strings.NewReader("hello"),
+//	    Stdout: os.Stdout,
+//	})
+func Run(ctx context.Context, m Machine, spec Spec) error {
+	e, _ := ctx.Value(elevationKey{}).(*elevation)
+	if e != nil && spec.Stdin == nil {
+		return e.run(ctx, m, spec)
+	}
+	ctx = spec.context(ctx)
+	var watch *stdinWatch
+	if d := stdinTimeout(ctx); spec.Stdin == nil && !spec.TTY && d > 0 {
+		ctx, watch = watchStdin(ctx, d)
+	}
+	buf := newCommand(ctx, m, spec.Args...)
+	if _, ok := buf.(WriteBuffer); !ok && watch != nil {
+		_ = watch.stop(nil, spec.Args) // It has no input to wait on.
+		watch = nil
+	}
+
+	log := spill.New(LogLimit(ctx), LogSpill(ctx))
+	defer log.Close()
+	var stderr io.Writer = log
+	if spec.Stderr != nil {
+		stderr = spec.Stderr
+	}
+	if watch != nil {
+		stderr = watch.writer(stderr)
+	}
+	Log(buf, stderr)
+
+	trace(ctx, buf, spec.Args...)
+	if spec.TTY {
+		if err := Attach(buf); err != nil {
+			return err
+		}
+	}
+
+	var (
+		in    *inputReader
+		inerr chan error
+	)
+	if spec.Stdin != nil {
+		w, ok := buf.(WriteBuffer)
+		if !ok {
+			// The command may hold resources, such as a shell of a
+			// pool, until it is closed.
+			if c, ok := buf.(io.Closer); ok {
+				_ = c.Close()
+			}
+			return ErrReadOnly
+		}
+		in = &inputReader{r: spec.Stdin}
+		inerr = make(chan error, 1)
+		go func() {
+			_, err := io.Copy(w, in)
+			inerr <- errors.Join(err, w.Close())
+		}()
+	}
+
+	stdout := spec.Stdout
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if watch != nil {
+		stdout = watch.writer(stdout)
+	}
+	_, err := io.Copy(stdout, buf)
+	if watch != nil {
+		if werr := watch.stop(err, spec.Args); werr != err {
+			_ = log.Remove()
+			return werr
+		}
+	}
+
+	if e := new(Error); err != nil && log.Len() > 0 && errors.As(err, &e) {
+		e.Log, e.LogFile = log.Bytes(), log.Name()
+	} else {
+		_ = log.Remove()
+	}
+	if inerr != nil {
+		if ierr := in.wait(inerr); err == nil {
+			err = ierr
+		}
+	}
+	if allowed(ctx, err) {
+		_ = log.Remove()
+		return nil
+	}
+	return redact(redactor(ctx), netDiagnose(ctx, m, spec.Args, err))
+}
+
+// context returns ctx with the options of the fields of spec that are
+// set.
+func (spec Spec) context(ctx context.Context) context.Context {
+	if len(spec.Env) > 0 {
+		ctx = WithEnv(ctx, spec.Env)
+	}
+	if spec.Dir != "" {
+		ctx = fs.WithWorkDir(ctx, spec.Dir)
+	}
+	if spec.MergedStderr {
+		ctx = WithMergedStderr(ctx, true)
+	}
+	if spec.StdoutFile != "" {
+		ctx = WithStdoutFile(ctx, spec.StdoutFile)
+	}
+	if spec.StderrFile != "" {
+		ctx = WithStderrFile(ctx, spec.StderrFile)
+	}
+	if len(spec.AllowedExitCodes) > 0 {
+		ctx = WithAllowedExitCodes(ctx, spec.AllowedExitCodes...)
+	}
+	if spec.Scratch {
+		ctx = WithScratch(ctx)
+	}
+	if spec.LogLimit > 0 {
+		ctx = WithLogLimit(ctx, spec.LogLimit)
+	}
+	if spec.LogSpill {
+		ctx = WithLogSpill(ctx, true)
+	}
+	if spec.StdinTimeout > 0 {
+		ctx = WithStdinTimeout(ctx, spec.StdinTimeout)
+	}
+	return ctx
+}
+
+// inputGrace is how long a command that is done waits on a read of its
+// input before leaving the copying of its input behind.
+const inputGrace = 100 * time.Millisecond
+
+// An inputReader is the input of a command run by [Run]. It records when
+// a read began, so that a copy blocked on a read can be left behind.
+type inputReader struct {
+	r       io.Reader
+	reading atomic.Int64 // Start of the current read, in Unix nanoseconds.
+}
+
+func (in *inputReader) Read(p []byte) (int, error) {
+	in.reading.Store(time.Now().UnixNano())
+	defer in.reading.Store(0)
+	return in.r.Read(p)
+}
+
+// wait returns the error of the copying of input to a command that is
+// done, from inerr. If the copy is blocked on a read for inputGrace,
+// wait leaves it behind and returns nil: the command will never read
+// the input, just as an exec.Cmd does not wait for a pipe it has handed
+// to a process.
+func (in *inputReader) wait(inerr <-chan error) error {
+	t := time.NewTicker(inputGrace / 4)
+	defer t.Stop()
+	for {
+		select {
+		case err := <-inerr:
+			return err
+		case <-t.C:
+		}
+		start := in.reading.Load()
+		if start != 0 && time.Since(time.Unix(0, start)) >= inputGrace {
+			return nil
+		}
+	}
+}
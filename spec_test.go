@@ -0,0 +1,280 @@
+package command_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mem"
+	"lesiw.io/command/sys"
+	"lesiw.io/fs"
+)
+
+func TestRunStdin(t *testing.T) {
+	var out bytes.Buffer
+	err := command.Run(t.Context(), mem.Machine(), command.Spec{
+		Args:   []string{"tr", "a-z", "A-Z"},
+		Stdin:  strings.NewReader("hello"),
+		Stdout: &out,
+	})
+	if err != nil {
+		t.Fatalf("command.Run error: %v", err)
+	}
+	if got, want := out.String(), "HELLO"; got != want {
+		t.Errorf("stdout: got %q, want %q", got, want)
+	}
+}
+
+func TestRunStdinBlocked(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires true")
+	}
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	done := make(chan error, 1)
+	go func() {
+		done <- command.Run(t.Context(), sys.Machine(), command.Spec{
+			Args:  []string{"true"},
+			Stdin: pr, // Never written to nor closed.
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("command.Run error: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("command.Run did not return after the command exited")
+	}
+}
+
+func TestRunEnvDir(t *testing.T) {
+	var (
+		env map[string]string
+		dir string
+	)
+	m := command.MachineFunc(
+		func(ctx context.Context, _ ...string) command.Buffer {
+			env, dir = command.Envs(ctx), fs.WorkDir(ctx)
+			return strings.NewReader("")
+		},
+	)
+	ctx := command.WithEnv(t.Context(), map[string]string{
+		"A": "ctx",
+		"B": "ctx",
+	})
+
+	err := command.Run(ctx, m, command.Spec{
+		Args: []string{"true"},
+		Env:  map[string]string{"B": "spec"},
+		Dir:  "/work",
+	})
+	if err != nil {
+		t.Fatalf("command.Run error: %v", err)
+	}
+
+	want := map[string]string{"A": "ctx", "B": "spec"}
+	if !cmp.Equal(env, want) {
+		t.Errorf("env (-want +got):\n%s", cmp.Diff(want, env))
+	}
+	if want := "/work"; dir != want {
+		t.Errorf("work dir: got %q, want %q", dir, want)
+	}
+}
+
+func TestRunStdinReadOnly(t *testing.T) {
+	m := command.MachineFunc(
+		func(context.Context, ...string) command.Buffer {
+			return strings.NewReader("")
+		},
+	)
+
+	err := command.Run(t.Context(), m, command.Spec{
+		Args:  []string{"cat"},
+		Stdin: strings.NewReader("input"),
+	})
+	if !errors.Is(err, command.ErrReadOnly) {
+		t.Errorf("command.Run error: got %v, want ErrReadOnly", err)
+	}
+}
+
+type closeRecorder struct {
+	*strings.Reader
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestRunStdinReadOnlyCloses(t *testing.T) {
+	buf := &closeRecorder{Reader: strings.NewReader("")}
+	m := command.MachineFunc(
+		func(context.Context, ...string) command.Buffer { return buf },
+	)
+
+	err := command.Run(t.Context(), m, command.Spec{
+		Args:  []string{"cat"},
+		Stdin: strings.NewReader("input"),
+	})
+	if !errors.Is(err, command.ErrReadOnly) {
+		t.Errorf("command.Run error: got %v, want ErrReadOnly", err)
+	}
+	if !buf.closed {
+		t.Error("command.Run did not close the read-only Buffer")
+	}
+}
+
+func TestRunLogLimit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	ctx := command.WithLogLimit(t.Context(), 8)
+
+	err := command.Run(ctx, sys.Machine(), command.Spec{
+		Args: []string{"sh", "-c", "printf 0123456789abcdef >&2; exit 1"},
+	})
+
+	var e *command.Error
+	if !errors.As(err, &e) {
+		t.Fatalf("err = %v, want *command.Error", err)
+	}
+	if got, want := string(e.Log), "89abcdef"; got != want {
+		t.Errorf("Log = %q, want %q", got, want)
+	}
+	if e.LogFile != "" {
+		t.Errorf("LogFile = %q, want none without WithLogSpill", e.LogFile)
+		_ = os.Remove(e.LogFile)
+	}
+}
+
+func TestRunLogSpill(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	ctx := command.WithLogLimit(t.Context(), 8)
+	ctx = command.WithLogSpill(ctx, true)
+
+	err := command.Run(ctx, sys.Machine(), command.Spec{
+		Args: []string{"sh", "-c", "printf 0123456789abcdef >&2; exit 1"},
+	})
+
+	var e *command.Error
+	if !errors.As(err, &e) {
+		t.Fatalf("err = %v, want *command.Error", err)
+	}
+	if got, want := string(e.Log), "89abcdef"; got != want {
+		t.Errorf("Log = %q, want %q", got, want)
+	}
+	if e.LogFile == "" {
+		t.Fatal("LogFile is empty")
+	}
+	t.Cleanup(func() { _ = os.Remove(e.LogFile) })
+	got, err := os.ReadFile(e.LogFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "0123456789abcdef"; string(got) != want {
+		t.Errorf("LogFile holds %q, want %q", got, want)
+	}
+}
+
+func TestRunStdinTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	ctx := command.WithStdinTimeout(t.Context(), 200*time.Millisecond)
+	m := sys.Machine()
+
+	_, err := command.Read(ctx, m, "cat")
+	if !errors.Is(err, command.ErrStdinNotClosed) {
+		t.Errorf("Read(cat) err = %v, want ErrStdinNotClosed", err)
+	}
+
+	// Output keeps a slow command alive.
+	out, err := command.Read(ctx, m, "sh", "-c",
+		"for i in 1 2 3; do sleep 0.1; echo $i; done")
+	if err != nil {
+		t.Fatalf("Read(sh) error: %v", err)
+	}
+	if want := "1\n2\n3"; out != want {
+		t.Errorf("Read(sh) = %q, want %q", out, want)
+	}
+
+	// A timeout of 0 clears it.
+	ctx = command.WithStdinTimeout(ctx, 0)
+	out, err = command.Read(ctx, m, "sh", "-c", "sleep 0.3; echo ok")
+	if err != nil {
+		t.Fatalf("Read(sh) with cleared timeout error: %v", err)
+	}
+	if want := "ok"; out != want {
+		t.Errorf("Read(sh) = %q, want %q", out, want)
+	}
+}
+
+func TestRunSpecOptions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	ctx, m, dir := t.Context(), sys.Machine(), t.TempDir()
+
+	var out strings.Builder
+	err := command.Run(ctx, m, command.Spec{
+		Args: []string{"sh", "-c", `test -d "$COMMAND_SCRATCH" &&
+			echo out && echo err >&2; exit 3`},
+		Stdout:           &out,
+		MergedStderr:     true,
+		AllowedExitCodes: []int{3},
+		Scratch:          true,
+	})
+	if err != nil {
+		t.Fatalf("command.Run error: %v", err)
+	}
+	if got, want := out.String(), "out\nerr\n"; got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	stdout, stderr := filepath.Join(dir, "out"), filepath.Join(dir, "err")
+	err = command.Run(ctx, m, command.Spec{
+		Args:       []string{"sh", "-c", "echo out; echo err >&2"},
+		StdoutFile: stdout,
+		StderrFile: stderr,
+	})
+	if err != nil {
+		t.Fatalf("command.Run error: %v", err)
+	}
+	files := map[string]string{stdout: "out\n", stderr: "err\n"}
+	for name, want := range files {
+		got, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s holds %q, want %q", name, got, want)
+		}
+	}
+
+	err = command.Run(ctx, m, command.Spec{
+		Args:     []string{"sh", "-c", "printf 0123456789abcdef >&2; exit 1"},
+		LogLimit: 8,
+	})
+	var e *command.Error
+	if !errors.As(err, &e) {
+		t.Fatalf("err = %v, want *command.Error", err)
+	}
+	if got, want := string(e.Log), "89abcdef"; got != want {
+		t.Errorf("Log = %q, want %q", got, want)
+	}
+}
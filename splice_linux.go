@@ -0,0 +1,70 @@
+package command
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// canSplice reports whether Copy splices stages.
+const canSplice = true
+
+// spliceChunk is the most data moved by one splice(2) call.
+const spliceChunk = 1 << 20
+
+// newPipe returns an operating system pipe in blocking mode, so that
+// splice(2) can wait on it.
+func newPipe() (r, w *os.File, err error) {
+	var fds [2]int
+	if err := unix.Pipe2(fds[:], unix.O_CLOEXEC); err != nil {
+		return nil, nil, os.NewSyscallError("pipe2", err)
+	}
+	return os.NewFile(uintptr(fds[0]), "|0"),
+		os.NewFile(uintptr(fds[1]), "|1"), nil
+}
+
+// spliceFile moves the data of r into w with splice(2), and returns how
+// much it moved. Where splice(2) cannot move it, as when neither is a
+// pipe, or w was opened to append, the rest is copied.
+func spliceFile(w, r *os.File) (written int64, err error) {
+	rc, err := r.SyscallConn()
+	if err != nil {
+		return io.Copy(w, r)
+	}
+	wc, err := w.SyscallConn()
+	if err != nil {
+		return io.Copy(w, r)
+	}
+	for {
+		var (
+			n          int64
+			serr, werr error
+		)
+		err := rc.Control(func(rfd uintptr) {
+			werr = wc.Control(func(wfd uintptr) {
+				n, serr = unix.Splice(int(rfd), nil, int(wfd), nil,
+					spliceChunk, unix.SPLICE_F_MOVE)
+			})
+		})
+		if err == nil {
+			err = werr
+		}
+		if err == nil {
+			err = serr
+		}
+		switch {
+		case errors.Is(err, unix.EINTR):
+			continue
+		case errors.Is(err, unix.EINVAL), errors.Is(err, unix.EAGAIN):
+			n, err := io.Copy(w, r)
+			return written + n, err
+		case err != nil:
+			return written, os.NewSyscallError("splice", err)
+		case n == 0:
+			return written, nil
+		}
+		written += n
+	}
+}
@@ -0,0 +1,44 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+
+	"lesiw.io/command"
+)
+
+var _ command.DialMachine = (*machine)(nil)
+
+// Dial connects to addr from the remote host over a channel of the SSH
+// connection, as with ssh -W, so that [command.Dial] needs no relay on
+// the remote host. Only TCP networks are supported.
+//
+// With [Multiplex], and always for a Machine made by [Host], the channel
+// is opened on the shared connection.
+func (sm *machine) Dial(
+	ctx context.Context, network, addr string,
+) (net.Conn, error) {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return nil, fmt.Errorf("ssh: dial %s: %w", network,
+			errors.ErrUnsupported)
+	}
+	if c, ok := sm.m.(*client); ok {
+		return c.dial(ctx, network, addr)
+	}
+	if len(sm.args) == 0 {
+		return nil, fmt.Errorf("ssh: dial: %w", errors.ErrUnsupported)
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	cmdline := sm.cmdline(ctx)
+	last := len(cmdline) - 1
+	args := slices.Clone(cmdline[:last])
+	args = append(args, "-W", addr, cmdline[last])
+	return command.CommandConn(ctx, sm.m, args...), nil
+}
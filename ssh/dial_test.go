@@ -0,0 +1,57 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+)
+
+func TestDial_Mock(t *testing.T) {
+	testHookOS = func() string { return "linux" }
+	t.Cleanup(func() { testHookOS = nil })
+
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("+OK\n"), "ssh")
+
+	sshm := New(m, []string{"ssh", "-p", "2222", "user@host"},
+		Via("bastion"))
+	conn, err := command.Dial(t.Context(), sshm, "tcp", "localhost:6379")
+	if err != nil {
+		t.Fatalf("command.Dial error: %v", err)
+	}
+	got, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("ReadAll error: %v", err)
+	}
+	if string(got) != "+OK\n" {
+		t.Errorf("read %q, want %q", got, "+OK\n")
+	}
+	if err := conn.Close(); err != nil {
+		t.Errorf("Close error: %v", err)
+	}
+
+	calls := mock.Calls(m, "ssh")
+	if len(calls) != 1 {
+		t.Fatalf("got %d ssh calls, want 1", len(calls))
+	}
+	want := []string{
+		"ssh", "-p", "2222", "-J", "bastion",
+		"-W", "localhost:6379", "user@host",
+	}
+	if args := calls[0].Args; strings.Join(args, "\x00") !=
+		strings.Join(want, "\x00") {
+		t.Errorf("args = %q, want %q", args, want)
+	}
+}
+
+func TestDialUnsupportedNetwork(t *testing.T) {
+	sshm := New(new(mock.Machine), []string{"ssh", "user@host"})
+	_, err := sshm.(command.DialMachine).Dial(t.Context(), "udp", "x:53")
+	if !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Dial error = %v, want ErrUnsupported", err)
+	}
+}
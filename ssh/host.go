@@ -0,0 +1,618 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	xssh "golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"lesiw.io/command"
+	"lesiw.io/command/credentials"
+	"lesiw.io/command/internal/sh"
+	"lesiw.io/command/internal/spill"
+)
+
+// Host returns a command.Machine that executes commands on the SSH server
+// at addr, of the form [user@]host[:port], as [Machine] does. Rather than
+// running an SSH client, it connects by itself with
+// golang.org/x/crypto/ssh, so no client needs to be installed.
+//
+// The Machine connects when it runs its first command, and runs each
+// command in a session of its own on that connection, so that only the
+// first pays to connect and authenticate. If the connection is lost, the
+// next command opens a new one. Commands that fail to connect, or that
+// lose their connection, fail with an error wrapping [ErrConnectionLost].
+//
+// Options configure the connection as they configure the OpenSSH client
+// for [New]. Without [Identity], the Machine authenticates with the keys
+// of the SSH agent at $SSH_AUTH_SOCK, and with those of ~/.ssh/id_ed25519,
+// ~/.ssh/id_ecdsa, and ~/.ssh/id_rsa that have no passphrase. Host keys
+// are checked against ~/.ssh/known_hosts, or the files of [KnownHosts];
+// unless [HostKeys] sets [TOFU], unknown hosts are refused. The user's
+// ssh_config is not read.
+//
+//	m := ssh.Host("ci@build-host", ssh.Identity("deploy/id_ed25519"))
+//	out, err := command.Read(ctx, m, "uname", "-a")
+func Host(addr string, opts ...Option) command.Machine {
+	sm := new(machine)
+	for _, opt := range opts {
+		opt(sm)
+	}
+	sm.m = &client{d: sm.d, addr: addr}
+	sm.opts = nil
+	return sm
+}
+
+// A dialer holds the settings of options that configure the connections
+// of a Machine made by Host.
+type dialer struct {
+	keys     []string // Identity files.
+	agent    bool     // Whether to forward the agent.
+	policy   HostKeyPolicy
+	known    []string // Known hosts files.
+	via      []string // Jump hosts.
+	alive    time.Duration
+	aliveMax int
+	creds    credentials.Store
+	credName string
+}
+
+// A client is a command.Machine that runs each command, its arguments
+// joined by spaces as OpenSSH joins them, in a session of a shared
+// connection.
+type client struct {
+	d    dialer
+	addr string
+
+	mu   sync.Mutex
+	conn *xssh.Client
+}
+
+func (c *client) Command(ctx context.Context, args ...string) command.Buffer {
+	if len(args) == 0 {
+		return command.Fail(errors.New("no command given"))
+	}
+	b := &session{
+		ctx:   ctx,
+		c:     c,
+		args:  args,
+		merge: command.MergedStderr(ctx),
+		done:  make(chan error, 1),
+	}
+	b.start = sync.OnceValue(b.startFunc)
+	b.wait = sync.OnceValue(b.waitFunc)
+	return b
+}
+
+// Close closes the connection, if it is open.
+func (c *client) Close() error {
+	c.mu.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.mu.Unlock()
+	if conn == nil {
+		return nil
+	}
+	if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// connect returns the open connection, or opens one.
+func (c *client) connect(ctx context.Context) (*xssh.Client, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn != nil {
+		return c.conn, nil
+	}
+	conn, err := c.d.dial(ctx, c.addr)
+	if err != nil {
+		return nil, err
+	}
+	if c.d.agent {
+		if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+			if err := agent.ForwardToRemote(conn, sock); err != nil {
+				_ = conn.Close()
+				return nil, err
+			}
+		}
+	}
+	closed := make(chan struct{})
+	go func() {
+		_ = conn.Wait()
+		close(closed)
+		c.forget(conn)
+	}()
+	if c.d.alive > 0 {
+		go keepAlive(conn, closed, c.d.alive, c.d.aliveMax)
+	}
+	c.conn = conn
+	return conn, nil
+}
+
+// forget drops conn, if it is still the open connection, so that the
+// next command opens a new one.
+func (c *client) forget(conn *xssh.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == conn {
+		c.conn = nil
+	}
+}
+
+// newSession opens a session on the connection. A shared connection may
+// have been lost since it was last used, so if it cannot open one, it
+// tries once more on a new connection.
+func (c *client) newSession(ctx context.Context) (*xssh.Session, error) {
+	var err error
+	for range 2 {
+		var conn *xssh.Client
+		conn, err = c.connect(ctx)
+		if err != nil {
+			return nil, err
+		}
+		var s *xssh.Session
+		if s, err = conn.NewSession(); err == nil {
+			return s, nil
+		}
+		c.forget(conn)
+		_ = conn.Close()
+	}
+	return nil, err
+}
+
+func (c *client) dial(
+	ctx context.Context, network, addr string,
+) (net.Conn, error) {
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConnectionLost, err)
+	}
+	return conn.DialContext(ctx, network, addr)
+}
+
+// keepAlive sends a keepalive request on conn every interval until it is
+// closed, and closes it once count requests in a row go unanswered.
+func keepAlive(
+	conn *xssh.Client, closed <-chan struct{},
+	interval time.Duration, count int,
+) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	reply := make(chan error, 1)
+	pending, missed := false, 0
+	for {
+		select {
+		case <-closed:
+			return
+		case err := <-reply:
+			pending, missed = false, 0
+			if err != nil {
+				return // The connection is closing.
+			}
+			continue
+		case <-t.C:
+		}
+		if pending {
+			if missed++; missed >= max(count, 1) {
+				_ = conn.Close()
+				return
+			}
+			continue
+		}
+		pending = true
+		go func() {
+			_, _, err := conn.SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}()
+	}
+}
+
+// dial connects and authenticates to addr, through the jump hosts.
+func (d *dialer) dial(ctx context.Context, addr string) (*xssh.Client, error) {
+	auth, closeAgent, err := d.auth(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAgent()
+	var jump *xssh.Client
+	for _, hop := range append(slices.Clone(d.via), addr) {
+		name, hostport := splitAddr(hop)
+		cfg := &xssh.ClientConfig{
+			User:            name,
+			Auth:            auth,
+			HostKeyCallback: d.checkHostKey,
+		}
+		c, err := connect(ctx, jump, hostport, cfg)
+		if err != nil {
+			if jump != nil {
+				_ = jump.Close()
+			}
+			return nil, fmt.Errorf("%s: %w", hop, err)
+		}
+		if jump != nil {
+			// Close the jump host with the connection through it.
+			go func(jump *xssh.Client) {
+				_ = c.Wait()
+				_ = jump.Close()
+			}(jump)
+		}
+		jump = c
+	}
+	return jump, nil
+}
+
+// connect connects to hostport, through jump if it is not nil, and sets
+// up an SSH connection over it.
+func connect(
+	ctx context.Context, jump *xssh.Client, hostport string,
+	cfg *xssh.ClientConfig,
+) (*xssh.Client, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+	if jump != nil {
+		conn, err = jump.DialContext(ctx, "tcp", hostport)
+	} else {
+		conn, err = new(net.Dialer).DialContext(ctx, "tcp", hostport)
+	}
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	cc, chans, reqs, err := xssh.NewClientConn(conn, hostport, cfg)
+	if !stop() {
+		if err == nil {
+			_ = cc.Close()
+		}
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return xssh.NewClient(cc, chans, reqs), nil
+}
+
+// splitAddr splits addr, of the form [user@]host[:port], into the user,
+// by default the current one, and the host and port, by default 22.
+func splitAddr(addr string) (name, hostport string) {
+	if i := strings.LastIndex(addr, "@"); i >= 0 {
+		name, addr = addr[:i], addr[i+1:]
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "22")
+	}
+	if name == "" {
+		name = currentUser()
+	}
+	return name, addr
+}
+
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		// Windows names users as DOMAIN\user.
+		return u.Username[strings.LastIndex(u.Username, `\`)+1:]
+	}
+	return os.Getenv("USER")
+}
+
+// defaultKeys are the key files tried without Identity, as OpenSSH
+// tries them.
+var defaultKeys = []string{"id_ed25519", "id_ecdsa", "id_rsa"}
+
+// auth returns the ways to authenticate, and a function that closes the
+// connection to the SSH agent, if one was opened. Keys that cannot be
+// loaded are skipped, unless they were given by Identity.
+func (d *dialer) auth(
+	ctx context.Context,
+) ([]xssh.AuthMethod, func(), error) {
+	var (
+		methods []xssh.AuthMethod
+		signers []xssh.Signer
+		done    = func() {}
+	)
+	keys := d.keys
+	if len(keys) == 0 {
+		if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+			if conn, err := net.Dial("unix", sock); err == nil {
+				done = func() { _ = conn.Close() }
+				methods = append(methods,
+					xssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+			}
+		}
+		if home, err := os.UserHomeDir(); err == nil {
+			for _, k := range defaultKeys {
+				keys = append(keys, filepath.Join(home, ".ssh", k))
+			}
+		}
+	}
+	for _, k := range keys {
+		s, err := loadKey(k)
+		if err == nil {
+			signers = append(signers, s)
+		} else if len(d.keys) > 0 {
+			done()
+			return nil, nil, err
+		}
+	}
+	if len(signers) > 0 {
+		methods = append(methods, xssh.PublicKeys(signers...))
+	}
+	if d.creds != nil {
+		methods = append(methods, xssh.PasswordCallback(
+			func() (string, error) {
+				pw, err := d.creds.Get(ctx, d.credName)
+				if err != nil {
+					return "", fmt.Errorf("password: %w", err)
+				}
+				return pw, nil
+			},
+		))
+	}
+	return methods, done, nil
+}
+
+// loadKey reads the private key in file.
+func loadKey(file string) (xssh.Signer, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	s, err := xssh.ParsePrivateKey(b)
+	if pe := new(xssh.PassphraseMissingError); errors.As(err, &pe) {
+		return nil, fmt.Errorf("%s: key has a passphrase; "+
+			"load it into an SSH agent instead", file)
+	} else if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return s, nil
+}
+
+// knownHostsMu serializes the recording of new host keys.
+var knownHostsMu sync.Mutex
+
+// checkHostKey checks key, the key of host, against the known hosts
+// files. With TOFU, the key of an unknown host is recorded in the first
+// of them.
+func (d *dialer) checkHostKey(
+	host string, remote net.Addr, key xssh.PublicKey,
+) error {
+	knownHostsMu.Lock()
+	defer knownHostsMu.Unlock()
+	files := d.known
+	if len(files) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		files = []string{filepath.Join(home, ".ssh", "known_hosts")}
+	}
+	var found []string
+	for _, f := range files {
+		if _, err := os.Stat(f); err == nil {
+			found = append(found, f)
+		}
+	}
+	err := error(&knownhosts.KeyError{})
+	if len(found) > 0 {
+		check, kerr := knownhosts.New(found...)
+		if kerr != nil {
+			return kerr
+		}
+		err = check(host, remote, key)
+	}
+	ke := new(knownhosts.KeyError)
+	if !errors.As(err, &ke) || len(ke.Want) > 0 {
+		return err // Known, or changed.
+	}
+	if d.policy != TOFU {
+		return fmt.Errorf("host key for %s is not known", host)
+	}
+	return recordHostKey(files[0], host, key)
+}
+
+func recordHostKey(file, host string, key xssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	line := knownhosts.Line([]string{knownhosts.Normalize(host)}, key)
+	_, err = fmt.Fprintln(f, line)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// A session is the command.Buffer of a command run by a client.
+type session struct {
+	ctx   context.Context
+	c     *client
+	args  []string
+	merge bool // Send diagnostic output to output.
+
+	start func() error
+	wait  func() error
+	done  chan error
+
+	s        *xssh.Session
+	attached bool
+	reader   *io.PipeReader
+	writer   io.WriteCloser
+	logger   io.Writer
+	logbuf   *spill.Buffer
+}
+
+var (
+	_ command.WriteBuffer  = (*session)(nil)
+	_ command.LogBuffer    = (*session)(nil)
+	_ command.AttachBuffer = (*session)(nil)
+)
+
+func (b *session) startFunc() error {
+	s, err := b.c.newSession(b.ctx)
+	if err != nil {
+		if b.ctx.Err() != nil {
+			return command.Interrupted(b.ctx, &command.Error{Err: err})
+		}
+		return &command.Error{
+			Err:  fmt.Errorf("%w: %w", ErrConnectionLost, err),
+			Code: 255,
+		}
+	}
+	b.s = s
+	if b.c.d.agent {
+		// Without an agent to forward, this is refused; OpenSSH only
+		// warns, so carry on.
+		_ = agent.RequestAgentForwarding(s)
+	}
+	var pw *io.PipeWriter
+	if b.attached {
+		s.Stdin, s.Stdout, s.Stderr = os.Stdin, os.Stdout, os.Stderr
+	} else {
+		if b.writer, err = s.StdinPipe(); err != nil {
+			_ = s.Close()
+			return &command.Error{Err: err}
+		}
+		b.reader, pw = io.Pipe()
+		s.Stdout = pw
+		switch {
+		case b.merge:
+			s.Stderr = pw
+		case b.logger != nil:
+			s.Stderr = b.logger
+		default:
+			b.logbuf = spill.New(command.LogLimit(b.ctx),
+				command.LogSpill(b.ctx))
+			s.Stderr = b.logbuf
+		}
+	}
+	if err := s.Start(strings.Join(b.args, " ")); err != nil {
+		_ = s.Close()
+		if pw != nil {
+			_ = pw.Close()
+		}
+		return &command.Error{Err: err}
+	}
+	stop := context.AfterFunc(b.ctx, func() {
+		_ = s.Signal(xssh.SIGKILL)
+		_ = s.Close()
+	})
+	go func() {
+		err := s.Wait()
+		stop()
+		_ = s.Close()
+		if pw != nil {
+			_ = pw.Close()
+		}
+		b.done <- err
+	}()
+	return nil
+}
+
+func (b *session) waitFunc() error {
+	err := <-b.done
+	if b.logbuf != nil {
+		defer b.logbuf.Close()
+	}
+	if err == nil {
+		if b.logbuf != nil {
+			_ = b.logbuf.Remove()
+		}
+		return nil
+	}
+	err = sessionError(err)
+	if e := new(command.Error); b.logbuf != nil && errors.As(err, &e) {
+		e.Log, e.LogFile = b.logbuf.Bytes(), b.logbuf.Name()
+	}
+	return command.Interrupted(b.ctx, err)
+}
+
+// sessionError wraps an error of a session as a command.Error, with the
+// exit code of the command if it exited. A command killed by a signal
+// has a code of -1, as it would on the local machine. A session that
+// ended without exiting lost its connection.
+func sessionError(err error) error {
+	e := &command.Error{Err: err}
+	if ee := new(xssh.ExitError); errors.As(err, &ee) {
+		e.Code = ee.ExitStatus()
+		if ee.Signal() != "" {
+			e.Code = -1
+		}
+		return e
+	}
+	e.Err, e.Code = fmt.Errorf("%w: %w", ErrConnectionLost, err), 255
+	return e
+}
+
+func (b *session) Read(p []byte) (int, error) {
+	if err := b.start(); err != nil {
+		return 0, err
+	}
+	if b.reader == nil {
+		if err := b.wait(); err != nil {
+			return 0, err
+		}
+		return 0, io.EOF
+	}
+	n, err := b.reader.Read(p)
+	if err != nil {
+		if werr := b.wait(); werr != nil {
+			err = werr
+		}
+	}
+	return n, err
+}
+
+func (b *session) Write(p []byte) (int, error) {
+	if err := b.start(); err != nil {
+		return 0, err
+	}
+	if b.writer == nil {
+		return 0, nil
+	}
+	return b.writer.Write(p)
+}
+
+func (b *session) Close() error {
+	if err := b.start(); err != nil {
+		return err
+	}
+	if b.writer == nil {
+		return nil
+	}
+	if err := b.writer.Close(); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
+func (b *session) Log(w io.Writer) { b.logger = w }
+
+func (b *session) Attach() error {
+	b.attached = true
+	return nil
+}
+
+func (b *session) String() string {
+	return sh.String(nil, append([]string{"ssh", b.c.addr}, b.args...)...).
+		String()
+}
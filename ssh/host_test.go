@@ -0,0 +1,272 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	xssh "golang.org/x/crypto/ssh"
+
+	"lesiw.io/command"
+)
+
+// A testServer is an SSH server that runs the commands of its sessions
+// with the local sh, and accepts only the key in key.
+type testServer struct {
+	addr  string
+	key   string // A file holding the client's private key.
+	conns atomic.Int32
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := xssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := xssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &testServer{key: filepath.Join(t.TempDir(), "id_ed25519")}
+	if err := os.WriteFile(srv.key, pem.EncodeToMemory(block),
+		0o600); err != nil {
+		t.Fatal(err)
+	}
+	authorized, err := xssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &xssh.ServerConfig{
+		PublicKeyCallback: func(
+			_ xssh.ConnMetadata, key xssh.PublicKey,
+		) (*xssh.Permissions, error) {
+			if !bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	cfg.AddHostKey(hostKey)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	srv.addr = ln.Addr().String()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			srv.conns.Add(1)
+			go srv.serve(conn, cfg)
+		}
+	}()
+	return srv
+}
+
+func (srv *testServer) serve(conn net.Conn, cfg *xssh.ServerConfig) {
+	sc, chans, reqs, err := xssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	defer sc.Close()
+	go xssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			_ = nc.Reject(xssh.UnknownChannelType, "")
+			continue
+		}
+		ch, reqs, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+		go serveSession(ch, reqs)
+	}
+}
+
+func serveSession(ch xssh.Channel, reqs <-chan *xssh.Request) {
+	defer ch.Close()
+	for req := range reqs {
+		if req.Type != "exec" {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		var payload struct{ Command string }
+		if err := xssh.Unmarshal(req.Payload, &payload); err != nil {
+			_ = req.Reply(false, nil)
+			continue
+		}
+		_ = req.Reply(true, nil)
+		cmd := exec.Command("sh", "-c", payload.Command)
+		cmd.Stdout, cmd.Stderr = ch, ch.Stderr()
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return
+		}
+		go func() {
+			_, _ = io.Copy(stdin, ch)
+			_ = stdin.Close()
+		}()
+		var status struct{ Status uint32 }
+		if err := cmd.Run(); err != nil {
+			status.Status = 1
+			if ee := new(exec.ExitError); errors.As(err, &ee) {
+				status.Status = uint32(ee.ExitCode())
+			}
+		}
+		_, _ = ch.SendRequest("exit-status", false,
+			xssh.Marshal(&status))
+		return
+	}
+}
+
+func (srv *testServer) machine(t *testing.T, opts ...Option) command.Machine {
+	known := filepath.Join(t.TempDir(), "known_hosts")
+	opts = append([]Option{
+		Identity(srv.key), KnownHosts(known), HostKeys(TOFU),
+	}, opts...)
+	m := Host("tester@"+srv.addr, opts...)
+	t.Cleanup(func() { _ = command.Shutdown(t.Context(), m) })
+	return m
+}
+
+func TestHost(t *testing.T) {
+	srv := newTestServer(t)
+	m := srv.machine(t)
+	ctx := command.WithEnv(t.Context(), map[string]string{"FOO": "b a r"})
+
+	out, err := command.Read(ctx, m, "printf", "%s|", "a b", "$FOO", "'")
+	if err != nil {
+		t.Fatalf("command.Read error: %v", err)
+	}
+	if want := "a b|$FOO|'|"; out != want {
+		t.Errorf("command.Read = %q, want %q", out, want)
+	}
+	out, err = command.Read(ctx, m, "printenv", "FOO")
+	if err != nil {
+		t.Fatalf("command.Read error: %v", err)
+	}
+	if want := "b a r"; out != want {
+		t.Errorf("printenv FOO = %q, want %q", out, want)
+	}
+	if n := srv.conns.Load(); n != 1 {
+		t.Errorf("connections = %d, want 1", n)
+	}
+}
+
+func TestHostStdin(t *testing.T) {
+	srv := newTestServer(t)
+	m := srv.machine(t)
+
+	out, err := command.ReadAll(strings.NewReader("hello\nworld\n"),
+		command.NewFilter(t.Context(), m, "tr", "a-z", "A-Z"))
+	if err != nil {
+		t.Fatalf("command.ReadAll error: %v", err)
+	}
+	if want := "HELLO\nWORLD"; out != want {
+		t.Errorf("output = %q, want %q", out, want)
+	}
+}
+
+func TestHostExitCode(t *testing.T) {
+	srv := newTestServer(t)
+	m := srv.machine(t)
+
+	err := command.Do(t.Context(), m, "sh", "-c", "echo oops >&2; exit 3")
+
+	cerr := new(command.Error)
+	if !errors.As(err, &cerr) {
+		t.Fatalf("command.Do error: got %v, want *command.Error", err)
+	}
+	if cerr.Code != 3 {
+		t.Errorf("Code = %d, want 3", cerr.Code)
+	}
+	if got := strings.TrimSpace(string(cerr.Log)); got != "oops" {
+		t.Errorf("Log = %q, want %q", got, "oops")
+	}
+	if errors.Is(err, ErrConnectionLost) {
+		t.Errorf("command.Do error %v wraps ErrConnectionLost", err)
+	}
+}
+
+func TestHostShutdownReconnects(t *testing.T) {
+	srv := newTestServer(t)
+	m := srv.machine(t)
+
+	if err := command.Do(t.Context(), m, "true"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+	if err := command.Shutdown(t.Context(), m); err != nil {
+		t.Fatalf("command.Shutdown error: %v", err)
+	}
+	if err := command.Do(t.Context(), m, "true"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+
+	if n := srv.conns.Load(); n != 2 {
+		t.Errorf("connections = %d, want 2", n)
+	}
+}
+
+func TestHostKeys(t *testing.T) {
+	srv := newTestServer(t)
+	known := filepath.Join(t.TempDir(), "known_hosts")
+
+	strict := Host(srv.addr, Identity(srv.key), KnownHosts(known))
+	err := command.Do(t.Context(), strict, "true")
+	if !errors.Is(err, ErrConnectionLost) {
+		t.Fatalf("unknown host: got %v, want ErrConnectionLost", err)
+	}
+
+	tofu := Host(srv.addr, Identity(srv.key), KnownHosts(known),
+		HostKeys(TOFU))
+	defer command.Shutdown(t.Context(), tofu)
+	if err := command.Do(t.Context(), tofu, "true"); err != nil {
+		t.Fatalf("TOFU: command.Do error: %v", err)
+	}
+
+	strict = Host(srv.addr, Identity(srv.key), KnownHosts(known))
+	defer command.Shutdown(t.Context(), strict)
+	if err := command.Do(t.Context(), strict, "true"); err != nil {
+		t.Errorf("recorded host: command.Do error: %v", err)
+	}
+}
+
+func TestHostIdentity(t *testing.T) {
+	srv := newTestServer(t)
+	other := newTestServer(t)
+	m := Host(srv.addr, Identity(other.key),
+		KnownHosts(filepath.Join(t.TempDir(), "known_hosts")),
+		HostKeys(TOFU))
+
+	err := command.Do(t.Context(), m, "true")
+
+	if !errors.Is(err, ErrConnectionLost) {
+		t.Errorf("wrong key: got %v, want ErrConnectionLost", err)
+	}
+}
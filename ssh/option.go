@@ -0,0 +1,297 @@
+package ssh
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"lesiw.io/command"
+	"lesiw.io/command/config"
+	"lesiw.io/command/credentials"
+)
+
+// An Option configures a Machine created with [New] or [Host].
+//
+// For New, options add flags of the OpenSSH client to the command line,
+// before its last argument, which must be the destination host. For
+// Host, they configure its connections in the same way.
+type Option func(*machine)
+
+// New is like [Machine], but configures the SSH command line with opts.
+// If the command line runs ssh, the flags of the user's configuration
+// follow those of opts. The configuration is that of each command's
+// context, as returned by [config.From]; if it cannot be read, commands
+// run without its flags.
+//
+//	m := ssh.New(sys.Machine(), []string{"ssh", "app1.internal"},
+//	    ssh.Via("bastion.example.com"),
+//	    ssh.HostKeys(ssh.Strict),
+//	    ssh.KnownHosts("deploy/known_hosts"),
+//	)
+func New(m command.Machine, args []string, opts ...Option) command.Machine {
+	sm := &machine{m: m}
+	for _, opt := range opts {
+		opt(sm)
+	}
+	sm.conf = slices.ContainsFunc(args, isSSH)
+	if len(sm.opts) > 0 && len(args) > 0 {
+		last := len(args) - 1
+		sm.args = append(sm.args, args[:last]...)
+		sm.args = append(sm.args, sm.opts...)
+		sm.args = append(sm.args, args[last])
+	} else {
+		sm.args = append(sm.args, args...)
+	}
+	sm.opts = nil
+	return sm
+}
+
+// configFrom returns the user's configuration for the commands of a
+// context.
+var configFrom = config.From
+
+// cmdline returns the command line of sm for the commands of ctx, with
+// the flags of the user's configuration before the destination.
+// ssh takes the first value of each option, so those of the Machine's
+// options win over the user's.
+func (sm *machine) cmdline(ctx context.Context) []string {
+	if !sm.conf || len(sm.args) == 0 {
+		return sm.args
+	}
+	c, _ := configFrom(ctx) // An empty Config if it cannot be read.
+	if len(c.SSHOptions) == 0 {
+		return sm.args
+	}
+	last := len(sm.args) - 1
+	args := slices.Concat(sm.args[:last], c.SSHOptions)
+	return append(args, sm.args[last])
+}
+
+// isSSH reports whether arg names the OpenSSH client.
+func isSSH(arg string) bool { return path.Base(arg) == "ssh" }
+
+// Via connects through the jump hosts, in order, as with ssh -J. Each
+// host is a destination of the form [user@]host[:port].
+//
+// Most production hosts are only reachable through a bastion:
+//
+//	m := ssh.New(sys.Machine(), []string{"ssh", "db1"}, ssh.Via("bastion"))
+func Via(hosts ...string) Option {
+	return func(m *machine) {
+		if len(hosts) > 0 {
+			m.opts = append(m.opts, "-J", strings.Join(hosts, ","))
+		}
+		m.d.via = append(m.d.via, hosts...)
+	}
+}
+
+// ForwardAgent enables or disables forwarding of the local SSH agent to
+// the remote host, as with ssh -A and ssh -a. Forwarding lets commands
+// authenticate onward as the local user, so enable it only for trusted
+// hosts.
+func ForwardAgent(forward bool) Option {
+	flag := "-a"
+	if forward {
+		flag = "-A"
+	}
+	return func(m *machine) {
+		m.opts = append(m.opts, flag)
+		m.d.agent = forward
+	}
+}
+
+// Identity authenticates with the private keys in files, in order, as
+// with ssh -i, and with no others, so that keys loaded in an agent are not
+// offered first and used up against the server's limit on attempts.
+//
+//	m := ssh.New(sys.Machine(), []string{"ssh", "ci@build1"},
+//	    ssh.Identity("deploy/id_ed25519"),
+//	)
+func Identity(files ...string) Option {
+	return func(m *machine) {
+		for _, f := range files {
+			m.opts = append(m.opts, "-i", f)
+		}
+		if len(files) > 0 {
+			m.opts = append(m.opts, "-o", "IdentitiesOnly=yes")
+		}
+		m.d.keys = append(m.d.keys, files...)
+	}
+}
+
+// A HostKeyPolicy decides whether to trust the keys of unknown hosts.
+type HostKeyPolicy int
+
+const (
+	// Strict refuses to connect to hosts whose keys are not already
+	// known.
+	Strict HostKeyPolicy = iota + 1
+
+	// TOFU (trust on first use) records the keys of new hosts, and
+	// refuses to connect to known hosts whose keys have changed.
+	TOFU
+)
+
+// HostKeys sets the policy for verifying host keys, as with the
+// StrictHostKeyChecking option of ssh.
+func HostKeys(p HostKeyPolicy) Option {
+	var v string
+	switch p {
+	case Strict:
+		v = "yes"
+	case TOFU:
+		v = "accept-new"
+	default:
+		return func(*machine) {}
+	}
+	return func(m *machine) {
+		m.opts = append(m.opts, "-o", "StrictHostKeyChecking="+v)
+		m.d.policy = p
+	}
+}
+
+// KnownHosts sets the files in which host keys are looked up, and in
+// which new ones are recorded, instead of ~/.ssh/known_hosts.
+func KnownHosts(files ...string) Option {
+	quoted := make([]string, len(files))
+	for i, f := range files {
+		if strings.ContainsAny(f, " \t") {
+			f = `"` + f + `"`
+		}
+		quoted[i] = f
+	}
+	return func(m *machine) {
+		m.opts = append(m.opts,
+			"-o", "UserKnownHostsFile="+strings.Join(quoted, " "))
+		m.d.known = files
+	}
+}
+
+// Multiplex shares one connection among all commands to the same host,
+// user, and port, as with the ControlMaster option of ssh, so that each
+// command after the first skips connecting and authenticating. This
+// makes workloads that run many short commands much faster.
+//
+// The connection is shared by every Machine using Multiplex for the
+// host, through a socket in ~/.ssh on the machine running ssh. It stays
+// open for persist after its last command, or until Shutdown. If it is
+// lost, the next command transparently opens a new one.
+//
+// Multiplexing is not supported by the Windows OpenSSH client. A
+// Machine made by [Host] always shares its connection, so it ignores
+// Multiplex.
+func Multiplex(persist time.Duration) Option {
+	secs := max(int64(persist/time.Second), 1)
+	return func(m *machine) {
+		m.mux = true
+		m.opts = append(m.opts,
+			"-o", "ControlMaster=auto",
+			"-o", "ControlPath=~/.ssh/cm-%C",
+			"-o", "ControlPersist="+strconv.FormatInt(secs, 10),
+		)
+	}
+}
+
+// KeepAlive sends a keepalive message to the server whenever the
+// connection has been idle for interval, and drops the connection after
+// count messages go unanswered, as with the ServerAliveInterval and
+// ServerAliveCountMax options of ssh. Dead connections then fail
+// promptly instead of hanging, and idle ones are kept open through
+// firewalls that drop idle connections.
+func KeepAlive(interval time.Duration, count int) Option {
+	secs := max(int64(interval/time.Second), 1)
+	return func(m *machine) {
+		m.opts = append(m.opts,
+			"-o", "ServerAliveInterval="+strconv.FormatInt(secs, 10),
+			"-o", "ServerAliveCountMax="+strconv.Itoa(count),
+		)
+		m.d.alive, m.d.aliveMax = interval, count
+	}
+}
+
+// Password authenticates with the password called name in creds, which
+// is read each time a connection is made. For [New], the password is
+// passed to ssh by sshpass through its environment, never on the command
+// line, so sshpass must be installed where ssh runs. Traces of the
+// command, even full ones, show the password as ***.
+//
+// Prefer keys where possible: passwords are for hosts that allow nothing
+// else, such as appliances and freshly provisioned servers.
+func Password(creds credentials.Store, name string) Option {
+	return func(m *machine) {
+		m.m = &sshpass{m: m.m, creds: creds, name: name}
+		m.d.creds, m.d.credName = creds, name
+	}
+}
+
+// sshpass runs commands under sshpass, with a password from a store.
+type sshpass struct {
+	m     command.Machine
+	creds credentials.Store
+	name  string
+}
+
+func (p *sshpass) Command(
+	ctx context.Context, args ...string,
+) command.Buffer {
+	pw, err := p.creds.Get(ctx, p.name)
+	if err != nil {
+		return command.Fail(fmt.Errorf("ssh: password: %w", err))
+	}
+	ctx = command.WithEnv(ctx, map[string]string{"SSHPASS": pw})
+	buf := p.m.Command(ctx, append([]string{"sshpass", "-e"}, args...)...)
+	c := &passCmd{Buffer: buf, pw: pw}
+	if _, ok := buf.(command.WriteBuffer); ok {
+		return &passWriteCmd{c}
+	}
+	return c
+}
+
+// A passCmd is a command run under sshpass. Its String hides the
+// password, which the underlying command shows with its environment.
+type passCmd struct {
+	command.Buffer
+	pw string
+}
+
+func (c *passCmd) String() string {
+	s := command.String(c.Buffer)
+	if c.pw == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, c.pw, "***")
+}
+
+func (c *passCmd) Close() error {
+	if closer, ok := c.Buffer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (c *passCmd) Attach() error   { return command.Attach(c.Buffer) }
+func (c *passCmd) Log(w io.Writer) { command.Log(c.Buffer, w) }
+
+func (c *passCmd) Result() (command.Result, bool) {
+	return command.ResultOf(c.Buffer)
+}
+
+func (c *passCmd) SpliceFrom(src io.Reader) bool {
+	return command.SpliceFrom(c.Buffer, src)
+}
+
+func (c *passCmd) SpliceTo(dst io.Writer) bool {
+	return command.SpliceTo(c.Buffer, dst)
+}
+
+// A passWriteCmd is a passCmd whose command takes input.
+type passWriteCmd struct{ *passCmd }
+
+func (c *passWriteCmd) Write(p []byte) (int, error) {
+	return c.Buffer.(command.WriteBuffer).Write(p)
+}
@@ -0,0 +1,229 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+
+	"lesiw.io/command"
+)
+
+// ErrConnectionLost is wrapped by the errors of commands run by a
+// [Resilient] Machine, or by a Machine made by [Host], that failed because
+// the connection to the host was lost, rather than because of the command
+// itself.
+var ErrConnectionLost = errors.New("ssh: connection lost")
+
+// lost matches the diagnostics of the OpenSSH client when it loses, or
+// cannot establish, its connection. The client then exits with status 255.
+var lost = regexp.MustCompile(`Connection (reset|timed out|closed|refused)` +
+	`|Broken pipe|client_loop: send disconnect|Network is unreachable` +
+	`|No route to host|Could not resolve hostname|Operation timed out` +
+	`|Timeout, server \S+ not responding|kex_exchange_identification`)
+
+// reconnectDelay is the delay before the first reconnect. It doubles
+// each time.
+var reconnectDelay = time.Second
+
+type idempotentKey struct{}
+
+// WithIdempotent returns a new context that marks the commands run with
+// it as idempotent: safe to run again from the start after a lost
+// connection, even if they had partly run. A [Resilient] Machine only
+// retries idempotent commands.
+func WithIdempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+func idempotent(ctx context.Context) bool {
+	ok, _ := ctx.Value(idempotentKey{}).(bool)
+	return ok
+}
+
+// Resilient returns a command.Machine that runs commands on m, an ssh
+// Machine, and tells a lost connection apart from a failed command. When
+// the connection is lost, a command marked with [WithIdempotent] is run
+// again on a new connection, up to attempts times in total. Other
+// commands, and idempotent ones that run out of attempts, fail with an
+// error wrapping [ErrConnectionLost].
+//
+// A retried command starts over, so its reader sees the output of the
+// lost attempt, followed by the output of the next. Commands that were
+// given input are not retried, since their input cannot be replayed.
+//
+//	m := ssh.Resilient(ssh.Machine(sys.Machine(), "ssh", "host"), 5)
+//	err := command.Do(ssh.WithIdempotent(ctx), m, "rsync", "-a", src, dst)
+func Resilient(m command.Machine, attempts int) command.Machine {
+	return &resilientMachine{m: m, attempts: attempts}
+}
+
+type resilientMachine struct {
+	m        command.Machine
+	attempts int
+}
+
+func (m *resilientMachine) Command(
+	ctx context.Context, arg ...string,
+) command.Buffer {
+	c := &resilientCmd{m: m, ctx: ctx, arg: arg, left: m.attempts - 1}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.start()
+	return c
+}
+
+func (m *resilientMachine) OS(ctx context.Context) string {
+	return command.OS(ctx, m.m)
+}
+
+func (m *resilientMachine) Arch(ctx context.Context) string {
+	return command.Arch(ctx, m.m)
+}
+
+var _ command.ShutdownMachine = (*resilientMachine)(nil)
+
+func (m *resilientMachine) Shutdown(ctx context.Context) error {
+	return command.Shutdown(ctx, m.m)
+}
+
+type resilientCmd struct {
+	m    *resilientMachine
+	ctx  context.Context
+	arg  []string
+	left int // Retries left.
+	wait time.Duration
+
+	mu     sync.Mutex
+	buf    command.Buffer
+	input  bool // Whether input was written.
+	closed bool // Whether input was closed.
+	log    io.Writer
+	stderr bytes.Buffer // Of the current attempt.
+}
+
+// start starts a new attempt. c.mu must be held.
+func (c *resilientCmd) start() {
+	c.stderr.Reset()
+	c.buf = c.m.m.Command(c.ctx, c.arg...)
+	command.Log(c.buf, resilientLog{c})
+	if w, ok := c.buf.(io.Closer); ok && c.closed {
+		_ = w.Close()
+	}
+}
+
+// lost reports whether err means the connection was lost. c.mu must be
+// held.
+func (c *resilientCmd) lost(err error) bool {
+	if errors.Is(err, ErrConnectionLost) {
+		return true // From a Machine made by Host.
+	}
+	var cerr *command.Error
+	if !errors.As(err, &cerr) || cerr.Code != 255 {
+		return false
+	}
+	return lost.Match(c.stderr.Bytes()) || lost.Match(cerr.Log)
+}
+
+func (c *resilientCmd) Read(p []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		buf := c.buf
+		c.mu.Unlock()
+
+		n, err := buf.Read(p)
+		if err == nil || err == io.EOF {
+			return n, err
+		}
+
+		c.mu.Lock()
+		if !c.lost(err) {
+			c.mu.Unlock()
+			return n, err
+		}
+		retry := idempotent(c.ctx) && !c.input && c.left > 0
+		c.mu.Unlock()
+		if !retry || c.ctx.Err() != nil {
+			return n, connectionLost(err)
+		}
+
+		c.left--
+		if c.wait == 0 {
+			c.wait = reconnectDelay
+		} else {
+			c.wait *= 2
+		}
+		select {
+		case <-c.ctx.Done():
+			return n, connectionLost(err)
+		case <-time.After(c.wait):
+		}
+
+		c.mu.Lock()
+		c.start()
+		c.mu.Unlock()
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// connectionLost wraps err with ErrConnectionLost, unless it already is.
+func connectionLost(err error) error {
+	if errors.Is(err, ErrConnectionLost) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", ErrConnectionLost, err)
+}
+
+func (c *resilientCmd) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	c.input = true
+	buf := c.buf
+	c.mu.Unlock()
+	if w, ok := buf.(command.WriteBuffer); ok {
+		return w.Write(p)
+	}
+	return 0, command.ErrReadOnly
+}
+
+func (c *resilientCmd) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	buf := c.buf
+	c.mu.Unlock()
+	if w, ok := buf.(io.Closer); ok {
+		return w.Close()
+	}
+	return nil
+}
+
+func (c *resilientCmd) Log(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.log = w
+}
+
+func (c *resilientCmd) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return command.String(c.buf)
+}
+
+// A resilientLog records the diagnostic output of a resilientCmd and
+// passes it on to the destination set by Log.
+type resilientLog struct{ c *resilientCmd }
+
+func (w resilientLog) Write(p []byte) (int, error) {
+	w.c.mu.Lock()
+	defer w.c.mu.Unlock()
+	w.c.stderr.Write(p)
+	if w.c.log != nil {
+		return w.c.log.Write(p)
+	}
+	return len(p), nil
+}
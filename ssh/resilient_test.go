@@ -0,0 +1,80 @@
+package ssh
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+)
+
+func lostConnection() command.Buffer {
+	return command.Fail(&command.Error{
+		Err:  errors.New("exit status 255"),
+		Code: 255,
+		Log:  []byte("client_loop: send disconnect: Broken pipe\n"),
+	})
+}
+
+func TestResilientRetriesIdempotent(t *testing.T) {
+	testHookOS = func() string { return "linux" }
+	t.Cleanup(func() { testHookOS = nil })
+	delay := reconnectDelay
+	reconnectDelay = time.Millisecond
+	t.Cleanup(func() { reconnectDelay = delay })
+
+	m := new(mock.Machine)
+	m.Return(lostConnection(), "ssh")
+	m.Return(strings.NewReader("done\n"), "ssh")
+	rm := Resilient(New(m, []string{"ssh", "host"}), 3)
+
+	out, err := command.Read(WithIdempotent(t.Context()), rm, "uptime")
+	if err != nil {
+		t.Fatalf("command.Read error: %v", err)
+	}
+	if out != "done" {
+		t.Errorf("command.Read = %q, want %q", out, "done")
+	}
+	if got := len(mock.Calls(m, "ssh")); got != 2 {
+		t.Errorf("got %d ssh calls, want 2", got)
+	}
+}
+
+func TestResilientConnectionLost(t *testing.T) {
+	testHookOS = func() string { return "linux" }
+	t.Cleanup(func() { testHookOS = nil })
+
+	m := new(mock.Machine)
+	m.Return(lostConnection(), "ssh")
+	rm := Resilient(New(m, []string{"ssh", "host"}), 3)
+
+	err := command.Do(t.Context(), rm, "apt-get", "install", "-y", "git")
+	if !errors.Is(err, ErrConnectionLost) {
+		t.Errorf("command.Do error = %v, want ErrConnectionLost", err)
+	}
+	if got := len(mock.Calls(m, "ssh")); got != 1 {
+		t.Errorf("got %d ssh calls, want 1", got)
+	}
+}
+
+func TestResilientCommandFailure(t *testing.T) {
+	testHookOS = func() string { return "linux" }
+	t.Cleanup(func() { testHookOS = nil })
+
+	m := new(mock.Machine)
+	m.Return(command.Fail(&command.Error{
+		Err:  errors.New("exit status 1"),
+		Code: 1,
+	}), "ssh")
+	rm := Resilient(New(m, []string{"ssh", "host"}), 3)
+
+	err := command.Do(WithIdempotent(t.Context()), rm, "false")
+	if err == nil || errors.Is(err, ErrConnectionLost) {
+		t.Errorf("command.Do error = %v, want plain failure", err)
+	}
+	if got := len(mock.Calls(m, "ssh")); got != 1 {
+		t.Errorf("got %d ssh calls, want 1", got)
+	}
+}
@@ -1,10 +1,21 @@
 // Package ssh implements a command.Machine that executes commands over SSH.
 //
+// [Machine] and [New] run an SSH client, such as OpenSSH, on another
+// machine. [Host] connects by itself with golang.org/x/crypto/ssh, and
+// reuses one connection for all of its commands:
+//
+//	m := ctr.Machine(ssh.Host("build-host"), "alpine")
+//
 // Unlike raw SSH execution, ssh.Machine preserves argument boundaries
 // and passes environment variables from the context, using the
 // mechanism appropriate to the remote operating system: a quoting
 // shell wrapper with VAR=value prefixes on Unix, and a base64-encoded
 // PowerShell script on Windows.
+//
+// [Put], [Get], and [SyncDir] copy files to and from an ssh.Machine with
+// sftp, so that the remote host needs no archiving tools. [command.Dial]
+// connects through an ssh.Machine over a channel of its connection, so
+// that the remote host needs no relay tools either.
 package ssh
 
 import (
@@ -30,11 +41,11 @@ import (
 //	ctx := command.WithEnv(ctx, map[string]string{"FOO": "bar"})
 //	command.Read(ctx, m, "printenv", "FOO")
 //	// Effectively: ssh user@host FOO=bar printenv FOO
+//
+// To configure the SSH client with options, such as to connect through a
+// bastion host, use [New]. To connect without an SSH client, use [Host].
 func Machine(m command.Machine, args ...string) command.Machine {
-	return &machine{
-		m:    m,
-		args: args,
-	}
+	return New(m, args)
 }
 
 var testHookOS func() string
@@ -42,6 +53,10 @@ var testHookOS func() string
 type machine struct {
 	m    command.Machine
 	args []string
+	opts []string // Client flags from options, before New.
+	mux  bool     // Whether the connection is shared.
+	conf bool     // Whether args take the user's configured flags.
+	d    dialer   // Settings of options, for connections made by Host.
 	once sync.Once
 	os   string
 	arch string
@@ -61,7 +76,12 @@ func (sm *machine) Command(
 	//     sh -c 'exec "$@"' sh 'arg1' 'arg2' ...
 	// where exec "$@" preserves argument boundaries and each arg is
 	// individually quoted so the remote shell passes it through literally.
-	inner := `exec "$@"`
+	inner := `exec "$@"` + sh.Redirect(
+		command.StdoutFile(ctx),
+		command.StderrFile(ctx),
+		command.MergedStderr(ctx),
+	)
+	ctx = command.WithoutCommandOptions(ctx)
 	dir := fs.WorkDir(ctx)
 	if dir != "" {
 		inner = "cd " + sh.Quote(dir) + " && " + inner
@@ -81,17 +101,42 @@ func (sm *machine) Command(
 		ctx = command.WithoutEnv(ctx)
 	}
 
-	fullArgs := append(append([]string(nil), sm.args...), args...)
+	fullArgs := append(append([]string(nil), sm.cmdline(ctx)...), args...)
 	return sm.m.Command(ctx, fullArgs...)
 }
 
+var _ command.ShutdownMachine = (*machine)(nil)
+
+// Shutdown stops a shared connection from accepting new commands, if
+// the machine was created with Multiplex. It closes once the commands
+// using it finish.
+//
+// For a Machine made by [Host], Shutdown closes its connection. The next
+// command opens a new one.
+func (sm *machine) Shutdown(ctx context.Context) error {
+	if c, ok := sm.m.(*client); ok {
+		return c.Close()
+	}
+	if !sm.mux || len(sm.args) == 0 {
+		return nil
+	}
+	cmdline := sm.cmdline(ctx)
+	last := len(cmdline) - 1
+	args := append([]string(nil), cmdline[:last]...)
+	args = append(args, "-O", "stop", cmdline[last])
+	// There is nothing to stop if the connection was never opened or has
+	// already closed, so errors are not reported.
+	_ = command.Do(ctx, sm.m, args...)
+	return nil
+}
+
 func (sm *machine) init(ctx context.Context) {
 	sm.once.Do(func() {
 		if h := testHookOS; h != nil {
 			sm.os = h()
 			return
 		}
-		probe := sub.Machine(sm.m, sm.args...)
+		probe := sub.Machine(sm.m, sm.cmdline(ctx)...)
 		sm.os = command.OS(ctx, probe)
 		sm.arch = command.Arch(ctx, probe)
 	})
@@ -1,19 +1,30 @@
 package ssh
 
 import (
+	"context"
 	"encoding/base64"
+	"errors"
 	"io"
+	"runtime"
+	"slices"
 	"strings"
 	"testing"
 	"time"
 	"unicode/utf16"
 
 	"lesiw.io/command"
+	"lesiw.io/command/config"
+	"lesiw.io/command/credentials"
 	"lesiw.io/command/ctr"
+	"lesiw.io/command/internal/configtest"
 	"lesiw.io/command/mock"
 	"lesiw.io/command/sys"
 )
 
+// TestMain keeps the tests from depending on the configuration file of
+// the user who runs them.
+func TestMain(m *testing.M) { configtest.Main(m, "") }
+
 // sshMachine returns an ssh.Machine connected to an SSH container on
 // localhost:2222. If no container is reachable, one is started and torn
 // down at test end. An already-running container is reused (without
@@ -223,6 +234,28 @@ func TestMachineNoEnvVars_Mock(t *testing.T) {
 	}
 }
 
+func TestMachineMergedStderr_Mock(t *testing.T) {
+	testHookOS = func() string { return "linux" }
+	t.Cleanup(func() { testHookOS = nil })
+
+	m := new(mock.Machine)
+
+	sshm := Machine(m, "user@host")
+
+	ctx := command.WithMergedStderr(t.Context(), true)
+	_, _ = io.ReadAll(sshm.Command(ctx, "echo", "hello"))
+
+	calls := mock.Calls(m)
+	if len(calls) == 0 {
+		t.Fatal("expected at least one call")
+	}
+	args := calls[len(calls)-1].Args
+	want := `sh -c 'exec "$@" 2>&1' sh echo hello`
+	if got := args[len(args)-1]; got != want {
+		t.Errorf("remote command = %q, want %q", got, want)
+	}
+}
+
 func TestMachineSSHOptions_Mock(t *testing.T) {
 	testHookOS = func() string { return "linux" }
 	t.Cleanup(func() { testHookOS = nil })
@@ -384,3 +417,228 @@ func TestMachineEnvVarsQuoted_Unix_Mock(t *testing.T) {
 		t.Errorf("quoted env prefix missing: %v", calls[0].Args)
 	}
 }
+
+func TestMachineStdoutFile_Mock(t *testing.T) {
+	testHookOS = func() string { return "linux" }
+	t.Cleanup(func() { testHookOS = nil })
+
+	m := new(mock.Machine)
+
+	sshm := Machine(m, "user@host")
+
+	ctx := command.WithStdoutFile(t.Context(), "/tmp/out log")
+	ctx = command.WithStderrFile(ctx, "err.log")
+	_, _ = io.ReadAll(sshm.Command(ctx, "echo", "hello"))
+
+	calls := mock.Calls(m)
+	if len(calls) == 0 {
+		t.Fatal("expected at least one call")
+	}
+	args := calls[len(calls)-1].Args
+	want := `sh -c 'exec "$@" >'\''/tmp/out log'\'' 2>err.log' sh echo hello`
+	if got := args[len(args)-1]; got != want {
+		t.Errorf("remote command = %q, want %q", got, want)
+	}
+}
+
+func TestNewOptions_Mock(t *testing.T) {
+	testHookOS = func() string { return "linux" }
+	t.Cleanup(func() { testHookOS = nil })
+
+	m := new(mock.Machine)
+
+	sshm := New(m, []string{"ssh", "-p", "2222", "user@host"},
+		Via("jump1", "admin@jump2:2200"),
+		ForwardAgent(false),
+		HostKeys(TOFU),
+		KnownHosts("/etc/ssh/fleet_hosts", "/tmp/my hosts"),
+	)
+	_, _ = io.ReadAll(sshm.Command(t.Context(), "echo", "hello"))
+
+	calls := mock.Calls(m)
+	if len(calls) == 0 {
+		t.Fatal("expected at least one call")
+	}
+	want := []string{
+		"ssh", "-p", "2222",
+		"-J", "jump1,admin@jump2:2200",
+		"-a",
+		"-o", "StrictHostKeyChecking=accept-new",
+		"-o", `UserKnownHostsFile=/etc/ssh/fleet_hosts "/tmp/my hosts"`,
+		"user@host", `sh -c 'exec "$@"' sh echo hello`,
+	}
+	got := calls[len(calls)-1].Args
+	if strings.Join(got, "\x00") != strings.Join(want, "\x00") {
+		t.Errorf("args = %q, want %q", got, want)
+	}
+}
+
+func TestMultiplex_Mock(t *testing.T) {
+	testHookOS = func() string { return "linux" }
+	t.Cleanup(func() { testHookOS = nil })
+
+	m := new(mock.Machine)
+
+	sshm := New(m, []string{"ssh", "user@host"},
+		Multiplex(10*time.Minute),
+		KeepAlive(15*time.Second, 3),
+	)
+	_, _ = io.ReadAll(sshm.Command(t.Context(), "true"))
+	if err := command.Shutdown(t.Context(), sshm); err != nil {
+		t.Fatalf("command.Shutdown error: %v", err)
+	}
+
+	calls := mock.Calls(m, "ssh")
+	if len(calls) < 2 {
+		t.Fatalf("got %d ssh calls, want at least 2", len(calls))
+	}
+	opts := []string{
+		"ssh",
+		"-o", "ControlMaster=auto",
+		"-o", "ControlPath=~/.ssh/cm-%C",
+		"-o", "ControlPersist=600",
+		"-o", "ServerAliveInterval=15",
+		"-o", "ServerAliveCountMax=3",
+	}
+	for i, want := range [][]string{
+		append(opts[:len(opts):len(opts)],
+			"user@host", `sh -c 'exec "$@"' sh true`),
+		append(opts[:len(opts):len(opts)], "-O", "stop", "user@host"),
+	} {
+		got := calls[len(calls)-2+i].Args
+		if strings.Join(got, "\x00") != strings.Join(want, "\x00") {
+			t.Errorf("call %d: args = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestPassword_Mock(t *testing.T) {
+	testHookOS = func() string { return "linux" }
+	t.Cleanup(func() { testHookOS = nil })
+
+	m := new(mock.Machine)
+	creds := new(credentials.Memory)
+	creds.Set("admin", "hunter2")
+
+	sshm := New(m, []string{"ssh", "admin@host"},
+		Password(creds, "admin"))
+	_, _ = io.ReadAll(sshm.Command(t.Context(), "true"))
+
+	calls := mock.Calls(m, "sshpass")
+	if len(calls) == 0 {
+		t.Fatal("expected an sshpass call")
+	}
+	call := calls[len(calls)-1]
+	want := []string{
+		"sshpass", "-e", "ssh", "admin@host", `sh -c 'exec "$@"' sh true`,
+	}
+	if strings.Join(call.Args, "\x00") != strings.Join(want, "\x00") {
+		t.Errorf("args = %q, want %q", call.Args, want)
+	}
+	if got := call.Env["SSHPASS"]; got != "hunter2" {
+		t.Errorf("SSHPASS = %q, want %q", got, "hunter2")
+	}
+}
+
+func TestPasswordTrace(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	t.Setenv("CMDTRACE", "full")
+	creds := new(credentials.Memory)
+	creds.Set("admin", "hunter2")
+	p := &sshpass{m: sys.Machine(), creds: creds, name: "admin"}
+	var trace strings.Builder
+	ctx := command.WithTrace(t.Context(), &trace)
+
+	// sshpass need not be installed: the command is traced either way.
+	_ = command.Do(ctx, p, "true")
+
+	if got := trace.String(); strings.Contains(got, "hunter2") {
+		t.Errorf("trace = %q, want no password", got)
+	} else if !strings.Contains(got, "SSHPASS=*** sshpass -e true") {
+		t.Errorf("trace = %q, want the hidden SSHPASS", got)
+	}
+}
+
+func TestIdentity_Mock(t *testing.T) {
+	testHookOS = func() string { return "linux" }
+	t.Cleanup(func() { testHookOS = nil })
+
+	m := new(mock.Machine)
+
+	sshm := New(m, []string{"ssh", "ci@build1"},
+		Identity("deploy/id_ed25519", "deploy/id_rsa"),
+		ForwardAgent(true),
+	)
+	_, _ = io.ReadAll(sshm.Command(t.Context(), "true"))
+
+	calls := mock.Calls(m, "ssh")
+	if len(calls) == 0 {
+		t.Fatal("expected an ssh call")
+	}
+	want := []string{
+		"ssh",
+		"-i", "deploy/id_ed25519",
+		"-i", "deploy/id_rsa",
+		"-o", "IdentitiesOnly=yes",
+		"-A",
+		"ci@build1", `sh -c 'exec "$@"' sh true`,
+	}
+	got := calls[len(calls)-1].Args
+	if strings.Join(got, "\x00") != strings.Join(want, "\x00") {
+		t.Errorf("args = %q, want %q", got, want)
+	}
+}
+
+func TestConfigOptions_Mock(t *testing.T) {
+	testHookOS = func() string { return "linux" }
+	t.Cleanup(func() { testHookOS = nil })
+	ctx := config.With(t.Context(), &config.Config{SSHOptions: []string{
+		"-o", "ServerAliveInterval=30", "-o", "User=deploy",
+	}})
+
+	m := new(mock.Machine)
+
+	sshm := New(m, []string{"ssh", "build1"}, KeepAlive(15*time.Second, 3))
+	_, _ = io.ReadAll(sshm.Command(ctx, "true"))
+
+	calls := mock.Calls(m, "ssh")
+	if len(calls) == 0 {
+		t.Fatal("expected an ssh call")
+	}
+	want := []string{
+		"ssh",
+		"-o", "ServerAliveInterval=15",
+		"-o", "ServerAliveCountMax=3",
+		"-o", "ServerAliveInterval=30",
+		"-o", "User=deploy",
+		"build1", `sh -c 'exec "$@"' sh true`,
+	}
+	got := calls[len(calls)-1].Args
+	if strings.Join(got, "\x00") != strings.Join(want, "\x00") {
+		t.Errorf("args = %q, want %q", got, want)
+	}
+}
+
+func TestConfigError_Mock(t *testing.T) {
+	testHookOS = func() string { return "linux" }
+	t.Cleanup(func() { testHookOS = nil })
+	old := configFrom
+	configFrom = func(context.Context) (*config.Config, error) {
+		return new(config.Config), errors.New("config: bad file")
+	}
+	t.Cleanup(func() { configFrom = old })
+
+	m := new(mock.Machine)
+	sshm := New(m, []string{"ssh", "build1"})
+
+	if err := command.Do(t.Context(), sshm, "true"); err != nil {
+		t.Errorf("Do error = %v, want nil", err)
+	}
+	want := []string{"ssh", "build1", `sh -c 'exec "$@"' sh true`}
+	calls := mock.Calls(m, "ssh")
+	if len(calls) != 1 || !slices.Equal(calls[0].Args, want) {
+		t.Errorf("ssh calls = %v, want %q", calls, want)
+	}
+}
@@ -0,0 +1,409 @@
+package ssh
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"lesiw.io/command"
+	lfs "lesiw.io/fs"
+)
+
+// A TransferOption configures [Put], [Get], and [SyncDir].
+type TransferOption func(*transfer)
+
+type transfer struct {
+	mode  bool // Preserve permissions and modification times.
+	links bool // Copy symlinks as links.
+}
+
+// PreserveMode keeps the permissions and modification times of copied
+// files.
+func PreserveMode() TransferOption {
+	return func(t *transfer) { t.mode = true }
+}
+
+// PreserveLinks makes [SyncDir] copy symbolic links as links, rather
+// than copying the files they point to. Link targets are copied as they
+// are, so relative links keep working only within the copied tree.
+func PreserveLinks() TransferOption {
+	return func(t *transfer) { t.links = true }
+}
+
+var errFallback = errors.New("ssh: transfer tool unavailable")
+
+// Put copies the local file at local to remote on m.
+//
+// If m is an ssh Machine, Put uses sftp, with the same client options,
+// so that the remote host needs no tools beyond its SFTP server. If sftp
+// is unavailable, it uses scp, and if that is unavailable too, or m is
+// not an ssh Machine, or was made by [Host], it copies the file through
+// commands on m, as the filesystem of command.FS does.
+func Put(
+	ctx context.Context, m command.Machine, local, remote string,
+	opts ...TransferOption,
+) error {
+	t := newTransfer(opts)
+	if sm, ok := m.(*machine); ok && sm.cli() {
+		err := sm.sftp(ctx, "put"+t.flag()+" "+batchQuote(local)+" "+
+			batchQuote(remote)+"\n")
+		if !errors.Is(err, errFallback) {
+			return err
+		}
+		err = sm.scp(ctx, t, local, sm.dest()+":"+remote)
+		if !errors.Is(err, errFallback) {
+			return err
+		}
+	}
+	return t.put(ctx, m, local, remote)
+}
+
+// Get copies the file at remote on m to local, as [Put] copies files to
+// m.
+func Get(
+	ctx context.Context, m command.Machine, remote, local string,
+	opts ...TransferOption,
+) error {
+	t := newTransfer(opts)
+	if sm, ok := m.(*machine); ok && sm.cli() {
+		err := sm.sftp(ctx, "get"+t.flag()+" "+batchQuote(remote)+" "+
+			batchQuote(local)+"\n")
+		if !errors.Is(err, errFallback) {
+			return err
+		}
+		err = sm.scp(ctx, t, sm.dest()+":"+remote, local)
+		if !errors.Is(err, errFallback) {
+			return err
+		}
+	}
+	return t.get(ctx, m, remote, local)
+}
+
+// SyncDir copies the local directory tree at local into the directory
+// remote on m, creating directories as needed and replacing files that
+// exist. Files on m that are not in local are left alone.
+//
+// Like [Put], SyncDir uses sftp if m is an ssh Machine, and otherwise
+// commands on m. It does not use scp, which cannot merge into an
+// existing directory.
+func SyncDir(
+	ctx context.Context, m command.Machine, local, remote string,
+	opts ...TransferOption,
+) error {
+	t := newTransfer(opts)
+	if sm, ok := m.(*machine); ok && sm.cli() {
+		batch, err := t.syncBatch(local, remote)
+		if err != nil {
+			return err
+		}
+		if err := sm.sftp(ctx, batch); !errors.Is(err, errFallback) {
+			return err
+		}
+	}
+	return t.sync(ctx, m, local, remote)
+}
+
+func newTransfer(opts []TransferOption) *transfer {
+	t := new(transfer)
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+func (t *transfer) flag() string {
+	if t.mode {
+		return " -p"
+	}
+	return ""
+}
+
+// syncBatch returns the sftp commands that copy the tree at local into
+// remote. Commands prefixed with - may fail, as when a directory exists.
+func (t *transfer) syncBatch(local, remote string) (string, error) {
+	var b strings.Builder
+	err := walk(local, t.links, func(rel string, d fs.DirEntry) error {
+		dst := remote
+		if rel != "." {
+			dst = path.Join(remote, filepath.ToSlash(rel))
+		}
+		src := filepath.Join(local, rel)
+		switch {
+		case d.IsDir():
+			b.WriteString("-mkdir " + batchQuote(dst) + "\n")
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(src)
+			if err != nil {
+				return err
+			}
+			b.WriteString("-rm " + batchQuote(dst) + "\n")
+			b.WriteString("symlink " + batchQuote(target) + " " +
+				batchQuote(dst) + "\n")
+		default:
+			b.WriteString("put" + t.flag() + " " + batchQuote(src) + " " +
+				batchQuote(dst) + "\n")
+		}
+		return nil
+	})
+	return b.String(), err
+}
+
+// walk calls fn for each file in the tree at root, with its path
+// relative to root. Symlinks are followed unless links is set.
+func walk(
+	root string, links bool, fn func(rel string, d fs.DirEntry) error,
+) error {
+	return walkFrom(root, links, map[string]bool{}, fn)
+}
+
+func walkFrom(
+	root string, links bool, seen map[string]bool,
+	fn func(rel string, d fs.DirEntry) error,
+) error {
+	if real, err := filepath.EvalSymlinks(root); err == nil {
+		if seen[real] {
+			return fmt.Errorf("symlink loop at %s", root)
+		}
+		seen[real] = true
+		defer delete(seen, real)
+	}
+	return filepath.WalkDir(root, func(
+		name string, d fs.DirEntry, err error,
+	) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		if links || d.Type()&fs.ModeSymlink == 0 {
+			return fn(rel, d)
+		}
+		target, err := filepath.EvalSymlinks(name)
+		if err != nil {
+			return err
+		}
+		info, err := os.Stat(target)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fn(rel, fs.FileInfoToDirEntry(info))
+		}
+		// WalkDir does not descend into links to directories.
+		return walkFrom(target, links, seen, func(
+			sub string, d fs.DirEntry,
+		) error {
+			return fn(filepath.Join(rel, sub), d)
+		})
+	})
+}
+
+// batchQuote quotes s as one argument of an sftp batch command, in which
+// paths are also glob patterns.
+func batchQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		if strings.ContainsRune(`"\*?[]`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// sftp runs the sftp batch on the host of sm. It returns errFallback if
+// sftp is not available on either end.
+func (sm *machine) sftp(ctx context.Context, batch string) error {
+	args, ok := sm.client(ctx, "sftp")
+	if !ok {
+		return errFallback
+	}
+	if _, ok := sm.m.(*sshpass); ok {
+		// Batch mode would otherwise disable password authentication.
+		args = append(args, "-o", "BatchMode=no")
+	}
+	args = append(args, "-b", "-", sm.dest())
+	err := command.Run(ctx, sm.m, command.Spec{
+		Args:  args,
+		Stdin: strings.NewReader(batch),
+	})
+	if command.NotFound(err) || noSubsystem(err) {
+		return errFallback
+	}
+	return err
+}
+
+// scp copies src to dst with scp. It returns errFallback if scp is not
+// available on either end.
+func (sm *machine) scp(
+	ctx context.Context, t *transfer, src, dst string,
+) error {
+	args, ok := sm.client(ctx, "scp")
+	if !ok {
+		return errFallback
+	}
+	if t.mode {
+		args = append(args, "-p")
+	}
+	args = append(args, "--", src, dst)
+	err := command.Do(ctx, sm.m, args...)
+	if command.NotFound(err) || noSubsystem(err) {
+		return errFallback
+	}
+	return err
+}
+
+func noSubsystem(err error) bool {
+	var cerr *command.Error
+	return errors.As(err, &cerr) &&
+		strings.Contains(string(cerr.Log), "subsystem request failed")
+}
+
+// cli reports whether sm runs an SSH client, rather than connecting by
+// itself.
+func (sm *machine) cli() bool { return len(sm.args) > 0 }
+
+// dest returns the destination host of sm.
+func (sm *machine) dest() string { return sm.args[len(sm.args)-1] }
+
+// client returns the command line that runs tool, sftp or scp, with the
+// options of sm's ssh command line, up to the destination. It reports
+// false if sm does not run the OpenSSH client, or uses options that tool
+// does not share.
+func (sm *machine) client(
+	ctx context.Context, tool string,
+) ([]string, bool) {
+	cmdline := sm.cmdline(ctx)
+	i := slices.Index(cmdline, "ssh")
+	if i < 0 || i+2 > len(cmdline) {
+		return nil, false
+	}
+	args := append(slices.Clone(cmdline[:i]), tool)
+	flags := cmdline[i+1 : len(cmdline)-1]
+	for j := 0; j < len(flags); j++ {
+		switch f := flags[j]; f {
+		case "-4", "-6", "-C", "-q", "-v":
+			args = append(args, f)
+		case "-A", "-a", "-n", "-T", "-t":
+			// Session options, which do not apply.
+		case "-c", "-F", "-i", "-J", "-o", "-p", "-l":
+			if j++; j == len(flags) {
+				return nil, false
+			}
+			switch f {
+			case "-p":
+				f = "-P"
+			case "-l":
+				// The login name: sftp and scp use -l for bandwidth.
+				args = append(args, "-o", "User="+flags[j])
+				continue
+			}
+			args = append(args, f, flags[j])
+		default:
+			return nil, false
+		}
+	}
+	return args, true
+}
+
+// put copies local to remote with commands on m.
+func (t *transfer) put(
+	ctx context.Context, m command.Machine, local, remote string,
+) error {
+	src, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	fsys := command.FS(m)
+	dst, err := lfs.Create(ctx, fsys, remote)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || !t.mode {
+		return err
+	}
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	return errors.Join(
+		lfs.Chmod(ctx, fsys, remote, info.Mode().Perm()),
+		lfs.Chtimes(ctx, fsys, remote, info.ModTime(), info.ModTime()),
+	)
+}
+
+// get copies remote to local with commands on m.
+func (t *transfer) get(
+	ctx context.Context, m command.Machine, remote, local string,
+) error {
+	fsys := command.FS(m)
+	src, err := lfs.Open(ctx, fsys, remote)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(local)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || !t.mode {
+		return err
+	}
+	info, err := lfs.Stat(ctx, fsys, remote)
+	if err != nil {
+		return err
+	}
+	return errors.Join(
+		os.Chmod(local, info.Mode().Perm()),
+		os.Chtimes(local, info.ModTime(), info.ModTime()),
+	)
+}
+
+// sync copies the tree at local into remote with commands on m.
+func (t *transfer) sync(
+	ctx context.Context, m command.Machine, local, remote string,
+) error {
+	return walk(local, t.links, func(rel string, d fs.DirEntry) error {
+		dst := remote
+		if rel != "." {
+			dst = path.Join(remote, filepath.ToSlash(rel))
+		}
+		src := filepath.Join(local, rel)
+		switch {
+		case d.IsDir():
+			return lfs.MkdirAll(ctx, command.FS(m), dst)
+		case d.Type()&fs.ModeSymlink != 0:
+			target, err := os.Readlink(src)
+			if err != nil {
+				return err
+			}
+			err = command.Do(ctx, m, "ln", "-sfn", target, dst)
+			if err != nil {
+				return fmt.Errorf("failed to link %s: %w", dst, err)
+			}
+			return nil
+		default:
+			return t.put(ctx, m, src, dst)
+		}
+	})
+}
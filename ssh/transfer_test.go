@@ -0,0 +1,127 @@
+package ssh
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mem"
+	"lesiw.io/command/mock"
+	"lesiw.io/fs"
+)
+
+func TestPutSFTP(t *testing.T) {
+	m := new(mock.Machine)
+	sshm := New(m, []string{"ssh", "-p", "2222", "-l", "bob", "host"})
+
+	err := Put(t.Context(), sshm, "a b.txt", "/srv/[x].txt", PreserveMode())
+	if err != nil {
+		t.Fatalf("Put error: %v", err)
+	}
+
+	calls := mock.Calls(m, "sftp")
+	if len(calls) != 1 {
+		t.Fatalf("got %d sftp calls, want 1", len(calls))
+	}
+	wantArgs := []string{
+		"sftp", "-P", "2222", "-o", "User=bob", "-b", "-", "host",
+	}
+	if got := calls[0].Args; strings.Join(got, " ") !=
+		strings.Join(wantArgs, " ") {
+		t.Errorf("sftp args = %q, want %q", got, wantArgs)
+	}
+	want := `put -p "a b.txt" "/srv/\[x\].txt"` + "\n"
+	if got := string(calls[0].Got); got != want {
+		t.Errorf("sftp batch = %q, want %q", got, want)
+	}
+}
+
+func TestGetSCPFallback(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(command.Fail(&command.Error{
+		Err: errors.New("executable file not found"),
+	}), "sftp")
+	sshm := New(m, []string{"ssh", "-A", "user@host"})
+
+	err := Get(t.Context(), sshm, "/var/log/app.log", "app.log")
+	if err != nil {
+		t.Fatalf("Get error: %v", err)
+	}
+
+	calls := mock.Calls(m, "scp")
+	if len(calls) != 1 {
+		t.Fatalf("got %d scp calls, want 1", len(calls))
+	}
+	want := []string{"scp", "--", "user@host:/var/log/app.log", "app.log"}
+	if got := calls[0].Args; strings.Join(got, " ") !=
+		strings.Join(want, " ") {
+		t.Errorf("scp args = %q, want %q", got, want)
+	}
+}
+
+func TestSyncDirSFTP(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks need privileges on Windows")
+	}
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	f := filepath.Join(dir, "sub", "f")
+	if err := os.WriteFile(f, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("sub/f", filepath.Join(dir, "l")); err != nil {
+		t.Fatal(err)
+	}
+	m := new(mock.Machine)
+	sshm := New(m, []string{"ssh", "host"})
+
+	if err := SyncDir(t.Context(), sshm, dir, "/srv",
+		PreserveLinks()); err != nil {
+		t.Fatalf("SyncDir error: %v", err)
+	}
+
+	calls := mock.Calls(m, "sftp")
+	if len(calls) != 1 {
+		t.Fatalf("got %d sftp calls, want 1", len(calls))
+	}
+	want := strings.Join([]string{
+		`-mkdir "/srv"`,
+		`-rm "/srv/l"`,
+		`symlink "sub/f" "/srv/l"`,
+		`-mkdir "/srv/sub"`,
+		`put "` + f + `" "/srv/sub/f"`,
+	}, "\n") + "\n"
+	if got := string(calls[0].Got); got != want {
+		t.Errorf("sftp batch:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSyncDirExec(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	err := os.WriteFile(filepath.Join(dir, "sub", "f"), []byte("x"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, m := t.Context(), mem.Machine()
+
+	if err := SyncDir(ctx, m, dir, "dst"); err != nil {
+		t.Fatalf("SyncDir error: %v", err)
+	}
+
+	got, err := fs.ReadFile(ctx, command.FS(m), "dst/sub/f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "x" {
+		t.Errorf("dst/sub/f = %q, want %q", got, "x")
+	}
+}
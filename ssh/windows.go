@@ -3,6 +3,7 @@ package ssh
 import (
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"regexp"
 	"strings"
@@ -21,6 +22,11 @@ import (
 func (sm *machine) windowsCommand(
 	ctx context.Context, args ...string,
 ) command.Buffer {
+	if command.StdoutFile(ctx) != "" || command.StderrFile(ctx) != "" {
+		return command.Fail(fmt.Errorf(
+			"ssh: redirecting output on windows: %w", errors.ErrUnsupported,
+		))
+	}
 	var script strings.Builder
 	// Stop makes failures terminate the script: without it, a failed
 	// Set-Location would leave the command running in the wrong
@@ -42,7 +48,7 @@ func (sm *machine) windowsCommand(
 	}
 	script.WriteString("\nexit $LASTEXITCODE\n")
 
-	fullArgs := append(append([]string(nil), sm.args...),
+	fullArgs := append(append([]string(nil), sm.cmdline(ctx)...),
 		"powershell.exe", "-NoProfile", "-NonInteractive",
 		"-EncodedCommand", psEncode(script.String()),
 	)
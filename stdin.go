@@ -0,0 +1,117 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrStdinNotClosed is returned by [Run] when a command is stopped
+// because of a timeout set with [WithStdinTimeout].
+var ErrStdinNotClosed = errors.New("command: stdin not closed")
+
+type stdinKey struct{}
+
+// WithStdinTimeout returns a context whose commands, when run by [Run],
+// and so [Read] and [Do], with no input, are stopped once they produce
+// no output for d. Such a command is usually blocked reading its input,
+// which Run leaves open and nobody will write to or close. When the
+// time passes, Run stops the command and fails with
+// [ErrStdinNotClosed]. A timeout of 0 or less clears it.
+//
+// A command that legitimately runs quietly for longer, such as sleep,
+// fails too, so d should be well above the time any quiet command
+// needs. Commands attached to a terminal are not watched.
+//
+//	ctx := command.WithStdinTimeout(ctx, time.Minute)
+//	out, err := command.Read(ctx, m, "cat") // Fails after a minute.
+func WithStdinTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, stdinKey{}, max(d, 0))
+}
+
+func stdinTimeout(ctx context.Context) time.Duration {
+	d, _ := ctx.Value(stdinKey{}).(time.Duration)
+	return d
+}
+
+// A stdinWatch stops a command that is idle for longer than its timeout.
+type stdinWatch struct {
+	timeout time.Duration
+	cancel  context.CancelFunc
+
+	mu    sync.Mutex
+	timer *time.Timer
+	fired bool
+}
+
+func watchStdin(
+	ctx context.Context, timeout time.Duration,
+) (context.Context, *stdinWatch) {
+	ctx, cancel := context.WithCancel(ctx)
+	sw := &stdinWatch{timeout: timeout, cancel: cancel}
+	// The timer is set under the lock, so that fire cannot see it unset
+	// and take the watch for stopped.
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.timer = time.AfterFunc(timeout, sw.fire)
+	return ctx, sw
+}
+
+func (sw *stdinWatch) fire() {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.timer == nil {
+		return // Stopped.
+	}
+	sw.fired = true
+	sw.cancel()
+}
+
+// touch records activity, restarting the timeout.
+func (sw *stdinWatch) touch() {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	if sw.timer != nil && !sw.fired {
+		sw.timer.Reset(sw.timeout)
+	}
+}
+
+// stop stops watching, and returns the error to report in place of err
+// if the command was stopped.
+func (sw *stdinWatch) stop(err error, args []string) error {
+	sw.mu.Lock()
+	defer sw.mu.Unlock()
+	sw.timer.Stop()
+	sw.timer = nil
+	sw.cancel()
+	if !sw.fired {
+		return err
+	}
+	name := "command"
+	if len(args) > 0 {
+		name = args[0]
+	}
+	return fmt.Errorf("%w: %s produced no output for %v "+
+		"while waiting with its input open; "+
+		"give it input with Spec.Stdin, "+
+		"or raise the timeout of WithStdinTimeout",
+		ErrStdinNotClosed, name, sw.timeout)
+}
+
+// writer returns a writer that records activity and writes to w.
+func (sw *stdinWatch) writer(w io.Writer) io.Writer {
+	return touchWriter{sw, w}
+}
+
+type touchWriter struct {
+	sw *stdinWatch
+	w  io.Writer
+}
+
+func (t touchWriter) Write(p []byte) (int, error) {
+	t.sw.touch()
+	return t.w.Write(p)
+}
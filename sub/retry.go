@@ -0,0 +1,188 @@
+package sub
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"lesiw.io/command"
+)
+
+// Transient is the default set of patterns that [Retry] treats as
+// transient errors: failures to reach a daemon or API server that
+// usually succeed when tried again.
+var Transient = []string{
+	`i/o timeout`,
+	`TLS handshake timeout`,
+	`connection reset by peer`,
+	`unexpected EOF`,
+	`(?i)server is currently unable to handle the request`,
+	`Client\.Timeout exceeded`,
+}
+
+// retryDelay is the delay before the first retry. It doubles each time.
+var retryDelay = 250 * time.Millisecond
+
+// Retry returns a command.Machine that runs commands on m, typically a sub
+// Machine for a CLI that controls a daemon, and runs a failed command
+// again, up to attempts times in total, if its error or diagnostic output
+// matches one of patterns. If no patterns are given, [Transient] is used.
+// Patterns are regular expressions; Retry panics if one is invalid.
+//
+// A command is retried only if it failed before producing output or
+// being given input, so retries are never observed by its reader.
+//
+//	docker := sub.Retry(sub.Machine(sys.Machine(), "docker"), 3)
+func Retry(
+	m command.Machine, attempts int, patterns ...string,
+) command.Machine {
+	if len(patterns) == 0 {
+		patterns = Transient
+	}
+	re := regexp.MustCompile(strings.Join(patterns, "|"))
+	return &retryMachine{m: m, attempts: attempts, re: re}
+}
+
+type retryMachine struct {
+	m        command.Machine
+	attempts int
+	re       *regexp.Regexp
+}
+
+func (m *retryMachine) Command(
+	ctx context.Context, arg ...string,
+) command.Buffer {
+	c := &retryCmd{m: m, ctx: ctx, arg: arg, left: m.attempts - 1}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.start()
+	return c
+}
+
+type retryCmd struct {
+	m    *retryMachine
+	ctx  context.Context
+	arg  []string
+	left int // Retries left.
+	wait time.Duration
+
+	mu     sync.Mutex
+	buf    command.Buffer
+	used   bool // Whether output was read or input written.
+	closed bool // Whether input was closed.
+	log    io.Writer
+	stderr bytes.Buffer // Of the current attempt.
+}
+
+// start starts a new attempt. c.mu must be held.
+func (c *retryCmd) start() {
+	c.stderr.Reset()
+	c.buf = c.m.m.Command(c.ctx, c.arg...)
+	command.Log(c.buf, logWriter{c})
+	if w, ok := c.buf.(io.Closer); ok && c.closed {
+		_ = w.Close()
+	}
+}
+
+// retry reports whether a command that failed with err should be retried.
+// c.mu must be held.
+func (c *retryCmd) retry(err error) bool {
+	if c.used || c.left <= 0 || errors.Is(err, io.EOF) {
+		return false
+	}
+	if c.ctx.Err() != nil {
+		return false
+	}
+	return c.m.re.MatchString(err.Error()) || c.m.re.Match(c.stderr.Bytes())
+}
+
+func (c *retryCmd) Read(p []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		buf := c.buf
+		c.mu.Unlock()
+
+		n, err := buf.Read(p)
+
+		c.mu.Lock()
+		c.used = c.used || n > 0
+		retry := err != nil && c.retry(err)
+		c.mu.Unlock()
+		if !retry {
+			return n, err
+		}
+
+		c.left--
+		if c.wait == 0 {
+			c.wait = retryDelay
+		} else {
+			c.wait *= 2
+		}
+		select {
+		case <-c.ctx.Done():
+			return n, err
+		case <-time.After(c.wait):
+		}
+
+		c.mu.Lock()
+		if c.used { // Input was written while waiting.
+			c.mu.Unlock()
+			return n, err
+		}
+		c.start()
+		c.mu.Unlock()
+	}
+}
+
+func (c *retryCmd) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	c.used = true
+	buf := c.buf
+	c.mu.Unlock()
+	if w, ok := buf.(command.WriteBuffer); ok {
+		return w.Write(p)
+	}
+	return 0, command.ErrReadOnly
+}
+
+func (c *retryCmd) Close() error {
+	c.mu.Lock()
+	c.closed = true
+	buf := c.buf
+	c.mu.Unlock()
+	if w, ok := buf.(io.Closer); ok {
+		return w.Close()
+	}
+	return nil
+}
+
+func (c *retryCmd) Log(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.log = w
+}
+
+func (c *retryCmd) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return command.String(c.buf)
+}
+
+// A logWriter records the diagnostic output of a retryCmd and passes it
+// on to the destination set by Log.
+type logWriter struct{ c *retryCmd }
+
+func (w logWriter) Write(p []byte) (int, error) {
+	w.c.mu.Lock()
+	defer w.c.mu.Unlock()
+	w.c.stderr.Write(p)
+	if w.c.log != nil {
+		return w.c.log.Write(p)
+	}
+	return len(p), nil
+}
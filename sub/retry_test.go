@@ -0,0 +1,71 @@
+package sub
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+)
+
+func TestRetry(t *testing.T) {
+	old := retryDelay
+	retryDelay = 0
+	t.Cleanup(func() { retryDelay = old })
+
+	m := new(mock.Machine)
+	m.Return(command.Fail(&command.Error{
+		Err:  errors.New("dial tcp 10.0.0.1:443: i/o timeout"),
+		Code: 1,
+	}), "kubectl", "get")
+	m.Return(strings.NewReader("pod/web\n"), "kubectl", "get")
+	k := Retry(Machine(m, "kubectl"), 3)
+
+	out, err := command.Read(t.Context(), k, "get", "pods")
+	if err != nil {
+		t.Fatalf("command.Read error: %v", err)
+	}
+	if out != "pod/web" {
+		t.Errorf("command.Read: got %q, want %q", out, "pod/web")
+	}
+	if got := len(mock.Calls(m, "kubectl", "get")); got != 2 {
+		t.Errorf("calls: got %d, want 2", got)
+	}
+}
+
+func TestRetryGivesUp(t *testing.T) {
+	old := retryDelay
+	retryDelay = 0
+	t.Cleanup(func() { retryDelay = old })
+
+	m := new(mock.Machine)
+	m.Return(command.Fail(&command.Error{
+		Err:  errors.New("TLS handshake timeout"),
+		Code: 1,
+	}), "kubectl")
+	k := Retry(Machine(m, "kubectl"), 3)
+
+	if err := command.Do(t.Context(), k, "get", "pods"); err == nil {
+		t.Fatal("command.Do error: got nil, want error")
+	}
+	if got := len(mock.Calls(m)); got != 3 {
+		t.Errorf("calls: got %d, want 3", got)
+	}
+}
+
+func TestRetryPermanentError(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(command.Fail(&command.Error{
+		Err:  errors.New("forbidden"),
+		Code: 1,
+	}), "kubectl")
+	k := Retry(Machine(m, "kubectl"), 3, `i/o timeout`)
+
+	if err := command.Do(t.Context(), k, "get", "pods"); err == nil {
+		t.Fatal("command.Do error: got nil, want error")
+	}
+	if got := len(mock.Calls(m)); got != 1 {
+		t.Errorf("calls: got %d, want 1", got)
+	}
+}
@@ -4,12 +4,18 @@ package sub
 
 import (
 	"context"
+	"maps"
+	"path"
+	"slices"
 
 	"lesiw.io/command"
 )
 
 // Machine returns a command.Machine that prefixes all commands with the given
 // prefix arguments, using the provided machine for execution.
+//
+// Arguments added by [WithArgs] for the machine's name, the base name of
+// its first prefix argument, follow the prefix.
 func Machine(m command.Machine, prefix ...string) command.Machine {
 	return &machine{m: m, prefix: prefix}
 }
@@ -20,5 +26,36 @@ type machine struct {
 }
 
 func (m *machine) Command(ctx context.Context, arg ...string) command.Buffer {
-	return m.m.Command(ctx, append(m.prefix, arg...)...)
+	args := slices.Clone(m.prefix)
+	if len(m.prefix) > 0 {
+		args = append(args, FromContext(ctx, path.Base(m.prefix[0]))...)
+	}
+	return m.m.Command(ctx, append(args, arg...)...)
+}
+
+type argsKey struct{}
+
+// WithArgs returns a context that adds args after the prefix of every sub
+// Machine named name, so that code deep in a stack can route commands
+// without constructing new machines. A sub Machine's name is the base
+// name of its first prefix argument. Calls accumulate.
+//
+//	k := sub.Machine(sys.Machine(), "kubectl")
+//	ctx = sub.WithArgs(ctx, "kubectl", "--context", "prod", "-n", "web")
+//	k.Command(ctx, "get", "pods") // kubectl --context prod -n web get pods
+func WithArgs(
+	ctx context.Context, name string, args ...string,
+) context.Context {
+	old, _ := ctx.Value(argsKey{}).(map[string][]string)
+	m := make(map[string][]string, len(old)+1)
+	maps.Copy(m, old)
+	m[name] = append(slices.Clip(m[name]), args...)
+	return context.WithValue(ctx, argsKey{}, m)
+}
+
+// FromContext returns the arguments added by [WithArgs] for sub Machines
+// named name.
+func FromContext(ctx context.Context, name string) []string {
+	m, _ := ctx.Value(argsKey{}).(map[string][]string)
+	return m[name]
 }
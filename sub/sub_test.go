@@ -4,6 +4,8 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
+
 	"lesiw.io/command"
 	"lesiw.io/command/mock"
 	"lesiw.io/command/sub"
@@ -35,3 +37,33 @@ func TestMachineProbingWithPrefix(t *testing.T) {
 		t.Error("expected non-nil FS")
 	}
 }
+
+func TestMachineWithArgs(t *testing.T) {
+	m := new(mock.Machine)
+	k := sub.Machine(m, "/usr/bin/kubectl")
+	ssh := sub.Machine(m, "ssh", "host")
+	ctx := sub.WithArgs(t.Context(), "kubectl", "--context", "prod")
+	ctx = sub.WithArgs(ctx, "kubectl", "-n", "web")
+
+	if err := command.Do(ctx, k, "get", "pods"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+	if err := command.Do(ctx, ssh, "true"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+	if err := command.Do(t.Context(), k, "version"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+
+	want := []mock.Call{
+		{Args: []string{
+			"/usr/bin/kubectl", "--context", "prod", "-n", "web",
+			"get", "pods",
+		}},
+		{Args: []string{"ssh", "host", "true"}},
+		{Args: []string{"/usr/bin/kubectl", "version"}},
+	}
+	if got := mock.Calls(m); !cmp.Equal(got, want) {
+		t.Errorf("calls (-want +got):\n%s", cmp.Diff(want, got))
+	}
+}
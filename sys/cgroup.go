@@ -0,0 +1,154 @@
+package sys
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"lesiw.io/command"
+)
+
+// A Limit limits the resources of the commands in a [Cgroup].
+type Limit func(*limits) error
+
+type limits struct {
+	memory int64  // Bytes; 0 for no limit.
+	pids   int    // Processes; 0 for no limit.
+	parent string // Cgroup in which to confine commands; "" for our own.
+}
+
+// MemoryMax limits the memory each command and its descendants may use
+// together. size is a number of bytes with an optional binary suffix:
+// K, M, G, or T, as in "512M" or "1G".
+func MemoryMax(size string) Limit {
+	return func(l *limits) error {
+		n, err := parseSize(size)
+		if err != nil {
+			return err
+		}
+		l.memory = n
+		return nil
+	}
+}
+
+// PidsMax limits the number of processes each command and its
+// descendants may run at once.
+func PidsMax(n int) Limit {
+	return func(l *limits) error {
+		if n <= 0 {
+			return fmt.Errorf("bad process limit %d", n)
+		}
+		l.pids = n
+		return nil
+	}
+}
+
+func parseSize(size string) (int64, error) {
+	s := strings.TrimSpace(size)
+	mul := int64(1)
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K', 'k':
+			mul = 1 << 10
+		case 'M', 'm':
+			mul = 1 << 20
+		case 'G', 'g':
+			mul = 1 << 30
+		case 'T', 't':
+			mul = 1 << 40
+		}
+		if mul > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("bad size %q", size)
+	}
+	return n * mul, nil
+}
+
+// Under confines commands in cgroups made beneath dir, the directory of a
+// cgroup v2 such as /sys/fs/cgroup/build.slice, rather than beneath the
+// cgroup of the current process. The cgroup must be writable by this
+// process and, for limits, hold no processes of its own, as a cgroup
+// delegated to it by systemd-run -p Delegate=yes holds none once the
+// process has moved to a child of it. Under has no effect on Windows.
+func Under(dir string) Limit {
+	return func(l *limits) error {
+		if !filepath.IsAbs(dir) {
+			return fmt.Errorf("bad cgroup %q: not an absolute path", dir)
+		}
+		l.parent = dir
+		return nil
+	}
+}
+
+// Cgroup returns a command.Machine that executes commands on the local
+// system, as [Machine] does, confining each command and every process it
+// starts to a group with the given resource limits. When the command's
+// context is canceled, or the command exits, every process left in its
+// group is killed, even those that have left its process group.
+//
+// On Linux, each command runs in a new cgroup v2 named for name, the
+// process ID, and a sequence number, beneath the cgroup of the current
+// process, or the cgroup given by [Under], which must be writable, as
+// with systemd's Delegate=yes. Limits require their controllers, memory
+// and pids, to be available there. Since cgroup v2 enables controllers
+// only for cgroups with no processes of their own, other than the root,
+// commands with limits fail if that cgroup holds any; processes are
+// never moved out of it. On Windows, each command runs in a new job
+// object. On other systems, commands fail.
+//
+//	m := sys.Cgroup("build", sys.MemoryMax("1G"), sys.PidsMax(512))
+func Cgroup(name string, limit ...Limit) command.Machine {
+	g := &cgroup{name: name}
+	for _, l := range limit {
+		if err := l(&g.limits); err != nil {
+			g.err = err
+		}
+	}
+	return machine{cgroup: g}
+}
+
+type cgroup struct {
+	name   string
+	limits limits
+	err    error // From limits.
+	seq    atomic.Int64
+}
+
+// A jail confines one command in a cgroup.
+type jail interface {
+	// start prepares cmd to start in the jail.
+	start(cmd *exec.Cmd) error
+	// started adds the process p, just started, to the jail.
+	started(p *os.Process) error
+	// kill kills every process in the jail.
+	kill() error
+	// close kills any processes left in the jail and releases it.
+	close() error
+}
+
+// confine prepares c to run in a new jail of g.
+func (g *cgroup) confine(c *cmd) error {
+	if g.err != nil {
+		return g.err
+	}
+	j, err := g.jail(fmt.Sprintf(
+		"%s-%d-%d", g.name, os.Getpid(), g.seq.Add(1),
+	))
+	if err != nil {
+		return fmt.Errorf("cgroup %s: %w", g.name, err)
+	}
+	if err := j.start(c.cmd); err != nil {
+		return fmt.Errorf("cgroup %s: %w", g.name, err)
+	}
+	c.jail = j
+	c.cmd.Cancel = j.kill
+	return nil
+}
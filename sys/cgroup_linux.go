@@ -0,0 +1,197 @@
+package sys
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+type linuxJail struct {
+	dir string
+	fd  *os.File // Open from start until started.
+}
+
+func (g *cgroup) jail(name string) (jail, error) {
+	var controllers []string
+	if g.limits.memory > 0 {
+		controllers = append(controllers, "+memory")
+	}
+	if g.limits.pids > 0 {
+		controllers = append(controllers, "+pids")
+	}
+	parent, err := jailCgroup(g.limits.parent, controllers)
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(parent, name)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return nil, err
+	}
+	j := &linuxJail{dir: dir}
+	if g.limits.memory > 0 {
+		err = j.write("memory.max", strconv.FormatInt(g.limits.memory, 10))
+	}
+	if err == nil && g.limits.pids > 0 {
+		err = j.write("pids.max", strconv.Itoa(g.limits.pids))
+	}
+	if err != nil {
+		return nil, errors.Join(err, os.Remove(dir))
+	}
+	return j, nil
+}
+
+// jailCgroup returns the cgroup in which to make jails, parent or else
+// the cgroup of this process, with controllers enabled for its children.
+//
+// A cgroup other than the root cannot both hold processes and enable
+// controllers for its children. Moving its processes elsewhere would
+// move processes that are not ours to move, so a cgroup that holds any
+// is refused.
+func jailCgroup(parent string, controllers []string) (string, error) {
+	if parent == "" {
+		var err error
+		if parent, err = ownCgroup(); err != nil {
+			return "", err
+		}
+	}
+	if len(controllers) == 0 {
+		return parent, nil
+	}
+	control := filepath.Join(parent, "cgroup.subtree_control")
+	value := []byte(strings.Join(controllers, " "))
+	err := os.WriteFile(control, value, 0)
+	if errors.Is(err, syscall.EBUSY) {
+		return "", fmt.Errorf("failed to enable controllers: %s holds "+
+			"processes; use sys.Under with an empty delegated cgroup",
+			parent)
+	} else if err != nil {
+		return "", fmt.Errorf("failed to enable controllers: %w", err)
+	}
+	return parent, nil
+}
+
+// ownCgroup returns the directory of the cgroup v2 of this process.
+func ownCgroup() (string, error) {
+	root, err := cgroupMount()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if p, ok := strings.CutPrefix(sc.Text(), "0::"); ok {
+			dir := filepath.Join(root, p)
+			_, err := os.Stat(filepath.Join(dir, "cgroup.controllers"))
+			if err != nil {
+				break
+			}
+			return dir, nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("cgroup v2 is not available")
+}
+
+// cgroupMount returns where the cgroup v2 hierarchy is mounted: usually
+// /sys/fs/cgroup, or /sys/fs/cgroup/unified on hybrid systems.
+func cgroupMount() (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		// The fields after " - " are the type and source of the mount;
+		// the fifth before it is the mount point.
+		pre, post, ok := strings.Cut(sc.Text(), " - ")
+		fields := strings.Fields(pre)
+		if ok && len(fields) >= 5 && strings.HasPrefix(post, "cgroup2 ") {
+			return fields[4], nil
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("cgroup v2 is not available")
+}
+
+func (j *linuxJail) write(file, value string) error {
+	return os.WriteFile(filepath.Join(j.dir, file), []byte(value), 0)
+}
+
+func (j *linuxJail) start(cmd *exec.Cmd) error {
+	fd, err := os.Open(j.dir)
+	if err != nil {
+		return err
+	}
+	j.fd = fd
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(fd.Fd())
+	return nil
+}
+
+func (j *linuxJail) started(*os.Process) error {
+	if j.fd == nil {
+		return nil
+	}
+	err := j.fd.Close()
+	j.fd = nil
+	return err
+}
+
+func (j *linuxJail) kill() error {
+	err := j.write("cgroup.kill", "1")
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// Kernels before 5.14 have no cgroup.kill.
+	procs, err := os.ReadFile(filepath.Join(j.dir, "cgroup.procs"))
+	if err != nil {
+		return err
+	}
+	for pid := range strings.FieldsSeq(string(procs)) {
+		if n, err := strconv.Atoi(pid); err == nil {
+			_ = syscall.Kill(n, syscall.SIGKILL) // Best effort.
+		}
+	}
+	return nil
+}
+
+func (j *linuxJail) close() error {
+	err := j.started(nil)
+	if !j.populated() {
+		return errors.Join(err, os.Remove(j.dir))
+	}
+	err = errors.Join(err, j.kill())
+	// Killed processes leave the cgroup asynchronously.
+	for range 100 {
+		if !j.populated() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return errors.Join(err, os.Remove(j.dir))
+}
+
+func (j *linuxJail) populated() bool {
+	events, err := os.ReadFile(filepath.Join(j.dir, "cgroup.events"))
+	return err == nil && bytes.Contains(events, []byte("populated 1"))
+}
@@ -0,0 +1,119 @@
+package sys
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"lesiw.io/command"
+)
+
+func TestCgroupKillsTree(t *testing.T) {
+	m := Cgroup("test")
+
+	// The background sleep outlives the shell unless the cgroup is killed.
+	out, err := command.Read(t.Context(), m,
+		"sh", "-c", "sleep 60 >/dev/null 2>&1 & echo $!",
+	)
+	if err != nil {
+		if cerr := new(command.Error); errors.As(err, &cerr) &&
+			cerr.Code == 0 {
+			t.Skipf("cgroups unavailable: %v", err)
+		}
+		t.Fatalf("command.Read error: %v", err)
+	}
+	pid, err := strconv.Atoi(out)
+	if err != nil {
+		t.Fatalf("bad pid %q: %v", out, err)
+	}
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for p.Signal(syscall.Signal(0)) == nil {
+		if time.Now().After(deadline) {
+			_ = p.Kill()
+			t.Fatalf("process %d still running after command exited", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestCgroupUnder runs commands with limits in an empty cgroup made for
+// the test, and checks that a cgroup holding a process is refused rather
+// than emptied. It changes the controllers of the cgroup the test runs
+// in, and so runs only if SYS_TEST_CGROUP is set.
+func TestCgroupUnder(t *testing.T) {
+	if os.Getenv("SYS_TEST_CGROUP") == "" {
+		t.Skip("set SYS_TEST_CGROUP=1 to change the test's own cgroup")
+	}
+	own, err := ownCgroup()
+	if err != nil {
+		t.Skipf("cgroup v2 unavailable: %v", err)
+	}
+	ctrls, err := os.ReadFile(filepath.Join(own, "cgroup.controllers"))
+	if err != nil || !strings.Contains(string(ctrls), "pids") {
+		t.Skip("pids controller unavailable")
+	}
+	err = os.WriteFile(filepath.Join(own, "cgroup.subtree_control"),
+		[]byte("+pids"), 0)
+	if err != nil {
+		t.Skipf("cannot enable controllers: %v", err)
+	}
+	newCgroup := func(name string) string {
+		dir := filepath.Join(own, name+"-"+strconv.Itoa(os.Getpid()))
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Skipf("cannot create cgroup: %v", err)
+		}
+		t.Cleanup(func() { _ = os.Remove(dir) })
+		return dir
+	}
+
+	empty := newCgroup("under-test")
+	m := Cgroup("under", Under(empty), PidsMax(64))
+	for range 2 {
+		if err := command.Do(t.Context(), m, "true"); err != nil {
+			t.Fatalf("command.Do error: %v", err)
+		}
+	}
+
+	busy := newCgroup("busy-test")
+	fd, err := os.Open(busy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+	sleep := exec.CommandContext(t.Context(), "sleep", "60")
+	sleep.SysProcAttr = &syscall.SysProcAttr{
+		UseCgroupFD: true,
+		CgroupFD:    int(fd.Fd()),
+	}
+	if err := sleep.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = sleep.Process.Kill()
+		_ = sleep.Wait()
+	}()
+
+	m = Cgroup("busy", Under(busy), PidsMax(64))
+	if err := command.Do(t.Context(), m, "true"); err == nil {
+		t.Error("command.Do error: got nil, want cgroup holds processes")
+	}
+	procs, err := os.ReadFile(filepath.Join(busy, "cgroup.procs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid := strconv.Itoa(sleep.Process.Pid)
+	if !slices.Contains(strings.Fields(string(procs)), pid) {
+		t.Errorf("process %s moved out of %s", pid, busy)
+	}
+}
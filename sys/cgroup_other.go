@@ -0,0 +1,9 @@
+//go:build !linux && !windows
+
+package sys
+
+import "errors"
+
+func (g *cgroup) jail(string) (jail, error) {
+	return nil, errors.ErrUnsupported
+}
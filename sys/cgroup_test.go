@@ -0,0 +1,26 @@
+package sys_test
+
+import (
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/sys"
+)
+
+func TestCgroupBadLimit(t *testing.T) {
+	m := sys.Cgroup("test", sys.MemoryMax("lots"))
+
+	err := command.Do(t.Context(), m, "true")
+	if err == nil {
+		t.Error("command.Do error: got nil, want bad size")
+	}
+}
+
+func TestCgroupUnderRelative(t *testing.T) {
+	m := sys.Cgroup("test", sys.Under("build.slice"))
+
+	err := command.Do(t.Context(), m, "true")
+	if err == nil {
+		t.Error("command.Do error: got nil, want bad cgroup")
+	}
+}
@@ -0,0 +1,63 @@
+package sys
+
+import (
+	"os"
+	"os/exec"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+type windowsJail struct {
+	job windows.Handle
+}
+
+func (g *cgroup) jail(string) (jail, error) {
+	job, err := windows.CreateJobObject(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var info windows.JOBOBJECT_EXTENDED_LIMIT_INFORMATION
+	flags := uint32(windows.JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE)
+	if g.limits.memory > 0 {
+		flags |= windows.JOB_OBJECT_LIMIT_JOB_MEMORY
+		info.JobMemoryLimit = uintptr(g.limits.memory)
+	}
+	if g.limits.pids > 0 {
+		flags |= windows.JOB_OBJECT_LIMIT_ACTIVE_PROCESS
+		info.BasicLimitInformation.ActiveProcessLimit = uint32(g.limits.pids)
+	}
+	info.BasicLimitInformation.LimitFlags = flags
+	_, err = windows.SetInformationJobObject(
+		job, windows.JobObjectExtendedLimitInformation,
+		uintptr(unsafe.Pointer(&info)), uint32(unsafe.Sizeof(info)),
+	)
+	if err != nil {
+		_ = windows.CloseHandle(job)
+		return nil, err
+	}
+	return &windowsJail{job: job}, nil
+}
+
+func (j *windowsJail) start(*exec.Cmd) error { return nil }
+
+func (j *windowsJail) started(p *os.Process) error {
+	h, err := windows.OpenProcess(
+		windows.PROCESS_SET_QUOTA|windows.PROCESS_TERMINATE,
+		false, uint32(p.Pid),
+	)
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(h)
+	return windows.AssignProcessToJobObject(j.job, h)
+}
+
+func (j *windowsJail) kill() error {
+	return windows.TerminateJobObject(j.job, 1)
+}
+
+func (j *windowsJail) close() error {
+	// The job kills its processes when its last handle closes.
+	return windows.CloseHandle(j.job)
+}
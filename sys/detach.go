@@ -0,0 +1,112 @@
+package sys
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"lesiw.io/command"
+)
+
+type detachKey struct{}
+
+// Detach returns a new context whose commands, when run by this package's
+// Machines, start as daemons that outlive the current process. Each
+// command runs in a session of its own, with no standard input, and its
+// process ID is written to pidfile, where [command.Reattach] finds it.
+// Relative pidfile paths are relative to the command's working directory.
+//
+// A detached command's Buffer is done once the command has started: it
+// has no output, and canceling ctx does not stop the command. Output is
+// discarded unless it is sent to files with [command.WithStdoutFile] and
+// [command.WithStderrFile]. On Windows, a command confined by [Cgroup]
+// still ends when the current process does.
+//
+//	ctx := command.WithStdoutFile(sys.Detach(ctx, "app.pid"), "app.log")
+//	err := command.Do(ctx, sys.Machine(), "./app", "serve")
+//	// Later, perhaps from another process:
+//	p, err := command.Reattach(ctx, sys.Machine(), "app.pid")
+//	err = p.Stop(ctx)
+func Detach(ctx context.Context, pidfile string) context.Context {
+	return context.WithValue(ctx, detachKey{}, pidfile)
+}
+
+func detach(ctx context.Context) string {
+	pidfile, _ := ctx.Value(detachKey{}).(string)
+	return pidfile
+}
+
+// startDetached starts the command in a new session and records its
+// process ID, without waiting for it.
+func (c *cmd) startDetached() error {
+	closeAll := func() {
+		for _, cl := range c.closers {
+			_ = cl.Close() // The command has its own copies.
+		}
+	}
+	defer closeAll()
+	if err := c.redirect(); err != nil {
+		return &command.Error{Err: err}
+	}
+	if c.cmd.Stderr == nil && c.merge && c.cmd.Stdout != nil {
+		c.cmd.Stderr = c.cmd.Stdout
+	}
+	if c.cgroup != nil {
+		if err := c.cgroup.confine(c); err != nil {
+			return &command.Error{Err: err}
+		}
+	}
+	setsid(c.cmd)
+	start := time.Now()
+	if err := c.cmd.Start(); err != nil {
+		if c.jail != nil {
+			_ = c.jail.close() // Best effort.
+		}
+		return cmdError(err)
+	}
+	c.timeStart(start)
+	var err error
+	if c.jail != nil {
+		err = c.jail.started(c.cmd.Process)
+	}
+	if err == nil {
+		err = c.writePid(c.cmd.Process.Pid)
+	}
+	if err != nil {
+		_ = c.cmd.Process.Kill() // No one could find it again.
+	}
+	go func() {
+		// Reap the command if it exits before this process does.
+		_ = c.cmd.Wait()
+		if c.jail != nil {
+			_ = c.jail.close()
+		}
+	}()
+	if err != nil {
+		return &command.Error{Err: err}
+	}
+	c.cmdwait <- nil
+	return nil
+}
+
+// writePid writes pid to the command's pidfile, replacing it atomically
+// so that readers never see a partial ID.
+func (c *cmd) writePid(pid int) error {
+	name := c.detach
+	if !filepath.IsAbs(name) && c.cmd.Dir != "" {
+		name = filepath.Join(c.cmd.Dir, name)
+	}
+	tmp := name + ".tmp"
+	data := []byte(strconv.Itoa(pid) + "\n")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write pidfile: %w", err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		_ = os.Remove(tmp)
+		return fmt.Errorf("failed to write pidfile: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,7 @@
+//go:build !unix && !windows
+
+package sys
+
+import "os/exec"
+
+func setsid(*exec.Cmd) {}
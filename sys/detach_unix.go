@@ -0,0 +1,17 @@
+//go:build unix
+
+package sys
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setsid starts cmd in a new session, so that it has no controlling
+// terminal and does not receive the signals sent to this process's group.
+func setsid(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	cmd.SysProcAttr.Setsid = true
+}
@@ -0,0 +1,18 @@
+package sys
+
+import (
+	"os/exec"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// setsid starts cmd without a console and in a process group of its own,
+// so that it does not receive this process's console events.
+func setsid(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	cmd.SysProcAttr.CreationFlags |= windows.DETACHED_PROCESS |
+		windows.CREATE_NEW_PROCESS_GROUP
+}
@@ -0,0 +1,25 @@
+package sys
+
+import (
+	"context"
+	"os"
+)
+
+type extraFilesKey struct{}
+
+// WithExtraFiles returns a new context that passes files to commands run
+// by this package's Machines as open file descriptors, in addition to
+// standard input, output, and error. files[i] becomes descriptor 3+i, as
+// with exec.Cmd.ExtraFiles. Later calls replace earlier ones.
+//
+// Passing a listening socket lets a test run a server that expects socket
+// activation, or a tool that speaks a protocol on descriptor 3.
+// Extra files are not supported on Windows.
+func WithExtraFiles(ctx context.Context, files ...*os.File) context.Context {
+	return context.WithValue(ctx, extraFilesKey{}, files)
+}
+
+func extraFiles(ctx context.Context) []*os.File {
+	files, _ := ctx.Value(extraFilesKey{}).([]*os.File)
+	return files
+}
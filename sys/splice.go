@@ -0,0 +1,59 @@
+package sys
+
+import (
+	"io"
+	"os"
+
+	"lesiw.io/command"
+)
+
+var _ command.SpliceBuffer = (*cmd)(nil)
+
+// SpliceFrom makes src the command's input, if src is a file and the
+// command has not started.
+func (c *cmd) SpliceFrom(src io.Reader) bool {
+	f, ok := src.(*os.File)
+	return ok && c.stdin(f)
+}
+
+// SpliceTo makes dst the command's output, if dst is a file and the
+// command has not started.
+func (c *cmd) SpliceTo(dst io.Writer) bool {
+	f, ok := dst.(*os.File)
+	return ok && c.stdout(f)
+}
+
+// stdin makes f the input of c, if c can take it.
+func (c *cmd) stdin(f *os.File) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.canStdin() {
+		return false
+	}
+	c.cmd.Stdin = f
+	return true
+}
+
+// stdout makes f the output of c, if c can take it.
+func (c *cmd) stdout(f *os.File) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.canStdout() {
+		return false
+	}
+	c.cmd.Stdout = f
+	return true
+}
+
+// canStdin reports whether c's input can be handed over. c.mu must be
+// held.
+func (c *cmd) canStdin() bool {
+	return !c.begun && c.detach == "" && c.cmd.Stdin == nil
+}
+
+// canStdout reports whether c's output can be handed over. c.mu must be
+// held.
+func (c *cmd) canStdout() bool {
+	return !c.begun && c.detach == "" && c.cmd.Stdout == nil &&
+		!c.merge && command.StdoutFile(c.ctx) == ""
+}
@@ -3,7 +3,6 @@
 package sys
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -16,6 +15,7 @@ import (
 
 	"lesiw.io/command"
 	"lesiw.io/command/internal/sh"
+	"lesiw.io/command/internal/spill"
 	"lesiw.io/fs"
 	"lesiw.io/fs/osfs"
 	"lesiw.io/fs/path"
@@ -36,12 +36,18 @@ var (
 // on the local system.
 func Machine() command.Machine { return machine{} }
 
-type machine struct{}
+type machine struct {
+	cgroup *cgroup
+}
 
 var _ command.Machine = (*machine)(nil)
 
-func (machine) Command(ctx context.Context, arg ...string) command.Buffer {
-	return newCmd(ctx, arg...)
+func (m machine) Command(ctx context.Context, arg ...string) command.Buffer {
+	buf := newCmd(ctx, arg...)
+	if c, ok := buf.(*cmd); ok {
+		c.cgroup = m.cgroup
+	}
+	return buf
 }
 
 var _ command.FSMachine = (*machine)(nil)
@@ -56,9 +62,10 @@ func (machine) FS() fs.FS {
 }
 
 type cmd struct {
-	ctx context.Context
-	cmd *exec.Cmd
-	env map[string]string
+	ctx   context.Context
+	cmd   *exec.Cmd
+	env   map[string]string
+	merge bool // Send stderr to stdout.
 
 	cmdwait chan error
 
@@ -68,12 +75,27 @@ type cmd struct {
 	reader io.ReadCloser
 	writer io.WriteCloser
 	logger io.Writer
-	logbuf bytes.Buffer
+	logbuf *spill.Buffer
 
 	closers []io.Closer
+
+	cgroup *cgroup
+	jail   jail
+	detach string // The pidfile of a detached command.
+
+	mu    sync.Mutex // Guards begun and the plumbing of cmd.
+	begun bool       // Whether start has been called.
+
+	timingMu sync.Mutex
+	timing   Timing
+	started  time.Time
+	result   command.Result
 }
 
 func (c *cmd) Attach() error {
+	if c.detach != "" {
+		return nil // Detached commands have no terminal.
+	}
 	c.cmd.Stdin = Stdin
 	c.cmd.Stdout = Stdout
 	c.cmd.Stderr = Stderr
@@ -105,8 +127,19 @@ func newCmd(ctx context.Context, args ...string) command.Buffer {
 
 	c := new(cmd)
 	c.ctx = ctx
-	c.cmd = exec.CommandContext(ctx, args[0], args[1:]...)
 	c.env = command.Envs(ctx)
+	c.detach = detach(ctx)
+	execCtx := ctx
+	if c.detach != "" {
+		execCtx = context.WithoutCancel(ctx) // It must outlive ctx.
+	}
+	lookup := time.Now()
+	c.cmd = exec.CommandContext(
+		execCtx, lookPath(args[0], c.env), args[1:]...,
+	)
+	c.timing.Lookup = time.Since(lookup)
+	c.cmd.Args[0] = args[0]
+	c.merge = command.MergedStderr(ctx)
 
 	dir := fs.WorkDir(ctx)
 	if dir != "" && !path.IsAbs(dir) {
@@ -119,6 +152,7 @@ func newCmd(ctx context.Context, args ...string) command.Buffer {
 		}
 	}
 	c.cmd.Dir = dir
+	c.cmd.ExtraFiles = extraFiles(ctx)
 	c.cmd.Env = os.Environ()
 	for k, v := range c.env {
 		c.cmd.Env = append(c.cmd.Env, k+"="+v)
@@ -131,7 +165,37 @@ func newCmd(ctx context.Context, args ...string) command.Buffer {
 	return c
 }
 
+// lookPath resolves file against the PATH in env, if env sets one,
+// since os/exec otherwise searches the PATH of the current process.
+func lookPath(file string, env map[string]string) string {
+	p, ok := env["PATH"]
+	if !ok || filepath.Base(file) != file {
+		return file
+	}
+	for _, dir := range filepath.SplitList(p) {
+		if dir == "" {
+			continue
+		}
+		if lp, err := exec.LookPath(filepath.Join(dir, file)); err == nil {
+			return lp
+		}
+	}
+	return file
+}
+
 func (c *cmd) startFunc() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.begun = true
+	if c.detach != "" {
+		return c.startDetached()
+	}
+	if err := c.redirect(); err != nil {
+		for _, cl := range c.closers {
+			_ = cl.Close() // Best effort.
+		}
+		return &command.Error{Err: err}
+	}
 	if c.cmd.Stdin == nil {
 		w, err := c.cmd.StdinPipe()
 		if err != nil {
@@ -145,21 +209,52 @@ func (c *cmd) startFunc() error {
 		c.cmd.Stdout = w
 		c.closers = append(c.closers, w)
 	}
+	toFile := command.StdoutFile(c.ctx) != ""
+	if c.cmd.Stderr == nil && c.merge && (c.reader != nil || toFile) {
+		// Sharing one writer makes os/exec share one pipe or file, so
+		// the command's writes keep their order.
+		c.cmd.Stderr = c.cmd.Stdout
+	}
 	if c.cmd.Stderr == nil {
 		if c.logger == nil {
-			c.cmd.Stderr = &c.logbuf
+			c.logbuf = spill.New(command.LogLimit(c.ctx),
+				command.LogSpill(c.ctx))
+			c.cmd.Stderr = c.logbuf
 		} else {
 			c.cmd.Stderr = c.logger
 		}
 	}
+	if c.cgroup != nil {
+		if err := c.cgroup.confine(c); err != nil {
+			for _, cl := range c.closers {
+				_ = cl.Close() // Best effort.
+			}
+			return &command.Error{Err: err}
+		}
+	}
+	start := time.Now()
 	if err := c.cmd.Start(); err != nil {
 		for _, cl := range c.closers {
 			_ = cl.Close() // Best effort.
 		}
+		if c.jail != nil {
+			_ = c.jail.close() // Best effort.
+		}
 		return cmdError(err)
 	}
+	c.timeStart(start)
+	var jailErr error
+	if c.jail != nil {
+		if jailErr = c.jail.started(c.cmd.Process); jailErr != nil {
+			_ = c.cmd.Process.Kill() // It must not run unconfined.
+		}
+	}
 	go func() {
 		err := c.cmd.Wait()
+		c.timeFinish()
+		if c.jail != nil {
+			err = errors.Join(err, jailErr, c.jail.close())
+		}
 
 		// Workaround for pipe cleanup race conditions on some systems.
 		// On certain systems (e.g., Windows with PowerShell), Wait() can
@@ -177,6 +272,38 @@ func (c *cmd) startFunc() error {
 	return nil
 }
 
+// redirect opens the files that the context asks output to be written
+// to, and hands them to the command.
+func (c *cmd) redirect() error {
+	open := func(name string) (*os.File, error) {
+		if !filepath.IsAbs(name) && c.cmd.Dir != "" {
+			name = filepath.Join(c.cmd.Dir, name)
+		}
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o666)
+		if err != nil {
+			return nil, err
+		}
+		// The command has its own copy once started.
+		c.closers = append(c.closers, f)
+		return f, nil
+	}
+	if name := command.StdoutFile(c.ctx); name != "" {
+		f, err := open(name)
+		if err != nil {
+			return err
+		}
+		c.cmd.Stdout = f
+	}
+	if name := command.StderrFile(c.ctx); name != "" {
+		f, err := open(name)
+		if err != nil {
+			return err
+		}
+		c.cmd.Stderr = f
+	}
+	return nil
+}
+
 func (c *cmd) Write(bytes []byte) (int, error) {
 	if err := c.start(); err != nil {
 		return 0, err
@@ -192,6 +319,12 @@ func (c *cmd) Write(bytes []byte) (int, error) {
 }
 
 func (c *cmd) Close() error {
+	c.mu.Lock()
+	handed := !c.begun && c.cmd.Stdin != nil
+	c.mu.Unlock()
+	if handed {
+		return nil // Its input is a file or pipe, not ours to close.
+	}
 	if err := c.start(); err != nil {
 		return err
 	}
@@ -213,7 +346,7 @@ func (c *cmd) Read(bytes []byte) (int, error) {
 	ch := make(chan struct {
 		n   int
 		err error
-	})
+	}, 1)
 	var n int
 	var err error
 	if c.reader == nil {
@@ -232,9 +365,15 @@ func (c *cmd) Read(bytes []byte) (int, error) {
 	case <-c.ctx.Done():
 		n = 0
 		err = io.EOF
+		// Output that will not be read must not block the command's
+		// exit, or waiting for it would never return.
+		if pr, ok := c.reader.(*io.PipeReader); ok {
+			_ = pr.CloseWithError(io.ErrClosedPipe)
+		}
 	case ret := <-ch:
 		n = ret.n
 		err = ret.err
+		c.timeRead(n)
 	}
 
 skipread:
@@ -252,13 +391,19 @@ func (c *cmd) Log(w io.Writer) {
 
 func (c *cmd) waitFunc() error {
 	err := <-c.cmdwait
+	if c.logbuf != nil {
+		defer c.logbuf.Close()
+	}
 	if err != nil {
 		cmdErr := cmdError(err)
 		// Add log buffer if available
-		if ce, ok := cmdErr.(*command.Error); ok && c.logger == nil {
-			ce.Log = c.logbuf.Bytes()
+		if ce, ok := cmdErr.(*command.Error); ok && c.logbuf != nil {
+			ce.Log, ce.LogFile = c.logbuf.Bytes(), c.logbuf.Name()
 		}
-		return cmdErr
+		return command.Interrupted(c.ctx, cmdErr)
+	}
+	if c.logbuf != nil {
+		_ = c.logbuf.Remove()
 	}
 	return nil
 }
@@ -8,12 +8,15 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"lesiw.io/command"
 	"lesiw.io/command/sys"
+	"lesiw.io/fs"
 )
 
 func testBinary(t *testing.T) string {
@@ -108,6 +111,10 @@ func TestCallFailure(t *testing.T) {
 	if log != wantLog {
 		t.Errorf("Log = %q, want %q", log, wantLog)
 	}
+
+	if got := strings.TrimSpace(string(cmdErr.Output)); got != want {
+		t.Errorf("Output = %q, want %q", got, want)
+	}
 }
 
 func TestCallBadCommand(t *testing.T) {
@@ -164,3 +171,419 @@ func TestContextCancellation(t *testing.T) {
 		t.Fatal("command did not terminate within 10 seconds")
 	}
 }
+
+func TestReadCanceledPartialOutput(t *testing.T) {
+	m, ctx := sys.Machine(), t.Context()
+
+	if os.Getenv("CMD_TEST_PROC") == "1" {
+		fmt.Println("partial")
+		_ = os.Stdout.Sync()
+		select {}
+	}
+	t.Setenv("CMD_TEST_PROC", "1")
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	out, err := command.Read(ctx, m,
+		testBinary(t), "-test.run=TestReadCanceledPartialOutput")
+	if err == nil {
+		t.Fatal("Read().error = <nil>, want error")
+	}
+	if want := "partial"; out != want {
+		t.Errorf("Read().output = %q, want %q", out, want)
+	}
+	var cmdErr *command.Error
+	if !errors.As(err, &cmdErr) {
+		t.Fatalf("expected *command.Error, got %T", err)
+	}
+	if got, want := string(cmdErr.Output), "partial\n"; got != want {
+		t.Errorf("Output = %q, want %q", got, want)
+	}
+}
+
+func TestCombinedOutput(t *testing.T) {
+	m, ctx := sys.Machine(), t.Context()
+
+	if os.Getenv("CMD_TEST_PROC") == "1" {
+		fmt.Println("out 1")
+		fmt.Fprintln(os.Stderr, "err 1")
+		fmt.Println("out 2")
+		fmt.Fprintln(os.Stderr, "err 2")
+		os.Exit(0)
+	}
+	t.Setenv("CMD_TEST_PROC", "1")
+
+	out, err := command.CombinedOutput(ctx, m,
+		testBinary(t), "-test.run=TestCombinedOutput")
+	if err != nil {
+		t.Fatalf("CombinedOutput() error = %v", err)
+	}
+	if want := "out 1\nerr 1\nout 2\nerr 2"; out != want {
+		t.Errorf("CombinedOutput() = %q, want %q", out, want)
+	}
+}
+
+func TestExecEnvPath(t *testing.T) {
+	m, ctx := sys.Machine(), t.Context()
+
+	if os.Getenv("CMD_TEST_PROC") == "1" {
+		os.Exit(0)
+	}
+	t.Setenv("CMD_TEST_PROC", "1")
+
+	data, err := os.ReadFile(testBinary(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, name := t.TempDir(), "cmd-test-path"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	err = os.WriteFile(filepath.Join(dir, name), data, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx = command.WithEnv(ctx, map[string]string{"PATH": dir})
+
+	err = command.Exec(ctx, m, "cmd-test-path", "-test.run=TestExecEnvPath")
+	if err != nil {
+		t.Errorf("Exec() = %q, want <nil>", err)
+	}
+}
+
+func TestExecStdoutFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	dir := t.TempDir()
+	ctx := fs.WithWorkDir(t.Context(), dir)
+	ctx = command.WithStdoutFile(ctx, "out.txt")
+	ctx = command.WithStderrFile(ctx, filepath.Join(dir, "err.txt"))
+
+	out, err := command.Read(ctx, sys.Machine(),
+		"sh", "-c", "echo hello; echo oops >&2",
+	)
+	if err != nil {
+		t.Fatalf("command.Read error: %v", err)
+	}
+	if out != "" {
+		t.Errorf("command.Read: got %q, want no output", out)
+	}
+	for name, want := range map[string]string{
+		"out.txt": "hello\n",
+		"err.txt": "oops\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestCombinedOutputFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	name := filepath.Join(t.TempDir(), "log.txt")
+	ctx := command.WithStdoutFile(t.Context(), name)
+
+	_, err := command.CombinedOutput(ctx, sys.Machine(),
+		"sh", "-c", "echo a; echo b >&2; echo c",
+	)
+	if err != nil {
+		t.Fatalf("command.CombinedOutput error: %v", err)
+	}
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "a\nb\nc\n"; string(got) != want {
+		t.Errorf("log.txt: got %q, want %q", got, want)
+	}
+}
+
+func TestExecExtraFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("extra files are not supported on Windows")
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	ctx := sys.WithExtraFiles(t.Context(), w)
+
+	err = command.Do(ctx, sys.Machine(), "sh", "-c", "echo ready >&3")
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "ready\n" {
+		t.Errorf("fd 3: got %q, want %q", got, "ready\n")
+	}
+}
+
+func TestExecDetach(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses sh")
+	}
+	dir := t.TempDir()
+	pidfile := filepath.Join(dir, "app.pid")
+	logfile := filepath.Join(dir, "app.log")
+	ctx, cancel := context.WithCancel(t.Context())
+	ctx = command.WithStdoutFile(sys.Detach(ctx, pidfile), logfile)
+
+	err := command.Do(ctx, sys.Machine(), "sh", "-c", "echo up; exec sleep 30")
+	if err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+	cancel() // Detached commands outlive their context.
+
+	ctx = t.Context()
+	p, err := command.Reattach(ctx, sys.Machine(), pidfile)
+	if err != nil {
+		t.Fatalf("command.Reattach error: %v", err)
+	}
+	t.Cleanup(func() { _ = p.Kill(ctx) })
+	if !p.Running(ctx) {
+		t.Fatal("Running() = false, want true")
+	}
+	if err := p.Stop(ctx); err != nil {
+		t.Fatalf("Stop() error: %v", err)
+	}
+	if err := p.Wait(ctx); err != nil {
+		t.Fatalf("Wait() error: %v", err)
+	}
+	got, err := os.ReadFile(logfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "up\n" {
+		t.Errorf("app.log: got %q, want %q", got, "up\n")
+	}
+}
+
+func TestTimings(t *testing.T) {
+	buf := sys.Machine().Command(t.Context(), "go", "version")
+	if _, err := io.ReadAll(buf); err != nil {
+		t.Fatalf("io.ReadAll error: %v", err)
+	}
+
+	got, ok := sys.Timings(buf)
+	if !ok {
+		t.Fatal("sys.Timings() ok = false, want true")
+	}
+	if got.Start <= 0 {
+		t.Errorf("Start = %v, want > 0", got.Start)
+	}
+	if got.FirstByte < got.Start {
+		t.Errorf("FirstByte = %v, want >= Start (%v)",
+			got.FirstByte, got.Start)
+	}
+	if _, ok := sys.Timings(command.Fail(io.ErrUnexpectedEOF)); ok {
+		t.Error("sys.Timings(command.Fail()) ok = true, want false")
+	}
+}
+
+func TestResult(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses sh")
+	}
+	r := command.NewReader(t.Context(), sys.Machine(),
+		"sh", "-c", "echo hi; exit 3")
+	if _, ok := command.ResultOf(r.(command.Buffer)); ok {
+		t.Error("ResultOf() ok = true before EOF, want false")
+	}
+	_, err := io.ReadAll(r)
+	if err == nil {
+		t.Fatal("io.ReadAll error = nil, want exit status 3")
+	}
+
+	got, ok := command.ResultOf(r.(command.Buffer))
+	if !ok {
+		t.Fatal("ResultOf() ok = false, want true")
+	}
+	if got.Code != 3 {
+		t.Errorf("Code = %d, want 3", got.Code)
+	}
+	if got.Started.IsZero() || got.Duration() <= 0 {
+		t.Errorf("Started = %v, Duration() = %v, want both set",
+			got.Started, got.Duration())
+	}
+}
+
+func TestInterrupted(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses sh")
+	}
+	m := sys.Machine()
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	err := command.Do(ctx, m, "sleep", "10")
+	if !errors.Is(err, command.ErrDeadline) {
+		t.Errorf("timed out: err = %v, want ErrDeadline", err)
+	}
+
+	ctx, cancel = context.WithCancel(t.Context())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	err = command.Do(ctx, m, "sleep", "10")
+	if !errors.Is(err, command.ErrCanceled) {
+		t.Errorf("canceled: err = %v, want ErrCanceled", err)
+	}
+
+	err = command.Do(t.Context(), m, "sh", "-c", "kill -9 $$")
+	if !errors.Is(err, command.ErrKilled) {
+		t.Errorf("killed: err = %v, want ErrKilled", err)
+	}
+}
+
+func TestCopySplicesFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses sh")
+	}
+	ctx, m, dir := t.Context(), sys.Machine(), t.TempDir()
+	in, err := os.Create(filepath.Join(dir, "in"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+	if _, err := in.WriteString("hello\n"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.Create(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+
+	n, err := command.Copy(out, in,
+		command.NewFilter(ctx, m, "tr", "a-z", "A-Z"),
+		command.NewFilter(ctx, m, "cat"),
+	)
+
+	if err != nil {
+		t.Fatalf("command.Copy error: %v", err)
+	}
+	got, err := os.ReadFile(out.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "HELLO\n" {
+		t.Errorf("out: got %q, want %q", got, "HELLO\n")
+	}
+	// Each of the three hops moved the line.
+	if got, want := n, int64(3*len("hello\n")); got != want {
+		t.Errorf("command.Copy: got %d bytes, want %d", got, want)
+	}
+}
+
+func TestCopySplicesCommands(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses sh")
+	}
+	ctx, m := t.Context(), sys.Machine()
+	var out strings.Builder
+
+	n, err := command.Copy(&out,
+		command.NewReader(ctx, m, "echo", "hello"),
+		command.NewFilter(ctx, m, "tr", "a-z", "A-Z"),
+	)
+
+	if err != nil {
+		t.Fatalf("command.Copy error: %v", err)
+	}
+	if got, want := out.String(), "HELLO\n"; got != want {
+		t.Errorf("out: got %q, want %q", got, want)
+	}
+	if got, want := n, int64(2*len("hello\n")); got != want {
+		t.Errorf("command.Copy: got %d bytes, want %d", got, want)
+	}
+}
+
+func TestCopySplicesWriter(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses sh")
+	}
+	ctx, m := t.Context(), sys.Machine()
+	file := filepath.Join(t.TempDir(), "out")
+
+	n, err := command.Copy(
+		command.NewWriter(ctx, m, "sh", "-c", `cat >"$1"`, "sh", file),
+		command.NewReader(ctx, m, "echo", "hello"),
+	)
+
+	if err != nil {
+		t.Fatalf("command.Copy error: %v", err)
+	}
+	if got, want := n, int64(len("hello\n")); got != want {
+		t.Errorf("command.Copy: got %d bytes, want %d", got, want)
+	}
+	got, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello\n" {
+		t.Errorf("out: got %q, want %q", got, "hello\n")
+	}
+}
+
+func TestCopySplicesMoreThanAPipe(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses sh")
+	}
+	ctx, m := t.Context(), sys.Machine()
+	file := filepath.Join(t.TempDir(), "out")
+	const size = 1 << 20
+
+	n, err := command.Copy(
+		command.NewWriter(ctx, m, "sh", "-c", `cat >"$1"`, "sh", file),
+		command.NewReader(ctx, m, "head", "-c", strconv.Itoa(size),
+			"/dev/zero"),
+		command.NewFilter(ctx, m, "cat"),
+	)
+
+	if err != nil {
+		t.Fatalf("command.Copy error: %v", err)
+	}
+	if got, want := n, int64(2*size); got != want {
+		t.Errorf("command.Copy: got %d bytes, want %d", got, want)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != size {
+		t.Errorf("out: got %d bytes, want %d", info.Size(), size)
+	}
+}
+
+func TestCopySplicedEarlyExit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test uses sh")
+	}
+	ctx, m := t.Context(), sys.Machine()
+	var out strings.Builder
+
+	// The reader outlives the filter, and must not hang the pipeline.
+	_, _ = command.Copy(&out,
+		command.NewReader(ctx, m, "cat", "/dev/zero"),
+		command.NewFilter(ctx, m, "head", "-c", "1"),
+	)
+
+	if got, want := out.Len(), 1; got != want {
+		t.Errorf("out: got %d bytes, want %d", got, want)
+	}
+}
@@ -0,0 +1,78 @@
+package sys
+
+import (
+	"time"
+
+	"lesiw.io/command"
+)
+
+// Timing breaks down the time it took to start a command, to help find
+// out why commands are slow to start, such as when antivirus software
+// scans each executable, or when PATH holds directories on a slow network
+// filesystem.
+type Timing struct {
+	Lookup    time.Duration // Finding the executable in PATH.
+	Start     time.Duration // Creating the process and executing it.
+	FirstByte time.Duration // From starting until the first output.
+}
+
+// Timings returns the Timing of buf, which must be a Buffer returned by
+// one of this package's Machines. It reports false if buf is some other
+// Buffer.
+//
+// Fields are zero until measured: Start once the command has started,
+// and FirstByte once its first output has been read from buf. Output
+// written to a file or a terminal is not measured.
+//
+//	buf := sys.Machine().Command(ctx, "go", "version")
+//	out, err := io.ReadAll(buf)
+//	t, _ := sys.Timings(buf)
+//	fmt.Println(t.Lookup, t.Start, t.FirstByte)
+func Timings(buf command.Buffer) (Timing, bool) {
+	c, ok := buf.(*cmd)
+	if !ok {
+		return Timing{}, false
+	}
+	c.timingMu.Lock()
+	defer c.timingMu.Unlock()
+	return c.timing, true
+}
+
+// timeStart records how long the command took to start.
+func (c *cmd) timeStart(start time.Time) {
+	c.timingMu.Lock()
+	defer c.timingMu.Unlock()
+	c.started = start
+	c.timing.Start = time.Since(start)
+}
+
+// timeRead records the time to the first byte of output.
+func (c *cmd) timeRead(n int) {
+	if n == 0 {
+		return
+	}
+	c.timingMu.Lock()
+	defer c.timingMu.Unlock()
+	if c.timing.FirstByte == 0 && !c.started.IsZero() {
+		c.timing.FirstByte = time.Since(c.started)
+	}
+}
+
+// Result implements command.ResultBuffer. Detached commands never report
+// a result, since they outlive their Buffers.
+func (c *cmd) Result() (command.Result, bool) {
+	c.timingMu.Lock()
+	defer c.timingMu.Unlock()
+	return c.result, !c.result.Finished.IsZero()
+}
+
+// timeFinish records the result of the command once it has exited.
+func (c *cmd) timeFinish() {
+	c.timingMu.Lock()
+	defer c.timingMu.Unlock()
+	c.result = command.Result{
+		Code:     c.cmd.ProcessState.ExitCode(),
+		Started:  c.started,
+		Finished: time.Now(),
+	}
+}
@@ -0,0 +1,228 @@
+// Package systemdrun implements a command.Machine that runs each command
+// as a transient systemd unit, so that systemd supervises it, confines it
+// with resource properties, and cleans up every process it starts.
+//
+//	m := systemdrun.Machine(sys.Machine(),
+//	    systemdrun.Property("MemoryMax=2G"),
+//	    systemdrun.Property("CPUQuota=200%"),
+//	)
+//	err := command.Do(ctx, m, "make", "-j8")
+//
+// By default, commands run as service units, with their input and output
+// piped through systemd-run. When a command fails, the last lines the
+// unit wrote to the journal are attached to its error in a [UnitError],
+// which reports why systemd stopped it, such as for running out of
+// memory. [Scope] runs commands as scope units instead.
+package systemdrun
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"lesiw.io/command"
+	"lesiw.io/fs"
+)
+
+// journalTail is the number of journal lines attached to a UnitError.
+const journalTail = 20
+
+// An Option configures a Machine.
+type Option func(*machine)
+
+// Property sets a unit property, such as MemoryMax=1G, as with
+// systemd-run --property.
+func Property(assignment string) Option {
+	return Args("--property=" + assignment)
+}
+
+// Slice places units in the named slice.
+func Slice(name string) Option { return Args("--slice=" + name) }
+
+// User runs units in the calling user's service manager rather than the
+// system's.
+func User() Option {
+	return func(m *machine) { m.user = true }
+}
+
+// Scope runs commands as scope units. A scope's command runs as a child
+// of systemd-run, so it inherits its environment, working directory, and
+// terminal, but systemd cannot report why it stopped.
+func Scope() Option {
+	return func(m *machine) { m.scope = true }
+}
+
+// Args passes args to systemd-run.
+func Args(args ...string) Option {
+	return func(m *machine) { m.args = append(m.args, args...) }
+}
+
+// Machine returns a command.Machine that runs commands on m with
+// systemd-run.
+func Machine(m command.Machine, opts ...Option) command.Machine {
+	sm := &machine{m: m}
+	for _, opt := range opts {
+		opt(sm)
+	}
+	return sm
+}
+
+type machine struct {
+	m     command.Machine
+	args  []string
+	user  bool
+	scope bool
+}
+
+func (m *machine) Command(
+	ctx context.Context, arg ...string,
+) command.Buffer {
+	unit, err := unitName()
+	if err != nil {
+		return command.Fail(&command.Error{Err: err})
+	}
+	args := []string{"systemd-run", "--unit=" + unit, "--quiet"}
+	if m.user {
+		args = append(args, "--user")
+	}
+	if m.scope {
+		args = append(args, "--scope")
+	} else {
+		// Services start in the service manager's environment and
+		// directory, so the command's are passed along explicitly.
+		// Variables are named without values, which systemd-run takes
+		// from its own environment, to keep them out of its arguments.
+		args = append(args, "--pipe", "--wait", "--collect")
+		if dir := fs.WorkDir(ctx); dir != "" {
+			args = append(args, "--working-directory="+dir)
+			ctx = fs.WithoutWorkDir(ctx)
+		}
+		keys := slices.Sorted(maps.Keys(command.Envs(ctx)))
+		for _, k := range keys {
+			args = append(args, "--setenv="+k)
+		}
+	}
+	args = append(args, m.args...)
+	args = append(args, "--")
+	args = append(args, arg...)
+	return &cmd{
+		Buffer: m.m.Command(ctx, args...),
+		m:      m,
+		ctx:    ctx,
+		unit:   unit,
+	}
+}
+
+func unitName() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to name unit: %w", err)
+	}
+	return "command-" + hex.EncodeToString(b), nil
+}
+
+// A UnitError reports that a command run as a transient unit failed.
+type UnitError struct {
+	Err error // The error of the failed command.
+
+	Unit    string // The name of the unit, without its suffix.
+	Result  string // The unit's result, such as oom-kill, if known.
+	Journal string // The last lines the unit wrote to the journal.
+}
+
+func (e *UnitError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Err.Error())
+	fmt.Fprintf(&b, "\nunit %s failed", e.Unit)
+	if e.Result != "" {
+		fmt.Fprintf(&b, " with result %s", e.Result)
+	}
+	if e.Journal != "" {
+		b.WriteString("\njournal:\n\t")
+		b.WriteString(strings.ReplaceAll(e.Journal, "\n", "\n\t"))
+	}
+	return b.String()
+}
+
+func (e *UnitError) Unwrap() error { return e.Err }
+
+var resultRE = regexp.MustCompile(`Failed with result '([^']+)'`)
+
+// diagnose wraps err in a [UnitError] with the journal of the unit.
+func (m *machine) diagnose(ctx context.Context, unit string, err error) error {
+	// The command's context may be the reason it failed.
+	ctx, cancel := context.WithTimeout(
+		command.WithoutCommandOptions(context.WithoutCancel(ctx)),
+		10*time.Second,
+	)
+	defer cancel()
+
+	args := []string{"journalctl"}
+	if m.user {
+		args = append(args, "--user")
+	}
+	args = append(args,
+		"--unit="+unit, "--lines="+strconv.Itoa(journalTail),
+		"--output=cat", "--no-pager", "--quiet",
+	)
+	journal, jerr := command.Read(ctx, m.m, args...)
+	if jerr != nil {
+		journal = ""
+	}
+	e := &UnitError{
+		Err:     err,
+		Unit:    unit,
+		Journal: strings.TrimRight(journal, "\n"),
+	}
+	if match := resultRE.FindAllStringSubmatch(journal, -1); match != nil {
+		e.Result = match[len(match)-1][1]
+	}
+	return e
+}
+
+type cmd struct {
+	command.Buffer
+	m    *machine
+	ctx  context.Context
+	unit string
+	diag bool // Whether a failure has been diagnosed.
+}
+
+func (c *cmd) Read(p []byte) (int, error) {
+	n, err := c.Buffer.Read(p)
+	if err != nil && err != io.EOF && !c.diag && !command.NotFound(err) {
+		c.diag = true
+		err = c.m.diagnose(c.ctx, c.unit, err)
+	}
+	return n, err
+}
+
+func (c *cmd) Write(p []byte) (int, error) {
+	if wb, ok := c.Buffer.(command.WriteBuffer); ok {
+		return wb.Write(p)
+	}
+	return 0, command.ErrReadOnly
+}
+
+func (c *cmd) Close() error {
+	if closer, ok := c.Buffer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (c *cmd) Attach() error   { return command.Attach(c.Buffer) }
+func (c *cmd) Log(w io.Writer) { command.Log(c.Buffer, w) }
+func (c *cmd) String() string  { return command.String(c.Buffer) }
+
+func (c *cmd) Result() (command.Result, bool) {
+	return command.ResultOf(c.Buffer)
+}
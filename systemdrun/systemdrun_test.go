@@ -0,0 +1,104 @@
+package systemdrun_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+	"lesiw.io/command/systemdrun"
+	"lesiw.io/fs"
+)
+
+func TestMachine(t *testing.T) {
+	m := new(mock.Machine)
+	sm := systemdrun.Machine(m, systemdrun.Property("MemoryMax=1G"))
+	ctx := command.WithEnv(t.Context(), map[string]string{
+		"B": "2", "A": "1",
+	})
+	ctx = fs.WithWorkDir(ctx, "/src")
+
+	if err := command.Do(ctx, sm, "make", "-j8"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+
+	calls := mock.Calls(m, "systemd-run")
+	if len(calls) != 1 {
+		t.Fatalf("got %d systemd-run calls, want 1", len(calls))
+	}
+	args := calls[0].Args
+	unit, ok := strings.CutPrefix(args[1], "--unit=command-")
+	if !ok || len(unit) != 12 {
+		t.Errorf("unit arg = %q, want --unit=command-<12 hex digits>",
+			args[1])
+	}
+	want := []string{
+		"--quiet", "--pipe", "--wait", "--collect",
+		"--working-directory=/src", "--setenv=A", "--setenv=B",
+		"--property=MemoryMax=1G", "--", "make", "-j8",
+	}
+	if !cmp.Equal(args[2:], want) {
+		t.Errorf("args (-want +got):\n%s", cmp.Diff(want, args[2:]))
+	}
+	if calls[0].Dir != "" {
+		t.Errorf("host dir = %q, want none", calls[0].Dir)
+	}
+	if got := calls[0].Env["A"]; got != "1" {
+		t.Errorf("host env A = %q, want 1", got)
+	}
+}
+
+func TestScope(t *testing.T) {
+	m := new(mock.Machine)
+	sm := systemdrun.Machine(m, systemdrun.Scope(), systemdrun.User())
+	ctx := fs.WithWorkDir(t.Context(), "/src")
+
+	if err := command.Do(ctx, sm, "true"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+
+	calls := mock.Calls(m, "systemd-run")
+	if len(calls) != 1 {
+		t.Fatalf("got %d systemd-run calls, want 1", len(calls))
+	}
+	want := []string{"--quiet", "--user", "--scope", "--", "true"}
+	if got := calls[0].Args[2:]; !cmp.Equal(got, want) {
+		t.Errorf("args (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	if got := calls[0].Dir; got != "/src" {
+		t.Errorf("host dir = %q, want /src", got)
+	}
+}
+
+func TestUnitError(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(command.Fail(&command.Error{Code: 137}), "systemd-run")
+	m.Return(strings.NewReader(
+		"A process of this unit has been killed by the OOM killer.\n"+
+			"Failed with result 'oom-kill'.\n",
+	), "journalctl")
+
+	err := command.Do(t.Context(), systemdrun.Machine(m), "make")
+
+	var ue *systemdrun.UnitError
+	if !errors.As(err, &ue) {
+		t.Fatalf("err = %v, want *systemdrun.UnitError", err)
+	}
+	if got, want := ue.Result, "oom-kill"; got != want {
+		t.Errorf("Result = %q, want %q", got, want)
+	}
+	if !strings.Contains(ue.Journal, "OOM killer") {
+		t.Errorf("Journal = %q, want the OOM message", ue.Journal)
+	}
+	var ce *command.Error
+	if !errors.As(err, &ce) || ce.Code != 137 {
+		t.Errorf("err = %v, want the command.Error with code 137", err)
+	}
+	jc := mock.Calls(m, "journalctl")
+	if len(jc) != 1 || !strings.HasPrefix(jc[0].Args[1], "--unit=command-") {
+		t.Errorf("journalctl calls = %v, want one for the unit", jc)
+	}
+}
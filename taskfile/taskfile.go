@@ -0,0 +1,294 @@
+// Package taskfile runs routine operations declared in a task file
+// against named command.Machines.
+//
+// A task file is written in a small subset of TOML. Each table is a
+// task, named by its header:
+//
+//	# Build on the Linux builder, after generating code locally.
+//	[generate]
+//	args = ["go", "generate", "./..."]
+//
+//	[build]
+//	machine = "builder"
+//	args = ["go", "build", "-o", "bin/", "./..."]
+//	env = { CGO_ENABLED = "0", GOFLAGS = "-trimpath" }
+//	dir = "/src"
+//	deps = ["generate"]
+//
+// The keys are:
+//
+//	args     the command and its arguments; required
+//	machine  selects the machine that runs the command; see [Machines]
+//	env      environment variables for the command
+//	dir      the working directory of the command
+//	deps     tasks that must succeed before this one runs
+//
+// Values are strings, arrays of strings, or inline tables of strings.
+// Strings are in double quotes, with TOML escapes, or in single quotes,
+// taken literally. Arrays may span lines. Comments begin with #.
+//
+// A [Runner] runs tasks after their dependencies, running independent
+// dependencies concurrently:
+//
+//	f, err := taskfile.Parse("tasks.toml", data)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	r := &taskfile.Runner{
+//	    Machines: taskfile.MachineMap{
+//	        "":        sys.Machine(),
+//	        "builder": ssh.Machine(sys.Machine(), "ssh", "builder"),
+//	    },
+//	    Stdout: os.Stdout,
+//	    Stderr: os.Stderr,
+//	}
+//	err = r.Run(ctx, f, "build")
+package taskfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+
+	"lesiw.io/command"
+	"lesiw.io/command/internal/toml"
+)
+
+// A Task is a command declared in a task file.
+type Task struct {
+	Name    string
+	Machine string // The machine selector; see [Machines].
+	Args    []string
+	Env     map[string]string
+	Dir     string
+	Deps    []string
+}
+
+// A File is a parsed task file.
+type File struct {
+	// Name identifies the file in errors, usually by its file name.
+	Name string
+
+	// Tasks are the tasks of the file, in the order they were declared.
+	Tasks []*Task
+}
+
+// Task returns the task called name, or nil if there is none.
+func (f *File) Task(name string) *Task {
+	for _, t := range f.Tasks {
+		if t.Name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+// Parse parses the task file in data. name identifies the file in
+// errors. Parse checks that every task has arguments and that
+// dependencies exist and do not form a cycle.
+func Parse(name string, data []byte) (*File, error) {
+	f, err := parse(name, data)
+	if err != nil {
+		var terr *toml.Error
+		if errors.As(err, &terr) {
+			return nil, fmt.Errorf("%s:%d: %s", name, terr.Line, terr.Msg)
+		}
+		return nil, err
+	}
+	if err := f.check(); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	return f, nil
+}
+
+func (f *File) check() error {
+	for _, t := range f.Tasks {
+		if len(t.Args) == 0 {
+			return fmt.Errorf("task %q: missing args", t.Name)
+		}
+		for _, dep := range t.Deps {
+			if f.Task(dep) == nil {
+				return fmt.Errorf("task %q: unknown dependency %q",
+					t.Name, dep)
+			}
+		}
+	}
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var visit func(t *Task, path []string) error
+	visit = func(t *Task, path []string) error {
+		path = append(path, t.Name)
+		switch state[t.Name] {
+		case visiting:
+			return fmt.Errorf("dependency cycle: %s",
+				strings.Join(path, " -> "))
+		case visited:
+			return nil
+		}
+		state[t.Name] = visiting
+		for _, dep := range t.Deps {
+			if err := visit(f.Task(dep), path); err != nil {
+				return err
+			}
+		}
+		state[t.Name] = visited
+		return nil
+	}
+	for _, t := range f.Tasks {
+		if err := visit(t, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parse(name string, data []byte) (*File, error) {
+	tables, err := toml.Parse(string(data))
+	if err != nil {
+		return nil, err
+	}
+	f := &File{Name: name}
+	for _, tbl := range tables {
+		if tbl.Name == "" {
+			if len(tbl.Keys) > 0 {
+				return nil, &toml.Error{Line: tbl.Keys[0].Line,
+					Msg: "key outside of a task"}
+			}
+			continue
+		}
+		t := &Task{Name: tbl.Name}
+		for _, kv := range tbl.Keys {
+			if err := t.set(kv); err != nil {
+				return nil, err
+			}
+		}
+		f.Tasks = append(f.Tasks, t)
+	}
+	return f, nil
+}
+
+func (t *Task) set(kv toml.KeyValue) (err error) {
+	switch kv.Key {
+	case "machine":
+		t.Machine, err = kv.Text()
+	case "dir":
+		t.Dir, err = kv.Text()
+	case "args":
+		t.Args, err = kv.Strings()
+	case "deps":
+		t.Deps, err = kv.Strings()
+	case "env":
+		t.Env, err = kv.Map()
+	default:
+		return &toml.Error{Line: kv.Line,
+			Msg: fmt.Sprintf("unknown key %q", kv.Key)}
+	}
+	return err
+}
+
+// Machines selects the machines that run tasks. A [MachineMap] selects
+// them by name, and a registry.Registry by name or by label.
+type Machines interface {
+	// Machine returns the machine for a task's machine selector, which
+	// is empty if the task has none.
+	Machine(selector string) (command.Machine, error)
+}
+
+// A MachineMap selects machines by name. The machine under the empty
+// name runs tasks that name no machine.
+type MachineMap map[string]command.Machine
+
+// Machine returns the machine called selector.
+func (mm MachineMap) Machine(selector string) (command.Machine, error) {
+	if m, ok := mm[selector]; ok {
+		return m, nil
+	}
+	if selector == "" {
+		return nil, errors.New("no default machine")
+	}
+	return nil, fmt.Errorf("unknown machine %q", selector)
+}
+
+// A Runner runs the tasks of a File.
+type Runner struct {
+	Machines Machines
+
+	// Stdout and Stderr receive the output and diagnostic output of
+	// tasks. If nil, output is discarded, and diagnostic output is
+	// reported in the errors of failed tasks.
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// Run runs the named tasks of f, and every task they depend on, each at
+// most once. A task runs once all of its dependencies have succeeded;
+// tasks whose dependencies are met run concurrently. When a task fails,
+// Run cancels the others and returns the first failure.
+func (r *Runner) Run(ctx context.Context, f *File, names ...string) error {
+	for _, name := range names {
+		if f.Task(name) == nil {
+			return fmt.Errorf("%s: unknown task %q", f.Name, name)
+		}
+	}
+	g, ctx := errgroup.WithContext(ctx)
+	var (
+		mu   sync.Mutex
+		runs = make(map[string]func() error)
+	)
+	var run func(t *Task) func() error
+	run = func(t *Task) func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		if fn, ok := runs[t.Name]; ok {
+			return fn
+		}
+		fn := sync.OnceValue(func() error {
+			var deps errgroup.Group
+			for _, dep := range t.Deps {
+				deps.Go(run(f.Task(dep)))
+			}
+			if err := deps.Wait(); err != nil {
+				return err
+			}
+			if err := r.runTask(ctx, t); err != nil {
+				return fmt.Errorf("task %q: %w", t.Name, err)
+			}
+			return nil
+		})
+		runs[t.Name] = fn
+		return fn
+	}
+	for _, name := range slices.Compact(slices.Clone(names)) {
+		g.Go(run(f.Task(name)))
+	}
+	return g.Wait()
+}
+
+func (r *Runner) runTask(ctx context.Context, t *Task) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if r.Machines == nil {
+		return errors.New("no machines")
+	}
+	m, err := r.Machines.Machine(t.Machine)
+	if err != nil {
+		return err
+	}
+	return command.Run(ctx, m, command.Spec{
+		Args:   t.Args,
+		Env:    t.Env,
+		Dir:    t.Dir,
+		Stdout: r.Stdout,
+		Stderr: r.Stderr,
+	})
+}
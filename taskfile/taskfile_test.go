@@ -0,0 +1,148 @@
+package taskfile_test
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+	"lesiw.io/command/taskfile"
+)
+
+const tasks = `# Routine operations.
+[generate]
+args = ["go", "generate", "./..."]
+
+[build]
+machine = "builder" # A comment.
+args = [
+	"go", "build",
+	'-ldflags=-X main.v="1"',  # Literal string.
+	"./...",
+]
+env = { CGO_ENABLED = "0", "GO FLAGS" = "a\tb" }
+dir = "/src"
+deps = ["generate"]
+
+[test]
+args = ["go", "test", "./..."]
+deps = ["generate", "build"]
+`
+
+func TestParse(t *testing.T) {
+	f, err := taskfile.Parse("tasks.toml", []byte(tasks))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+
+	want := []*taskfile.Task{{
+		Name: "generate",
+		Args: []string{"go", "generate", "./..."},
+	}, {
+		Name:    "build",
+		Machine: "builder",
+		Args: []string{"go", "build", `-ldflags=-X main.v="1"`,
+			"./..."},
+		Env:  map[string]string{"CGO_ENABLED": "0", "GO FLAGS": "a\tb"},
+		Dir:  "/src",
+		Deps: []string{"generate"},
+	}, {
+		Name: "test",
+		Args: []string{"go", "test", "./..."},
+		Deps: []string{"generate", "build"},
+	}}
+	if !cmp.Equal(f.Tasks, want) {
+		t.Errorf("Tasks (-want +got):\n%s", cmp.Diff(want, f.Tasks))
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name, data, err string
+	}{
+		{"no args", "[a]\ndir = \"/\"", `task "a": missing args`},
+		{"unknown dep", "[a]\nargs = [\"x\"]\ndeps = [\"b\"]",
+			`unknown dependency "b"`},
+		{"cycle", "[a]\nargs = [\"x\"]\ndeps = [\"b\"]\n" +
+			"[b]\nargs = [\"x\"]\ndeps = [\"a\"]",
+			"dependency cycle: a -> b -> a"},
+		{"unknown key", "[a]\nrun = \"x\"", `t:2: unknown key "run"`},
+		{"orphan key", "args = [\"x\"]", "t:1: key outside of a task"},
+		{"duplicate", "[a]\n[a]", "t:2: table a defined twice"},
+		{"unterminated", "[a]\nargs = [\"x\",\n", "unterminated array"},
+		{"trailing", "[a]\ndir = \"/\" x", "t:2: want newline"},
+		{"env type", "[a]\nenv = [\"x\"]",
+			"t:2: a.env: want an inline table of strings"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := taskfile.Parse("t", []byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Parse error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	f, err := taskfile.Parse("tasks.toml", []byte(tasks))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	local, builder := new(mock.Machine), new(mock.Machine)
+	r := &taskfile.Runner{Machines: taskfile.MachineMap{
+		"":        local,
+		"builder": builder,
+	}}
+
+	if err := r.Run(t.Context(), f, "test", "build"); err != nil {
+		t.Fatalf("Run error: %v", err)
+	}
+
+	var got []string
+	for _, c := range mock.Calls(local) {
+		got = append(got, strings.Join(c.Args[:2], " "))
+	}
+	want := []string{"go generate", "go test"}
+	if !cmp.Equal(got, want) {
+		t.Errorf("local calls (-want +got):\n%s", cmp.Diff(want, got))
+	}
+	calls := mock.Calls(builder)
+	if len(calls) != 1 {
+		t.Fatalf("builder calls = %d, want 1", len(calls))
+	}
+	if got, want := calls[0].Dir, "/src"; got != want {
+		t.Errorf("build dir = %q, want %q", got, want)
+	}
+	if got, want := calls[0].Env["CGO_ENABLED"], "0"; got != want {
+		t.Errorf("build CGO_ENABLED = %q, want %q", got, want)
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	f, err := taskfile.Parse("tasks.toml", []byte(tasks))
+	if err != nil {
+		t.Fatalf("Parse error: %v", err)
+	}
+	m := new(mock.Machine)
+	m.Return(command.Fail(&command.Error{Code: 1}), "go", "generate")
+	r := &taskfile.Runner{Machines: taskfile.MachineMap{
+		"": m, "builder": m,
+	}}
+
+	err = r.Run(t.Context(), f, "test")
+	if err == nil || !strings.Contains(err.Error(), `task "generate"`) {
+		t.Errorf("Run error = %v, want generate failure", err)
+	}
+	for _, c := range mock.Calls(m) {
+		if !slices.Equal(c.Args[:2], []string{"go", "generate"}) {
+			t.Errorf("ran %q after its dependency failed", c.Args)
+		}
+	}
+	if err := r.Run(t.Context(), f, "deploy"); err == nil {
+		t.Error("Run(deploy) error = nil, want unknown task")
+	}
+}
@@ -0,0 +1,80 @@
+package tools
+
+import "fmt"
+
+// Go returns the Go toolchain release version, as published at go.dev/dl.
+// sums holds the SHA-256 checksum of each platform's archive, keyed by
+// "os/arch".
+func Go(version string, sums map[string]string) Tool {
+	return Tool{
+		Name:    "go",
+		Version: version,
+		URL: func(os, arch string) string {
+			ext := "tar.gz"
+			if os == "windows" {
+				ext = "zip"
+			}
+			return fmt.Sprintf("https://go.dev/dl/go%s.%s-%s.%s",
+				version, os, arch, ext)
+		},
+		Bin:  func(_, _ string) string { return "go/bin" },
+		Sums: sums,
+	}
+}
+
+// Node returns the Node.js release version, as published at
+// nodejs.org/dist. version has no leading v. sums holds the SHA-256
+// checksum of each platform's archive, keyed by "os/arch".
+func Node(version string, sums map[string]string) Tool {
+	name := func(os, arch string) string {
+		if os == "windows" {
+			os = "win"
+		}
+		if arch == "amd64" {
+			arch = "x64"
+		}
+		return fmt.Sprintf("node-v%s-%s-%s", version, os, arch)
+	}
+	return Tool{
+		Name:    "node",
+		Version: version,
+		URL: func(os, arch string) string {
+			ext := "tar.gz"
+			if os == "windows" {
+				ext = "zip"
+			}
+			return fmt.Sprintf("https://nodejs.org/dist/v%s/%s.%s",
+				version, name(os, arch), ext)
+		},
+		Bin: func(os, arch string) string {
+			if os == "windows" {
+				return name(os, arch)
+			}
+			return name(os, arch) + "/bin"
+		},
+		Sums: sums,
+	}
+}
+
+// JQ returns the jq release version, as published on GitHub by the jqlang
+// project. sums holds the SHA-256 checksum of each platform's executable,
+// keyed by "os/arch".
+func JQ(version string, sums map[string]string) Tool {
+	return Tool{
+		Name:    "jq",
+		Version: version,
+		URL: func(os, arch string) string {
+			if os == "darwin" {
+				os = "macos"
+			}
+			url := fmt.Sprintf("https://github.com/jqlang/jq/releases/"+
+				"download/jq-%s/jq-%s-%s", version, os, arch)
+			if os == "windows" {
+				url += ".exe"
+			}
+			return url
+		},
+		Bin:  func(_, _ string) string { return "bin" },
+		Sums: sums,
+	}
+}
@@ -0,0 +1,189 @@
+// Package tools provisions pinned versions of tools on a command.Machine.
+//
+// A [Tool] describes an official release: where to download it for each
+// platform, the checksum of each download, and where its executables
+// live once unpacked. [Ensure] installs tools into a cache directory on
+// the machine, once per version and platform, and returns a context
+// whose PATH finds them first:
+//
+//	ctx, err := tools.Ensure(ctx, m,
+//	    tools.Go("1.24.7", map[string]string{
+//	        "linux/amd64": "d5e5e6a9...",
+//	        "darwin/arm64": "3d0bc4c8...",
+//	    }),
+//	)
+//	if err != nil {
+//	    log.Fatal(err)
+//	}
+//	err = command.Exec(ctx, m, "go", "build", "./...")
+//
+// Because tools are fetched with [command.Fetch] and unpacked with
+// [command.Extract], this works on bare machines with little more than a
+// filesystem, and every download is verified before it is used.
+package tools
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"lesiw.io/command"
+	"lesiw.io/fs"
+	"lesiw.io/fs/path"
+)
+
+// A Tool is a release of a tool.
+//
+// Platforms are named by GOOS and GOARCH values, as returned by
+// [command.OS] and [command.Arch].
+type Tool struct {
+	Name    string
+	Version string
+
+	// URL returns the download URL of the release for a platform.
+	// If the URL names an archive (.tar, .tar.gz, .tgz, .tar.zst, .tzst,
+	// or .zip), it is extracted. Otherwise the download is the executable
+	// itself, and is installed as Name in the Bin directory.
+	URL func(os, arch string) string
+
+	// Bin returns the directory of the tool's executables, relative to
+	// the install directory. If Bin is nil, the executables are in the
+	// install directory itself.
+	Bin func(os, arch string) string
+
+	// Sums holds the hex-encoded SHA-256 checksum of each download,
+	// keyed by platform as "os/arch".
+	Sums map[string]string
+}
+
+type cacheDirKey struct{}
+
+// WithCacheDir returns a context that makes [Ensure] install tools under
+// dir instead of the machine's default cache directory.
+func WithCacheDir(ctx context.Context, dir string) context.Context {
+	return context.WithValue(ctx, cacheDirKey{}, dir)
+}
+
+// CacheDir returns the directory on m under which [Ensure] installs
+// tools: the directory set by [WithCacheDir], or the tools directory in
+// [command.CacheDir].
+func CacheDir(ctx context.Context, m command.Machine) (string, error) {
+	if dir, ok := ctx.Value(cacheDirKey{}).(string); ok && dir != "" {
+		return dir, nil
+	}
+	dir, err := command.CacheDir(ctx, m)
+	if err != nil {
+		return "", fmt.Errorf("tools: %w", err)
+	}
+	return path.Join(dir, "tools"), nil
+}
+
+// Ensure installs tools on m if they are not already installed, and
+// returns a context whose PATH lists their executable directories ahead
+// of the PATH of ctx, or of m if ctx sets none.
+//
+// Each tool is installed in its own directory beneath [CacheDir], named
+// by its name, version, and platform. An installation is recorded only
+// once it completes, so an interrupted Ensure is retried from scratch.
+func Ensure(
+	ctx context.Context, m command.Machine, tools ...Tool,
+) (context.Context, error) {
+	m = command.Unshell(m)
+	octx := command.WithoutCommandOptions(ctx) // For Ensure's own commands.
+	root, err := CacheDir(octx, m)
+	if err != nil {
+		return ctx, err
+	}
+	os, arch := command.OS(octx, m), command.Arch(octx, m)
+	var bins []string
+	for _, t := range tools {
+		bin, err := t.ensure(octx, m, root, os, arch)
+		if err != nil {
+			return ctx, fmt.Errorf("tools: %s %s: %w", t.Name, t.Version, err)
+		}
+		bins = append(bins, bin)
+	}
+	sep := ":"
+	if os == "windows" {
+		sep = ";"
+		for i := range bins {
+			bins[i] = strings.ReplaceAll(bins[i], "/", `\`)
+		}
+	}
+	if p := command.Env(octx, m, "PATH"); p != "" {
+		bins = append(bins, p)
+	}
+	return command.WithEnv(ctx, map[string]string{
+		"PATH": strings.Join(bins, sep),
+	}), nil
+}
+
+func (t Tool) ensure(
+	ctx context.Context, m command.Machine, root, os, arch string,
+) (bin string, err error) {
+	platform := os + "/" + arch
+	sum, ok := t.Sums[platform]
+	if !ok {
+		return "", fmt.Errorf("no checksum for %s", platform)
+	}
+	dir := path.Join(root, t.Name, t.Version, os+"-"+arch)
+	bin = dir
+	if t.Bin != nil {
+		bin = path.Join(dir, t.Bin(os, arch))
+	}
+	fsys := command.FS(m)
+	done := path.Join(dir, ".complete")
+	if _, err := fs.Stat(ctx, fsys, done); err == nil {
+		return bin, nil
+	}
+	if err := fs.RemoveAll(ctx, fsys, dir); err != nil {
+		return "", err
+	}
+	if err := fs.MkdirAll(ctx, fsys, dir); err != nil {
+		return "", err
+	}
+	url := t.URL(os, arch)
+	if isArchive(url) {
+		file := dir + "-" + path.Base(url)
+		err = command.Fetch(ctx, m, url, file, command.SHA256(sum))
+		if err == nil {
+			err = command.Extract(ctx, m, file, dir)
+		}
+		_ = fs.Remove(ctx, fsys, file) // Best effort.
+	} else {
+		exe := path.Join(bin, t.Name)
+		if os == "windows" {
+			exe += ".exe"
+		}
+		err = fs.MkdirAll(ctx, fsys, bin)
+		if err == nil {
+			err = command.Fetch(ctx, m, url, exe, command.SHA256(sum))
+		}
+		if err == nil && os != "windows" {
+			err = fs.Chmod(ctx, fsys, exe, 0755)
+			if errors.Is(err, fs.ErrUnsupported) {
+				err = nil // The filesystem has no modes to set.
+			}
+		}
+	}
+	if err == nil {
+		err = fs.WriteFile(ctx, fsys, done, nil)
+	}
+	if err != nil {
+		return "", err
+	}
+	return bin, nil
+}
+
+func isArchive(url string) bool {
+	url = strings.ToLower(url)
+	for _, ext := range []string{
+		".tar", ".tar.gz", ".tgz", ".tar.zst", ".tzst", ".zip",
+	} {
+		if strings.HasSuffix(url, ext) {
+			return true
+		}
+	}
+	return false
+}
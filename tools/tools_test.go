@@ -0,0 +1,120 @@
+package tools_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mem"
+	"lesiw.io/command/tools"
+	"lesiw.io/fs"
+)
+
+func tgz(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	for name, data := range files {
+		err := tw.WriteHeader(&tar.Header{
+			Name: name, Mode: 0755, Size: int64(len(data)),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func sha256sum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestEnsure(t *testing.T) {
+	archive := tgz(t, map[string]string{"hello/bin/hello": "#!/bin/sh\n"})
+	exe := []byte("\x7fELF")
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			hits.Add(1)
+			switch r.URL.Path {
+			case "/hello.tar.gz":
+				_, _ = w.Write(archive)
+			case "/jq":
+				_, _ = w.Write(exe)
+			default:
+				http.NotFound(w, r)
+			}
+		},
+	))
+	defer srv.Close()
+	hello := tools.Tool{
+		Name:    "hello",
+		Version: "1.0",
+		URL: func(_, _ string) string {
+			return srv.URL + "/hello.tar.gz"
+		},
+		Bin:  func(_, _ string) string { return "hello/bin" },
+		Sums: map[string]string{"linux/amd64": sha256sum(archive)},
+	}
+	jq := tools.Tool{
+		Name:    "jq",
+		Version: "1.7",
+		URL:     func(_, _ string) string { return srv.URL + "/jq" },
+		Bin:     func(_, _ string) string { return "bin" },
+		Sums:    map[string]string{"linux/amd64": sha256sum(exe)},
+	}
+	m := mem.Machine()
+	ctx := tools.WithCacheDir(t.Context(), "/cache")
+
+	for range 2 {
+		got, err := tools.Ensure(ctx, m, hello, jq)
+		if err != nil {
+			t.Fatalf("tools.Ensure error: %v", err)
+		}
+		want := "/cache/hello/1.0/linux-amd64/hello/bin:" +
+			"/cache/jq/1.7/linux-amd64/bin"
+		if path := command.Envs(got)["PATH"]; path != want {
+			t.Errorf("PATH = %q, want %q", path, want)
+		}
+	}
+
+	fsys := command.FS(m)
+	for _, name := range []string{
+		"/cache/hello/1.0/linux-amd64/hello/bin/hello",
+		"/cache/jq/1.7/linux-amd64/bin/jq",
+	} {
+		if _, err := fs.Stat(ctx, fsys, name); err != nil {
+			t.Errorf("fs.Stat(%q) error: %v", name, err)
+		}
+	}
+	if got, want := hits.Load(), int32(2); got != want {
+		t.Errorf("downloads: got %d, want %d", got, want)
+	}
+}
+
+func TestEnsureNoChecksum(t *testing.T) {
+	tool := tools.Go("1.24.7", map[string]string{"darwin/arm64": "00"})
+	ctx := tools.WithCacheDir(t.Context(), "/cache")
+
+	if _, err := tools.Ensure(ctx, mem.Machine(), tool); err == nil {
+		t.Error("tools.Ensure error: got nil, want error")
+	}
+}
@@ -0,0 +1,87 @@
+package command
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"lesiw.io/command/argv"
+	"lesiw.io/command/config"
+)
+
+type traceKey struct{}
+
+type traceDest struct{ w io.Writer }
+
+// WithTrace returns a new context that traces commands to w.
+//
+// Commands run with the returned context are traced whether or not the
+// CMDTRACE environment variable is set. CMDTRACE=full includes
+// environment variables; any other value traces arguments only.
+// Unlike replacing [Trace], WithTrace affects only the commands that use
+// the returned context, so it is safe for parallel tests:
+//
+//	var log bytes.Buffer
+//	ctx := command.WithTrace(t.Context(), &log)
+//
+// If w is nil, commands run with the returned context are not traced.
+func WithTrace(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, traceKey{}, traceDest{w})
+}
+
+// TraceWriter returns the writer that commands run with ctx are traced
+// to, or nil if they are not traced. Helpers whose own output belongs
+// with the trace, such as the log of an image build, write it there.
+func TraceWriter(ctx context.Context) io.Writer {
+	w, _ := traceDestination(ctx)
+	return w
+}
+
+// traceDestination returns where and how commands run with ctx are
+// traced, per the CMDTRACE environment variable, which is read on each
+// call so tests and long-lived processes see changes, or else per the
+// trace mode of the user's configuration. A destination set by
+// [WithTrace] takes precedence. It returns a nil writer if commands are
+// not traced.
+func traceDestination(ctx context.Context) (w io.Writer, mode string) {
+	w, mode = Trace, os.Getenv("CMDTRACE")
+	if mode == "" {
+		// A configuration that cannot be read is reported by the
+		// machines that need it; commands are not traced for it.
+		c, _ := config.From(ctx)
+		mode = c.Trace
+	}
+	if dest, ok := ctx.Value(traceKey{}).(traceDest); ok {
+		if dest.w == nil {
+			return nil, ""
+		}
+		w = dest.w
+		if mode != "full" {
+			mode = "on"
+		}
+	}
+	if mode != "on" && mode != "full" {
+		return nil, ""
+	}
+	return w, mode
+}
+
+// trace reports a command where ctx asks for commands to be traced.
+func trace(ctx context.Context, buf Buffer, args ...string) {
+	w, mode := traceDestination(ctx)
+	var line string
+	switch mode {
+	case "on":
+		line = argv.Marshal(args)
+	case "full":
+		line = strings.TrimRight(fmt.Sprint(buf), "\n")
+	default:
+		return
+	}
+	if r := redactor(ctx); r != nil {
+		line = r.Replace(line)
+	}
+	_, _ = fmt.Fprintf(w, "%s\n", line)
+}
@@ -1,11 +1,21 @@
 package command
 
 import (
+	"bytes"
 	"context"
+	"io"
 	"strings"
+	"sync"
 	"testing"
+
+	"lesiw.io/command/config"
+	"lesiw.io/command/internal/configtest"
 )
 
+// TestMain keeps the tests from depending on the configuration file of
+// the user who runs them.
+func TestMain(m *testing.M) { configtest.Main(m, "") }
+
 func traceMachine(line string) Machine {
 	return MachineFunc(func(_ context.Context, _ ...string) Buffer {
 		return &readStringer{strings.NewReader(""), line}
@@ -79,3 +89,157 @@ func TestTraceSilentWhenDisabled(t *testing.T) {
 		t.Errorf("trace = %q, want no output", got)
 	}
 }
+
+func TestWithTrace(t *testing.T) {
+	t.Setenv("CMDTRACE", "")
+	var global, local strings.Builder
+	old := Trace
+	Trace = &global
+	t.Cleanup(func() { Trace = old })
+
+	ctx := WithTrace(t.Context(), &local)
+	err := Do(ctx, traceMachine("FOO=bar echo hi"), "echo", "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := local.String(), "echo hi\n"; got != want {
+		t.Errorf("trace = %q, want %q", got, want)
+	}
+	if got := global.String(); got != "" {
+		t.Errorf("global trace = %q, want no output", got)
+	}
+}
+
+func TestWithTraceFull(t *testing.T) {
+	t.Setenv("CMDTRACE", "full")
+	var buf strings.Builder
+
+	ctx := WithTrace(t.Context(), &buf)
+	err := Do(ctx, traceMachine("FOO=bar echo hi"), "echo", "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := buf.String(), "FOO=bar echo hi\n"; got != want {
+		t.Errorf("trace = %q, want %q", got, want)
+	}
+}
+
+func TestWithTraceNil(t *testing.T) {
+	t.Setenv("CMDTRACE", "on")
+	var buf strings.Builder
+	old := Trace
+	Trace = &buf
+	t.Cleanup(func() { Trace = old })
+
+	ctx := WithTrace(t.Context(), nil)
+	err := Do(ctx, traceMachine("FOO=bar echo hi"), "echo", "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := buf.String(); got != "" {
+		t.Errorf("trace = %q, want no output", got)
+	}
+}
+
+func TestTraceWriter(t *testing.T) {
+	var local strings.Builder
+	tests := []struct {
+		name string
+		env  string
+		ctx  context.Context
+		want io.Writer
+	}{
+		{"off", "", t.Context(), nil},
+		{"on", "on", t.Context(), Trace},
+		{"with trace", "", WithTrace(t.Context(), &local), &local},
+		{"with nil trace", "on", WithTrace(t.Context(), nil), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CMDTRACE", tt.env)
+			if got := TraceWriter(tt.ctx); got != tt.want {
+				t.Errorf("TraceWriter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := newPrefixWriter("+ ", &buf)
+
+	for _, s := range []string{"", "a", "b\nc", "\n", "d\n\ne\n"} {
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if got, want := buf.String(), "+ ab\n+ c\n+ d\n+ \n+ e\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestPrefixWriterConcurrent(t *testing.T) {
+	var buf bytes.Buffer
+	w := newPrefixWriter("+ ", &buf)
+
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				_, _ = w.Write([]byte("line\n"))
+			}
+		}()
+	}
+	wg.Wait()
+
+	want := strings.Repeat("+ line\n", 800)
+	if got := buf.String(); got != want {
+		t.Errorf("got %d bytes, want %d", len(got), len(want))
+	}
+}
+
+func TestPrefixWriterAllocs(t *testing.T) {
+	w := newPrefixWriter("+ ", io.Discard)
+	line := []byte("some trace output\nacross lines\n")
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = w.Write(line)
+	})
+
+	if allocs > 0 {
+		t.Errorf("Write allocated %v times per call, want 0", allocs)
+	}
+}
+
+func TestCmdTraceConfig(t *testing.T) {
+	t.Setenv("CMDTRACE", "")
+	var buf strings.Builder
+	old := Trace
+	Trace = &buf
+	t.Cleanup(func() { Trace = old })
+
+	ctx := config.With(t.Context(), &config.Config{Trace: "full"})
+	err := Do(ctx, traceMachine("FOO=bar echo hi"), "echo", "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "FOO=bar echo hi\n"; got != want {
+		t.Errorf("trace = %q, want %q", got, want)
+	}
+
+	buf.Reset()
+	t.Setenv("CMDTRACE", "on")
+	err = Do(ctx, traceMachine("FOO=bar echo hi"), "echo", "hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "echo hi\n"; got != want {
+		t.Errorf("trace with CMDTRACE=on = %q, want %q", got, want)
+	}
+}
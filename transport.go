@@ -0,0 +1,32 @@
+package command
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Transport returns an http.RoundTripper that connects to servers from m,
+// as by [Dial], so that plain net/http clients can reach services that
+// only m can, such as a server listening inside a container:
+//
+//	client := &http.Client{Transport: command.Transport(m)}
+//	resp, err := client.Get("http://localhost:8080/healthz")
+//
+// Host names in request URLs are resolved on m. Proxy settings of the
+// environment are not used. Each connection is dialed with the context
+// of the request that opened it, without its cancellation, so that the
+// connection can serve later requests. Idle connections are closed after
+// the timeout of http.DefaultTransport, or by CloseIdleConnections.
+func Transport(m Machine) http.RoundTripper {
+	return &http.Transport{
+		DialContext: func(
+			ctx context.Context, network, addr string,
+		) (net.Conn, error) {
+			return Dial(context.WithoutCancel(ctx), m, network, addr)
+		},
+		MaxIdleConns:    100,
+		IdleConnTimeout: 90 * time.Second,
+	}
+}
@@ -0,0 +1,66 @@
+package command_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/sys"
+)
+
+func TestTransport(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, "ok "+r.URL.Path)
+		},
+	))
+	defer srv.Close()
+	tr := command.Transport(sys.Machine())
+	client := &http.Client{Transport: tr}
+	defer client.CloseIdleConnections()
+	for _, path := range []string{"/healthz", "/ready"} {
+		req, err := http.NewRequestWithContext(t.Context(),
+			http.MethodGet, srv.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Do(%s) error: %v", path, err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		if err != nil {
+			t.Fatalf("read body of %s: %v", path, err)
+		}
+		if got, want := string(body), "ok "+path; got != want {
+			t.Errorf("body = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestTransportRefused(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires sh")
+	}
+	srv := httptest.NewServer(http.NotFoundHandler())
+	url := srv.URL
+	srv.Close()
+	client := &http.Client{Transport: command.Transport(sys.Machine())}
+	req, err := http.NewRequestWithContext(t.Context(),
+		http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err == nil {
+		_ = resp.Body.Close()
+		t.Fatal("Do error = nil, want error")
+	}
+}
@@ -0,0 +1,38 @@
+package ui
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDraw(t *testing.T) {
+	var out bytes.Buffer
+	u := &UI{w: &out, tty: true, width: 20}
+	s := &step{name: "make all", begin: time.Now()}
+	_, _ = s.Write([]byte("compiling a\ncompiling b\nlinking"))
+
+	u.start(s)
+	u.finish(s, nil)
+	_ = u.Close()
+
+	lines := strings.Split(out.String(), "\n")
+	if got, want := lines[0], "⠋ make all (0s) lin…"; got != want {
+		t.Errorf("live line = %q, want %q", got, want)
+	}
+	if !strings.HasPrefix(lines[1], "\x1b[1A\x1b[J✓ make all") {
+		t.Errorf("summary line = %q, want it to replace the live line",
+			lines[1])
+	}
+}
+
+func TestStepLast(t *testing.T) {
+	s := new(step)
+	for _, chunk := range []string{"one\ntw", "o\n", "\n  \n"} {
+		_, _ = s.Write([]byte(chunk))
+	}
+	if got, want := s.last(), "two"; got != want {
+		t.Errorf("last() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,341 @@
+// Package ui renders the live status of in-flight commands to a
+// terminal, for tools that orchestrate many steps.
+//
+// Commands run on a Machine from [UI.Machine], or watched with
+// [UI.Watch], each get a line showing a spinner, how long the command has
+// been running, its arguments, and the last line it printed. When a
+// command finishes, its line is replaced by a summary that scrolls up
+// above the live lines:
+//
+//	u := ui.New(os.Stderr)
+//	defer u.Close()
+//	m := u.Machine(sys.Machine())
+//	g, ctx := errgroup.WithContext(ctx)
+//	for _, pkg := range pkgs {
+//	    g.Go(func() error { return command.Do(ctx, m, "go", "test", pkg) })
+//	}
+//	err := g.Wait()
+//
+// If the writer is not a terminal, only the summaries are written.
+package ui
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+
+	"lesiw.io/command"
+)
+
+// Interval is how often the live lines are redrawn.
+var Interval = 100 * time.Millisecond
+
+var spinner = []rune("⠋⠙⠹⠸⠼⠴⠦⠧⠇⠏")
+
+// A UI renders the status of the commands run on its Machines.
+type UI struct {
+	w     io.Writer
+	tty   bool
+	width int
+
+	mu     sync.Mutex
+	steps  []*step
+	events map[uint64]*step // Steps by command.Event ID.
+	drawn  int              // The number of live lines on screen.
+	frame  int
+	ticker *time.Ticker
+	quit   chan struct{}
+	closed bool
+}
+
+// New returns a UI that writes to w.
+func New(w io.Writer) *UI {
+	u := &UI{w: w, width: 80}
+	if f, ok := w.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		u.tty = true
+		if width, _, err := term.GetSize(int(f.Fd())); err == nil {
+			u.width = width
+		}
+	}
+	return u
+}
+
+// Machine returns a command.Machine that runs commands on m and shows
+// them on u.
+func (u *UI) Machine(m command.Machine) command.Machine {
+	return &machine{m: m, u: u}
+}
+
+// Watch shows the commands that [command.Subscribe] reports, until the
+// returned function is called. If any machines are given, only commands
+// run on them are shown.
+func (u *UI) Watch(m ...command.Machine) (cancel func()) {
+	return command.Subscribe(u.event, m...)
+}
+
+func (u *UI) event(e command.Event) {
+	u.mu.Lock()
+	s, ok := u.events[e.ID]
+	if !ok && e.Kind == command.EventStart {
+		s = &step{name: strings.Join(e.Args, " "), begin: e.Time}
+		if u.events == nil {
+			u.events = make(map[uint64]*step)
+		}
+		u.events[e.ID] = s
+	}
+	if e.Kind == command.EventExit {
+		delete(u.events, e.ID)
+	}
+	u.mu.Unlock()
+	if s == nil {
+		return
+	}
+	switch e.Kind {
+	case command.EventStart:
+		u.start(s)
+	case command.EventStdout, command.EventStderr:
+		_, _ = s.Write(e.Data)
+	case command.EventExit:
+		u.finish(s, e.Err)
+	}
+}
+
+// Close stops redrawing and clears the live lines of commands still in
+// flight. Commands that finish after Close are not shown.
+func (u *UI) Close() error {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.closed {
+		return nil
+	}
+	u.closed = true
+	if u.ticker != nil {
+		u.ticker.Stop()
+		close(u.quit)
+	}
+	u.clear()
+	return nil
+}
+
+// start adds s to the live lines, starting the redraw loop if needed.
+func (u *UI) start(s *step) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.closed {
+		return
+	}
+	u.steps = append(u.steps, s)
+	if u.tty && u.ticker == nil {
+		u.ticker = time.NewTicker(Interval)
+		u.quit = make(chan struct{})
+		go u.loop(u.ticker, u.quit)
+	}
+	u.draw()
+}
+
+// finish replaces the live line of s with its summary.
+func (u *UI) finish(s *step, err error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	for i, st := range u.steps {
+		if st == s {
+			u.steps = append(u.steps[:i], u.steps[i+1:]...)
+			break
+		}
+	}
+	if u.closed {
+		return
+	}
+	u.clear()
+	mark := "✓"
+	if err != nil {
+		mark = "✗"
+	}
+	line := fmt.Sprintf("%s %s (%s)", mark, s.name, s.elapsed())
+	if err != nil {
+		line += ": " + firstLine(err.Error())
+	}
+	_, _ = fmt.Fprintln(u.w, u.fit(line))
+	u.draw()
+}
+
+func (u *UI) loop(t *time.Ticker, quit chan struct{}) {
+	for {
+		select {
+		case <-t.C:
+			u.mu.Lock()
+			u.frame++
+			u.clear()
+			u.draw()
+			u.mu.Unlock()
+		case <-quit:
+			return
+		}
+	}
+}
+
+// clear erases the live lines. u.mu must be held.
+func (u *UI) clear() {
+	if u.drawn == 0 {
+		return
+	}
+	_, _ = fmt.Fprintf(u.w, "\x1b[%dA\x1b[J", u.drawn)
+	u.drawn = 0
+}
+
+// draw writes the live lines below the cursor. u.mu must be held.
+func (u *UI) draw() {
+	if !u.tty {
+		return
+	}
+	var buf bytes.Buffer
+	for _, s := range u.steps {
+		line := fmt.Sprintf("%c %s (%s)",
+			spinner[u.frame%len(spinner)], s.name, s.elapsed())
+		if last := s.last(); last != "" {
+			line += " " + last
+		}
+		buf.WriteString(u.fit(line) + "\n")
+	}
+	_, _ = u.w.Write(buf.Bytes())
+	u.drawn = len(u.steps)
+}
+
+// fit truncates line to the width of the terminal, so that each live
+// line takes exactly one row.
+func (u *UI) fit(line string) string {
+	if !u.tty || utf8.RuneCountInString(line) <= u.width {
+		return line
+	}
+	r := []rune(line)
+	return string(r[:max(u.width-1, 0)]) + "…"
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+// A step is a command in flight.
+type step struct {
+	name  string
+	begin time.Time
+
+	mu   sync.Mutex
+	line []byte // The last complete line of output.
+	part []byte // Output since the last newline.
+}
+
+func (s *step) elapsed() time.Duration {
+	return time.Since(s.begin).Round(100 * time.Millisecond)
+}
+
+// Write records the last line of output.
+func (s *step) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.part = append(s.part, p...)
+	if i := bytes.LastIndexByte(s.part, '\n'); i >= 0 {
+		lines := bytes.TrimRight(s.part[:i], "\r\n")
+		if j := bytes.LastIndexByte(lines, '\n'); j >= 0 {
+			lines = lines[j+1:]
+		}
+		if line := bytes.TrimSpace(lines); len(line) > 0 {
+			s.line = append(s.line[:0], line...)
+		}
+		s.part = append(s.part[:0], s.part[i+1:]...)
+	}
+	return len(p), nil
+}
+
+func (s *step) last() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if line := bytes.TrimSpace(s.part); len(line) > 0 {
+		return string(line)
+	}
+	return string(s.line)
+}
+
+type machine struct {
+	m command.Machine
+	u *UI
+}
+
+func (m *machine) Command(
+	ctx context.Context, arg ...string,
+) command.Buffer {
+	return &cmd{
+		Buffer: m.m.Command(ctx, arg...),
+		u:      m.u,
+		step:   &step{name: strings.Join(arg, " ")},
+	}
+}
+
+// cmd shows its command on u from its first Read or Write until it
+// completes.
+type cmd struct {
+	command.Buffer
+	u    *UI
+	step *step
+	once sync.Once
+	end  sync.Once
+}
+
+func (c *cmd) begin() {
+	c.once.Do(func() {
+		c.step.begin = time.Now()
+		c.u.start(c.step)
+	})
+}
+
+func (c *cmd) Read(p []byte) (int, error) {
+	c.begin()
+	n, err := c.Buffer.Read(p)
+	_, _ = c.step.Write(p[:n])
+	if err != nil {
+		c.end.Do(func() {
+			if err == io.EOF {
+				c.u.finish(c.step, nil)
+			} else {
+				c.u.finish(c.step, err)
+			}
+		})
+	}
+	return n, err
+}
+
+func (c *cmd) Write(p []byte) (int, error) {
+	c.begin()
+	if wb, ok := c.Buffer.(command.WriteBuffer); ok {
+		return wb.Write(p)
+	}
+	return 0, command.ErrReadOnly
+}
+
+func (c *cmd) Close() error {
+	if closer, ok := c.Buffer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Log records diagnostic output as well as sending it to w.
+func (c *cmd) Log(w io.Writer) {
+	command.Log(c.Buffer, io.MultiWriter(w, c.step))
+}
+
+func (c *cmd) Attach() error  { return command.Attach(c.Buffer) }
+func (c *cmd) String() string { return command.String(c.Buffer) }
+
+func (c *cmd) Result() (command.Result, bool) {
+	return command.ResultOf(c.Buffer)
+}
@@ -0,0 +1,83 @@
+package ui_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+	"lesiw.io/command/ui"
+)
+
+func TestSummaries(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("ok\n"), "go", "build")
+	m.Return(command.Fail(&command.Error{
+		Code: 1,
+		Log:  []byte("FAIL\n"),
+	}), "go", "test")
+	var out bytes.Buffer
+	u := ui.New(&out)
+	um := u.Machine(m)
+
+	if err := command.Do(t.Context(), um, "go", "build", "./..."); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+	if err := command.Do(t.Context(), um, "go", "test", "./..."); err == nil {
+		t.Fatal("command.Do error = nil, want exit status 1")
+	}
+	if err := u.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	want := regexp.MustCompile(`^✓ go build \./\.\.\. \([0-9.]+m?s\)
+✗ go test \./\.\.\. \([0-9.]+m?s\): exit status 1
+$`)
+	if got := out.String(); !want.MatchString(got) {
+		t.Errorf("output = %q, want match for %q", got, want)
+	}
+}
+
+func TestClosed(t *testing.T) {
+	m := new(mock.Machine)
+	var out bytes.Buffer
+	u := ui.New(&out)
+	if err := u.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	if err := command.Do(t.Context(), u.Machine(m), "true"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+	if got := out.String(); got != "" {
+		t.Errorf("output after Close = %q, want none", got)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	m := new(mock.Machine)
+	m.Return(strings.NewReader("ok\n"), "make")
+	var out bytes.Buffer
+	u := ui.New(&out)
+	stop := u.Watch(m)
+
+	if err := command.Do(t.Context(), m, "make", "all"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+	other := new(mock.Machine)
+	if err := command.Do(t.Context(), other, "true"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+	stop()
+	if err := command.Do(t.Context(), m, "make", "clean"); err != nil {
+		t.Fatalf("command.Do error: %v", err)
+	}
+	_ = u.Close()
+
+	want := regexp.MustCompile(`^✓ make all \([0-9.]+m?s\)\n$`)
+	if got := out.String(); !want.MatchString(got) {
+		t.Errorf("output = %q, want match for %q", got, want)
+	}
+}
@@ -0,0 +1,174 @@
+package command
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// waitInterval is how long the Wait functions pause between probes.
+var waitInterval = 500 * time.Millisecond
+
+// httpScript prints the HTTP status code of a GET of the URL in $1, or
+// 000 if there is no response, with curl or else wget.
+const httpScript = `if command -v curl >/dev/null 2>&1; then
+	curl -s -o /dev/null -w '%{http_code}' --max-time 5 "$1" || :
+elif command -v wget >/dev/null 2>&1; then
+	wget -q -S -O /dev/null -T 5 "$1" 2>&1 |
+		awk '$1 ~ /^HTTP\// { c = $2 } END { print (c ? c : "000") }'
+else
+	echo 'no curl or wget' >&2
+	exit 127
+fi`
+
+// psHTTPScript prints the HTTP status code of a GET of a URL, or 0 if
+// there is no response.
+const psHTTPScript = `try {
+	(Invoke-WebRequest -UseBasicParsing -TimeoutSec 5 -Uri '%s').StatusCode
+} catch {
+	$r = $_.Exception.Response
+	if ($r) { [int]$r.StatusCode } else { 0 }
+}`
+
+// WaitHTTP waits until a GET of url from m returns status, or any 2xx
+// status if status is 0. It gives up after timeout, or when ctx is done,
+// if that is sooner; a timeout of 0 waits as long as ctx allows.
+//
+// The request is made on m, with curl or wget on Unix and PowerShell on
+// Windows, so that services in a container or on a remote host can be
+// checked from where they run, inside their network:
+//
+//	m := ctr.Machine(sys.Machine(), "nginx")
+//	err := command.WaitHTTP(ctx, m, "http://localhost/", 200, time.Minute)
+func WaitHTTP(
+	ctx context.Context, m Machine, url string, status int,
+	timeout time.Duration,
+) error {
+	ctx = WithoutCommandOptions(ctx)
+	windows := OS(ctx, m) == "windows"
+	probe := func(ctx context.Context) (string, error) {
+		var (
+			out string
+			err error
+		)
+		if windows {
+			out, err = psRead(ctx, m, psHTTPScript, psEscape(url))
+		} else {
+			out, err = Read(ctx, m, "sh", "-c", httpScript, "sh", url)
+		}
+		if err != nil {
+			return "", err
+		}
+		code, _ := strconv.Atoi(strings.TrimSpace(out))
+		switch {
+		case code == 0:
+			return "no response", nil
+		case status == 0 && code >= 200 && code < 300, code == status:
+			return "", nil
+		}
+		return "status " + strconv.Itoa(code), nil
+	}
+	return wait(ctx, "GET "+url, timeout, probe)
+}
+
+// tcpScript prints open if a TCP connection to host $1 and port $2
+// succeeds, or closed if not, with bash or else nc.
+const tcpScript = `if command -v bash >/dev/null 2>&1; then
+	t=; command -v timeout >/dev/null 2>&1 && t='timeout 5'
+	$t bash -c 'exec 3<>"/dev/tcp/$1/$2"' bash "$1" "$2" 2>/dev/null
+elif command -v nc >/dev/null 2>&1; then
+	nc -z -w 5 "$1" "$2" 2>/dev/null
+else
+	echo 'no bash or nc' >&2
+	exit 127
+fi && echo open || echo closed`
+
+// psTCPScript prints True if a TCP connection to a host and port
+// succeeds.
+const psTCPScript = `(Test-NetConnection -ComputerName '%s' -Port %s ` +
+	`-WarningAction SilentlyContinue).TcpTestSucceeded`
+
+// WaitTCP waits until a TCP connection from m to addr, a host and port
+// such as "localhost:5432", succeeds. It gives up after timeout, or when
+// ctx is done, if that is sooner; a timeout of 0 waits as long as ctx
+// allows.
+//
+// Like [WaitHTTP], it connects from m itself, with bash or nc on Unix and
+// PowerShell on Windows, so it suits services that do not speak HTTP.
+func WaitTCP(
+	ctx context.Context, m Machine, addr string, timeout time.Duration,
+) error {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("bad address %q: %w", addr, err)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("bad port in address %q", addr)
+	}
+	ctx = WithoutCommandOptions(ctx)
+	windows := OS(ctx, m) == "windows"
+	probe := func(ctx context.Context) (string, error) {
+		var (
+			out string
+			err error
+		)
+		if windows {
+			out, err = psRead(ctx, m, psTCPScript, psEscape(host), port)
+		} else {
+			out, err = Read(ctx, m, "sh", "-c", tcpScript, "sh", host, port)
+		}
+		if err != nil {
+			return "", err
+		}
+		switch strings.ToLower(strings.TrimSpace(out)) {
+		case "open", "true":
+			return "", nil
+		}
+		return "connection failed", nil
+	}
+	return wait(ctx, "connect "+addr, timeout, probe)
+}
+
+// wait calls probe until it reports success, returning an error if it
+// fails to run, or if timeout passes first. A successful probe returns
+// "", and an unsuccessful one describes why it did not succeed.
+func wait(
+	ctx context.Context, what string, timeout time.Duration,
+	probe func(context.Context) (string, error),
+) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	last := "no attempt"
+	for {
+		why, err := probe(ctx)
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", what, err)
+		}
+		if why == "" {
+			return nil
+		}
+		last = why
+		select {
+		case <-ctx.Done():
+		case <-time.After(waitInterval):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	err := ctx.Err()
+	if errors.Is(err, context.DeadlineExceeded) {
+		err = ErrDeadline
+	}
+	return fmt.Errorf("%s: not ready (%s): %w", what, last, err)
+}
@@ -0,0 +1,151 @@
+package command_test
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"lesiw.io/command"
+	"lesiw.io/command/mock"
+	"lesiw.io/command/sys"
+)
+
+func TestWaitHTTP(t *testing.T) {
+	if _, err := exec.LookPath("curl"); err != nil {
+		t.Skip("requires curl")
+	}
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, _ *http.Request) {
+			if hits.Add(1) < 3 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		},
+	))
+	t.Cleanup(srv.Close)
+
+	err := command.WaitHTTP(t.Context(), sys.Machine(), srv.URL, 0,
+		10*time.Second)
+	if err != nil {
+		t.Fatalf("command.WaitHTTP error: %v", err)
+	}
+	if got, want := hits.Load(), int32(3); got != want {
+		t.Errorf("requests = %d, want %d", got, want)
+	}
+}
+
+func TestWaitHTTPTimeout(t *testing.T) {
+	if _, err := exec.LookPath("curl"); err != nil {
+		t.Skip("requires curl")
+	}
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		},
+	))
+	t.Cleanup(srv.Close)
+
+	err := command.WaitHTTP(t.Context(), sys.Machine(), srv.URL, 200,
+		time.Second)
+	if !errors.Is(err, command.ErrDeadline) {
+		t.Fatalf("command.WaitHTTP error: got %v, want ErrDeadline", err)
+	}
+	if !strings.Contains(err.Error(), "status 500") {
+		t.Errorf("command.WaitHTTP error = %q, want last status", err)
+	}
+}
+
+func TestWaitHTTPWindows(t *testing.T) {
+	m := new(mock.Machine)
+	m.SetOS("windows")
+	m.Return(strings.NewReader("0\r\n"), "powershell")
+	m.Return(strings.NewReader("204\r\n"), "powershell")
+
+	err := command.WaitHTTP(t.Context(), m, "http://localhost/it's", 204,
+		10*time.Second)
+	if err != nil {
+		t.Fatalf("command.WaitHTTP error: %v", err)
+	}
+	calls := mock.Calls(m, "powershell")
+	if got, want := len(calls), 2; got != want {
+		t.Fatalf("powershell calls = %d, want %d", got, want)
+	}
+	script := calls[0].Args[len(calls[0].Args)-1]
+	if !strings.Contains(script, "'http://localhost/it''s'") {
+		t.Errorf("script = %q, want quoted URL", script)
+	}
+}
+
+func TestWaitTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+	listening := make(chan net.Listener, 1)
+	go func() {
+		time.Sleep(time.Second)
+		ln, _ := net.Listen("tcp", addr)
+		listening <- ln
+	}()
+
+	err = command.WaitTCP(t.Context(), sys.Machine(), addr, 10*time.Second)
+	if ln := <-listening; ln != nil {
+		_ = ln.Close()
+	}
+	if err != nil {
+		t.Fatalf("command.WaitTCP error: %v", err)
+	}
+}
+
+func TestWaitTCPTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	if err := ln.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = command.WaitTCP(t.Context(), sys.Machine(), addr, time.Second)
+	if !errors.Is(err, command.ErrDeadline) {
+		t.Fatalf("command.WaitTCP error: got %v, want ErrDeadline", err)
+	}
+}
+
+func TestWaitTCPWindows(t *testing.T) {
+	m := new(mock.Machine)
+	m.SetOS("windows")
+	m.Return(strings.NewReader("True\r\n"), "powershell")
+
+	err := command.WaitTCP(t.Context(), m, "db:5432", 10*time.Second)
+	if err != nil {
+		t.Fatalf("command.WaitTCP error: %v", err)
+	}
+	calls := mock.Calls(m, "powershell")
+	if len(calls) != 1 {
+		t.Fatalf("powershell calls = %d, want 1", len(calls))
+	}
+	script := calls[0].Args[len(calls[0].Args)-1]
+	if !strings.Contains(script, "-ComputerName 'db' -Port 5432") {
+		t.Errorf("script = %q, want host and port", script)
+	}
+}
+
+func TestWaitTCPBadAddress(t *testing.T) {
+	err := command.WaitTCP(t.Context(), new(mock.Machine), "localhost",
+		time.Second)
+	if err == nil {
+		t.Fatal("command.WaitTCP(localhost) succeeded, want error")
+	}
+}
@@ -0,0 +1,86 @@
+// Package workspace builds a local source tree on a command.Machine.
+//
+// [Run] is the core of a remote build: it stages a source tree on a
+// machine, such as a container or a remote host, runs a command there,
+// and brings back what the command produced.
+//
+//	files, err := workspace.Run(ctx, m, ".", command.Spec{
+//	    Args:   []string{"go", "build", "-o", "bin/", "./..."},
+//	    Stdout: os.Stdout,
+//	    Stderr: os.Stderr,
+//	}, "bin/*")
+package workspace
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"lesiw.io/command"
+	"lesiw.io/command/artifact"
+	"lesiw.io/fs"
+	"lesiw.io/fs/osfs"
+)
+
+// Run copies the local directory src to a new temporary directory on m,
+// runs cmd there, as [command.Run] does, with any cmd.Dir relative to
+// it, and copies the files matching outputs back into src,
+// as [artifact.Fetch] does. The temporary directory is removed
+// afterward. Run returns the files it copied back.
+//
+// Outputs are copied back even if cmd fails, so that its logs and
+// partial results are available, and Run returns the error of cmd.
+func Run(
+	ctx context.Context, m command.Machine, src string, cmd command.Spec,
+	outputs ...string,
+) (files []artifact.File, err error) {
+	if len(cmd.Args) == 0 {
+		return nil, fmt.Errorf("no command given")
+	}
+	sctx := command.WithoutCommandOptions(ctx) // For the workspace itself.
+	dir, err := stage(sctx, m, src)
+	if dir != "" {
+		defer func() {
+			rmctx := context.WithoutCancel(sctx)
+			err = errors.Join(err, fs.RemoveAll(rmctx, command.FS(m), dir))
+		}()
+	}
+	if err != nil {
+		return nil, err
+	}
+	ctx = fs.WithWorkDir(ctx, dir)
+	err = command.Run(ctx, m, cmd)
+	if len(outputs) > 0 {
+		var ferr error
+		files, ferr = artifact.Fetch(ctx, m, src, outputs...)
+		err = errors.Join(err, ferr)
+	}
+	return files, err
+}
+
+// stage copies src to a new temporary directory on m and returns its
+// path. The path is returned even on failure, if the directory exists.
+func stage(
+	ctx context.Context, m command.Machine, src string,
+) (string, error) {
+	r, err := fs.Open(ctx, osfs.New(), strings.TrimSuffix(src, "/")+"/")
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", src, err)
+	}
+	defer r.Close()
+	w, err := fs.Temp(ctx, command.FS(m), "workspace/")
+	if err != nil {
+		return "", fmt.Errorf("failed to create workspace: %w", err)
+	}
+	dir := w.Path()
+	_, err = io.Copy(w, r)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return dir, fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	return dir, nil
+}
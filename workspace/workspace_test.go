@@ -0,0 +1,71 @@
+package workspace_test
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"lesiw.io/command"
+	"lesiw.io/command/sys"
+	"lesiw.io/command/workspace"
+)
+
+func TestRun(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test needs sh, find, and tar")
+	}
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not found")
+	}
+	src := t.TempDir()
+	err := os.WriteFile(filepath.Join(src, "in.txt"), []byte("hi\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := workspace.Run(t.Context(), sys.Machine(), src,
+		command.Spec{Args: []string{
+			"sh", "-c", "mkdir out && tr a-z A-Z <in.txt >out/up.txt",
+		}},
+		"out/*",
+	)
+	if err != nil {
+		t.Fatalf("workspace.Run error: %v", err)
+	}
+
+	if len(files) != 1 || files[0].Path != "out/up.txt" {
+		t.Errorf("workspace.Run files = %v, want out/up.txt", files)
+	}
+	got, err := os.ReadFile(filepath.Join(src, "out", "up.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "HI\n" {
+		t.Errorf("out/up.txt = %q, want %q", got, "HI\n")
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("test needs sh, find, and tar")
+	}
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not found")
+	}
+	src := t.TempDir()
+
+	files, err := workspace.Run(t.Context(), sys.Machine(), src,
+		command.Spec{Args: []string{
+			"sh", "-c", "echo failed >build.log; exit 1",
+		}},
+		"*.log",
+	)
+	if err == nil {
+		t.Error("workspace.Run: got nil error, want failure")
+	}
+	if len(files) != 1 || files[0].Path != "build.log" {
+		t.Errorf("workspace.Run files = %v, want build.log", files)
+	}
+}
@@ -4,21 +4,33 @@ import (
 	"context"
 	"io"
 	"sync"
+	"time"
 )
 
 type writer struct {
 	sync.Mutex
 	w       io.Writer
+	cancel  context.CancelFunc
 	read    chan error
+	leak    *leak
+	stall   time.Duration
+	codes   []int // Exit codes that complete the command without error.
 	started bool
+	spliced bool // Its input was spliced, so it runs when closed.
 	closed  bool
 }
 
+func (w *writer) stallTimeout() time.Duration { return w.stall }
+func (w *writer) abort()                      { w.cancel() }
+
 func (w *writer) init() {
 	w.read = make(chan error, 1)
 	if r, ok := w.w.(io.Reader); ok {
 		go func() {
 			_, err := io.Copy(io.Discard, r)
+			if exitAllowed(w.codes, err) {
+				err = nil
+			}
 			w.read <- err
 		}()
 	} else {
@@ -50,12 +62,19 @@ func (w *writer) Close() error {
 		return nil
 	}
 	w.closed = true
+	if w.spliced && !w.started {
+		// Nothing will be written, but the command must still run.
+		w.started = true
+		w.init()
+	}
 	started := w.started
 	w.Unlock()
+	w.leak.release()
 
 	if !started {
 		return nil
 	}
+	defer w.cancel() // The command is done once Close returns.
 
 	if closer, ok := w.w.(io.Closer); ok {
 		if err := closer.Close(); err != nil {
@@ -66,6 +85,29 @@ func (w *writer) Close() error {
 	return <-w.read
 }
 
+// Result returns the result of the command, if it implements
+// [ResultBuffer].
+func (w *writer) Result() (Result, bool) {
+	if buf, ok := w.w.(Buffer); ok {
+		return ResultOf(buf)
+	}
+	return Result{}, false
+}
+
+// SpliceFrom splices src into the command's input, if the command
+// implements [SpliceBuffer] and has not started. The command then runs
+// when the writer is closed.
+func (w *writer) SpliceFrom(src io.Reader) bool {
+	w.Lock()
+	defer w.Unlock()
+	buf, ok := w.w.(WriteBuffer)
+	if !ok || w.started || w.closed || w.spliced {
+		return false
+	}
+	w.spliced = SpliceFrom(buf, src)
+	return w.spliced
+}
+
 // ReadFrom implements io.ReaderFrom for optimized copying that auto-closes
 // stdin when the source reaches EOF.
 // This allows io.Copy(NewWriter(...), src) to work correctly without requiring
@@ -103,6 +145,8 @@ func (w *writer) ReadFrom(src io.Reader) (n int64, err error) {
 	w.Lock()
 	w.closed = true
 	w.Unlock()
+	w.leak.release()
+	w.cancel()
 
 	return n, err
 }
@@ -119,14 +163,22 @@ func (w *writer) ReadFrom(src io.Reader) (n int64, err error) {
 // NewWriter implements io.ReaderFrom for optimized copying. When io.Copy
 // detects this, it will auto-close stdin after the source reaches EOF.
 func NewWriter(ctx context.Context, m Machine, args ...string) io.WriteCloser {
-	buf := m.Command(ctx, args...)
+	ctx, cancel := context.WithCancel(ctx)
+	buf := newCommand(ctx, m, args...)
+	w := &writer{
+		cancel: cancel,
+		leak:   track(m, args),
+		stall:  stallTimeout(ctx),
+		codes:  allowedCodes(ctx),
+	}
 	// Assert that the command supports writing
-	wb, ok := buf.(WriteBuffer)
-	if !ok {
+	if wb, ok := buf.(WriteBuffer); ok {
+		w.w = wb
+	} else {
 		// Return a writer that will fail on first write
-		return &writer{w: &readOnlyBuffer{buf}}
+		w.w = &readOnlyBuffer{buf}
 	}
-	return &writer{w: wb}
+	return w
 }
 
 type readOnlyBuffer struct {
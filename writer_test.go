@@ -196,3 +196,26 @@ func TestWriterConcurrentWriteClose(t *testing.T) {
 		go w.Close()
 	}
 }
+
+func TestWriterCloseReleasesContext(t *testing.T) {
+	var cmdCtx context.Context
+	w := NewWriter(t.Context(), MachineFunc(func(
+		ctx context.Context, _ ...string,
+	) Buffer {
+		cmdCtx = ctx
+		return struct {
+			io.Reader
+			io.Writer
+			io.Closer
+		}{strings.NewReader(""), io.Discard, nopCloser{}}
+	}))
+	if _, err := w.Write([]byte("data")); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if cmdCtx.Err() == nil {
+		t.Error("command context not canceled after Close()")
+	}
+}